@@ -0,0 +1,68 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// Error declarations.
+var (
+	ErrInvalidAnnotationKey = errors.New("Annotation keys must not be empty nor contain whitespace or '='")
+)
+
+// validAnnotationKey returns whether key can be used as the key of an
+// annotation. Keys can't contain '=', so key=value pairs can be parsed
+// unambiguously.
+func validAnnotationKey(key string) bool {
+	return key != "" && strings.IndexFunc(key, func(r rune) bool {
+		return r == '=' || unicode.IsSpace(r) || unicode.IsControl(r)
+	}) < 0
+}
+
+// Annotate attaches an annotation to the snapshot, replacing any previous
+// value of the same key. External tools use annotations to record their own
+// information about a snapshot, e.g. who verified it. Save the snapshot to
+// persist them.
+func (snapshot *Snapshot) Annotate(key, value string) error {
+	if !validAnnotationKey(key) {
+		return ErrInvalidAnnotationKey
+	}
+	if snapshot.Annotations == nil {
+		snapshot.Annotations = make(map[string]string)
+	}
+	snapshot.Annotations[key] = value
+	return nil
+}
+
+// RemoveAnnotation removes an annotation from the snapshot. It returns false
+// if the snapshot isn't annotated with key.
+func (snapshot *Snapshot) RemoveAnnotation(key string) bool {
+	if _, ok := snapshot.Annotations[key]; !ok {
+		return false
+	}
+	delete(snapshot.Annotations, key)
+	if len(snapshot.Annotations) == 0 {
+		snapshot.Annotations = nil
+	}
+	return true
+}
+
+// ParseAnnotation splits a key=value pair. Without '=', the value is empty.
+func ParseAnnotation(s string) (string, string, error) {
+	kv := strings.SplitN(s, "=", 2)
+	if !validAnnotationKey(kv[0]) {
+		return "", "", ErrInvalidAnnotationKey
+	}
+	if len(kv) == 1 {
+		return kv[0], "", nil
+	}
+	return kv[0], kv[1], nil
+}
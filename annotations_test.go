@@ -0,0 +1,113 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSnapshotAnnotations(t *testing.T) {
+	testPassword := "this_is_a_password"
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	vol, _ := NewVolume("test", "")
+	_ = r.AddVolume(vol)
+	snapshot, _ := NewSnapshot("test_snapshot")
+	if err := snapshot.Save(&r); err != nil {
+		t.Fatal(err)
+	}
+	_ = vol.AddSnapshot(snapshot.ID)
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"", "a=b", "a b", "a\tb"} {
+		if err := snapshot.Annotate(key, "value"); err != ErrInvalidAnnotationKey {
+			t.Errorf("Expected %v for key %q, got %v", ErrInvalidAnnotationKey, key, err)
+		}
+	}
+	if err := snapshot.Annotate("verified-by", "QA"); err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshot.Annotate("ticket", "OPS-42"); err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshot.Annotate("obsolete", ""); err != nil {
+		t.Fatal(err)
+	}
+	if !snapshot.RemoveAnnotation("obsolete") {
+		t.Error("Expected annotation to be removed")
+	}
+	if snapshot.RemoveAnnotation("obsolete") {
+		t.Error("Expected annotation to be gone already")
+	}
+	if err := snapshot.Save(&r); err != nil {
+		t.Fatalf("Failed saving annotated snapshot: %s", err)
+	}
+
+	r, err = OpenRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed opening repository: %s", err)
+	}
+	_, snapshot, err = r.FindSnapshot(snapshot.ID)
+	if err != nil {
+		t.Fatalf("Failed loading snapshot: %s", err)
+	}
+	if len(snapshot.Annotations) != 2 || snapshot.Annotations["verified-by"] != "QA" || snapshot.Annotations["ticket"] != "OPS-42" {
+		t.Errorf("Unexpected annotations after reopening: %v", snapshot.Annotations)
+	}
+
+	tests := []struct {
+		filter  SnapshotFilter
+		matches bool
+	}{
+		{SnapshotFilter{Annotations: map[string]string{"verified-by": "QA"}}, true},
+		{SnapshotFilter{Annotations: map[string]string{"verified-by": ""}}, true},
+		{SnapshotFilter{Annotations: map[string]string{"verified-by": "QA", "ticket": "OPS-42"}}, true},
+		{SnapshotFilter{Annotations: map[string]string{"verified-by": "dev"}}, false},
+		{SnapshotFilter{Annotations: map[string]string{"obsolete": ""}}, false},
+		{SnapshotFilter{Search: "ops-42"}, true},
+	}
+	for _, tt := range tests {
+		if m := tt.filter.Matches(snapshot); m != tt.matches {
+			t.Errorf("Expected filter %+v to return %v, got %v", tt.filter, tt.matches, m)
+		}
+	}
+}
+
+func TestParseAnnotation(t *testing.T) {
+	tests := []struct {
+		s     string
+		key   string
+		value string
+		err   error
+	}{
+		{"ticket=OPS-42", "ticket", "OPS-42", nil},
+		{"note=a=b", "note", "a=b", nil},
+		{"ticket", "ticket", "", nil},
+		{"ticket=", "ticket", "", nil},
+		{"=value", "", "", ErrInvalidAnnotationKey},
+	}
+	for _, tt := range tests {
+		key, value, err := ParseAnnotation(tt.s)
+		if key != tt.key || value != tt.value || err != tt.err {
+			t.Errorf("Expected %q to parse as (%q, %q, %v), got (%q, %q, %v)", tt.s, tt.key, tt.value, tt.err, key, value, err)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import "errors"
+
+// appendOnlyVersion is the first repository version supporting the
+// append-only mode. Older versions of knoxite would ignore it.
+const appendOnlyVersion = 8
+
+// Error declarations.
+var (
+	ErrAppendOnly          = errors.New("The repository is append-only, removing or overwriting data requires an admin key")
+	ErrAppendOnlyNeedsKeys = errors.New("The append-only mode requires key records, add a key to the repository first")
+)
+
+// appendOnlyState records what a client, which isn't allowed to remove data
+// from an append-only repository, found when opening it.
+type appendOnlyState struct {
+	// snapshots maps the IDs of all snapshots to the IDs of their volumes
+	snapshots map[string]string
+	// keys maps the IDs of all keys to whether they are admin keys
+	keys map[string]bool
+	// volumeKeys maps the IDs of the keys of protected volumes to the IDs of
+	// their volumes
+	volumeKeys map[string]string
+}
+
+// IsAdmin returns true if the repository has been opened with a key, which
+// may remove and overwrite data. Unless the repository is append-only, every
+// key may do so.
+func (r *Repository) IsAdmin() bool {
+	if !r.AppendOnly {
+		return true
+	}
+	for _, k := range r.keys {
+		if k.ID == r.currentKey {
+			return k.Admin
+		}
+	}
+	return false
+}
+
+// SetAppendOnly enables or disables the append-only mode. Clients, which
+// don't use an admin key, can then store new snapshots, but neither remove
+// snapshots, volumes, keys, volume keys or chunks, nor overwrite existing
+// snapshots.
+// Enabling the mode turns the key in use into an admin key, so only the
+// password used to enable it can disable it again, or prune the repository.
+func (r *Repository) SetAppendOnly(enable bool) error {
+	if !r.IsAdmin() {
+		return ErrAppendOnly
+	}
+	if enable {
+		if r.currentKey == "" {
+			return ErrAppendOnlyNeedsKeys
+		}
+		for i := range r.keys {
+			if r.keys[i].ID == r.currentKey {
+				r.keys[i].Admin = true
+			}
+		}
+		if r.Version < appendOnlyVersion {
+			r.Version = appendOnlyVersion
+		}
+		if r.ReaderVersion < appendOnlyVersion {
+			r.ReaderVersion = appendOnlyVersion
+		}
+	}
+
+	r.AppendOnly = enable
+	return r.Save()
+}
+
+// restrictAppendOnly prevents clients without an admin key from removing or
+// overwriting any data of an append-only repository.
+func (r *Repository) restrictAppendOnly() {
+	if r.IsAdmin() {
+		return
+	}
+
+	state := &appendOnlyState{
+		snapshots:  make(map[string]string),
+		keys:       make(map[string]bool),
+		volumeKeys: make(map[string]string),
+	}
+	for _, v := range r.Volumes {
+		for _, id := range v.Snapshots {
+			state.snapshots[id] = v.ID
+		}
+		for _, k := range v.Keys {
+			state.volumeKeys[k.ID] = v.ID
+		}
+	}
+	for _, k := range r.keys {
+		state.keys[k.ID] = k.Admin
+	}
+	r.appendOnly = state
+	r.backend.AppendOnly = true
+}
+
+// checkAppendOnly returns ErrAppendOnly if the repository's metadata lost
+// anything since it has been opened by a client without an admin key.
+func (r *Repository) checkAppendOnly() error {
+	state := r.appendOnly
+	if state == nil {
+		return nil
+	}
+	if !r.AppendOnly {
+		return ErrAppendOnly
+	}
+
+	found := make(map[string]string)
+	volumeKeys := make(map[string]string)
+	for _, v := range r.Volumes {
+		for _, id := range v.Snapshots {
+			found[id] = v.ID
+		}
+		for _, k := range v.Keys {
+			volumeKeys[k.ID] = v.ID
+		}
+	}
+	for id, vol := range state.snapshots {
+		if found[id] != vol {
+			return ErrAppendOnly
+		}
+	}
+	for id, vol := range state.volumeKeys {
+		if volumeKeys[id] != vol {
+			return ErrAppendOnly
+		}
+	}
+
+	keys := make(map[string]bool)
+	for _, k := range r.keys {
+		if k.Admin && !state.keys[k.ID] {
+			// keys can't be promoted to admin keys
+			return ErrAppendOnly
+		}
+		keys[k.ID] = true
+	}
+	for id := range state.keys {
+		if !keys[id] {
+			return ErrAppendOnly
+		}
+	}
+	return nil
+}
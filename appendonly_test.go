@@ -0,0 +1,99 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestAppendOnly(t *testing.T) {
+	adminPassword := "this_is_a_password"
+	clientPassword := "this_is_another_password"
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, adminPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	vol, _ := NewVolume("test", "")
+	_ = r.AddVolume(vol)
+	snapshot, _ := NewSnapshot("test_snapshot")
+	if err := snapshot.Save(&r); err != nil {
+		t.Fatalf("Failed saving snapshot: %s", err)
+	}
+	_ = vol.AddSnapshot(snapshot.ID)
+	if _, err := r.AddKey(clientPassword); err != nil {
+		t.Fatalf("Failed adding key: %s", err)
+	}
+	if err := r.SetAppendOnly(true); err != nil {
+		t.Fatalf("Failed enabling append-only mode: %s", err)
+	}
+
+	// clients without an admin key can only add data
+	r, err = OpenRepository(dir, clientPassword)
+	if err != nil {
+		t.Fatalf("Failed opening repository: %s", err)
+	}
+	if r.IsAdmin() {
+		t.Fatalf("Expected client key not to be an admin key")
+	}
+	added, _ := NewSnapshot("another_snapshot")
+	if err := added.Save(&r); err != nil {
+		t.Fatalf("Failed saving snapshot: %s", err)
+	}
+	_ = r.Volumes[0].AddSnapshot(added.ID)
+	if err := r.Save(); err != nil {
+		t.Fatalf("Failed saving repository: %s", err)
+	}
+	if err := r.ChangePassword("yet_another_password"); err != nil {
+		t.Fatalf("Failed changing password: %s", err)
+	}
+
+	if err := added.Save(&r); err != ErrAppendOnly {
+		t.Errorf("Expected overwriting a snapshot to fail with %v, got %v", ErrAppendOnly, err)
+	}
+	if err := r.backend.DeleteChunk(context.Background(), "0000", 0, 1); err != ErrAppendOnly {
+		t.Errorf("Expected deleting a chunk to fail with %v, got %v", ErrAppendOnly, err)
+	}
+	if err := r.SetAppendOnly(false); err != ErrAppendOnly {
+		t.Errorf("Expected disabling append-only mode to fail with %v, got %v", ErrAppendOnly, err)
+	}
+	_ = r.Volumes[0].RemoveSnapshot(snapshot.ID)
+	if err := r.Save(); err != ErrAppendOnly {
+		t.Errorf("Expected removing a snapshot to fail with %v, got %v", ErrAppendOnly, err)
+	}
+	_ = r.Volumes[0].AddSnapshot(snapshot.ID)
+	r.AppendOnly = false
+	if err := r.Save(); err != ErrAppendOnly {
+		t.Errorf("Expected disabling append-only mode to fail with %v, got %v", ErrAppendOnly, err)
+	}
+
+	// the admin key can remove data again
+	r, err = OpenRepository(dir, adminPassword)
+	if err != nil {
+		t.Fatalf("Failed opening repository: %s", err)
+	}
+	if !r.IsAdmin() || len(r.Volumes[0].Snapshots) != 2 {
+		t.Fatalf("Expected admin access to a repository with 2 snapshots")
+	}
+	_ = r.Volumes[0].RemoveSnapshot(snapshot.ID)
+	if err := r.Save(); err != nil {
+		t.Errorf("Failed removing snapshot with admin key: %s", err)
+	}
+	if err := r.SetAppendOnly(false); err != nil {
+		t.Errorf("Failed disabling append-only mode: %s", err)
+	}
+}
@@ -21,18 +21,35 @@ const (
 
 // Archive contains all metadata belonging to a file/directory.
 type Archive struct {
-	Path        string      `json:"path"`               // Where in filesystem does this belong to
-	PointsTo    string      `json:"pointsto,omitempty"` // If this is a SymLink, where does it point to
-	Mode        os.FileMode `json:"mode"`               // file mode bits
-	ModTime     int64       `json:"modtime"`            // modification time
-	Size        uint64      `json:"size"`               // size
-	StorageSize uint64      `json:"storagesize"`        // size in storage
-	UID         uint32      `json:"uid"`                // owner
-	GID         uint32      `json:"gid"`                // group
-	Chunks      []Chunk     `json:"chunks,omitempty"`   // data chunks
-	Encrypted   uint16      `json:"encrypted"`          // encryption type
-	Compressed  uint16      `json:"compressed"`         // compression type
-	Type        uint8       `json:"type"`               // Is this a File, Directory or SymLink
+	Path         string            `json:"path"`                   // Where in filesystem does this belong to
+	PointsTo     string            `json:"pointsto,omitempty"`     // If this is a SymLink, where does it point to
+	TargetMode   os.FileMode       `json:"targetmode,omitempty"`   // If this is a SymLink, the mode of its target at backup time
+	Dangling     bool              `json:"dangling,omitempty"`     // If this is a SymLink, whether its target was missing at backup time
+	LinkTo       string            `json:"linkto,omitempty"`       // If this is a hard link, the path of the item it shares its data with
+	Mode         os.FileMode       `json:"mode"`                   // file mode bits
+	ModTime      int64             `json:"modtime"`                // modification time
+	Size         uint64            `json:"size"`                   // size
+	StorageSize  uint64            `json:"storagesize"`            // size in storage
+	UID          uint32            `json:"uid"`                    // owner
+	GID          uint32            `json:"gid"`                    // group
+	XAttrs       map[string][]byte `json:"xattrs,omitempty"`       // extended attributes, including POSIX ACLs
+	Attributes   uint32            `json:"attributes,omitempty"`   // Windows file attributes, e.g. hidden, readonly or system
+	CreationTime int64             `json:"creationtime,omitempty"` // creation time on Windows
+	Streams      map[string][]byte `json:"streams,omitempty"`      // NTFS alternate data streams
+	Chunks       []Chunk           `json:"chunks,omitempty"`       // data chunks
+	Flags        map[string]string `json:"flags,omitempty"`        // findings of the processors which flagged this file, by their name
+	Encrypted    uint16            `json:"encrypted"`              // encryption type
+	Compressed   uint16            `json:"compressed"`             // compression type
+	Type         uint8             `json:"type"`                   // Is this a File, Directory or SymLink
+
+	// inode identifies files with multiple hard links while scanning
+	inode [2]uint64
+	// device is the ID of the device a file is stored on, if it's known
+	device uint64
+	// ino is the inode of a file, if it's known
+	ino uint64
+	// sourcePath is where the item's data gets read from while storing it
+	sourcePath string
 }
 
 // ArchiveResult wraps Archive and an error.
@@ -42,6 +59,16 @@ type ArchiveResult struct {
 	Error   error
 }
 
+// sparse returns true if the archive contains hole chunks.
+func (arc *Archive) sparse() bool {
+	for _, chunk := range arc.Chunks {
+		if chunk.Hole {
+			return true
+		}
+	}
+	return false
+}
+
 // IndexOfChunk returns the slice-index for a specific chunk number.
 func (arc *Archive) IndexOfChunk(chunkNum uint) (int, error) {
 	for i, chunk := range arc.Chunks {
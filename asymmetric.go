@@ -0,0 +1,150 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// asymmetricVersion is the first repository version supporting asymmetric
+// encryption.
+const asymmetricVersion = 7
+
+// Error declarations.
+var (
+	ErrRepositoryNotEmpty = errors.New("Asymmetric encryption can only be enabled for empty repositories")
+	ErrPrivateKeyRequired = errors.New("The repository uses asymmetric encryption, its private key is required to read its data")
+	ErrPrivateKeyMismatch = errors.New("The private key doesn't belong to the repository")
+	ErrEncryptionRequired = errors.New("The repository uses asymmetric encryption, data can't be stored unencrypted")
+)
+
+// EnableAsymmetricEncryption generates a key pair for the repository and
+// returns its private key. From now on all data and snapshots get sealed with
+// the public key, so storing data only requires the repository's password,
+// whereas reading any of it also requires the private key.
+//
+// The private key doesn't get stored in the repository, it's up to the
+// caller to keep it safe.
+func (r *Repository) EnableAsymmetricEncryption() ([]byte, error) {
+	if !r.IsEmpty() {
+		return nil, ErrRepositoryNotEmpty
+	}
+
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	r.PublicKey = pub[:]
+	r.privateKey = string(priv[:])
+	if r.Version < asymmetricVersion {
+		r.Version = asymmetricVersion
+	}
+	if r.ReaderVersion < asymmetricVersion {
+		r.ReaderVersion = asymmetricVersion
+	}
+
+	return priv[:], r.Save()
+}
+
+// Asymmetric returns true if the repository's data is sealed with a public
+// key.
+func (r *Repository) Asymmetric() bool {
+	return len(r.PublicKey) > 0
+}
+
+// SetPrivateKey supplies the private key needed to read data of a repository
+// using asymmetric encryption.
+func (r *Repository) SetPrivateKey(key []byte) error {
+	if len(key) != 32 {
+		return ErrInvalidKey
+	}
+
+	var pub, priv [32]byte
+	copy(priv[:], key)
+	curve25519.ScalarBaseMult(&pub, &priv)
+	if !bytes.Equal(pub[:], r.PublicKey) {
+		return ErrPrivateKeyMismatch
+	}
+
+	r.privateKey = string(key)
+	return nil
+}
+
+// CanRead returns false if the repository uses asymmetric encryption, but
+// its private key hasn't been supplied.
+func (r *Repository) CanRead() bool {
+	return !r.Asymmetric() || r.privateKey != ""
+}
+
+// DataEncryption returns the encryption algo used for new data. Repositories
+// using asymmetric encryption always seal their data with the public key.
+func (r *Repository) DataEncryption(method uint16) (uint16, error) {
+	if !r.Asymmetric() {
+		return method, nil
+	}
+	if method == EncryptionNone {
+		return method, ErrEncryptionRequired
+	}
+	return EncryptionX25519, nil
+}
+
+// encryptionKey returns the key data gets encrypted with.
+func (r *Repository) encryptionKey(method uint16) string {
+	if method == EncryptionX25519 {
+		return string(r.PublicKey)
+	}
+	return r.Key
+}
+
+// decryptionKey returns the key data gets decrypted with. id identifies the
+// data key it has been encrypted with.
+func (r *Repository) decryptionKey(method uint16, id string) (string, error) {
+	if method == EncryptionX25519 {
+		if r.privateKey == "" {
+			return "", ErrPrivateKeyRequired
+		}
+		return r.privateKey, nil
+	}
+	return r.dataKey(id)
+}
+
+// encodeSnapshot encodes and encrypts a snapshot's metadata. Repositories
+// using asymmetric encryption seal it with their public key.
+func (r *Repository) encodeSnapshot(v interface{}) ([]byte, error) {
+	if !r.Asymmetric() {
+		return r.encodeMetadata(CompressionLZMA, r.Key, v)
+	}
+
+	pipe, err := NewEncodingPipeline(CompressionLZMA, EncryptionX25519, string(r.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+	return pipe.Encode(v)
+}
+
+// decodeSnapshot decrypts and decodes a snapshot's metadata. It returns the
+// data key the metadata has been encrypted with.
+func (r *Repository) decodeSnapshot(b []byte, v interface{}) (string, error) {
+	if !r.Asymmetric() {
+		return r.decodeWithDataKeys(b, v)
+	}
+	if r.privateKey == "" {
+		return "", ErrPrivateKeyRequired
+	}
+
+	pipe, err := NewDecodingPipeline(CompressionLZMA, EncryptionX25519, r.privateKey)
+	if err != nil {
+		return "", err
+	}
+	return "", pipe.Decode(b, v)
+}
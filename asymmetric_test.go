@@ -0,0 +1,165 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAsymmetricEncryption(t *testing.T) {
+	testPassword := "this_is_a_password"
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var snapshotID string
+	var privateKey []byte
+	{
+		r, err := NewRepository(dir, testPassword)
+		if err != nil {
+			t.Fatalf("Failed creating repository: %s", err)
+		}
+		privateKey, err = r.EnableAsymmetricEncryption()
+		if err != nil {
+			t.Fatalf("Failed enabling asymmetric encryption: %s", err)
+		}
+	}
+
+	// a backup agent only knows the password
+	{
+		r, err := OpenRepository(dir, testPassword)
+		if err != nil {
+			t.Fatalf("Failed opening repository: %s", err)
+		}
+		if !r.Asymmetric() || r.CanRead() {
+			t.Fatalf("Expected an asymmetric repository, which can't be read")
+		}
+		encryption, err := r.DataEncryption(EncryptionAESGCM)
+		if err != nil || encryption != EncryptionX25519 {
+			t.Fatalf("Expected data to be sealed with the public key, got %d: %v", encryption, err)
+		}
+		if _, err := r.DataEncryption(EncryptionNone); err != ErrEncryptionRequired {
+			t.Errorf("Expected %v, got %v", ErrEncryptionRequired, err)
+		}
+
+		vol, _ := NewVolume("test_name", "test_description")
+		if err := r.AddVolume(vol); err != nil {
+			t.Fatalf("Failed creating volume: %s", err)
+		}
+		snapshot, _ := NewSnapshot("test_snapshot")
+		index, err := OpenChunkIndex(&r)
+		if err != nil {
+			t.Fatalf("Failed opening chunk-index: %s", err)
+		}
+		wd, _ := os.Getwd()
+		opts := StoreOptions{
+			CWD:       wd,
+			Paths:     []string{"asymmetric.go"},
+			Compress:  CompressionNone,
+			Encrypt:   encryption,
+			DataParts: 1,
+		}
+		for p := range snapshot.Add(context.Background(), r, &index, opts) {
+			if p.Error != nil {
+				t.Fatalf("Failed adding to snapshot: %s", p.Error)
+			}
+		}
+		if err := snapshot.Save(&r); err != nil {
+			t.Fatalf("Failed saving snapshot: %s", err)
+		}
+		_ = vol.AddSnapshot(snapshot.ID)
+		if err := r.Save(); err != nil {
+			t.Fatalf("Failed saving repository: %s", err)
+		}
+		if err := index.Save(&r); err != nil {
+			t.Fatalf("Failed saving chunk-index: %s", err)
+		}
+		snapshotID = snapshot.ID
+
+		if _, _, err := r.FindSnapshot(snapshotID); err != ErrPrivateKeyRequired {
+			t.Errorf("Expected %v, got %v", ErrPrivateKeyRequired, err)
+		}
+	}
+
+	r, err := OpenRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed opening repository: %s", err)
+	}
+	if err := r.SetPrivateKey(bytes.Repeat([]byte{1}, 32)); err != ErrPrivateKeyMismatch {
+		t.Errorf("Expected %v, got %v", ErrPrivateKeyMismatch, err)
+	}
+	if err := r.SetPrivateKey(privateKey); err != nil {
+		t.Fatalf("Failed setting private key: %s", err)
+	}
+	_, snapshot, err := r.FindSnapshot(snapshotID)
+	if err != nil {
+		t.Fatalf("Failed finding snapshot: %s", err)
+	}
+
+	targetdir, err := ioutil.TempDir("", "knoxite.target")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for restore: %s", err)
+	}
+	defer os.RemoveAll(targetdir)
+
+	progress, err := DecodeSnapshot(context.Background(), r, snapshot, targetdir, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Failed restoring snapshot: %s", err)
+	}
+	for p := range progress {
+		if p.Error != nil {
+			t.Errorf("Failed restoring snapshot: %s", p.Error)
+		}
+	}
+
+	hash1, _ := hashFile(filepath.Join(targetdir, "asymmetric.go"))
+	hash2, _ := hashFile("asymmetric.go")
+	if hash1 != hash2 {
+		t.Errorf("Failed verifying shasum: %s != %s", hash1, hash2)
+	}
+}
+
+func TestEncryptionX25519(t *testing.T) {
+	r := Repository{}
+	if _, err := r.EnableAsymmetricEncryption(); err == nil {
+		t.Fatalf("Expected saving a repository without backend to fail")
+	}
+
+	e, err := NewEncryptor(EncryptionX25519, string(r.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("knoxite")
+	b1, _ := e.Process(data)
+	b2, _ := e.Process(data)
+	if !bytes.Equal(b1, b2) {
+		t.Errorf("Expected equal data to result in equal ciphertexts")
+	}
+
+	d, err := NewDecryptor(EncryptionX25519, r.privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := d.Process(b1)
+	if err != nil || !bytes.Equal(b, data) {
+		t.Errorf("Failed decrypting data: %s %v", b, err)
+	}
+
+	b1[len(b1)-1] ^= 0xff
+	if _, err := d.Process(b1); err != ErrDecryptionFailed {
+		t.Errorf("Expected %v, got %v", ErrDecryptionFailed, err)
+	}
+}
@@ -8,7 +8,11 @@
 package knoxite
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/url"
 	"path/filepath"
 	"strings"
@@ -37,12 +41,13 @@ type Backend interface {
 	// AvailableSpace returns the free space in bytes on this backend
 	AvailableSpace() (uint64, error)
 
-	// LoadChunk loads a single Chunk
-	LoadChunk(shasum string, part, totalParts uint) ([]byte, error)
-	// StoreChunk stores a single Chunk
-	StoreChunk(shasum string, part, totalParts uint, data []byte) (uint64, error)
+	// LoadChunk returns a reader for a single Chunk. The caller must close it.
+	// Reading from it fails once ctx is done
+	LoadChunk(ctx context.Context, shasum string, part, totalParts uint) (io.ReadCloser, error)
+	// StoreChunk stores a single Chunk of the given size, read from data
+	StoreChunk(ctx context.Context, shasum string, part, totalParts uint, data io.Reader, size uint64) (uint64, error)
 	// DeleteChunk deletes a single Chunk
-	DeleteChunk(shasum string, part, totalParts uint) error
+	DeleteChunk(ctx context.Context, shasum string, part, totalParts uint) error
 
 	// LoadSnapshot loads a snapshot
 	LoadSnapshot(id string) ([]byte, error)
@@ -60,15 +65,118 @@ type Backend interface {
 	LoadRepository() ([]byte, error)
 	// SaveRepository stores the metadata for a repository
 	SaveRepository(data []byte) error
+
+	// LoadPasswordHint reads the repository's unencrypted password hint
+	LoadPasswordHint() ([]byte, error)
+	// SavePasswordHint stores the repository's unencrypted password hint
+	SavePasswordHint(data []byte) error
+}
+
+// BackendLister is implemented by backends, which can enumerate the data they
+// store. It's used to salvage data from damaged repositories.
+type BackendLister interface {
+	// ListSnapshots returns the IDs of all stored snapshots
+	ListSnapshots() ([]string, error)
+	// ListChunks returns all stored parts of chunks
+	ListChunks() ([]ChunkPart, error)
+}
+
+// IndexingBackend is implemented by backends, which maintain the chunk-index
+// on the storage side, like knoxite's server. Clients then don't need to load
+// the chunk-index: they ask which chunks are stored already, only push the
+// missing ones and tell the backend which chunks a snapshot references.
+type IndexingBackend interface {
+	// HasChunk reports whether a part of a chunk is stored
+	HasChunk(ctx context.Context, shasum string, part, totalParts uint) (bool, error)
+	// AddChunkReferences records chunks as being referenced by a snapshot
+	AddChunkReferences(snapshot string, chunks []ChunkIndexItem) error
+	// RemoveChunkReferences drops all chunk references of a snapshot
+	RemoveChunkReferences(snapshot string) error
+	// PackChunks deletes chunks no longer referenced by any snapshot and
+	// returns the freed storage space
+	PackChunks(ctx context.Context) (uint64, error)
+}
+
+// ChunkIndexPackBackend is implemented by backends, which can store the
+// chunk-index in packs. The chunk-index then only lists its packs, and saving
+// it only adds a pack with the changes made since it got loaded, instead of
+// rewriting the entire chunk-index.
+type ChunkIndexPackBackend interface {
+	// LoadChunkIndexPack reads a pack of the chunk-index
+	LoadChunkIndexPack(id string) ([]byte, error)
+	// SaveChunkIndexPack stores a pack of the chunk-index
+	SaveChunkIndexPack(id string, data []byte) error
+	// DeleteChunkIndexPack deletes a pack of the chunk-index
+	DeleteChunkIndexPack(id string) error
+}
+
+// LockingBackend is implemented by backends, which can store the locks of the
+// clients accessing a repository.
+type LockingBackend interface {
+	// ListLocks returns the IDs of all locks
+	ListLocks() ([]string, error)
+	// LoadLock reads a lock
+	LoadLock(id string) ([]byte, error)
+	// SaveLock stores a lock
+	SaveLock(id string, data []byte) error
+	// DeleteLock deletes a lock
+	DeleteLock(id string) error
+}
+
+// RangeLoader is implemented by backends, which can load the chunk-index and
+// snapshots starting at an offset. Downloads of them, which got interrupted,
+// then get resumed instead of restarted.
+type RangeLoader interface {
+	// LoadChunkIndexRange returns a reader for the chunk-index starting at
+	// offset, and its total size or -1 if it's unknown. The caller must close
+	// the reader
+	LoadChunkIndexRange(ctx context.Context, offset int64) (io.ReadCloser, int64, error)
+	// LoadSnapshotRange returns a reader for a snapshot starting at offset,
+	// and its total size or -1 if it's unknown. The caller must close the
+	// reader
+	LoadSnapshotRange(ctx context.Context, id string, offset int64) (io.ReadCloser, int64, error)
+}
+
+// ChunkSizer is implemented by backends, which can tell the size of a stored
+// part of a chunk without loading it.
+type ChunkSizer interface {
+	// ChunkSize returns the size of a stored part of a chunk
+	ChunkSize(ctx context.Context, shasum string, part, totalParts uint) (uint64, error)
+}
+
+// VersionedBackend is implemented by remote backends, which can tell the
+// current version of the chunk-index and snapshots without loading them, e.g.
+// their ETag. Clients then keep copies of them in a local cache, and only load
+// them again once they changed.
+type VersionedBackend interface {
+	// ChunkIndexVersion returns the version of the chunk-index
+	ChunkIndexVersion() (string, error)
+	// SnapshotVersion returns the version of a snapshot
+	SnapshotVersion(id string) (string, error)
+}
+
+// ChunkPart identifies a single stored part of a chunk.
+type ChunkPart struct {
+	Hash       string
+	Part       uint
+	TotalParts uint
+}
+
+// String returns the name a part of a chunk gets stored with.
+func (part ChunkPart) String() string {
+	return fmt.Sprintf("%s.%d_%d", part.Hash, part.Part, part.TotalParts)
 }
 
 // Error declarations.
 var (
-	ErrRepositoryExists        = errors.New("Repository seems to already exist")
-	ErrInvalidRepositoryURL    = errors.New("Invalid repository url specified")
-	ErrAvailableSpaceUnknown   = errors.New("Available space is unknown or undefined")
-	ErrAvailableSpaceUnlimited = errors.New("Available space is unlimited")
-	ErrInvalidUsername         = errors.New("Username wrong or missing")
+	ErrListingUnsupported         = errors.New("Listing stored data is not supported by this backend")
+	ErrVersioningUnsupported      = errors.New("Telling the version of stored data is not supported by this backend")
+	ErrChunkIndexPacksUnsupported = errors.New("Storing the chunk-index in packs is not supported by this backend")
+	ErrRepositoryExists           = errors.New("Repository seems to already exist")
+	ErrInvalidRepositoryURL       = errors.New("Invalid repository url specified")
+	ErrAvailableSpaceUnknown      = errors.New("Available space is unknown or undefined")
+	ErrAvailableSpaceUnlimited    = errors.New("Available space is unlimited")
+	ErrInvalidUsername            = errors.New("Username wrong or missing")
 
 	backends = []BackendFactory{}
 )
@@ -79,6 +187,17 @@ func RegisterStorageBackend(factory BackendFactory) {
 	backends = append(backends, factory)
 }
 
+// StorageProtocols returns the URL schemes supported by all registered
+// storage backends.
+func StorageProtocols() []string {
+	var protocols []string
+	for _, backend := range backends {
+		protocols = append(protocols, backend.Protocols()...)
+	}
+
+	return protocols
+}
+
 func newBackendFromProtocol(url url.URL) (Backend, error) {
 	for _, backend := range backends {
 		for _, p := range backend.Protocols() {
@@ -114,3 +233,26 @@ func BackendFromURL(path string) (Backend, error) {
 
 	return newBackendFromProtocol(*u)
 }
+
+// RedactURL removes the password from the location of a backend, so it can be
+// logged or printed.
+func RedactURL(location string) string {
+	u, err := url.Parse(location)
+	if err != nil || u.User == nil {
+		return location
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), "xxxxx")
+	}
+	return u.String()
+}
+
+// readAll reads everything from r and closes it. Some backends only report
+// errors on close, so its error gets returned as well.
+func readAll(r io.ReadCloser) ([]byte, error) {
+	data, err := ioutil.ReadAll(r)
+	if cerr := r.Close(); err == nil && cerr != nil {
+		return nil, cerr
+	}
+	return data, err
+}
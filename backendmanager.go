@@ -7,37 +7,80 @@
 
 package knoxite
 
-import "errors"
-
-const (
-	retries = 3
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
 )
 
 // BackendManager stores data on multiple backends.
 type BackendManager struct {
 	Backends []*Backend
-
-	lastUsedBackend int
+	// Retry defines how failed operations get retried. If it's nil, the
+	// DefaultRetryPolicy is used
+	Retry *RetryPolicy
+	// ReadOnly rejects all operations modifying the stored data
+	ReadOnly bool
+	// AppendOnly rejects all operations removing or overwriting stored data
+	AppendOnly bool
+
+	// accessed atomically, as chunks get stored concurrently
+	lastUsedBackend uint32
+	// debts records the data backends missed while they were unavailable
+	debts *debtLedger
 }
 
 // Error declarations.
 var (
-	ErrLoadChunkFailed       = errors.New("Unable to load chunk from any storage backend")
-	ErrLoadSnapshotFailed    = errors.New("Unable to load snapshot from any storage backend")
-	ErrLoadChunkIndexFailed  = errors.New("Unable to load chunk-index from any storage backend")
-	ErrLoadRepositoryFailed  = errors.New("Unable to load repository from any storage backend")
-	ErrDeleteChunkFailed     = errors.New("Unable to delete chunk from any storage backend")
-	ErrStoreChunkFailed      = errors.New("Storing chunk failed")
-	ErrStoreSnapshotFailed   = errors.New("Storing snapshot failed")
-	ErrStoreChunkIndexFailed = errors.New("Storing chunk-index failed")
-	ErrStoreRepositoryFailed = errors.New("Storing repository failed")
+	ErrLoadChunkFailed         = errors.New("Unable to load chunk from any storage backend")
+	ErrLoadSnapshotFailed      = errors.New("Unable to load snapshot from any storage backend")
+	ErrLoadChunkIndexFailed    = errors.New("Unable to load chunk-index from any storage backend")
+	ErrLoadRepositoryFailed    = errors.New("Unable to load repository from any storage backend")
+	ErrLoadPasswordHintFailed  = errors.New("Unable to load password hint from any storage backend")
+	ErrDeleteChunkFailed       = errors.New("Unable to delete chunk from any storage backend")
+	ErrSetChunkTierFailed      = errors.New("Unable to move chunk to another tier on any storage backend")
+	ErrThawChunkFailed         = errors.New("Unable to retrieve archived chunk from any storage backend")
+	ErrStoreChunkFailed        = errors.New("Storing chunk failed")
+	ErrStoreSnapshotFailed     = errors.New("Storing snapshot failed")
+	ErrStoreChunkIndexFailed   = errors.New("Storing chunk-index failed")
+	ErrStoreRepositoryFailed   = errors.New("Storing repository failed")
+	ErrStorePasswordHintFailed = errors.New("Storing password hint failed")
+	ErrRepositoryReadOnly      = errors.New("The repository has been opened read-only")
 )
 
 // AddBackend adds a backend.
 func (backend *BackendManager) AddBackend(be *Backend) {
+	if backend.debts == nil {
+		backend.debts = newDebtLedger()
+	}
 	backend.Backends = append(backend.Backends, be)
 }
 
+// retry calls op with the manager's RetryPolicy.
+func (backend *BackendManager) retry(ctx context.Context, op func() error) error {
+	policy := DefaultRetryPolicy
+	if backend.Retry != nil {
+		policy = *backend.Retry
+	}
+	return policy.retry(ctx, op)
+}
+
+// trace logs a request to a storage backend and how long it took at debug
+// level, to help diagnosing slow or failing backends.
+func trace(be *Backend, start time.Time, err error, format string, v ...interface{}) {
+	request := fmt.Sprintf(format, v...)
+	location := RedactURL((*be).Location())
+	if err != nil {
+		log.Debugf("%s: %s failed after %s: %v", location, request, time.Since(start), err)
+		return
+	}
+	log.Debugf("%s: %s took %s", location, request, time.Since(start))
+}
+
 // Locations returns the urls for all backends.
 func (backend *BackendManager) Locations() []string {
 	paths := []string{}
@@ -49,128 +92,448 @@ func (backend *BackendManager) Locations() []string {
 }
 
 // LoadChunk loads a Chunk from backends.
-func (backend *BackendManager) LoadChunk(chunk Chunk, part uint) ([]byte, error) {
+func (backend *BackendManager) LoadChunk(ctx context.Context, chunk Chunk, part uint) ([]byte, error) {
+	b, _, err := backend.locateChunk(ctx, chunk, part)
+	return b, err
+}
+
+// locateChunk loads a part of a Chunk and also returns the backend it has
+// been loaded from.
+func (backend *BackendManager) locateChunk(ctx context.Context, chunk Chunk, part uint) ([]byte, *Backend, error) {
 	for _, be := range backend.Backends {
-		for i := 0; i < retries; i++ {
-			b, err := (*be).LoadChunk(chunk.Hash, part, chunk.DataParts)
-			if err == nil {
-				return b, err
+		var b []byte
+		err := backend.retry(ctx, func() error {
+			start := time.Now()
+			var err error
+			b, err = readChunkPart(ctx, be, chunk, part)
+			if ctx.Err() == nil {
+				trace(be, start, err, "loading chunk %s (part %d/%d)", chunk.Hash, part+1, chunk.DataParts)
 			}
+			return err
+		})
+		if ctx.Err() != nil {
+			return []byte{}, nil, ctx.Err()
+		}
+		if err == nil {
+			return b, be, nil
 		}
 	}
 
-	return []byte{}, ErrLoadChunkFailed
+	return []byte{}, nil, ErrLoadChunkFailed
 }
 
-// StoreChunk stores a single Chunk on backends.
-func (backend *BackendManager) StoreChunk(chunk Chunk) (size uint64, err error) {
-	for i, data := range *chunk.Data {
-		// Use storage backends in a round robin fashion to store chunks
-		backend.lastUsedBackend++
-		if backend.lastUsedBackend+1 > len(backend.Backends) {
-			backend.lastUsedBackend = 0
-		}
+// readChunkPart reads a single part of a Chunk from a backend. Chunks get
+// decrypted and erasure decoded as a whole, so the part is read completely.
+func readChunkPart(ctx context.Context, be *Backend, chunk Chunk, part uint) ([]byte, error) {
+	r, err := (*be).LoadChunk(ctx, chunk.Hash, part, chunk.DataParts)
+	if err != nil {
+		return nil, err
+	}
+	return readAll(r)
+}
 
-		be := backend.Backends[backend.lastUsedBackend]
+// StoreChunk stores a single Chunk on backends. Its parts have been encoded
+// in memory already, so they get streamed to the backends from there.
+func (backend *BackendManager) StoreChunk(ctx context.Context, chunk Chunk) (size uint64, err error) {
+	if backend.ReadOnly {
+		return 0, ErrRepositoryReadOnly
+	}
 
-		var n uint64
-		var err error
-		for j := 0; j < retries; j++ {
-			n, err = (*be).StoreChunk(chunk.Hash, uint(i), chunk.DataParts, data)
-			if err != nil {
-				// retry
+	// Use storage backends in a round robin fashion to store chunks. All parts
+	// of a chunk get reserved at once, so chunks stored concurrently don't end
+	// up with multiple parts on the same backend
+	parts := uint32(len(*chunk.Data))
+	first := atomic.AddUint32(&backend.lastUsedBackend, parts) - parts
+
+	for i, data := range *chunk.Data {
+		be := backend.Backends[int(first+uint32(i))%len(backend.Backends)]
+
+		// backends maintaining the chunk-index know which chunks they store
+		// already, so these don't need to be transferred again
+		if ib, ok := (*be).(IndexingBackend); ok {
+			var stored bool
+			err := backend.retry(ctx, func() error {
+				var err error
+				stored, err = ib.HasChunk(ctx, chunk.Hash, uint(i), chunk.DataParts)
+				return err
+			})
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			if err == nil && stored {
+				log.Debugf("%s: chunk %s (part %d/%d) is stored already", RedactURL((*be).Location()), chunk.Hash, i+1, chunk.DataParts)
 				continue
 			}
+		}
 
-			if n > size {
-				size = n
+		n, err := backend.storeChunkPartOn(ctx, be, chunk, uint(i), data)
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		if err != nil && unavailable(err) {
+			// store the part on another backend for now, it gets copied to
+			// this one once it's available again
+			cause := err
+			for j := 1; j < len(backend.Backends) && err != nil; j++ {
+				other := backend.Backends[int(first+uint32(i+j))%len(backend.Backends)]
+				n, err = backend.storeChunkPartOn(ctx, other, chunk, uint(i), data)
+				if ctx.Err() != nil {
+					return 0, ctx.Err()
+				}
+			}
+			if err == nil {
+				backend.debts.markDown((*be).Location(), cause)
+				name := ChunkPart{Hash: chunk.Hash, Part: uint(i), TotalParts: chunk.DataParts}.String()
+				backend.debts.update((*be).Location(), func(d *debt) { d.chunks[name] = true })
 			}
-			break
 		}
 		if err != nil {
 			return 0, err
 		}
+
+		if n > size {
+			size = n
+		}
 	}
 
 	return size, nil
 }
 
+// storeChunkPartOn stores a single part of a Chunk on a backend, unless it
+// has been found to be unavailable.
+func (backend *BackendManager) storeChunkPartOn(ctx context.Context, be *Backend, chunk Chunk, part uint, data []byte) (uint64, error) {
+	if backend.debts.isDown((*be).Location()) {
+		return 0, ErrBackendUnavailable
+	}
+
+	var n uint64
+	err := backend.retry(ctx, func() error {
+		start := time.Now()
+		var err error
+		n, err = (*be).StoreChunk(ctx, chunk.Hash, part, chunk.DataParts, bytes.NewReader(data), uint64(len(data)))
+		if ctx.Err() == nil {
+			trace(be, start, err, "storing chunk %s (part %d/%d, %d bytes)", chunk.Hash, part+1, chunk.DataParts, len(data))
+		}
+		return err
+	})
+	return n, err
+}
+
+// storeChunkPart stores a single part of a Chunk on a specific backend.
+func (backend *BackendManager) storeChunkPart(ctx context.Context, be *Backend, chunk Chunk, part uint, data []byte) error {
+	if backend.ReadOnly {
+		return ErrRepositoryReadOnly
+	}
+	if backend.AppendOnly {
+		return ErrAppendOnly
+	}
+
+	return backend.retry(ctx, func() error {
+		start := time.Now()
+		_, err := (*be).StoreChunk(ctx, chunk.Hash, part, chunk.DataParts, bytes.NewReader(data), uint64(len(data)))
+		if ctx.Err() == nil {
+			trace(be, start, err, "storing chunk %s (part %d/%d, %d bytes)", chunk.Hash, part+1, chunk.DataParts, len(data))
+		}
+		return err
+	})
+}
+
 // DeleteChunk deletes a single Chunk.
-func (backend *BackendManager) DeleteChunk(shasum string, part, totalParts uint) error {
+func (backend *BackendManager) DeleteChunk(ctx context.Context, shasum string, part, totalParts uint) error {
+	if backend.ReadOnly {
+		return ErrRepositoryReadOnly
+	}
+	if backend.AppendOnly {
+		return ErrAppendOnly
+	}
+
 	for _, be := range backend.Backends {
-		for i := 0; i < retries; i++ {
-			err := (*be).DeleteChunk(shasum, part, totalParts)
-			if err == nil {
-				return nil
+		err := backend.retry(ctx, func() error {
+			start := time.Now()
+			err := (*be).DeleteChunk(ctx, shasum, part, totalParts)
+			if ctx.Err() == nil {
+				trace(be, start, err, "deleting chunk %s (part %d/%d)", shasum, part+1, totalParts)
 			}
+			return err
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			return nil
 		}
 	}
 
 	return ErrDeleteChunkFailed
 }
 
-// LoadSnapshot loads a snapshot.
-func (backend *BackendManager) LoadSnapshot(id string) ([]byte, error) {
+// SetChunkTier moves a single chunk to another storage tier.
+func (backend *BackendManager) SetChunkTier(ctx context.Context, shasum string, part, totalParts uint, tier string) error {
+	if backend.ReadOnly {
+		return ErrRepositoryReadOnly
+	}
+
+	supported := false
 	for _, be := range backend.Backends {
-		for i := 0; i < retries; i++ {
-			b, err := (*be).LoadSnapshot(id)
-			if err == nil {
-				return b, err
+		tb, ok := (*be).(TieringBackend)
+		if !ok {
+			continue
+		}
+		supported = true
+
+		err := backend.retry(ctx, func() error {
+			start := time.Now()
+			err := tb.SetChunkTier(ctx, shasum, part, totalParts, tier)
+			if ctx.Err() == nil {
+				trace(be, start, err, "moving chunk %s (part %d/%d) to tier %s", shasum, part+1, totalParts, tier)
 			}
+			return err
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			return nil
 		}
 	}
 
-	return []byte{}, ErrLoadSnapshotFailed
+	if !supported {
+		return ErrTieringUnsupported
+	}
+	return ErrSetChunkTierFailed
 }
 
-// SaveSnapshot stores a snapshot on all storage backends.
-func (backend *BackendManager) SaveSnapshot(id string, b []byte) error {
+// ThawChunk requests a chunk stored in an archive tier to be retrieved. It
+// returns true once the chunk can be read.
+func (backend *BackendManager) ThawChunk(ctx context.Context, shasum string, part, totalParts uint) (bool, error) {
+	supported := false
 	for _, be := range backend.Backends {
-		var err error
-		for i := 0; i < retries; i++ {
-			err = (*be).SaveSnapshot(id, b)
-			if err == nil {
-				break
-			}
+		ab, ok := (*be).(ArchivingBackend)
+		if !ok {
+			continue
 		}
-		if err != nil {
+		supported = true
+
+		var ready bool
+		err := backend.retry(ctx, func() error {
+			start := time.Now()
+			var err error
+			ready, err = ab.ThawChunk(ctx, shasum, part, totalParts)
+			if ctx.Err() == nil {
+				trace(be, start, err, "retrieving chunk %s (part %d/%d)", shasum, part+1, totalParts)
+			}
 			return err
+		})
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		if err == nil {
+			return ready, nil
 		}
 	}
 
-	return nil
+	if !supported {
+		// there's nothing to retrieve
+		return true, nil
+	}
+	return false, ErrThawChunkFailed
+}
+
+// LoadSnapshot loads a snapshot.
+func (backend *BackendManager) LoadSnapshot(id string) ([]byte, error) {
+	for _, be := range backend.readOrder(func(d *debt) bool { return d.snapshots[id] }) {
+		start := time.Now()
+		b, err := backend.loadCached(be, "snapshots/"+id, func(vb VersionedBackend) (string, error) {
+			return vb.SnapshotVersion(id)
+		}, func() ([]byte, error) {
+			return backend.loadObject(context.Background(), be, func() ([]byte, error) {
+				return (*be).LoadSnapshot(id)
+			}, func(rl RangeLoader, offset int64) (io.ReadCloser, int64, error) {
+				return rl.LoadSnapshotRange(context.Background(), id, offset)
+			})
+		})
+		trace(be, start, err, "loading snapshot %s", id)
+		if err == nil {
+			return b, nil
+		}
+	}
+
+	return []byte{}, ErrLoadSnapshotFailed
+}
+
+// SaveSnapshot stores a snapshot on all storage backends.
+func (backend *BackendManager) SaveSnapshot(id string, b []byte) error {
+	if backend.ReadOnly {
+		return ErrRepositoryReadOnly
+	}
+	if backend.AppendOnly {
+		if _, err := backend.LoadSnapshot(id); err == nil {
+			return ErrAppendOnly
+		}
+	}
+
+	return backend.saveOnAll("saving snapshot "+id, func(be Backend) error {
+		return be.SaveSnapshot(id, b)
+	}, func(d *debt, owed bool) {
+		if owed {
+			d.snapshots[id] = true
+		} else {
+			delete(d.snapshots, id)
+		}
+	})
 }
 
 // LoadChunkIndex loads the chunk-index.
 func (backend *BackendManager) LoadChunkIndex() ([]byte, error) {
-	for _, be := range backend.Backends {
-		for i := 0; i < retries; i++ {
-			b, err := (*be).LoadChunkIndex()
-			if err == nil {
-				return b, err
-			}
+	for _, be := range backend.readOrder(func(d *debt) bool { return d.chunkIndex }) {
+		start := time.Now()
+		b, err := backend.loadCached(be, "index", func(vb VersionedBackend) (string, error) {
+			return vb.ChunkIndexVersion()
+		}, func() ([]byte, error) {
+			return backend.loadObject(context.Background(), be, (*be).LoadChunkIndex, func(rl RangeLoader, offset int64) (io.ReadCloser, int64, error) {
+				return rl.LoadChunkIndexRange(context.Background(), offset)
+			})
+		})
+		trace(be, start, err, "loading chunk-index")
+		if err == nil {
+			return b, nil
 		}
 	}
 
 	return []byte{}, ErrLoadChunkIndexFailed
 }
 
+// loadObject loads an object from be with load, retrying it on transient
+// errors. If be is a RangeLoader, the object gets loaded with loadRange
+// instead: downloads interrupted by transient errors get resumed where they
+// stopped, and only count as a retry if they didn't make any progress.
+func (backend *BackendManager) loadObject(ctx context.Context, be *Backend, load func() ([]byte, error), loadRange func(rl RangeLoader, offset int64) (io.ReadCloser, int64, error)) ([]byte, error) {
+	rl, ok := (*be).(RangeLoader)
+	if !ok {
+		var b []byte
+		err := backend.retry(ctx, func() error {
+			var err error
+			b, err = load()
+			return err
+		})
+		return b, err
+	}
+
+	var buf bytes.Buffer
+	size := int64(-1)
+	err := backend.retry(ctx, func() error {
+		for {
+			offset := int64(buf.Len())
+			rc, total, err := loadRange(rl, offset)
+			if err != nil {
+				return err
+			}
+			if offset > 0 && total != size {
+				// the object changed in the meantime, start over
+				rc.Close()
+				buf.Reset()
+				return errors.New("object changed while loading it")
+			}
+			size = total
+
+			n, err := io.Copy(&buf, rc)
+			rc.Close()
+			if err == nil && size >= 0 && int64(buf.Len()) < size {
+				err = io.ErrUnexpectedEOF
+			}
+			if err == nil || n == 0 || !IsRetryable(err) {
+				return err
+			}
+			log.Debugf("Resuming download after %s: %v", SizeToString(uint64(buf.Len())), err)
+		}
+	})
+	return buf.Bytes(), err
+}
+
 // SaveChunkIndex stores the chunk-index on all storage backends.
 func (backend *BackendManager) SaveChunkIndex(b []byte) error {
-	for _, be := range backend.Backends {
-		var err error
-		for i := 0; i < retries; i++ {
-			err = (*be).SaveChunkIndex(b)
-			if err == nil {
-				break
-			}
+	if backend.ReadOnly {
+		return ErrRepositoryReadOnly
+	}
+
+	return backend.saveOnAll("saving chunk-index", func(be Backend) error {
+		return be.SaveChunkIndex(b)
+	}, func(d *debt, owed bool) {
+		d.chunkIndex = owed
+	})
+}
+
+// LoadChunkIndexPack loads a pack of the chunk-index.
+func (backend *BackendManager) LoadChunkIndexPack(id string) ([]byte, error) {
+	for _, be := range backend.readOrder(func(d *debt) bool { return d.chunkIndex }) {
+		pb, ok := (*be).(ChunkIndexPackBackend)
+		if !ok {
+			// e.g. a mirror added after the index got stored in packs
+			continue
 		}
-		if err != nil {
+		var b []byte
+		err := backend.retry(context.Background(), func() error {
+			start := time.Now()
+			var err error
+			b, err = pb.LoadChunkIndexPack(id)
+			trace(be, start, err, "loading chunk-index pack %s", id)
 			return err
+		})
+		if err == nil {
+			return b, nil
 		}
 	}
 
-	return nil
+	return []byte{}, ErrLoadChunkIndexFailed
+}
+
+// SaveChunkIndexPack stores a pack of the chunk-index on all storage
+// backends.
+func (backend *BackendManager) SaveChunkIndexPack(id string, b []byte) error {
+	if backend.ReadOnly {
+		return ErrRepositoryReadOnly
+	}
+
+	return backend.saveOnAll("saving chunk-index pack "+id, func(be Backend) error {
+		pb, ok := be.(ChunkIndexPackBackend)
+		if !ok {
+			return ErrChunkIndexPacksUnsupported
+		}
+		return pb.SaveChunkIndexPack(id, b)
+	}, func(d *debt, owed bool) {
+		if owed {
+			d.chunkIndex = true
+		}
+	})
+}
+
+// DeleteChunkIndexPack deletes a pack of the chunk-index from all storage
+// backends.
+func (backend *BackendManager) DeleteChunkIndexPack(id string) error {
+	if backend.ReadOnly {
+		return ErrRepositoryReadOnly
+	}
+	if backend.AppendOnly {
+		return ErrAppendOnly
+	}
+
+	var lastErr error
+	for _, be := range backend.Backends {
+		pb, ok := (*be).(ChunkIndexPackBackend)
+		if !ok {
+			continue
+		}
+		err := backend.retry(context.Background(), func() error {
+			start := time.Now()
+			err := pb.DeleteChunkIndexPack(id)
+			trace(be, start, err, "deleting chunk-index pack %s", id)
+			return err
+		})
+		if err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
 }
 
 // InitRepository creates a new repository.
@@ -187,12 +550,17 @@ func (backend *BackendManager) InitRepository() error {
 
 // LoadRepository reads the metadata for a repository.
 func (backend *BackendManager) LoadRepository() ([]byte, error) {
-	for _, be := range backend.Backends {
-		for i := 0; i < retries; i++ {
-			b, err := (*be).LoadRepository()
-			if err == nil {
-				return b, err
-			}
+	for _, be := range backend.readOrder(func(d *debt) bool { return d.repository }) {
+		var b []byte
+		err := backend.retry(context.Background(), func() error {
+			start := time.Now()
+			var err error
+			b, err = (*be).LoadRepository()
+			trace(be, start, err, "loading repository")
+			return err
+		})
+		if err == nil {
+			return b, nil
 		}
 	}
 
@@ -201,18 +569,82 @@ func (backend *BackendManager) LoadRepository() ([]byte, error) {
 
 // SaveRepository stores the metadata for a repository.
 func (backend *BackendManager) SaveRepository(b []byte) error {
+	if backend.ReadOnly {
+		return ErrRepositoryReadOnly
+	}
+
+	return backend.saveOnAll("saving repository", func(be Backend) error {
+		return be.SaveRepository(b)
+	}, func(d *debt, owed bool) {
+		d.repository = owed
+	})
+}
+
+// LoadPasswordHint reads the repository's password hint.
+func (backend *BackendManager) LoadPasswordHint() ([]byte, error) {
+	for _, be := range backend.readOrder(func(d *debt) bool { return d.passwordHint }) {
+		var b []byte
+		err := backend.retry(context.Background(), func() error {
+			start := time.Now()
+			var err error
+			b, err = (*be).LoadPasswordHint()
+			trace(be, start, err, "loading password hint")
+			return err
+		})
+		if err == nil {
+			return b, nil
+		}
+	}
+
+	return []byte{}, ErrLoadPasswordHintFailed
+}
+
+// SavePasswordHint stores the repository's password hint on all storage
+// backends.
+func (backend *BackendManager) SavePasswordHint(b []byte) error {
+	if backend.ReadOnly {
+		return ErrRepositoryReadOnly
+	}
+
+	return backend.saveOnAll("saving password hint", func(be Backend) error {
+		return be.SavePasswordHint(b)
+	}, func(d *debt, owed bool) {
+		d.passwordHint = owed
+	})
+}
+
+// indexingBackends returns the storage backends, if all of them maintain the
+// chunk-index themselves.
+func (backend *BackendManager) indexingBackends() []IndexingBackend {
+	var ibs []IndexingBackend
 	for _, be := range backend.Backends {
-		var err error
-		for i := 0; i < retries; i++ {
-			err = (*be).SaveRepository(b)
-			if err == nil {
-				break
-			}
+		ib, ok := (*be).(IndexingBackend)
+		if !ok {
+			return nil
 		}
-		if err != nil {
-			return err
+		ibs = append(ibs, ib)
+	}
+
+	return ibs
+}
+
+// chunkIndexPacks returns true if all storage backends can store the
+// chunk-index in packs.
+func (backend *BackendManager) chunkIndexPacks() bool {
+	for _, be := range backend.Backends {
+		if _, ok := (*be).(ChunkIndexPackBackend); !ok {
+			return false
 		}
 	}
+	return len(backend.Backends) > 0
+}
 
+// lockingBackend returns the first storage backend, which can store locks.
+func (backend *BackendManager) lockingBackend() LockingBackend {
+	for _, be := range backend.Backends {
+		if lb, ok := (*be).(LockingBackend); ok {
+			return lb
+		}
+	}
 	return nil
 }
@@ -0,0 +1,180 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// A ChangeCache remembers the chunks of the files stored in a volume, so
+// files which haven't changed since don't need to be read, chunked and
+// encrypted again by later snapshots. Files are recognized as unchanged by
+// their path, size, modification time and inode.
+//
+// The cache is kept on the local machine, encrypted with the repository's
+// key. It's safe to delete it at any time.
+type ChangeCache struct {
+	mut  sync.Mutex
+	path string
+	// files maps the absolute paths of files, without the StripPrefix, to
+	// what's been stored of them
+	files map[string]changeCacheEntry
+	// seen contains the files, which have been visited by Snapshot.Add
+	seen map[string]changeCacheEntry
+	// roots are the paths Snapshot.Add stored the files below
+	roots []string
+}
+
+// changeCacheEntry is what gets remembered of a stored file.
+type changeCacheEntry struct {
+	Size       uint64  `json:"size"`
+	ModTime    int64   `json:"modtime"`
+	Inode      uint64  `json:"inode,omitempty"`
+	Chunks     []Chunk `json:"chunks"`
+	Encrypted  uint16  `json:"encrypted"`
+	Compressed uint16  `json:"compressed"`
+	// Domain is the deduplication domain the chunks have been stored in
+	Domain string `json:"domain,omitempty"`
+}
+
+// OpenChangeCache opens the change cache of a volume. An empty cache gets
+// returned if there's none yet, or it can't be read anymore, e.g. because
+// the repository's key has been rotated.
+func OpenChangeCache(repository *Repository, volume string) (*ChangeCache, error) {
+	dir, err := CacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &ChangeCache{
+		path:  filepath.Join(dir, "changes", repository.ID, volume),
+		files: make(map[string]changeCacheEntry),
+		seen:  make(map[string]changeCacheEntry),
+	}
+	b, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeMetadata(CompressionZstd, repository.Key, b, &c.files); err != nil {
+		log.Warnf("Ignoring unreadable change cache %s: %v", c.path, err)
+		c.files = make(map[string]changeCacheEntry)
+	}
+	return c, nil
+}
+
+// Save writes the cache, remembering the files seen by Snapshot.Add. Files
+// below the stored paths, which haven't been seen, don't exist anymore and
+// get forgotten. Save should only be called once the snapshot has been saved.
+func (c *ChangeCache) Save(repository *Repository) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	files := make(map[string]changeCacheEntry)
+	for path, e := range c.files {
+		if !withinPaths(path, "", c.roots) {
+			files[path] = e
+		}
+	}
+	for path, e := range c.seen {
+		files[path] = e
+	}
+
+	b, err := repository.encodeMetadata(CompressionZstd, repository.Key, files)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return err
+	}
+
+	// write to a temporary file first, so we never end up with a partially
+	// written cache
+	tmp := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return err
+	}
+	c.files = files
+	c.seen = make(map[string]changeCacheEntry)
+	c.roots = nil
+	return nil
+}
+
+// addRoots records the paths Snapshot.Add is going to store.
+func (c *ChangeCache) addRoots(paths []string) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.roots = append(c.roots, paths...)
+}
+
+// lookup returns the chunks of the file at path, if it hasn't changed since
+// it got stored with the same options, and all of its chunks are still found
+// in the chunk-index. The caller must prevent concurrent changes of index.
+func (c *ChangeCache) lookup(path string, archive *Archive, opts StoreOptions, index *ChunkIndex) (changeCacheEntry, bool) {
+	if c == nil {
+		return changeCacheEntry{}, false
+	}
+	path, _ = filepath.Abs(path)
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	e, ok := c.files[path]
+	if !ok || e.Size != archive.Size || e.ModTime != archive.ModTime || e.Inode != archive.ino ||
+		e.Domain != opts.dedupDomain {
+		return e, false
+	}
+	prev := Archive{Chunks: e.Chunks, Encrypted: e.Encrypted, Compressed: e.Compressed}
+	if !prev.storedWith(opts) || !index.hasChunks(e.Chunks) {
+		return e, false
+	}
+
+	c.seen[path] = e
+	return e, true
+}
+
+// update remembers the chunks a file at path has been stored with. Files,
+// which may have changed while they were read, don't get remembered.
+func (c *ChangeCache) update(path string, archive *Archive, opts StoreOptions, readAt int64) {
+	if c == nil {
+		return
+	}
+	// the modification time only has a resolution of seconds, so changes
+	// made in the same second the file got read at might go unnoticed
+	if archive.ModTime >= readAt {
+		return
+	}
+	var size uint64
+	for _, chunk := range archive.Chunks {
+		size += uint64(chunk.OriginalSize)
+	}
+	if size != archive.Size {
+		// not all chunks have been stored
+		return
+	}
+
+	path, _ = filepath.Abs(path)
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.seen[path] = changeCacheEntry{
+		Size:       archive.Size,
+		ModTime:    archive.ModTime,
+		Inode:      archive.ino,
+		Chunks:     archive.Chunks,
+		Encrypted:  archive.Encrypted,
+		Compressed: archive.Compressed,
+		Domain:     opts.dedupDomain,
+	}
+}
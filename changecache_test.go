@@ -0,0 +1,138 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestChangeCache(t *testing.T) {
+	cache, err := ioutil.TempDir("", "knoxite.cache")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for cache: %s", err)
+	}
+	defer os.RemoveAll(cache)
+	os.Setenv(EnvCacheDir, cache)
+	defer os.Unsetenv(EnvCacheDir)
+
+	src, err := ioutil.TempDir("", "knoxite.source")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+	file := filepath.Join(src, "data")
+	mtime := time.Now().Add(-time.Hour)
+	// write changes the content of the test file in place, keeping its inode
+	write := func(content string) {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE, 0600)
+		if err != nil {
+			t.Fatalf("Failed opening test file: %s", err)
+		}
+		if _, err := f.WriteString(content); err != nil {
+			t.Fatalf("Failed writing test file: %s", err)
+		}
+		f.Close()
+		if err := os.Chtimes(file, mtime, mtime); err != nil {
+			t.Fatalf("Failed changing modification time: %s", err)
+		}
+	}
+	write("first content")
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, "this_is_a_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+
+	// store returns the hash of the chunk stored for the test file
+	store := func() string {
+		cc, err := OpenChangeCache(&r, "volume")
+		if err != nil {
+			t.Fatalf("Failed opening change cache: %s", err)
+		}
+		snapshot, err := NewSnapshot("test_snapshot")
+		if err != nil {
+			t.Fatalf("Failed creating snapshot: %s", err)
+		}
+		opts := StoreOptions{
+			CWD:         src,
+			Paths:       []string{src},
+			Encrypt:     EncryptionAES,
+			DataParts:   1,
+			ChangeCache: cc,
+		}
+		for p := range snapshot.Add(context.Background(), r, &index, opts) {
+			if p.Error != nil {
+				t.Fatalf("Failed adding to snapshot: %s", p.Error)
+			}
+		}
+		if err := cc.Save(&r); err != nil {
+			t.Fatalf("Failed saving change cache: %s", err)
+		}
+		return snapshot.Archives["data"].Chunks[0].Hash
+	}
+
+	first := store()
+	// the same size and modification time let the file appear unchanged,
+	// so its content doesn't get read again
+	write("other content")
+	if h := store(); h != first {
+		t.Errorf("Expected the chunks of the unchanged file to be reused, got %s instead of %s", h, first)
+	}
+
+	// chunks which got removed from the repository don't get reused
+	item := index.Chunks[first]
+	delete(index.Chunks, first)
+	second := store()
+	if second == first {
+		t.Error("Expected the file to be stored again after its chunk got removed")
+	}
+	index.Chunks[first] = item
+
+	mtime = mtime.Add(time.Minute)
+	write("first content")
+	if h := store(); h != first {
+		t.Errorf("Expected the changed file to be stored again, got chunk %s instead of %s", h, first)
+	}
+
+	// files, which don't exist anymore, get forgotten
+	if err := os.Remove(file); err != nil {
+		t.Fatalf("Failed removing test file: %s", err)
+	}
+	cc, err := OpenChangeCache(&r, "volume")
+	if err != nil {
+		t.Fatalf("Failed opening change cache: %s", err)
+	}
+	snapshot, err := NewSnapshot("test_snapshot")
+	if err != nil {
+		t.Fatalf("Failed creating snapshot: %s", err)
+	}
+	opts := StoreOptions{CWD: src, Paths: []string{src}, DataParts: 1, ChangeCache: cc}
+	for range snapshot.Add(context.Background(), r, &index, opts) {
+	}
+	if err := cc.Save(&r); err != nil {
+		t.Fatalf("Failed saving change cache: %s", err)
+	}
+	if len(cc.files) != 0 {
+		t.Errorf("Expected removed files to be forgotten, got %d files", len(cc.files))
+	}
+}
@@ -0,0 +1,111 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+// SaveCheckpoint writes all archives that have been stored in the snapshot so
+// far to path, so an interrupted store operation can be resumed later on. It
+// is safe to call this while items are being added to the snapshot.
+//
+// Checkpoints get encrypted with the repository's key, just like snapshots,
+// or the volume's key for snapshots of protected volumes.
+func (snapshot *Snapshot) SaveCheckpoint(path string, repository *Repository) error {
+	key := repository.Key
+	if snapshot.volumeKey != "" {
+		key = snapshot.volumeKey
+	}
+	snapshot.mut.Lock()
+	b, err := repository.encodeMetadata(CompressionLZMA, key, snapshot)
+	snapshot.mut.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	// write to a temporary file first, so we never end up with a partially
+	// written checkpoint
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// LoadCheckpoint loads a snapshot from a checkpoint written by SaveCheckpoint.
+// Adding the same paths to the returned snapshot again skips all archives
+// that have already been stored and haven't changed since.
+func LoadCheckpoint(path string, repository *Repository) (*Snapshot, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := Snapshot{
+		Archives: make(map[string]*Archive),
+	}
+	if _, err := repository.decodeWithDataKeys(b, &snapshot); err != nil {
+		return nil, err
+	}
+
+	// the stats get collected again while resuming
+	snapshot.Stats = Stats{}
+	return &snapshot, nil
+}
+
+// unchanged returns true if archive, which was stored before, still matches
+// the current item and storage options.
+func (archive *Archive) unchanged(current *Archive, opts StoreOptions) bool {
+	if archive.Type != current.Type ||
+		archive.Mode != current.Mode ||
+		archive.ModTime != current.ModTime ||
+		archive.Size != current.Size ||
+		archive.PointsTo != current.PointsTo ||
+		archive.LinkTo != current.LinkTo ||
+		archive.UID != current.UID ||
+		archive.GID != current.GID ||
+		archive.Attributes != current.Attributes ||
+		!reflect.DeepEqual(archive.XAttrs, current.XAttrs) ||
+		!reflect.DeepEqual(archive.Streams, current.Streams) {
+		return false
+	}
+
+	if archive.Type != File {
+		return true
+	}
+	return archive.storedWith(opts)
+}
+
+// storedWith returns true if the chunks of archive have been stored with the
+// compression, encryption and redundancy of opts.
+func (archive *Archive) storedWith(opts StoreOptions) bool {
+	if archive.Compressed != opts.Compress || archive.Encrypted != opts.Encrypt {
+		return false
+	}
+	dataParts := opts.DataParts
+	if opts.ParityParts == 0 {
+		dataParts = 1
+	}
+	for _, chunk := range archive.Chunks {
+		if chunk.Hole {
+			continue
+		}
+		if chunk.DataParts != dataParts || chunk.ParityParts != opts.ParityParts {
+			return false
+		}
+	}
+	return true
+}
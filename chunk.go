@@ -8,17 +8,64 @@
 package knoxite
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/restic/chunker"
 )
 
 const (
 	preferredChunkSize = 1 * (1 << 20) // 1 MiB
+	maxChunkSize       = 64 * (1 << 20)
 )
 
+// ChunkerSettings define how files get divided into chunks. Data only gets
+// deduplicated with data stored using the same settings.
+type ChunkerSettings struct {
+	// Fixed divides files into chunks of MaxSize, instead of finding chunk
+	// boundaries based on their content
+	Fixed   bool `json:"fixed,omitempty"`
+	MinSize uint `json:"min_size"`
+	MaxSize uint `json:"max_size"`
+}
+
+// DefaultChunkerSettings are used unless a repository specifies others.
+var DefaultChunkerSettings = ChunkerSettings{MinSize: chunker.MinSize, MaxSize: preferredChunkSize}
+
+// ErrInvalidChunkerSettings is returned for chunk sizes the chunker can't use.
+var ErrInvalidChunkerSettings = errors.New("Invalid chunker settings, chunks need to be between 64 KiB and 64 MiB")
+
+// Validate returns an error if the chunker can't use the settings.
+func (c ChunkerSettings) Validate() error {
+	if c.MaxSize < 64*1024 || c.MaxSize > maxChunkSize {
+		return ErrInvalidChunkerSettings
+	}
+	if !c.Fixed && (c.MinSize < 64*1024 || c.MinSize > c.MaxSize) {
+		return ErrInvalidChunkerSettings
+	}
+	return nil
+}
+
+func (c ChunkerSettings) String() string {
+	if c.Fixed {
+		return fmt.Sprintf("fixed %s", SizeToString(uint64(c.MaxSize)))
+	}
+	return fmt.Sprintf("content-defined %s - %s", SizeToString(uint64(c.MinSize)), SizeToString(uint64(c.MaxSize)))
+}
+
+// orDefault returns the default settings for zero settings.
+func (c ChunkerSettings) orDefault() ChunkerSettings {
+	if c == (ChunkerSettings{}) {
+		return DefaultChunkerSettings
+	}
+	return c
+}
+
 // Chunk stores an encrypted chunk alongside with its metadata.
 type Chunk struct {
 	Data          *[][]byte `json:"-"`
@@ -29,6 +76,12 @@ type Chunk struct {
 	DecryptedHash string    `json:"decrypted_hash"`
 	Hash          string    `json:"hash"`
 	Num           uint      `json:"num"`
+	// Hole marks chunks which only consist of zeros. They don't get stored,
+	// but recreated as holes of sparse files on restore
+	Hole bool `json:"hole,omitempty"`
+	// KeyID identifies the key the chunk has been encrypted with. Chunks
+	// stored by older versions of knoxite don't record it
+	KeyID string `json:"key_id,omitempty"`
 }
 
 // ChunkResult is used to transfer either a chunk or an error down the channel.
@@ -40,95 +93,132 @@ type ChunkResult struct {
 type inputChunk struct {
 	Data []byte
 	Num  uint
+	// Hole is the size of a hole of a sparse file
+	Hole int
 }
 
 func processChunk(password string, opts StoreOptions, jobs <-chan inputChunk, chunks chan<- ChunkResult, wg *sync.WaitGroup) {
 	pipe, _ := NewEncodingPipeline(opts.Compress, opts.Encrypt, password)
+	pipe = opts.Timings.pipeline(pipe, StageCompressing, StageEncrypting)
 
 	for j := range jobs {
 		// fmt.Println("\tWorker", id, "processing job", j.Num, len(j.Data))
 
-		b, err := pipe.Process(j.Data)
-		if err != nil {
-			chunks <- ChunkResult{Error: err}
+		if j.Hole > 0 || isZero(j.Data) {
+			chunks <- ChunkResult{Chunk: Chunk{
+				OriginalSize: j.Hole + len(j.Data),
+				Num:          j.Num,
+				Hole:         true,
+			}}
 			wg.Done()
 			continue
 		}
 
-		hashsum := Hash(b, HashHighway256)
-		orighashsum := Hash(j.Data, HashHighway256)
-
-		c := Chunk{
-			DataParts:     opts.DataParts,
-			ParityParts:   opts.ParityParts,
-			OriginalSize:  len(j.Data),
-			Size:          len(b),
-			DecryptedHash: orighashsum,
-			Hash:          hashsum,
-			Num:           j.Num,
-		}
+		c, err := encodeChunk(pipe, password, opts, j)
+		chunks <- ChunkResult{Chunk: c, Error: err}
+		wg.Done()
+	}
+}
 
-		if opts.ParityParts > 0 {
-			pars, err := redundantData(b, int(opts.DataParts), int(opts.ParityParts))
-			if err != nil {
-				chunks <- ChunkResult{Error: err}
-				wg.Done()
-				continue
-			}
-			c.Data = &pars
-		} else {
-			c.DataParts = 1
-			c.Data = &[][]byte{b}
+// encodeChunk compresses and encrypts the data of j with pipe and splits it
+// into parts. Unless opts.chunkHeaders is false, each part gets prefixed with
+// a header describing its format.
+func encodeChunk(pipe Pipeline, password string, opts StoreOptions, j inputChunk) (Chunk, error) {
+	b, err := pipe.Process(j.Data)
+	if err != nil {
+		return Chunk{}, err
+	}
+
+	hashsum := domainHash(b, opts.dedupDomain)
+	orighashsum := Hash(j.Data, HashHighway256)
+
+	c := Chunk{
+		DataParts:     opts.DataParts,
+		ParityParts:   opts.ParityParts,
+		OriginalSize:  len(j.Data),
+		Size:          len(b),
+		DecryptedHash: orighashsum,
+		Hash:          hashsum,
+		Num:           j.Num,
+	}
+	if opts.Encrypt == EncryptionAES || opts.Encrypt == EncryptionAESGCM {
+		c.KeyID = keyID(password)
+	}
+
+	if opts.ParityParts > 0 {
+		pars, err := redundantData(b, int(opts.DataParts), int(opts.ParityParts))
+		if err != nil {
+			return Chunk{}, err
 		}
+		c.Data = &pars
+	} else {
+		c.DataParts = 1
+		c.Data = &[][]byte{b}
+	}
 
-		chunks <- ChunkResult{Chunk: c}
-		wg.Done()
+	if !opts.chunkHeaders {
+		return c, nil
 	}
+	// prefix every part with a header describing its format
+	for i, data := range *c.Data {
+		header, _ := newChunkHeader(c, uint(i), opts).MarshalBinary()
+		(*c.Data)[i] = append(header, data...)
+	}
+
+	return c, nil
 }
 
-// chunkFile divides filename into chunks of 1MiB each.
-func chunkFile(filename string, password string, opts StoreOptions) (<-chan ChunkResult, error) {
+// chunkFile divides the data read from file into chunks, as configured by
+// opts.Chunker. Holes of
+// sparse files and runs of zeros become hole chunks. It closes file once all
+// data has been read.
+func chunkFile(ctx context.Context, file io.ReadCloser, password string, opts StoreOptions) (<-chan ChunkResult, error) {
 	c := make(chan ChunkResult)
 
-	file, err := os.Open(filename)
-	if err != nil {
-		return c, err
-	}
-
 	wg := &sync.WaitGroup{}
 	jobs := make(chan inputChunk)
-	for w := 1; w <= 4; w++ {
+	for w := uint(1); w <= opts.Concurrency; w++ {
 		go processChunk(password, opts, jobs, c, wg)
 	}
 
 	wg.Add(1)
 	go func() {
-		chunker := chunker.NewWithBoundaries(file, chunker.Pol(0x3DA3358B4DC173), chunker.MinSize, preferredChunkSize)
+		defer wg.Done()
+		defer file.Close()
 
 		i := uint(0)
-		for {
-			buf := make([]byte, preferredChunkSize)
-			chunk, err := chunker.Next(buf)
-			if err == io.EOF {
-				wg.Done()
-				break
+		send := func(j inputChunk) bool {
+			if ctx.Err() != nil {
+				return false
 			}
-			if err != nil {
-				c <- ChunkResult{Error: err}
-				wg.Done()
-				break
-			}
-
-			wg.Add(1)
-			j := inputChunk{
-				Data: chunk.Data,
-				Num:  i,
-			}
-
+			j.Num = i
 			i++
+			wg.Add(1)
 			jobs <- j
+			return true
+		}
+
+		settings := opts.Chunker.orDefault()
+		regions := sparseRegions(file)
+		if regions == nil {
+			_ = chunkReader(file, settings, opts.Timings, send, c)
+			return
+		}
+
+		f := file.(*os.File)
+		offset := int64(0)
+		for _, region := range regions {
+			if !sendHole(region.offset-offset, send) {
+				return
+			}
+			if !chunkReader(io.NewSectionReader(f, region.offset, region.length), settings, opts.Timings, send, c) {
+				return
+			}
+			offset = region.offset + region.length
+		}
+		if fi, err := f.Stat(); err == nil {
+			sendHole(fi.Size()-offset, send)
 		}
-		_ = file.Close()
 	}()
 
 	go func() {
@@ -139,3 +229,70 @@ func chunkFile(filename string, password string, opts StoreOptions) (<-chan Chun
 
 	return c, nil
 }
+
+// chunkReader divides the data read from r into chunks and passes them to
+// send. It returns false if chunking should stop.
+func chunkReader(r io.Reader, settings ChunkerSettings, timings *Timings, send func(inputChunk) bool, c chan<- ChunkResult) bool {
+	tr := &timedReader{Reader: r, timings: timings}
+	if settings.Fixed {
+		return chunkFixed(tr, settings, send, c)
+	}
+
+	chunker := chunker.NewWithBoundaries(tr, chunker.Pol(0x3DA3358B4DC173), settings.MinSize, settings.MaxSize)
+	for {
+		buf := make([]byte, settings.MaxSize)
+		start, read := time.Now(), tr.total
+		chunk, err := chunker.Next(buf)
+		// the chunker reads on demand, which doesn't count as chunking
+		timings.Add(StageChunking, time.Since(start)-(tr.total-read))
+		if err == io.EOF {
+			return true
+		}
+		if err != nil {
+			c <- ChunkResult{Error: err}
+			return false
+		}
+
+		if !send(inputChunk{Data: chunk.Data}) {
+			return false
+		}
+	}
+}
+
+// chunkFixed divides the data read from r into chunks of settings.MaxSize.
+func chunkFixed(r io.Reader, settings ChunkerSettings, send func(inputChunk) bool, c chan<- ChunkResult) bool {
+	for {
+		buf := make([]byte, settings.MaxSize)
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			return true
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			c <- ChunkResult{Error: err}
+			return false
+		}
+
+		if !send(inputChunk{Data: buf[:n]}) {
+			return false
+		}
+		if err == io.ErrUnexpectedEOF {
+			return true
+		}
+	}
+}
+
+// sendHole passes a hole of size bytes to send, divided into chunks of at most
+// maxHoleSize.
+func sendHole(size int64, send func(inputChunk) bool) bool {
+	for size > 0 {
+		n := size
+		if n > maxHoleSize {
+			n = maxHoleSize
+		}
+		if !send(inputChunk{Hole: int(n)}) {
+			return false
+		}
+		size -= n
+	}
+	return true
+}
@@ -0,0 +1,128 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	// ChunkFormatVersion is the version of the chunk format written by this
+	// version of knoxite.
+	ChunkFormatVersion = 1
+
+	// chunkHeaderSize is the size of an encoded ChunkHeader: the magic, the
+	// format version, compression, encryption, part, data & parity parts and
+	// the size of the encoded chunk.
+	chunkHeaderSize = 4 + 1 + 2 + 2 + 2 + 2 + 2 + 4
+
+	// chunkHeadersVersion is the first repository version, whose chunk parts
+	// start with a ChunkHeader. Older versions of knoxite can't decode them.
+	chunkHeadersVersion = 5
+)
+
+var chunkMagic = []byte("KNXC")
+
+// Error declarations.
+var (
+	ErrUnsupportedChunkFormat = errors.New("Unsupported chunk format, please upgrade knoxite")
+)
+
+// ChunkHeader precedes every part of a chunk stored on a backend. It describes
+// how the chunk has been encoded, so a stored part can be interpreted without
+// the snapshot or chunk-index referencing it.
+//
+// Chunks stored by older versions of knoxite, or in repositories older than
+// chunkHeadersVersion, don't have a header.
+type ChunkHeader struct {
+	Version     uint8  `json:"version"`
+	Compression uint16 `json:"compression"`
+	Encryption  uint16 `json:"encryption"`
+	Part        uint16 `json:"part"`
+	DataParts   uint16 `json:"data_parts"`
+	ParityParts uint16 `json:"parity_parts"`
+	// Size is the size of the encoded chunk, before it got split into parts
+	Size uint32 `json:"size"`
+}
+
+// newChunkHeader returns the header for a part of chunk.
+func newChunkHeader(chunk Chunk, part uint, opts StoreOptions) ChunkHeader {
+	return ChunkHeader{
+		Version:     ChunkFormatVersion,
+		Compression: opts.Compress,
+		Encryption:  opts.Encrypt,
+		Part:        uint16(part),
+		DataParts:   uint16(chunk.DataParts),
+		ParityParts: uint16(chunk.ParityParts),
+		Size:        uint32(chunk.Size),
+	}
+}
+
+// chunkHeaders returns true if chunk parts get stored with a ChunkHeader in
+// the repository. Repositories of older versions keep storing them without
+// one, so older versions of knoxite can still read them.
+func (r *Repository) chunkHeaders() bool {
+	return r.Version >= chunkHeadersVersion
+}
+
+// MarshalBinary encodes the header.
+func (h ChunkHeader) MarshalBinary() ([]byte, error) {
+	b := make([]byte, chunkHeaderSize)
+	copy(b, chunkMagic)
+	b[4] = h.Version
+	binary.BigEndian.PutUint16(b[5:], h.Compression)
+	binary.BigEndian.PutUint16(b[7:], h.Encryption)
+	binary.BigEndian.PutUint16(b[9:], h.Part)
+	binary.BigEndian.PutUint16(b[11:], h.DataParts)
+	binary.BigEndian.PutUint16(b[13:], h.ParityParts)
+	binary.BigEndian.PutUint32(b[15:], h.Size)
+	return b, nil
+}
+
+// String returns a human-readable description of the header.
+func (h ChunkHeader) String() string {
+	return fmt.Sprintf("format %d, compression %d, encryption %d, part %d of %d+%d, %d bytes",
+		h.Version, h.Compression, h.Encryption, h.Part+1, h.DataParts, h.ParityParts, h.Size)
+}
+
+// SplitChunkHeader separates the header of a stored chunk part from its data.
+// If the part has been stored without a header, the returned header is nil
+// and data is returned unchanged.
+func SplitChunkHeader(data []byte) (*ChunkHeader, []byte, error) {
+	if len(data) < chunkHeaderSize || !bytes.Equal(data[:4], chunkMagic) {
+		return nil, data, nil
+	}
+
+	h := ChunkHeader{
+		Version:     data[4],
+		Compression: binary.BigEndian.Uint16(data[5:]),
+		Encryption:  binary.BigEndian.Uint16(data[7:]),
+		Part:        binary.BigEndian.Uint16(data[9:]),
+		DataParts:   binary.BigEndian.Uint16(data[11:]),
+		ParityParts: binary.BigEndian.Uint16(data[13:]),
+		Size:        binary.BigEndian.Uint32(data[15:]),
+	}
+	if h.Version > ChunkFormatVersion {
+		return nil, data, ErrUnsupportedChunkFormat
+	}
+
+	return &h, data[chunkHeaderSize:], nil
+}
+
+// matches returns true if the header belongs to a part of chunk. Legacy chunks
+// could start with the magic by pure chance, so headers only get trusted when
+// they match the chunk's metadata.
+func (h ChunkHeader) matches(chunk Chunk, part uint) bool {
+	return h.Part == uint16(part) &&
+		h.DataParts == uint16(chunk.DataParts) &&
+		h.ParityParts == uint16(chunk.ParityParts) &&
+		h.Size == uint32(chunk.Size)
+}
@@ -0,0 +1,235 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestChunkHeader(t *testing.T) {
+	h := ChunkHeader{
+		Version:     ChunkFormatVersion,
+		Compression: CompressionZstd,
+		Encryption:  EncryptionAES,
+		Part:        2,
+		DataParts:   2,
+		ParityParts: 1,
+		Size:        123456,
+	}
+	payload := []byte("encrypted chunk data")
+
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Failed encoding chunk header: %s", err)
+	}
+	header, data, err := SplitChunkHeader(append(b, payload...))
+	if err != nil {
+		t.Fatalf("Failed decoding chunk header: %s", err)
+	}
+	if header == nil || !reflect.DeepEqual(*header, h) {
+		t.Errorf("Expected header %v, got %v", h, header)
+	}
+	if !bytes.Equal(data, payload) {
+		t.Errorf("Expected data %q, got %q", payload, data)
+	}
+
+	// chunks without a header
+	header, data, err = SplitChunkHeader(payload)
+	if err != nil || header != nil || !bytes.Equal(data, payload) {
+		t.Errorf("Expected legacy chunk to be returned unchanged, got %v %q %v", header, data, err)
+	}
+
+	// chunks written by future versions
+	h.Version = ChunkFormatVersion + 1
+	b, _ = h.MarshalBinary()
+	if _, _, err = SplitChunkHeader(append(b, payload...)); err != ErrUnsupportedChunkFormat {
+		t.Errorf("Expected %v, got %v", ErrUnsupportedChunkFormat, err)
+	}
+}
+
+func TestLegacyChunks(t *testing.T) {
+	testPassword := "this_is_a_password"
+
+	src, err := ioutil.TempDir("", "knoxite.source")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	content := make([]byte, 3*preferredChunkSize)
+	_, _ = rand.Read(content)
+	if err := ioutil.WriteFile(filepath.Join(src, "data"), content, 0600); err != nil {
+		t.Fatalf("Failed writing test file: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	snapshot, err := NewSnapshot("test_snapshot")
+	if err != nil {
+		t.Fatalf("Failed creating snapshot: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+
+	opts := StoreOptions{
+		CWD:         src,
+		Paths:       []string{src},
+		Compress:    CompressionGZip,
+		Encrypt:     EncryptionAES,
+		DataParts:   2,
+		ParityParts: 1,
+	}
+	for p := range snapshot.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Fatalf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+
+	// strip the headers, as if the chunks had been stored by an older
+	// version of knoxite
+	stripped := 0
+	err = filepath.Walk(filepath.Join(dir, chunksDirname), func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || fi.Name() == ChunkIndexFilename {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		header, data, err := SplitChunkHeader(b)
+		if err != nil {
+			return err
+		}
+		if header == nil {
+			t.Errorf("Chunk %s has been stored without a header", path)
+			return nil
+		}
+		if header.Compression != CompressionGZip || header.Encryption != EncryptionAES ||
+			header.DataParts != 2 || header.ParityParts != 1 {
+			t.Errorf("Unexpected header for chunk %s: %s", path, header)
+		}
+		stripped++
+		return ioutil.WriteFile(path, data, 0600)
+	})
+	if err != nil {
+		t.Fatalf("Failed stripping chunk headers: %s", err)
+	}
+	if stripped == 0 {
+		t.Fatal("No chunks have been stored")
+	}
+
+	b, _, err := DecodeArchiveData(context.Background(), r, *snapshot.Archives["data"])
+	if err != nil {
+		t.Fatalf("Failed reading legacy chunks: %s", err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Error("Data read from legacy chunks doesn't match")
+	}
+}
+
+func TestChunksOfLegacyRepositories(t *testing.T) {
+	testPassword := "this_is_a_password"
+
+	src, err := ioutil.TempDir("", "knoxite.source")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	content := make([]byte, 3*preferredChunkSize)
+	_, _ = rand.Read(content)
+	if err := ioutil.WriteFile(filepath.Join(src, "data"), content, 0600); err != nil {
+		t.Fatalf("Failed writing test file: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a repository written by a version of knoxite, which can't read chunk
+	// headers
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	r.Version, r.ReaderVersion = 4, 4
+	snapshot, err := NewSnapshot("test_snapshot")
+	if err != nil {
+		t.Fatalf("Failed creating snapshot: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+
+	opts := StoreOptions{
+		CWD:         src,
+		Paths:       []string{src},
+		Compress:    CompressionGZip,
+		Encrypt:     EncryptionAES,
+		DataParts:   2,
+		ParityParts: 1,
+	}
+	for p := range snapshot.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Fatalf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+
+	stored := 0
+	err = filepath.Walk(filepath.Join(dir, chunksDirname), func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() || fi.Name() == ChunkIndexFilename {
+			return err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if bytes.HasPrefix(b, chunkMagic) {
+			t.Errorf("Chunk %s has been stored with a header", path)
+		}
+		stored++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Failed reading chunks: %s", err)
+	}
+	if stored == 0 {
+		t.Fatal("No chunks have been stored")
+	}
+	if r.Version != 4 || r.ReaderVersion != 4 {
+		t.Errorf("Expected the repository to stay at version 4, got %d/%d", r.Version, r.ReaderVersion)
+	}
+
+	b, _, err := DecodeArchiveData(context.Background(), r, *snapshot.Archives["data"])
+	if err != nil {
+		t.Fatalf("Failed reading legacy chunks: %s", err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Error("Data read from legacy chunks doesn't match")
+	}
+}
@@ -8,7 +8,14 @@
 package knoxite
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"sort"
+)
+
+// Error declarations.
+var (
+	ErrDryRunUnsupported = errors.New("The chunk-index is maintained by the storage backend, a dry run is not supported")
 )
 
 // A ChunkIndexItem links a chunk with one or many snapshots.
@@ -18,11 +25,37 @@ type ChunkIndexItem struct {
 	ParityParts uint     `json:"parity_parts"`
 	Size        int      `json:"size"`
 	Snapshots   []string `json:"snapshots"`
+	// Tier is the storage tier the chunk has been moved to, unless it's
+	// stored in the default tier
+	Tier string `json:"tier,omitempty"`
 }
 
 // A ChunkIndex links chunks with snapshots.
 type ChunkIndex struct {
 	Chunks map[string]*ChunkIndexItem `json:"chunks"`
+
+	// indexing contains the backends maintaining the chunk-index themselves.
+	// Chunks then only contains the references added since opening the index
+	indexing []IndexingBackend
+	// removed contains the snapshots removed since opening the index
+	removed []string
+
+	// packs are the packs the index has been loaded from, if it's stored in
+	// packs
+	packs []string
+	// changes and deleted contain the chunks added, changed or deleted since
+	// the index got loaded. They get saved in a new pack, or merged with the
+	// single chunk-index other clients may have saved in the meantime
+	changes map[string]*ChunkIndexItem
+	deleted map[string]bool
+	// rewrite merges all packs into a single one on the next save
+	rewrite bool
+	// rebuild replaces the stored index with this one on the next save,
+	// instead of adding its changes to it. It's set once the index has been
+	// rebuilt from the snapshots
+	rebuild bool
+	// key is the data key of the repository when the index got loaded
+	key string
 }
 
 // OpenChunkIndex opens an existing chunkindex.
@@ -31,67 +64,201 @@ func OpenChunkIndex(repository *Repository) (ChunkIndex, error) {
 		Chunks: make(map[string]*ChunkIndexItem),
 	}
 
+	// there's no need to load the chunk-index, if the backends maintain it
+	if ibs := repository.backend.indexingBackends(); len(ibs) > 0 {
+		index.indexing = ibs
+		return index, nil
+	}
+
+	packed := repository.chunkIndexPacks()
 	b, err := repository.backend.LoadChunkIndex()
 	if err != nil {
 		if !repository.IsEmpty() {
-			fmt.Println("Chunk-Index is empty, re-indexing all snapshots...")
+			log.Print("Chunk-Index is empty, re-indexing all snapshots...")
 			err = index.reindex(repository)
 			if err != nil {
 				return index, err
 			}
-			fmt.Println("Successfully re-indexed snapshots.")
+			log.Print("Successfully re-indexed snapshots.")
 		}
 
+		index.trackChanges()
+		index.rebuild = true
+		index.key = repository.Key
 		err = index.Save(repository)
 		return index, err
 	}
 
-	pipe, err := NewDecodingPipeline(CompressionLZMA, EncryptionAES, repository.Key)
-	if err != nil {
-		return index, err
+	if manifest, ok := parseChunkIndexManifest(b); ok {
+		err = index.loadPacks(repository, manifest)
+	} else {
+		_, err = repository.decodeWithDataKeys(b, &index)
+		// the single chunk-index gets replaced by packs when saving it
+		index.rewrite = packed
 	}
-	err = pipe.Decode(b, &index)
+	index.trackChanges()
+	index.key = repository.Key
 	return index, err
 }
 
 // Save writes a chunk-index.
 func (index *ChunkIndex) Save(repository *Repository) error {
-	pipe, err := NewEncodingPipeline(CompressionLZMA, EncryptionAES, repository.Key)
-	if err != nil {
+	if repository.backend.ReadOnly {
+		return ErrRepositoryReadOnly
+	}
+	if repository.backend.AppendOnly && len(index.removed) > 0 {
+		return ErrAppendOnly
+	}
+	if err := repository.checkLock(); err != nil {
 		return err
 	}
-	b, err := pipe.Encode(index)
+	if len(index.indexing) > 0 {
+		return index.saveReferences()
+	}
+	if repository.chunkIndexPacks() {
+		return index.savePacks(repository)
+	}
+
+	if !index.rebuild {
+		// other clients may have saved the index in the meantime
+		if b, err := repository.backend.LoadChunkIndex(); err == nil {
+			stored := ChunkIndex{Chunks: make(map[string]*ChunkIndexItem)}
+			if _, err := repository.decodeWithDataKeys(b, &stored); err == nil {
+				index.merge(stored)
+			}
+		}
+	}
+	b, err := repository.encodeMetadata(CompressionLZMA, repository.Key, index)
 	if err != nil {
 		return err
 	}
-	return repository.backend.SaveChunkIndex(b)
+	if err := repository.backend.SaveChunkIndex(b); err != nil {
+		return err
+	}
+	index.removed = nil
+	index.rebuild = false
+	index.trackChanges()
+	return nil
+}
+
+// Unreferenced returns the chunks Pack would delete, sorted by their hashes,
+// and the storage space deleting them would free. It fails with
+// ErrDryRunUnsupported if a backend maintains the chunk-index.
+func (index *ChunkIndex) Unreferenced(repository *Repository) ([]ChunkIndexItem, uint64, error) {
+	if len(index.indexing) > 0 {
+		return nil, 0, ErrDryRunUnsupported
+	}
+	chunks := index.Chunks
+	if repository.AppendOnly {
+		// Pack only trusts the references of the snapshots themselves
+		reindexed := ChunkIndex{Chunks: make(map[string]*ChunkIndexItem)}
+		if err := reindexed.reindex(repository); err != nil {
+			return nil, 0, err
+		}
+		chunks = reindexed.Chunks
+	}
+
+	var unreferenced []ChunkIndexItem
+	var size uint64
+	for _, chunk := range chunks {
+		if len(chunk.Snapshots) > 0 {
+			continue
+		}
+		unreferenced = append(unreferenced, *chunk)
+		size += uint64(chunk.Size) * uint64(chunk.DataParts+chunk.ParityParts)
+	}
+	sort.Slice(unreferenced, func(i, j int) bool {
+		return unreferenced[i].Hash < unreferenced[j].Hash
+	})
+	return unreferenced, size, nil
 }
 
 // Pack deletes unreferenced chunks and removes them from the index.
-func (index *ChunkIndex) Pack(repository *Repository) (freedSize uint64, err error) {
-	chunks := make(map[string]*ChunkIndexItem)
+// Chunks that have been deleted before ctx got canceled or an error occurred
+// are removed from the index nonetheless.
+func (index *ChunkIndex) Pack(ctx context.Context, repository *Repository) (freedSize uint64, err error) {
+	if repository.backend.ReadOnly {
+		return 0, ErrRepositoryReadOnly
+	}
+	if repository.backend.AppendOnly {
+		return 0, ErrAppendOnly
+	}
+	if len(index.indexing) > 0 {
+		// the backends need to know about removed snapshots before packing
+		if err = index.saveReferences(); err != nil {
+			return
+		}
+		for _, ib := range index.indexing {
+			var n uint64
+			n, err = ib.PackChunks(ctx)
+			freedSize += n
+			if err != nil {
+				return
+			}
+		}
+		return
+	}
+	if repository.AppendOnly {
+		// the chunk-index may have been saved by clients, which can't be
+		// trusted to keep the references of all snapshots
+		index.Chunks = make(map[string]*ChunkIndexItem)
+		if err = index.reindex(repository); err != nil {
+			return
+		}
+		index.rebuild = true
+	}
 
-	for _, chunk := range index.Chunks {
+	for hash, chunk := range index.Chunks {
 		// fmt.Printf("Chunk %s referenced in Snapshots %+v\n", chunk.Hash, chunk.Snapshots)
-		if len(chunk.Snapshots) == 0 {
-			fmt.Printf("Chunk %s is no longer referenced by any snapshot. Deleting!\n", chunk.Hash)
-
-			for i := uint(0); i < chunk.DataParts+chunk.ParityParts; i++ {
-				err = repository.backend.DeleteChunk(chunk.Hash, i, chunk.DataParts)
-				if err != nil {
-					return
-				}
-				freedSize += uint64(chunk.Size)
+		if len(chunk.Snapshots) > 0 {
+			continue
+		}
+
+		log.Infof("Chunk %s is no longer referenced by any snapshot. Deleting!", chunk.Hash)
+		for i := uint(0); i < chunk.DataParts+chunk.ParityParts; i++ {
+			err = repository.backend.DeleteChunk(ctx, chunk.Hash, i, chunk.DataParts)
+			if err != nil {
+				return
 			}
-		} else {
-			chunks[chunk.Hash] = chunk
+			freedSize += uint64(chunk.Size)
 		}
+
+		index.deleteChunk(hash)
 	}
 
-	index.Chunks = chunks
 	return
 }
 
+// saveReferences pushes the chunk references changed since opening the index
+// to the backends maintaining it.
+func (index *ChunkIndex) saveReferences() error {
+	refs := make(map[string][]ChunkIndexItem)
+	for _, chunk := range index.Chunks {
+		item := *chunk
+		item.Snapshots = nil
+		for _, snapshot := range chunk.Snapshots {
+			refs[snapshot] = append(refs[snapshot], item)
+		}
+	}
+
+	for _, ib := range index.indexing {
+		for _, snapshot := range index.removed {
+			if err := ib.RemoveChunkReferences(snapshot); err != nil {
+				return err
+			}
+		}
+		for snapshot, chunks := range refs {
+			if err := ib.AddChunkReferences(snapshot, chunks); err != nil {
+				return err
+			}
+		}
+	}
+
+	index.Chunks = make(map[string]*ChunkIndexItem)
+	index.removed = nil
+	return nil
+}
+
 func (index *ChunkIndex) reindex(repository *Repository) error {
 	for _, vol := range repository.Volumes {
 		for _, snapshotID := range vol.Snapshots {
@@ -112,32 +279,66 @@ func (index *ChunkIndex) reindex(repository *Repository) error {
 // AddArchive updates chunk-index with the new chunks.
 func (index *ChunkIndex) AddArchive(archive *Archive, snapshot string) {
 	for _, chunk := range archive.Chunks {
+		if chunk.Hole {
+			continue
+		}
 		c, ok := index.Chunks[chunk.Hash]
 		if ok {
 			c.Snapshots = append(c.Snapshots, snapshot)
 		} else {
-			chunkItem := ChunkIndexItem{
+			c = &ChunkIndexItem{
 				Hash:        chunk.Hash,
 				DataParts:   chunk.DataParts,
 				ParityParts: chunk.ParityParts,
 				Size:        chunk.Size,
 				Snapshots:   []string{snapshot},
 			}
-			index.Chunks[chunk.Hash] = &chunkItem
+			index.Chunks[chunk.Hash] = c
+		}
+		if change := index.changed(c); change != nil {
+			change.Snapshots = append(change.Snapshots, snapshot)
+		}
+	}
+}
+
+// hasChunks returns true if all chunks, except holes, are found in the
+// chunk-index. They may have been removed by repo pack since they got stored.
+func (index *ChunkIndex) hasChunks(chunks []Chunk) bool {
+	for _, chunk := range chunks {
+		if chunk.Hole {
+			continue
+		}
+		item, ok := index.Chunks[chunk.Hash]
+		if !ok || item.Size != chunk.Size {
+			return false
 		}
 	}
+	return true
 }
 
 // RemoveSnapshot removes all references to snapshot from the chunk-index.
 func (index *ChunkIndex) RemoveSnapshot(snapshot string) {
+	index.removed = append(index.removed, snapshot)
+	index.removeReferences(snapshot)
+	for _, change := range index.changes {
+		change.Snapshots = withoutSnapshot(change.Snapshots, snapshot)
+	}
+}
+
+// removeReferences removes all references to snapshot from the chunks.
+func (index *ChunkIndex) removeReferences(snapshot string) {
 	for _, chunk := range index.Chunks {
-		snapshots := []string{}
-		for _, s := range chunk.Snapshots {
-			if s != snapshot {
-				snapshots = append(snapshots, s)
-			}
-		}
+		chunk.Snapshots = withoutSnapshot(chunk.Snapshots, snapshot)
+	}
+}
 
-		chunk.Snapshots = snapshots
+// withoutSnapshot returns the snapshots except for snapshot.
+func withoutSnapshot(snapshots []string, snapshot string) []string {
+	s := []string{}
+	for _, id := range snapshots {
+		if id != snapshot {
+			s = append(s, id)
+		}
 	}
+	return s
 }
@@ -8,8 +8,12 @@
 package knoxite
 
 import (
+	"context"
+	"errors"
+	"io"
 	"io/ioutil"
 	"os"
+	"sync"
 	"testing"
 )
 
@@ -42,7 +46,7 @@ func TestChunkIndexReindex(t *testing.T) {
 		ParityParts: 0,
 	}
 
-	progress := snapshot.Add(r, &index, opts)
+	progress := snapshot.Add(context.Background(), r, &index, opts)
 	for p := range progress {
 		if p.Error != nil {
 			t.Errorf("Failed adding to snapshot: %s", p.Error)
@@ -102,7 +106,7 @@ func TestChunkIndexPack(t *testing.T) {
 		ParityParts: 0,
 	}
 
-	progress := snapshot.Add(r, &index, opts)
+	progress := snapshot.Add(context.Background(), r, &index, opts)
 	for p := range progress {
 		if p.Error != nil {
 			t.Errorf("Failed adding to snapshot: %s", p.Error)
@@ -118,8 +122,150 @@ func TestChunkIndexPack(t *testing.T) {
 	}
 	index.RemoveSnapshot(snapshot.ID)
 
-	_, err = index.Pack(&r)
+	unreferenced, size, err := index.Unreferenced(&r)
+	if err != nil {
+		t.Fatalf("Failed finding unreferenced chunks: %s", err)
+	}
+	if len(unreferenced) != len(index.Chunks) || size == 0 {
+		t.Errorf("Expected all %d chunks to be unreferenced, got %d of %d bytes", len(index.Chunks), len(unreferenced), size)
+	}
+
+	freed, err := index.Pack(context.Background(), &r)
 	if err != nil {
 		t.Errorf("Packing chunk index failed: %s", err)
 	}
+	if freed != size {
+		t.Errorf("Expected packing to free %d bytes, got %d", size, freed)
+	}
+	if unreferenced, _, _ := index.Unreferenced(&r); len(unreferenced) != 0 {
+		t.Errorf("Expected no unreferenced chunks after packing, got %d", len(unreferenced))
+	}
+}
+
+// indexingBackend maintains the chunk-index on top of another backend.
+type indexingBackend struct {
+	Backend
+
+	mut        sync.Mutex
+	stored     map[string]bool
+	uploads    int
+	references map[string][]ChunkIndexItem
+	packed     bool
+}
+
+func (b *indexingBackend) StoreChunk(ctx context.Context, shasum string, part, totalParts uint, data io.Reader, size uint64) (uint64, error) {
+	b.mut.Lock()
+	b.stored[shasum] = true
+	b.uploads++
+	b.mut.Unlock()
+	return b.Backend.StoreChunk(ctx, shasum, part, totalParts, data, size)
+}
+
+func (b *indexingBackend) LoadChunkIndex() ([]byte, error) {
+	return nil, errors.New("chunk-index should not be loaded")
+}
+
+func (b *indexingBackend) SaveChunkIndex(data []byte) error {
+	return errors.New("chunk-index should not be saved")
+}
+
+func (b *indexingBackend) HasChunk(ctx context.Context, shasum string, part, totalParts uint) (bool, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.stored[shasum], nil
+}
+
+func (b *indexingBackend) AddChunkReferences(snapshot string, chunks []ChunkIndexItem) error {
+	b.references[snapshot] = append(b.references[snapshot], chunks...)
+	return nil
+}
+
+func (b *indexingBackend) RemoveChunkReferences(snapshot string) error {
+	delete(b.references, snapshot)
+	return nil
+}
+
+func (b *indexingBackend) PackChunks(ctx context.Context) (uint64, error) {
+	b.packed = true
+	return 0, nil
+}
+
+func TestChunkIndexIndexingBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, "this_is_a_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	ib := &indexingBackend{
+		Backend:    *r.backend.Backends[0],
+		stored:     make(map[string]bool),
+		references: make(map[string][]ChunkIndexItem),
+	}
+	var be Backend = ib
+	r.backend.Backends = []*Backend{&be}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed getting working dir: %s", err)
+	}
+	opts := StoreOptions{
+		CWD:       wd,
+		Paths:     []string{"snapshot.go"},
+		Encrypt:   EncryptionAES,
+		DataParts: 1,
+	}
+
+	var snapshots []*Snapshot
+	for i := 0; i < 2; i++ {
+		index, err := OpenChunkIndex(&r)
+		if err != nil {
+			t.Fatalf("Failed opening chunk-index: %s", err)
+		}
+
+		snapshot, _ := NewSnapshot("test_snapshot")
+		for p := range snapshot.Add(context.Background(), r, &index, opts) {
+			if p.Error != nil {
+				t.Fatalf("Failed adding to snapshot: %s", p.Error)
+			}
+		}
+		if err := index.Save(&r); err != nil {
+			t.Fatalf("Failed saving chunk-index: %s", err)
+		}
+		if len(ib.references[snapshot.ID]) == 0 {
+			t.Errorf("Expected backend to know the chunks of snapshot %s", snapshot.ID)
+		}
+		snapshots = append(snapshots, snapshot)
+
+		// unchanged chunks must not be uploaded again
+		uploads := ib.uploads
+		if i == 0 && uploads == 0 {
+			t.Errorf("Expected chunks to be uploaded")
+		}
+		if i == 1 && uploads != len(ib.stored) {
+			t.Errorf("Expected no chunks to be uploaded again, got %d uploads of %d chunks", uploads, len(ib.stored))
+		}
+	}
+
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	index.RemoveSnapshot(snapshots[0].ID)
+	if _, err := index.Pack(context.Background(), &r); err != nil {
+		t.Fatalf("Packing chunk-index failed: %s", err)
+	}
+	if _, ok := ib.references[snapshots[0].ID]; ok {
+		t.Errorf("Expected references of removed snapshot to be dropped")
+	}
+	if _, ok := ib.references[snapshots[1].ID]; !ok {
+		t.Errorf("Expected references of remaining snapshot to be kept")
+	}
+	if !ib.packed {
+		t.Errorf("Expected backend to pack its chunks")
+	}
 }
@@ -0,0 +1,356 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+// chunkIndexPacksVersion is the first repository version, which may store
+// its chunk-index in packs. Older versions of knoxite only read the single
+// chunk-index, which then only lists the packs.
+const chunkIndexPacksVersion = 12
+
+// maxChunkIndexPacks is the amount of packs, after which saving the
+// chunk-index merges them into a single one again.
+const maxChunkIndexPacks = 64
+
+// chunkIndexManifest lists the packs of a chunk-index, in the order they need
+// to be applied in. It doesn't contain any information about the stored data,
+// so backends can copy the packs it lists without knowing the repository's
+// key.
+type chunkIndexManifest struct {
+	Packs []string `json:"packs"`
+}
+
+// chunkIndexPack contains the changes made to a chunk-index while it was
+// open.
+type chunkIndexPack struct {
+	// RemovedSnapshots don't reference any chunks anymore
+	RemovedSnapshots []string `json:"removed_snapshots,omitempty"`
+	// Chunks have been added or changed. Their Snapshots are the references
+	// added to them
+	Chunks []ChunkIndexItem `json:"chunks,omitempty"`
+	// Deleted contains the hashes of the chunks, which have been deleted
+	Deleted []string `json:"deleted,omitempty"`
+}
+
+// parseChunkIndexManifest returns the manifest stored as the chunk-index, if
+// it isn't a single, encrypted chunk-index.
+func parseChunkIndexManifest(b []byte) (chunkIndexManifest, bool) {
+	var manifest chunkIndexManifest
+	if !bytes.HasPrefix(b, []byte("{")) {
+		return manifest, false
+	}
+	err := json.Unmarshal(b, &manifest)
+	return manifest, err == nil
+}
+
+// chunkIndexPacks returns true if the chunk-index of the repository gets
+// stored in packs.
+func (r *Repository) chunkIndexPacks() bool {
+	return r.ReaderVersion >= chunkIndexPacksVersion && r.backend.chunkIndexPacks()
+}
+
+// trackChanges starts recording the changes made to the chunk-index, so they
+// can be saved in a pack, or merged with the stored index.
+func (index *ChunkIndex) trackChanges() {
+	index.changes = make(map[string]*ChunkIndexItem)
+	index.deleted = make(map[string]bool)
+}
+
+// changed records that chunk has been added or changed, and returns its
+// change. Its Snapshots are the references added since the index got loaded.
+func (index *ChunkIndex) changed(chunk *ChunkIndexItem) *ChunkIndexItem {
+	if index.changes == nil {
+		return nil
+	}
+	change, ok := index.changes[chunk.Hash]
+	if !ok {
+		c := *chunk
+		c.Snapshots = nil
+		change = &c
+		index.changes[chunk.Hash] = change
+	}
+	change.DataParts = chunk.DataParts
+	change.ParityParts = chunk.ParityParts
+	change.Size = chunk.Size
+	change.Tier = chunk.Tier
+	delete(index.deleted, chunk.Hash)
+	return change
+}
+
+// deleteChunk removes a chunk from the index.
+func (index *ChunkIndex) deleteChunk(hash string) {
+	delete(index.Chunks, hash)
+	if index.changes != nil {
+		delete(index.changes, hash)
+		index.deleted[hash] = true
+	}
+}
+
+// apply applies the changes of a pack to the index.
+func (index *ChunkIndex) apply(pack chunkIndexPack) {
+	for _, snapshot := range pack.RemovedSnapshots {
+		index.removeReferences(snapshot)
+	}
+	for _, item := range pack.Chunks {
+		chunk, ok := index.Chunks[item.Hash]
+		if !ok {
+			chunk = &ChunkIndexItem{Hash: item.Hash, Snapshots: []string{}}
+			index.Chunks[item.Hash] = chunk
+		}
+		chunk.DataParts = item.DataParts
+		chunk.ParityParts = item.ParityParts
+		chunk.Size = item.Size
+		chunk.Tier = item.Tier
+		chunk.Snapshots = append(chunk.Snapshots, item.Snapshots...)
+	}
+	for _, hash := range pack.Deleted {
+		delete(index.Chunks, hash)
+	}
+}
+
+// loadPacks loads the packs listed in manifest.
+func (index *ChunkIndex) loadPacks(repository *Repository, manifest chunkIndexManifest) error {
+	cache := chunkIndexPackCache(repository)
+	for _, id := range manifest.Packs {
+		b, err := cache.load(id)
+		if err != nil {
+			if b, err = repository.backend.LoadChunkIndexPack(id); err != nil {
+				return err
+			}
+			cache.save(id, b)
+		}
+
+		var pack chunkIndexPack
+		key, err := repository.decodeWithDataKeys(b, &pack)
+		if err != nil {
+			return err
+		}
+		if key != repository.Key {
+			// re-encrypt it with the current key, so the retired keys can
+			// be forgotten
+			index.rewrite = true
+		}
+		index.apply(pack)
+	}
+	cache.prune(manifest.Packs)
+
+	index.packs = manifest.Packs
+	return nil
+}
+
+// savePacks saves the changes made since the index got loaded in a new pack.
+// It merges all packs into a single one instead, once there are too many of
+// them, or if the index needs to be rewritten.
+func (index *ChunkIndex) savePacks(repository *Repository) error {
+	// other clients may have added packs in the meantime
+	var packs []string
+	var single []byte
+	b, err := repository.backend.LoadChunkIndex()
+	if err == nil {
+		if manifest, ok := parseChunkIndexManifest(b); ok {
+			packs = manifest.Packs
+		} else {
+			// the single chunk-index gets replaced by the packs
+			single = b
+			index.rewrite = true
+		}
+	} else {
+		index.rebuild = true
+	}
+	if index.key != repository.Key {
+		// the key got rotated since loading the index
+		index.rewrite = true
+	}
+	merge := index.rebuild || index.rewrite ||
+		(len(packs) >= maxChunkIndexPacks && !repository.backend.AppendOnly)
+
+	if merge && !index.rebuild {
+		// the merged pack replaces all packs, including the ones added by
+		// other clients since the index got loaded
+		current := ChunkIndex{Chunks: make(map[string]*ChunkIndexItem)}
+		switch {
+		case single != nil:
+			if _, err := repository.decodeWithDataKeys(single, &current); err != nil {
+				return err
+			}
+			index.merge(current)
+		case !equalStrings(packs, index.packs):
+			if err := current.loadPacks(repository, chunkIndexManifest{Packs: packs}); err != nil {
+				return err
+			}
+			index.merge(current)
+		}
+	}
+
+	var pack chunkIndexPack
+	if merge {
+		for _, chunk := range index.Chunks {
+			pack.Chunks = append(pack.Chunks, *chunk)
+		}
+		sort.Slice(pack.Chunks, func(i, j int) bool {
+			return pack.Chunks[i].Hash < pack.Chunks[j].Hash
+		})
+	} else {
+		pack = index.pending()
+		if len(pack.RemovedSnapshots) == 0 && len(pack.Chunks) == 0 && len(pack.Deleted) == 0 {
+			// nothing changed
+			index.packs = packs
+			return nil
+		}
+	}
+
+	manifest := chunkIndexManifest{Packs: []string{}}
+	if !merge {
+		manifest.Packs = append(manifest.Packs, packs...)
+	}
+	// an empty index doesn't need any packs
+	if !merge || len(pack.Chunks) > 0 {
+		u, err := uuid.NewV4()
+		if err != nil {
+			return err
+		}
+		id := u.String()
+		b, err := repository.encodeMetadata(CompressionLZMA, repository.Key, pack)
+		if err != nil {
+			return err
+		}
+		if err := repository.backend.SaveChunkIndexPack(id, b); err != nil {
+			return err
+		}
+		chunkIndexPackCache(repository).save(id, b)
+		manifest.Packs = append(manifest.Packs, id)
+	}
+
+	b, err = json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	if err := repository.backend.SaveChunkIndex(b); err != nil {
+		return err
+	}
+
+	if merge {
+		for _, p := range packs {
+			if err := repository.backend.DeleteChunkIndexPack(p); err != nil {
+				log.Debugf("Failed deleting chunk-index pack %s: %v", p, err)
+			}
+		}
+	}
+	index.packs = manifest.Packs
+	index.removed = nil
+	index.rewrite = false
+	index.rebuild = false
+	index.trackChanges()
+	index.key = repository.Key
+	return nil
+}
+
+// pending returns the changes made since the index got loaded.
+func (index *ChunkIndex) pending() chunkIndexPack {
+	pack := chunkIndexPack{RemovedSnapshots: index.removed}
+	for _, chunk := range index.changes {
+		pack.Chunks = append(pack.Chunks, *chunk)
+	}
+	for hash := range index.deleted {
+		pack.Deleted = append(pack.Deleted, hash)
+	}
+	sort.Slice(pack.Chunks, func(i, j int) bool {
+		return pack.Chunks[i].Hash < pack.Chunks[j].Hash
+	})
+	sort.Strings(pack.Deleted)
+	return pack
+}
+
+// merge replaces the index with current, the index stored by other clients
+// in the meantime, plus the changes made since the index got loaded.
+func (index *ChunkIndex) merge(current ChunkIndex) {
+	current.apply(index.pending())
+	index.Chunks = current.Chunks
+}
+
+// equalStrings returns true if a and b contain the same strings in the same
+// order.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// packCache keeps copies of the packs of a chunk-index on the local
+// machine. Packs never change once they have been stored, so only the packs
+// added by other clients need to be loaded from the backends.
+type packCache string
+
+// chunkIndexPackCache returns the cache of the packs of the repository's
+// chunk-index. It's disabled if there's no cache dir.
+func chunkIndexPackCache(repository *Repository) packCache {
+	dir, err := CacheDir()
+	if err != nil || repository.ID == "" {
+		return ""
+	}
+	return packCache(filepath.Join(dir, "index", repository.ID))
+}
+
+func (c packCache) load(id string) ([]byte, error) {
+	if c == "" {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.ReadFile(filepath.Join(string(c), id))
+}
+
+func (c packCache) save(id string, b []byte) {
+	if c == "" {
+		return
+	}
+	if err := os.MkdirAll(string(c), 0700); err != nil {
+		return
+	}
+	// write to a temporary file first, so we never end up with a partially
+	// written pack
+	path := filepath.Join(string(c), id)
+	if err := ioutil.WriteFile(path+".tmp", b, 0600); err != nil {
+		return
+	}
+	_ = os.Rename(path+".tmp", path)
+}
+
+// prune removes the packs, which aren't part of the chunk-index anymore.
+func (c packCache) prune(packs []string) {
+	if c == "" {
+		return
+	}
+	keep := make(map[string]bool)
+	for _, id := range packs {
+		keep[id] = true
+	}
+	files, err := ioutil.ReadDir(string(c))
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		if !keep[f.Name()] {
+			os.Remove(filepath.Join(string(c), f.Name()))
+		}
+	}
+}
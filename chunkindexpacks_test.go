@@ -0,0 +1,304 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// testHash returns the hash of a chunk named name.
+func testHash(name string) string {
+	h := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(h[:])
+}
+
+// testArchive returns an archive consisting of the chunks with the given
+// names.
+func testArchive(names ...string) *Archive {
+	arc := &Archive{}
+	for i, name := range names {
+		arc.Chunks = append(arc.Chunks, Chunk{Hash: testHash(name), Num: uint(i), Size: 42, DataParts: 1})
+	}
+	return arc
+}
+
+// indexState returns the chunks of an index with their references sorted,
+// so indexes can be compared.
+func indexState(index ChunkIndex) map[string]ChunkIndexItem {
+	state := make(map[string]ChunkIndexItem)
+	for hash, chunk := range index.Chunks {
+		c := *chunk
+		c.Snapshots = append([]string{}, c.Snapshots...)
+		sort.Strings(c.Snapshots)
+		state[hash] = c
+	}
+	return state
+}
+
+func TestChunkIndexPacks(t *testing.T) {
+	cache, err := ioutil.TempDir("", "knoxite.cache")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for cache: %s", err)
+	}
+	defer os.RemoveAll(cache)
+	os.Setenv(EnvCacheDir, cache)
+	defer os.Unsetenv(EnvCacheDir)
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, "this_is_a_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	if !r.chunkIndexPacks() {
+		t.Fatal("Expected new repositories to store the chunk-index in packs")
+	}
+
+	open := func() ChunkIndex {
+		index, err := OpenChunkIndex(&r)
+		if err != nil {
+			t.Fatalf("Failed opening chunk-index: %s", err)
+		}
+		return index
+	}
+	save := func(index *ChunkIndex) {
+		if err := index.Save(&r); err != nil {
+			t.Fatalf("Failed saving chunk-index: %s", err)
+		}
+	}
+	// check reopens the index and compares it to the expected state
+	check := func(expected ChunkIndex) ChunkIndex {
+		index := open()
+		if !reflect.DeepEqual(indexState(index), indexState(expected)) {
+			t.Errorf("Expected the reopened chunk-index to be %v, got %v", indexState(expected), indexState(index))
+		}
+		return index
+	}
+
+	index := open()
+	index.AddArchive(testArchive("a", "b", "c"), "s1")
+	save(&index)
+	index = check(index)
+
+	// only the changes get saved in a new pack
+	index.AddArchive(testArchive("a", "d"), "s2")
+	save(&index)
+	if len(index.packs) != 2 {
+		t.Fatalf("Expected the chunk-index to consist of 2 packs, got %d", len(index.packs))
+	}
+	b, err := r.backend.LoadChunkIndexPack(index.packs[1])
+	if err != nil {
+		t.Fatalf("Failed loading pack: %s", err)
+	}
+	var pack chunkIndexPack
+	if _, err := r.decodeWithDataKeys(b, &pack); err != nil {
+		t.Fatalf("Failed decoding pack: %s", err)
+	}
+	if len(pack.Chunks) != 2 || !reflect.DeepEqual(pack.Chunks[0].Snapshots, []string{"s2"}) {
+		t.Errorf("Expected the pack to only contain the references of s2, got %v", pack.Chunks)
+	}
+	index = check(index)
+
+	// references of removed snapshots and deleted chunks
+	index.RemoveSnapshot("s1")
+	index.AddArchive(testArchive("e"), "s1")
+	// as if Pack deleted the unreferenced chunk
+	index.deleteChunk(testHash("b"))
+	save(&index)
+	index = check(index)
+
+	// clients, which opened the index at the same time, don't lose their
+	// changes
+	other := open()
+	index.AddArchive(testArchive("f"), "s3")
+	other.AddArchive(testArchive("g"), "s4")
+	save(&index)
+	save(&other)
+	merged := open()
+	for _, name := range []string{"f", "g"} {
+		if _, ok := merged.Chunks[testHash(name)]; !ok {
+			t.Errorf("Expected chunk %s to be indexed", name)
+		}
+	}
+
+	// clients rewriting the index, e.g. because they loaded a pack encrypted
+	// with a retired key, keep the packs other clients added in the meantime
+	rewriting := open()
+	other = open()
+	other.AddArchive(testArchive("i"), "s6")
+	save(&other)
+	rewriting.rewrite = true
+	rewriting.AddArchive(testArchive("j"), "s7")
+	save(&rewriting)
+	if len(rewriting.packs) != 1 {
+		t.Errorf("Expected the packs to be merged into a single one, got %d packs", len(rewriting.packs))
+	}
+	merged = open()
+	for _, name := range []string{"f", "g", "i", "j"} {
+		if _, ok := merged.Chunks[testHash(name)]; !ok {
+			t.Errorf("Expected chunk %s to be indexed after rewriting the index", name)
+		}
+	}
+
+	// too many packs get merged into a single one
+	index = merged
+	for i := 0; len(index.packs) != 1; i++ {
+		if i > maxChunkIndexPacks {
+			t.Fatalf("Expected the packs to be merged, got %d packs", len(index.packs))
+		}
+		index.AddArchive(testArchive(fmt.Sprintf("h%d", i)), "s5")
+		save(&index)
+	}
+	check(index)
+	packs, err := ioutil.ReadDir(dir + "/" + ChunkIndexPacksDirname)
+	if err != nil {
+		t.Fatalf("Failed listing packs: %s", err)
+	}
+	if len(packs) != 1 {
+		t.Errorf("Expected the merged packs to be deleted, found %d packs", len(packs))
+	}
+}
+
+func TestChunkIndexPacksUpgrade(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, "this_is_a_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	// a repository created by an older version of knoxite
+	r.Version = chunkIndexPacksVersion - 1
+	r.ReaderVersion = chunkIndexPacksVersion - 1
+	if err := r.Save(); err != nil {
+		t.Fatalf("Failed saving repository: %s", err)
+	}
+
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	index.AddArchive(testArchive("a", "b"), "s1")
+	if err := index.Save(&r); err != nil {
+		t.Fatalf("Failed saving chunk-index: %s", err)
+	}
+	b, err := r.backend.LoadChunkIndex()
+	if err != nil {
+		t.Fatalf("Failed loading chunk-index: %s", err)
+	}
+	if _, ok := parseChunkIndexManifest(b); ok {
+		t.Fatal("Expected older repositories to keep a single chunk-index")
+	}
+
+	if err := r.Upgrade(); err != nil {
+		t.Fatalf("Failed upgrading repository: %s", err)
+	}
+	index, err = OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	if err := index.Save(&r); err != nil {
+		t.Fatalf("Failed saving chunk-index: %s", err)
+	}
+	if b, err = r.backend.LoadChunkIndex(); err != nil {
+		t.Fatalf("Failed loading chunk-index: %s", err)
+	}
+	if _, ok := parseChunkIndexManifest(b); !ok {
+		t.Fatal("Expected the chunk-index of upgraded repositories to be stored in packs")
+	}
+
+	upgraded, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	if !reflect.DeepEqual(indexState(upgraded), indexState(index)) {
+		t.Errorf("Expected the upgraded chunk-index to be %v, got %v", indexState(index), indexState(upgraded))
+	}
+}
+
+// plainBackend hides all optional capabilities of the wrapped backend, like
+// storing the chunk-index in packs.
+type plainBackend struct {
+	Backend
+}
+
+func TestChunkIndexPacksMirror(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, "this_is_a_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	index.AddArchive(testArchive("a", "b"), "s1")
+	if err := index.Save(&r); err != nil {
+		t.Fatalf("Failed saving chunk-index: %s", err)
+	}
+
+	// a mirror added later, which can't store packs, gets read first
+	local, err := BackendFromURL(dir)
+	if err != nil {
+		t.Fatalf("Failed creating backend: %s", err)
+	}
+	var mirror Backend = &plainBackend{local}
+	r.backend.Backends = append([]*Backend{&mirror}, r.backend.Backends...)
+	if r.chunkIndexPacks() {
+		t.Fatal("Expected packs to be unsupported with a mirror, which can't store them")
+	}
+
+	index, err = OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	if len(index.Chunks) != 2 {
+		t.Errorf("Expected 2 indexed chunks, got %d", len(index.Chunks))
+	}
+	if _, err := r.backend.LoadChunkIndexPack("missing"); err != ErrLoadChunkIndexFailed {
+		t.Errorf("Expected %v, got %v", ErrLoadChunkIndexFailed, err)
+	}
+	if err := r.backend.DeleteChunkIndexPack("missing"); err == nil {
+		t.Error("Expected deleting a missing pack to fail")
+	}
+
+	// the index gets stored as a single index again
+	index.AddArchive(testArchive("c"), "s2")
+	if err := index.Save(&r); err != nil {
+		t.Fatalf("Failed saving chunk-index: %s", err)
+	}
+	b, err := r.backend.LoadChunkIndex()
+	if err != nil {
+		t.Fatalf("Failed loading chunk-index: %s", err)
+	}
+	if _, ok := parseChunkIndexManifest(b); ok {
+		t.Error("Expected a single chunk-index to be stored")
+	}
+	if index, err = OpenChunkIndex(&r); err != nil || len(index.Chunks) != 3 {
+		t.Errorf("Expected 3 indexed chunks, got %d: %v", len(index.Chunks), err)
+	}
+}
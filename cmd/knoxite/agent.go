@@ -0,0 +1,53 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"context"
+	"os"
+
+	shutdown "github.com/klauspost/shutdown2"
+	"github.com/spf13/cobra"
+
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+	"github.com/knoxite/knoxite/source/remote"
+)
+
+var (
+	agentCmd = &cobra.Command{
+		Use:    "agent",
+		Short:  "serve a knoxite instance pulling data over SSH",
+		Long:   `The agent command gets run on remote hosts by the ssh source. It scans paths and streams the content of files back to the knoxite instance storing them`,
+		Hidden: true,
+	}
+	agentScanCmd = &cobra.Command{
+		Use:   "scan",
+		Short: "scan the path of a request read from stdin",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := shutdown.CancelCtx(context.Background())
+			defer cancel()
+			return remote.ServeScan(ctx, os.Stdin, os.Stdout)
+		},
+	}
+	agentCatCmd = &cobra.Command{
+		Use:   "cat [file]",
+		Short: "write the content of a file to stdout",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return i18n.Errorf("cat needs to know which file to read")
+			}
+			return remote.ServeCat(args[0], os.Stdout)
+		},
+	}
+)
+
+func init() {
+	agentCmd.AddCommand(agentScanCmd)
+	agentCmd.AddCommand(agentCatCmd)
+	RootCmd.AddCommand(agentCmd)
+}
@@ -0,0 +1,69 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var aliasArg = regexp.MustCompile(`^@[a-zA-Z0-9_.-]+$`)
+
+// expandAliasArg replaces the first positional argument of the form @name
+// with --alias name, so `knoxite store @nas /home` works on the repository
+// configured as nas.
+func expandAliasArg(root *cobra.Command, args []string) []string {
+	cmd, _, err := root.Find(args)
+	if err != nil || cmd == root || strings.HasPrefix(cmd.Name(), "_carapace") {
+		return args
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if strings.HasPrefix(arg, "-") {
+			if takesValue(cmd, arg) {
+				// skip the flag's value
+				i++
+			}
+			continue
+		}
+		if aliasArg.MatchString(arg) {
+			expanded := append([]string{}, args[:i]...)
+			expanded = append(expanded, "--alias", arg[1:])
+			return append(expanded, args[i+1:]...)
+		}
+	}
+	return args
+}
+
+// takesValue returns true if arg is a flag, whose value is passed as the next
+// argument.
+func takesValue(cmd *cobra.Command, arg string) bool {
+	if strings.Contains(arg, "=") {
+		return false
+	}
+
+	for _, flags := range []*pflag.FlagSet{cmd.Flags(), cmd.InheritedFlags()} {
+		var f *pflag.Flag
+		if strings.HasPrefix(arg, "--") {
+			f = flags.Lookup(arg[2:])
+		} else if len(arg) == 2 {
+			f = flags.ShorthandLookup(arg[1:])
+		}
+		if f != nil {
+			return f.NoOptDefVal == ""
+		}
+	}
+	return false
+}
@@ -8,11 +8,16 @@
 package main
 
 import (
-	"fmt"
+	"bufio"
+	"context"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
 
+	shutdown "github.com/klauspost/shutdown2"
 	"github.com/spf13/cobra"
 )
 
@@ -20,10 +25,10 @@ var (
 	catCmd = &cobra.Command{
 		Use:   "cat [snapshot] [file]",
 		Short: "print file",
-		Long:  `The cat command prints a file on the standard output`,
+		Long:  `The cat command streams the content of a file on the standard output, e.g. to pipe a database dump straight into its database`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) != 2 {
-				return fmt.Errorf("cat needs a snapshot ID and filename")
+				return i18n.Errorf("cat needs a snapshot ID and filename")
 			}
 			return executeCat(args[0], args[1])
 		},
@@ -43,16 +48,29 @@ func executeCat(snapshotID string, file string) error {
 	if err != nil {
 		return err
 	}
+	snapshot, err = snapshot.Merged(&repository)
+	if err != nil {
+		return err
+	}
+
+	archive, ok := snapshot.Archives[file]
+	if !ok {
+		// items are stored with relative paths
+		archive, ok = snapshot.Archives[strings.TrimPrefix(filepath.Clean(file), string(filepath.Separator))]
+	}
+	if !ok {
+		return i18n.Errorf("%s: No such file or directory", file)
+	}
+	if archive.Type == knoxite.Directory {
+		return i18n.Errorf("%s: Is a directory", file)
+	}
 
-	if archive, ok := snapshot.Archives[file]; ok {
-		b, _, err := knoxite.DecodeArchiveData(repository, *archive)
-		if err != nil {
-			return err
-		}
+	ctx, cancel := shutdown.CancelCtx(context.Background())
+	defer cancel()
 
-		_, err = os.Stdout.Write(b)
+	w := bufio.NewWriter(os.Stdout)
+	if err := knoxite.WriteArchiveData(ctx, repository, *archive, w); err != nil {
 		return err
 	}
-
-	return fmt.Errorf("%s: No such file or directory", file)
+	return w.Flush()
 }
@@ -8,13 +8,11 @@
 package main
 
 import (
-	"fmt"
-	"path/filepath"
-
 	shutdown "github.com/klauspost/shutdown2"
 	"github.com/spf13/cobra"
 
 	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
 )
 
 var (
@@ -26,10 +24,10 @@ var (
 		Long:  `The clone command clones an existing snapshot and adds a file or directory`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) < 1 {
-				return fmt.Errorf("clone needs to know which snapshot to clone")
+				return i18n.Errorf("clone needs to know which snapshot to clone")
 			}
 			if len(args) < 2 {
-				return fmt.Errorf("clone needs to know which files and/or directories to work on")
+				return i18n.Errorf("clone needs to know which files and/or directories to work on")
 			}
 
 			configureStoreOpts(cmd, &cloneOpts)
@@ -44,12 +42,9 @@ func init() {
 }
 
 func executeClone(snapshotID string, args []string, opts StoreOptions) error {
-	targets := []string{}
-	for _, target := range args {
-		if absTarget, err := filepath.Abs(target); err == nil {
-			target = absTarget
-		}
-		targets = append(targets, target)
+	_, targets, err := storeTargets(args, opts)
+	if err != nil {
+		return err
 	}
 
 	// acquire a shutdown lock. we don't want these next calls to be interrupted
@@ -61,14 +56,26 @@ func executeClone(snapshotID string, args []string, opts StoreOptions) error {
 	if err != nil {
 		return err
 	}
+	unlock, err := lockRepository(&repository, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	volume, s, err := repository.FindSnapshot(snapshotID)
 	if err != nil {
 		return err
 	}
+	s, err = s.Merged(&repository)
+	if err != nil {
+		return err
+	}
 	snapshot, err := s.Clone()
 	if err != nil {
 		return err
 	}
+	if len(opts.Tags) > 0 {
+		snapshot.Tags = opts.Tags
+	}
 	chunkIndex, err := knoxite.OpenChunkIndex(&repository)
 	if err != nil {
 		return err
@@ -76,7 +83,7 @@ func executeClone(snapshotID string, args []string, opts StoreOptions) error {
 	// release the shutdown lock
 	lock()
 
-	err = store(&repository, &chunkIndex, snapshot, targets, opts)
+	err = store(&repository, &chunkIndex, snapshot, targets, "", nil, nil, opts)
 	if err != nil {
 		return err
 	}
@@ -96,9 +103,5 @@ func executeClone(snapshotID string, args []string, opts StoreOptions) error {
 	if err != nil {
 		return err
 	}
-	err = chunkIndex.Save(&repository)
-	if err != nil {
-		return err
-	}
-	return repository.Save()
+	return repository.Commit(&chunkIndex)
 }
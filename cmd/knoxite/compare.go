@@ -0,0 +1,109 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"context"
+
+	shutdown "github.com/klauspost/shutdown2"
+	"github.com/spf13/cobra"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+)
+
+var (
+	repoCompareCmd = &cobra.Command{
+		Use:   "compare [urlA] [urlB]",
+		Short: "verify that two repositories store the same data",
+		Long: `The compare command checks whether two repositories, e.g. a repository and
+the mirror it gets synced to, store the same snapshots and chunks. Snapshots
+and the repository's metadata are compared by their checksums, chunks only by
+their names and sizes, so no chunks get downloaded. It doesn't need the
+repository's password`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				return i18n.Errorf("compare needs the URLs of two repositories")
+			}
+			return executeRepoCompare(args[0], args[1])
+		},
+	}
+)
+
+func init() {
+	repoCmd.AddCommand(repoCompareCmd)
+}
+
+func executeRepoCompare(urlA, urlB string) error {
+	a, err := knoxite.BackendFromURL(urlA)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	b, err := knoxite.BackendFromURL(urlB)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	ctx, cancel := shutdown.CancelCtx(context.Background())
+	defer cancel()
+
+	report, err := knoxite.CompareBackends(ctx, a, b)
+	if err != nil {
+		return err
+	}
+	if globalOpts.JSON {
+		if err := printJSON(report); err != nil {
+			return err
+		}
+	} else {
+		printCompareReport(report, knoxite.RedactURL(a.Location()), knoxite.RedactURL(b.Location()))
+	}
+
+	if !report.Identical() {
+		return i18n.Errorf("The repositories differ")
+	}
+	return nil
+}
+
+func printCompareReport(report *knoxite.CompareReport, a, b string) {
+	log.Printf("Compared snapshots: %d", report.Snapshots)
+	log.Printf("Compared chunks:    %d", report.Chunks)
+
+	if report.RepositoryDiffers {
+		log.Printf("The repository metadata differs")
+	}
+	if report.ChunkIndexDiffers {
+		log.Printf("The chunk-index differs")
+	}
+	for _, id := range report.SnapshotsOnlyA {
+		log.Printf("Snapshot %s is missing in %s", id, b)
+	}
+	for _, id := range report.SnapshotsOnlyB {
+		log.Printf("Snapshot %s is missing in %s", id, a)
+	}
+	for _, id := range report.SnapshotsDiffer {
+		log.Printf("Snapshot %s differs", id)
+	}
+	for _, name := range report.ChunksOnlyA {
+		log.Printf("Chunk %s is missing in %s", name, b)
+	}
+	for _, name := range report.ChunksOnlyB {
+		log.Printf("Chunk %s is missing in %s", name, a)
+	}
+	for _, name := range report.ChunksDiffer {
+		log.Printf("Chunk %s differs in size", name)
+	}
+	if !report.SizesCompared {
+		log.Printf("Sizes of chunks could not be compared, only checked that they exist")
+	}
+	if report.Identical() {
+		log.Printf("Both repositories store the same data")
+	}
+}
@@ -14,6 +14,7 @@ import (
 
 	"github.com/knoxite/knoxite"
 	"github.com/knoxite/knoxite/cmd/knoxite/config"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
 	"github.com/knoxite/knoxite/cmd/knoxite/utils"
 	"github.com/muesli/gotable"
 	"github.com/pelletier/go-toml"
@@ -40,7 +41,7 @@ var (
 		Long:  `The set command adds an alias for the storage backend url to a repository`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) != 1 {
-				return fmt.Errorf("alias needs an ALIAS to set")
+				return i18n.Errorf("alias needs an ALIAS to set")
 			}
 			return executeConfigAlias(args[0])
 		},
@@ -51,10 +52,10 @@ var (
 		Long:  "The set command lets you set configuration values for an alias",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) < 1 {
-				return fmt.Errorf("set needs to know which option to set")
+				return i18n.Errorf("set needs to know which option to set")
 			}
 			if len(args) < 2 {
-				return fmt.Errorf("set needs to know which value to set")
+				return i18n.Errorf("set needs to know which value to set")
 			}
 			return executeConfigSet(args[0], args[1:])
 		},
@@ -81,10 +82,10 @@ var (
 		Long:  "The convert command translates between several configuration backends",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) < 1 {
-				return fmt.Errorf("convert needs a source to work on")
+				return i18n.Errorf("convert needs a source to work on")
 			}
 			if len(args) < 2 {
-				return fmt.Errorf("convert needs a target to write to")
+				return i18n.Errorf("convert needs a target to write to")
 			}
 			return executeConfigConvert(args[0], args[1])
 		},
@@ -102,7 +103,7 @@ func init() {
 }
 
 func executeConfigInit() error {
-	log.Printf("Writing configuration file to: %s\n", cfg.URL().Path)
+	log.Printf("Writing configuration file to: %s", cfg.URL().Path)
 	return cfg.Save()
 }
 
@@ -123,13 +124,13 @@ func executeConfigSet(option string, values []string) error {
 	// fine for now.
 	parts := strings.Split(option, ".")
 	if len(parts) != 2 {
-		return fmt.Errorf("config set needs to work on an alias and a option like this: alias.option")
+		return i18n.Errorf("config set needs to work on an alias and a option like this: alias.option")
 	}
 
 	// The first part should be the repos alias
 	repo, ok := cfg.Repositories[strings.ToLower(parts[0])]
 	if !ok {
-		return fmt.Errorf("No alias with name %s found", parts[0])
+		return i18n.Errorf("No alias with name %s found", parts[0])
 	}
 
 	opt := strings.ToLower(parts[1])
@@ -143,7 +144,7 @@ func executeConfigSet(option string, values []string) error {
 	case "tolerance":
 		tol, err := strconv.Atoi(values[0])
 		if err != nil {
-			return fmt.Errorf("Failed to convert %s to uint for the fault tolerance option: %v", opt, err)
+			return i18n.Errorf("Failed to convert %s to uint for the fault tolerance option: %v", opt, err)
 		}
 		repo.Tolerance = uint(tol)
 	case "store_excludes":
@@ -156,9 +157,100 @@ func executeConfigSet(option string, values []string) error {
 			return err
 		}
 		repo.Pedantic = b
+	case "volume":
+		repo.Volume = values[0]
+	case "store_paths":
+		repo.StorePaths = values
+	case "schedule":
+		if !validSchedule(values[0]) {
+			return i18n.Errorf("Unknown schedule %s, use one of: %s or a cron expression", values[0], strings.Join(schedules, ", "))
+		}
+		repo.Schedule = values[0]
+	case "blackouts":
+		repo.Blackouts = values
+		if _, err := profileSchedule(repo); err != nil {
+			return err
+		}
+	case "jitter":
+		repo.Jitter = values[0]
+		if _, err := profileSchedule(repo); err != nil {
+			return err
+		}
+	case "catch_up":
+		b, err := strconv.ParseBool(values[0])
+		if err != nil {
+			return err
+		}
+		repo.CatchUp = b
+	case "keep":
+		if _, err := knoxite.ParseRetentionPolicy(values[0]); err != nil {
+			return err
+		}
+		repo.Keep = values[0]
+	case "keep_paths":
+		if _, err := parsePathRetentionPolicies(values); err != nil {
+			return err
+		}
+		repo.KeepPaths = values
+	case "tags":
+		repo.Tags = values
+	case "redact":
+		if _, err := newRedactor(values, ""); err != nil {
+			return err
+		}
+		repo.Redact = values
+	case "processors":
+		if _, err := parseProcessors(values); err != nil {
+			return err
+		}
+		repo.Processors = values
+	case "exclude_flagged":
+		b, err := strconv.ParseBool(values[0])
+		if err != nil {
+			return err
+		}
+		repo.ExcludeFlagged = b
+	case "bandwidth_share":
+		n, err := strconv.ParseUint(values[0], 10, 32)
+		if err != nil {
+			return err
+		}
+		repo.BandwidthShare = uint(n)
+	case "verify_every":
+		n, err := strconv.ParseUint(values[0], 10, 32)
+		if err != nil {
+			return err
+		}
+		repo.VerifyEvery = uint(n)
+	case "verify_forget":
+		b, err := strconv.ParseBool(values[0])
+		if err != nil {
+			return err
+		}
+		repo.VerifyForget = b
+	case "verify_percent":
+		n, err := strconv.Atoi(values[0])
+		if err != nil || n < 0 || n > 100 {
+			return i18n.Errorf("Invalid percentage %s, expected a number between 0 and 100", values[0])
+		}
+		repo.VerifyPercent = n
+	case "password_file":
+		repo.PasswordFile = values[0]
+	case "password_command":
+		repo.PasswordCommand = values[0]
+	case "private_key":
+		repo.PrivateKey = values[0]
+	case "keyring":
+		b, err := strconv.ParseBool(values[0])
+		if err != nil {
+			return err
+		}
+		repo.Keyring = b
+	case "metrics_file":
+		repo.MetricsFile = values[0]
 
 	default:
-		return fmt.Errorf("Unknown configuration option: %s", opt)
+		return i18n.Errorf("Unknown configuration option: %s", opt)
 	}
 	cfg.Repositories[strings.ToLower(parts[0])] = repo
 
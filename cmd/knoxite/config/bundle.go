@@ -0,0 +1,118 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+package config
+
+import (
+	"bytes"
+	"errors"
+	"time"
+
+	"github.com/knoxite/knoxite"
+	"github.com/pelletier/go-toml"
+)
+
+// BundleHeaderPrefix is added to configuration bundles to make it possible
+// to detect them.
+const BundleHeaderPrefix = "knoxitebundle+"
+
+// bundleVersion is the format version of bundles written by this version
+// of knoxite.
+const bundleVersion = 1
+
+// Error declarations.
+var (
+	ErrInvalidBundle  = errors.New("not a knoxite configuration bundle")
+	ErrBundleNewer    = errors.New("the configuration bundle has been written by a newer version of knoxite")
+	ErrBundlePassword = errors.New("wrong password or corrupted configuration bundle")
+)
+
+// A Bundle holds the client-side configuration of knoxite, i.e. all
+// profiles with their repository URLs, schedules and hooks, to move it to
+// another machine.
+type Bundle struct {
+	Version      int                   `toml:"version"`
+	Created      time.Time             `toml:"created"`
+	Hostname     string                `toml:"hostname"`
+	Repositories map[string]RepoConfig `toml:"repositories"`
+	// Secrets maps the accounts of the OS keyring to the repository passwords
+	// stored for them, if they have been exported
+	Secrets map[string]string `toml:"secrets"`
+}
+
+// NewBundle returns a bundle of the profiles of config.
+func NewBundle(config *Config) *Bundle {
+	b := &Bundle{
+		Version:      bundleVersion,
+		Created:      time.Now(),
+		Repositories: make(map[string]RepoConfig),
+		Secrets:      make(map[string]string),
+	}
+	for alias, repo := range config.Repositories {
+		b.Repositories[alias] = repo
+	}
+	return b
+}
+
+// Encode serializes the bundle, compresses it with zstd and encrypts it with
+// a key derived from password.
+func (b *Bundle) Encode(password string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := toml.NewEncoder(buf).Encode(*b); err != nil {
+		return nil, err
+	}
+	data, err := knoxite.Compressor{Method: knoxite.CompressionZstd}.Process(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := encrypt(data, []byte(password))
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(BundleHeaderPrefix), ciphertext...), nil
+}
+
+// DecodeBundle decrypts and decodes a bundle written by Encode.
+func DecodeBundle(data []byte, password string) (*Bundle, error) {
+	if !bytes.HasPrefix(data, []byte(BundleHeaderPrefix)) || len(data) < len(BundleHeaderPrefix)+32 {
+		return nil, ErrInvalidBundle
+	}
+	plaintext, err := decrypt(data[len(BundleHeaderPrefix):], []byte(password))
+	if err != nil {
+		return nil, ErrBundlePassword
+	}
+	plaintext, err = knoxite.Decompressor{Method: knoxite.CompressionZstd}.Process(plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bundle{}
+	if err := toml.Unmarshal(plaintext, b); err != nil {
+		return nil, err
+	}
+	if b.Version > bundleVersion {
+		return nil, ErrBundleNewer
+	}
+	return b, nil
+}
+
+// Merge adds the profiles of the bundle to config. Existing profiles only get
+// replaced if overwrite is true. It returns the aliases of the added
+// profiles and of those which have been skipped.
+func (b *Bundle) Merge(config *Config, overwrite bool) (added, skipped []string) {
+	if config.Repositories == nil {
+		config.Repositories = make(map[string]RepoConfig)
+	}
+	for alias, repo := range b.Repositories {
+		if _, ok := config.Repositories[alias]; ok && !overwrite {
+			skipped = append(skipped, alias)
+			continue
+		}
+		config.Repositories[alias] = repo
+		added = append(added, alias)
+	}
+	return added, skipped
+}
@@ -0,0 +1,74 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+package config
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestBundle(t *testing.T) {
+	conf, err := New("mem://")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf.Repositories = map[string]RepoConfig{
+		"home": {
+			Url:           "/tmp/knoxitetest",
+			Compression:   "zstd",
+			Schedule:      "daily",
+			StoreExcludes: []string{"*.tmp"},
+		},
+		"offsite": {Url: "s3://backup/knoxite", Keep: "last=3"},
+	}
+
+	b := NewBundle(conf)
+	b.Secrets["/tmp/knoxitetest"] = "secret"
+	data, err := b.Encode(testPassword)
+	if err != nil {
+		t.Fatalf("Failed encoding bundle: %v", err)
+	}
+	if !bytes.HasPrefix(data, []byte(BundleHeaderPrefix)) || bytes.Contains(data, []byte("secret")) {
+		t.Error("Expected bundle to be encrypted")
+	}
+
+	if _, err := DecodeBundle(data, "wrong"); err != ErrBundlePassword {
+		t.Errorf("Expected %v for a wrong password, got %v", ErrBundlePassword, err)
+	}
+	if _, err := DecodeBundle([]byte("[repositories]"), testPassword); err != ErrInvalidBundle {
+		t.Errorf("Expected %v for a config file, got %v", ErrInvalidBundle, err)
+	}
+	decoded, err := DecodeBundle(data, testPassword)
+	if err != nil {
+		t.Fatalf("Failed decoding bundle: %v", err)
+	}
+	home := decoded.Repositories["home"]
+	if len(decoded.Repositories) != 2 || home.Url != "/tmp/knoxitetest" || home.Schedule != "daily" ||
+		!reflect.DeepEqual(home.StoreExcludes, []string{"*.tmp"}) || decoded.Repositories["offsite"].Keep != "last=3" {
+		t.Errorf("Profiles did not match:\nExpected: %v\nGot: %v", conf.Repositories, decoded.Repositories)
+	}
+	if decoded.Secrets["/tmp/knoxitetest"] != "secret" {
+		t.Errorf("Expected secrets to be part of the bundle, got %v", decoded.Secrets)
+	}
+
+	target, _ := New("mem://")
+	target.Repositories = map[string]RepoConfig{
+		"home": {Url: "/mnt/backup"},
+	}
+	added, skipped := decoded.Merge(target, false)
+	if len(added) != 1 || added[0] != "offsite" || len(skipped) != 1 || skipped[0] != "home" {
+		t.Errorf("Expected offsite to be added and home to be skipped, got %v and %v", added, skipped)
+	}
+	if target.Repositories["home"].Url != "/mnt/backup" {
+		t.Error("Expected existing profile to be kept")
+	}
+	decoded.Merge(target, true)
+	if target.Repositories["home"].Url != "/tmp/knoxitetest" {
+		t.Error("Expected existing profile to be overwritten")
+	}
+}
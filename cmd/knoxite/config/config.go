@@ -13,6 +13,7 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/knoxite/knoxite"
 	"github.com/knoxite/knoxite/cmd/knoxite/utils"
 	gap "github.com/muesli/go-app-paths"
 )
@@ -33,10 +34,34 @@ type RepoConfig struct {
 	Url             string   `toml:"url" comment:"Repository directory to backup to/restore from"`
 	Compression     string   `toml:"compression" comment:"Compression algo to use: none (default), flate, gzip, lzma, zlib, zstd"`
 	Tolerance       uint     `toml:"tolerance" comment:"Failure tolerance against n backend failures"`
-	Encryption      string   `toml:"encryption" comment:"Encryption algo to use: aes (default), none"`
+	Encryption      string   `toml:"encryption" comment:"Encryption algo to use: aes (default), aes-gcm, none"`
 	Pedantic        bool     `toml:"pedantic" comment:"Stop backup operation after the first error occurred"`
 	StoreExcludes   []string `toml:"store_excludes" comment:"Specify excludes for the store operation"`
 	RestoreExcludes []string `toml:"restore_excludes" comment:"Specify excludes for the restore operation"`
+	Volume          string   `toml:"volume" comment:"Volume to store snapshots in when no volume is given"`
+	StorePaths      []string `toml:"store_paths" comment:"Files and directories to store when none are given"`
+	Schedule        string   `toml:"schedule" comment:"How often to store a snapshot: hourly, daily, weekly, monthly, never or a cron expression like 30 2 * * 1-5"`
+	Blackouts       []string `toml:"blackouts" comment:"Time windows without scheduled snapshots, e.g. Mon-Fri 09:00-17:00"`
+	Jitter          string   `toml:"jitter" comment:"Maximum random delay of scheduled snapshots, e.g. 10m"`
+	CatchUp         bool     `toml:"catch_up" comment:"Store a missed scheduled snapshot as soon as possible"`
+	BandwidthShare  uint     `toml:"bandwidth_share" comment:"Share of the daemon's bandwidth while other jobs run concurrently, relative to theirs (default: 1)"`
+	Keep            string   `toml:"keep" comment:"Retention policy for snapshot forget, e.g. last=3,daily=7,weekly=4"`
+	KeepPaths       []string `toml:"keep_paths" comment:"Retention policies for items below a path, e.g. /etc:daily=30,monthly=12"`
+	Tags            []string `toml:"tags" comment:"Tags of stored snapshots, snapshot forget only removes snapshots carrying them"`
+	VerifyEvery     uint     `toml:"verify_every" comment:"Verify the repository after every n-th stored snapshot, 0 disables it"`
+	VerifyForget    bool     `toml:"verify_forget" comment:"Verify the repository after forgetting snapshots"`
+	VerifyPercent   int      `toml:"verify_percent" comment:"How many files automatic verifications check the content of, between 0 (only metadata) and 100"`
+	Redact          []string `toml:"redact" comment:"Metadata to hide when storing snapshots: host[=strip], owner or path=[name]"`
+	Processors      []string `toml:"processors" comment:"Commands inspecting every stored file, e.g. clamav=clamdscan --no-summary -"`
+	ExcludeFlagged  bool     `toml:"exclude_flagged" comment:"Don't store files flagged by one of the processors"`
+	PasswordFile    string   `toml:"password_file" comment:"File to read the repository's password from"`
+	PasswordCommand string   `toml:"password_command" comment:"Command printing the repository's password, e.g. pass show knoxite"`
+	PrivateKey      string   `toml:"private_key" comment:"File holding the private key of a repository using asymmetric encryption"`
+	Keyring         bool     `toml:"keyring" comment:"Read the password from and save it in the OS keyring"`
+	MetricsFile     string   `toml:"metrics_file" comment:"File to write Prometheus metrics of stored snapshots to, for the node exporter's textfile collector"`
+	PreHook         string   `toml:"pre_hook" comment:"Command to run before storing a snapshot, e.g. to dump a database"`
+	PostSuccessHook string   `toml:"post_success_hook" comment:"Command to run after a snapshot has been stored"`
+	PostFailureHook string   `toml:"post_failure_hook" comment:"Command to run after storing a snapshot failed"`
 }
 
 type Config struct {
@@ -138,15 +163,23 @@ func New(url string) (*Config, error) {
 
 // DefaultPath returns Knoxite's default config path.
 //
-// The path returned is OS dependant. If there's an error
-// while trying to figure out the OS dependant path, "knoxite.conf"
-// in the current working dir is returned.
+// The path returned is OS dependant, see knoxite.ConfigDir. Configs created by
+// older versions of knoxite in a different location are still being used if
+// no config exists in the default location. If there's an error while trying
+// to figure out the OS dependant path, "knoxite.conf" in the current working
+// dir is returned.
 func DefaultPath() string {
-	userScope := gap.NewScope(gap.User, appName)
-	path, err := userScope.ConfigPath(cfgFileName)
+	dir, err := knoxite.ConfigDir()
 	if err != nil {
 		return cfgFileName
 	}
+	path := filepath.Join(dir, cfgFileName)
+
+	if !exist(path) && os.Getenv(knoxite.EnvConfigDir) == "" {
+		if legacy, err := gap.NewScope(gap.User, appName).ConfigPath(cfgFileName); err == nil && exist(legacy) {
+			return legacy
+		}
+	}
 
 	return path
 }
@@ -157,11 +190,15 @@ func DefaultPath() string {
 // Otherwise we try to locate it following an OS dependant:
 //
 // Unix:
-//   - ~/.config/knoxite/knoxite.conf
+//   - $XDG_CONFIG_HOME/knoxite/knoxite.conf (~/.config/knoxite/knoxite.conf)
+//
 // macOS:
-//   - ~/Library/Preferences/knoxite/knoxite.conf
+//   - ~/Library/Application Support/knoxite/knoxite.conf
+//
 // Windows:
-//   - %LOCALAPPDATA%/knoxite/Config/knoxite.conf
+//   - %AppData%/knoxite/knoxite.conf
+//
+// The directory can be overridden with $KNOXITE_CONFIG_DIR.
 //
 // If no valid config file is found, an empty string is returned.
 func Lookup() string {
@@ -0,0 +1,152 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"github.com/knoxite/knoxite/cmd/knoxite/config"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+	"github.com/knoxite/knoxite/cmd/knoxite/utils"
+)
+
+// ConfigBundleOptions holds all the options of the config export and import
+// commands.
+type ConfigBundleOptions struct {
+	Password  string
+	Secrets   bool
+	Overwrite bool
+}
+
+var (
+	configBundleOpts = ConfigBundleOptions{}
+
+	configExportCmd = &cobra.Command{
+		Use:   "export [file]",
+		Short: "export the configuration as an encrypted bundle",
+		Long: `The export command writes all profiles, including their repository URLs,
+schedules and hooks, to a single file, compressed and encrypted with a password
+of your choice. Import it with 'knoxite config import' on another machine.
+
+With --secrets, the repository passwords saved in the OS keyring get exported,
+too. Files referenced by the profiles, like password files and private keys,
+never become part of the bundle`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return i18n.Errorf("export needs a file to write the bundle to")
+			}
+			return executeConfigExport(args[0], configBundleOpts)
+		},
+	}
+	configImportCmd = &cobra.Command{
+		Use:   "import [file]",
+		Short: "import a configuration bundle",
+		Long: `The import command adds the profiles of a bundle written by 'knoxite config
+export' to the configuration. Existing profiles only get replaced with
+--overwrite. Repository passwords contained in the bundle get saved in the OS
+keyring`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return i18n.Errorf("import needs a bundle to read")
+			}
+			return executeConfigImport(args[0], configBundleOpts)
+		},
+	}
+)
+
+func init() {
+	configExportCmd.Flags().StringVar(&configBundleOpts.Password, "bundle-password", "", "Password to encrypt the bundle with")
+	configExportCmd.Flags().BoolVar(&configBundleOpts.Secrets, "secrets", false, "Export the repository passwords saved in the OS keyring")
+	configImportCmd.Flags().StringVar(&configBundleOpts.Password, "bundle-password", "", "Password the bundle is encrypted with")
+	configImportCmd.Flags().BoolVar(&configBundleOpts.Overwrite, "overwrite", false, "Replace existing profiles with the same alias")
+	configCmd.AddCommand(configExportCmd)
+	configCmd.AddCommand(configImportCmd)
+}
+
+func executeConfigExport(path string, opts ConfigBundleOptions) error {
+	b := config.NewBundle(cfg)
+	b.Hostname, _ = os.Hostname()
+	if opts.Secrets {
+		for _, repo := range cfg.Repositories {
+			if repo.Url == "" {
+				continue
+			}
+			if password, ok := passwordFromKeyring(repo.Url); ok {
+				b.Secrets[keyringAccount(repo.Url)] = password
+			}
+		}
+	}
+
+	password := opts.Password
+	if password == "" {
+		var err error
+		password, err = utils.ReadPasswordTwice(i18n.Sprintf("Enter a password to encrypt the bundle with:"), i18n.Sprintf("Confirm password:"))
+		if err != nil {
+			return err
+		}
+	}
+	data, err := b.Encode(password)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return err
+	}
+
+	log.Printf("Exported %d profiles and %d passwords to %s", len(b.Repositories), len(b.Secrets), path)
+	return nil
+}
+
+func executeConfigImport(path string, opts ConfigBundleOptions) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	password := opts.Password
+	if password == "" {
+		password, err = utils.ReadPassword(i18n.Sprintf("Enter the password of the bundle:"))
+		if err != nil {
+			return err
+		}
+	}
+	b, err := config.DecodeBundle(data, password)
+	if err != nil {
+		return err
+	}
+
+	added, skipped := b.Merge(cfg, opts.Overwrite)
+	sort.Strings(added)
+	sort.Strings(skipped)
+	for _, alias := range skipped {
+		log.Warnf("Skipping profile %s, it already exists (use --overwrite to replace it)", alias)
+	}
+	for _, alias := range added {
+		repo := cfg.Repositories[alias]
+		for _, file := range []string{repo.PasswordFile, repo.PrivateKey} {
+			if file == "" {
+				continue
+			}
+			if _, err := os.Stat(file); err != nil {
+				log.Warnf("Profile %s refers to %s, which doesn't exist on this machine", alias, file)
+			}
+		}
+	}
+	if err := cfg.Save(); err != nil {
+		return err
+	}
+	for account, password := range b.Secrets {
+		savePasswordInKeyring(account, password)
+	}
+
+	log.Printf("Imported %d profiles from %s, exported on %s by %s", len(added), path, b.Created.Format(timeFormat), b.Hostname)
+	return nil
+}
@@ -0,0 +1,182 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"context"
+
+	shutdown "github.com/klauspost/shutdown2"
+	"github.com/spf13/cobra"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+	"github.com/knoxite/knoxite/cmd/knoxite/utils"
+)
+
+// CopyOptions holds all the options of the copy command.
+type CopyOptions struct {
+	From             string
+	To               string
+	ToPassword       string
+	ToVolume         string
+	FailureTolerance uint
+}
+
+var (
+	copyOpts = CopyOptions{}
+
+	copyCmd = &cobra.Command{
+		Use:   "copy [snapshot] [...]",
+		Short: "copy snapshots to another repository",
+		Long: `The copy command copies snapshots and all the data they refer to from one
+repository to another, e.g. to replicate selected snapshots offsite. The data
+gets re-encrypted with the key of the destination repository. Chunks already
+stored in the destination don't get transferred again.
+
+The snapshots keep their IDs and metadata, partial snapshots get copied as full
+snapshots. They get added to the volume of the destination given by
+--to-volume, by default to the volume named like the one they're stored in,
+which gets created if it doesn't exist`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return i18n.Errorf("copy needs to know which snapshots to copy")
+			}
+			if copyOpts.To == "" {
+				return i18n.Errorf("copy needs to know the destination repository, use --to")
+			}
+			if copyOpts.From == "" {
+				copyOpts.From = globalOpts.Repo
+			}
+			return executeCopy(args, copyOpts)
+		},
+	}
+)
+
+func init() {
+	copyCmd.Flags().StringVar(&copyOpts.From, "from", "", "Repository to copy the snapshots from (default: --repo)")
+	copyCmd.Flags().StringVar(&copyOpts.To, "to", "", "Repository to copy the snapshots to")
+	copyCmd.Flags().StringVar(&copyOpts.ToPassword, "to-password", "", "Password of the destination repository")
+	copyCmd.Flags().StringVar(&copyOpts.ToVolume, "to-volume", "", "Volume of the destination repository to add the snapshots to")
+	copyCmd.Flags().UintVarP(&copyOpts.FailureTolerance, "tolerance", "t", 0, "failure tolerance against n backend failures of the destination repository")
+	RootCmd.AddCommand(copyCmd)
+}
+
+// copyVolume returns the volume of dst the snapshots of the volume src get
+// copied to, creating it if necessary.
+func copyVolume(dst *knoxite.Repository, src *knoxite.Volume, opts CopyOptions) (*knoxite.Volume, error) {
+	if opts.ToVolume != "" {
+		return dst.FindVolume(opts.ToVolume)
+	}
+	for _, vol := range dst.Volumes {
+		if vol.Name == src.Name {
+			return vol, nil
+		}
+	}
+
+	vol, err := knoxite.NewVolume(src.Name, src.Description)
+	if err != nil {
+		return nil, err
+	}
+	if err := dst.AddVolume(vol); err != nil {
+		return nil, err
+	}
+	log.Printf("Created volume %s (%s) in %s", vol.Name, vol.ID, knoxite.RedactURL(opts.To))
+	return vol, nil
+}
+
+func executeCopy(snapshotIDs []string, opts CopyOptions) error {
+	src, err := openRepository(opts.From, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+	unlockSrc, err := lockRepository(&src, false)
+	if err != nil {
+		return err
+	}
+	defer unlockSrc()
+
+	password := opts.ToPassword
+	if password == "" {
+		password, err = utils.ReadPassword(i18n.Sprintf("Enter password of %s:", knoxite.RedactURL(opts.To)))
+		if err != nil {
+			return err
+		}
+	}
+	dst, err := openRepository(opts.To, password)
+	if err != nil {
+		return err
+	}
+	unlockDst, err := lockRepository(&dst, false)
+	if err != nil {
+		return err
+	}
+	defer unlockDst()
+
+	if len(dst.BackendManager().Backends)-int(opts.FailureTolerance) <= 0 {
+		return ErrRedundancyAmount
+	}
+	encryption, err := dst.DataEncryption(dst.Encryption())
+	if err != nil {
+		return err
+	}
+	index, err := knoxite.OpenChunkIndex(&dst)
+	if err != nil {
+		return err
+	}
+	copier, err := knoxite.NewCopier(&src, &dst, &index, knoxite.CopyOptions{
+		Encrypt:     encryption,
+		DataParts:   uint(len(dst.BackendManager().Backends) - int(opts.FailureTolerance)),
+		ParityParts: opts.FailureTolerance,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := shutdown.CancelCtx(context.Background())
+	defer cancel()
+
+	for _, id := range snapshotIDs {
+		srcVolume, snapshot, err := src.FindSnapshot(id)
+		if err != nil {
+			return i18n.Errorf("Loading snapshot %s failed: %v", id, err)
+		}
+		volume, err := copyVolume(&dst, srcVolume, opts)
+		if err != nil {
+			return err
+		}
+		if err := unlockVolume(volume); err != nil {
+			return err
+		}
+
+		copied, stats, err := copier.Snapshot(ctx, snapshot, volume)
+		if err == knoxite.ErrSnapshotExists {
+			log.Printf("Skipping snapshot %s, it already exists in %s", snapshot.ID, knoxite.RedactURL(opts.To))
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Printf("Aborted copying snapshot %s", snapshot.ID)
+				return nil
+			}
+			return i18n.Errorf("Copying snapshot %s failed: %v", snapshot.ID, err)
+		}
+
+		// acquire a shutdown lock. we don't want these next calls to be interrupted
+		lock := shutdown.Lock()
+		if lock == nil {
+			return nil
+		}
+		err = dst.Commit(&index)
+		lock()
+		if err != nil {
+			return err
+		}
+		log.Printf("Copied snapshot %s to volume %s: %d chunks, %s stored", copied.ID, volume.ID, stats.Chunks, knoxite.SizeToString(stats.StorageSize))
+	}
+	return nil
+}
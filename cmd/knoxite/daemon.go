@@ -0,0 +1,791 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	shutdown "github.com/klauspost/shutdown2"
+	"github.com/muesli/gotable"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+)
+
+// DaemonOptions holds all the options that can be set for the 'daemon'
+// command.
+type DaemonOptions struct {
+	Socket       string
+	Aliases      []string
+	StatusListen string
+	MaxJobs      uint
+	Bandwidth    string
+}
+
+// A daemonJob stores the snapshots of a profile according to its schedule.
+type daemonJob struct {
+	Alias     string    `json:"alias"`
+	Schedule  string    `json:"schedule"`
+	Next      time.Time `json:"next,omitempty"`
+	LastRun   time.Time `json:"last_run,omitempty"`
+	LastError string    `json:"last_error,omitempty"`
+	Running   bool      `json:"running"`
+	Queued    bool      `json:"queued"`
+
+	schedule knoxite.Schedule
+}
+
+// daemonState is what gets kept of a job between runs of the daemon.
+type daemonState struct {
+	LastRun   time.Time `json:"last_run"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// daemonRequest is a command sent to the control socket of a daemon.
+type daemonRequest struct {
+	Command string `json:"command"`
+	Alias   string `json:"alias,omitempty"`
+}
+
+// daemonResponse is the reply to a daemonRequest.
+type daemonResponse struct {
+	Jobs  []daemonJob `json:"jobs,omitempty"`
+	Error string      `json:"error,omitempty"`
+}
+
+// A runningJob is a job the daemon is running.
+type runningJob struct {
+	// share is the job's share of the bandwidth, relative to the other
+	// running jobs
+	share uint64
+	// setRate changes the upload rate of the job, process is the child
+	// process running it, if it doesn't run in the daemon itself
+	setRate func(rate uint64)
+	process *os.Process
+}
+
+// daemon runs the jobs of all scheduled profiles, up to maxJobs at a time.
+type daemon struct {
+	mut  sync.Mutex
+	jobs []*daemonJob
+
+	// statePath is where the outcome of the last runs gets kept
+	statePath string
+	// trigger receives the aliases of jobs to run right away, done wakes up
+	// the loop once a job finished
+	trigger chan string
+	done    chan struct{}
+
+	// maxJobs is the amount of jobs running at the same time, bandwidth the
+	// upload rate in bytes per second they share, 0 if it's unlimited
+	maxJobs   int
+	bandwidth uint64
+	// queue holds the jobs waiting for one of the running jobs to finish
+	queue   []*daemonJob
+	running map[*daemonJob]*runningJob
+
+	// progress is the progress of the running job, unless multiple jobs run
+	// at the same time, history the outcome of the recent runs
+	progress *jobProgress
+	history  []daemonRun
+}
+
+// jobLimiter limits the upload rate of the snapshots stored by a job of the
+// daemon, it's nil outside of the daemon.
+var jobLimiter *knoxite.RateLimiter
+
+var (
+	daemonOpts = DaemonOptions{}
+
+	daemonCmd = &cobra.Command{
+		Use:   "daemon",
+		Short: "store snapshots of all profiles according to their schedules",
+		Long: `The daemon command keeps running and stores snapshots of all configured profiles
+with a volume and store paths, according to their schedule. After storing a
+snapshot it forgets the snapshots, which the profile's retention policy doesn't
+keep, and verifies the repository like the store and forget commands do.
+
+Profiles need a password_file or password_command, unless all repositories share
+the password given in KNOXITE_PASSWORD. Use 'daemon status' and 'daemon run' to
+control a running daemon through its control socket.
+
+Jobs which are due while --max-jobs jobs are running already wait in a queue.
+Jobs running at the same time share the --bandwidth, according to the
+bandwidth_share of their profiles`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return executeDaemon(daemonOpts)
+		},
+	}
+	daemonStatusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "show the jobs of a running daemon",
+		Long:  `The status command shows when a running daemon stored the snapshots of each profile and when it's going to store the next ones`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return executeDaemonStatus(daemonOpts.Socket)
+		},
+	}
+	daemonRunCmd = &cobra.Command{
+		Use:   "run [alias]",
+		Short: "make a running daemon store a snapshot right away",
+		Long:  `The run command makes a running daemon store a snapshot of a profile right away`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return i18n.Errorf("run needs the alias of a profile")
+			}
+			return executeDaemonRun(daemonOpts.Socket, args[0])
+		},
+	}
+	daemonJobCmd = &cobra.Command{
+		Use:    "job [alias]",
+		Short:  "run a job of the daemon",
+		Long:   `The job command runs a job of a daemon running multiple jobs at the same time, reading its upload rate from stdin`,
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return i18n.Errorf("job needs the alias of a profile")
+			}
+			return executeDaemonJob(args[0])
+		},
+	}
+)
+
+func init() {
+	daemonCmd.PersistentFlags().StringVar(&daemonOpts.Socket, "socket", "", "path of the control socket (default: daemon.sock in knoxite's state directory)")
+	daemonCmd.Flags().StringArrayVar(&daemonOpts.Aliases, "profile", []string{}, "only run the jobs of the profile with this alias, can be given multiple times")
+	daemonCmd.Flags().StringVar(&daemonOpts.StatusListen, "status-listen", "", "address to serve a status page on, e.g. localhost:9143")
+	daemonCmd.Flags().UintVar(&daemonOpts.MaxJobs, "max-jobs", 1, "maximum amount of jobs running at the same time")
+	daemonCmd.Flags().StringVar(&daemonOpts.Bandwidth, "bandwidth", "", "upload rate shared by all running jobs per second, e.g. 10MB (default: unlimited)")
+	daemonCmd.AddCommand(daemonStatusCmd)
+	daemonCmd.AddCommand(daemonRunCmd)
+	daemonCmd.AddCommand(daemonJobCmd)
+	RootCmd.AddCommand(daemonCmd)
+}
+
+// daemonSocket returns the path of the control socket.
+func daemonSocket(path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	dir, err := knoxite.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "daemon.sock"), nil
+}
+
+func executeDaemon(opts DaemonOptions) error {
+	if opts.MaxJobs == 0 {
+		return i18n.Errorf("--max-jobs needs to be at least 1")
+	}
+	var bandwidth uint64
+	if opts.Bandwidth != "" {
+		var err error
+		bandwidth, err = humanize.ParseBytes(opts.Bandwidth)
+		if err != nil {
+			return i18n.Errorf("Invalid bandwidth %s: %v", opts.Bandwidth, err)
+		}
+	}
+
+	dir, err := knoxite.StateDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	d := &daemon{
+		statePath: filepath.Join(dir, "daemon.json"),
+		trigger:   make(chan string, 16),
+		done:      make(chan struct{}, 1),
+		maxJobs:   int(opts.MaxJobs),
+		bandwidth: bandwidth,
+		running:   make(map[*daemonJob]*runningJob),
+	}
+	if err := d.addJobs(opts.Aliases, time.Now()); err != nil {
+		return err
+	}
+
+	socket, err := daemonSocket(opts.Socket)
+	if err != nil {
+		return err
+	}
+	l, err := listenDaemon(socket)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	go d.serve(l)
+	if opts.StatusListen != "" {
+		if err := serveStatusPage(opts.StatusListen, d); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := shutdown.CancelCtx(context.Background())
+	defer cancel()
+	for _, job := range d.jobs {
+		if !job.Next.IsZero() {
+			log.Printf("Next snapshot of %s: %s", job.Alias, job.Next.Format(timeFormat))
+		}
+	}
+	log.Printf("Listening for commands on %s", socket)
+	return d.loop(ctx)
+}
+
+// addJobs adds a job for each profile with a volume and store paths. Only the
+// profiles in aliases get added, unless it's empty.
+func (d *daemon) addJobs(aliases []string, now time.Time) error {
+	state := make(map[string]daemonState)
+	if b, err := ioutil.ReadFile(d.statePath); err == nil {
+		if err := json.Unmarshal(b, &state); err != nil {
+			log.Warnf("Ignoring unreadable daemon state: %v", err)
+		}
+	}
+
+	names := aliases
+	if len(names) == 0 {
+		for alias := range cfg.Repositories {
+			names = append(names, alias)
+		}
+		sort.Strings(names)
+	}
+	for _, alias := range names {
+		rep, ok := cfg.Repositories[alias]
+		if !ok {
+			return i18n.Errorf("Unknown alias %s", alias)
+		}
+		if rep.Volume == "" || len(rep.StorePaths) == 0 {
+			if len(aliases) > 0 {
+				return i18n.Errorf("Profile %s needs a volume and store paths", alias)
+			}
+			continue
+		}
+		schedule, err := profileSchedule(rep)
+		if err != nil {
+			return i18n.Errorf("Invalid schedule of profile %s: %v", alias, err)
+		}
+
+		job := &daemonJob{
+			Alias:     alias,
+			Schedule:  rep.Schedule,
+			LastRun:   state[alias].LastRun,
+			LastError: state[alias].LastError,
+			schedule:  schedule,
+		}
+		last := job.LastRun
+		if last.IsZero() {
+			// wait for the first scheduled time, instead of storing a
+			// snapshot right away
+			last = now
+		}
+		job.Next = schedule.Next(last, now)
+		d.jobs = append(d.jobs, job)
+	}
+
+	if len(d.jobs) == 0 {
+		return i18n.Errorf("No profiles with a volume and store paths found, configure them with 'knoxite config set'")
+	}
+	return nil
+}
+
+// loop queues the jobs once they are due, or triggered through the control
+// socket, and runs them as soon as less than maxJobs jobs are running.
+func (d *daemon) loop(ctx context.Context) error {
+	for {
+		d.dispatch()
+		job := d.nextJob()
+		wait := 24 * time.Hour
+		if job != nil {
+			wait = time.Until(job.Next)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			d.interrupt()
+			return nil
+		case alias := <-d.trigger:
+			job = d.job(alias)
+		case <-d.done:
+			job = nil
+		case <-timer.C:
+		}
+		timer.Stop()
+
+		if job != nil {
+			d.enqueue(job)
+		}
+	}
+}
+
+// nextJob returns the job, which is due next.
+func (d *daemon) nextJob() *daemonJob {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	var next *daemonJob
+	for _, job := range d.jobs {
+		if job.Next.IsZero() || job.Running || job.Queued {
+			continue
+		}
+		if next == nil || job.Next.Before(next.Next) {
+			next = job
+		}
+	}
+	return next
+}
+
+// job returns the job of alias.
+func (d *daemon) job(alias string) *daemonJob {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	for _, job := range d.jobs {
+		if job.Alias == alias {
+			return job
+		}
+	}
+	return nil
+}
+
+// enqueue adds a job to the queue, unless it's queued or running already.
+func (d *daemon) enqueue(job *daemonJob) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if job.Running || job.Queued {
+		return
+	}
+	job.Queued = true
+	d.queue = append(d.queue, job)
+	if len(d.running) >= d.maxJobs {
+		log.Printf("Waiting for a running job to finish before storing snapshot of %s", job.Alias)
+	}
+}
+
+// dispatch starts the queued jobs in the order they got queued in, as long as
+// less than maxJobs jobs are running.
+func (d *daemon) dispatch() {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	for len(d.queue) > 0 && len(d.running) < d.maxJobs {
+		job := d.queue[0]
+		d.queue = d.queue[1:]
+		job.Queued = false
+		job.Running = true
+
+		share := uint64(1)
+		if rep := cfg.Repositories[job.Alias]; rep.BandwidthShare > 0 {
+			share = uint64(rep.BandwidthShare)
+		}
+		d.running[job] = &runningJob{share: share}
+		go d.run(job)
+	}
+}
+
+// rebalance divides the bandwidth between the running jobs according to
+// their shares.
+func (d *daemon) rebalance() {
+	var total uint64
+	for _, rj := range d.running {
+		total += rj.share
+	}
+	for _, rj := range d.running {
+		if rj.setRate == nil {
+			continue
+		}
+		var rate uint64
+		if d.bandwidth > 0 {
+			// never lift the limit by rounding down to 0
+			rate = d.bandwidth*rj.share/total + 1
+		}
+		rj.setRate(rate)
+	}
+}
+
+// interrupt asks the child processes running jobs to stop.
+func (d *daemon) interrupt() {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	for _, rj := range d.running {
+		if rj.process != nil {
+			_ = rj.process.Signal(os.Interrupt)
+		}
+	}
+}
+
+// run stores a snapshot of the job's profile and schedules the next one.
+func (d *daemon) run(job *daemonJob) {
+	start := time.Now()
+	d.mut.Lock()
+	inProcess := d.maxJobs == 1
+	if inProcess {
+		d.progress = &jobProgress{Alias: job.Alias, Start: start}
+		currentProgress = d.progress
+	}
+	d.mut.Unlock()
+
+	log.Printf("Storing snapshot of %s", job.Alias)
+	var err error
+	if inProcess {
+		err = d.runInProcess(job)
+	} else {
+		err = d.runChild(job)
+	}
+	if err != nil {
+		log.Errorf("Storing snapshot of %s failed: %v", job.Alias, err)
+	} else {
+		log.Printf("Stored snapshot of %s in %s", job.Alias, time.Since(start).Round(time.Second))
+	}
+
+	d.mut.Lock()
+	job.Running = false
+	delete(d.running, job)
+	d.rebalance()
+	job.LastRun = start
+	job.LastError = ""
+	if err != nil {
+		job.LastError = err.Error()
+	}
+	if inProcess {
+		d.progress = nil
+		currentProgress = nil
+	}
+	d.history = append(d.history, daemonRun{job.Alias, start, time.Since(start), job.LastError})
+	if len(d.history) > maxHistory {
+		d.history = d.history[1:]
+	}
+	job.Next = job.schedule.Next(job.LastRun, time.Now())
+	if !job.Next.IsZero() {
+		log.Printf("Next snapshot of %s: %s", job.Alias, job.Next.Format(timeFormat))
+	}
+	serr := d.saveState()
+	d.mut.Unlock()
+	if serr != nil {
+		log.Warnf("Writing daemon state failed: %v", serr)
+	}
+
+	select {
+	case d.done <- struct{}{}:
+	default:
+	}
+}
+
+// runInProcess runs a job in the daemon itself, with all of the bandwidth.
+func (d *daemon) runInProcess(job *daemonJob) error {
+	jobLimiter = knoxite.NewRateLimiter(d.bandwidth)
+	defer func() {
+		jobLimiter = nil
+	}()
+	return runProfile(job.Alias)
+}
+
+// runChild runs a job in a child process, which gets sent its upload rate on
+// stdin whenever it changes. Its output gets prefixed with the job's alias.
+func (d *daemon) runChild(job *daemonJob) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	var args []string
+	// the child uses the same configuration, log level and passwords
+	RootCmd.PersistentFlags().VisitAll(func(f *pflag.Flag) {
+		if f.Changed {
+			args = append(args, "--"+f.Name+"="+f.Value.String())
+		}
+	})
+	cmd := exec.Command(exe, append(args, "daemon", "job", job.Alias)...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	for _, out := range []struct {
+		pipe func() (io.ReadCloser, error)
+		w    io.Writer
+	}{{cmd.StdoutPipe, os.Stdout}, {cmd.StderrPipe, os.Stderr}} {
+		r, err := out.pipe()
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func(r io.Reader, w io.Writer) {
+			defer wg.Done()
+			scanner := bufio.NewScanner(r)
+			for scanner.Scan() {
+				fmt.Fprintf(w, "%s: %s\n", job.Alias, scanner.Text())
+			}
+		}(r, out.w)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	d.mut.Lock()
+	rj := d.running[job]
+	rj.process = cmd.Process
+	rj.setRate = func(rate uint64) {
+		_, _ = fmt.Fprintln(stdin, rate)
+	}
+	d.rebalance()
+	d.mut.Unlock()
+
+	// all output needs to be read before waiting for the child
+	wg.Wait()
+	if err := cmd.Wait(); err != nil {
+		return i18n.Errorf("Job failed: %v", err)
+	}
+	return nil
+}
+
+// executeDaemonJob runs a job in a child process of the daemon. The daemon
+// sends the upload rate of the job on stdin, whenever it changes.
+func executeDaemonJob(alias string) error {
+	jobLimiter = knoxite.NewRateLimiter(0)
+	scanner := bufio.NewScanner(os.Stdin)
+	// wait for the initial rate, before uploading anything
+	if scanner.Scan() {
+		rate, _ := strconv.ParseUint(scanner.Text(), 10, 64)
+		jobLimiter.SetRate(rate)
+	}
+	go func() {
+		for scanner.Scan() {
+			rate, err := strconv.ParseUint(scanner.Text(), 10, 64)
+			if err == nil {
+				jobLimiter.SetRate(rate)
+			}
+		}
+	}()
+
+	return runProfile(alias)
+}
+
+// saveState keeps the outcome of the last runs, so the schedules continue
+// where they left off after a restart.
+func (d *daemon) saveState() error {
+	state := make(map[string]daemonState)
+	for _, job := range d.jobs {
+		state[job.Alias] = daemonState{LastRun: job.LastRun, LastError: job.LastError}
+	}
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(d.statePath, b, 0600)
+}
+
+// runProfile stores a snapshot of a profile, forgets the snapshots its
+// retention policy doesn't keep and verifies the repository, if configured.
+func runProfile(alias string) error {
+	// every profile starts from the options given on the command line
+	saved := globalOpts
+	defer func() {
+		globalOpts = saved
+	}()
+	if !applyProfile(alias) {
+		return i18n.Errorf("Unknown alias %s", alias)
+	}
+	rep := cfg.Repositories[alias]
+
+	// the defaults of the store command's flags, overridden by the profile
+	opts := storeOpts
+	configureStoreOpts(storeCmd, &opts)
+	if err := executeStore(rep.Volume, rep.StorePaths, opts); err != nil {
+		return err
+	}
+	if err := autoVerify(false); err != nil {
+		return err
+	}
+
+	if rep.Keep == "" && len(rep.KeepPaths) == 0 {
+		return nil
+	}
+	forget := SnapshotForgetOptions{
+		Keep:      rep.Keep,
+		KeepPaths: rep.KeepPaths,
+		Tags:      rep.Tags,
+	}
+	if err := executeSnapshotForget(rep.Volume, forget); err != nil {
+		return err
+	}
+	return autoVerify(true)
+}
+
+// listenDaemon listens on the control socket. A socket left behind by a daemon
+// which didn't shut down cleanly gets replaced.
+func listenDaemon(socket string) (net.Listener, error) {
+	if c, err := net.Dial("unix", socket); err == nil {
+		c.Close()
+		return nil, i18n.Errorf("Another daemon is listening on %s already", socket)
+	}
+	_ = os.Remove(socket)
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// serve answers the requests sent to the control socket.
+func (d *daemon) serve(l net.Listener) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go d.handle(c)
+	}
+}
+
+func (d *daemon) handle(c net.Conn) {
+	defer c.Close()
+
+	var req daemonRequest
+	var resp daemonResponse
+	if err := json.NewDecoder(bufio.NewReader(c)).Decode(&req); err != nil {
+		resp.Error = err.Error()
+	} else {
+		resp = d.request(req)
+	}
+	_ = json.NewEncoder(c).Encode(resp)
+}
+
+func (d *daemon) request(req daemonRequest) daemonResponse {
+	switch req.Command {
+	case "status":
+		return daemonResponse{Jobs: d.status()}
+
+	case "run":
+		for _, job := range d.status() {
+			if job.Alias != req.Alias {
+				continue
+			}
+			if job.Running {
+				return daemonResponse{Error: i18n.Sprintf("A snapshot of %s is being stored already", req.Alias)}
+			}
+			if job.Queued {
+				return daemonResponse{Error: i18n.Sprintf("A snapshot of %s is queued already", req.Alias)}
+			}
+			select {
+			case d.trigger <- req.Alias:
+				return daemonResponse{}
+			default:
+				return daemonResponse{Error: i18n.Sprintf("Too many pending requests")}
+			}
+		}
+		return daemonResponse{Error: i18n.Sprintf("Unknown alias %s", req.Alias)}
+	}
+
+	return daemonResponse{Error: i18n.Sprintf("Unknown command %s", req.Command)}
+}
+
+// status returns a copy of all jobs.
+func (d *daemon) status() []daemonJob {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	jobs := make([]daemonJob, 0, len(d.jobs))
+	for _, job := range d.jobs {
+		jobs = append(jobs, *job)
+	}
+	return jobs
+}
+
+// requestDaemon sends a request to the daemon listening on socket.
+func requestDaemon(socket string, req daemonRequest) (daemonResponse, error) {
+	var resp daemonResponse
+	socket, err := daemonSocket(socket)
+	if err != nil {
+		return resp, err
+	}
+	c, err := net.Dial("unix", socket)
+	if err != nil {
+		return resp, i18n.Errorf("Connecting to the daemon failed: %v", err)
+	}
+	defer c.Close()
+
+	if err := json.NewEncoder(c).Encode(req); err != nil {
+		return resp, err
+	}
+	if err := json.NewDecoder(c).Decode(&resp); err != nil {
+		return resp, err
+	}
+	if resp.Error != "" {
+		return resp, i18n.Errorf("%s", resp.Error)
+	}
+	return resp, nil
+}
+
+func executeDaemonStatus(socket string) error {
+	resp, err := requestDaemon(socket, daemonRequest{Command: "status"})
+	if err != nil {
+		return err
+	}
+	if globalOpts.JSON {
+		return printJSON(resp.Jobs)
+	}
+
+	tab := gotable.NewTable([]string{"Alias", "Schedule", "Last Run", "Result", "Next Run"},
+		[]int64{-16, -16, -19, -32, -19}, "No jobs found.")
+	for _, job := range resp.Jobs {
+		last, result, next := "never", "", "-"
+		if !job.LastRun.IsZero() {
+			last = job.LastRun.Format(timeFormat)
+			result = "ok"
+			if job.LastError != "" {
+				result = job.LastError
+			}
+		}
+		if job.Running {
+			result = "running"
+		} else if job.Queued {
+			result = "queued"
+		}
+		if !job.Next.IsZero() {
+			next = job.Next.Format(timeFormat)
+		}
+		tab.AppendRow([]interface{}{job.Alias, job.Schedule, last, result, next})
+	}
+
+	_ = tab.Print()
+	return nil
+}
+
+func executeDaemonRun(socket, alias string) error {
+	if _, err := requestDaemon(socket, daemonRequest{Command: "run", Alias: alias}); err != nil {
+		return err
+	}
+
+	log.Printf("Storing snapshot of %s", alias)
+	return nil
+}
@@ -0,0 +1,163 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	shutdown "github.com/klauspost/shutdown2"
+	"github.com/spf13/cobra"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+)
+
+// DiffOptions holds all the options of the diff command.
+type DiffOptions struct {
+	Local    bool
+	Content  bool
+	Excludes []string
+}
+
+var (
+	diffOpts = DiffOptions{}
+
+	diffCmd = &cobra.Command{
+		Use:   "diff [snapshot-a] [snapshot-b]",
+		Short: "show changes between two snapshots",
+		Long: `The diff command lists all files which have been added, removed or modified
+between two snapshots. Files are compared by their stored size, mode,
+modification time and content.
+
+With --local, the snapshot gets compared with the files currently found at the
+given paths, or at the paths it has been created from, i.e. what the next
+snapshot would contain. Files are compared by their size, mode and
+modification time. With --content, files of the same size get read and
+compared by their content, too. Run it from the directory the snapshot has been
+stored from, as paths below it are stored relative to it`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if diffOpts.Local {
+				if len(args) < 1 {
+					return i18n.Errorf("diff needs the ID of a snapshot to compare with the local files")
+				}
+				return executeDiffLocal(args[0], args[1:], diffOpts)
+			}
+			if len(args) != 2 {
+				return i18n.Errorf("diff needs the IDs of two snapshots to compare")
+			}
+			return executeDiff(args[0], args[1])
+		},
+	}
+)
+
+func init() {
+	diffCmd.Flags().BoolVar(&diffOpts.Local, "local", false, "compare a snapshot with the local files")
+	diffCmd.Flags().BoolVar(&diffOpts.Content, "content", false, "compare the content of local files of the same size")
+	diffCmd.Flags().StringArrayVarP(&diffOpts.Excludes, "excludes", "x", []string{}, "list of excludes")
+	RootCmd.AddCommand(diffCmd)
+}
+
+func executeDiff(snapshotA, snapshotB string) error {
+	repository, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+
+	_, a, err := repository.FindSnapshot(snapshotA)
+	if err != nil {
+		return err
+	}
+	_, b, err := repository.FindSnapshot(snapshotB)
+	if err != nil {
+		return err
+	}
+	if a, err = a.Merged(&repository); err != nil {
+		return err
+	}
+	if b, err = b.Merged(&repository); err != nil {
+		return err
+	}
+
+	return printDiffs(knoxite.DiffSnapshots(a, b))
+}
+
+func executeDiffLocal(snapshotID string, paths []string, opts DiffOptions) error {
+	repository, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+
+	_, snapshot, err := repository.FindSnapshot(snapshotID)
+	if err != nil {
+		return err
+	}
+	if snapshot, err = snapshot.Merged(&repository); err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		paths = snapshot.Paths
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	targets := []string{}
+	for _, path := range paths {
+		if absPath, err := filepath.Abs(path); err == nil {
+			path = absPath
+		}
+		targets = append(targets, path)
+	}
+
+	excludes := opts.Excludes
+	if rep, ok := cfg.Repositories[globalOpts.Alias]; ok && len(excludes) == 0 {
+		excludes = rep.StoreExcludes
+	}
+	ctx, cancel := shutdown.CancelCtx(context.Background())
+	defer cancel()
+
+	diffs, err := knoxite.DiffLocal(ctx, snapshot, knoxite.StoreOptions{
+		CWD:           wd,
+		Paths:         targets,
+		Excludes:      excludes,
+		ExcludeCaches: true,
+	}, opts.Content)
+	if err != nil {
+		return err
+	}
+	return printDiffs(diffs)
+}
+
+// printDiffs prints the changes found by diff, one per line.
+func printDiffs(diffs []knoxite.ArchiveDiff) error {
+	if globalOpts.JSON {
+		return printJSON(diffs)
+	}
+
+	var added, removed, modified int
+	for _, d := range diffs {
+		switch d.Change {
+		case knoxite.Added:
+			added++
+			fmt.Printf("+ %s\n", d.Path)
+		case knoxite.Removed:
+			removed++
+			fmt.Printf("- %s\n", d.Path)
+		case knoxite.Modified:
+			modified++
+			fmt.Printf("M %s (%s)\n", d.Path, strings.Join(d.Fields, ", "))
+		}
+	}
+	log.Printf("%d added, %d removed, %d modified", added, removed, modified)
+
+	return nil
+}
@@ -0,0 +1,77 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"context"
+	"strings"
+
+	shutdown "github.com/klauspost/shutdown2"
+	"github.com/spf13/cobra"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+)
+
+var (
+	repoHealCmd = &cobra.Command{
+		Use:   "heal",
+		Short: "copy data to storage backends, which missed it while they were unavailable",
+		Long: `The heal command copies all chunks and snapshots, which storage backends of the
+repository missed while they were unavailable, from the other backends. knoxite
+keeps track of this data and also heals backends automatically, the next time
+it stores a snapshot`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return executeRepoHeal()
+		},
+	}
+)
+
+func init() {
+	repoCmd.AddCommand(repoHealCmd)
+}
+
+func executeRepoHeal() error {
+	r, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockRepository(&r, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if len(r.BackendManager().Debts()) == 0 {
+		log.Printf("All storage backends are up to date")
+		return nil
+	}
+	return healRepository(&r)
+}
+
+// healRepository copies the data storage backends missed to them.
+func healRepository(r *knoxite.Repository) error {
+	ctx, cancel := shutdown.CancelCtx(context.Background())
+	defer cancel()
+
+	report, err := r.Heal(ctx)
+	if err != nil {
+		return err
+	}
+	log.Printf("Copied %d chunks and %d snapshots to lagging storage backends", report.Chunks, report.Snapshots)
+
+	if len(report.Lagging) > 0 {
+		var locations []string
+		for _, l := range report.Lagging {
+			locations = append(locations, knoxite.RedactURL(l))
+		}
+		return i18n.Errorf("%s still miss data, run 'repo heal' once they are available",
+			strings.Join(locations, ", "))
+	}
+	return nil
+}
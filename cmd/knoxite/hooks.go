@@ -0,0 +1,103 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+)
+
+// Hooks get run around storing a snapshot.
+const (
+	hookPre         = "pre"
+	hookPostSuccess = "post-success"
+	hookPostFailure = "post-failure"
+)
+
+// shellCommand returns a command running command in the system's shell.
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("sh", "-c", command)
+}
+
+// safeEnv returns knoxite's environment without the passwords, for the
+// commands it runs.
+func safeEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "KNOXITE_PASSWORD=") || strings.HasPrefix(kv, "KNOXITE_VOLUME_PASSWORD=") {
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+// hookEnv returns the environment of a hook. Besides the variables describing
+// the snapshot run, hooks inherit knoxite's environment, except for the
+// passwords.
+func hookEnv(hook, volume string, snapshot *knoxite.Snapshot, start time.Time, err error) []string {
+	env := append(safeEnv(),
+		"KNOXITE_HOOK="+hook,
+		"KNOXITE_REPOSITORY="+knoxite.RedactURL(globalOpts.Repo),
+		"KNOXITE_VOLUME="+volume,
+	)
+	if hook == hookPre {
+		return env
+	}
+
+	env = append(env, fmt.Sprintf("KNOXITE_DURATION=%d", int64(time.Since(start).Seconds())))
+	if err != nil {
+		env = append(env, "KNOXITE_ERROR="+err.Error())
+	}
+	if snapshot != nil {
+		env = append(env,
+			"KNOXITE_SNAPSHOT="+snapshot.ID,
+			fmt.Sprintf("KNOXITE_FILES=%d", snapshot.Stats.Files),
+			fmt.Sprintf("KNOXITE_DIRS=%d", snapshot.Stats.Dirs),
+			fmt.Sprintf("KNOXITE_SYMLINKS=%d", snapshot.Stats.SymLinks),
+			fmt.Sprintf("KNOXITE_SIZE=%d", snapshot.Stats.Size),
+			fmt.Sprintf("KNOXITE_STORAGE_SIZE=%d", snapshot.Stats.StorageSize),
+			fmt.Sprintf("KNOXITE_TRANSFERRED=%d", snapshot.Stats.Transferred),
+			fmt.Sprintf("KNOXITE_ERRORS=%d", snapshot.Stats.Errors),
+		)
+	}
+	return env
+}
+
+// runHook runs the command of a hook in the system's shell, unless it's
+// empty.
+func runHook(hook, command, volume string, snapshot *knoxite.Snapshot, start time.Time, err error) error {
+	if command == "" {
+		return nil
+	}
+
+	cmd := shellCommand(command)
+	cmd.Env = hookEnv(hook, volume, snapshot, start, err)
+	cmd.Stdout = os.Stdout
+	if globalOpts.JSON {
+		// keep stdout machine-readable
+		cmd.Stdout = os.Stderr
+	}
+	cmd.Stderr = os.Stderr
+
+	log.Infof("Running %s hook: %s", hook, command)
+	if err := cmd.Run(); err != nil {
+		return i18n.Errorf("Running %s hook failed: %v", hook, err)
+	}
+	return nil
+}
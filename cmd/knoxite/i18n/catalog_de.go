@@ -0,0 +1,439 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package i18n
+
+// German translations.
+var de = map[string]string{
+	// log levels
+	"Fatal":   "Schwerer Fehler",
+	"Error":   "Fehler",
+	"Warning": "Warnung",
+	"Print":   "Ausgabe",
+	"Info":    "Info",
+	"Debug":   "Debug",
+
+	// progress & summary
+	"Files":                    "Dateien",
+	"Dirs":                     "Verzeichnisse",
+	"SymLinks":                 "SymLinks",
+	"Errors":                   "Fehler",
+	"Original Size":            "Originalgröße",
+	"Storage Size":             "Speichergröße",
+	"Duration":                 "Dauer",
+	"Speed":                    "Geschwindigkeit",
+	"Total":                    "Gesamt",
+	"%s / %s (%s of %s)  %s/s": "%s / %s (%s von %s)  %s/s",
+	"%s  failed: %v":           "%s  fehlgeschlagen: %v",
+	"... and %d more":          "... und %d weitere",
+	"Aborting...":              "Breche ab...",
+
+	// store, restore & verify
+	"Snapshot %s created":                         "Snapshot %s erstellt",
+	"'%s': failed to store: %v":                   "'%s': Speichern fehlgeschlagen: %v",
+	"'%s' failed to restore: %v":                  "'%s': Wiederherstellen fehlgeschlagen: %v",
+	"All symlinks point inside the restored tree": "Alle SymLinks zeigen in den wiederhergestellten Verzeichnisbaum",
+	"%d symlinks need to be checked:":             "%d SymLinks müssen überprüft werden:",
+	"'%s' -> '%s': %s":                            "'%s' -> '%s': %s",
+	"points outside of the restored tree":         "zeigt aus dem wiederhergestellten Verzeichnisbaum heraus",
+	"target does not exist":                       "Ziel existiert nicht",
+	"Verify failed: %v":                           "Überprüfung fehlgeschlagen: %v",
+	"Verify repository done: %d errors":           "Überprüfung des Repositorys abgeschlossen: %d Fehler",
+	"Verify volume done: %d errors":               "Überprüfung des Volumes abgeschlossen: %d Fehler",
+	"Verify snapshot done: %d errors":             "Überprüfung des Snapshots abgeschlossen: %d Fehler",
+	"failure tolerance can't be equal or higher as the number of storage backends": "die Fehlertoleranz muss kleiner als die Anzahl der Speicher-Backends sein",
+	"please specify a directory to restore to":                                     "bitte gib ein Verzeichnis zum Wiederherstellen an",
+
+	// repositories, volumes & snapshots
+	"Created new repository at %s":         "Neues Repository in %s erstellt",
+	"Creating repository at %s failed: %v": "Erstellen des Repositorys in %s fehlgeschlagen: %v",
+	"Changed password successfully":        "Passwort erfolgreich geändert",
+	"Added %s to repository":               "%s zum Repository hinzugefügt",
+	"Freed storage space: %s":              "Freigegebener Speicherplatz: %s",
+	"Volume %s (%s) created":               "Volume %s (%s) erstellt",
+	"Creating volume %s failed: %v":        "Erstellen des Volumes %s fehlgeschlagen: %v",
+	"Volume %s '%s' successfully removed":  "Volume %s '%s' erfolgreich entfernt",
+	"Snapshot %s removed: %s":              "Snapshot %s entfernt: %s",
+	"Do not forget to run 'repo pack' to delete un-referenced chunks and free up storage space!": "Vergiss nicht 'repo pack' auszuführen, um nicht mehr referenzierte Chunks zu löschen und Speicherplatz freizugeben!",
+	"%s: No such file or directory": "%s: Datei oder Verzeichnis nicht gefunden",
+
+	// mount
+	"Mountpoint %s doesn't exist, creating it": "Einhängepunkt %s existiert nicht, wird erstellt",
+	"Updating index":      "Aktualisiere Index",
+	"Updating index done": "Index aktualisiert",
+	"Error umounting: %s": "Fehler beim Aushängen: %s",
+
+	// configuration
+	"Writing configuration file to: %s":                                         "Schreibe Konfigurationsdatei nach: %s",
+	"Error loading the specified alias":                                         "Fehler beim Laden des angegebenen Alias",
+	"Error reading the config file: %v":                                         "Fehler beim Lesen der Konfigurationsdatei: %v",
+	"Error parsing the toml config file at '%s': %v":                            "Fehler beim Parsen der TOML-Konfigurationsdatei '%s': %v",
+	`Error setting log level "%s": %s. Using default log level Info instead.`:   `Fehler beim Setzen des Log-Levels "%s": %s. Verwende stattdessen den Standard-Log-Level Info.`,
+	"Specify either repository directory '-r' or an alias '-R'":                 "Gib entweder ein Repository-Verzeichnis '-r' oder einen Alias '-R' an",
+	"Specify either quiet '-q' or verbose '-v' output":                          "Gib entweder stille '-q' oder ausführliche '-v' Ausgabe an",
+	"No alias with name %s found":                                               "Kein Alias mit dem Namen %s gefunden",
+	"Unknown configuration option: %s":                                          "Unbekannte Konfigurationsoption: %s",
+	"Failed to convert %s to uint for the fault tolerance option: %v":           "Konnte %s für die Fehlertoleranz-Option nicht in uint umwandeln: %v",
+	"config set needs to work on an alias and a option like this: alias.option": "config set benötigt einen Alias und eine Option in der Form: alias.option",
+
+	// stats & reports
+	"Snapshots:          %d": "Snapshots:           %d",
+	"Original size:      %s": "Originalgröße:       %s",
+	"Chunks:             %d": "Chunks:              %d",
+	"Stored size:        %s (deduplication & compression ratio %.2f)": "Gespeicherte Größe:  %s (Deduplizierungs- & Kompressionsrate %.2f)",
+	"Parity overhead:    %s":                                              "Paritäts-Mehrbedarf: %s",
+	"Unreferenced:       %s (released by 'repo pack')":                    "Nicht referenziert:  %s (wird von 'repo pack' freigegeben)",
+	"Skipping metadata of snapshot %s of locked volume %s":                "Überspringe Metadaten des Snapshots %s im gesperrten Volume %s",
+	"Compared snapshots: %d":                                              "Verglichene Snapshots: %d",
+	"Compared chunks:    %d":                                              "Verglichene Chunks:    %d",
+	"The repository metadata differs":                                     "Die Metadaten der Repositorys unterscheiden sich",
+	"The chunk-index differs":                                             "Der Chunk-Index unterscheidet sich",
+	"Snapshot %s is missing in %s":                                        "Snapshot %s fehlt in %s",
+	"Snapshot %s differs":                                                 "Snapshot %s unterscheidet sich",
+	"Chunk %s is missing in %s":                                           "Chunk %s fehlt in %s",
+	"Chunk %s differs in size":                                            "Chunk %s unterscheidet sich in der Größe",
+	"Sizes of chunks could not be compared, only checked that they exist": "Die Größen der Chunks konnten nicht verglichen werden, es wurde nur geprüft, ob sie existieren",
+	"Both repositories store the same data":                               "Beide Repositorys speichern dieselben Daten",
+	"The repositories differ":                                             "Die Repositorys unterscheiden sich",
+	"%d added, %d removed, %d modified":                                   "%d hinzugefügt, %d entfernt, %d geändert",
+
+	// store & watch
+	"'%s': failed to read: %v": "'%s': Lesen fehlgeschlagen: %v",
+	"'%s': flagged by %s: %s":  "'%s': von %s markiert: %s",
+	"Dry run: would store %d items in %d new chunks, taking %s of storage space": "Probelauf: würde %d Einträge in %d neuen Chunks speichern und %s Speicherplatz belegen",
+	"Files since snapshot %s: %d new, %d changed, %d unmodified, %d removed":     "Dateien seit Snapshot %s: %d neu, %d geändert, %d unverändert, %d entfernt",
+	"Resuming interrupted snapshot %s":                                           "Setze unterbrochenen Snapshot %s fort",
+	"Ignoring unreadable checkpoint: %v":                                         "Ignoriere unlesbaren Checkpoint: %v",
+	"Writing checkpoint failed: %v":                                              "Schreiben des Checkpoints fehlgeschlagen: %v",
+	"Removing checkpoint failed: %v":                                             "Entfernen des Checkpoints fehlgeschlagen: %v",
+	"Run the same command again to resume storing this snapshot":                 "Führe denselben Befehl erneut aus, um das Speichern dieses Snapshots fortzusetzen",
+	"Loading baseline snapshot %s failed: %v":                                    "Laden des Basis-Snapshots %s fehlgeschlagen: %v",
+	"Not using the change cache: %v":                                             "Verwende den Änderungs-Cache nicht: %v",
+	"Saving the change cache failed: %v":                                         "Speichern des Änderungs-Caches fehlgeschlagen: %v",
+	"Invalid redaction %s, expected host[=strip], owner or path=[name]":          "Ungültige Schwärzung %s, erwartet wird host[=strip], owner oder path=[name]",
+	"Invalid processor %s, expected name=command":                                "Ungültiger Prozessor %s, erwartet wird name=command",
+	"Running %s hook: %s":                                                        "Führe %s-Hook aus: %s",
+	"Running %s hook failed: %v":                                                 "Ausführen des %s-Hooks fehlgeschlagen: %v",
+	"storing the snapshot has been aborted":                                      "das Speichern des Snapshots wurde abgebrochen",
+	"Watching %s for changes":                                                    "Überwache %s auf Änderungen",
+	"Watching %s failed: %v":                                                     "Überwachen von %s fehlgeschlagen: %v",
+	"Watching for changes failed: %v":                                            "Überwachen auf Änderungen fehlgeschlagen: %v",
+	"Changed: %s":                                                                "Geändert: %s",
+	"Storing changes of %s":                                                      "Speichere Änderungen von %s",
+	"Storing changes failed: %v":                                                 "Speichern der Änderungen fehlgeschlagen: %v",
+	"The quiet period needs to be positive":                                      "Die Ruhezeit muss positiv sein",
+
+	// restore
+	"Restoring %d files, %d directories and %d symlinks (%s) to %s": "Stelle %d Dateien, %d Verzeichnisse und %d SymLinks (%s) nach %s wieder her",
+	"Downloading %s in %d chunks":                                   "Lade %s in %d Chunks herunter",
+	"    %s from %s":                                                "    %s von %s",
+	"Start restoring?":                                              "Wiederherstellung starten?",
+	"Restore aborted":                                               "Wiederherstellung abgebrochen",
+	"Snapshot %s isn't tagged with %s":                              "Snapshot %s ist nicht mit %s markiert",
+	"Can't tell the archive format of %s, use --archive-format":     "Das Archivformat von %s ist nicht erkennbar, verwende --archive-format",
+	"invalid size %s: %v":                                           "ungültige Größe %s: %v",
+	"%s: Is a directory":                                            "%s: Ist ein Verzeichnis",
+
+	// verify & repair
+	"Verifying repository...":             "Überprüfe Repository...",
+	"Verify repository done: no errors":   "Überprüfung des Repositorys abgeschlossen: keine Fehler",
+	"Verify repository failed: %d errors": "Überprüfung des Repositorys fehlgeschlagen: %d Fehler",
+	"Skipping verification, the repository's private key is required to read its data":   "Überspringe Überprüfung, zum Lesen der Daten wird der private Schlüssel des Repositorys benötigt",
+	"Can't list the chunks stored in %s, skipped checking for missing & orphaned chunks": "Die in %s gespeicherten Chunks können nicht aufgelistet werden, fehlende & verwaiste Chunks wurden nicht gesucht",
+	"Chunk %s is missing":                                           "Chunk %s fehlt",
+	"Chunk %s is missing in the chunk-index":                        "Chunk %s fehlt im Chunk-Index",
+	"Chunk %s isn't referenced by any snapshot":                     "Chunk %s wird von keinem Snapshot referenziert",
+	"Chunk %s is indexed for snapshots that don't reference it":     "Chunk %s ist für Snapshots indiziert, die ihn nicht referenzieren",
+	"Snapshot %s can't be read: %s":                                 "Snapshot %s kann nicht gelesen werden: %s",
+	"Repaired":                                                      "Repariert",
+	"Can repair":                                                    "Reparierbar",
+	"%s missing part %d of chunk %s":                                "%s: fehlender Teil %d von Chunk %s",
+	"%s corrupt part %d of chunk %s":                                "%s: beschädigter Teil %d von Chunk %s",
+	"Chunk %s can't be repaired: %s":                                "Chunk %s kann nicht repariert werden: %s",
+	"Repair done: %d chunks checked, %d repaired, %d unrepairable":  "Reparatur abgeschlossen: %d Chunks überprüft, %d repariert, %d nicht reparierbar",
+	"%s still miss data, run 'repo heal' once they are available":   "%s fehlen noch Daten, führe 'repo heal' aus, sobald sie verfügbar sind",
+	"All storage backends are up to date":                           "Alle Speicher-Backends sind auf dem neuesten Stand",
+	"Copied %d chunks and %d snapshots to lagging storage backends": "%d Chunks und %d Snapshots auf zurückliegende Speicher-Backends kopiert",
+
+	// salvage
+	"Couldn't open repository (%v), recreating it from the data key": "Repository konnte nicht geöffnet werden (%v), stelle es aus dem Datenschlüssel wieder her",
+	"Key fingerprint: %s":                      "Fingerabdruck des Schlüssels: %s",
+	"Orphaned chunks with a chunk header: %d":  "Verwaiste Chunks mit Chunk-Header: %d",
+	"Added %d snapshots to volume %s":          "%d Snapshots zum Volume %s hinzugefügt",
+	"Readable snapshots:     %d":               "Lesbare Snapshots:             %d",
+	"Unreferenced snapshots: %d":               "Nicht referenzierte Snapshots: %d",
+	"Recoverable archives:   %d":               "Wiederherstellbare Archive:    %d",
+	"Damaged archives:       %d":               "Beschädigte Archive:           %d",
+	"Orphaned chunks:        %d":               "Verwaiste Chunks:              %d",
+	"Archive %s in snapshot %s is damaged: %s": "Archiv %s in Snapshot %s ist beschädigt: %s",
+	"Stored data could not be listed for %s, only snapshots referenced by volumes were checked": "Die gespeicherten Daten von %s konnten nicht aufgelistet werden, nur von Volumes referenzierte Snapshots wurden überprüft",
+
+	// snapshots & retention
+	"Removing snapshot %s (%s)":                                   "Entferne Snapshot %s (%s)",
+	"Removing %d items from snapshot %s (%s)":                     "Entferne %d Einträge aus Snapshot %s (%s)",
+	"Kept %d snapshots, removed %d and pruned %d":                 "%d Snapshots behalten, %d entfernt und %d ausgedünnt",
+	"Would keep %d snapshots, remove %d and prune %d":             "Würde %d Snapshots behalten, %d entfernen und %d ausdünnen",
+	"Annotated snapshot %s":                                       "Snapshot %s annotiert",
+	"Snapshot %s has no annotation %s":                            "Snapshot %s hat keine Annotation %s",
+	"Invalid annotation %s, expected key=value":                   "Ungültige Annotation %s, erwartet wird key=value",
+	"Invalid annotation %s: %v":                                   "Ungültige Annotation %s: %v",
+	"(partial, based on %s)":                                      "(partiell, basierend auf %s)",
+	"never":                                                       "nie",
+	"%d errors":                                                   "%d Fehler",
+	"invalid date %s, expected YYYY-MM-DD or YYYY-MM-DD HH:MM:SS": "ungültiges Datum %s, erwartet wird YYYY-MM-DD oder YYYY-MM-DD HH:MM:SS",
+	"invalid path retention policy %s, expected [path]:[policy]":  "ungültige Aufbewahrungsregel für Pfade %s, erwartet wird [path]:[policy]",
+
+	// copy, migrate & rewrite
+	"Enter password of %s:":                                                                 "Passwort für %s eingeben:",
+	"Created volume %s (%s) in %s":                                                          "Volume %s (%s) in %s erstellt",
+	"Loading snapshot %s failed: %v":                                                        "Laden des Snapshots %s fehlgeschlagen: %v",
+	"Skipping snapshot %s, it already exists in %s":                                         "Überspringe Snapshot %s, er existiert bereits in %s",
+	"Copied snapshot %s to volume %s: %d chunks, %s stored":                                 "Snapshot %s in Volume %s kopiert: %d Chunks, %s gespeichert",
+	"Copying snapshot %s failed: %v":                                                        "Kopieren des Snapshots %s fehlgeschlagen: %v",
+	"Aborted copying snapshot %s":                                                           "Kopieren des Snapshots %s abgebrochen",
+	"Upgraded repository from version %d to %d":                                             "Repository von Version %d auf %d aktualisiert",
+	"Data can only be re-encrypted with aes or aes-gcm":                                     "Daten können nur mit aes oder aes-gcm neu verschlüsselt werden",
+	"Repositories using asymmetric encryption always seal their data with their public key": "Repositorys mit asymmetrischer Verschlüsselung versiegeln ihre Daten immer mit ihrem öffentlichen Schlüssel",
+	"Rewrote snapshot %s (%d/%d): %d chunks, %s stored":                                     "Snapshot %s neu geschrieben (%d/%d): %d Chunks, %s gespeichert",
+	"Rewriting snapshot %s failed: %v":                                                      "Neuschreiben des Snapshots %s fehlgeschlagen: %v",
+	"Aborting, run %s again to resume":                                                      "Breche ab, führe %s erneut aus, um fortzufahren",
+	"Migrated %d snapshots, re-encrypted %d chunks, freed %s":                               "%d Snapshots migriert, %d Chunks neu verschlüsselt, %s freigegeben",
+	"Rotated key, new key fingerprint: %s":                                                  "Schlüssel gewechselt, Fingerabdruck des neuen Schlüssels: %s",
+	"The old key has been removed from the repository":                                      "Der alte Schlüssel wurde aus dem Repository entfernt",
+	"Resuming key rotation, new key fingerprint: %s":                                        "Setze Schlüsselwechsel fort, Fingerabdruck des neuen Schlüssels: %s",
+	"Re-encrypted snapshot %s: %d chunks, %s stored":                                        "Snapshot %s neu verschlüsselt: %d Chunks, %s gespeichert",
+	"Re-encrypting snapshot %s failed: %v":                                                  "Neuverschlüsseln des Snapshots %s fehlgeschlagen: %v",
+	"Aborting, run rotate-key again to resume":                                              "Breche ab, führe rotate-key erneut aus, um fortzufahren",
+	"Re-encrypted %d snapshots and %d chunks, freed %s":                                     "%d Snapshots und %d Chunks neu verschlüsselt, %s freigegeben",
+	"Chunker settings: %s":                                                                  "Chunker-Einstellungen: %s",
+	"Skipping volume %s, which is protected by its own key":                                 "Überspringe Volume %s, das durch einen eigenen Schlüssel geschützt ist",
+	"Snapshot %s has been rechunked already":                                                "Snapshot %s wurde bereits neu aufgeteilt",
+	"Rechunked snapshot %s: %d files, %s stored":                                            "Snapshot %s neu aufgeteilt: %d Dateien, %s gespeichert",
+	"Rechunking snapshot %s failed: %v":                                                     "Neuaufteilen des Snapshots %s fehlgeschlagen: %v",
+	"Aborting, run rechunk again to resume":                                                 "Breche ab, führe rechunk erneut aus, um fortzufahren",
+	"Rechunked %d snapshots, stored %s of new chunks":                                       "%d Snapshots neu aufgeteilt, %s an neuen Chunks gespeichert",
+	"Recompressed %d snapshots and %d chunks, stored %s, freed %s":                          "%d Snapshots und %d Chunks neu komprimiert, %s gespeichert, %s freigegeben",
+
+	// storage tiers
+	"Please specify a tier with --tier, supported tiers: %s":                                                              "Bitte gib eine Speicherklasse mit --tier an, unterstützte Speicherklassen: %s",
+	"Moving %s of chunks to tier %s":                                                                                      "Verschiebe %s an Chunks in die Speicherklasse %s",
+	"Moving %d chunks back to tier %s":                                                                                    "Verschiebe %d Chunks zurück in die Speicherklasse %s",
+	"Aborting, run tier again to resume":                                                                                  "Breche ab, führe tier erneut aus, um fortzufahren",
+	"%s of chunks are stored in tier %s, restoring them may take longer and incur retrieval costs":                        "%s an Chunks liegen in der Speicherklasse %s, ihre Wiederherstellung kann länger dauern und Abrufkosten verursachen",
+	"Use --wait-for-archive to retrieve chunks from archive tiers first, --hydrate to move them back to the default tier": "Verwende --wait-for-archive, um Chunks zuerst aus Archiv-Speicherklassen abzurufen, oder --hydrate, um sie in die Standard-Speicherklasse zurückzuverschieben",
+	"Retrieving archived chunks: %d of %d available":                                                                      "Rufe archivierte Chunks ab: %d von %d verfügbar",
+
+	// keys & passwords
+	"Added key %s":                                              "Schlüssel %s hinzugefügt",
+	"Removed key %s":                                            "Schlüssel %s entfernt",
+	"Added key %s to volume %s":                                 "Schlüssel %s zum Volume %s hinzugefügt",
+	"Removed key %s from volume %s":                             "Schlüssel %s aus Volume %s entfernt",
+	"Enter password of volume %s:":                              "Passwort für Volume %s eingeben:",
+	"Confirm password:":                                         "Passwort bestätigen:",
+	"Enter password:":                                           "Passwort eingeben:",
+	"Enter new password:":                                       "Neues Passwort eingeben:",
+	"Enter a password to protect this volume with:":             "Gib ein Passwort ein, mit dem dieses Volume geschützt wird:",
+	"Enter a password to encrypt the bundle with:":              "Gib ein Passwort ein, mit dem das Bundle verschlüsselt wird:",
+	"Enter the password of the bundle:":                         "Passwort des Bundles eingeben:",
+	"Leave the password empty to generate a strong passphrase.": "Lass das Passwort leer, um eine sichere Passphrase zu erzeugen.",
+	"Your passphrase is:":                                       "Deine Passphrase lautet:",
+	"Write it down and keep it in a safe place. Without it you won't be able to access your data!": "Schreib sie auf und bewahre sie an einem sicheren Ort auf. Ohne sie kannst du nicht auf deine Daten zugreifen!",
+	"Password is weak (estimated time to crack: %s).":                                              "Das Passwort ist schwach (geschätzte Zeit zum Knacken: %s).",
+	"Are you sure you want to use this password (y/N)?: ":                                          "Bist du sicher, dass du dieses Passwort verwenden willst (y/N)?: ",
+	"Unlocked volume %s":               "Volume %s entsperrt",
+	"Protecting volume %s failed: %v":  "Schützen des Volumes %s fehlgeschlagen: %v",
+	"Reading password file failed: %v": "Lesen der Passwortdatei fehlgeschlagen: %v",
+	"Password file %s is accessible by other users, consider restricting its permissions to 0600": "Die Passwortdatei %s ist für andere Benutzer zugänglich, beschränke ihre Berechtigungen am besten auf 0600",
+	"Running password command failed: %v":                  "Ausführen des Passwort-Befehls fehlgeschlagen: %v",
+	"The password read is empty":                           "Das gelesene Passwort ist leer",
+	"Saved the password in the OS keyring":                 "Passwort im Schlüsselbund des Betriebssystems gespeichert",
+	"Saving the password in the OS keyring failed: %v":     "Speichern des Passworts im Schlüsselbund des Betriebssystems fehlgeschlagen: %v",
+	"Reading the password from the OS keyring failed: %v":  "Lesen des Passworts aus dem Schlüsselbund des Betriebssystems fehlgeschlagen: %v",
+	"Removed the password from the OS keyring":             "Passwort aus dem Schlüsselbund des Betriebssystems entfernt",
+	"Removing the password from the OS keyring failed: %v": "Entfernen des Passworts aus dem Schlüsselbund des Betriebssystems fehlgeschlagen: %v",
+	"The OS keyring holds no password for %s":              "Der Schlüsselbund des Betriebssystems enthält kein Passwort für %s",
+	"The password saved in the OS keyring doesn't open the repository, run 'knoxite repo forget-password' to remove it": "Das im Schlüsselbund des Betriebssystems gespeicherte Passwort öffnet das Repository nicht, entferne es mit 'knoxite repo forget-password'",
+	"Changed password hint successfully":                                       "Passwort-Hinweis erfolgreich geändert",
+	"Password hint: %s":                                                        "Passwort-Hinweis: %s",
+	"This repository has no password hint":                                     "Dieses Repository hat keinen Passwort-Hinweis",
+	"Private key file %s already exists":                                       "Die Datei für den privaten Schlüssel %s existiert bereits",
+	"Reading private key from %s failed: %v":                                   "Lesen des privaten Schlüssels aus %s fehlgeschlagen: %v",
+	"Writing private key failed: %v, it is %s":                                 "Schreiben des privaten Schlüssels fehlgeschlagen: %v, er lautet %s",
+	"Wrote private key to %s, keep it safe: it's required to restore any data": "Privater Schlüssel nach %s geschrieben, bewahre ihn sicher auf: er wird zum Wiederherstellen aller Daten benötigt",
+
+	// repository settings
+	"Equal data gets stored once per volume":                                             "Gleiche Daten werden einmal pro Volume gespeichert",
+	"Equal data gets stored once in the whole repository":                                "Gleiche Daten werden einmal im gesamten Repository gespeichert",
+	"Equal data gets stored once per volume from now on":                                 "Gleiche Daten werden ab jetzt einmal pro Volume gespeichert",
+	"Equal data gets stored once in the whole repository from now on":                    "Gleiche Daten werden ab jetzt einmal im gesamten Repository gespeichert",
+	"The repository is append-only now, only the password in use can remove data":        "Das Repository ist jetzt append-only, nur das verwendete Passwort kann Daten entfernen",
+	"The repository is not append-only anymore":                                          "Das Repository ist nicht mehr append-only",
+	"The repository is not append-only":                                                  "Das Repository ist nicht append-only",
+	"The repository is append-only, the key in use can't remove any data":                "Das Repository ist append-only, der verwendete Schlüssel kann keine Daten entfernen",
+	"The repository is append-only, the key in use is an admin key":                      "Das Repository ist append-only, der verwendete Schlüssel ist ein Admin-Schlüssel",
+	"The repository has been written by a newer version of knoxite and can only be read": "Das Repository wurde von einer neueren Version von knoxite geschrieben und kann nur gelesen werden",
+	"Use --force-read-only to try reading it anyway":                                     "Verwende --force-read-only, um es trotzdem zu lesen",
+	"Would delete chunk %s (%s)":                                                         "Würde Chunk %s (%s) löschen",
+	"Would free storage space: %s by deleting %d chunks":                                 "Würde Speicherplatz freigeben: %s durch Löschen von %d Chunks",
+	"Wrote recovery sheet to %s":                                                         "Wiederherstellungsblatt nach %s geschrieben",
+
+	// locks
+	"%v, aborting": "%v, breche ab",
+	"If the client holding the lock crashed, remove it with 'knoxite unlock --all'": "Falls der Client, der die Sperre hält, abgestürzt ist, entferne sie mit 'knoxite unlock --all'",
+	"Releasing the repository lock failed: %v":                                      "Freigeben der Repository-Sperre fehlgeschlagen: %v",
+	"Removed lock %s of %s@%s (PID %s)":                                             "Sperre %s von %s@%s (PID %s) entfernt",
+	"Removed %d locks":                                                              "%d Sperren entfernt",
+
+	// recovery sheet
+	"KNOXITE RECOVERY SHEET": "KNOXITE WIEDERHERSTELLUNGSBLATT",
+	"Created:":               "Erstellt:",
+	"Repository ID:":         "Repository-ID:",
+	"Storage:":               "Speicher:",
+	"Key fingerprint:":       "Fingerabdruck des Schlüssels:",
+	"Password hint:":         "Passwort-Hinweis:",
+	"Password:":              "Passwort:",
+	"(none)":                 "(keiner)",
+	"To restore your data:":  "So stellst du deine Daten wieder her:",
+	"  1. Install knoxite, see https://github.com/knoxite/knoxite":  "  1. Installiere knoxite, siehe https://github.com/knoxite/knoxite",
+	"  2. Check that this command shows the key fingerprint above:": "  2. Prüfe, dass dieser Befehl den obigen Fingerabdruck anzeigt:",
+	"  3. Find the volume and snapshot you want to restore:":        "  3. Suche das Volume und den Snapshot, die du wiederherstellen willst:",
+	"  4. Restore the snapshot:":                                    "  4. Stelle den Snapshot wieder her:",
+
+	// setup
+	"This will walk you through setting up a new knoxite repository.": "Dies führt dich durch die Einrichtung eines neuen knoxite-Repositorys.",
+	"Name of the profile":                                                  "Name des Profils",
+	"Profile %s already exists. Overwrite it?":                             "Profil %s existiert bereits. Überschreiben?",
+	"Storage backend":                                                      "Speicher-Backend",
+	"Repository directory":                                                 "Repository-Verzeichnis",
+	"Path or bucket":                                                       "Pfad oder Bucket",
+	"Host or account (if required)":                                        "Host oder Konto (falls benötigt)",
+	"Username or access key (if required)":                                 "Benutzername oder Zugangsschlüssel (falls benötigt)",
+	"Password or secret key (leave empty if not required):":                "Passwort oder geheimer Schlüssel (leer lassen, falls nicht benötigt):",
+	"Enter a password to encrypt this repository with:":                    "Gib ein Passwort ein, mit dem dieses Repository verschlüsselt wird:",
+	"Password hint, stored unencrypted (optional)":                         "Passwort-Hinweis, unverschlüsselt gespeichert (optional)",
+	"Compression":                                                          "Kompression",
+	"Encryption":                                                           "Verschlüsselung",
+	"Name of the volume for your backups":                                  "Name des Volumes für deine Backups",
+	"Description of the volume":                                            "Beschreibung des Volumes",
+	"Files and directories to back up (comma separated)":                   "Zu sichernde Dateien und Verzeichnisse (durch Kommas getrennt)",
+	"Exclude patterns (comma separated)":                                   "Ausschlussmuster (durch Kommas getrennt)",
+	"How often should snapshots be stored":                                 "Wie oft sollen Snapshots gespeichert werden",
+	"Print a recovery sheet to keep in a safe place?":                      "Ein Wiederherstellungsblatt zum sicheren Aufbewahren ausgeben?",
+	"Please pick one of: %s":                                               "Bitte wähle eines von: %s",
+	"%v, please try again.":                                                "%v, bitte versuche es erneut.",
+	"Invalid jitter %s: %v":                                                "Ungültige Streuung %s: %v",
+	"Setup aborted":                                                        "Einrichtung abgebrochen",
+	"Volume %s (Name: %s) created":                                         "Volume %s (Name: %s) erstellt",
+	"You can now store a snapshot with: knoxite -R %s store":               "Du kannst jetzt einen Snapshot speichern mit: knoxite -R %s store",
+	"You can now store a snapshot with: knoxite -R %s store %s [dir/file]": "Du kannst jetzt einen Snapshot speichern mit: knoxite -R %s store %s [dir/file]",
+	"To store snapshots %s, add this line to your crontab (and set KNOXITE_PASSWORD):": "Um Snapshots %s zu speichern, füge diese Zeile zu deiner crontab hinzu (und setze KNOXITE_PASSWORD):",
+	"Invalid percentage %s, expected a number between 0 and 100":                       "Ungültiger Prozentsatz %s, erwartet wird eine Zahl zwischen 0 und 100",
+	"Unknown schedule %s, use one of: %s or a cron expression":                         "Unbekannter Zeitplan %s, verwende eines von: %s oder einen Cron-Ausdruck",
+	"Exported %d profiles and %d passwords to %s":                                      "%d Profile und %d Passwörter nach %s exportiert",
+	"Imported %d profiles from %s, exported on %s by %s":                               "%d Profile aus %s importiert, exportiert am %s von %s",
+	"Skipping profile %s, it already exists (use --overwrite to replace it)":           "Überspringe Profil %s, es existiert bereits (verwende --overwrite, um es zu ersetzen)",
+	"Profile %s refers to %s, which doesn't exist on this machine":                     "Profil %s verweist auf %s, das auf diesem Rechner nicht existiert",
+	"Unknown alias %s": "Unbekannter Alias %s",
+	"Unknown service manager %s, expected one of: %s": "Unbekannter Dienstverwalter %s, erwartet wird eines von: %s",
+	"Error opening log file: %v":                      "Fehler beim Öffnen der Logdatei: %v",
+
+	// daemon & server
+	"Listening for commands on %s":              "Warte auf Befehle an %s",
+	"Another daemon is listening on %s already": "Ein anderer Daemon wartet bereits an %s",
+	"Connecting to the daemon failed: %v":       "Verbindung zum Daemon fehlgeschlagen: %v",
+	"--max-jobs needs to be at least 1":         "--max-jobs muss mindestens 1 sein",
+	"No profiles with a volume and store paths found, configure them with 'knoxite config set'": "Keine Profile mit Volume und zu sichernden Pfaden gefunden, richte sie mit 'knoxite config set' ein",
+	"Profile %s needs a volume and store paths":                                                 "Profil %s benötigt ein Volume und zu sichernde Pfade",
+	"Invalid schedule of profile %s: %v":                                                        "Ungültiger Zeitplan von Profil %s: %v",
+	"Invalid bandwidth %s: %v":                                                                  "Ungültige Bandbreite %s: %v",
+	"Ignoring unreadable daemon state: %v":                                                      "Ignoriere unlesbaren Daemon-Zustand: %v",
+	"Writing daemon state failed: %v":                                                           "Schreiben des Daemon-Zustands fehlgeschlagen: %v",
+	"Next snapshot of %s: %s":                                                                   "Nächster Snapshot von %s: %s",
+	"Waiting for a running job to finish before storing snapshot of %s":                         "Warte auf das Ende eines laufenden Auftrags, bevor ein Snapshot von %s gespeichert wird",
+	"Storing snapshot of %s":                                                                    "Speichere Snapshot von %s",
+	"Stored snapshot of %s in %s":                                                               "Snapshot von %s in %s gespeichert",
+	"Storing snapshot of %s failed: %v":                                                         "Speichern des Snapshots von %s fehlgeschlagen: %v",
+	"A snapshot of %s is being stored already":                                                  "Ein Snapshot von %s wird bereits gespeichert",
+	"A snapshot of %s is queued already":                                                        "Ein Snapshot von %s ist bereits eingeplant",
+	"Too many pending requests":                                                                 "Zu viele ausstehende Anfragen",
+	"Unknown command %s":                                                                        "Unbekannter Befehl %s",
+	"Job failed: %v":                                                                            "Auftrag fehlgeschlagen: %v",
+	"Please specify the directory to store the repositories in with --root":                     "Bitte gib mit --root das Verzeichnis an, in dem die Repositorys gespeichert werden",
+	"--tls-cert and --tls-key need to be specified together":                                    "--tls-cert und --tls-key müssen zusammen angegeben werden",
+	"Loading users failed: %v, add users with 'serve adduser'":                                  "Laden der Benutzer fehlgeschlagen: %v, füge Benutzer mit 'serve adduser' hinzu",
+	"Serving without TLS, passwords and data get transferred unencrypted":                       "Server läuft ohne TLS, Passwörter und Daten werden unverschlüsselt übertragen",
+	"Serving %d users on %s":                                                                    "Bediene %d Benutzer an %s",
+	"User %s can access the server now":                                                         "Benutzer %s kann jetzt auf den Server zugreifen",
+	"Serving metrics on http://%s/metrics":                                                      "Stelle Metriken unter http://%s/metrics bereit",
+	"Serving metrics failed: %v":                                                                "Bereitstellen der Metriken fehlgeschlagen: %v",
+	"Ignoring unreadable metrics file: %v":                                                      "Ignoriere unlesbare Metrikdatei: %v",
+	"Writing metrics failed: %v":                                                                "Schreiben der Metriken fehlgeschlagen: %v",
+	"Serving status page on http://%s/":                                                         "Stelle Statusseite unter http://%s/ bereit",
+	"Serving status page failed: %v":                                                            "Bereitstellen der Statusseite fehlgeschlagen: %v",
+	"Rendering status page failed: %v":                                                          "Erzeugen der Statusseite fehlgeschlagen: %v",
+	"Running workload %s against %s":                                                            "Führe Arbeitslast %s gegen %s aus",
+	"Stage":                                                                                     "Phase",
+	"Time":                                                                                      "Zeit",
+	"Share":                                                                                     "Anteil",
+
+	// command arguments
+	"add needs a URL to be added":                                                    "add benötigt eine URL, die hinzugefügt werden soll",
+	"alias needs an ALIAS to set":                                                    "alias benötigt einen ALIAS, der gesetzt werden soll",
+	"cat needs a snapshot ID and filename":                                           "cat benötigt eine Snapshot-ID und einen Dateinamen",
+	"clone needs to know which snapshot to clone":                                    "clone muss wissen, welcher Snapshot geklont werden soll",
+	"clone needs to know which files and/or directories to work on":                  "clone muss wissen, mit welchen Dateien und/oder Verzeichnissen gearbeitet werden soll",
+	"convert needs a source to work on":                                              "convert benötigt eine Quelle",
+	"convert needs a target to write to":                                             "convert benötigt ein Ziel",
+	"docs needs a target directory":                                                  "docs benötigt ein Zielverzeichnis",
+	"init needs a name for the new volume":                                           "init benötigt einen Namen für das neue Volume",
+	"list needs a volume ID to work on":                                              "list benötigt eine Volume-ID",
+	"ls needs a snapshot ID":                                                         "ls benötigt eine Snapshot-ID",
+	"mount needs to know which snapshot to work on":                                  "mount muss wissen, welcher Snapshot eingehängt werden soll",
+	"mount needs to know where to mount the snapshot to":                             "mount muss wissen, wo der Snapshot eingehängt werden soll",
+	"remove needs a snapshot ID to work on":                                          "remove benötigt eine Snapshot-ID",
+	"remove needs a volume to work on":                                               "remove benötigt ein Volume",
+	"restore needs to know which snapshot to work on":                                "restore muss wissen, welcher Snapshot wiederhergestellt werden soll",
+	"set needs to know which option to set":                                          "set muss wissen, welche Option gesetzt werden soll",
+	"set needs to know which value to set":                                           "set muss wissen, welcher Wert gesetzt werden soll",
+	"store needs to know which volume to create a snapshot in":                       "store muss wissen, in welchem Volume der Snapshot erstellt werden soll",
+	"store needs to know which files and/or directories to work on":                  "store muss wissen, welche Dateien und/oder Verzeichnisse gesichert werden sollen",
+	"annotate needs a snapshot ID to work on":                                        "annotate benötigt eine Snapshot-ID",
+	"append-only needs either on or off as argument":                                 "append-only benötigt entweder on oder off als Argument",
+	"adduser needs to know the name of the user":                                     "adduser muss den Namen des Benutzers kennen",
+	"add needs a volume to work on":                                                  "add benötigt ein Volume",
+	"cat needs to know which file to read":                                           "cat muss wissen, welche Datei gelesen werden soll",
+	"compare needs the URLs of two repositories":                                     "compare benötigt die URLs von zwei Repositorys",
+	"copy needs to know the destination repository, use --to":                        "copy muss das Ziel-Repository kennen, verwende --to",
+	"copy needs to know which snapshots to copy":                                     "copy muss wissen, welche Snapshots kopiert werden sollen",
+	"dedup needs either repository or volume as argument":                            "dedup benötigt entweder repository oder volume als Argument",
+	"diff needs the ID of a snapshot to compare with the local files":                "diff benötigt die ID eines Snapshots, der mit den lokalen Dateien verglichen werden soll",
+	"diff needs the IDs of two snapshots to compare":                                 "diff benötigt die IDs von zwei Snapshots, die verglichen werden sollen",
+	"export needs a file to write the bundle to":                                     "export benötigt eine Datei, in die das Paket geschrieben wird",
+	"forget needs a retention policy, use --keep or --keep-path":                     "forget benötigt eine Aufbewahrungsregel, verwende --keep oder --keep-path",
+	"forget needs a volume ID to work on":                                            "forget benötigt eine Volume-ID",
+	"hint needs the hint as a single (quoted) argument":                              "hint benötigt den Hinweis als einzelnes (in Anführungszeichen gesetztes) Argument",
+	"import needs a bundle to read":                                                  "import benötigt ein Paket zum Lesen",
+	"job needs the alias of a profile":                                               "job benötigt den Alias eines Profils",
+	"list needs a volume to work on":                                                 "list benötigt ein Volume",
+	"recompress needs a compression algo to use":                                     "recompress benötigt einen Kompressionsalgorithmus",
+	"remove needs a volume and the ID of a key to be removed":                        "remove benötigt ein Volume und die ID des zu entfernenden Schlüssels",
+	"remove needs the ID of a key to be removed":                                     "remove benötigt die ID des zu entfernenden Schlüssels",
+	"repair needs at most a volume and a snapshot ID":                                "repair benötigt höchstens ein Volume und eine Snapshot-ID",
+	"run needs the alias of a profile":                                               "run benötigt den Alias eines Profils",
+	"service needs to know which service manager to generate a definition for":       "service muss wissen, für welchen Dienstverwalter eine Definition erzeugt werden soll",
+	"storagebench needs the URL of at least one backend to benchmark":                "storagebench benötigt die URL von mindestens einem Backend",
+	"--asymmetric needs a file to write the private key to, use --private-key":       "--asymmetric benötigt eine Datei für den privaten Schlüssel, verwende --private-key",
+	"--json can't be used while writing the archive to stdout":                       "--json kann nicht verwendet werden, während das Archiv nach stdout geschrieben wird",
+	"--strip-prefix needs to be an absolute path":                                    "--strip-prefix muss ein absoluter Pfad sein",
+	"--watch only works with paths of the local file system":                         "--watch funktioniert nur mit Pfaden des lokalen Dateisystems",
+	"restore can't export to an archive and restore to a directory at the same time": "restore kann nicht gleichzeitig in ein Archiv exportieren und in ein Verzeichnis wiederherstellen",
+	"store can't pass the data read from stdin through processors":                   "store kann von stdin gelesene Daten nicht durch Prozessoren leiten",
+	"store can't read from stdin and store other files at the same time":             "store kann nicht gleichzeitig von stdin lesen und andere Dateien speichern",
+	"store can't watch the paths during a dry run":                                   "store kann die Pfade während eines Probelaufs nicht überwachen",
+	"Adding to tree: %s":                                                             "Füge zum Baum hinzu: %s",
+	"Adding to index: %s":                                                            "Füge zum Index hinzu: %s",
+}
@@ -0,0 +1,439 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package i18n
+
+// Spanish translations.
+var es = map[string]string{
+	// log levels
+	"Fatal":   "Error fatal",
+	"Error":   "Error",
+	"Warning": "Advertencia",
+	"Print":   "Salida",
+	"Info":    "Info",
+	"Debug":   "Depuración",
+
+	// progress & summary
+	"Files":                    "Archivos",
+	"Dirs":                     "Directorios",
+	"SymLinks":                 "Enlaces",
+	"Errors":                   "Errores",
+	"Original Size":            "Tamaño original",
+	"Storage Size":             "Tamaño almacenado",
+	"Duration":                 "Duración",
+	"Speed":                    "Velocidad",
+	"Total":                    "Total",
+	"%s / %s (%s of %s)  %s/s": "%s / %s (%s de %s)  %s/s",
+	"%s  failed: %v":           "%s  falló: %v",
+	"... and %d more":          "... y %d más",
+	"Aborting...":              "Cancelando...",
+
+	// store, restore & verify
+	"Snapshot %s created":                         "Instantánea %s creada",
+	"'%s': failed to store: %v":                   "'%s': no se pudo guardar: %v",
+	"'%s' failed to restore: %v":                  "'%s': no se pudo restaurar: %v",
+	"All symlinks point inside the restored tree": "Todos los enlaces simbólicos apuntan dentro del árbol restaurado",
+	"%d symlinks need to be checked:":             "Hay que revisar %d enlaces simbólicos:",
+	"'%s' -> '%s': %s":                            "'%s' -> '%s': %s",
+	"points outside of the restored tree":         "apunta fuera del árbol restaurado",
+	"target does not exist":                       "el destino no existe",
+	"Verify failed: %v":                           "La verificación falló: %v",
+	"Verify repository done: %d errors":           "Verificación del repositorio terminada: %d errores",
+	"Verify volume done: %d errors":               "Verificación del volumen terminada: %d errores",
+	"Verify snapshot done: %d errors":             "Verificación de la instantánea terminada: %d errores",
+	"failure tolerance can't be equal or higher as the number of storage backends": "la tolerancia a fallos debe ser menor que el número de backends de almacenamiento",
+	"please specify a directory to restore to":                                     "indique un directorio donde restaurar",
+
+	// repositories, volumes & snapshots
+	"Created new repository at %s":         "Nuevo repositorio creado en %s",
+	"Creating repository at %s failed: %v": "No se pudo crear el repositorio en %s: %v",
+	"Changed password successfully":        "Contraseña cambiada correctamente",
+	"Added %s to repository":               "%s añadido al repositorio",
+	"Freed storage space: %s":              "Espacio de almacenamiento liberado: %s",
+	"Volume %s (%s) created":               "Volumen %s (%s) creado",
+	"Creating volume %s failed: %v":        "No se pudo crear el volumen %s: %v",
+	"Volume %s '%s' successfully removed":  "Volumen %s '%s' eliminado correctamente",
+	"Snapshot %s removed: %s":              "Instantánea %s eliminada: %s",
+	"Do not forget to run 'repo pack' to delete un-referenced chunks and free up storage space!": "¡No olvide ejecutar 'repo pack' para borrar los bloques sin referencia y liberar espacio de almacenamiento!",
+	"%s: No such file or directory": "%s: no existe el archivo o el directorio",
+
+	// mount
+	"Mountpoint %s doesn't exist, creating it": "El punto de montaje %s no existe, se creará",
+	"Updating index":      "Actualizando el índice",
+	"Updating index done": "Índice actualizado",
+	"Error umounting: %s": "Error al desmontar: %s",
+
+	// configuration
+	"Writing configuration file to: %s":                                         "Escribiendo el archivo de configuración en: %s",
+	"Error loading the specified alias":                                         "Error al cargar el alias indicado",
+	"Error reading the config file: %v":                                         "Error al leer el archivo de configuración: %v",
+	"Error parsing the toml config file at '%s': %v":                            "Error al analizar el archivo de configuración toml '%s': %v",
+	`Error setting log level "%s": %s. Using default log level Info instead.`:   `Error al establecer el nivel de registro "%s": %s. Se usará el nivel predeterminado Info.`,
+	"Specify either repository directory '-r' or an alias '-R'":                 "Indique un directorio de repositorio '-r' o un alias '-R', no ambos",
+	"Specify either quiet '-q' or verbose '-v' output":                          "Indique salida silenciosa '-q' o detallada '-v', no ambas",
+	"No alias with name %s found":                                               "No se encontró ningún alias llamado %s",
+	"Unknown configuration option: %s":                                          "Opción de configuración desconocida: %s",
+	"Failed to convert %s to uint for the fault tolerance option: %v":           "No se pudo convertir %s a uint para la opción de tolerancia a fallos: %v",
+	"config set needs to work on an alias and a option like this: alias.option": "config set necesita un alias y una opción con la forma: alias.opción",
+
+	// stats & reports
+	"Snapshots:          %d": "Instantáneas:          %d",
+	"Original size:      %s": "Tamaño original:       %s",
+	"Chunks:             %d": "Bloques:               %d",
+	"Stored size:        %s (deduplication & compression ratio %.2f)": "Tamaño almacenado:     %s (tasa de deduplicación y compresión %.2f)",
+	"Parity overhead:    %s":                                              "Sobrecoste de paridad: %s",
+	"Unreferenced:       %s (released by 'repo pack')":                    "Sin referencia:        %s (se libera con 'repo pack')",
+	"Skipping metadata of snapshot %s of locked volume %s":                "Se omiten los metadatos de la instantánea %s del volumen bloqueado %s",
+	"Compared snapshots: %d":                                              "Instantáneas comparadas: %d",
+	"Compared chunks:    %d":                                              "Bloques comparados:      %d",
+	"The repository metadata differs":                                     "Los metadatos de los repositorios difieren",
+	"The chunk-index differs":                                             "El índice de bloques difiere",
+	"Snapshot %s is missing in %s":                                        "Falta la instantánea %s en %s",
+	"Snapshot %s differs":                                                 "La instantánea %s difiere",
+	"Chunk %s is missing in %s":                                           "Falta el bloque %s en %s",
+	"Chunk %s differs in size":                                            "El tamaño del bloque %s difiere",
+	"Sizes of chunks could not be compared, only checked that they exist": "No se pudo comparar el tamaño de los bloques, solo se comprobó que existen",
+	"Both repositories store the same data":                               "Ambos repositorios almacenan los mismos datos",
+	"The repositories differ":                                             "Los repositorios difieren",
+	"%d added, %d removed, %d modified":                                   "%d añadidos, %d eliminados, %d modificados",
+
+	// store & watch
+	"'%s': failed to read: %v": "'%s': no se pudo leer: %v",
+	"'%s': flagged by %s: %s":  "'%s': marcado por %s: %s",
+	"Dry run: would store %d items in %d new chunks, taking %s of storage space": "Simulación: se almacenarían %d elementos en %d bloques nuevos, ocupando %s de espacio de almacenamiento",
+	"Files since snapshot %s: %d new, %d changed, %d unmodified, %d removed":     "Archivos desde la instantánea %s: %d nuevos, %d modificados, %d sin cambios, %d eliminados",
+	"Resuming interrupted snapshot %s":                                           "Reanudando la instantánea interrumpida %s",
+	"Ignoring unreadable checkpoint: %v":                                         "Se ignora el punto de control ilegible: %v",
+	"Writing checkpoint failed: %v":                                              "No se pudo escribir el punto de control: %v",
+	"Removing checkpoint failed: %v":                                             "No se pudo eliminar el punto de control: %v",
+	"Run the same command again to resume storing this snapshot":                 "Ejecute de nuevo el mismo comando para reanudar el almacenamiento de esta instantánea",
+	"Loading baseline snapshot %s failed: %v":                                    "No se pudo cargar la instantánea de referencia %s: %v",
+	"Not using the change cache: %v":                                             "No se usa la caché de cambios: %v",
+	"Saving the change cache failed: %v":                                         "No se pudo guardar la caché de cambios: %v",
+	"Invalid redaction %s, expected host[=strip], owner or path=[name]":          "Ocultación %s no válida, se esperaba host[=strip], owner o path=[name]",
+	"Invalid processor %s, expected name=command":                                "Procesador %s no válido, se esperaba name=command",
+	"Running %s hook: %s":                                                        "Ejecutando el hook %s: %s",
+	"Running %s hook failed: %v":                                                 "No se pudo ejecutar el hook %s: %v",
+	"storing the snapshot has been aborted":                                      "se interrumpió el almacenamiento de la instantánea",
+	"Watching %s for changes":                                                    "Vigilando los cambios en %s",
+	"Watching %s failed: %v":                                                     "No se pudo vigilar %s: %v",
+	"Watching for changes failed: %v":                                            "No se pudieron vigilar los cambios: %v",
+	"Changed: %s":                                                                "Modificado: %s",
+	"Storing changes of %s":                                                      "Almacenando los cambios de %s",
+	"Storing changes failed: %v":                                                 "No se pudieron almacenar los cambios: %v",
+	"The quiet period needs to be positive":                                      "El periodo de espera debe ser positivo",
+
+	// restore
+	"Restoring %d files, %d directories and %d symlinks (%s) to %s": "Restaurando %d archivos, %d directorios y %d enlaces simbólicos (%s) en %s",
+	"Downloading %s in %d chunks":                                   "Descargando %s en %d bloques",
+	"    %s from %s":                                                "    %s desde %s",
+	"Start restoring?":                                              "¿Iniciar la restauración?",
+	"Restore aborted":                                               "Restauración cancelada",
+	"Snapshot %s isn't tagged with %s":                              "La instantánea %s no está etiquetada con %s",
+	"Can't tell the archive format of %s, use --archive-format":     "No se puede determinar el formato de archivo de %s, use --archive-format",
+	"invalid size %s: %v":                                           "tamaño %s no válido: %v",
+	"%s: Is a directory":                                            "%s: es un directorio",
+
+	// verify & repair
+	"Verifying repository...":             "Verificando el repositorio...",
+	"Verify repository done: no errors":   "Verificación del repositorio terminada: sin errores",
+	"Verify repository failed: %d errors": "La verificación del repositorio falló: %d errores",
+	"Skipping verification, the repository's private key is required to read its data":   "Se omite la verificación, se necesita la clave privada del repositorio para leer sus datos",
+	"Can't list the chunks stored in %s, skipped checking for missing & orphaned chunks": "No se pueden listar los bloques almacenados en %s, se omitió la búsqueda de bloques ausentes y huérfanos",
+	"Chunk %s is missing":                                           "Falta el bloque %s",
+	"Chunk %s is missing in the chunk-index":                        "Falta el bloque %s en el índice de bloques",
+	"Chunk %s isn't referenced by any snapshot":                     "Ninguna instantánea hace referencia al bloque %s",
+	"Chunk %s is indexed for snapshots that don't reference it":     "El bloque %s está indexado para instantáneas que no hacen referencia a él",
+	"Snapshot %s can't be read: %s":                                 "No se puede leer la instantánea %s: %s",
+	"Repaired":                                                      "Reparado",
+	"Can repair":                                                    "Reparable",
+	"%s missing part %d of chunk %s":                                "%s: parte ausente %d del bloque %s",
+	"%s corrupt part %d of chunk %s":                                "%s: parte dañada %d del bloque %s",
+	"Chunk %s can't be repaired: %s":                                "No se puede reparar el bloque %s: %s",
+	"Repair done: %d chunks checked, %d repaired, %d unrepairable":  "Reparación terminada: %d bloques comprobados, %d reparados, %d irreparables",
+	"%s still miss data, run 'repo heal' once they are available":   "A %s aún les faltan datos, ejecute 'repo heal' cuando estén disponibles",
+	"All storage backends are up to date":                           "Todos los backends de almacenamiento están al día",
+	"Copied %d chunks and %d snapshots to lagging storage backends": "%d bloques y %d instantáneas copiados a los backends de almacenamiento atrasados",
+
+	// salvage
+	"Couldn't open repository (%v), recreating it from the data key": "No se pudo abrir el repositorio (%v), se reconstruye a partir de la clave de datos",
+	"Key fingerprint: %s":                      "Huella de la clave: %s",
+	"Orphaned chunks with a chunk header: %d":  "Chunks huérfanos con cabecera: %d",
+	"Added %d snapshots to volume %s":          "%d instantáneas añadidas al volumen %s",
+	"Readable snapshots:     %d":               "Instantáneas legibles:       %d",
+	"Unreferenced snapshots: %d":               "Instantáneas sin referencia: %d",
+	"Recoverable archives:   %d":               "Archivos recuperables:       %d",
+	"Damaged archives:       %d":               "Archivos dañados:            %d",
+	"Orphaned chunks:        %d":               "Bloques huérfanos:           %d",
+	"Archive %s in snapshot %s is damaged: %s": "El archivo %s de la instantánea %s está dañado: %s",
+	"Stored data could not be listed for %s, only snapshots referenced by volumes were checked": "No se pudieron listar los datos almacenados de %s, solo se comprobaron las instantáneas referenciadas por volúmenes",
+
+	// snapshots & retention
+	"Removing snapshot %s (%s)":                                   "Eliminando la instantánea %s (%s)",
+	"Removing %d items from snapshot %s (%s)":                     "Eliminando %d elementos de la instantánea %s (%s)",
+	"Kept %d snapshots, removed %d and pruned %d":                 "%d instantáneas conservadas, %d eliminadas y %d recortadas",
+	"Would keep %d snapshots, remove %d and prune %d":             "Se conservarían %d instantáneas, se eliminarían %d y se recortarían %d",
+	"Annotated snapshot %s":                                       "Instantánea %s anotada",
+	"Snapshot %s has no annotation %s":                            "La instantánea %s no tiene la anotación %s",
+	"Invalid annotation %s, expected key=value":                   "Anotación %s no válida, se esperaba key=value",
+	"Invalid annotation %s: %v":                                   "Anotación %s no válida: %v",
+	"(partial, based on %s)":                                      "(parcial, basada en %s)",
+	"never":                                                       "nunca",
+	"%d errors":                                                   "%d errores",
+	"invalid date %s, expected YYYY-MM-DD or YYYY-MM-DD HH:MM:SS": "fecha %s no válida, se esperaba YYYY-MM-DD o YYYY-MM-DD HH:MM:SS",
+	"invalid path retention policy %s, expected [path]:[policy]":  "política de retención por ruta %s no válida, se esperaba [path]:[policy]",
+
+	// copy, migrate & rewrite
+	"Enter password of %s:":                                                                 "Introduzca la contraseña de %s:",
+	"Created volume %s (%s) in %s":                                                          "Volumen %s (%s) creado en %s",
+	"Loading snapshot %s failed: %v":                                                        "No se pudo cargar la instantánea %s: %v",
+	"Skipping snapshot %s, it already exists in %s":                                         "Se omite la instantánea %s, ya existe en %s",
+	"Copied snapshot %s to volume %s: %d chunks, %s stored":                                 "Instantánea %s copiada al volumen %s: %d bloques, %s almacenados",
+	"Copying snapshot %s failed: %v":                                                        "No se pudo copiar la instantánea %s: %v",
+	"Aborted copying snapshot %s":                                                           "Copia de la instantánea %s interrumpida",
+	"Upgraded repository from version %d to %d":                                             "Repositorio actualizado de la versión %d a la %d",
+	"Data can only be re-encrypted with aes or aes-gcm":                                     "Los datos solo se pueden volver a cifrar con aes o aes-gcm",
+	"Repositories using asymmetric encryption always seal their data with their public key": "Los repositorios con cifrado asimétrico siempre sellan sus datos con su clave pública",
+	"Rewrote snapshot %s (%d/%d): %d chunks, %s stored":                                     "Instantánea %s reescrita (%d/%d): %d bloques, %s almacenados",
+	"Rewriting snapshot %s failed: %v":                                                      "No se pudo reescribir la instantánea %s: %v",
+	"Aborting, run %s again to resume":                                                      "Cancelando, ejecute %s de nuevo para reanudar",
+	"Migrated %d snapshots, re-encrypted %d chunks, freed %s":                               "%d instantáneas migradas, %d bloques cifrados de nuevo, %s liberados",
+	"Rotated key, new key fingerprint: %s":                                                  "Clave rotada, huella de la nueva clave: %s",
+	"The old key has been removed from the repository":                                      "La clave antigua se ha eliminado del repositorio",
+	"Resuming key rotation, new key fingerprint: %s":                                        "Reanudando la rotación de clave, huella de la nueva clave: %s",
+	"Re-encrypted snapshot %s: %d chunks, %s stored":                                        "Instantánea %s cifrada de nuevo: %d bloques, %s almacenados",
+	"Re-encrypting snapshot %s failed: %v":                                                  "No se pudo volver a cifrar la instantánea %s: %v",
+	"Aborting, run rotate-key again to resume":                                              "Cancelando, ejecute rotate-key de nuevo para reanudar",
+	"Re-encrypted %d snapshots and %d chunks, freed %s":                                     "%d instantáneas y %d bloques cifrados de nuevo, %s liberados",
+	"Chunker settings: %s":                                                                  "Ajustes del troceado: %s",
+	"Skipping volume %s, which is protected by its own key":                                 "Se omite el volumen %s, protegido por su propia clave",
+	"Snapshot %s has been rechunked already":                                                "La instantánea %s ya se ha troceado de nuevo",
+	"Rechunked snapshot %s: %d files, %s stored":                                            "Instantánea %s troceada de nuevo: %d archivos, %s almacenados",
+	"Rechunking snapshot %s failed: %v":                                                     "No se pudo trocear de nuevo la instantánea %s: %v",
+	"Aborting, run rechunk again to resume":                                                 "Cancelando, ejecute rechunk de nuevo para reanudar",
+	"Rechunked %d snapshots, stored %s of new chunks":                                       "%d instantáneas troceadas de nuevo, %s de bloques nuevos almacenados",
+	"Recompressed %d snapshots and %d chunks, stored %s, freed %s":                          "%d instantáneas y %d bloques comprimidos de nuevo, %s almacenados, %s liberados",
+
+	// storage tiers
+	"Please specify a tier with --tier, supported tiers: %s":                                                              "Indique una clase de almacenamiento con --tier, clases admitidas: %s",
+	"Moving %s of chunks to tier %s":                                                                                      "Moviendo %s de bloques a la clase de almacenamiento %s",
+	"Moving %d chunks back to tier %s":                                                                                    "Devolviendo %d bloques a la clase de almacenamiento %s",
+	"Aborting, run tier again to resume":                                                                                  "Cancelando, ejecute tier de nuevo para reanudar",
+	"%s of chunks are stored in tier %s, restoring them may take longer and incur retrieval costs":                        "%s de bloques están en la clase de almacenamiento %s, restaurarlos puede tardar más y generar costes de recuperación",
+	"Use --wait-for-archive to retrieve chunks from archive tiers first, --hydrate to move them back to the default tier": "Use --wait-for-archive para recuperar primero los bloques de las clases de archivo, o --hydrate para devolverlos a la clase predeterminada",
+	"Retrieving archived chunks: %d of %d available":                                                                      "Recuperando bloques archivados: %d de %d disponibles",
+
+	// keys & passwords
+	"Added key %s":                                              "Clave %s añadida",
+	"Removed key %s":                                            "Clave %s eliminada",
+	"Added key %s to volume %s":                                 "Clave %s añadida al volumen %s",
+	"Removed key %s from volume %s":                             "Clave %s eliminada del volumen %s",
+	"Enter password of volume %s:":                              "Introduzca la contraseña del volumen %s:",
+	"Confirm password:":                                         "Confirme la contraseña:",
+	"Enter password:":                                           "Introduce la contraseña:",
+	"Enter new password:":                                       "Introduce la nueva contraseña:",
+	"Enter a password to protect this volume with:":             "Introduce una contraseña para proteger este volumen:",
+	"Enter a password to encrypt the bundle with:":              "Introduce una contraseña para cifrar el paquete:",
+	"Enter the password of the bundle:":                         "Introduce la contraseña del paquete:",
+	"Leave the password empty to generate a strong passphrase.": "Deja la contraseña vacía para generar una frase de contraseña segura.",
+	"Your passphrase is:":                                       "Tu frase de contraseña es:",
+	"Write it down and keep it in a safe place. Without it you won't be able to access your data!": "Anótala y guárdala en un lugar seguro. ¡Sin ella no podrás acceder a tus datos!",
+	"Password is weak (estimated time to crack: %s).":                                              "La contraseña es débil (tiempo estimado para descifrarla: %s).",
+	"Are you sure you want to use this password (y/N)?: ":                                          "¿Seguro que quieres usar esta contraseña (y/N)?: ",
+	"Unlocked volume %s":               "Volumen %s desbloqueado",
+	"Protecting volume %s failed: %v":  "No se pudo proteger el volumen %s: %v",
+	"Reading password file failed: %v": "No se pudo leer el archivo de contraseña: %v",
+	"Password file %s is accessible by other users, consider restricting its permissions to 0600": "Otros usuarios pueden acceder al archivo de contraseña %s, considere restringir sus permisos a 0600",
+	"Running password command failed: %v":                  "No se pudo ejecutar el comando de contraseña: %v",
+	"The password read is empty":                           "La contraseña leída está vacía",
+	"Saved the password in the OS keyring":                 "Contraseña guardada en el llavero del sistema",
+	"Saving the password in the OS keyring failed: %v":     "No se pudo guardar la contraseña en el llavero del sistema: %v",
+	"Reading the password from the OS keyring failed: %v":  "No se pudo leer la contraseña del llavero del sistema: %v",
+	"Removed the password from the OS keyring":             "Contraseña eliminada del llavero del sistema",
+	"Removing the password from the OS keyring failed: %v": "No se pudo eliminar la contraseña del llavero del sistema: %v",
+	"The OS keyring holds no password for %s":              "El llavero del sistema no contiene ninguna contraseña para %s",
+	"The password saved in the OS keyring doesn't open the repository, run 'knoxite repo forget-password' to remove it": "La contraseña guardada en el llavero del sistema no abre el repositorio, elimínela con 'knoxite repo forget-password'",
+	"Changed password hint successfully":                                       "Pista de contraseña cambiada correctamente",
+	"Password hint: %s":                                                        "Pista de contraseña: %s",
+	"This repository has no password hint":                                     "Este repositorio no tiene pista de contraseña",
+	"Private key file %s already exists":                                       "El archivo de clave privada %s ya existe",
+	"Reading private key from %s failed: %v":                                   "No se pudo leer la clave privada de %s: %v",
+	"Writing private key failed: %v, it is %s":                                 "No se pudo escribir la clave privada: %v, es %s",
+	"Wrote private key to %s, keep it safe: it's required to restore any data": "Clave privada escrita en %s, guárdela en un lugar seguro: es necesaria para restaurar cualquier dato",
+
+	// repository settings
+	"Equal data gets stored once per volume":                                             "Los datos iguales se almacenan una vez por volumen",
+	"Equal data gets stored once in the whole repository":                                "Los datos iguales se almacenan una vez en todo el repositorio",
+	"Equal data gets stored once per volume from now on":                                 "A partir de ahora, los datos iguales se almacenan una vez por volumen",
+	"Equal data gets stored once in the whole repository from now on":                    "A partir de ahora, los datos iguales se almacenan una vez en todo el repositorio",
+	"The repository is append-only now, only the password in use can remove data":        "El repositorio ahora es de solo anexado, solo la contraseña en uso puede eliminar datos",
+	"The repository is not append-only anymore":                                          "El repositorio ya no es de solo anexado",
+	"The repository is not append-only":                                                  "El repositorio no es de solo anexado",
+	"The repository is append-only, the key in use can't remove any data":                "El repositorio es de solo anexado, la clave en uso no puede eliminar datos",
+	"The repository is append-only, the key in use is an admin key":                      "El repositorio es de solo anexado, la clave en uso es una clave de administración",
+	"The repository has been written by a newer version of knoxite and can only be read": "El repositorio fue escrito por una versión más reciente de knoxite y solo se puede leer",
+	"Use --force-read-only to try reading it anyway":                                     "Use --force-read-only para intentar leerlo de todos modos",
+	"Would delete chunk %s (%s)":                                                         "Se borraría el bloque %s (%s)",
+	"Would free storage space: %s by deleting %d chunks":                                 "Espacio de almacenamiento que se liberaría: %s borrando %d bloques",
+	"Wrote recovery sheet to %s":                                                         "Hoja de recuperación escrita en %s",
+
+	// locks
+	"%v, aborting": "%v, cancelando",
+	"If the client holding the lock crashed, remove it with 'knoxite unlock --all'": "Si el cliente que tiene el bloqueo falló, elimínelo con 'knoxite unlock --all'",
+	"Releasing the repository lock failed: %v":                                      "No se pudo liberar el bloqueo del repositorio: %v",
+	"Removed lock %s of %s@%s (PID %s)":                                             "Bloqueo %s de %s@%s (PID %s) eliminado",
+	"Removed %d locks":                                                              "%d bloqueos eliminados",
+
+	// recovery sheet
+	"KNOXITE RECOVERY SHEET": "HOJA DE RECUPERACIÓN DE KNOXITE",
+	"Created:":               "Creada:",
+	"Repository ID:":         "ID del repositorio:",
+	"Storage:":               "Almacenamiento:",
+	"Key fingerprint:":       "Huella de la clave:",
+	"Password hint:":         "Pista de contraseña:",
+	"Password:":              "Contraseña:",
+	"(none)":                 "(ninguna)",
+	"To restore your data:":  "Para restaurar sus datos:",
+	"  1. Install knoxite, see https://github.com/knoxite/knoxite":  "  1. Instale knoxite, consulte https://github.com/knoxite/knoxite",
+	"  2. Check that this command shows the key fingerprint above:": "  2. Compruebe que este comando muestra la huella anterior:",
+	"  3. Find the volume and snapshot you want to restore:":        "  3. Busque el volumen y la instantánea que desea restaurar:",
+	"  4. Restore the snapshot:":                                    "  4. Restaure la instantánea:",
+
+	// setup
+	"This will walk you through setting up a new knoxite repository.": "Este asistente le guía en la configuración de un nuevo repositorio de knoxite.",
+	"Name of the profile":                                                  "Nombre del perfil",
+	"Profile %s already exists. Overwrite it?":                             "El perfil %s ya existe. ¿Sobrescribirlo?",
+	"Storage backend":                                                      "Backend de almacenamiento",
+	"Repository directory":                                                 "Directorio del repositorio",
+	"Path or bucket":                                                       "Ruta o bucket",
+	"Host or account (if required)":                                        "Host o cuenta (si es necesario)",
+	"Username or access key (if required)":                                 "Usuario o clave de acceso (si es necesario)",
+	"Password or secret key (leave empty if not required):":                "Contraseña o clave secreta (déjela vacía si no es necesaria):",
+	"Enter a password to encrypt this repository with:":                    "Introduzca una contraseña para cifrar este repositorio:",
+	"Password hint, stored unencrypted (optional)":                         "Pista de contraseña, almacenada sin cifrar (opcional)",
+	"Compression":                                                          "Compresión",
+	"Encryption":                                                           "Cifrado",
+	"Name of the volume for your backups":                                  "Nombre del volumen para sus copias de seguridad",
+	"Description of the volume":                                            "Descripción del volumen",
+	"Files and directories to back up (comma separated)":                   "Archivos y directorios que respaldar (separados por comas)",
+	"Exclude patterns (comma separated)":                                   "Patrones de exclusión (separados por comas)",
+	"How often should snapshots be stored":                                 "Con qué frecuencia almacenar las instantáneas",
+	"Print a recovery sheet to keep in a safe place?":                      "¿Imprimir una hoja de recuperación para guardar en un lugar seguro?",
+	"Please pick one of: %s":                                               "Elija una de estas opciones: %s",
+	"%v, please try again.":                                                "%v, inténtelo de nuevo.",
+	"Invalid jitter %s: %v":                                                "Variación aleatoria %s no válida: %v",
+	"Setup aborted":                                                        "Configuración cancelada",
+	"Volume %s (Name: %s) created":                                         "Volumen %s (nombre: %s) creado",
+	"You can now store a snapshot with: knoxite -R %s store":               "Ahora puede almacenar una instantánea con: knoxite -R %s store",
+	"You can now store a snapshot with: knoxite -R %s store %s [dir/file]": "Ahora puede almacenar una instantánea con: knoxite -R %s store %s [dir/file]",
+	"To store snapshots %s, add this line to your crontab (and set KNOXITE_PASSWORD):": "Para almacenar instantáneas %s, añada esta línea a su crontab (y defina KNOXITE_PASSWORD):",
+	"Invalid percentage %s, expected a number between 0 and 100":                       "Porcentaje %s no válido, se esperaba un número entre 0 y 100",
+	"Unknown schedule %s, use one of: %s or a cron expression":                         "Programación %s desconocida, use una de: %s o una expresión cron",
+	"Exported %d profiles and %d passwords to %s":                                      "%d perfiles y %d contraseñas exportados a %s",
+	"Imported %d profiles from %s, exported on %s by %s":                               "%d perfiles importados de %s, exportados el %s por %s",
+	"Skipping profile %s, it already exists (use --overwrite to replace it)":           "Se omite el perfil %s, ya existe (use --overwrite para reemplazarlo)",
+	"Profile %s refers to %s, which doesn't exist on this machine":                     "El perfil %s hace referencia a %s, que no existe en esta máquina",
+	"Unknown alias %s": "Alias %s desconocido",
+	"Unknown service manager %s, expected one of: %s": "Gestor de servicios %s desconocido, se esperaba uno de: %s",
+	"Error opening log file: %v":                      "Error al abrir el archivo de registro: %v",
+
+	// daemon & server
+	"Listening for commands on %s":              "Escuchando comandos en %s",
+	"Another daemon is listening on %s already": "Otro demonio ya está escuchando en %s",
+	"Connecting to the daemon failed: %v":       "No se pudo conectar con el demonio: %v",
+	"--max-jobs needs to be at least 1":         "--max-jobs debe ser al menos 1",
+	"No profiles with a volume and store paths found, configure them with 'knoxite config set'": "No se encontraron perfiles con un volumen y rutas que almacenar, configúrelos con 'knoxite config set'",
+	"Profile %s needs a volume and store paths":                                                 "El perfil %s necesita un volumen y rutas que almacenar",
+	"Invalid schedule of profile %s: %v":                                                        "Programación del perfil %s no válida: %v",
+	"Invalid bandwidth %s: %v":                                                                  "Ancho de banda %s no válido: %v",
+	"Ignoring unreadable daemon state: %v":                                                      "Se ignora el estado ilegible del demonio: %v",
+	"Writing daemon state failed: %v":                                                           "No se pudo escribir el estado del demonio: %v",
+	"Next snapshot of %s: %s":                                                                   "Próxima instantánea de %s: %s",
+	"Waiting for a running job to finish before storing snapshot of %s":                         "Esperando a que termine una tarea en curso antes de almacenar la instantánea de %s",
+	"Storing snapshot of %s":                                                                    "Almacenando la instantánea de %s",
+	"Stored snapshot of %s in %s":                                                               "Instantánea de %s almacenada en %s",
+	"Storing snapshot of %s failed: %v":                                                         "No se pudo almacenar la instantánea de %s: %v",
+	"A snapshot of %s is being stored already":                                                  "Ya se está almacenando una instantánea de %s",
+	"A snapshot of %s is queued already":                                                        "Ya hay una instantánea de %s en cola",
+	"Too many pending requests":                                                                 "Demasiadas solicitudes pendientes",
+	"Unknown command %s":                                                                        "Comando %s desconocido",
+	"Job failed: %v":                                                                            "La tarea falló: %v",
+	"Please specify the directory to store the repositories in with --root":                     "Indique con --root el directorio donde almacenar los repositorios",
+	"--tls-cert and --tls-key need to be specified together":                                    "--tls-cert y --tls-key deben indicarse juntos",
+	"Loading users failed: %v, add users with 'serve adduser'":                                  "No se pudieron cargar los usuarios: %v, añada usuarios con 'serve adduser'",
+	"Serving without TLS, passwords and data get transferred unencrypted":                       "Sirviendo sin TLS, las contraseñas y los datos se transfieren sin cifrar",
+	"Serving %d users on %s":                                                                    "Sirviendo a %d usuarios en %s",
+	"User %s can access the server now":                                                         "El usuario %s ya puede acceder al servidor",
+	"Serving metrics on http://%s/metrics":                                                      "Sirviendo métricas en http://%s/metrics",
+	"Serving metrics failed: %v":                                                                "No se pudieron servir las métricas: %v",
+	"Ignoring unreadable metrics file: %v":                                                      "Se ignora el archivo de métricas ilegible: %v",
+	"Writing metrics failed: %v":                                                                "No se pudieron escribir las métricas: %v",
+	"Serving status page on http://%s/":                                                         "Sirviendo la página de estado en http://%s/",
+	"Serving status page failed: %v":                                                            "No se pudo servir la página de estado: %v",
+	"Rendering status page failed: %v":                                                          "No se pudo generar la página de estado: %v",
+	"Running workload %s against %s":                                                            "Ejecutando la carga de trabajo %s contra %s",
+	"Stage":                                                                                     "Fase",
+	"Time":                                                                                      "Tiempo",
+	"Share":                                                                                     "Proporción",
+
+	// command arguments
+	"add needs a URL to be added":                                                    "add necesita una URL para añadir",
+	"alias needs an ALIAS to set":                                                    "alias necesita un ALIAS para establecer",
+	"cat needs a snapshot ID and filename":                                           "cat necesita un ID de instantánea y un nombre de archivo",
+	"clone needs to know which snapshot to clone":                                    "clone necesita saber qué instantánea clonar",
+	"clone needs to know which files and/or directories to work on":                  "clone necesita saber con qué archivos y/o directorios trabajar",
+	"convert needs a source to work on":                                              "convert necesita un origen",
+	"convert needs a target to write to":                                             "convert necesita un destino",
+	"docs needs a target directory":                                                  "docs necesita un directorio de destino",
+	"init needs a name for the new volume":                                           "init necesita un nombre para el nuevo volumen",
+	"list needs a volume ID to work on":                                              "list necesita un ID de volumen",
+	"ls needs a snapshot ID":                                                         "ls necesita un ID de instantánea",
+	"mount needs to know which snapshot to work on":                                  "mount necesita saber qué instantánea montar",
+	"mount needs to know where to mount the snapshot to":                             "mount necesita saber dónde montar la instantánea",
+	"remove needs a snapshot ID to work on":                                          "remove necesita un ID de instantánea",
+	"remove needs a volume to work on":                                               "remove necesita un volumen",
+	"restore needs to know which snapshot to work on":                                "restore necesita saber qué instantánea restaurar",
+	"set needs to know which option to set":                                          "set necesita saber qué opción establecer",
+	"set needs to know which value to set":                                           "set necesita saber qué valor establecer",
+	"store needs to know which volume to create a snapshot in":                       "store necesita saber en qué volumen crear la instantánea",
+	"store needs to know which files and/or directories to work on":                  "store necesita saber qué archivos y/o directorios guardar",
+	"annotate needs a snapshot ID to work on":                                        "annotate necesita un ID de instantánea",
+	"append-only needs either on or off as argument":                                 "append-only necesita on u off como argumento",
+	"adduser needs to know the name of the user":                                     "adduser necesita saber el nombre del usuario",
+	"add needs a volume to work on":                                                  "add necesita un volumen",
+	"cat needs to know which file to read":                                           "cat necesita saber qué archivo leer",
+	"compare needs the URLs of two repositories":                                     "compare necesita las URL de dos repositorios",
+	"copy needs to know the destination repository, use --to":                        "copy necesita saber el repositorio de destino, use --to",
+	"copy needs to know which snapshots to copy":                                     "copy necesita saber qué instantáneas copiar",
+	"dedup needs either repository or volume as argument":                            "dedup necesita repository o volume como argumento",
+	"diff needs the ID of a snapshot to compare with the local files":                "diff necesita el ID de una instantánea que comparar con los archivos locales",
+	"diff needs the IDs of two snapshots to compare":                                 "diff necesita los ID de dos instantáneas que comparar",
+	"export needs a file to write the bundle to":                                     "export necesita un archivo donde escribir el paquete",
+	"forget needs a retention policy, use --keep or --keep-path":                     "forget necesita una política de retención, use --keep o --keep-path",
+	"forget needs a volume ID to work on":                                            "forget necesita un ID de volumen",
+	"hint needs the hint as a single (quoted) argument":                              "hint necesita la pista como un único argumento (entre comillas)",
+	"import needs a bundle to read":                                                  "import necesita un paquete que leer",
+	"job needs the alias of a profile":                                               "job necesita el alias de un perfil",
+	"list needs a volume to work on":                                                 "list necesita un volumen",
+	"recompress needs a compression algo to use":                                     "recompress necesita un algoritmo de compresión",
+	"remove needs a volume and the ID of a key to be removed":                        "remove necesita un volumen y el ID de la clave que eliminar",
+	"remove needs the ID of a key to be removed":                                     "remove necesita el ID de la clave que eliminar",
+	"repair needs at most a volume and a snapshot ID":                                "repair necesita como máximo un volumen y un ID de instantánea",
+	"run needs the alias of a profile":                                               "run necesita el alias de un perfil",
+	"service needs to know which service manager to generate a definition for":       "service necesita saber para qué gestor de servicios generar una definición",
+	"storagebench needs the URL of at least one backend to benchmark":                "storagebench necesita la URL de al menos un backend que evaluar",
+	"--asymmetric needs a file to write the private key to, use --private-key":       "--asymmetric necesita un archivo donde escribir la clave privada, use --private-key",
+	"--json can't be used while writing the archive to stdout":                       "--json no se puede usar mientras se escribe el archivo en stdout",
+	"--strip-prefix needs to be an absolute path":                                    "--strip-prefix debe ser una ruta absoluta",
+	"--watch only works with paths of the local file system":                         "--watch solo funciona con rutas del sistema de archivos local",
+	"restore can't export to an archive and restore to a directory at the same time": "restore no puede exportar a un archivo y restaurar en un directorio a la vez",
+	"store can't pass the data read from stdin through processors":                   "store no puede pasar por procesadores los datos leídos de stdin",
+	"store can't read from stdin and store other files at the same time":             "store no puede leer de stdin y almacenar otros archivos a la vez",
+	"store can't watch the paths during a dry run":                                   "store no puede vigilar las rutas durante una simulación",
+	"Adding to tree: %s":                                                             "Añadiendo al árbol: %s",
+	"Adding to index: %s":                                                            "Añadiendo al índice: %s",
+}
@@ -0,0 +1,439 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package i18n
+
+// French translations.
+var fr = map[string]string{
+	// log levels
+	"Fatal":   "Erreur fatale",
+	"Error":   "Erreur",
+	"Warning": "Avertissement",
+	"Print":   "Sortie",
+	"Info":    "Info",
+	"Debug":   "Débogage",
+
+	// progress & summary
+	"Files":                    "Fichiers",
+	"Dirs":                     "Dossiers",
+	"SymLinks":                 "Liens",
+	"Errors":                   "Erreurs",
+	"Original Size":            "Taille d'origine",
+	"Storage Size":             "Taille stockée",
+	"Duration":                 "Durée",
+	"Speed":                    "Vitesse",
+	"Total":                    "Total",
+	"%s / %s (%s of %s)  %s/s": "%s / %s (%s sur %s)  %s/s",
+	"%s  failed: %v":           "%s  échec : %v",
+	"... and %d more":          "... et %d de plus",
+	"Aborting...":              "Abandon...",
+
+	// store, restore & verify
+	"Snapshot %s created":                         "Instantané %s créé",
+	"'%s': failed to store: %v":                   "'%s' : échec de la sauvegarde : %v",
+	"'%s' failed to restore: %v":                  "'%s' : échec de la restauration : %v",
+	"All symlinks point inside the restored tree": "Tous les liens symboliques pointent dans l'arborescence restaurée",
+	"%d symlinks need to be checked:":             "%d liens symboliques doivent être vérifiés :",
+	"'%s' -> '%s': %s":                            "'%s' -> '%s' : %s",
+	"points outside of the restored tree":         "pointe hors de l'arborescence restaurée",
+	"target does not exist":                       "la cible n'existe pas",
+	"Verify failed: %v":                           "Échec de la vérification : %v",
+	"Verify repository done: %d errors":           "Vérification du dépôt terminée : %d erreurs",
+	"Verify volume done: %d errors":               "Vérification du volume terminée : %d erreurs",
+	"Verify snapshot done: %d errors":             "Vérification de l'instantané terminée : %d erreurs",
+	"failure tolerance can't be equal or higher as the number of storage backends": "la tolérance aux pannes doit être inférieure au nombre de backends de stockage",
+	"please specify a directory to restore to":                                     "veuillez indiquer un dossier de destination pour la restauration",
+
+	// repositories, volumes & snapshots
+	"Created new repository at %s":         "Nouveau dépôt créé à %s",
+	"Creating repository at %s failed: %v": "La création du dépôt à %s a échoué : %v",
+	"Changed password successfully":        "Mot de passe modifié avec succès",
+	"Added %s to repository":               "%s ajouté au dépôt",
+	"Freed storage space: %s":              "Espace de stockage libéré : %s",
+	"Volume %s (%s) created":               "Volume %s (%s) créé",
+	"Creating volume %s failed: %v":        "La création du volume %s a échoué : %v",
+	"Volume %s '%s' successfully removed":  "Volume %s '%s' supprimé avec succès",
+	"Snapshot %s removed: %s":              "Instantané %s supprimé : %s",
+	"Do not forget to run 'repo pack' to delete un-referenced chunks and free up storage space!": "N'oubliez pas d'exécuter 'repo pack' pour supprimer les blocs non référencés et libérer de l'espace de stockage !",
+	"%s: No such file or directory": "%s : aucun fichier ou dossier de ce nom",
+
+	// mount
+	"Mountpoint %s doesn't exist, creating it": "Le point de montage %s n'existe pas, création en cours",
+	"Updating index":      "Mise à jour de l'index",
+	"Updating index done": "Mise à jour de l'index terminée",
+	"Error umounting: %s": "Erreur lors du démontage : %s",
+
+	// configuration
+	"Writing configuration file to: %s":                                         "Écriture du fichier de configuration dans : %s",
+	"Error loading the specified alias":                                         "Erreur lors du chargement de l'alias indiqué",
+	"Error reading the config file: %v":                                         "Erreur lors de la lecture du fichier de configuration : %v",
+	"Error parsing the toml config file at '%s': %v":                            "Erreur lors de l'analyse du fichier de configuration toml '%s' : %v",
+	`Error setting log level "%s": %s. Using default log level Info instead.`:   `Erreur lors du réglage du niveau de journalisation "%s" : %s. Utilisation du niveau par défaut Info.`,
+	"Specify either repository directory '-r' or an alias '-R'":                 "Indiquez soit un dossier de dépôt '-r', soit un alias '-R'",
+	"Specify either quiet '-q' or verbose '-v' output":                          "Indiquez soit une sortie silencieuse '-q', soit une sortie détaillée '-v'",
+	"No alias with name %s found":                                               "Aucun alias nommé %s trouvé",
+	"Unknown configuration option: %s":                                          "Option de configuration inconnue : %s",
+	"Failed to convert %s to uint for the fault tolerance option: %v":           "Impossible de convertir %s en uint pour l'option de tolérance aux pannes : %v",
+	"config set needs to work on an alias and a option like this: alias.option": "config set attend un alias et une option sous la forme : alias.option",
+
+	// stats & reports
+	"Snapshots:          %d": "Instantanés :       %d",
+	"Original size:      %s": "Taille d'origine :  %s",
+	"Chunks:             %d": "Blocs :             %d",
+	"Stored size:        %s (deduplication & compression ratio %.2f)": "Taille stockée :    %s (taux de déduplication et compression %.2f)",
+	"Parity overhead:    %s":                                              "Surcoût de parité : %s",
+	"Unreferenced:       %s (released by 'repo pack')":                    "Non référencé :     %s (libéré par 'repo pack')",
+	"Skipping metadata of snapshot %s of locked volume %s":                "Métadonnées de l'instantané %s du volume verrouillé %s ignorées",
+	"Compared snapshots: %d":                                              "Instantanés comparés : %d",
+	"Compared chunks:    %d":                                              "Blocs comparés :       %d",
+	"The repository metadata differs":                                     "Les métadonnées des dépôts diffèrent",
+	"The chunk-index differs":                                             "L'index des blocs diffère",
+	"Snapshot %s is missing in %s":                                        "L'instantané %s manque dans %s",
+	"Snapshot %s differs":                                                 "L'instantané %s diffère",
+	"Chunk %s is missing in %s":                                           "Le bloc %s manque dans %s",
+	"Chunk %s differs in size":                                            "La taille du bloc %s diffère",
+	"Sizes of chunks could not be compared, only checked that they exist": "Impossible de comparer la taille des blocs, seule leur présence a été vérifiée",
+	"Both repositories store the same data":                               "Les deux dépôts stockent les mêmes données",
+	"The repositories differ":                                             "Les dépôts diffèrent",
+	"%d added, %d removed, %d modified":                                   "%d ajoutés, %d supprimés, %d modifiés",
+
+	// store & watch
+	"'%s': failed to read: %v": "'%s' : échec de la lecture : %v",
+	"'%s': flagged by %s: %s":  "'%s' : signalé par %s : %s",
+	"Dry run: would store %d items in %d new chunks, taking %s of storage space": "Simulation : %d éléments seraient stockés dans %d nouveaux blocs, occupant %s d'espace de stockage",
+	"Files since snapshot %s: %d new, %d changed, %d unmodified, %d removed":     "Fichiers depuis l'instantané %s : %d nouveaux, %d modifiés, %d inchangés, %d supprimés",
+	"Resuming interrupted snapshot %s":                                           "Reprise de l'instantané interrompu %s",
+	"Ignoring unreadable checkpoint: %v":                                         "Point de reprise illisible ignoré : %v",
+	"Writing checkpoint failed: %v":                                              "L'écriture du point de reprise a échoué : %v",
+	"Removing checkpoint failed: %v":                                             "La suppression du point de reprise a échoué : %v",
+	"Run the same command again to resume storing this snapshot":                 "Exécutez à nouveau la même commande pour reprendre la sauvegarde de cet instantané",
+	"Loading baseline snapshot %s failed: %v":                                    "Le chargement de l'instantané de référence %s a échoué : %v",
+	"Not using the change cache: %v":                                             "Le cache des modifications n'est pas utilisé : %v",
+	"Saving the change cache failed: %v":                                         "L'enregistrement du cache des modifications a échoué : %v",
+	"Invalid redaction %s, expected host[=strip], owner or path=[name]":          "Masquage %s invalide, attendu : host[=strip], owner ou path=[name]",
+	"Invalid processor %s, expected name=command":                                "Processeur %s invalide, attendu : name=command",
+	"Running %s hook: %s":                                                        "Exécution du hook %s : %s",
+	"Running %s hook failed: %v":                                                 "L'exécution du hook %s a échoué : %v",
+	"storing the snapshot has been aborted":                                      "la sauvegarde de l'instantané a été interrompue",
+	"Watching %s for changes":                                                    "Surveillance des modifications de %s",
+	"Watching %s failed: %v":                                                     "La surveillance de %s a échoué : %v",
+	"Watching for changes failed: %v":                                            "La surveillance des modifications a échoué : %v",
+	"Changed: %s":                                                                "Modifié : %s",
+	"Storing changes of %s":                                                      "Sauvegarde des modifications de %s",
+	"Storing changes failed: %v":                                                 "La sauvegarde des modifications a échoué : %v",
+	"The quiet period needs to be positive":                                      "La période de calme doit être positive",
+
+	// restore
+	"Restoring %d files, %d directories and %d symlinks (%s) to %s": "Restauration de %d fichiers, %d dossiers et %d liens symboliques (%s) vers %s",
+	"Downloading %s in %d chunks":                                   "Téléchargement de %s en %d blocs",
+	"    %s from %s":                                                "    %s depuis %s",
+	"Start restoring?":                                              "Démarrer la restauration ?",
+	"Restore aborted":                                               "Restauration annulée",
+	"Snapshot %s isn't tagged with %s":                              "L'instantané %s n'est pas étiqueté %s",
+	"Can't tell the archive format of %s, use --archive-format":     "Impossible de déterminer le format d'archive de %s, utilisez --archive-format",
+	"invalid size %s: %v":                                           "taille %s invalide : %v",
+	"%s: Is a directory":                                            "%s : est un dossier",
+
+	// verify & repair
+	"Verifying repository...":             "Vérification du dépôt...",
+	"Verify repository done: no errors":   "Vérification du dépôt terminée : aucune erreur",
+	"Verify repository failed: %d errors": "Échec de la vérification du dépôt : %d erreurs",
+	"Skipping verification, the repository's private key is required to read its data":   "Vérification ignorée, la clé privée du dépôt est nécessaire pour lire ses données",
+	"Can't list the chunks stored in %s, skipped checking for missing & orphaned chunks": "Impossible de lister les blocs stockés dans %s, la recherche de blocs manquants et orphelins a été ignorée",
+	"Chunk %s is missing":                                           "Le bloc %s est manquant",
+	"Chunk %s is missing in the chunk-index":                        "Le bloc %s manque dans l'index des blocs",
+	"Chunk %s isn't referenced by any snapshot":                     "Le bloc %s n'est référencé par aucun instantané",
+	"Chunk %s is indexed for snapshots that don't reference it":     "Le bloc %s est indexé pour des instantanés qui ne le référencent pas",
+	"Snapshot %s can't be read: %s":                                 "L'instantané %s est illisible : %s",
+	"Repaired":                                                      "Réparé",
+	"Can repair":                                                    "Réparable",
+	"%s missing part %d of chunk %s":                                "%s : partie manquante %d du bloc %s",
+	"%s corrupt part %d of chunk %s":                                "%s : partie corrompue %d du bloc %s",
+	"Chunk %s can't be repaired: %s":                                "Le bloc %s ne peut pas être réparé : %s",
+	"Repair done: %d chunks checked, %d repaired, %d unrepairable":  "Réparation terminée : %d blocs vérifiés, %d réparés, %d irréparables",
+	"%s still miss data, run 'repo heal' once they are available":   "Il manque encore des données à %s, exécutez 'repo heal' dès qu'ils seront disponibles",
+	"All storage backends are up to date":                           "Tous les backends de stockage sont à jour",
+	"Copied %d chunks and %d snapshots to lagging storage backends": "%d blocs et %d instantanés copiés vers les backends de stockage en retard",
+
+	// salvage
+	"Couldn't open repository (%v), recreating it from the data key": "Impossible d'ouvrir le dépôt (%v), reconstruction à partir de la clé de données",
+	"Key fingerprint: %s":                      "Empreinte de la clé : %s",
+	"Orphaned chunks with a chunk header: %d":  "Chunks orphelins avec en-tête : %d",
+	"Added %d snapshots to volume %s":          "%d instantanés ajoutés au volume %s",
+	"Readable snapshots:     %d":               "Instantanés lisibles :       %d",
+	"Unreferenced snapshots: %d":               "Instantanés non référencés : %d",
+	"Recoverable archives:   %d":               "Archives récupérables :      %d",
+	"Damaged archives:       %d":               "Archives endommagées :       %d",
+	"Orphaned chunks:        %d":               "Blocs orphelins :            %d",
+	"Archive %s in snapshot %s is damaged: %s": "L'archive %s de l'instantané %s est endommagée : %s",
+	"Stored data could not be listed for %s, only snapshots referenced by volumes were checked": "Impossible de lister les données stockées de %s, seuls les instantanés référencés par des volumes ont été vérifiés",
+
+	// snapshots & retention
+	"Removing snapshot %s (%s)":                                   "Suppression de l'instantané %s (%s)",
+	"Removing %d items from snapshot %s (%s)":                     "Suppression de %d éléments de l'instantané %s (%s)",
+	"Kept %d snapshots, removed %d and pruned %d":                 "%d instantanés conservés, %d supprimés et %d élagués",
+	"Would keep %d snapshots, remove %d and prune %d":             "%d instantanés seraient conservés, %d supprimés et %d élagués",
+	"Annotated snapshot %s":                                       "Instantané %s annoté",
+	"Snapshot %s has no annotation %s":                            "L'instantané %s n'a pas d'annotation %s",
+	"Invalid annotation %s, expected key=value":                   "Annotation %s invalide, attendu : key=value",
+	"Invalid annotation %s: %v":                                   "Annotation %s invalide : %v",
+	"(partial, based on %s)":                                      "(partiel, basé sur %s)",
+	"never":                                                       "jamais",
+	"%d errors":                                                   "%d erreurs",
+	"invalid date %s, expected YYYY-MM-DD or YYYY-MM-DD HH:MM:SS": "date %s invalide, attendu : YYYY-MM-DD ou YYYY-MM-DD HH:MM:SS",
+	"invalid path retention policy %s, expected [path]:[policy]":  "règle de conservation par chemin %s invalide, attendu : [path]:[policy]",
+
+	// copy, migrate & rewrite
+	"Enter password of %s:":                                                                 "Saisissez le mot de passe de %s :",
+	"Created volume %s (%s) in %s":                                                          "Volume %s (%s) créé dans %s",
+	"Loading snapshot %s failed: %v":                                                        "Le chargement de l'instantané %s a échoué : %v",
+	"Skipping snapshot %s, it already exists in %s":                                         "Instantané %s ignoré, il existe déjà dans %s",
+	"Copied snapshot %s to volume %s: %d chunks, %s stored":                                 "Instantané %s copié dans le volume %s : %d blocs, %s stockés",
+	"Copying snapshot %s failed: %v":                                                        "La copie de l'instantané %s a échoué : %v",
+	"Aborted copying snapshot %s":                                                           "Copie de l'instantané %s interrompue",
+	"Upgraded repository from version %d to %d":                                             "Dépôt mis à niveau de la version %d à %d",
+	"Data can only be re-encrypted with aes or aes-gcm":                                     "Les données ne peuvent être rechiffrées qu'avec aes ou aes-gcm",
+	"Repositories using asymmetric encryption always seal their data with their public key": "Les dépôts à chiffrement asymétrique scellent toujours leurs données avec leur clé publique",
+	"Rewrote snapshot %s (%d/%d): %d chunks, %s stored":                                     "Instantané %s réécrit (%d/%d) : %d blocs, %s stockés",
+	"Rewriting snapshot %s failed: %v":                                                      "La réécriture de l'instantané %s a échoué : %v",
+	"Aborting, run %s again to resume":                                                      "Interruption, exécutez à nouveau %s pour reprendre",
+	"Migrated %d snapshots, re-encrypted %d chunks, freed %s":                               "%d instantanés migrés, %d blocs rechiffrés, %s libérés",
+	"Rotated key, new key fingerprint: %s":                                                  "Clé renouvelée, empreinte de la nouvelle clé : %s",
+	"The old key has been removed from the repository":                                      "L'ancienne clé a été supprimée du dépôt",
+	"Resuming key rotation, new key fingerprint: %s":                                        "Reprise du renouvellement de clé, empreinte de la nouvelle clé : %s",
+	"Re-encrypted snapshot %s: %d chunks, %s stored":                                        "Instantané %s rechiffré : %d blocs, %s stockés",
+	"Re-encrypting snapshot %s failed: %v":                                                  "Le rechiffrement de l'instantané %s a échoué : %v",
+	"Aborting, run rotate-key again to resume":                                              "Interruption, exécutez à nouveau rotate-key pour reprendre",
+	"Re-encrypted %d snapshots and %d chunks, freed %s":                                     "%d instantanés et %d blocs rechiffrés, %s libérés",
+	"Chunker settings: %s":                                                                  "Paramètres du découpage : %s",
+	"Skipping volume %s, which is protected by its own key":                                 "Volume %s ignoré, il est protégé par sa propre clé",
+	"Snapshot %s has been rechunked already":                                                "L'instantané %s a déjà été redécoupé",
+	"Rechunked snapshot %s: %d files, %s stored":                                            "Instantané %s redécoupé : %d fichiers, %s stockés",
+	"Rechunking snapshot %s failed: %v":                                                     "Le redécoupage de l'instantané %s a échoué : %v",
+	"Aborting, run rechunk again to resume":                                                 "Interruption, exécutez à nouveau rechunk pour reprendre",
+	"Rechunked %d snapshots, stored %s of new chunks":                                       "%d instantanés redécoupés, %s de nouveaux blocs stockés",
+	"Recompressed %d snapshots and %d chunks, stored %s, freed %s":                          "%d instantanés et %d blocs recompressés, %s stockés, %s libérés",
+
+	// storage tiers
+	"Please specify a tier with --tier, supported tiers: %s":                                                              "Veuillez indiquer une classe de stockage avec --tier, classes prises en charge : %s",
+	"Moving %s of chunks to tier %s":                                                                                      "Déplacement de %s de blocs vers la classe de stockage %s",
+	"Moving %d chunks back to tier %s":                                                                                    "Retour de %d blocs vers la classe de stockage %s",
+	"Aborting, run tier again to resume":                                                                                  "Interruption, exécutez à nouveau tier pour reprendre",
+	"%s of chunks are stored in tier %s, restoring them may take longer and incur retrieval costs":                        "%s de blocs sont stockés dans la classe %s, leur restauration peut prendre plus de temps et entraîner des frais de récupération",
+	"Use --wait-for-archive to retrieve chunks from archive tiers first, --hydrate to move them back to the default tier": "Utilisez --wait-for-archive pour récupérer d'abord les blocs des classes d'archivage, ou --hydrate pour les ramener dans la classe par défaut",
+	"Retrieving archived chunks: %d of %d available":                                                                      "Récupération des blocs archivés : %d sur %d disponibles",
+
+	// keys & passwords
+	"Added key %s":                                              "Clé %s ajoutée",
+	"Removed key %s":                                            "Clé %s supprimée",
+	"Added key %s to volume %s":                                 "Clé %s ajoutée au volume %s",
+	"Removed key %s from volume %s":                             "Clé %s supprimée du volume %s",
+	"Enter password of volume %s:":                              "Saisissez le mot de passe du volume %s :",
+	"Confirm password:":                                         "Confirmez le mot de passe :",
+	"Enter password:":                                           "Saisir le mot de passe :",
+	"Enter new password:":                                       "Saisir le nouveau mot de passe :",
+	"Enter a password to protect this volume with:":             "Saisissez un mot de passe pour protéger ce volume :",
+	"Enter a password to encrypt the bundle with:":              "Saisissez un mot de passe pour chiffrer le bundle :",
+	"Enter the password of the bundle:":                         "Saisir le mot de passe du bundle :",
+	"Leave the password empty to generate a strong passphrase.": "Laissez le mot de passe vide pour générer une phrase secrète robuste.",
+	"Your passphrase is:":                                       "Votre phrase secrète est :",
+	"Write it down and keep it in a safe place. Without it you won't be able to access your data!": "Notez-la et conservez-la en lieu sûr. Sans elle, vous ne pourrez pas accéder à vos données !",
+	"Password is weak (estimated time to crack: %s).":                                              "Le mot de passe est faible (temps estimé pour le casser : %s).",
+	"Are you sure you want to use this password (y/N)?: ":                                          "Voulez-vous vraiment utiliser ce mot de passe (y/N) ? : ",
+	"Unlocked volume %s":               "Volume %s déverrouillé",
+	"Protecting volume %s failed: %v":  "La protection du volume %s a échoué : %v",
+	"Reading password file failed: %v": "La lecture du fichier de mot de passe a échoué : %v",
+	"Password file %s is accessible by other users, consider restricting its permissions to 0600": "Le fichier de mot de passe %s est accessible aux autres utilisateurs, pensez à restreindre ses permissions à 0600",
+	"Running password command failed: %v":                  "L'exécution de la commande de mot de passe a échoué : %v",
+	"The password read is empty":                           "Le mot de passe lu est vide",
+	"Saved the password in the OS keyring":                 "Mot de passe enregistré dans le trousseau du système",
+	"Saving the password in the OS keyring failed: %v":     "L'enregistrement du mot de passe dans le trousseau du système a échoué : %v",
+	"Reading the password from the OS keyring failed: %v":  "La lecture du mot de passe depuis le trousseau du système a échoué : %v",
+	"Removed the password from the OS keyring":             "Mot de passe supprimé du trousseau du système",
+	"Removing the password from the OS keyring failed: %v": "La suppression du mot de passe du trousseau du système a échoué : %v",
+	"The OS keyring holds no password for %s":              "Le trousseau du système ne contient aucun mot de passe pour %s",
+	"The password saved in the OS keyring doesn't open the repository, run 'knoxite repo forget-password' to remove it": "Le mot de passe enregistré dans le trousseau du système n'ouvre pas le dépôt, supprimez-le avec 'knoxite repo forget-password'",
+	"Changed password hint successfully":                                       "Indice de mot de passe modifié avec succès",
+	"Password hint: %s":                                                        "Indice de mot de passe : %s",
+	"This repository has no password hint":                                     "Ce dépôt n'a pas d'indice de mot de passe",
+	"Private key file %s already exists":                                       "Le fichier de clé privée %s existe déjà",
+	"Reading private key from %s failed: %v":                                   "La lecture de la clé privée depuis %s a échoué : %v",
+	"Writing private key failed: %v, it is %s":                                 "L'écriture de la clé privée a échoué : %v, elle vaut %s",
+	"Wrote private key to %s, keep it safe: it's required to restore any data": "Clé privée écrite dans %s, conservez-la en lieu sûr : elle est nécessaire pour restaurer la moindre donnée",
+
+	// repository settings
+	"Equal data gets stored once per volume":                                             "Les données identiques sont stockées une fois par volume",
+	"Equal data gets stored once in the whole repository":                                "Les données identiques sont stockées une fois dans tout le dépôt",
+	"Equal data gets stored once per volume from now on":                                 "Les données identiques sont désormais stockées une fois par volume",
+	"Equal data gets stored once in the whole repository from now on":                    "Les données identiques sont désormais stockées une fois dans tout le dépôt",
+	"The repository is append-only now, only the password in use can remove data":        "Le dépôt est désormais en ajout seul, seul le mot de passe utilisé peut supprimer des données",
+	"The repository is not append-only anymore":                                          "Le dépôt n'est plus en ajout seul",
+	"The repository is not append-only":                                                  "Le dépôt n'est pas en ajout seul",
+	"The repository is append-only, the key in use can't remove any data":                "Le dépôt est en ajout seul, la clé utilisée ne peut supprimer aucune donnée",
+	"The repository is append-only, the key in use is an admin key":                      "Le dépôt est en ajout seul, la clé utilisée est une clé d'administration",
+	"The repository has been written by a newer version of knoxite and can only be read": "Le dépôt a été écrit par une version plus récente de knoxite et ne peut être que lu",
+	"Use --force-read-only to try reading it anyway":                                     "Utilisez --force-read-only pour tenter de le lire malgré tout",
+	"Would delete chunk %s (%s)":                                                         "Le bloc %s (%s) serait supprimé",
+	"Would free storage space: %s by deleting %d chunks":                                 "Espace de stockage qui serait libéré : %s en supprimant %d blocs",
+	"Wrote recovery sheet to %s":                                                         "Fiche de récupération écrite dans %s",
+
+	// locks
+	"%v, aborting": "%v, interruption",
+	"If the client holding the lock crashed, remove it with 'knoxite unlock --all'": "Si le client qui détient le verrou a planté, supprimez-le avec 'knoxite unlock --all'",
+	"Releasing the repository lock failed: %v":                                      "La libération du verrou du dépôt a échoué : %v",
+	"Removed lock %s of %s@%s (PID %s)":                                             "Verrou %s de %s@%s (PID %s) supprimé",
+	"Removed %d locks":                                                              "%d verrous supprimés",
+
+	// recovery sheet
+	"KNOXITE RECOVERY SHEET": "FICHE DE RÉCUPÉRATION KNOXITE",
+	"Created:":               "Créée le :",
+	"Repository ID:":         "Identifiant du dépôt :",
+	"Storage:":               "Stockage :",
+	"Key fingerprint:":       "Empreinte de la clé :",
+	"Password hint:":         "Indice de mot de passe :",
+	"Password:":              "Mot de passe :",
+	"(none)":                 "(aucun)",
+	"To restore your data:":  "Pour restaurer vos données :",
+	"  1. Install knoxite, see https://github.com/knoxite/knoxite":  "  1. Installez knoxite, voir https://github.com/knoxite/knoxite",
+	"  2. Check that this command shows the key fingerprint above:": "  2. Vérifiez que cette commande affiche l'empreinte ci-dessus :",
+	"  3. Find the volume and snapshot you want to restore:":        "  3. Trouvez le volume et l'instantané à restaurer :",
+	"  4. Restore the snapshot:":                                    "  4. Restaurez l'instantané :",
+
+	// setup
+	"This will walk you through setting up a new knoxite repository.": "Cet assistant vous guide dans la création d'un nouveau dépôt knoxite.",
+	"Name of the profile":                                                  "Nom du profil",
+	"Profile %s already exists. Overwrite it?":                             "Le profil %s existe déjà. L'écraser ?",
+	"Storage backend":                                                      "Backend de stockage",
+	"Repository directory":                                                 "Dossier du dépôt",
+	"Path or bucket":                                                       "Chemin ou bucket",
+	"Host or account (if required)":                                        "Hôte ou compte (si nécessaire)",
+	"Username or access key (if required)":                                 "Nom d'utilisateur ou clé d'accès (si nécessaire)",
+	"Password or secret key (leave empty if not required):":                "Mot de passe ou clé secrète (laisser vide si non nécessaire) :",
+	"Enter a password to encrypt this repository with:":                    "Saisissez un mot de passe pour chiffrer ce dépôt :",
+	"Password hint, stored unencrypted (optional)":                         "Indice de mot de passe, stocké en clair (facultatif)",
+	"Compression":                                                          "Compression",
+	"Encryption":                                                           "Chiffrement",
+	"Name of the volume for your backups":                                  "Nom du volume pour vos sauvegardes",
+	"Description of the volume":                                            "Description du volume",
+	"Files and directories to back up (comma separated)":                   "Fichiers et dossiers à sauvegarder (séparés par des virgules)",
+	"Exclude patterns (comma separated)":                                   "Motifs d'exclusion (séparés par des virgules)",
+	"How often should snapshots be stored":                                 "À quelle fréquence stocker les instantanés",
+	"Print a recovery sheet to keep in a safe place?":                      "Imprimer une fiche de récupération à conserver en lieu sûr ?",
+	"Please pick one of: %s":                                               "Veuillez choisir parmi : %s",
+	"%v, please try again.":                                                "%v, veuillez réessayer.",
+	"Invalid jitter %s: %v":                                                "Variation aléatoire %s invalide : %v",
+	"Setup aborted":                                                        "Configuration annulée",
+	"Volume %s (Name: %s) created":                                         "Volume %s (nom : %s) créé",
+	"You can now store a snapshot with: knoxite -R %s store":               "Vous pouvez maintenant stocker un instantané avec : knoxite -R %s store",
+	"You can now store a snapshot with: knoxite -R %s store %s [dir/file]": "Vous pouvez maintenant stocker un instantané avec : knoxite -R %s store %s [dir/file]",
+	"To store snapshots %s, add this line to your crontab (and set KNOXITE_PASSWORD):": "Pour stocker des instantanés %s, ajoutez cette ligne à votre crontab (et définissez KNOXITE_PASSWORD) :",
+	"Invalid percentage %s, expected a number between 0 and 100":                       "Pourcentage %s invalide, attendu : un nombre entre 0 et 100",
+	"Unknown schedule %s, use one of: %s or a cron expression":                         "Planification %s inconnue, utilisez l'une de : %s ou une expression cron",
+	"Exported %d profiles and %d passwords to %s":                                      "%d profils et %d mots de passe exportés dans %s",
+	"Imported %d profiles from %s, exported on %s by %s":                               "%d profils importés depuis %s, exportés le %s par %s",
+	"Skipping profile %s, it already exists (use --overwrite to replace it)":           "Profil %s ignoré, il existe déjà (utilisez --overwrite pour le remplacer)",
+	"Profile %s refers to %s, which doesn't exist on this machine":                     "Le profil %s fait référence à %s, qui n'existe pas sur cette machine",
+	"Unknown alias %s": "Alias %s inconnu",
+	"Unknown service manager %s, expected one of: %s": "Gestionnaire de services %s inconnu, attendu l'un de : %s",
+	"Error opening log file: %v":                      "Erreur lors de l'ouverture du fichier journal : %v",
+
+	// daemon & server
+	"Listening for commands on %s":              "En attente de commandes sur %s",
+	"Another daemon is listening on %s already": "Un autre démon écoute déjà sur %s",
+	"Connecting to the daemon failed: %v":       "La connexion au démon a échoué : %v",
+	"--max-jobs needs to be at least 1":         "--max-jobs doit valoir au moins 1",
+	"No profiles with a volume and store paths found, configure them with 'knoxite config set'": "Aucun profil avec un volume et des chemins à sauvegarder trouvé, configurez-les avec 'knoxite config set'",
+	"Profile %s needs a volume and store paths":                                                 "Le profil %s attend un volume et des chemins à sauvegarder",
+	"Invalid schedule of profile %s: %v":                                                        "Planification du profil %s invalide : %v",
+	"Invalid bandwidth %s: %v":                                                                  "Bande passante %s invalide : %v",
+	"Ignoring unreadable daemon state: %v":                                                      "État du démon illisible ignoré : %v",
+	"Writing daemon state failed: %v":                                                           "L'écriture de l'état du démon a échoué : %v",
+	"Next snapshot of %s: %s":                                                                   "Prochain instantané de %s : %s",
+	"Waiting for a running job to finish before storing snapshot of %s":                         "Attente de la fin d'une tâche en cours avant de stocker l'instantané de %s",
+	"Storing snapshot of %s":                                                                    "Stockage de l'instantané de %s",
+	"Stored snapshot of %s in %s":                                                               "Instantané de %s stocké en %s",
+	"Storing snapshot of %s failed: %v":                                                         "Le stockage de l'instantané de %s a échoué : %v",
+	"A snapshot of %s is being stored already":                                                  "Un instantané de %s est déjà en cours de stockage",
+	"A snapshot of %s is queued already":                                                        "Un instantané de %s est déjà en file d'attente",
+	"Too many pending requests":                                                                 "Trop de requêtes en attente",
+	"Unknown command %s":                                                                        "Commande %s inconnue",
+	"Job failed: %v":                                                                            "Échec de la tâche : %v",
+	"Please specify the directory to store the repositories in with --root":                     "Veuillez indiquer avec --root le dossier où stocker les dépôts",
+	"--tls-cert and --tls-key need to be specified together":                                    "--tls-cert et --tls-key doivent être indiqués ensemble",
+	"Loading users failed: %v, add users with 'serve adduser'":                                  "Le chargement des utilisateurs a échoué : %v, ajoutez des utilisateurs avec 'serve adduser'",
+	"Serving without TLS, passwords and data get transferred unencrypted":                       "Service sans TLS, les mots de passe et les données sont transmis en clair",
+	"Serving %d users on %s":                                                                    "Service de %d utilisateurs sur %s",
+	"User %s can access the server now":                                                         "L'utilisateur %s peut maintenant accéder au serveur",
+	"Serving metrics on http://%s/metrics":                                                      "Métriques disponibles sur http://%s/metrics",
+	"Serving metrics failed: %v":                                                                "La mise à disposition des métriques a échoué : %v",
+	"Ignoring unreadable metrics file: %v":                                                      "Fichier de métriques illisible ignoré : %v",
+	"Writing metrics failed: %v":                                                                "L'écriture des métriques a échoué : %v",
+	"Serving status page on http://%s/":                                                         "Page d'état disponible sur http://%s/",
+	"Serving status page failed: %v":                                                            "La mise à disposition de la page d'état a échoué : %v",
+	"Rendering status page failed: %v":                                                          "Le rendu de la page d'état a échoué : %v",
+	"Running workload %s against %s":                                                            "Exécution de la charge %s sur %s",
+	"Stage":                                                                                     "Étape",
+	"Time":                                                                                      "Durée",
+	"Share":                                                                                     "Part",
+
+	// command arguments
+	"add needs a URL to be added":                                                    "add attend une URL à ajouter",
+	"alias needs an ALIAS to set":                                                    "alias attend un ALIAS à définir",
+	"cat needs a snapshot ID and filename":                                           "cat attend un identifiant d'instantané et un nom de fichier",
+	"clone needs to know which snapshot to clone":                                    "clone doit savoir quel instantané cloner",
+	"clone needs to know which files and/or directories to work on":                  "clone doit savoir sur quels fichiers et/ou dossiers travailler",
+	"convert needs a source to work on":                                              "convert attend une source",
+	"convert needs a target to write to":                                             "convert attend une cible",
+	"docs needs a target directory":                                                  "docs attend un dossier cible",
+	"init needs a name for the new volume":                                           "init attend un nom pour le nouveau volume",
+	"list needs a volume ID to work on":                                              "list attend un identifiant de volume",
+	"ls needs a snapshot ID":                                                         "ls attend un identifiant d'instantané",
+	"mount needs to know which snapshot to work on":                                  "mount doit savoir quel instantané monter",
+	"mount needs to know where to mount the snapshot to":                             "mount doit savoir où monter l'instantané",
+	"remove needs a snapshot ID to work on":                                          "remove attend un identifiant d'instantané",
+	"remove needs a volume to work on":                                               "remove attend un volume",
+	"restore needs to know which snapshot to work on":                                "restore doit savoir quel instantané restaurer",
+	"set needs to know which option to set":                                          "set doit savoir quelle option définir",
+	"set needs to know which value to set":                                           "set doit savoir quelle valeur définir",
+	"store needs to know which volume to create a snapshot in":                       "store doit savoir dans quel volume créer l'instantané",
+	"store needs to know which files and/or directories to work on":                  "store doit savoir quels fichiers et/ou dossiers sauvegarder",
+	"annotate needs a snapshot ID to work on":                                        "annotate attend un identifiant d'instantané",
+	"append-only needs either on or off as argument":                                 "append-only attend on ou off comme argument",
+	"adduser needs to know the name of the user":                                     "adduser doit connaître le nom de l'utilisateur",
+	"add needs a volume to work on":                                                  "add attend un volume",
+	"cat needs to know which file to read":                                           "cat doit savoir quel fichier lire",
+	"compare needs the URLs of two repositories":                                     "compare attend les URL de deux dépôts",
+	"copy needs to know the destination repository, use --to":                        "copy doit connaître le dépôt de destination, utilisez --to",
+	"copy needs to know which snapshots to copy":                                     "copy doit savoir quels instantanés copier",
+	"dedup needs either repository or volume as argument":                            "dedup attend repository ou volume comme argument",
+	"diff needs the ID of a snapshot to compare with the local files":                "diff attend l'identifiant d'un instantané à comparer avec les fichiers locaux",
+	"diff needs the IDs of two snapshots to compare":                                 "diff attend les identifiants de deux instantanés à comparer",
+	"export needs a file to write the bundle to":                                     "export attend un fichier où écrire le paquet",
+	"forget needs a retention policy, use --keep or --keep-path":                     "forget attend une règle de conservation, utilisez --keep ou --keep-path",
+	"forget needs a volume ID to work on":                                            "forget attend un identifiant de volume",
+	"hint needs the hint as a single (quoted) argument":                              "hint attend l'indice comme argument unique (entre guillemets)",
+	"import needs a bundle to read":                                                  "import attend un paquet à lire",
+	"job needs the alias of a profile":                                               "job attend l'alias d'un profil",
+	"list needs a volume to work on":                                                 "list attend un volume",
+	"recompress needs a compression algo to use":                                     "recompress attend un algorithme de compression",
+	"remove needs a volume and the ID of a key to be removed":                        "remove attend un volume et l'identifiant de la clé à supprimer",
+	"remove needs the ID of a key to be removed":                                     "remove attend l'identifiant de la clé à supprimer",
+	"repair needs at most a volume and a snapshot ID":                                "repair attend au plus un volume et un identifiant d'instantané",
+	"run needs the alias of a profile":                                               "run attend l'alias d'un profil",
+	"service needs to know which service manager to generate a definition for":       "service doit savoir pour quel gestionnaire de services générer une définition",
+	"storagebench needs the URL of at least one backend to benchmark":                "storagebench attend l'URL d'au moins un backend à évaluer",
+	"--asymmetric needs a file to write the private key to, use --private-key":       "--asymmetric attend un fichier où écrire la clé privée, utilisez --private-key",
+	"--json can't be used while writing the archive to stdout":                       "--json ne peut pas être utilisé en écrivant l'archive sur stdout",
+	"--strip-prefix needs to be an absolute path":                                    "--strip-prefix doit être un chemin absolu",
+	"--watch only works with paths of the local file system":                         "--watch ne fonctionne qu'avec des chemins du système de fichiers local",
+	"restore can't export to an archive and restore to a directory at the same time": "restore ne peut pas exporter une archive et restaurer dans un dossier en même temps",
+	"store can't pass the data read from stdin through processors":                   "store ne peut pas faire passer les données lues sur stdin par des processeurs",
+	"store can't read from stdin and store other files at the same time":             "store ne peut pas lire sur stdin et stocker d'autres fichiers en même temps",
+	"store can't watch the paths during a dry run":                                   "store ne peut pas surveiller les chemins pendant une simulation",
+	"Adding to tree: %s":                                                             "Ajout à l'arborescence : %s",
+	"Adding to index: %s":                                                            "Ajout à l'index : %s",
+}
@@ -0,0 +1,89 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+// Package i18n provides localized versions of knoxite's user-facing messages.
+//
+// Messages are looked up by their English text. The language is selected via
+// the LC_ALL, LC_MESSAGES and LANG environment variables. Messages missing
+// from a catalog are printed in English.
+package i18n
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// catalogs maps the supported languages to their translations.
+var catalogs = map[language.Tag]map[string]string{
+	language.German:  de,
+	language.French:  fr,
+	language.Spanish: es,
+}
+
+var printer *message.Printer
+
+func init() {
+	for tag, catalog := range catalogs {
+		for key, msg := range catalog {
+			_ = message.SetString(tag, key, msg)
+		}
+	}
+
+	SetLanguage(Detect())
+}
+
+// Detect returns the user's preferred language from the environment.
+func Detect() language.Tag {
+	for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if s := os.Getenv(env); s != "" {
+			return parseLocale(s)
+		}
+	}
+
+	return language.English
+}
+
+// SetLanguage sets the language used for all messages.
+func SetLanguage(tag language.Tag) {
+	supported := []language.Tag{language.English}
+	for t := range catalogs {
+		supported = append(supported, t)
+	}
+
+	_, idx, _ := language.NewMatcher(supported).Match(tag)
+	printer = message.NewPrinter(supported[idx])
+}
+
+// Sprintf returns the localized and formatted message.
+func Sprintf(format string, a ...interface{}) string {
+	return printer.Sprintf(format, a...)
+}
+
+// Errorf returns an error containing the localized and formatted message.
+func Errorf(format string, a ...interface{}) error {
+	return errors.New(Sprintf(format, a...))
+}
+
+// parseLocale converts a POSIX locale like de_DE.UTF-8 to a language tag.
+func parseLocale(s string) language.Tag {
+	if i := strings.IndexAny(s, ".@"); i >= 0 {
+		s = s[:i]
+	}
+	if s == "C" || s == "POSIX" {
+		return language.English
+	}
+
+	tag, err := language.Parse(strings.ReplaceAll(s, "_", "-"))
+	if err != nil {
+		return language.English
+	}
+	return tag
+}
@@ -0,0 +1,153 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package i18n
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode"
+
+	"golang.org/x/text/language"
+
+	"github.com/knoxite/knoxite"
+)
+
+var verbs = regexp.MustCompile(`%[a-z]`)
+
+func TestCatalogs(t *testing.T) {
+	keys := messages(t)
+
+	for tag, catalog := range catalogs {
+		for key, msg := range catalog {
+			if a, b := verbs.FindAllString(key, -1), verbs.FindAllString(msg, -1); len(a) != len(b) {
+				t.Errorf("%s: translation of %q has mismatching format verbs", tag, key)
+			}
+		}
+
+		// all catalogs should be complete
+		for other, c := range catalogs {
+			for key := range c {
+				if _, ok := catalog[key]; !ok {
+					t.Errorf("%s: missing translation of %q (found in %s)", tag, key, other)
+				}
+			}
+		}
+
+		// as well as cover every message knoxite prints, including the
+		// prefixes of the log levels
+		for _, key := range keys {
+			if _, ok := catalog[key]; !ok {
+				t.Errorf("%s: missing translation of %q", tag, key)
+			}
+		}
+	}
+}
+
+// messages returns all messages, which get translated when knoxite prints
+// them: those passed to i18n.Sprintf and i18n.Errorf, those logged and the
+// names of the log levels.
+func messages(t *testing.T) []string {
+	files, err := filepath.Glob("../*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the prompts of the utils package get translated as well
+	utils, err := filepath.Glob("../utils/*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, utils...)
+
+	var keys []string
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatalf("Failed parsing %s: %s", file, err)
+		}
+
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok || len(call.Args) == 0 {
+				return true
+			}
+			sel, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			pkg, ok := sel.X.(*ast.Ident)
+			if !ok {
+				return true
+			}
+			lit, ok := call.Args[0].(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+			key, err := strconv.Unquote(lit.Value)
+			if err != nil || strings.IndexFunc(verbs.ReplaceAllString(key, ""), unicode.IsLetter) < 0 {
+				// nothing to translate
+				return true
+			}
+
+			switch {
+			case pkg.Name == "i18n" && (sel.Sel.Name == "Sprintf" || sel.Sel.Name == "Errorf"),
+				pkg.Name == "log" && strings.HasSuffix(sel.Sel.Name, "f"):
+				keys = append(keys, key)
+			case pkg.Name == "log":
+				// plain messages don't get translated by the logger
+				t.Errorf("%s: untranslated message %q, use i18n.Sprintf", fset.Position(lit.Pos()), key)
+			}
+			return true
+		})
+	}
+
+	for l := knoxite.LogLevelFatal; l <= knoxite.LogLevelDebug; l++ {
+		keys = append(keys, knoxite.LogLevel(l).String())
+	}
+	return keys
+}
+
+func TestParseLocale(t *testing.T) {
+	tests := map[string]language.Tag{
+		"de_DE.UTF-8":     language.MustParse("de-DE"),
+		"fr_FR@euro":      language.MustParse("fr-FR"),
+		"es":              language.Spanish,
+		"C":               language.English,
+		"POSIX":           language.English,
+		"not a language!": language.English,
+	}
+
+	for s, expected := range tests {
+		if tag := parseLocale(s); tag != expected {
+			t.Errorf("Expected %s for locale %s, got %s", expected, s, tag)
+		}
+	}
+}
+
+func TestSprintf(t *testing.T) {
+	defer SetLanguage(Detect())
+
+	SetLanguage(language.MustParse("de-AT"))
+	if s := Sprintf("Snapshot %s created", "abc"); s != "Snapshot abc erstellt" {
+		t.Errorf("Unexpected German translation: %s", s)
+	}
+
+	SetLanguage(language.Japanese)
+	if s := Sprintf("Snapshot %s created", "abc"); s != "Snapshot abc created" {
+		t.Errorf("Expected fallback to English, got: %s", s)
+	}
+}
@@ -0,0 +1,36 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// printJSON prints the result of a command as a single JSON document.
+func printJSON(v interface{}) error {
+	j, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", j)
+	return nil
+}
+
+// printEvent prints an event of a long running command as a single line of
+// JSON, so the output of a command can be consumed as newline-delimited JSON
+// while it's still running.
+func printEvent(v interface{}) {
+	j, err := json.Marshal(v)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	fmt.Printf("%s\n", j)
+}
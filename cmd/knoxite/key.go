@@ -0,0 +1,136 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"github.com/muesli/gotable"
+	"github.com/spf13/cobra"
+
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+	"github.com/knoxite/knoxite/cmd/knoxite/utils"
+)
+
+var (
+	keyCmd = &cobra.Command{
+		Use:   "key",
+		Short: "manage the passwords of a repository",
+		Long: `The key command manages the passwords, which grant access to a repository.
+Each password wraps the repository's master key, so passwords can be added,
+changed and revoked without re-encrypting any data`,
+		RunE: nil,
+	}
+	keyListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "list all keys of a repository",
+		Long:  `The list command lists all keys of a repository`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return executeKeyList()
+		},
+	}
+	keyAddCmd = &cobra.Command{
+		Use:   "add",
+		Short: "add another password to a repository",
+		Long: `The add command grants access to a repository with another password.
+Repositories created by older versions of knoxite can't be read by those
+anymore, once a key has been added`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return executeKeyAdd()
+		},
+	}
+	keyRemoveCmd = &cobra.Command{
+		Use:   "remove [key ID]",
+		Short: "revoke a password of a repository",
+		Long: `The remove command revokes access to a repository for a key. The key used to
+open the repository can't be removed, use another password instead`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return i18n.Errorf("remove needs the ID of a key to be removed")
+			}
+			return executeKeyRemove(args[0])
+		},
+	}
+	keyChangePasswordCmd = &cobra.Command{
+		Use:   "passwd",
+		Short: "change the password of the key in use",
+		Long: `The passwd command replaces the key used to open the repository with a key
+for a new password, e.g. after the password got compromised`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return executeRepoChangePassword()
+		},
+	}
+)
+
+func init() {
+	keyCmd.AddCommand(keyListCmd)
+	keyCmd.AddCommand(keyAddCmd)
+	keyCmd.AddCommand(keyRemoveCmd)
+	keyCmd.AddCommand(keyChangePasswordCmd)
+	RootCmd.AddCommand(keyCmd)
+}
+
+func executeKeyList() error {
+	r, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+
+	if globalOpts.JSON {
+		return printJSON(r.Keys())
+	}
+
+	tab := gotable.NewTable([]string{"", "ID", "Created", "Hostname", "User", "Admin"},
+		[]int64{-1, -8, -19, -24, -16, -5}, "No keys found. This repository is protected by a single password.")
+	for _, k := range r.Keys() {
+		current := ""
+		if k.ID == r.CurrentKey() {
+			current = "*"
+		}
+		admin := ""
+		if k.Admin {
+			admin = "yes"
+		}
+		tab.AppendRow([]interface{}{current, k.ID, k.Created.Format(timeFormat), k.Hostname, k.Username, admin})
+	}
+
+	_ = tab.Print()
+	return nil
+}
+
+func executeKeyAdd() error {
+	r, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+
+	password, err := utils.ReadPasswordTwice(i18n.Sprintf("Enter new password:"), i18n.Sprintf("Confirm password:"))
+	if err != nil {
+		return err
+	}
+
+	k, err := r.AddKey(password)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Added key %s", k.ID)
+	return nil
+}
+
+func executeKeyRemove(id string) error {
+	r, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+
+	if err := r.RemoveKey(id); err != nil {
+		return err
+	}
+
+	log.Printf("Removed key %s", id)
+	return nil
+}
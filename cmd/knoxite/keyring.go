@@ -0,0 +1,92 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"net/url"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/zalando/go-keyring"
+
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+)
+
+// keyringService is the service name the passwords are stored under in the
+// OS keyring (macOS Keychain, Secret Service or Windows Credential Manager).
+const keyringService = "knoxite"
+
+var (
+	// keyringPassword is true if the password has been read from the keyring
+	keyringPassword bool
+	// promptedPassword is true if the user typed in the password, it gets
+	// saved in the keyring once it opened the repository
+	promptedPassword bool
+
+	repoForgetPasswordCmd = &cobra.Command{
+		Use:   "forget-password",
+		Short: "remove the repository's password from the OS keyring",
+		Long:  `The forget-password command removes the repository's password from the OS keyring`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return executeRepoForgetPassword()
+		},
+	}
+)
+
+func init() {
+	repoCmd.AddCommand(repoForgetPasswordCmd)
+}
+
+// keyringAccount identifies a repository in the keyring. Local repositories
+// are identified by their absolute path, so the entry is found no matter
+// which directory knoxite gets run from.
+func keyringAccount(repo string) string {
+	if u, err := url.Parse(repo); err == nil && u.Scheme != "" && len(u.Scheme) > 1 {
+		return repo
+	}
+	if abs, err := filepath.Abs(repo); err == nil {
+		return abs
+	}
+	return repo
+}
+
+// passwordFromKeyring returns the password stored in the keyring for a
+// repository, if any.
+func passwordFromKeyring(repo string) (string, bool) {
+	password, err := keyring.Get(keyringService, keyringAccount(repo))
+	if err != nil {
+		if err != keyring.ErrNotFound {
+			log.Warnf("Reading the password from the OS keyring failed: %v", err)
+		}
+		return "", false
+	}
+	return password, password != ""
+}
+
+// savePasswordInKeyring stores the password of a repository in the keyring.
+// Failing to do so isn't fatal, the user just gets prompted again next time.
+func savePasswordInKeyring(repo, password string) {
+	if err := keyring.Set(keyringService, keyringAccount(repo), password); err != nil {
+		log.Warnf("Saving the password in the OS keyring failed: %v", err)
+		return
+	}
+	log.Info(i18n.Sprintf("Saved the password in the OS keyring"))
+}
+
+func executeRepoForgetPassword() error {
+	err := keyring.Delete(keyringService, keyringAccount(globalOpts.Repo))
+	if err == keyring.ErrNotFound {
+		return i18n.Errorf("The OS keyring holds no password for %s", globalOpts.Repo)
+	}
+	if err != nil {
+		return i18n.Errorf("Removing the password from the OS keyring failed: %v", err)
+	}
+
+	log.Print(i18n.Sprintf("Removed the password from the OS keyring"))
+	return nil
+}
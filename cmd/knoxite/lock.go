@@ -0,0 +1,86 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"context"
+	"strconv"
+
+	shutdown "github.com/klauspost/shutdown2"
+	"github.com/spf13/cobra"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+)
+
+var (
+	unlockAll bool
+
+	unlockCmd = &cobra.Command{
+		Use:   "unlock",
+		Short: "remove stale locks of a repository",
+		Long: `The unlock command removes the locks of clients, which crashed while accessing
+a repository. Use --all to remove the locks of running clients as well`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return executeUnlock(unlockAll)
+		},
+	}
+)
+
+func init() {
+	unlockCmd.Flags().BoolVar(&unlockAll, "all", false, "remove all locks, even those of running clients")
+	RootCmd.AddCommand(unlockCmd)
+}
+
+// lockRepository locks the repository for the duration of a command, unless
+// --no-lock has been passed. The returned function releases the lock. The
+// lock gets refreshed while the command runs. If another client removes it,
+// the command gets aborted and can't save the repository anymore.
+func lockRepository(r *knoxite.Repository, exclusive bool) (func(), error) {
+	if globalOpts.NoLock {
+		return func() {}, nil
+	}
+
+	l, err := r.Lock(exclusive)
+	if err != nil {
+		if _, ok := err.(*knoxite.LockedError); ok {
+			log.Warn(i18n.Sprintf("If the client holding the lock crashed, remove it with 'knoxite unlock --all'"))
+		}
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go r.KeepLock(ctx, l, 0, func(err error) {
+		log.Errorf("%v, aborting", err)
+		go shutdown.Shutdown()
+	})
+	return func() {
+		cancel()
+		if err := r.Unlock(l); err != nil {
+			log.Warnf("Releasing the repository lock failed: %v", err)
+		}
+	}, nil
+}
+
+func executeUnlock(all bool) error {
+	r, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+
+	removed, err := r.RemoveLocks(all)
+	for _, l := range removed {
+		log.Infof("Removed lock %s of %s@%s (PID %s)", l.ID, l.Username, l.Hostname, strconv.Itoa(l.PID))
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Removed %d locks", len(removed))
+	return nil
+}
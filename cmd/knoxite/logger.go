@@ -12,13 +12,21 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
 )
 
 type Logger struct {
 	LogLevel knoxite.LogLevel
 	w        io.Writer
+
+	// FileLevel is the log level of messages written to the log file
+	FileLevel knoxite.LogLevel
+	file      io.Writer
 }
 
 func NewLogger(l knoxite.LogLevel) *Logger {
@@ -33,6 +41,14 @@ func (l *Logger) WithWriter(w io.Writer) *Logger {
 	return l
 }
 
+// WithFile additionally writes all messages up to level to w, one per line in
+// the logfmt format, e.g. for log collectors.
+func (l *Logger) WithFile(w io.Writer, level knoxite.LogLevel) *Logger {
+	l.file = w
+	l.FileLevel = level
+	return l
+}
+
 func (l Logger) Fatal(v ...interface{}) {
 	l.log(knoxite.LogLevelFatal, v...)
 	os.Exit(1)
@@ -43,6 +59,14 @@ func (l Logger) Fatalf(format string, v ...interface{}) {
 	os.Exit(1)
 }
 
+func (l Logger) Error(v ...interface{}) {
+	l.log(knoxite.LogLevelError, v...)
+}
+
+func (l Logger) Errorf(format string, v ...interface{}) {
+	l.logf(knoxite.LogLevelError, format, v...)
+}
+
 func (l Logger) Warn(v ...interface{}) {
 	l.log(knoxite.LogLevelWarning, v...)
 }
@@ -75,22 +99,36 @@ func (l Logger) Debugf(format string, v ...interface{}) {
 	l.logf(knoxite.LogLevelDebug, format, v...)
 }
 
+// enabled returns true if messages of logLevel get written anywhere.
+func (l Logger) enabled(logLevel knoxite.LogLevel) bool {
+	return logLevel <= l.LogLevel || (l.file != nil && logLevel <= l.FileLevel)
+}
+
 func (l Logger) log(logLevel knoxite.LogLevel, v ...interface{}) {
-	if logLevel <= l.LogLevel {
+	if l.enabled(logLevel) {
 		l.printV(logLevel, v...)
 	}
 }
 
 func (l Logger) logf(logLevel knoxite.LogLevel, format string, v ...interface{}) {
-	if logLevel <= l.LogLevel {
-		l.printV(logLevel, fmt.Sprintf(format, v...))
+	if l.enabled(logLevel) {
+		l.printV(logLevel, i18n.Sprintf(format, v...))
 	}
 }
 
 func (l Logger) printV(logLevel knoxite.LogLevel, v ...interface{}) {
+	msg := fmt.Sprint(v...)
+	if l.file != nil && logLevel <= l.FileLevel {
+		_, _ = fmt.Fprintf(l.file, "time=%s level=%s msg=%s\n",
+			time.Now().Format(time.RFC3339), strings.ToLower(logLevel.String()), strconv.Quote(msg))
+	}
+	if logLevel > l.LogLevel {
+		return
+	}
+
 	if logLevel != knoxite.LogLevelPrint {
-		_, _ = l.w.Write([]byte(logLevel.String() + ": "))
+		_, _ = l.w.Write([]byte(i18n.Sprintf(logLevel.String()) + ": "))
 	}
-	_, _ = l.w.Write([]byte(fmt.Sprint(v...)))
+	_, _ = l.w.Write([]byte(msg))
 	_, _ = l.w.Write([]byte("\n"))
 }
@@ -8,7 +8,6 @@
 package main
 
 import (
-	"fmt"
 	"os/user"
 	"strconv"
 	"time"
@@ -17,6 +16,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
 )
 
 const timeFormat = "2006-01-02 15:04:05"
@@ -28,7 +28,7 @@ var (
 		Long:  `The ls command lists all files stored in a snapshot`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) != 1 {
-				return fmt.Errorf("ls needs a snapshot ID")
+				return i18n.Errorf("ls needs a snapshot ID")
 			}
 			return executeLs(args[0])
 		},
@@ -50,6 +50,10 @@ func executeLs(snapshotID string) error {
 		if err != nil {
 			return err
 		}
+		snapshot, err = snapshot.Merged(&repository)
+		if err != nil {
+			return err
+		}
 
 		for _, archive := range snapshot.Archives {
 			username := strconv.FormatInt(int64(archive.UID), 10)
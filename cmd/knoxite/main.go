@@ -10,6 +10,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"syscall"
 
@@ -19,15 +20,23 @@ import (
 
 	"github.com/knoxite/knoxite"
 	"github.com/knoxite/knoxite/cmd/knoxite/config"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
 	"github.com/knoxite/knoxite/cmd/knoxite/utils"
+	_ "github.com/knoxite/knoxite/source/mail"
+	_ "github.com/knoxite/knoxite/source/remote"
+	_ "github.com/knoxite/knoxite/source/s3"
 	_ "github.com/knoxite/knoxite/storage/amazons3"
 	_ "github.com/knoxite/knoxite/storage/azure"
 	_ "github.com/knoxite/knoxite/storage/backblaze"
 	_ "github.com/knoxite/knoxite/storage/dropbox"
 	_ "github.com/knoxite/knoxite/storage/ftp"
 	_ "github.com/knoxite/knoxite/storage/googlecloud"
+	_ "github.com/knoxite/knoxite/storage/googledrive"
 	_ "github.com/knoxite/knoxite/storage/http"
 	_ "github.com/knoxite/knoxite/storage/mega"
+	_ "github.com/knoxite/knoxite/storage/onedrive"
+	_ "github.com/knoxite/knoxite/storage/rclone"
+	_ "github.com/knoxite/knoxite/storage/rest"
 	_ "github.com/knoxite/knoxite/storage/s3"
 	_ "github.com/knoxite/knoxite/storage/sftp"
 	_ "github.com/knoxite/knoxite/storage/webdav"
@@ -40,7 +49,26 @@ type GlobalOptions struct {
 	Password  string
 	ConfigURL string
 	Verbose   int
+	Quiet     bool
 	LogLevel  string
+	LogFile   string
+	Retries   int
+	JSON      bool
+	ReadOnly  bool
+
+	// PasswordFile and PasswordCommand provide the password for
+	// non-interactive use, unless it has been passed directly
+	PasswordFile    string
+	PasswordCommand string
+	// PrivateKey is the file holding the private key of a repository using
+	// asymmetric encryption
+	PrivateKey string
+	// VolumePassword unlocks the volumes protected by their own key
+	VolumePassword string
+	// Keyring reads the password from and saves it in the OS keyring
+	Keyring bool
+	// NoLock accesses the repository without locking it
+	NoLock bool
 }
 
 var (
@@ -61,7 +89,7 @@ var (
 		DisableAutoGenTag: true,
 	}
 
-	log knoxite.Logger
+	log = *NewLogger(knoxite.LogLevelPrint)
 )
 
 func main() {
@@ -73,19 +101,35 @@ func main() {
 	RootCmd.PersistentFlags().StringVarP(&globalOpts.Repo, "repo", "r", "", "Repository directory to backup to/restore from (default: current working dir)")
 	RootCmd.PersistentFlags().StringVarP(&globalOpts.Alias, "alias", "R", "", "Repository alias to backup to/restore from")
 	RootCmd.PersistentFlags().StringVar(&globalOpts.Password, "password", "", "Password to use for data encryption")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.PasswordFile, "password-file", "", "Read the password from the first line of this file")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.PasswordCommand, "password-command", "", "Read the password from the output of this command, e.g. \"pass show knoxite\"")
+	RootCmd.PersistentFlags().BoolVar(&globalOpts.Keyring, "keyring", false, "Read the password from and save it in the OS keyring")
 	RootCmd.PersistentFlags().StringVarP(&globalOpts.ConfigURL, "configURL", "C", config.DefaultPath(), "Path to the configuration file")
-	RootCmd.PersistentFlags().StringVar(&globalOpts.LogLevel, "loglevel", "Print", "Verbose output. Possible levels are Debug, Info, Warning and Fatal")
-	RootCmd.PersistentFlags().CountVarP(&globalOpts.Verbose, "verbose", "v", "Verbose output on log level Info (-v) or Debug (-vv). Use --loglevel to choose between Debug, Info, Warning and Fatal")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.LogLevel, "loglevel", "Print", "Verbose output. Possible levels are Debug, Info, Warning, Error and Fatal")
+	RootCmd.PersistentFlags().CountVarP(&globalOpts.Verbose, "verbose", "v", "Verbose output on log level Info (-v) or Debug (-vv). Use --loglevel to choose between Debug, Info, Warning, Error and Fatal")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.LogFile, "log-file", "", "Append all log messages to this file, including those hidden by --quiet")
+	RootCmd.PersistentFlags().BoolVarP(&globalOpts.Quiet, "quiet", "q", false, "Only print errors")
+	RootCmd.PersistentFlags().IntVar(&globalOpts.Retries, "retries", knoxite.DefaultRetryPolicy.Retries, "How often failed storage operations get retried")
+	RootCmd.PersistentFlags().BoolVar(&globalOpts.ReadOnly, "force-read-only", false, "Open the repository read-only, even if it has been written by a newer version of knoxite")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.PrivateKey, "private-key", "", "File holding the private key of a repository using asymmetric encryption")
+	RootCmd.PersistentFlags().StringVar(&globalOpts.VolumePassword, "volume-password", "", "Password to unlock volumes protected by their own key")
+	RootCmd.PersistentFlags().BoolVar(&globalOpts.NoLock, "no-lock", false, "Don't lock the repository, e.g. on read-only storage")
+	RootCmd.PersistentFlags().BoolVar(&globalOpts.JSON, "json", false, "Print results and progress as JSON, log messages are written to stderr")
 
 	globalOpts.Repo = os.Getenv("KNOXITE_REPOSITORY")
 	globalOpts.Password = os.Getenv("KNOXITE_PASSWORD")
+	globalOpts.PasswordFile = os.Getenv("KNOXITE_PASSWORD_FILE")
+	globalOpts.PasswordCommand = os.Getenv("KNOXITE_PASSWORD_COMMAND")
+	globalOpts.PrivateKey = os.Getenv("KNOXITE_PRIVATE_KEY")
+	globalOpts.VolumePassword = os.Getenv("KNOXITE_VOLUME_PASSWORD")
 
 	// add the `completion` command via carapace
 	carapace.Gen(RootCmd)
 
+	RootCmd.SetArgs(expandAliasArg(RootCmd, os.Args[1:]))
 	if err := RootCmd.Execute(); err != nil {
-		log.Fatal(err)
-		os.Exit(-1)
+		log.Error(err)
+		os.Exit(1)
 	}
 }
 
@@ -105,6 +149,10 @@ func init() {
 
 func initLogger() {
 	switch {
+	case globalOpts.Quiet && globalOpts.Verbose > 0:
+		// the logger isn't set up yet, so we can't use it to report this
+		fmt.Fprintln(os.Stderr, i18n.Sprintf("Specify either quiet '-q' or verbose '-v' output"))
+		os.Exit(1)
 	case globalOpts.Verbose == 1:
 		globalOpts.LogLevel = "Info"
 	case globalOpts.Verbose >= 2:
@@ -112,9 +160,24 @@ func initLogger() {
 	}
 
 	logLevel, err := utils.LogLevelFromString(globalOpts.LogLevel)
+	consoleLevel := logLevel
+	if globalOpts.Quiet {
+		consoleLevel = knoxite.LogLevelError
+	}
 
-	log = *NewLogger(logLevel).
+	log = *NewLogger(consoleLevel).
 		WithWriter(os.Stdout)
+	if globalOpts.JSON {
+		// keep stdout parseable
+		log.WithWriter(os.Stderr)
+	}
+	if globalOpts.LogFile != "" {
+		f, ferr := os.OpenFile(globalOpts.LogFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if ferr != nil {
+			log.Fatalf("Error opening log file: %v", ferr)
+		}
+		log.WithFile(f, logLevel)
+	}
 
 	if err != nil {
 		log.Warnf("Error setting log level \"%s\": %s. Using default log level Info instead.", globalOpts.LogLevel, err)
@@ -154,12 +217,31 @@ func initConfig() {
 	}
 
 	if globalOpts.Alias != "" {
-		rep, ok := cfg.Repositories[globalOpts.Alias]
-		if !ok {
+		if !applyProfile(globalOpts.Alias) {
 			log.Fatalf("Error loading the specified alias")
 			return
 		}
+	}
+}
+
+// applyProfile sets the global options configured for alias, unless they
+// have been set on the command line already. It returns false if there is
+// no such alias.
+func applyProfile(alias string) bool {
+	rep, ok := cfg.Repositories[alias]
+	if !ok {
+		return false
+	}
 
-		globalOpts.Repo = rep.Url
+	globalOpts.Alias = alias
+	globalOpts.Repo = rep.Url
+	if globalOpts.PasswordFile == "" && globalOpts.PasswordCommand == "" {
+		globalOpts.PasswordFile = rep.PasswordFile
+		globalOpts.PasswordCommand = rep.PasswordCommand
+	}
+	if globalOpts.PrivateKey == "" {
+		globalOpts.PrivateKey = rep.PrivateKey
 	}
+	globalOpts.Keyring = globalOpts.Keyring || rep.Keyring
+	return true
 }
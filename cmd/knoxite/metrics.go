@@ -0,0 +1,91 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/metrics"
+)
+
+// serveMetrics exposes the metrics collected by a long-running command to
+// Prometheus, in the background.
+func serveMetrics(addr string, reg *metrics.Registry) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", reg)
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			log.Warnf("Serving metrics failed: %v", err)
+		}
+	}()
+	log.Printf("Serving metrics on http://%s/metrics", l.Addr())
+	return nil
+}
+
+// writeBackupMetrics updates the metrics of previous backups stored at path
+// with the outcome of storing a snapshot.
+func writeBackupMetrics(path, volume string, snapshot *knoxite.Snapshot, newChunks int, start time.Time, err error) {
+	reg, lerr := metrics.LoadFile(path)
+	if lerr != nil {
+		if !os.IsNotExist(lerr) {
+			log.Warnf("Ignoring unreadable metrics file: %v", lerr)
+		}
+		reg = metrics.NewRegistry()
+	}
+
+	recordBackup(reg, volume, snapshot, newChunks, start, err)
+	if err := reg.WriteFile(path); err != nil {
+		log.Warnf("Writing metrics failed: %v", err)
+	}
+}
+
+// recordBackup adds the outcome of storing a snapshot to reg. newChunks is
+// the amount of chunks, which weren't stored in the repository before.
+func recordBackup(reg *metrics.Registry, volume string, snapshot *knoxite.Snapshot, newChunks int, start time.Time, err error) {
+	labels := []string{"volume", volume}
+	now := time.Now()
+
+	success := 0.0
+	if err == nil {
+		success = 1
+		reg.Set("knoxite_backup_last_success_timestamp_seconds", "Time of the last successful backup.",
+			float64(now.Unix()), labels...)
+	}
+	reg.Set("knoxite_backup_success", "Whether the last backup succeeded.", success, labels...)
+	reg.Set("knoxite_backup_last_run_timestamp_seconds", "Time the last backup finished.", float64(now.Unix()), labels...)
+	reg.Set("knoxite_backup_duration_seconds", "Duration of the last backup.", now.Sub(start).Seconds(), labels...)
+	if snapshot == nil {
+		return
+	}
+
+	var chunks int
+	for _, arc := range snapshot.Archives {
+		chunks += len(arc.Chunks)
+	}
+	dedup := 0.0
+	if chunks > 0 {
+		dedup = 1 - float64(newChunks)/float64(chunks)
+	}
+
+	stats := snapshot.Stats
+	reg.Set("knoxite_backup_files", "Files in the last backup.", float64(stats.Files), labels...)
+	reg.Set("knoxite_backup_size_bytes", "Original size of the data in the last backup.", float64(stats.Size), labels...)
+	reg.Set("knoxite_backup_transferred_bytes", "Bytes written to the storage backends by the last backup.", float64(stats.StorageSize), labels...)
+	reg.Set("knoxite_backup_chunks_stored", "New chunks stored by the last backup.", float64(newChunks), labels...)
+	reg.Set("knoxite_backup_dedup_ratio", "Share of the last backup's chunks, which were stored already.", dedup, labels...)
+	reg.Set("knoxite_backup_errors", "Files, which couldn't be stored by the last backup.", float64(stats.Errors), labels...)
+}
@@ -0,0 +1,194 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"context"
+
+	shutdown "github.com/klauspost/shutdown2"
+	"github.com/spf13/cobra"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+	"github.com/knoxite/knoxite/cmd/knoxite/utils"
+)
+
+// MigrateOptions holds all the options of the repo migrate command.
+type MigrateOptions struct {
+	RotateKey  bool
+	Encryption string
+}
+
+var (
+	repoMigrateOpts = MigrateOptions{}
+
+	repoMigrateCmd = &cobra.Command{
+		Use:   "migrate",
+		Short: "upgrade the repository format and re-encrypt its data",
+		Long: `The migrate command upgrades the repository to the current format version and
+re-encrypts the metadata of all snapshots accordingly. Older versions of
+knoxite can't read the repository afterwards.
+
+With --rotate-key, all data gets re-encrypted with a new random key, like
+'repo rotate-key' does. With --encryption, all encrypted chunks get
+re-encrypted with another algo, e.g. to move data stored with AES-CFB to
+authenticated encryption.
+
+Chunks get re-encrypted one by one and verified after storing them. Snapshots
+are migrated one by one, run the command again to resume an interrupted
+migration. Once all snapshots have been migrated, the repository gets packed.
+Snapshots of locked protected volumes get skipped`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return executeRepoMigrate(repoMigrateOpts)
+		},
+	}
+)
+
+func init() {
+	repoMigrateCmd.Flags().BoolVar(&repoMigrateOpts.RotateKey, "rotate-key", false, "re-encrypt all data with a new key")
+	repoMigrateCmd.Flags().StringVar(&repoMigrateOpts.Encryption, "encryption", "", "re-encrypt all encrypted data with this algo: aes or aes-gcm")
+	repoCmd.AddCommand(repoMigrateCmd)
+}
+
+func executeRepoMigrate(opts MigrateOptions) error {
+	var encryption uint16
+	if opts.Encryption != "" {
+		var err error
+		encryption, err = utils.EncryptionTypeFromString(opts.Encryption)
+		if err != nil {
+			return err
+		}
+		if encryption != knoxite.EncryptionAES && encryption != knoxite.EncryptionAESGCM {
+			return i18n.Errorf("Data can only be re-encrypted with aes or aes-gcm")
+		}
+	}
+
+	r, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+	if !r.IsAdmin() {
+		return knoxite.ErrAppendOnly
+	}
+	if r.Asymmetric() && (opts.RotateKey || encryption != knoxite.EncryptionNone) {
+		return i18n.Errorf("Repositories using asymmetric encryption always seal their data with their public key")
+	}
+	unlock, err := lockRepository(&r, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if v := r.Version; v < knoxite.RepositoryVersion {
+		if err := r.Upgrade(); err != nil {
+			return err
+		}
+		log.Printf("Upgraded repository from version %d to %d", v, r.Version)
+	}
+	if opts.RotateKey && len(r.RetiredKeys) == 0 {
+		if err := r.RotateKey(); err != nil {
+			return err
+		}
+		log.Printf("Rotated key, new key fingerprint: %s", r.KeyFingerprint())
+	}
+
+	index, err := knoxite.OpenChunkIndex(&r)
+	if err != nil {
+		return err
+	}
+	re := knoxite.NewReencryption(&r, &index)
+	re.Encrypt = encryption
+	re.Metadata = true
+	res, err := rewriteSnapshots(&r, &index, re, "migrate")
+	if err != nil || res.aborted {
+		return err
+	}
+	if len(r.RetiredKeys) > 0 {
+		if err := r.ForgetRetiredKeys(); err != nil {
+			return err
+		}
+		log.Print(i18n.Sprintf("The old key has been removed from the repository"))
+	}
+
+	log.Printf("Migrated %d snapshots, re-encrypted %d chunks, freed %s", res.snapshots, res.Chunks, knoxite.SizeToString(res.freed))
+	return nil
+}
+
+// rewriteResult describes the snapshots rewritten by rewriteSnapshots.
+type rewriteResult struct {
+	knoxite.ReencryptStats
+	snapshots int
+	freed     uint64
+	aborted   bool
+}
+
+// rewriteSnapshots re-encrypts all snapshots re considers pending, one by
+// one, and packs the repository afterwards if any chunks got replaced. The
+// chunk-index gets saved after each snapshot, so running cmd again resumes an
+// interrupted run. Snapshots of locked protected volumes get skipped.
+func rewriteSnapshots(r *knoxite.Repository, index *knoxite.ChunkIndex, re *knoxite.Reencryption, cmd string) (rewriteResult, error) {
+	var res rewriteResult
+	ctx, cancel := shutdown.CancelCtx(context.Background())
+	defer cancel()
+
+	// snapshots rewritten already provide their chunks to the others
+	var pending []*knoxite.Snapshot
+	for _, vol := range r.Volumes {
+		if vol.Protected() && !vol.Unlocked() {
+			log.Warnf("Skipping volume %s, which is protected by its own key", vol.ID)
+			continue
+		}
+		for _, id := range vol.Snapshots {
+			snapshot, err := vol.LoadSnapshot(id, r)
+			if err != nil {
+				return res, err
+			}
+			if re.Pending(snapshot) {
+				pending = append(pending, snapshot)
+			}
+		}
+	}
+
+	for i, snapshot := range pending {
+		stats, err := re.Snapshot(ctx, snapshot)
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Printf("Aborting, run %s again to resume", cmd)
+				res.aborted = true
+				return res, nil
+			}
+			return res, i18n.Errorf("Rewriting snapshot %s failed: %v", snapshot.ID, err)
+		}
+		// saving the index makes the run resumable
+		if err := index.Save(r); err != nil {
+			return res, err
+		}
+
+		log.Printf("Rewrote snapshot %s (%d/%d): %d chunks, %s stored", snapshot.ID, i+1, len(pending), stats.Chunks, knoxite.SizeToString(stats.StorageSize))
+		res.snapshots++
+		res.Chunks += stats.Chunks
+		res.StorageSize += stats.StorageSize
+	}
+	// the chunk-index gets saved in the current format, too
+	if err := index.Save(r); err != nil {
+		return res, err
+	}
+
+	if res.Chunks > 0 || len(r.RetiredKeys) > 0 {
+		// the replaced chunks are no longer referenced
+		freed, err := index.Pack(ctx, r)
+		if err != nil {
+			return res, err
+		}
+		res.freed = freed
+		if err := index.Save(r); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
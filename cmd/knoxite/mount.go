@@ -11,7 +11,6 @@
 package main
 
 import (
-	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -24,6 +23,7 @@ import (
 	"golang.org/x/net/context"
 
 	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
 )
 
 var (
@@ -33,10 +33,10 @@ var (
 		Long:  `The mount command mounts a repository read-only to a given directory`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) < 1 {
-				return fmt.Errorf("mount needs to know which snapshot to work on")
+				return i18n.Errorf("mount needs to know which snapshot to work on")
 			}
 			if len(args) < 2 {
-				return fmt.Errorf("mount needs to know where to mount the snapshot to")
+				return i18n.Errorf("mount needs to know where to mount the snapshot to")
 			}
 			return executeMount(args[0], args[1])
 		},
@@ -56,9 +56,13 @@ func executeMount(snapshotID, mountpoint string) error {
 	if err != nil {
 		return err
 	}
+	snapshot, err = snapshot.Merged(&repository)
+	if err != nil {
+		return err
+	}
 
 	if _, err := os.Stat(mountpoint); os.IsNotExist(err) {
-		fmt.Printf("Mountpoint %s doesn't exist, creating it\n", mountpoint)
+		log.Infof("Mountpoint %s doesn't exist, creating it", mountpoint)
 		err = os.Mkdir(mountpoint, os.ModeDir|0700)
 		if err != nil {
 			return err
@@ -75,9 +79,9 @@ func executeMount(snapshotID, mountpoint string) error {
 
 	roottree := fs.Tree{}
 
-	fmt.Println("Updating index")
+	log.Info(i18n.Sprintf("Updating index"))
 	updateIndex(&repository, snapshot)
-	fmt.Println("Updating index done")
+	log.Info(i18n.Sprintf("Updating index done"))
 	for _, arc := range root.Items {
 		roottree.Add(arc.Archive.Path, arc)
 	}
@@ -103,7 +107,7 @@ func executeMount(snapshotID, mountpoint string) error {
 	case <-done:
 		err := fuse.Unmount(mountpoint)
 		if err != nil {
-			fmt.Printf("Error umounting: %s\n", err)
+			log.Warnf("Error umounting: %s", err)
 		}
 		return c.Close()
 	}
@@ -133,7 +137,7 @@ func node(name string, arc knoxite.Archive, repository *knoxite.Repository) *Nod
 		v, ok := item.Items[s]
 		if !ok {
 			path := filepath.Join(l[:k+1]...)
-			fmt.Println("Adding to tree:", path)
+			log.Debugf("Adding to tree: %s", path)
 			if name != path {
 				// We stored an absolute path and need to fake the parent
 				// dirs for the first item in the archive
@@ -169,7 +173,7 @@ func updateIndex(repository *knoxite.Repository, snapshot *knoxite.Snapshot) {
 			// Strip the leading slash for mounting
 			path = path[1:]
 		}
-		fmt.Println("Adding to index:", path)
+		log.Debugf("Adding to index: %s", path)
 		node(path, *arc, repository)
 	}
 }
@@ -234,8 +238,8 @@ func (node *Node) Open(_ context.Context, req *fuse.OpenRequest, resp *fuse.Open
 }
 
 // Read reads from a file.
-func (node *Node) Read(_ context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
-	d, err := knoxite.ReadArchive(*node.Repository, node.Archive, int(req.Offset), req.Size)
+func (node *Node) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	d, err := knoxite.ReadArchive(ctx, *node.Repository, node.Archive, int(req.Offset), req.Size)
 	if err != nil {
 		if err != io.EOF {
 			return err
@@ -0,0 +1,94 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+	"github.com/knoxite/knoxite/cmd/knoxite/utils"
+)
+
+// readPassword returns the repository's password. Unless it has been passed
+// with --password or KNOXITE_PASSWORD, it gets read from the password file or
+// the output of the password command. If neither has been configured, it gets
+// read from the OS keyring with --keyring, otherwise the user gets prompted
+// for it.
+func readPassword(prompt string) (string, error) {
+	if globalOpts.Password != "" {
+		return globalOpts.Password, nil
+	}
+
+	var password string
+	var err error
+	switch {
+	case globalOpts.PasswordFile != "":
+		password, err = passwordFromFile(globalOpts.PasswordFile)
+	case globalOpts.PasswordCommand != "":
+		password, err = passwordFromCommand(globalOpts.PasswordCommand)
+	default:
+		if globalOpts.Keyring {
+			if password, ok := passwordFromKeyring(globalOpts.Repo); ok {
+				keyringPassword = true
+				return password, nil
+			}
+		}
+		password, err = utils.ReadPassword(prompt)
+		promptedPassword = err == nil
+		return password, err
+	}
+	if err != nil {
+		return "", err
+	}
+	if password == "" {
+		return "", i18n.Errorf("The password read is empty")
+	}
+
+	// don't run the command or read the file again
+	globalOpts.Password = password
+	return password, nil
+}
+
+// passwordFromFile reads the password from the first line of a file.
+func passwordFromFile(path string) (string, error) {
+	if fi, err := os.Stat(path); err == nil && runtime.GOOS != "windows" && fi.Mode().Perm()&0077 != 0 {
+		log.Warnf("Password file %s is accessible by other users, consider restricting its permissions to 0600", path)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", i18n.Errorf("Reading password file failed: %v", err)
+	}
+	return firstLine(b), nil
+}
+
+// passwordFromCommand runs a command, e.g. querying a password manager, and
+// reads the password from the first line of its output. The command can still
+// interact with the user through stdin and stderr.
+func passwordFromCommand(command string) (string, error) {
+	cmd := shellCommand(command)
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = os.Stderr
+
+	b, err := cmd.Output()
+	if err != nil {
+		return "", i18n.Errorf("Running password command failed: %v", err)
+	}
+	return firstLine(b), nil
+}
+
+func firstLine(b []byte) string {
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSuffix(string(b), "\r")
+}
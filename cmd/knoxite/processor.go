@@ -0,0 +1,89 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+)
+
+// commandProcessor inspects files with a command run in the system's shell,
+// e.g. clamdscan. The command reads the file's content from stdin. It exits
+// with 0 if the file is fine, or 1 if it flagged the file, printing its
+// finding. Any other exit code means the file couldn't be inspected.
+type commandProcessor struct {
+	name    string
+	command string
+}
+
+// parseProcessors parses specs like "clamav=clamdscan --no-summary -".
+func parseProcessors(specs []string) ([]knoxite.Processor, error) {
+	var processors []knoxite.Processor
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return nil, i18n.Errorf("Invalid processor %s, expected name=command", spec)
+		}
+		processors = append(processors, &commandProcessor{
+			name:    strings.TrimSpace(kv[0]),
+			command: kv[1],
+		})
+	}
+	return processors, nil
+}
+
+func (p *commandProcessor) Name() string {
+	return p.name
+}
+
+func (p *commandProcessor) Process(ctx context.Context, archive *knoxite.Archive, r io.Reader) (string, error) {
+	cmd := shellCommand(p.command)
+	cmd.Env = append(safeEnv(),
+		"KNOXITE_PROCESSOR="+p.name,
+		"KNOXITE_FILE="+archive.Path,
+	)
+	cmd.Stdin = r
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = cmd.Process.Kill()
+		case <-done:
+		}
+	}()
+
+	err := cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		finding := strings.TrimSpace(firstLine(stdout.Bytes()))
+		if finding == "" {
+			finding = "flagged"
+		}
+		return finding, nil
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(firstLine(stderr.Bytes())); msg != "" {
+			return "", i18n.Errorf("%v: %s", err, msg)
+		}
+		return "", err
+	}
+	return "", nil
+}
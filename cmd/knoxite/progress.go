@@ -0,0 +1,356 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	"github.com/muesli/goprogressbar"
+	"github.com/muesli/gotable"
+	"golang.org/x/term"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+)
+
+const (
+	// maximum amount of items displayed at once
+	maxActiveLines = 8
+	// minimum time between two redraws of the active items
+	redrawInterval = time.Second / 25
+	// minimum time between two progress events in JSON mode
+	eventInterval = time.Second
+)
+
+// itemEvent is emitted in JSON mode whenever an item has been processed.
+type itemEvent struct {
+	Event string `json:"event"`
+	Path  string `json:"path"`
+	Size  uint64 `json:"size"`
+	Error string `json:"error,omitempty"`
+}
+
+// progressEvent is emitted in JSON mode to report the overall progress.
+type progressEvent struct {
+	Event       string `json:"event"`
+	Transferred uint64 `json:"transferred"`
+	TotalSize   uint64 `json:"total_size"`
+	Items       uint64 `json:"items"`
+	TotalItems  uint64 `json:"total_items"`
+	Speed       uint64 `json:"speed"`
+}
+
+// summaryEvent is emitted in JSON mode once all items have been processed.
+type summaryEvent struct {
+	Event    string        `json:"event"`
+	Stats    knoxite.Stats `json:"stats"`
+	Duration float64       `json:"duration"`
+	Speed    uint64        `json:"speed"`
+	// Timings maps the stages to the seconds spent in them
+	Timings map[string]float64 `json:"timings,omitempty"`
+}
+
+// progressItem is an item currently being processed.
+type progressItem struct {
+	bar         *goprogressbar.ProgressBar
+	transferred uint64
+}
+
+// progressUI renders the progress of a store or restore operation. On a
+// terminal it keeps one line per active item plus a totals bar at the bottom.
+// In verbose mode a plain line is printed for every finished item, quiet mode
+// suppresses all output. In JSON mode it emits newline-delimited events
+// instead.
+type progressUI struct {
+	out     io.Writer
+	tty     bool
+	quiet   bool
+	verbose bool
+	json    bool
+
+	start     time.Time
+	lastPrint time.Time
+	lines     int
+
+	active   map[string]*progressItem
+	order    []string
+	finished []string
+
+	totalSize   uint64
+	totalItems  uint64
+	transferred uint64
+	items       uint64
+
+	totalBar *goprogressbar.ProgressBar
+
+	// timings get collected by the operation and summarized once it finished
+	timings *knoxite.Timings
+	// status receives the progress for the daemon's status page
+	status *jobProgress
+}
+
+func newProgressUI() *progressUI {
+	ui := &progressUI{
+		out: os.Stdout,
+		// debug messages would garble the redrawn lines
+		tty:     term.IsTerminal(int(os.Stdout.Fd())) && log.LogLevel < knoxite.LogLevelDebug,
+		quiet:   log.LogLevel < knoxite.LogLevelPrint,
+		verbose: log.LogLevel >= knoxite.LogLevelInfo,
+		json:    globalOpts.JSON,
+		start:   time.Now(),
+		active:  make(map[string]*progressItem),
+		timings: &knoxite.Timings{},
+		status:  currentProgress,
+	}
+	ui.totalBar = &goprogressbar.ProgressBar{
+		Text:  i18n.Sprintf("Total"),
+		Width: 40,
+		PrependTextFunc: func(p *goprogressbar.ProgressBar) string {
+			return i18n.Sprintf("%s / %s (%s of %s)  %s/s",
+				knoxite.SizeToString(ui.transferred),
+				knoxite.SizeToString(ui.totalSize),
+				humanize.Comma(int64(ui.items)),
+				humanize.Comma(int64(ui.totalItems)),
+				knoxite.SizeToString(ui.speed()))
+		},
+	}
+	goprogressbar.Stdout = ui.out
+
+	return ui
+}
+
+// SetTotal sets the overall size and amount of items to be processed.
+func (ui *progressUI) SetTotal(size, items uint64) {
+	ui.totalSize = size
+	ui.totalItems = items
+}
+
+// Update processes a progress report for a single item.
+func (ui *progressUI) Update(p knoxite.Progress) {
+	item, ok := ui.active[p.Path]
+	if !ok {
+		item = &progressItem{
+			bar: &goprogressbar.ProgressBar{Text: p.Path, Width: 40},
+		}
+		ui.active[p.Path] = item
+		ui.order = append(ui.order, p.Path)
+	}
+
+	if p.Error != nil {
+		ui.finish(p.Path, p.Error)
+		ui.redraw(true)
+		return
+	}
+
+	ui.transferred += p.CurrentItemStats.Transferred - item.transferred
+	item.transferred = p.CurrentItemStats.Transferred
+
+	item.bar.Total = int64(p.CurrentItemStats.Size)
+	item.bar.Current = int64(p.CurrentItemStats.Transferred)
+	item.bar.PrependText = fmt.Sprintf("%s  %s/s",
+		knoxite.SizeToString(uint64(item.bar.Current)),
+		knoxite.SizeToString(p.TransferSpeed()))
+
+	if p.CurrentItemStats.Transferred >= p.CurrentItemStats.Size {
+		ui.finish(p.Path, nil)
+		ui.redraw(true)
+		return
+	}
+
+	ui.redraw(!ok)
+}
+
+// Close removes the active items from the screen.
+func (ui *progressUI) Close() {
+	for _, path := range append([]string{}, ui.order...) {
+		ui.finish(path, nil)
+	}
+	ui.redraw(true)
+}
+
+// Finish removes the active items from the screen and prints a summary.
+func (ui *progressUI) Finish(stats knoxite.Stats) {
+	ui.Close()
+	elapsed := time.Since(ui.start)
+	if ui.json {
+		timings := make(map[string]float64)
+		for _, stage := range ui.stages() {
+			timings[stage.String()] = ui.timings.Get(stage).Seconds()
+		}
+		printEvent(summaryEvent{"summary", stats, elapsed.Seconds(), ui.speed(), timings})
+		return
+	}
+	if ui.quiet {
+		return
+	}
+
+	tab := gotable.NewTableWithWriter([]string{i18n.Sprintf("Files"), i18n.Sprintf("Dirs"), i18n.Sprintf("SymLinks"),
+		i18n.Sprintf("Errors"), i18n.Sprintf("Original Size"), i18n.Sprintf("Storage Size"), i18n.Sprintf("Duration"), i18n.Sprintf("Speed")},
+		[]int64{8, 8, 8, 8, 13, 12, 10, 11}, "", ui.out)
+	tab.AppendRow([]interface{}{
+		humanize.Comma(int64(stats.Files)),
+		humanize.Comma(int64(stats.Dirs)),
+		humanize.Comma(int64(stats.SymLinks)),
+		humanize.Comma(int64(stats.Errors)),
+		knoxite.SizeToString(stats.Size),
+		knoxite.SizeToString(stats.StorageSize),
+		elapsed.Round(time.Second).String(),
+		knoxite.SizeToString(ui.speed()) + "/s"})
+
+	fmt.Fprintln(ui.out)
+	_ = tab.Print()
+	ui.printTimings()
+}
+
+// stages returns the stages the operation spent any time in.
+func (ui *progressUI) stages() []knoxite.Stage {
+	var stages []knoxite.Stage
+	for _, stage := range knoxite.Stages {
+		if ui.timings.Get(stage) > 0 {
+			stages = append(stages, stage)
+		}
+	}
+	return stages
+}
+
+// printTimings prints how much time was spent in each stage, so users can
+// tell whether CPU, I/O or bandwidth is the bottleneck.
+func (ui *progressUI) printTimings() {
+	stages := ui.stages()
+	if len(stages) == 0 {
+		return
+	}
+	var total time.Duration
+	for _, stage := range stages {
+		total += ui.timings.Get(stage)
+	}
+
+	tab := gotable.NewTableWithWriter([]string{i18n.Sprintf("Stage"), i18n.Sprintf("Time"), i18n.Sprintf("Share")},
+		[]int64{-14, 12, 7}, "", ui.out)
+	for _, stage := range stages {
+		d := ui.timings.Get(stage)
+		precision := time.Millisecond
+		if d < time.Second {
+			precision = time.Microsecond
+		}
+		tab.AppendRow([]interface{}{
+			stage.String(),
+			d.Round(precision).String(),
+			fmt.Sprintf("%.1f%%", float64(d)/float64(total)*100)})
+	}
+
+	fmt.Fprintln(ui.out)
+	_ = tab.Print()
+}
+
+// Abort removes the active items from the screen without a summary.
+func (ui *progressUI) Abort() {
+	ui.active = make(map[string]*progressItem)
+	ui.order = nil
+	ui.redraw(true)
+}
+
+// finish moves an item from the active set to the list of finished items.
+func (ui *progressUI) finish(path string, err error) {
+	item, ok := ui.active[path]
+	if !ok {
+		return
+	}
+	delete(ui.active, path)
+	for i, p := range ui.order {
+		if p == path {
+			ui.order = append(ui.order[:i], ui.order[i+1:]...)
+			break
+		}
+	}
+	ui.items++
+	if ui.json {
+		e := itemEvent{Event: "item", Path: path, Size: uint64(item.bar.Total)}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		printEvent(e)
+		return
+	}
+	if !ui.verbose {
+		return
+	}
+
+	line := fmt.Sprintf("%s  %s", path, knoxite.SizeToString(uint64(item.bar.Total)))
+	if err != nil {
+		line = i18n.Sprintf("%s  failed: %v", path, err)
+	}
+	ui.finished = append(ui.finished, line)
+}
+
+func (ui *progressUI) speed() uint64 {
+	return uint64(float64(ui.transferred) / time.Since(ui.start).Seconds())
+}
+
+// redraw prints all finished items and, on a terminal, refreshes the active
+// items and the totals bar. Unless forced, redraws are rate-limited.
+func (ui *progressUI) redraw(force bool) {
+	ui.publish()
+	if ui.json {
+		if now := time.Now(); now.Sub(ui.lastPrint) >= eventInterval {
+			ui.lastPrint = now
+			printEvent(progressEvent{"progress", ui.transferred, ui.totalSize, ui.items, ui.totalItems, ui.speed()})
+		}
+		return
+	}
+	if ui.quiet {
+		return
+	}
+	if !ui.tty {
+		for _, line := range ui.finished {
+			fmt.Fprintln(ui.out, line)
+		}
+		ui.finished = nil
+		return
+	}
+
+	now := time.Now()
+	if !force && now.Sub(ui.lastPrint) < redrawInterval {
+		return
+	}
+	ui.lastPrint = now
+
+	// move back to the beginning of the previously drawn area and clear it
+	if ui.lines > 0 {
+		fmt.Fprintf(ui.out, "\x1b[%dA", ui.lines)
+	}
+	fmt.Fprint(ui.out, "\r\x1b[J")
+
+	for _, line := range ui.finished {
+		fmt.Fprintln(ui.out, line)
+	}
+	ui.finished = nil
+
+	ui.lines = 0
+	for i, path := range ui.order {
+		if i == maxActiveLines-1 && len(ui.order) > maxActiveLines {
+			fmt.Fprintln(ui.out, i18n.Sprintf("... and %d more", len(ui.order)-i))
+			ui.lines++
+			break
+		}
+
+		ui.active[path].bar.Print()
+		fmt.Fprintln(ui.out)
+		ui.lines++
+	}
+
+	ui.totalBar.Total = int64(ui.totalSize)
+	ui.totalBar.Current = int64(ui.transferred)
+	ui.totalBar.Print()
+	fmt.Fprintln(ui.out)
+	ui.lines++
+}
@@ -0,0 +1,150 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"context"
+
+	humanize "github.com/dustin/go-humanize"
+	shutdown "github.com/klauspost/shutdown2"
+	"github.com/spf13/cobra"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+)
+
+// RepoRechunkOptions holds all the options that can be set for the 'repo
+// rechunk' command.
+type RepoRechunkOptions struct {
+	Fixed       bool
+	MinSize     string
+	MaxSize     string
+	Concurrency uint
+}
+
+var (
+	repoRechunkOpts = RepoRechunkOptions{}
+
+	repoRechunkCmd = &cobra.Command{
+		Use:   "rechunk",
+		Short: "divide all stored files into chunks with new chunker settings",
+		Long: `The rechunk command changes the chunker settings of a repository and divides
+the files of all existing snapshots into chunks again, so they get deduplicated
+with the data stored from now on. Snapshots are migrated one by one, run the
+command without any settings to resume an interrupted migration. Run 'repo pack'
+afterwards to free the storage space of the old chunks`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			changed := cmd.Flags().Changed("fixed") || cmd.Flags().Changed("min-size") || cmd.Flags().Changed("max-size")
+			return executeRepoRechunk(repoRechunkOpts, changed)
+		},
+	}
+)
+
+func init() {
+	repoRechunkCmd.Flags().BoolVar(&repoRechunkOpts.Fixed, "fixed", false, "divide files into chunks of --max-size, instead of finding chunk boundaries based on their content")
+	repoRechunkCmd.Flags().StringVar(&repoRechunkOpts.MinSize, "min-size", humanize.IBytes(uint64(knoxite.DefaultChunkerSettings.MinSize)), "minimum size of content-defined chunks")
+	repoRechunkCmd.Flags().StringVar(&repoRechunkOpts.MaxSize, "max-size", humanize.IBytes(uint64(knoxite.DefaultChunkerSettings.MaxSize)), "maximum size of chunks")
+	repoRechunkCmd.Flags().UintVar(&repoRechunkOpts.Concurrency, "concurrency", knoxite.DefaultConcurrency, "amount of chunks being processed in parallel")
+	repoCmd.AddCommand(repoRechunkCmd)
+}
+
+func executeRepoRechunk(opts RepoRechunkOptions, changed bool) error {
+	r, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+	if !r.IsAdmin() {
+		return knoxite.ErrAppendOnly
+	}
+	unlock, err := lockRepository(&r, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	settings := r.ChunkerSettings()
+	if changed {
+		settings = knoxite.ChunkerSettings{Fixed: opts.Fixed}
+		if !opts.Fixed {
+			min, err := humanize.ParseBytes(opts.MinSize)
+			if err != nil {
+				return i18n.Errorf("invalid size %s: %v", opts.MinSize, err)
+			}
+			settings.MinSize = uint(min)
+		}
+		max, err := humanize.ParseBytes(opts.MaxSize)
+		if err != nil {
+			return i18n.Errorf("invalid size %s: %v", opts.MaxSize, err)
+		}
+		settings.MaxSize = uint(max)
+		if err := settings.Validate(); err != nil {
+			return err
+		}
+
+		// new snapshots use the new settings right away
+		r.Chunker = &settings
+		if settings == knoxite.DefaultChunkerSettings {
+			r.Chunker = nil
+		}
+		if err := r.Save(); err != nil {
+			return err
+		}
+	}
+	log.Printf("Chunker settings: %s", settings.String())
+
+	index, err := knoxite.OpenChunkIndex(&r)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := shutdown.CancelCtx(context.Background())
+	defer cancel()
+
+	so := knoxite.StoreOptions{
+		Chunker:     settings,
+		Concurrency: opts.Concurrency,
+	}
+	var rechunked int
+	var total knoxite.Stats
+	for _, vol := range r.Volumes {
+		if vol.Protected() && !vol.Unlocked() {
+			log.Warnf("Skipping volume %s, which is protected by its own key", vol.ID)
+			continue
+		}
+		for _, id := range vol.Snapshots {
+			snapshot, err := vol.LoadSnapshot(id, &r)
+			if err != nil {
+				return err
+			}
+			if snapshot.ChunkerSettings() == settings {
+				log.Infof("Snapshot %s has been rechunked already", id)
+				continue
+			}
+
+			stats, err := knoxite.RechunkSnapshot(ctx, &r, &index, snapshot, so)
+			if err != nil {
+				if ctx.Err() != nil {
+					log.Print(i18n.Sprintf("Aborting, run rechunk again to resume"))
+					return nil
+				}
+				return i18n.Errorf("Rechunking snapshot %s failed: %v", id, err)
+			}
+			// saving the index makes the migration resumable
+			if err := index.Save(&r); err != nil {
+				return err
+			}
+
+			log.Printf("Rechunked snapshot %s: %d files, %s stored", id, stats.Files, knoxite.SizeToString(stats.StorageSize))
+			rechunked++
+			total.Add(stats)
+		}
+	}
+
+	log.Printf("Rechunked %d snapshots, stored %s of new chunks", rechunked, knoxite.SizeToString(total.StorageSize))
+	log.Print(i18n.Sprintf("Do not forget to run 'repo pack' to delete un-referenced chunks and free up storage space!"))
+	return nil
+}
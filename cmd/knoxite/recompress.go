@@ -0,0 +1,79 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+	"github.com/knoxite/knoxite/cmd/knoxite/utils"
+)
+
+var (
+	repoRecompressCompression string
+
+	repoRecompressCmd = &cobra.Command{
+		Use:   "recompress",
+		Short: "recompress all data with another algo",
+		Long: `The recompress command rewrites all compressed chunks with another compression
+algo, e.g. to move data stored with GZip or LZMA to zstd and reclaim storage
+space. Data stored without compression stays uncompressed.
+
+The command needs exclusive access to the repository. Chunks get rewritten one
+by one and verified after storing them. Snapshots are recompressed one by one,
+run the command again to resume an interrupted run. Once all snapshots have
+been recompressed, the repository gets packed`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return executeRepoRecompress(repoRecompressCompression)
+		},
+	}
+)
+
+func init() {
+	repoRecompressCmd.Flags().StringVarP(&repoRecompressCompression, "compression", "c", "zstd", "compression algo to use: flate, gzip, lzma, zlib, zstd")
+	repoCmd.AddCommand(repoRecompressCmd)
+}
+
+func executeRepoRecompress(algo string) error {
+	compression, err := utils.CompressionTypeFromString(algo)
+	if err != nil {
+		return err
+	}
+	if compression == knoxite.CompressionNone {
+		return i18n.Errorf("recompress needs a compression algo to use")
+	}
+
+	r, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+	if !r.IsAdmin() {
+		return knoxite.ErrAppendOnly
+	}
+	unlock, err := lockRepository(&r, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	index, err := knoxite.OpenChunkIndex(&r)
+	if err != nil {
+		return err
+	}
+	re := knoxite.NewReencryption(&r, &index)
+	re.Compress = compression
+	res, err := rewriteSnapshots(&r, &index, re, "recompress")
+	if err != nil || res.aborted {
+		return err
+	}
+
+	log.Printf("Recompressed %d snapshots and %d chunks, stored %s, freed %s", res.snapshots, res.Chunks,
+		knoxite.SizeToString(res.StorageSize), knoxite.SizeToString(res.freed))
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+)
+
+// writeRecoverySheet renders a printable sheet with everything needed to
+// restore data from a repository, except for its password.
+func writeRecoverySheet(w io.Writer, r *knoxite.Repository) error {
+	var locations []string
+	for _, be := range r.BackendManager().Backends {
+		locations = append(locations, knoxite.RedactURL((*be).Location()))
+	}
+	hint := r.PasswordHint()
+	if hint == "" {
+		hint = i18n.Sprintf("(none)")
+	}
+	repo := "<repository>"
+	if len(locations) > 0 {
+		repo = locations[0]
+	}
+
+	title := i18n.Sprintf("KNOXITE RECOVERY SHEET")
+	fmt.Fprintln(w, title)
+	fmt.Fprintln(w, strings.Repeat("=", len(title)))
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, i18n.Sprintf("Keep this sheet in a safe place. Together with the repository's password it\n"+
+		"lets you restore your data, even if the computer it was stored from is lost."))
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "%s\t%s\n", i18n.Sprintf("Created:"), time.Now().Format("2006-01-02"))
+	fmt.Fprintf(tw, "%s\t%s\n", i18n.Sprintf("Repository ID:"), r.ID)
+	fmt.Fprintf(tw, "%s\t%s\n", i18n.Sprintf("Key fingerprint:"), r.KeyFingerprint())
+	for i, l := range locations {
+		label := ""
+		if i == 0 {
+			label = i18n.Sprintf("Storage:")
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", label, l)
+	}
+	fmt.Fprintf(tw, "%s\t%s\n", i18n.Sprintf("Password hint:"), hint)
+	fmt.Fprintf(tw, "%s\t%s\n", i18n.Sprintf("Password:"), "________________________________")
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+
+	fmt.Fprintln(w, i18n.Sprintf("To restore your data:"))
+	fmt.Fprintln(w, i18n.Sprintf("  1. Install knoxite, see https://github.com/knoxite/knoxite"))
+	fmt.Fprintln(w, i18n.Sprintf("  2. Check that this command shows the key fingerprint above:"))
+	fmt.Fprintf(w, "       knoxite -r %s repo recovery-sheet\n", repo)
+	fmt.Fprintln(w, i18n.Sprintf("  3. Find the volume and snapshot you want to restore:"))
+	fmt.Fprintf(w, "       knoxite -r %s volume list\n", repo)
+	fmt.Fprintf(w, "       knoxite -r %s snapshot list [volume ID]\n", repo)
+	fmt.Fprintln(w, i18n.Sprintf("  4. Restore the snapshot:"))
+	fmt.Fprintf(w, "       knoxite -r %s restore [snapshot ID] [target directory]\n", repo)
+
+	return nil
+}
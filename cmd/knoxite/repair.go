@@ -0,0 +1,126 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	shutdown "github.com/klauspost/shutdown2"
+	"github.com/spf13/cobra"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+)
+
+// RepairOptions holds all the options that can be set for the 'repair' command.
+type RepairOptions struct {
+	DryRun bool
+}
+
+var (
+	repairOpts = RepairOptions{}
+
+	repairCmd = &cobra.Command{
+		Use:   "repair [volume [snapshot]]",
+		Short: "repair damaged chunks of a repo, volume or snapshot",
+		Long: `The repair command checks all chunks of a repo, volume or snapshot. Missing
+or corrupt parts of chunks, which have been stored with a failure tolerance, get
+reconstructed from the remaining parts and stored again`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 2 {
+				return i18n.Errorf("repair needs at most a volume and a snapshot ID")
+			}
+			return executeRepair(args, repairOpts)
+		},
+	}
+)
+
+func init() {
+	repairCmd.Flags().BoolVar(&repairOpts.DryRun, "dry-run", false, "only report damaged chunks, don't repair them")
+	RootCmd.AddCommand(repairCmd)
+}
+
+func executeRepair(args []string, opts RepairOptions) error {
+	// acquire a shutdown lock. we don't want these next calls to be interrupted
+	lock := shutdown.Lock()
+	if lock == nil {
+		return nil
+	}
+	defer lock()
+
+	repository, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+	if !opts.DryRun && !repository.IsAdmin() {
+		return knoxite.ErrAppendOnly
+	}
+	unlock, err := lockRepository(&repository, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var snapshots []*knoxite.Snapshot
+	if len(args) == 2 {
+		_, snapshot, err := repository.FindSnapshot(args[1])
+		if err != nil {
+			return err
+		}
+		snapshots = append(snapshots, snapshot)
+	} else {
+		volumes := repository.Volumes
+		if len(args) == 1 {
+			volume, err := repository.FindVolume(args[0])
+			if err != nil {
+				return err
+			}
+			volumes = []*knoxite.Volume{volume}
+		}
+
+		for _, volume := range volumes {
+			for _, id := range volume.Snapshots {
+				snapshot, err := volume.LoadSnapshot(id, &repository)
+				if err != nil {
+					return err
+				}
+				snapshots = append(snapshots, snapshot)
+			}
+		}
+	}
+
+	ctx, cancel := shutdown.CancelCtx(context.Background())
+	defer cancel()
+
+	report, err := knoxite.Repair(ctx, repository, snapshots, opts.DryRun)
+	if err != nil {
+		return err
+	}
+
+	for _, chunk := range report.Repaired {
+		verb := i18n.Sprintf("Repaired")
+		if opts.DryRun {
+			verb = i18n.Sprintf("Can repair")
+		}
+		for _, part := range chunk.Missing {
+			log.Printf("%s missing part %d of chunk %s", verb, part+1, chunk.Hash)
+		}
+		for _, part := range chunk.Corrupt {
+			log.Printf("%s corrupt part %d of chunk %s", verb, part+1, chunk.Hash)
+		}
+	}
+	for hash, reason := range report.Unrepairable {
+		fmt.Fprintln(os.Stderr, i18n.Sprintf("Chunk %s can't be repaired: %s", hash, reason))
+	}
+
+	log.Printf("Repair done: %d chunks checked, %d repaired, %d unrepairable",
+		report.Checked, len(report.Repaired), len(report.Unrepairable))
+	return nil
+}
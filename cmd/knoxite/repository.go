@@ -9,18 +9,36 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"encoding/base64"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
 
 	shutdown "github.com/klauspost/shutdown2"
 	"github.com/muesli/gotable"
 	"github.com/spf13/cobra"
 
 	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
 	"github.com/knoxite/knoxite/cmd/knoxite/utils"
 )
 
+// RepoInitOptions holds all the options that can be set for the 'repo init'
+// command.
+type RepoInitOptions struct {
+	Hint       string
+	Asymmetric bool
+	Dedup      string
+}
+
 var (
+	repoInitOpts    = RepoInitOptions{}
+	repoSheetOutput string
+	repoPackDryRun  bool
+
 	repoCmd = &cobra.Command{
 		Use:   "repo",
 		Short: "manage repository",
@@ -32,7 +50,31 @@ var (
 		Short: "initialize a new repository",
 		Long:  `The init command initializes a new repository`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return executeRepoInit()
+			return executeRepoInit(repoInitOpts)
+		},
+	}
+	repoHintCmd = &cobra.Command{
+		Use:   "hint [hint]",
+		Short: "show or set the password hint of a repository",
+		Long: "The hint command shows the password hint of a repository, which can be read without the password. " +
+			"When a hint is given, it replaces the existing one. The hint is stored unencrypted, so it must not reveal the password",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				return i18n.Errorf("hint needs the hint as a single (quoted) argument")
+			}
+			if len(args) == 0 {
+				return executeRepoShowHint()
+			}
+			return executeRepoSetHint(args[0])
+		},
+	}
+	repoSheetCmd = &cobra.Command{
+		Use:   "recovery-sheet",
+		Short: "print an emergency recovery sheet",
+		Long: "The recovery-sheet command renders a printable sheet with the repository's location, ID, " +
+			"key fingerprint, password hint and instructions on how to restore your data",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return executeRepoRecoverySheet(repoSheetOutput)
 		},
 	}
 	repoChangePasswordCmd = &cobra.Command{
@@ -65,32 +107,88 @@ var (
 		Long:  `The add command adds another storage backend to a repository`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) != 1 {
-				return fmt.Errorf("add needs a URL to be added")
+				return i18n.Errorf("add needs a URL to be added")
 			}
 			return executeRepoAdd(args[0])
 		},
 	}
+	repoAppendOnlyCmd = &cobra.Command{
+		Use:   "append-only [on|off]",
+		Short: "show or change the append-only mode of a repository",
+		Long: `The append-only command shows whether a repository is append-only, or enables
+and disables this mode. Clients of an append-only repository can store new
+snapshots, but can neither remove nor overwrite any data, unless they use an
+admin key. The password used to enable the mode becomes an admin key, so give
+your regular clients another password, added with 'key add'.
+
+The mode is only enforced by knoxite itself. To protect the repository from
+compromised clients, its storage has to reject deletions as well, e.g. by
+running 'knoxite serve --append-only'`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				return i18n.Errorf("append-only needs either on or off as argument")
+			}
+			if len(args) == 0 {
+				return executeRepoShowAppendOnly()
+			}
+			switch args[0] {
+			case "on":
+				return executeRepoSetAppendOnly(true)
+			case "off":
+				return executeRepoSetAppendOnly(false)
+			}
+			return i18n.Errorf("append-only needs either on or off as argument")
+		},
+	}
+	repoDedupCmd = &cobra.Command{
+		Use:   "dedup [repository|volume]",
+		Short: "show or change the scope of deduplication",
+		Long: `The dedup command shows or changes whether equal data gets stored only once in
+the whole repository, or once per volume. Deduplicating each volume on its own
+isolates the data of the volumes from each other, so removing all snapshots of
+a volume and packing the repository deletes all of the volume's data. The
+scope only affects snapshots stored afterwards`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) > 1 {
+				return i18n.Errorf("dedup needs either repository or volume as argument")
+			}
+			if len(args) == 0 {
+				return executeRepoShowDedup()
+			}
+			return executeRepoSetDedup(args[0])
+		},
+	}
 	repoPackCmd = &cobra.Command{
 		Use:   "pack",
 		Short: "pack repository and release redundant data",
 		Long:  `The pack command deletes all unused data chunks from storage`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return executeRepoPack()
+			return executeRepoPack(repoPackDryRun)
 		},
 	}
 )
 
 func init() {
+	repoInitCmd.Flags().StringVar(&repoInitOpts.Hint, "hint", "", "an unencrypted hint that helps you remember the password")
+	repoInitCmd.Flags().StringVar(&repoInitOpts.Dedup, "dedup", knoxite.DedupRepository, "scope of deduplication: repository or volume")
+	repoInitCmd.Flags().BoolVar(&repoInitOpts.Asymmetric, "asymmetric", false, "seal all data with a public key, reading it requires the private key written to --private-key")
+	repoPackCmd.Flags().BoolVar(&repoPackDryRun, "dry-run", false, "only list the chunks that would be deleted and the space it would free")
+	repoSheetCmd.Flags().StringVarP(&repoSheetOutput, "output", "o", "", "write the recovery sheet to a file instead of stdout")
+
 	repoCmd.AddCommand(repoInitCmd)
+	repoCmd.AddCommand(repoHintCmd)
+	repoCmd.AddCommand(repoSheetCmd)
 	repoCmd.AddCommand(repoChangePasswordCmd)
 	repoCmd.AddCommand(repoCatCmd)
 	repoCmd.AddCommand(repoInfoCmd)
 	repoCmd.AddCommand(repoAddCmd)
+	repoCmd.AddCommand(repoAppendOnlyCmd)
+	repoCmd.AddCommand(repoDedupCmd)
 	repoCmd.AddCommand(repoPackCmd)
 	RootCmd.AddCommand(repoCmd)
 }
 
-func executeRepoInit() error {
+func executeRepoInit(opts RepoInitOptions) error {
 	// acquire a shutdown lock. we don't want these next calls to be interrupted
 	lock := shutdown.Lock()
 	if lock == nil {
@@ -98,12 +196,110 @@ func executeRepoInit() error {
 	}
 	defer lock()
 
+	if opts.Asymmetric {
+		if globalOpts.PrivateKey == "" {
+			return i18n.Errorf("--asymmetric needs a file to write the private key to, use --private-key")
+		}
+		if _, err := os.Stat(globalOpts.PrivateKey); err == nil {
+			return i18n.Errorf("Private key file %s already exists", globalOpts.PrivateKey)
+		}
+	}
+
 	r, err := newRepository(globalOpts.Repo, globalOpts.Password)
 	if err != nil {
-		return fmt.Errorf("Creating repository at %s failed: %v", globalOpts.Repo, err)
+		return i18n.Errorf("Creating repository at %s failed: %v", globalOpts.Repo, err)
+	}
+
+	log.Printf("Created new repository at %s", (*r.BackendManager().Backends[0]).Location())
+
+	if opts.Asymmetric {
+		key, err := r.EnableAsymmetricEncryption()
+		if err != nil {
+			return err
+		}
+		// without the private key none of the data can ever be read again
+		s := base64.StdEncoding.EncodeToString(key) + "\n"
+		if err := ioutil.WriteFile(globalOpts.PrivateKey, []byte(s), 0600); err != nil {
+			return i18n.Errorf("Writing private key failed: %v, it is %s", err, s)
+		}
+		log.Printf("Wrote private key to %s, keep it safe: it's required to restore any data", globalOpts.PrivateKey)
+	}
+
+	if opts.Dedup != knoxite.DedupRepository {
+		if err := r.SetDedupScope(opts.Dedup); err != nil {
+			return err
+		}
 	}
 
-	fmt.Printf("Created new repository at %s\n", (*r.BackendManager().Backends[0]).Location())
+	if opts.Hint != "" {
+		if err = r.SetPasswordHint(opts.Hint); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func executeRepoShowHint() error {
+	hint, err := knoxite.LoadPasswordHint(globalOpts.Repo)
+	if err != nil || hint == "" {
+		return i18n.Errorf("This repository has no password hint")
+	}
+
+	fmt.Println(hint)
+	return nil
+}
+
+func executeRepoSetHint(hint string) error {
+	// acquire a shutdown lock. we don't want these next calls to be interrupted
+	lock := shutdown.Lock()
+	if lock == nil {
+		return nil
+	}
+	defer lock()
+
+	// only the owner of the repository may change its hint
+	r, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+
+	err = r.SetPasswordHint(hint)
+	if err != nil {
+		return err
+	}
+
+	log.Print(i18n.Sprintf("Changed password hint successfully"))
+	return nil
+}
+
+func executeRepoRecoverySheet(output string) error {
+	r, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+	if r.ID == "" {
+		// assigns an ID to repositories created by older versions
+		if err = r.Save(); err != nil {
+			return err
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err = writeRecoverySheet(w, &r); err != nil {
+		return err
+	}
+	if output != "" {
+		log.Printf("Wrote recovery sheet to %s", output)
+	}
 	return nil
 }
 
@@ -113,7 +309,7 @@ func executeRepoChangePassword() error {
 		return err
 	}
 
-	password, err := utils.ReadPasswordTwice("Enter new password:", "Confirm password:")
+	password, err := utils.ReadPasswordTwice(i18n.Sprintf("Enter new password:"), i18n.Sprintf("Confirm password:"))
 	if err != nil {
 		return err
 	}
@@ -122,8 +318,11 @@ func executeRepoChangePassword() error {
 	if err != nil {
 		return err
 	}
+	if globalOpts.Keyring {
+		savePasswordInKeyring(globalOpts.Repo, password)
+	}
 
-	fmt.Printf("Changed password successfully\n")
+	log.Print(i18n.Sprintf("Changed password successfully"))
 	return nil
 }
 
@@ -156,7 +355,7 @@ func executeRepoAdd(url string) error {
 	if err != nil {
 		return err
 	}
-	fmt.Printf("Added %s to repository\n", backend.Location())
+	log.Printf("Added %s to repository", backend.Location())
 	return nil
 }
 
@@ -166,25 +365,130 @@ func executeRepoCat() error {
 		return err
 	}
 
-	json, err := json.MarshalIndent(r, "", "    ")
+	return printJSON(r)
+}
+
+func executeRepoShowAppendOnly() error {
+	r, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !r.AppendOnly:
+		log.Print(i18n.Sprintf("The repository is not append-only"))
+	case r.IsAdmin():
+		log.Print(i18n.Sprintf("The repository is append-only, the key in use is an admin key"))
+	default:
+		log.Print(i18n.Sprintf("The repository is append-only, the key in use can't remove any data"))
+	}
+	return nil
+}
+
+func executeRepoSetAppendOnly(enable bool) error {
+	r, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockRepository(&r, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := r.SetAppendOnly(enable); err != nil {
+		return err
+	}
+	if enable {
+		log.Print(i18n.Sprintf("The repository is append-only now, only the password in use can remove data"))
+	} else {
+		log.Print(i18n.Sprintf("The repository is not append-only anymore"))
+	}
+	return nil
+}
+
+func executeRepoShowDedup() error {
+	r, err := openRepository(globalOpts.Repo, globalOpts.Password)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("%s\n", json)
+
+	if r.DedupScope() == knoxite.DedupVolume {
+		log.Print(i18n.Sprintf("Equal data gets stored once per volume"))
+	} else {
+		log.Print(i18n.Sprintf("Equal data gets stored once in the whole repository"))
+	}
 	return nil
 }
 
-func executeRepoPack() error {
+func executeRepoSetDedup(scope string) error {
 	r, err := openRepository(globalOpts.Repo, globalOpts.Password)
 	if err != nil {
 		return err
 	}
+	unlock, err := lockRepository(&r, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := r.SetDedupScope(scope); err != nil {
+		return err
+	}
+	if scope == knoxite.DedupVolume {
+		log.Print(i18n.Sprintf("Equal data gets stored once per volume from now on"))
+	} else {
+		log.Print(i18n.Sprintf("Equal data gets stored once in the whole repository from now on"))
+	}
+	return nil
+}
+
+func executeRepoPack(dryRun bool) error {
+	r, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+	if !dryRun && !r.IsAdmin() {
+		return knoxite.ErrAppendOnly
+	}
+	unlock, err := lockRepository(&r, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	index, err := knoxite.OpenChunkIndex(&r)
 	if err != nil {
 		return err
 	}
+	if dryRun {
+		chunks, size, err := index.Unreferenced(&r)
+		if err != nil {
+			return err
+		}
+		if globalOpts.JSON {
+			return printJSON(struct {
+				Chunks []knoxite.ChunkIndexItem `json:"chunks"`
+				Size   uint64                   `json:"size"`
+			}{chunks, size})
+		}
+		for _, chunk := range chunks {
+			log.Printf("Would delete chunk %s (%s)", chunk.Hash,
+				knoxite.SizeToString(uint64(chunk.Size)*uint64(chunk.DataParts+chunk.ParityParts)))
+		}
+		log.Printf("Would free storage space: %s by deleting %d chunks", knoxite.SizeToString(size), len(chunks))
+		return nil
+	}
 
-	freedSize, err := index.Pack(&r)
+	// packing can be interrupted safely, as long as the index of the chunks
+	// deleted so far gets saved
+	ctx, cancel := shutdown.CancelCtx(context.Background())
+	defer cancel()
+
+	freedSize, err := index.Pack(ctx, &r)
+	if err != nil && err == ctx.Err() {
+		log.Print(i18n.Sprintf("Aborting..."))
+		err = nil
+	}
 	if err != nil {
 		return err
 	}
@@ -194,7 +498,7 @@ func executeRepoPack() error {
 		return err
 	}
 
-	fmt.Printf("Freed storage space: %s\n", knoxite.SizeToString(freedSize))
+	log.Printf("Freed storage space: %s", knoxite.SizeToString(freedSize))
 	return nil
 }
 
@@ -204,6 +508,20 @@ func executeRepoInfo() error {
 		return err
 	}
 
+	if globalOpts.JSON {
+		type entry struct {
+			URL            string `json:"url"`
+			Description    string `json:"description"`
+			AvailableSpace uint64 `json:"available_space"`
+		}
+		entries := []entry{}
+		for _, be := range r.BackendManager().Backends {
+			space, _ := (*be).AvailableSpace()
+			entries = append(entries, entry{(*be).Location(), (*be).Description(), space})
+		}
+		return printJSON(entries)
+	}
+
 	tab := gotable.NewTable([]string{"Storage URL", "Available Space"},
 		[]int64{-48, 15},
 		"No backends found.")
@@ -222,23 +540,83 @@ func executeRepoInfo() error {
 func openRepository(path, password string) (knoxite.Repository, error) {
 	if password == "" {
 		var err error
-		password, err = utils.ReadPassword("Enter password:")
+		password, err = readPassword(i18n.Sprintf("Enter password:"))
 		if err != nil {
 			return knoxite.Repository{}, err
 		}
 	}
 
-	return knoxite.OpenRepository(path, password)
+	open := knoxite.OpenRepository
+	if globalOpts.ReadOnly {
+		open = knoxite.OpenRepositoryReadOnly
+	}
+	r, err := open(path, password)
+	switch {
+	case err == knoxite.ErrOpenRepositoryFailed && keyringPassword:
+		log.Warn(i18n.Sprintf("The password saved in the OS keyring doesn't open the repository, run 'knoxite repo forget-password' to remove it"))
+	case err == knoxite.ErrOpenRepositoryFailed:
+		if hint, herr := knoxite.LoadPasswordHint(path); herr == nil && hint != "" {
+			fmt.Fprintln(os.Stderr, i18n.Sprintf("Password hint: %s", hint))
+		}
+	case err == knoxite.ErrRepositoryNewer:
+		fmt.Fprintln(os.Stderr, i18n.Sprintf("Use --force-read-only to try reading it anyway"))
+	case err == nil && r.ReadOnly() && !globalOpts.ReadOnly:
+		log.Warn(i18n.Sprintf("The repository has been written by a newer version of knoxite and can only be read"))
+	}
+	if err == nil && globalOpts.Keyring && promptedPassword {
+		savePasswordInKeyring(path, password)
+	}
+	if err == nil && r.Asymmetric() && globalOpts.PrivateKey != "" {
+		err = loadPrivateKey(&r, globalOpts.PrivateKey)
+	}
+	if err == nil && globalOpts.VolumePassword != "" {
+		unlockVolumes(&r, globalOpts.VolumePassword)
+	}
+	setRetryPolicy(&r)
+	return r, err
+}
+
+// loadPrivateKey reads the private key of a repository using asymmetric
+// encryption from a file.
+func loadPrivateKey(r *knoxite.Repository, path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return i18n.Errorf("Reading private key from %s failed: %v", path, err)
+	}
+	return r.SetPrivateKey(key)
+}
+
+// setRetryPolicy configures how often failed storage operations of a
+// repository get retried.
+func setRetryPolicy(r *knoxite.Repository) {
+	policy := knoxite.DefaultRetryPolicy
+	policy.Retries = globalOpts.Retries
+	r.BackendManager().Retry = &policy
 }
 
 func newRepository(path, password string) (knoxite.Repository, error) {
+	if password == "" && (globalOpts.PasswordFile != "" || globalOpts.PasswordCommand != "") {
+		var err error
+		if password, err = readPassword(""); err != nil {
+			return knoxite.Repository{}, err
+		}
+	}
 	if password == "" {
 		var err error
-		password, err = utils.ReadPasswordTwice("Enter a password to encrypt this repository with:", "Confirm password:")
+		password, err = utils.ReadPasswordTwice(i18n.Sprintf("Enter a password to encrypt this repository with:"), i18n.Sprintf("Confirm password:"))
 		if err != nil {
 			return knoxite.Repository{}, err
 		}
 	}
 
-	return knoxite.NewRepository(path, password)
+	r, err := knoxite.NewRepository(path, password)
+	if err == nil && globalOpts.Keyring {
+		savePasswordInKeyring(path, password)
+	}
+	setRetryPolicy(&r)
+	return r, err
 }
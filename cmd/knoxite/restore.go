@@ -9,24 +9,46 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"os"
+	"sort"
+	"strings"
 
-	"github.com/muesli/goprogressbar"
+	humanize "github.com/dustin/go-humanize"
+	shutdown "github.com/klauspost/shutdown2"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
+	"golang.org/x/term"
 
 	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
 )
 
 // Error declarations.
 var (
-	ErrTargetMissing = errors.New("please specify a directory to restore to")
+	ErrTargetMissing = i18n.Errorf("please specify a directory to restore to")
 )
 
 type RestoreOptions struct {
-	Excludes []string
-	Pedantic bool
+	Tags           []string
+	Includes       []string
+	Excludes       []string
+	Pedantic       bool
+	CheckSymLinks  bool
+	MaxOpenFiles   uint
+	SkipXAttrs     bool
+	Hydrate        bool
+	WaitForArchive bool
+	MinSize        string
+	MaxSize        string
+	ModifiedAfter  string
+	// ToArchive exports the snapshot as an archive to this file, "-" being
+	// stdout, instead of restoring it to a directory
+	ToArchive     string
+	ArchiveFormat string
+	// Yes starts restoring without asking for confirmation
+	Yes bool
 }
 
 var (
@@ -35,16 +57,23 @@ var (
 	restoreCmd = &cobra.Command{
 		Use:   "restore [snapshot] [destination]",
 		Short: "restore a snapshot",
-		Long:  `The restore command restores a snapshot to a directory`,
+		Long: `The restore command restores a snapshot to a directory. Use "latest" to restore the most recent snapshot, optionally of those carrying the tags given with --tag. ` +
+			`With --to-archive the snapshot gets exported as a tar, tar.gz, tar.zst or zip archive instead, without touching the disk`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) < 1 {
-				return fmt.Errorf("restore needs to know which snapshot to work on")
+				return i18n.Errorf("restore needs to know which snapshot to work on")
+			}
+
+			configureRestoreOpts(cmd, &restoreOpts)
+			if restoreOpts.ToArchive != "" {
+				if len(args) > 1 {
+					return i18n.Errorf("restore can't export to an archive and restore to a directory at the same time")
+				}
+				return executeExport(args[0], restoreOpts)
 			}
 			if len(args) < 2 {
 				return ErrTargetMissing
 			}
-
-			configureRestoreOpts(cmd, &restoreOpts)
 			return executeRestore(args[0], args[1], restoreOpts)
 		},
 	}
@@ -66,73 +95,300 @@ func configureRestoreOpts(cmd *cobra.Command, opts *RestoreOptions) {
 }
 
 func initRestoreFlags(f func() *pflag.FlagSet) {
+	f().StringArrayVar(&restoreOpts.Tags, "tag", []string{}, "restore the latest snapshot with this tag, can be given multiple times")
+	f().StringArrayVarP(&restoreOpts.Includes, "include", "i", []string{}, "only restore items matching this pattern, e.g. 'home/**/*.jpg'")
 	f().StringArrayVarP(&restoreOpts.Excludes, "excludes", "x", []string{}, "list of excludes")
+	f().StringVar(&restoreOpts.MinSize, "min-size", "", "only restore files of at least this size, e.g. 10MB")
+	f().StringVar(&restoreOpts.MaxSize, "max-size", "", "only restore files of at most this size, e.g. 4GB")
+	f().StringVar(&restoreOpts.ModifiedAfter, "modified-after", "", "only restore files modified since this date, YYYY-MM-DD [HH:MM:SS]")
 	f().BoolVar(&restoreOpts.Pedantic, "pedantic", false, "exit on first error")
+	f().BoolVar(&restoreOpts.CheckSymLinks, "check-symlinks", false, "report symlinks pointing outside of the restored tree")
+	f().BoolVar(&restoreOpts.SkipXAttrs, "skip-xattrs", false, "don't restore extended attributes and ACLs, e.g. if the target file system doesn't support them")
+	f().BoolVar(&restoreOpts.WaitForArchive, "wait-for-archive", false, "retrieve chunks stored in archive tiers like S3 Glacier and wait until they are available, which may take hours")
+	f().BoolVar(&restoreOpts.Hydrate, "hydrate", false, "move chunks stored in cheaper storage tiers back to the default tier before restoring them")
+	f().UintVar(&restoreOpts.MaxOpenFiles, "max-open-files", knoxite.DefaultMaxOpenFiles, "maximum amount of files to restore in parallel")
+	f().StringVar(&restoreOpts.ToArchive, "to-archive", "", "export the snapshot as an archive to this file instead, - writes it to stdout")
+	f().StringVar(&restoreOpts.ArchiveFormat, "archive-format", "", "format of the archive: tar, tar.gz, tar.zst or zip (default: guessed from the file name, tar for stdout)")
 }
 
 func init() {
 	initRestoreFlags(restoreCmd.Flags)
+	restoreCmd.Flags().BoolVarP(&restoreOpts.Yes, "yes", "y", false, "don't ask for confirmation before restoring")
 	RootCmd.AddCommand(restoreCmd)
 }
 
+// findTaggedSnapshot finds a snapshot carrying all tags. "latest" refers to the
+// most recent of those snapshots.
+func findTaggedSnapshot(repository *knoxite.Repository, snapshotID string, tags []string) (*knoxite.Volume, *knoxite.Snapshot, error) {
+	filter := knoxite.SnapshotFilter{Tags: tags}
+	if snapshotID == "latest" {
+		return repository.FindLatestSnapshot(filter)
+	}
+
+	volume, snapshot, err := repository.FindSnapshot(snapshotID)
+	if err == nil && !filter.Matches(snapshot) {
+		return volume, snapshot, i18n.Errorf("Snapshot %s isn't tagged with %s", snapshotID, strings.Join(tags, ", "))
+	}
+	return volume, snapshot, err
+}
+
+// confirmRestore shows what restoring archives involves and asks the user to
+// confirm it, unless --yes has been given or knoxite isn't run interactively.
+func confirmRestore(repository *knoxite.Repository, archives []*knoxite.Archive, target string, opts RestoreOptions) error {
+	plan := knoxite.PlanRestore(repository, archives)
+	log.Printf("Restoring %d files, %d directories and %d symlinks (%s) to %s",
+		plan.Files, plan.Dirs, plan.SymLinks, knoxite.SizeToString(plan.Size), target)
+	log.Printf("Downloading %s in %d chunks", knoxite.SizeToString(plan.Download), plan.Chunks)
+	var locations []string
+	for location := range plan.Backends {
+		locations = append(locations, location)
+	}
+	sort.Strings(locations)
+	for _, location := range locations {
+		log.Printf("    %s from %s", knoxite.SizeToString(plan.Backends[location]), knoxite.RedactURL(location))
+	}
+
+	if opts.Yes || globalOpts.JSON || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil
+	}
+	p := newPrompter()
+	p.out = os.Stderr
+	ok, err := p.confirm(i18n.Sprintf("Start restoring?"), true)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return i18n.Errorf("Restore aborted")
+	}
+	return nil
+}
+
 func executeRestore(snapshotID, target string, opts RestoreOptions) error {
 	repository, err := openRepository(globalOpts.Repo, globalOpts.Password)
 	if err != nil {
 		return err
 	}
+	unlock, err := lockRepository(&repository, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	_, snapshot, err := repository.FindSnapshot(snapshotID)
+	_, snapshot, err := findTaggedSnapshot(&repository, snapshotID, opts.Tags)
 	if err != nil {
 		return err
 	}
+	snapshot, err = snapshot.Merged(&repository)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := shutdown.CancelCtx(context.Background())
+	defer cancel()
 
-	progress, err := knoxite.DecodeSnapshot(repository, snapshot, target, opts.Excludes, opts.Pedantic)
+	ro := knoxite.RestoreOptions{
+		Includes:     opts.Includes,
+		Excludes:     opts.Excludes,
+		Pedantic:     opts.Pedantic,
+		MaxOpenFiles: opts.MaxOpenFiles,
+		SkipXAttrs:   opts.SkipXAttrs,
+	}
+	if err := parseRestoreFilters(opts, &ro); err != nil {
+		return err
+	}
+	archives, err := knoxite.SelectArchives(snapshot, ro)
+	if err != nil {
+		return err
+	}
+	if err := checkTieredChunks(ctx, &repository, archives, opts); err != nil {
+		return err
+	}
+	var totalSize uint64
+	for _, arc := range archives {
+		totalSize += arc.Size
+	}
+	if err := confirmRestore(&repository, archives, target, opts); err != nil {
+		return err
+	}
+
+	ui := newProgressUI()
+	ro.Timings = ui.timings
+	progress, err := knoxite.DecodeSnapshot(ctx, repository, snapshot, target, ro)
 	if err != nil {
 		return err
 	}
 
-	pb := &goprogressbar.ProgressBar{Total: 1000, Width: 40}
+	ui.SetTotal(totalSize, uint64(len(archives)))
 	stats := knoxite.Stats{}
-	lastPath := ""
 
 	errs := make(map[string]error)
 	for p := range progress {
 		if p.Error != nil {
 			if restoreOpts.Pedantic {
-				fmt.Println()
+				ui.Abort()
 				return p.Error
 			}
 			errs[p.Path] = p.Error
 			stats.Errors++
 		}
-
-		pb.Total = int64(p.CurrentItemStats.Size)
-		pb.Current = int64(p.CurrentItemStats.Transferred)
-		pb.PrependText = fmt.Sprintf("%s / %s  %s/s",
-			knoxite.SizeToString(uint64(pb.Current)),
-			knoxite.SizeToString(uint64(pb.Total)),
-			knoxite.SizeToString(p.TransferSpeed()))
-
-		if p.Path != lastPath {
-			// We have just started restoring a new item
-			if len(lastPath) > 0 {
-				fmt.Println()
-			}
-			lastPath = p.Path
-			pb.Text = p.Path
-		}
 		if p.CurrentItemStats.Size == p.CurrentItemStats.Transferred {
 			// We have just finished restoring an item
 			stats.Add(p.TotalStatistics)
 		}
 
-		pb.LazyPrint()
+		ui.Update(p)
+	}
+	if ctx.Err() != nil {
+		ui.Abort()
+		log.Print(i18n.Sprintf("Aborting..."))
+		return nil
 	}
-	fmt.Println()
-	fmt.Println("Restore done:", stats.String())
+	ui.Finish(stats)
 	for file, err := range errs {
-		fmt.Printf("'%s' failed to restore: %v\n", file, err)
+		fmt.Fprintln(os.Stderr, i18n.Sprintf("'%s' failed to restore: %v", file, err))
+	}
+
+	if opts.CheckSymLinks {
+		printSymLinkReport(knoxite.CheckSymLinks(snapshot, target))
+	}
+
+	return nil
+}
+
+// parseRestoreFilters parses the size and date filters given on the command
+// line.
+func parseRestoreFilters(opts RestoreOptions, ro *knoxite.RestoreOptions) error {
+	var err error
+	if opts.MinSize != "" {
+		if ro.MinSize, err = humanize.ParseBytes(opts.MinSize); err != nil {
+			return i18n.Errorf("invalid size %s: %v", opts.MinSize, err)
+		}
+	}
+	if opts.MaxSize != "" {
+		if ro.MaxSize, err = humanize.ParseBytes(opts.MaxSize); err != nil {
+			return i18n.Errorf("invalid size %s: %v", opts.MaxSize, err)
+		}
+	}
+	ro.ModifiedAfter, err = parseDate(opts.ModifiedAfter, false)
+	return err
+}
+
+// executeExport writes a snapshot as an archive to a file or stdout.
+func executeExport(snapshotID string, opts RestoreOptions) error {
+	format := opts.ArchiveFormat
+	if format == "" {
+		format = knoxite.ArchiveFormatFromPath(opts.ToArchive)
+	}
+	if format == "" {
+		if opts.ToArchive != "-" {
+			return i18n.Errorf("Can't tell the archive format of %s, use --archive-format", opts.ToArchive)
+		}
+		format = knoxite.FormatTar
+	}
+	if opts.ToArchive == "-" && globalOpts.JSON {
+		return i18n.Errorf("--json can't be used while writing the archive to stdout")
+	}
+
+	repository, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockRepository(&repository, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	_, snapshot, err := findTaggedSnapshot(&repository, snapshotID, opts.Tags)
+	if err != nil {
+		return err
+	}
+	snapshot, err = snapshot.Merged(&repository)
+	if err != nil {
+		return err
+	}
+
+	ui := newProgressUI()
+	out := os.Stdout
+	if opts.ToArchive == "-" {
+		// keep the archive clean
+		log.WithWriter(os.Stderr)
+		ui.out = os.Stderr
+	} else {
+		out, err = os.Create(opts.ToArchive)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
 	}
 
+	ctx, cancel := shutdown.CancelCtx(context.Background())
+	defer cancel()
+
+	ro := knoxite.RestoreOptions{
+		Includes: opts.Includes,
+		Excludes: opts.Excludes,
+		Timings:  ui.timings,
+	}
+	if err := parseRestoreFilters(opts, &ro); err != nil {
+		return err
+	}
+	archives, err := knoxite.SelectArchives(snapshot, ro)
+	if err != nil {
+		return err
+	}
+	if err := checkTieredChunks(ctx, &repository, archives, opts); err != nil {
+		return err
+	}
+	var totalSize uint64
+	for _, arc := range archives {
+		totalSize += arc.Size
+	}
+
+	progress, err := knoxite.ExportSnapshot(ctx, repository, snapshot, out, format, ro)
+	if err != nil {
+		return err
+	}
+
+	ui.SetTotal(totalSize, uint64(len(archives)))
+	stats := knoxite.Stats{}
+	for p := range progress {
+		if p.Error != nil {
+			ui.Abort()
+			return p.Error
+		}
+		if p.CurrentItemStats.Size == p.CurrentItemStats.Transferred {
+			stats.Add(p.TotalStatistics)
+		}
+		ui.Update(p)
+	}
+	if ctx.Err() != nil {
+		ui.Abort()
+		log.Print(i18n.Sprintf("Aborting..."))
+		return nil
+	}
+	ui.Finish(stats)
+
+	if opts.ToArchive != "-" {
+		return out.Close()
+	}
 	return nil
 }
+
+func printSymLinkReport(links []knoxite.SymLinkTarget) {
+	if len(links) == 0 {
+		log.Print(i18n.Sprintf("All symlinks point inside the restored tree"))
+		return
+	}
+
+	log.Warnf("%d symlinks need to be checked:", len(links))
+	for _, link := range links {
+		var problems []string
+		if link.Outside {
+			problems = append(problems, i18n.Sprintf("points outside of the restored tree"))
+		}
+		if link.Dangling {
+			problems = append(problems, i18n.Sprintf("target does not exist"))
+		}
+		log.Warnf("'%s' -> '%s': %s", link.Path, link.PointsTo, strings.Join(problems, ", "))
+	}
+}
@@ -0,0 +1,132 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"context"
+
+	shutdown "github.com/klauspost/shutdown2"
+	"github.com/spf13/cobra"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+)
+
+var (
+	repoRotateKeyCmd = &cobra.Command{
+		Use:   "rotate-key",
+		Short: "re-encrypt all data with a new key",
+		Long: `The rotate-key command replaces the key all data of the repository is encrypted
+with by a new random key, e.g. to comply with a key rotation policy or after the
+key may have been compromised. All chunks get re-encrypted with the new key and
+verified after storing them. Snapshots are migrated one by one, run the command
+again to resume an interrupted rotation. Once all snapshots have been
+re-encrypted, the repository gets packed and the old key gets removed.
+
+The passwords of the repository stay the same, but the key fingerprint changes,
+so print a new recovery sheet afterwards. Volumes protected by their own key
+keep their key`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return executeRepoRotateKey()
+		},
+	}
+)
+
+func init() {
+	repoCmd.AddCommand(repoRotateKeyCmd)
+}
+
+func executeRepoRotateKey() error {
+	r, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+	if !r.IsAdmin() {
+		return knoxite.ErrAppendOnly
+	}
+	unlock, err := lockRepository(&r, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if len(r.RetiredKeys) == 0 {
+		if err := r.RotateKey(); err != nil {
+			return err
+		}
+		log.Printf("Rotated key, new key fingerprint: %s", r.KeyFingerprint())
+	} else {
+		log.Printf("Resuming key rotation, new key fingerprint: %s", r.KeyFingerprint())
+	}
+
+	index, err := knoxite.OpenChunkIndex(&r)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := shutdown.CancelCtx(context.Background())
+	defer cancel()
+
+	// snapshots re-encrypted already provide their chunks to the others
+	re := knoxite.NewReencryption(&r, &index)
+	var pending []*knoxite.Snapshot
+	for _, vol := range r.Volumes {
+		if vol.Protected() {
+			// encrypted with the volume's key, not the repository's
+			continue
+		}
+		for _, id := range vol.Snapshots {
+			snapshot, err := vol.LoadSnapshot(id, &r)
+			if err != nil {
+				return err
+			}
+			if re.Pending(snapshot) {
+				pending = append(pending, snapshot)
+			}
+		}
+	}
+
+	var total knoxite.ReencryptStats
+	for _, snapshot := range pending {
+		stats, err := re.Snapshot(ctx, snapshot)
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Print(i18n.Sprintf("Aborting, run rotate-key again to resume"))
+				return nil
+			}
+			return i18n.Errorf("Re-encrypting snapshot %s failed: %v", snapshot.ID, err)
+		}
+		// saving the index makes the rotation resumable
+		if err := index.Save(&r); err != nil {
+			return err
+		}
+
+		log.Printf("Re-encrypted snapshot %s: %d chunks, %s stored", snapshot.ID, stats.Chunks, knoxite.SizeToString(stats.StorageSize))
+		total.Chunks += stats.Chunks
+		total.StorageSize += stats.StorageSize
+	}
+	// the chunk-index gets encrypted with the new key, too
+	if err := index.Save(&r); err != nil {
+		return err
+	}
+
+	// the chunks encrypted with the old key are no longer referenced
+	freed, err := index.Pack(ctx, &r)
+	if err != nil {
+		return err
+	}
+	if err := index.Save(&r); err != nil {
+		return err
+	}
+	if err := r.ForgetRetiredKeys(); err != nil {
+		return err
+	}
+
+	log.Printf("Re-encrypted %d snapshots and %d chunks, freed %s", len(pending), total.Chunks, knoxite.SizeToString(freed))
+	log.Print(i18n.Sprintf("The old key has been removed from the repository"))
+	return nil
+}
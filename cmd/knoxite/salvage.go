@@ -0,0 +1,164 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	shutdown "github.com/klauspost/shutdown2"
+	"github.com/spf13/cobra"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+)
+
+// SalvageOptions holds all the options that can be set for the 'salvage' command.
+type SalvageOptions struct {
+	Key    string
+	Verify bool
+	Report string
+	DryRun bool
+}
+
+var (
+	salvageOpts = SalvageOptions{}
+
+	salvageCmd = &cobra.Command{
+		Use:   "salvage",
+		Short: "recover snapshots from a damaged repository",
+		Long: `The salvage command scans all data stored in a repository and recovers
+every snapshot that can still be read, even if the repository's volumes or
+chunk-index are damaged. Snapshots that aren't part of any volume get added to
+a new volume called "Salvaged", and the chunk-index is rebuilt.
+
+If the repository file itself can't be opened anymore, pass the repository's
+data key with --key to recreate it. The data key is the "key" field shown by
+"knoxite repo cat". It's only stored in the repository file, so save it while
+the repository is still intact. A recreated repository shows the same key
+fingerprint as the repository's recovery sheet.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return executeSalvage(salvageOpts)
+		},
+	}
+)
+
+func init() {
+	salvageCmd.Flags().StringVar(&salvageOpts.Key, "key", "", "data key to recreate a lost repository file with (see 'repo cat')")
+	salvageCmd.Flags().BoolVar(&salvageOpts.Verify, "verify", false, "load and decode every chunk")
+	salvageCmd.Flags().StringVar(&salvageOpts.Report, "report", "", "write a report of all unrecoverable data to this file (JSON)")
+	salvageCmd.Flags().BoolVar(&salvageOpts.DryRun, "dry-run", false, "only report what could be salvaged, don't modify the repository")
+	RootCmd.AddCommand(salvageCmd)
+}
+
+func executeSalvage(opts SalvageOptions) error {
+	password, err := readPassword(i18n.Sprintf("Enter password:"))
+	if err != nil {
+		return err
+	}
+
+	repository, err := openRepository(globalOpts.Repo, password)
+	if err != nil {
+		if opts.Key == "" {
+			return err
+		}
+
+		log.Printf("Couldn't open repository (%v), recreating it from the data key", err)
+		repository, err = knoxite.RecoverRepository(globalOpts.Repo, password, opts.Key)
+		if err != nil {
+			return err
+		}
+		setRetryPolicy(&repository)
+		log.Printf("Key fingerprint: %s", repository.KeyFingerprint())
+	}
+
+	ctx, cancel := shutdown.CancelCtx(context.Background())
+	defer cancel()
+
+	report, err := knoxite.Salvage(ctx, &repository, knoxite.SalvageOptions{Verify: opts.Verify})
+	if err != nil {
+		return err
+	}
+	printSalvageReport(report)
+
+	if opts.Report != "" {
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(opts.Report, b, 0600); err != nil {
+			return err
+		}
+	}
+
+	if opts.DryRun {
+		return nil
+	}
+
+	// acquire a shutdown lock. we don't want these next calls to be interrupted
+	lock := shutdown.Lock()
+	if lock == nil {
+		return nil
+	}
+	defer lock()
+
+	if len(report.Unreferenced) > 0 {
+		vol, err := knoxite.NewVolume("Salvaged", "Snapshots recovered by knoxite salvage")
+		if err != nil {
+			return err
+		}
+		for _, id := range report.Unreferenced {
+			_ = vol.AddSnapshot(id)
+		}
+		_ = repository.AddVolume(vol)
+		log.Printf("Added %d snapshots to volume %s", len(report.Unreferenced), vol.ID)
+	}
+
+	// rebuild the chunk-index from all readable snapshots
+	index := knoxite.ChunkIndex{
+		Chunks: make(map[string]*knoxite.ChunkIndexItem),
+	}
+	for _, snapshot := range report.Snapshots {
+		for _, archive := range snapshot.Archives {
+			index.AddArchive(archive, snapshot.ID)
+		}
+	}
+	if err := index.Save(&repository); err != nil {
+		return err
+	}
+
+	return repository.Save()
+}
+
+func printSalvageReport(report *knoxite.SalvageReport) {
+	log.Printf("Readable snapshots:     %d", len(report.Snapshots))
+	log.Printf("Unreferenced snapshots: %d", len(report.Unreferenced))
+	log.Printf("Recoverable archives:   %d", report.RecoverableArchives)
+	log.Printf("Damaged archives:       %d", len(report.DamagedArchives))
+	log.Printf("Orphaned chunks:        %d", len(report.OrphanedChunks))
+	if len(report.OrphanedHeaders) > 0 {
+		log.Printf("Orphaned chunks with a chunk header: %d", len(report.OrphanedHeaders))
+	}
+
+	for id, reason := range report.UnreadableSnapshots {
+		log.Printf("Snapshot %s can't be read: %s", id, reason)
+	}
+	for _, archive := range report.DamagedArchives {
+		log.Printf("Archive %s in snapshot %s is damaged: %s", archive.Path, archive.Snapshot, archive.Error)
+	}
+	if len(report.UnlistedBackends) > 0 {
+		var locations []string
+		for _, l := range report.UnlistedBackends {
+			locations = append(locations, knoxite.RedactURL(l))
+		}
+		log.Printf("Stored data could not be listed for %s, only snapshots referenced by volumes were checked",
+			strings.Join(locations, ", "))
+	}
+}
@@ -0,0 +1,260 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"encoding/xml"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+)
+
+// serviceDefinition describes how to run the daemon as a service.
+type serviceDefinition struct {
+	Command string
+	Args    []string
+	User    string
+	Home    string
+}
+
+// CommandLine returns the daemon's command line, quoted for a shell.
+func (s serviceDefinition) CommandLine() string {
+	return shellQuote(append([]string{s.Command}, s.Args...))
+}
+
+// ArgLine returns the daemon's arguments, quoted for a shell.
+func (s serviceDefinition) ArgLine() string {
+	return shellQuote(s.Args)
+}
+
+var (
+	serviceCmd = &cobra.Command{
+		Use:   "service [systemd|launchd|openrc|runit|rc.d]",
+		Short: "generate a service definition running the daemon",
+		Long: `The service command prints a definition for the given service manager,
+which runs the daemon as the current user whenever the system is up:
+
+  systemd  user unit, e.g. ~/.config/systemd/user/knoxite.service
+  launchd  launch agent, e.g. ~/Library/LaunchAgents/io.knoxite.daemon.plist
+  openrc   init script, e.g. /etc/init.d/knoxite
+  runit    run script of a service directory, e.g. /etc/sv/knoxite/run
+  rc.d     FreeBSD rc.d script, e.g. /usr/local/etc/rc.d/knoxite
+
+The profiles run by the daemon need a password_file or password_command`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return i18n.Errorf("service needs to know which service manager to generate a definition for")
+			}
+			return executeService(args[0], daemonOpts)
+		},
+	}
+)
+
+func init() {
+	serviceCmd.Flags().StringArrayVar(&daemonOpts.Aliases, "profile", []string{}, "only run the jobs of the profile with this alias, can be given multiple times")
+	serviceCmd.Flags().StringVar(&daemonOpts.StatusListen, "status-listen", "", "address to serve a status page on, e.g. localhost:9143")
+	serviceCmd.Flags().UintVar(&daemonOpts.MaxJobs, "max-jobs", 1, "maximum amount of jobs running at the same time")
+	serviceCmd.Flags().StringVar(&daemonOpts.Bandwidth, "bandwidth", "", "upload rate shared by all running jobs per second, e.g. 10MB (default: unlimited)")
+	daemonCmd.AddCommand(serviceCmd)
+}
+
+// shellQuote joins args, quoting them for a POSIX shell where necessary.
+func shellQuote(args []string) string {
+	var quoted []string
+	for _, arg := range args {
+		if arg != "" && strings.IndexFunc(arg, func(r rune) bool {
+			return !strings.ContainsRune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=@,+", r)
+		}) < 0 {
+			quoted = append(quoted, arg)
+			continue
+		}
+		quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+	}
+	return strings.Join(quoted, " ")
+}
+
+// newServiceDefinition returns the definition of a service running the daemon
+// with the given options as the current user.
+func newServiceDefinition(opts DaemonOptions) (serviceDefinition, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return serviceDefinition{}, err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return serviceDefinition{}, err
+	}
+	u, err := user.Current()
+	if err != nil {
+		return serviceDefinition{}, err
+	}
+
+	s := serviceDefinition{
+		Command: exe,
+		User:    u.Username,
+		Home:    u.HomeDir,
+	}
+	if globalOpts.ConfigURL != "" {
+		s.Args = append(s.Args, "--configURL", globalOpts.ConfigURL)
+	}
+	if globalOpts.LogFile != "" {
+		s.Args = append(s.Args, "--log-file", globalOpts.LogFile)
+	}
+	s.Args = append(s.Args, "daemon")
+	if opts.Socket != "" {
+		s.Args = append(s.Args, "--socket", opts.Socket)
+	}
+	for _, alias := range opts.Aliases {
+		if _, ok := cfg.Repositories[alias]; !ok {
+			return s, i18n.Errorf("Unknown alias %s", alias)
+		}
+		s.Args = append(s.Args, "--profile", alias)
+	}
+	if opts.StatusListen != "" {
+		s.Args = append(s.Args, "--status-listen", opts.StatusListen)
+	}
+	if opts.MaxJobs > 1 {
+		s.Args = append(s.Args, "--max-jobs", strconv.FormatUint(uint64(opts.MaxJobs), 10))
+	}
+	if opts.Bandwidth != "" {
+		s.Args = append(s.Args, "--bandwidth", opts.Bandwidth)
+	}
+	return s, nil
+}
+
+func executeService(manager string, opts DaemonOptions) error {
+	tmpl, ok := serviceTemplates[manager]
+	if !ok {
+		var managers []string
+		for m := range serviceTemplates {
+			managers = append(managers, m)
+		}
+		sort.Strings(managers)
+		return i18n.Errorf("Unknown service manager %s, expected one of: %s", manager, strings.Join(managers, ", "))
+	}
+
+	s, err := newServiceDefinition(opts)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(os.Stdout, s)
+}
+
+// shellFuncs quote values for shell scripts.
+var shellFuncs = template.FuncMap{
+	"sh": func(s string) string {
+		return shellQuote([]string{s})
+	},
+}
+
+// serviceTemplates are the service definitions for each supported service
+// manager.
+var serviceTemplates = map[string]*template.Template{
+	"systemd": template.Must(template.New("systemd").Parse(`[Unit]
+Description=knoxite backup daemon
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+ExecStart={{.CommandLine}}
+Restart=on-failure
+RestartSec=1min
+
+[Install]
+WantedBy=default.target
+`)),
+
+	"launchd": template.Must(template.New("launchd").Funcs(template.FuncMap{
+		"xml": func(s string) string {
+			var b strings.Builder
+			_ = xml.EscapeText(&b, []byte(s))
+			return b.String()
+		},
+	}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>io.knoxite.daemon</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>{{xml .Command}}</string>
+{{- range .Args}}
+		<string>{{xml .}}</string>
+{{- end}}
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>ThrottleInterval</key>
+	<integer>60</integer>
+	<key>StandardOutPath</key>
+	<string>{{xml .Home}}/Library/Logs/knoxite.log</string>
+	<key>StandardErrorPath</key>
+	<string>{{xml .Home}}/Library/Logs/knoxite.log</string>
+</dict>
+</plist>
+`)),
+
+	"openrc": template.Must(template.New("openrc").Funcs(shellFuncs).Parse(`#!/sbin/openrc-run
+
+description="knoxite backup daemon"
+
+command={{sh .Command}}
+command_args="{{.ArgLine}}"
+command_user={{sh .User}}
+command_background=true
+pidfile="/run/${RC_SVCNAME}.pid"
+export HOME={{sh .Home}}
+
+depend() {
+	need net
+	after localmount
+}
+`)),
+
+	"runit": template.Must(template.New("runit").Funcs(shellFuncs).Parse(`#!/bin/sh
+export HOME={{sh .Home}}
+exec chpst -u {{sh .User}} {{.CommandLine}} 2>&1
+`)),
+
+	"rc.d": template.Must(template.New("rc.d").Funcs(shellFuncs).Parse(`#!/bin/sh
+
+# PROVIDE: knoxite
+# REQUIRE: LOGIN NETWORKING
+# KEYWORD: shutdown
+#
+# Add knoxite_enable="YES" to /etc/rc.conf to start the knoxite backup daemon.
+
+. /etc/rc.subr
+
+name=knoxite
+rcvar=knoxite_enable
+
+load_rc_config $name
+: ${knoxite_enable:="NO"}
+
+pidfile="/var/run/${name}.pid"
+knoxite_env=HOME={{sh .Home}}
+command=/usr/sbin/daemon
+command_args="-f -P ${pidfile} -r -u {{sh .User}} {{.CommandLine}}"
+
+run_rc_command "$1"
+`)),
+}
@@ -0,0 +1,415 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	shutdown "github.com/klauspost/shutdown2"
+	"github.com/spf13/cobra"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/config"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+	"github.com/knoxite/knoxite/cmd/knoxite/utils"
+)
+
+// schedules contains the valid values for a profile's schedule.
+var schedules = []string{"hourly", "daily", "weekly", "monthly", "never"}
+
+var (
+	setupCmd = &cobra.Command{
+		Use:   "setup",
+		Short: "interactively set up a new repository",
+		Long: "The setup command walks you through creating a new repository, " +
+			"a volume and a profile for your backups and writes them to the configuration file",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return executeSetup()
+		},
+	}
+)
+
+func init() {
+	RootCmd.AddCommand(setupCmd)
+}
+
+// validSchedule returns true for one of the schedules and cron expressions.
+func validSchedule(schedule string) bool {
+	_, err := knoxite.CalendarFromName(schedule)
+	return err == nil && schedule != ""
+}
+
+// profileSchedule returns the schedule snapshots of a profile get stored by.
+func profileSchedule(rc config.RepoConfig) (knoxite.Schedule, error) {
+	var schedule knoxite.Schedule
+	var err error
+	if schedule.Calendar, err = knoxite.CalendarFromName(rc.Schedule); err != nil {
+		return schedule, err
+	}
+	for _, b := range rc.Blackouts {
+		w, err := knoxite.ParseBlackoutWindow(b)
+		if err != nil {
+			return schedule, err
+		}
+		schedule.Blackouts = append(schedule.Blackouts, w)
+	}
+	if rc.Jitter != "" {
+		if schedule.Jitter, err = time.ParseDuration(rc.Jitter); err != nil {
+			return schedule, i18n.Errorf("Invalid jitter %s: %v", rc.Jitter, err)
+		}
+	}
+	schedule.CatchUp = rc.CatchUp
+
+	return schedule, nil
+}
+
+// prompter asks the user questions on the terminal.
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func newPrompter() *prompter {
+	return &prompter{
+		in:  bufio.NewReader(os.Stdin),
+		out: os.Stdout,
+	}
+}
+
+// ask asks a question and returns the answer. If the user doesn't answer, def
+// is returned.
+func (p *prompter) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+
+	s, err := p.in.ReadString('\n')
+	s = strings.TrimSpace(s)
+	if err != nil && (err != io.EOF || s == "") {
+		return "", err
+	}
+	if s == "" {
+		return def, nil
+	}
+	return s, nil
+}
+
+// askRequired asks a question until the user answers it.
+func (p *prompter) askRequired(question, def string) (string, error) {
+	for {
+		s, err := p.ask(question, def)
+		if err != nil || s != "" {
+			return s, err
+		}
+	}
+}
+
+// confirm asks a yes/no question.
+func (p *prompter) confirm(question string, def bool) (bool, error) {
+	d := "y/N"
+	if def {
+		d = "Y/n"
+	}
+
+	for {
+		s, err := p.ask(question+" ("+d+")", "")
+		if err != nil {
+			return false, err
+		}
+
+		switch strings.ToLower(s) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+	}
+}
+
+// choose asks the user to pick one of the given options.
+func (p *prompter) choose(question string, options []string, def string) (string, error) {
+	for {
+		s, err := p.ask(question+" ("+strings.Join(options, ", ")+")", def)
+		if err != nil {
+			return "", err
+		}
+
+		for _, o := range options {
+			if strings.EqualFold(o, s) {
+				return o, nil
+			}
+		}
+		fmt.Fprintln(p.out, i18n.Sprintf("Please pick one of: %s", strings.Join(options, ", ")))
+	}
+}
+
+// askList asks for a comma separated list of values.
+func (p *prompter) askList(question string) ([]string, error) {
+	s, err := p.ask(question, "")
+	if err != nil || s == "" {
+		return nil, err
+	}
+
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+	return l, nil
+}
+
+// password asks for a new password, until the user picked one and confirmed
+// it.
+func (p *prompter) password() (string, error) {
+	for {
+		pw, err := utils.ReadPasswordTwice(i18n.Sprintf("Enter a password to encrypt this repository with:"),
+			i18n.Sprintf("Confirm password:"))
+		switch err {
+		case nil:
+			return pw, nil
+		case utils.ErrPasswordMismatch, utils.ErrPasswordWeak:
+			fmt.Fprintln(p.out, i18n.Sprintf("%v, please try again.", err))
+		default:
+			return "", err
+		}
+	}
+}
+
+// repositoryURL asks for the storage backend and its location & credentials.
+func (p *prompter) repositoryURL() (string, error) {
+	protocols := knoxite.StorageProtocols()
+	sort.Strings(protocols)
+
+	scheme, err := p.choose(i18n.Sprintf("Storage backend"), protocols, "file")
+	if err != nil {
+		return "", err
+	}
+
+	if scheme == "file" {
+		def := ""
+		if home, err := os.UserHomeDir(); err == nil {
+			def = filepath.Join(home, "knoxite")
+		}
+		path, err := p.askRequired(i18n.Sprintf("Repository directory"), def)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Abs(path)
+	}
+
+	u := url.URL{Scheme: scheme}
+	if u.Host, err = p.ask(i18n.Sprintf("Host or account (if required)"), ""); err != nil {
+		return "", err
+	}
+	if u.Path, err = p.ask(i18n.Sprintf("Path or bucket"), ""); err != nil {
+		return "", err
+	}
+	if u.Path != "" && !strings.HasPrefix(u.Path, "/") {
+		u.Path = "/" + u.Path
+	}
+
+	user, err := p.ask(i18n.Sprintf("Username or access key (if required)"), "")
+	if err != nil || user == "" {
+		return u.String(), err
+	}
+	secret, err := utils.ReadPassword(i18n.Sprintf("Password or secret key (leave empty if not required):"))
+	if err != nil {
+		return "", err
+	}
+	if secret != "" {
+		u.User = url.UserPassword(user, secret)
+	} else {
+		u.User = url.User(user)
+	}
+
+	return u.String(), nil
+}
+
+// profile asks for the settings used to store snapshots in volume.
+func (p *prompter) profile(repoURL, volume string) (config.RepoConfig, error) {
+	rc := config.RepoConfig{
+		Url:    repoURL,
+		Volume: volume,
+	}
+
+	var err error
+	if rc.StorePaths, err = p.askList(i18n.Sprintf("Files and directories to back up (comma separated)")); err != nil {
+		return rc, err
+	}
+	for i, path := range rc.StorePaths {
+		if abs, err := filepath.Abs(path); err == nil {
+			rc.StorePaths[i] = abs
+		}
+	}
+	if rc.StoreExcludes, err = p.askList(i18n.Sprintf("Exclude patterns (comma separated)")); err != nil {
+		return rc, err
+	}
+
+	if rc.Compression, err = p.choose(i18n.Sprintf("Compression"),
+		[]string{"none", "flate", "gzip", "lzma", "zlib", "zstd"}, "none"); err != nil {
+		return rc, err
+	}
+	if rc.Encryption, err = p.choose(i18n.Sprintf("Encryption"), []string{"aes", "none"}, "aes"); err != nil {
+		return rc, err
+	}
+	if rc.Schedule, err = p.choose(i18n.Sprintf("How often should snapshots be stored"), schedules, "daily"); err != nil {
+		return rc, err
+	}
+
+	return rc, nil
+}
+
+func executeSetup() error {
+	p := newPrompter()
+
+	fmt.Fprintln(p.out, i18n.Sprintf("This will walk you through setting up a new knoxite repository."))
+	fmt.Fprintln(p.out)
+
+	repoURL, err := p.repositoryURL()
+	if err != nil {
+		return err
+	}
+
+	password := globalOpts.Password
+	if password == "" {
+		if password, err = p.password(); err != nil {
+			return err
+		}
+	}
+	hint, err := p.ask(i18n.Sprintf("Password hint, stored unencrypted (optional)"), "")
+	if err != nil {
+		return err
+	}
+
+	volName, err := p.askRequired(i18n.Sprintf("Name of the volume for your backups"), "Backups")
+	if err != nil {
+		return err
+	}
+	volDesc, err := p.ask(i18n.Sprintf("Description of the volume"), "")
+	if err != nil {
+		return err
+	}
+
+	alias, err := p.askRequired(i18n.Sprintf("Name of the profile"), "default")
+	if err != nil {
+		return err
+	}
+	alias = strings.ToLower(alias)
+	if _, ok := cfg.Repositories[alias]; ok {
+		ok, err := p.confirm(i18n.Sprintf("Profile %s already exists. Overwrite it?", alias), false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return i18n.Errorf("Setup aborted")
+		}
+	}
+
+	// acquire a shutdown lock. we don't want these next calls to be interrupted
+	lock := shutdown.Lock()
+	if lock == nil {
+		return nil
+	}
+	defer lock()
+
+	r, err := knoxite.NewRepository(repoURL, password)
+	if err != nil {
+		return i18n.Errorf("Creating repository at %s failed: %v", repoURL, err)
+	}
+	setRetryPolicy(&r)
+	log.Printf("Created new repository at %s", (*r.BackendManager().Backends[0]).Location())
+	if hint != "" {
+		if err = r.SetPasswordHint(hint); err != nil {
+			return err
+		}
+	}
+
+	vol, err := knoxite.NewVolume(volName, volDesc)
+	if err != nil {
+		return err
+	}
+	if err = r.AddVolume(vol); err != nil {
+		return i18n.Errorf("Creating volume %s failed: %v", volName, err)
+	}
+	if err = r.Save(); err != nil {
+		return err
+	}
+	log.Printf("Volume %s (Name: %s) created", vol.ID, vol.Name)
+
+	rc, err := p.profile(repoURL, vol.ID)
+	if err != nil {
+		return err
+	}
+	cfg.Repositories[alias] = rc
+
+	log.Printf("Writing configuration file to: %s", cfg.URL().Path)
+	if err = cfg.Save(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(p.out)
+	ok, err := p.confirm(i18n.Sprintf("Print a recovery sheet to keep in a safe place?"), true)
+	if err != nil {
+		return err
+	}
+	if ok {
+		fmt.Fprintln(p.out)
+		if err = writeRecoverySheet(p.out, &r); err != nil {
+			return err
+		}
+		fmt.Fprintln(p.out)
+	}
+
+	if len(rc.StorePaths) > 0 {
+		fmt.Fprintln(p.out, i18n.Sprintf("You can now store a snapshot with: knoxite -R %s store", alias))
+		if line := cronLine(rc.Schedule, alias); line != "" {
+			fmt.Fprintln(p.out, i18n.Sprintf("To store snapshots %s, add this line to your crontab (and set KNOXITE_PASSWORD):", rc.Schedule))
+			fmt.Fprintln(p.out, "    "+line)
+		}
+	} else {
+		fmt.Fprintln(p.out, i18n.Sprintf("You can now store a snapshot with: knoxite -R %s store %s [dir/file]", alias, vol.ID))
+	}
+
+	return nil
+}
+
+// cronLine returns a crontab entry storing a snapshot for alias according to
+// schedule.
+func cronLine(schedule, alias string) string {
+	var spec string
+	switch schedule {
+	case "hourly":
+		spec = "0 * * * *"
+	case "daily":
+		spec = "0 3 * * *"
+	case "weekly":
+		spec = "0 3 * * 0"
+	case "monthly":
+		spec = "0 3 1 * *"
+	case "never", "":
+		return ""
+	default:
+		// a cron expression
+		spec = schedule
+	}
+
+	return spec + " knoxite -R " + alias + " store"
+}
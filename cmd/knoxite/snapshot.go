@@ -9,14 +9,50 @@ package main
 
 import (
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
+	shutdown "github.com/klauspost/shutdown2"
 	"github.com/muesli/gotable"
 	"github.com/spf13/cobra"
 
 	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
 )
 
+// SnapshotListOptions holds all the options that can be set for the
+// 'snapshot list' command.
+type SnapshotListOptions struct {
+	Search      string
+	Tags        []string
+	Annotations []string
+	Host        string
+	Since       string
+	Until       string
+	Long        bool
+}
+
+// SnapshotAnnotateOptions holds all the options that can be set for the
+// 'snapshot annotate' command.
+type SnapshotAnnotateOptions struct {
+	Remove []string
+}
+
+// SnapshotForgetOptions holds all the options that can be set for the
+// 'snapshot forget' command.
+type SnapshotForgetOptions struct {
+	Keep      string
+	KeepPaths []string
+	Tags      []string
+	DryRun    bool
+}
+
 var (
+	snapshotListOpts     = SnapshotListOptions{}
+	snapshotForgetOpts   = SnapshotForgetOptions{}
+	snapshotAnnotateOpts = SnapshotAnnotateOptions{}
+
 	snapshotCmd = &cobra.Command{
 		Use:   "snapshot",
 		Short: "manage snapshots",
@@ -29,9 +65,9 @@ var (
 		Long:  `The list command lists all snapshots stored in a volume`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) != 1 {
-				return fmt.Errorf("list needs a volume ID to work on")
+				return i18n.Errorf("list needs a volume ID to work on")
 			}
-			return executeSnapshotList(args[0])
+			return executeSnapshotList(args[0], snapshotListOpts)
 		},
 	}
 	snapshotRemoveCmd = &cobra.Command{
@@ -40,16 +76,81 @@ var (
 		Long:  `The remove command deletes a snapshot from a volume`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) != 1 {
-				return fmt.Errorf("remove needs a snapshot ID to work on")
+				return i18n.Errorf("remove needs a snapshot ID to work on")
 			}
 			return executeSnapshotRemove(args[0])
 		},
 	}
+	snapshotAnnotateCmd = &cobra.Command{
+		Use:   "annotate [snapshot] [key=value] [...]",
+		Short: "attach annotations to a snapshot",
+		Long: `The annotate command attaches key/value annotations to an existing snapshot,
+e.g. to record who verified it or a ticket ID. Existing annotations of the same
+key get replaced, --remove removes annotations. Without any annotations to
+change, it shows the snapshot's annotations. 'snapshot list --annotation'
+lists the snapshots carrying an annotation`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) < 1 {
+				return i18n.Errorf("annotate needs a snapshot ID to work on")
+			}
+			return executeSnapshotAnnotate(args[0], args[1:], snapshotAnnotateOpts)
+		},
+	}
+	snapshotForgetCmd = &cobra.Command{
+		Use:   "forget [volume]",
+		Short: "remove snapshots according to a retention policy",
+		Long: `The forget command removes all snapshots of a volume, which aren't kept by
+a retention policy. Items stored below a path given with --keep-path are subject
+to that path's policy instead, and get removed from snapshots which only that
+policy doesn't keep. With --tag only snapshots carrying all given tags are
+affected, so different backup jobs sharing a volume can be managed independently`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rep, ok := cfg.Repositories[globalOpts.Alias]
+			if ok && len(args) == 0 && rep.Volume != "" {
+				args = append(args, rep.Volume)
+			}
+			if len(args) != 1 {
+				return i18n.Errorf("forget needs a volume ID to work on")
+			}
+
+			// fall back to the retention policies configured for this alias
+			if ok && !cmd.Flags().Changed("keep") {
+				snapshotForgetOpts.Keep = rep.Keep
+			}
+			if ok && !cmd.Flags().Changed("keep-path") {
+				snapshotForgetOpts.KeepPaths = rep.KeepPaths
+			}
+			if ok && !cmd.Flags().Changed("tag") {
+				snapshotForgetOpts.Tags = rep.Tags
+			}
+			if err := executeSnapshotForget(args[0], snapshotForgetOpts); err != nil {
+				return err
+			}
+			if snapshotForgetOpts.DryRun {
+				return nil
+			}
+			return autoVerify(true)
+		},
+	}
 )
 
 func init() {
+	snapshotListCmd.Flags().StringVarP(&snapshotListOpts.Search, "search", "s", "", "only list snapshots whose description, tags or hostname contain this text")
+	snapshotListCmd.Flags().StringArrayVar(&snapshotListOpts.Tags, "tag", []string{}, "only list snapshots with this tag, can be given multiple times")
+	snapshotListCmd.Flags().StringArrayVar(&snapshotListOpts.Annotations, "annotation", []string{}, "only list snapshots with this annotation, given as key or key=value, can be given multiple times")
+	snapshotListCmd.Flags().StringVar(&snapshotListOpts.Host, "host", "", "only list snapshots taken on this host")
+	snapshotListCmd.Flags().StringVar(&snapshotListOpts.Since, "since", "", "only list snapshots taken since this date (YYYY-MM-DD [HH:MM:SS])")
+	snapshotListCmd.Flags().BoolVarP(&snapshotListOpts.Long, "long", "l", false, "also list the stored paths and the command line that stored each snapshot")
+	snapshotListCmd.Flags().StringVar(&snapshotListOpts.Until, "until", "", "only list snapshots taken until this date (YYYY-MM-DD [HH:MM:SS])")
+	snapshotForgetCmd.Flags().StringVar(&snapshotForgetOpts.Keep, "keep", "", "retention policy, e.g. last=3,daily=7,weekly=4,monthly=12,yearly=2")
+	snapshotForgetCmd.Flags().StringArrayVar(&snapshotForgetOpts.KeepPaths, "keep-path", []string{}, "retention policy for items below a path, e.g. /etc:daily=30,monthly=12")
+	snapshotForgetCmd.Flags().StringArrayVar(&snapshotForgetOpts.Tags, "tag", []string{}, "only forget snapshots with this tag, can be given multiple times")
+	snapshotForgetCmd.Flags().BoolVar(&snapshotForgetOpts.DryRun, "dry-run", false, "only show which snapshots and items would be removed")
 	snapshotCmd.AddCommand(snapshotListCmd)
 	snapshotCmd.AddCommand(snapshotRemoveCmd)
+	snapshotAnnotateCmd.Flags().StringArrayVar(&snapshotAnnotateOpts.Remove, "remove", []string{}, "remove the annotation with this key, can be given multiple times")
+	snapshotCmd.AddCommand(snapshotForgetCmd)
+	snapshotCmd.AddCommand(snapshotAnnotateCmd)
 	RootCmd.AddCommand(snapshotCmd)
 }
 
@@ -58,6 +159,14 @@ func executeSnapshotRemove(snapshotID string) error {
 	if err != nil {
 		return err
 	}
+	if !repository.IsAdmin() {
+		return knoxite.ErrAppendOnly
+	}
+	unlock, err := lockRepository(&repository, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	chunkIndex, err := knoxite.OpenChunkIndex(&repository)
 	if err != nil {
 		return err
@@ -84,43 +193,368 @@ func executeSnapshotRemove(snapshotID string) error {
 		return err
 	}
 
-	fmt.Printf("Snapshot %s removed: %s\n", snapshot.ID, snapshot.Stats.String())
-	fmt.Println("Do not forget to run 'repo pack' to delete un-referenced chunks and free up storage space!")
+	log.Printf("Snapshot %s removed: %s", snapshot.ID, snapshot.Stats.String())
+	log.Print(i18n.Sprintf("Do not forget to run 'repo pack' to delete un-referenced chunks and free up storage space!"))
 	return nil
 }
 
-func executeSnapshotList(volID string) error {
-	repository, err := openRepository(globalOpts.Repo, globalOpts.Password)
+// parsePathRetentionPolicies parses retention policies for paths, given as
+// [path]:[policy].
+func parsePathRetentionPolicies(values []string) ([]knoxite.PathRetentionPolicy, error) {
+	var policies []knoxite.PathRetentionPolicy
+	for _, v := range values {
+		i := strings.LastIndex(v, ":")
+		if i <= 0 {
+			return nil, i18n.Errorf("invalid path retention policy %s, expected [path]:[policy]", v)
+		}
+		policy, err := knoxite.ParseRetentionPolicy(v[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, knoxite.PathRetentionPolicy{Path: v[:i], Policy: policy})
+	}
+	return policies, nil
+}
+
+func executeSnapshotForget(volID string, opts SnapshotForgetOptions) error {
+	policy, err := knoxite.ParseRetentionPolicy(opts.Keep)
+	if err != nil {
+		return err
+	}
+	paths, err := parsePathRetentionPolicies(opts.KeepPaths)
 	if err != nil {
 		return err
 	}
+	if policy.Empty() && len(paths) == 0 {
+		return i18n.Errorf("forget needs a retention policy, use --keep or --keep-path")
+	}
 
+	// acquire a shutdown lock. we don't want these next calls to be interrupted
+	lock := shutdown.Lock()
+	if lock == nil {
+		return nil
+	}
+	defer lock()
+
+	repository, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+	if !opts.DryRun && !repository.IsAdmin() {
+		return knoxite.ErrAppendOnly
+	}
+	unlock, err := lockRepository(&repository, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	volume, err := repository.FindVolume(volID)
 	if err != nil {
 		return err
 	}
 
-	tab := gotable.NewTable([]string{"ID", "Date", "Original Size", "Storage Size", "Description"},
-		[]int64{-8, -19, 13, 12, -48}, "No snapshots found. This volume is empty.")
-	totalSize := uint64(0)
-	totalStorageSize := uint64(0)
+	// snapshots not carrying the tags are neither kept nor removed
+	filter := knoxite.SnapshotFilter{Tags: opts.Tags}
+	var snapshots []*knoxite.Snapshot
+	for _, id := range volume.Snapshots {
+		snapshot, err := volume.LoadSnapshot(id, &repository)
+		if err != nil {
+			return err
+		}
+		if filter.Matches(snapshot) {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+
+	plan := knoxite.PlanRetention(snapshots, policy, paths)
+	for _, snapshot := range plan.Remove {
+		log.Printf("Removing snapshot %s (%s)", snapshot.ID, snapshot.Date.Format(timeFormat))
+	}
+	for _, snapshot := range plan.Keep {
+		if prune := plan.Prune[snapshot.ID]; len(prune) > 0 {
+			log.Printf("Removing %d items from snapshot %s (%s)", len(prune), snapshot.ID, snapshot.Date.Format(timeFormat))
+		}
+	}
+	if opts.DryRun {
+		log.Printf("Would keep %d snapshots, remove %d and prune %d", len(plan.Keep), len(plan.Remove), len(plan.Prune))
+		return nil
+	}
+
+	chunkIndex, err := knoxite.OpenChunkIndex(&repository)
+	if err != nil {
+		return err
+	}
+	for _, snapshot := range plan.Remove {
+		if err := volume.RemoveSnapshot(snapshot.ID); err != nil {
+			return err
+		}
+		chunkIndex.RemoveSnapshot(snapshot.ID)
+	}
+	for _, snapshot := range plan.Keep {
+		prune := plan.Prune[snapshot.ID]
+		if len(prune) == 0 {
+			continue
+		}
+
+		for _, path := range prune {
+			snapshot.RemoveArchive(path)
+		}
+		if err := snapshot.Save(&repository); err != nil {
+			return err
+		}
+
+		chunkIndex.RemoveSnapshot(snapshot.ID)
+		for _, archive := range snapshot.Archives {
+			chunkIndex.AddArchive(archive, snapshot.ID)
+		}
+	}
+
+	if err := chunkIndex.Save(&repository); err != nil {
+		return err
+	}
+	if err := repository.Save(); err != nil {
+		return err
+	}
 
+	log.Printf("Kept %d snapshots, removed %d and pruned %d", len(plan.Keep), len(plan.Remove), len(plan.Prune))
+	log.Print(i18n.Sprintf("Do not forget to run 'repo pack' to delete un-referenced chunks and free up storage space!"))
+	return nil
+}
+
+// parseDate parses a date given on the command line in the local time zone.
+// Dates without a time refer to the start of the day, or its end if endOfDay
+// is set.
+func parseDate(s string, endOfDay bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(timeFormat, s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", s, time.Local)
+	if err != nil {
+		return t, i18n.Errorf("invalid date %s, expected YYYY-MM-DD or YYYY-MM-DD HH:MM:SS", s)
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, nil
+}
+
+func executeSnapshotList(volID string, opts SnapshotListOptions) error {
+	filter := knoxite.SnapshotFilter{
+		Search: opts.Search,
+		Tags:   opts.Tags,
+		Host:   opts.Host,
+	}
+	var err error
+	if filter.Annotations, err = parseAnnotations(opts.Annotations); err != nil {
+		return err
+	}
+	if filter.Since, err = parseDate(opts.Since, false); err != nil {
+		return err
+	}
+	if filter.Until, err = parseDate(opts.Until, true); err != nil {
+		return err
+	}
+
+	repository, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+
+	volume, err := repository.FindVolume(volID)
+	if err != nil {
+		return err
+	}
+
+	var snapshots []*knoxite.Snapshot
 	for _, snapshotID := range volume.Snapshots {
 		snapshot, err := volume.LoadSnapshot(snapshotID, &repository)
 		if err != nil {
 			return err
 		}
-		tab.AppendRow([]interface{}{
+		if filter.Matches(snapshot) {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+
+	if globalOpts.JSON {
+		type entry struct {
+			ID          string                `json:"id"`
+			Date        time.Time             `json:"date"`
+			Description string                `json:"description"`
+			Tags        []string              `json:"tags"`
+			Hostname    string                `json:"hostname"`
+			Username    string                `json:"username,omitempty"`
+			Paths       []string              `json:"paths,omitempty"`
+			CommandLine []string              `json:"command_line,omitempty"`
+			Stats       knoxite.Stats         `json:"stats"`
+			Parent      string                `json:"parent,omitempty"`
+			Baseline    string                `json:"baseline,omitempty"`
+			Annotations map[string]string     `json:"annotations,omitempty"`
+			Verified    *knoxite.Verification `json:"verified,omitempty"`
+		}
+		entries := []entry{}
+		for _, s := range snapshots {
+			e := entry{ID: s.ID, Date: s.Date, Description: s.Description, Tags: s.Tags, Hostname: s.Hostname, Username: s.Username, Paths: s.Paths, CommandLine: s.CommandLine, Stats: s.Stats, Parent: s.Parent, Baseline: s.Baseline, Annotations: s.Annotations}
+			if v, ok := repository.Verifications[s.ID]; ok {
+				e.Verified = &v
+			}
+			entries = append(entries, e)
+		}
+
+		return printJSON(entries)
+	}
+
+	headers := []string{"ID", "Date", "Original Size", "Storage Size", "Verified", "Origin", "Description"}
+	widths := []int64{-8, -19, 13, 12, -10, -20, -36}
+	if opts.Long {
+		headers = append(headers, "Paths", "Command Line")
+		widths = append(widths, -24, -24)
+	}
+	tab := gotable.NewTable(headers, widths, "No snapshots found. This volume is empty.")
+	totalSize := uint64(0)
+	totalStorageSize := uint64(0)
+
+	for _, snapshot := range snapshots {
+		description := snapshot.Description
+		if len(snapshot.Tags) > 0 {
+			description += " [" + strings.Join(snapshot.Tags, ", ") + "]"
+		}
+		if len(snapshot.Annotations) > 0 {
+			description += " {" + strings.Join(annotationPairs(snapshot.Annotations), ", ") + "}"
+		}
+		if snapshot.Parent != "" {
+			description += " " + i18n.Sprintf("(partial, based on %s)", snapshot.Parent)
+		}
+		verified := i18n.Sprintf("never")
+		if v, ok := repository.Verifications[snapshot.ID]; ok {
+			verified = v.Date.Format("2006-01-02")
+			if v.Errors > 0 {
+				verified = i18n.Sprintf("%d errors", v.Errors)
+			}
+		}
+		origin := snapshot.Hostname
+		if snapshot.Username != "" {
+			origin = snapshot.Username + "@" + origin
+		}
+		row := []interface{}{
 			snapshot.ID,
 			snapshot.Date.Format(timeFormat),
 			knoxite.SizeToString(snapshot.Stats.Size),
 			knoxite.SizeToString(snapshot.Stats.StorageSize),
-			snapshot.Description})
+			verified,
+			origin,
+			description}
+		if opts.Long {
+			row = append(row, strings.Join(snapshot.Paths, ", "), strings.Join(snapshot.CommandLine, " "))
+		}
+		tab.AppendRow(row)
 		totalSize += snapshot.Stats.Size
 		totalStorageSize += snapshot.Stats.StorageSize
 	}
 
-	tab.SetSummary([]interface{}{"", "", knoxite.SizeToString(totalSize), knoxite.SizeToString(totalStorageSize), ""})
+	summary := []interface{}{"", "", knoxite.SizeToString(totalSize), knoxite.SizeToString(totalStorageSize), "", "", ""}
+	if opts.Long {
+		summary = append(summary, "", "")
+	}
+	tab.SetSummary(summary)
 	_ = tab.Print()
 	return nil
 }
+
+// parseAnnotations parses the key[=value] pairs of annotations to filter by.
+func parseAnnotations(specs []string) (map[string]string, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	annotations := make(map[string]string)
+	for _, spec := range specs {
+		key, value, err := knoxite.ParseAnnotation(spec)
+		if err != nil {
+			return nil, i18n.Errorf("Invalid annotation %s: %v", spec, err)
+		}
+		annotations[key] = value
+	}
+	return annotations, nil
+}
+
+// annotationPairs returns the annotations as key=value pairs, sorted by key.
+func annotationPairs(annotations map[string]string) []string {
+	var pairs []string
+	for key, value := range annotations {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+func executeSnapshotAnnotate(snapshotID string, args []string, opts SnapshotAnnotateOptions) error {
+	annotations := make(map[string]string)
+	for _, arg := range args {
+		if !strings.Contains(arg, "=") {
+			return i18n.Errorf("Invalid annotation %s, expected key=value", arg)
+		}
+		key, value, err := knoxite.ParseAnnotation(arg)
+		if err != nil {
+			return i18n.Errorf("Invalid annotation %s: %v", arg, err)
+		}
+		annotations[key] = value
+	}
+
+	repository, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+	if len(annotations) == 0 && len(opts.Remove) == 0 {
+		_, snapshot, err := repository.FindSnapshot(snapshotID)
+		if err != nil {
+			return err
+		}
+		if globalOpts.JSON {
+			a := snapshot.Annotations
+			if a == nil {
+				a = map[string]string{}
+			}
+			return printJSON(a)
+		}
+		for _, pair := range annotationPairs(snapshot.Annotations) {
+			fmt.Println(pair)
+		}
+		return nil
+	}
+
+	unlock, err := lockRepository(&repository, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	_, snapshot, err := repository.FindSnapshot(snapshotID)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range opts.Remove {
+		if !snapshot.RemoveAnnotation(key) {
+			log.Warnf("Snapshot %s has no annotation %s", snapshot.ID, key)
+		}
+	}
+	for key, value := range annotations {
+		if err := snapshot.Annotate(key, value); err != nil {
+			return err
+		}
+	}
+
+	// acquire a shutdown lock. we don't want these next calls to be interrupted
+	lock := shutdown.Lock()
+	if lock == nil {
+		return nil
+	}
+	defer lock()
+	if err := snapshot.Save(&repository); err != nil {
+		return err
+	}
+	log.Printf("Annotated snapshot %s", snapshot.ID)
+	return nil
+}
@@ -0,0 +1,146 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/muesli/gotable"
+	"github.com/spf13/cobra"
+
+	"github.com/knoxite/knoxite"
+)
+
+var (
+	statsCmd = &cobra.Command{
+		Use:   "stats",
+		Short: "show how much data the repository stores",
+		Long: `The stats command shows the total size of all snapshots, how much storage their
+chunks take up after deduplication, compression and encryption, and how much
+of it is unique to each snapshot or shared with others. The statistics get
+computed from the chunk-index and the snapshots' metadata, no chunks get
+downloaded`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return executeStats()
+		},
+	}
+)
+
+func init() {
+	RootCmd.AddCommand(statsCmd)
+}
+
+// snapshotStats are the statistics of a single snapshot.
+type snapshotStats struct {
+	ID     string    `json:"id"`
+	Volume string    `json:"volume"`
+	Date   time.Time `json:"date"`
+	// Size is the snapshot's original size, unless its volume is locked
+	Size uint64 `json:"size"`
+	knoxite.SnapshotIndexStats
+}
+
+// repositoryStats are the statistics of a repository.
+type repositoryStats struct {
+	Snapshots    uint64          `json:"snapshots"`
+	Size         uint64          `json:"size"`
+	Chunks       uint64          `json:"chunks"`
+	StorageSize  uint64          `json:"stored_size"`
+	ParitySize   uint64          `json:"parity_size"`
+	Unreferenced uint64          `json:"unreferenced_size"`
+	PerSnapshot  []snapshotStats `json:"per_snapshot"`
+}
+
+// dedupRatio returns how much larger the snapshots are than the data stored
+// for them.
+func (s repositoryStats) dedupRatio() float64 {
+	referenced := s.StorageSize - s.Unreferenced
+	if referenced == 0 {
+		return 0
+	}
+	return float64(s.Size) / float64(referenced)
+}
+
+func executeStats() error {
+	repository, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+	index, err := knoxite.OpenChunkIndex(&repository)
+	if err != nil {
+		return err
+	}
+	is, err := index.Stats()
+	if err != nil {
+		return err
+	}
+
+	stats := repositoryStats{
+		Chunks:       is.Chunks,
+		StorageSize:  is.StorageSize,
+		ParitySize:   is.ParitySize,
+		Unreferenced: is.Unreferenced,
+		PerSnapshot:  []snapshotStats{},
+	}
+	for _, volume := range repository.Volumes {
+		for _, id := range volume.Snapshots {
+			s := snapshotStats{ID: id, Volume: volume.ID}
+			if ss, ok := is.Snapshots[id]; ok {
+				s.SnapshotIndexStats = *ss
+			}
+			snapshot, err := volume.LoadSnapshot(id, &repository)
+			switch {
+			case err == knoxite.ErrVolumeLocked:
+				log.Debugf("Skipping metadata of snapshot %s of locked volume %s", id, volume.ID)
+			case err != nil:
+				return err
+			default:
+				s.Date = snapshot.Date
+				s.Size = snapshot.Stats.Size
+			}
+
+			stats.Snapshots++
+			stats.Size += s.Size
+			stats.PerSnapshot = append(stats.PerSnapshot, s)
+		}
+	}
+
+	if globalOpts.JSON {
+		return printJSON(stats)
+	}
+
+	log.Printf("Snapshots:          %d", stats.Snapshots)
+	log.Printf("Original size:      %s", knoxite.SizeToString(stats.Size))
+	log.Printf("Chunks:             %d", stats.Chunks)
+	log.Printf("Stored size:        %s (deduplication & compression ratio %.2f)", knoxite.SizeToString(stats.StorageSize), stats.dedupRatio())
+	log.Printf("Parity overhead:    %s", knoxite.SizeToString(stats.ParitySize))
+	if stats.Unreferenced > 0 {
+		log.Printf("Unreferenced:       %s (released by 'repo pack')", knoxite.SizeToString(stats.Unreferenced))
+	}
+	log.Print("")
+
+	tab := gotable.NewTable([]string{"Snapshot", "Volume", "Date", "Original Size", "Chunks", "Unique Size", "Shared Size"},
+		[]int64{-8, -8, -19, 13, 8, 12, 12}, "No snapshots found.")
+	for _, s := range stats.PerSnapshot {
+		date := "-"
+		if !s.Date.IsZero() {
+			date = s.Date.Format(timeFormat)
+		}
+		tab.AppendRow([]interface{}{
+			s.ID,
+			s.Volume,
+			date,
+			knoxite.SizeToString(s.Size),
+			fmt.Sprintf("%d", s.Chunks),
+			knoxite.SizeToString(s.UniqueSize),
+			knoxite.SizeToString(s.SharedSize)})
+	}
+	_ = tab.Print()
+	return nil
+}
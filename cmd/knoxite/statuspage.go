@@ -0,0 +1,229 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/knoxite/knoxite"
+)
+
+// maxHistory is the amount of finished runs shown on the status page.
+const maxHistory = 20
+
+// jobProgress is the progress of the operation a daemon is running, as
+// reported by its progressUI.
+type jobProgress struct {
+	mut sync.Mutex
+
+	Alias       string         `json:"alias"`
+	Start       time.Time      `json:"start"`
+	TotalSize   uint64         `json:"total_size"`
+	Transferred uint64         `json:"transferred"`
+	TotalItems  uint64         `json:"total_items"`
+	Items       uint64         `json:"items"`
+	Speed       uint64         `json:"speed"`
+	Active      []itemProgress `json:"active"`
+}
+
+// itemProgress is the progress of an item currently being processed.
+type itemProgress struct {
+	Path        string `json:"path"`
+	Size        uint64 `json:"size"`
+	Transferred uint64 `json:"transferred"`
+}
+
+// daemonRun is a finished run of a job.
+type daemonRun struct {
+	Alias    string        `json:"alias"`
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// statusPage is what the status page shows.
+type statusPage struct {
+	Time    time.Time    `json:"time"`
+	Jobs    []daemonJob  `json:"jobs"`
+	Current *jobProgress `json:"current,omitempty"`
+	History []daemonRun  `json:"history"`
+}
+
+// currentProgress receives the progress of the operation running in the
+// daemon, if any. progressUIs created while it's set report to it.
+var currentProgress *jobProgress
+
+// publish reports the progress of the operation to currentProgress.
+func (ui *progressUI) publish() {
+	p := ui.status
+	if p == nil {
+		return
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	p.TotalSize = ui.totalSize
+	p.Transferred = ui.transferred
+	p.TotalItems = ui.totalItems
+	p.Items = ui.items
+	p.Speed = ui.speed()
+	p.Active = p.Active[:0]
+	for _, path := range ui.order {
+		bar := ui.active[path].bar
+		p.Active = append(p.Active, itemProgress{path, uint64(bar.Total), uint64(bar.Current)})
+	}
+}
+
+// copy returns a copy of the progress, which is safe to render.
+func (p *jobProgress) copy() *jobProgress {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	return &jobProgress{
+		Alias:       p.Alias,
+		Start:       p.Start,
+		TotalSize:   p.TotalSize,
+		Transferred: p.Transferred,
+		TotalItems:  p.TotalItems,
+		Items:       p.Items,
+		Speed:       p.Speed,
+		Active:      append([]itemProgress{}, p.Active...),
+	}
+}
+
+// statusPage returns the current state of the daemon.
+func (d *daemon) statusPage() statusPage {
+	s := statusPage{
+		Time: time.Now(),
+		Jobs: d.status(),
+	}
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+	if d.progress != nil {
+		s.Current = d.progress.copy()
+	}
+	// most recent first
+	for i := len(d.history) - 1; i >= 0; i-- {
+		s.History = append(s.History, d.history[i])
+	}
+	return s
+}
+
+// serveStatusPage serves a page showing the jobs of the daemon, the progress
+// of the current one and the outcome of the recent runs, in the background.
+func serveStatusPage(addr string, d *daemon) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := statusTemplate.Execute(w, d.statusPage()); err != nil {
+			log.Warnf("Rendering status page failed: %v", err)
+		}
+	})
+	mux.HandleFunc("/status.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(d.statusPage())
+	})
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			log.Warnf("Serving status page failed: %v", err)
+		}
+	}()
+	log.Printf("Serving status page on http://%s/", l.Addr())
+	return nil
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"size": knoxite.SizeToString,
+	"time": func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Format(timeFormat)
+	},
+	"since": func(t time.Time) string {
+		return time.Since(t).Round(time.Second).String()
+	},
+	"duration": func(d time.Duration) string {
+		return d.Round(time.Second).String()
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>knoxite</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { text-align: left; padding: 0.2em 1em 0.2em 0; }
+progress { width: 20em; }
+.error { color: #c00; }
+</style>
+</head>
+<body>
+<h1>knoxite</h1>
+
+<h2>Current job</h2>
+{{with .Current}}
+<p>Storing snapshot of <b>{{.Alias}}</b>, running for {{since .Start}}</p>
+<p>
+<progress max="{{.TotalSize}}" value="{{.Transferred}}"></progress>
+{{size .Transferred}} / {{size .TotalSize}} ({{.Items}} of {{.TotalItems}} items) {{size .Speed}}/s
+</p>
+<table>
+{{range .Active}}
+<tr><td>{{.Path}}</td><td><progress max="{{.Size}}" value="{{.Transferred}}"></progress></td><td>{{size .Transferred}} / {{size .Size}}</td></tr>
+{{end}}
+</table>
+{{else}}
+<p>Idle</p>
+{{end}}
+
+<h2>Jobs</h2>
+<table>
+<tr><th>Alias</th><th>Schedule</th><th>Last run</th><th>Result</th><th>Next run</th></tr>
+{{range .Jobs}}
+<tr>
+<td>{{.Alias}}</td><td>{{.Schedule}}</td><td>{{time .LastRun}}</td>
+<td{{if .LastError}} class="error"{{end}}>{{if .Running}}running{{else if .Queued}}queued{{else if .LastError}}{{.LastError}}{{else if not .LastRun.IsZero}}ok{{end}}</td>
+<td>{{time .Next}}</td>
+</tr>
+{{end}}
+</table>
+
+<h2>Recent runs</h2>
+{{if .History}}
+<table>
+<tr><th>Alias</th><th>Started</th><th>Duration</th><th>Result</th></tr>
+{{range .History}}
+<tr><td>{{.Alias}}</td><td>{{time .Start}}</td><td>{{duration .Duration}}</td><td{{if .Error}} class="error"{{end}}>{{if .Error}}{{.Error}}{{else}}ok{{end}}</td></tr>
+{{end}}
+</table>
+{{else}}
+<p>No runs yet</p>
+{{end}}
+
+<p><small>Updated {{time .Time}}</small></p>
+</body>
+</html>
+`))
@@ -0,0 +1,126 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	shutdown "github.com/klauspost/shutdown2"
+	"github.com/muesli/gotable"
+	"github.com/spf13/cobra"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+	"github.com/knoxite/knoxite/storage/storagebench"
+)
+
+// StorageBenchOptions holds all the options that can be set for the
+// 'storagebench' command.
+type StorageBenchOptions struct {
+	Workloads []string
+	Scale     float64
+	CSV       bool
+}
+
+var (
+	storageBenchOpts = StorageBenchOptions{}
+
+	storageBenchCmd = &cobra.Command{
+		Use:   "storagebench [URL]...",
+		Short: "benchmark storage backends",
+		Long: `The storagebench command runs standardized workloads against storage backends
+and reports comparable results: many small chunks, few huge chunks, a mix of
+both and the same workload with an increasing amount of concurrent operations.
+The chunks get deleted again afterwards`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return i18n.Errorf("storagebench needs the URL of at least one backend to benchmark")
+			}
+			return executeStorageBench(args, storageBenchOpts)
+		},
+	}
+)
+
+func init() {
+	storageBenchCmd.Flags().StringSliceVarP(&storageBenchOpts.Workloads, "workload", "w", nil, "workloads to run: small, huge, mixed, parallel-1, parallel-2, parallel-4, parallel-8, parallel-16 (default all)")
+	storageBenchCmd.Flags().Float64Var(&storageBenchOpts.Scale, "scale", 1, "multiplies the amount of chunks of all workloads, e.g. 0.1 for a quick run")
+	storageBenchCmd.Flags().BoolVar(&storageBenchOpts.CSV, "csv", false, "print the results as CSV")
+	RootCmd.AddCommand(storageBenchCmd)
+}
+
+func executeStorageBench(urls []string, opts StorageBenchOptions) error {
+	workloads := storagebench.Workloads(opts.Scale)
+	if len(opts.Workloads) > 0 {
+		var err error
+		if workloads, err = storagebench.FindWorkloads(workloads, opts.Workloads); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := shutdown.CancelCtx(context.Background())
+	defer cancel()
+
+	var results []storagebench.Result
+	for _, url := range urls {
+		backend, err := knoxite.BackendFromURL(url)
+		if err != nil {
+			return err
+		}
+		// benchmarks may run against existing repositories, too
+		if err := backend.InitRepository(); err != nil && err != knoxite.ErrRepositoryExists {
+			return err
+		}
+
+		for _, w := range workloads {
+			log.Infof("Running workload %s against %s", w.Name, backend.Location())
+			res, err := storagebench.Run(ctx, backend, w)
+			results = append(results, res...)
+			if err != nil {
+				_ = backend.Close()
+				return err
+			}
+		}
+		_ = backend.Close()
+	}
+
+	if globalOpts.JSON {
+		return printJSON(results)
+	}
+	if opts.CSV {
+		return storagebench.WriteCSV(os.Stdout, results)
+	}
+
+	tab := gotable.NewTable([]string{"Backend", "Workload", "Operation", "Parallel", "Throughput", "Ops/s", "p50", "p99", "Errors"},
+		[]int64{-32, -12, -9, 8, 12, 10, 10, 10, 6}, "No results.")
+	for _, r := range results {
+		tab.AppendRow([]interface{}{
+			truncate(r.Backend, 32),
+			r.Workload,
+			r.Operation,
+			fmt.Sprintf("%d", r.Parallelism),
+			knoxite.SizeToString(uint64(r.Throughput)) + "/s",
+			fmt.Sprintf("%.1f", r.OpsPerSecond),
+			r.LatencyP50.Round(time.Microsecond).String(),
+			r.LatencyP99.Round(time.Microsecond).String(),
+			fmt.Sprintf("%d", r.Errors),
+		})
+	}
+	_ = tab.Print()
+	return nil
+}
+
+// truncate shortens s to at most n characters.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return "…" + s[len(s)-n+1:]
+}
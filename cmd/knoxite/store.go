@@ -8,37 +8,75 @@
 package main
 
 import (
-	"errors"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
-	humanize "github.com/dustin/go-humanize"
 	shutdown "github.com/klauspost/shutdown2"
-	"github.com/muesli/goprogressbar"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 
 	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
 	"github.com/knoxite/knoxite/cmd/knoxite/utils"
 )
 
 // Error declarations.
 var (
-	ErrRedundancyAmount = errors.New("failure tolerance can't be equal or higher as the number of storage backends")
+	ErrRedundancyAmount = i18n.Errorf("failure tolerance can't be equal or higher as the number of storage backends")
+	ErrStoreAborted     = i18n.Errorf("storing the snapshot has been aborted")
 )
 
 // StoreOptions holds all the options that can be set for the 'store' command.
 type StoreOptions struct {
 	Description      string
+	Tags             []string
 	Compression      string
 	Encryption       string
 	FailureTolerance uint
 	Excludes         []string
+	ExcludeFiles     []string
+	ExcludeMarkers   []string
+	ExcludeCaches    bool
+	OneFileSystem    bool
+	FollowSymlinks   bool
 	Pedantic         bool
+	Concurrency      uint
+	DiskConcurrency  uint
+	Resume           bool
+	Parent           string
+	Watch            bool
+	QuietPeriod      time.Duration
+	Source           string
+	Stdin            bool
+	StdinFilename    string
+	AlternateStreams bool
+	Redact           []string
+	Limits           knoxite.ScanLimits
+	MetricsFile      string
+	DryRun           bool
+	NoChangeCache    bool
+	StripPrefix      string
+	Baseline         string
+	Processors       []string
+	ExcludeFlagged   bool
+
+	// PreHook, PostSuccessHook and PostFailureHook are commands run before
+	// and after storing the snapshot
+	PreHook         string
+	PostSuccessHook string
+	PostFailureHook string
 }
 
+// checkpointInterval is how often the progress of a store operation gets
+// written to its checkpoint.
+const checkpointInterval = time.Minute
+
 var (
 	storeOpts = StoreOptions{}
 
@@ -47,15 +85,40 @@ var (
 		Short: "store files/directories",
 		Long:  `The store command creates a snapshot of a file or directory`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			// fall back to the volume & paths configured for this alias
+			if rep, ok := cfg.Repositories[globalOpts.Alias]; ok && rep.Volume != "" {
+				if len(args) == 0 {
+					args = append([]string{rep.Volume}, rep.StorePaths...)
+				} else if _, err := os.Stat(args[0]); err == nil && !storeOpts.Stdin {
+					// only paths have been given
+					args = append([]string{rep.Volume}, args...)
+				}
+			}
 			if len(args) < 1 {
-				return fmt.Errorf("store needs to know which volume to create a snapshot in")
+				return i18n.Errorf("store needs to know which volume to create a snapshot in")
 			}
-			if len(args) < 2 {
-				return fmt.Errorf("store needs to know which files and/or directories to work on")
+			if storeOpts.Stdin {
+				if len(args) > 1 || storeOpts.Source != "" {
+					return i18n.Errorf("store can't read from stdin and store other files at the same time")
+				}
+			} else if len(args) < 2 {
+				return i18n.Errorf("store needs to know which files and/or directories to work on")
 			}
 
 			configureStoreOpts(cmd, &storeOpts)
-			return executeStore(args[0], args[1:], storeOpts)
+			if storeOpts.DryRun {
+				if storeOpts.Watch {
+					return i18n.Errorf("store can't watch the paths during a dry run")
+				}
+				return executeStore(args[0], args[1:], storeOpts)
+			}
+			if storeOpts.Watch {
+				return watchStore(args[0], args[1:], storeOpts)
+			}
+			if err := executeStore(args[0], args[1:], storeOpts); err != nil {
+				return err
+			}
+			return autoVerify(false)
 		},
 	}
 )
@@ -81,28 +144,146 @@ func configureStoreOpts(cmd *cobra.Command, opts *StoreOptions) {
 		if !cmd.Flags().Changed("pedantic") {
 			opts.Pedantic = rep.Pedantic
 		}
+		if !cmd.Flags().Changed("tag") {
+			opts.Tags = rep.Tags
+		}
+		if !cmd.Flags().Changed("redact") {
+			opts.Redact = rep.Redact
+		}
+		if !cmd.Flags().Changed("processor") {
+			opts.Processors = rep.Processors
+		}
+		if !cmd.Flags().Changed("exclude-flagged") {
+			opts.ExcludeFlagged = rep.ExcludeFlagged
+		}
+		if !cmd.Flags().Changed("metrics-file") {
+			opts.MetricsFile = rep.MetricsFile
+		}
+		if !cmd.Flags().Changed("pre") {
+			opts.PreHook = rep.PreHook
+		}
+		if !cmd.Flags().Changed("post-success") {
+			opts.PostSuccessHook = rep.PostSuccessHook
+		}
+		if !cmd.Flags().Changed("post-failure") {
+			opts.PostFailureHook = rep.PostFailureHook
+		}
+	}
+}
+
+// newRedactor returns a redactor for specs like "host", "host=strip",
+// "owner" or "path=alice", whose hashes are keyed with secret.
+func newRedactor(specs []string, secret string) (*knoxite.NameRedactor, error) {
+	r := &knoxite.NameRedactor{Secret: secret}
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, "=", 2)
+		switch {
+		case kv[0] == "host" && (len(kv) == 1 || kv[1] == "hash"):
+			r.Hostname = knoxite.RedactHash
+		case kv[0] == "host" && kv[1] == "strip":
+			r.Hostname = knoxite.RedactStrip
+		case kv[0] == "owner" && len(kv) == 1:
+			r.Owners = true
+		case kv[0] == "path" && len(kv) == 2 && kv[1] != "":
+			r.Components = append(r.Components, kv[1])
+		default:
+			return nil, i18n.Errorf("Invalid redaction %s, expected host[=strip], owner or path=[name]", spec)
+		}
 	}
+	return r, nil
 }
 
 func initStoreFlags(f func() *pflag.FlagSet, opts *StoreOptions) {
 	f().StringVarP(&opts.Description, "desc", "d", "", "a description or comment for this volume")
+	f().StringArrayVar(&opts.Tags, "tag", []string{}, "tag the snapshot, can be given multiple times")
 	f().StringVarP(&opts.Compression, "compression", "c", "", "compression algo to use: none (default), flate, gzip, lzma, zlib, zstd")
-	f().StringVarP(&opts.Encryption, "encryption", "e", "", "encryption algo to use: aes (default), none")
+	f().StringVarP(&opts.Encryption, "encryption", "e", "", "encryption algo to use: aes (default), aes-gcm, none")
 	f().UintVarP(&opts.FailureTolerance, "tolerance", "t", 0, "failure tolerance against n backend failures")
 	f().StringArrayVarP(&opts.Excludes, "excludes", "x", []string{}, "list of excludes")
+	f().StringArrayVar(&opts.ExcludeFiles, "exclude-file", []string{}, "read gitignore-style exclude patterns from a file")
+	f().StringArrayVar(&opts.ExcludeMarkers, "exclude-if-present", []string{}, "skip directories containing a file with this name")
+	f().BoolVar(&opts.ExcludeCaches, "exclude-caches", true, "skip directories containing a CACHEDIR.TAG file")
+	f().BoolVar(&opts.OneFileSystem, "one-file-system", false, "don't descend into directories on other file systems")
+	f().BoolVar(&opts.FollowSymlinks, "follow-symlinks", false, "store the files and directories symlinks point to instead of the links")
+	f().StringVar(&opts.StripPrefix, "strip-prefix", "", "remove this prefix from the stored paths, e.g. the mount point of a file system snapshot")
+	f().StringArrayVar(&opts.Processors, "processor", []string{}, "inspect every file with a command, given as name=command. It reads the file from stdin and exits with 1 to flag it, e.g. clamav='clamdscan --no-summary -'")
+	f().BoolVar(&opts.ExcludeFlagged, "exclude-flagged", false, "don't store files flagged by a processor")
 	f().BoolVar(&opts.Pedantic, "pedantic", false, "exit on first error")
+	f().UintVar(&opts.Concurrency, "concurrency", knoxite.DefaultConcurrency, "amount of chunks to process and upload in parallel")
+	f().UintVar(&opts.DiskConcurrency, "disk-concurrency", knoxite.DefaultDiskConcurrency, "amount of files to read in parallel from each spinning disk")
+	f().StringVar(&opts.Source, "source", "", "URL of the source to read the given paths from instead of the local file system")
+	f().BoolVar(&opts.AlternateStreams, "alternate-streams", false, "store the NTFS alternate data streams of files (Windows only)")
+	f().StringArrayVar(&opts.Redact, "redact", []string{}, "hide private metadata: host[=strip] hashes or strips the hostname, owner strips owner IDs, path=[name] hashes a path component")
+	f().BoolVar(&opts.Resume, "resume", true, "resume an interrupted snapshot of the same files/directories")
+	f().IntVar(&opts.Limits.MaxDepth, "max-depth", knoxite.DefaultScanLimits.MaxDepth, "skip entries nested deeper than this many directories")
+	f().IntVar(&opts.Limits.MaxNameLength, "max-name-length", knoxite.DefaultScanLimits.MaxNameLength, "skip entries with longer names (in bytes)")
+	f().IntVar(&opts.Limits.MaxPathLength, "max-path-length", knoxite.DefaultScanLimits.MaxPathLength, "skip entries with longer paths (in bytes)")
+	f().IntVar(&opts.Limits.MaxSymLinkLength, "max-symlink-length", knoxite.DefaultScanLimits.MaxSymLinkLength, "skip symlinks with longer targets (in bytes)")
 }
 
 func init() {
 	initStoreFlags(storeCmd.Flags, &storeOpts)
+	storeCmd.Flags().BoolVar(&storeOpts.DryRun, "dry-run", false, "only scan and chunk the files, report what would be stored without storing anything")
+	storeCmd.Flags().BoolVar(&storeOpts.NoChangeCache, "no-change-cache", false, "read all files, instead of reusing the chunks of files which haven't changed since the last snapshot")
+	storeCmd.Flags().StringVar(&storeOpts.Baseline, "baseline", "", "snapshot to detect changes against, or none (default: the latest snapshot of the volume storing the same paths)")
+	storeCmd.Flags().StringVar(&storeOpts.Parent, "parent", "", "only store the given paths and inherit everything else from this snapshot")
+	storeCmd.Flags().BoolVar(&storeOpts.Watch, "watch", false, "keep watching the paths and store the changes made to them")
+	storeCmd.Flags().DurationVar(&storeOpts.QuietPeriod, "quiet-period", 30*time.Second, "how long no changes need to be made before storing them with --watch")
+	storeCmd.Flags().BoolVar(&storeOpts.Stdin, "stdin", false, "store the data read from stdin as a single file")
+	storeCmd.Flags().StringVar(&storeOpts.StdinFilename, "stdin-filename", "stdin", "name of the file storing the data read from stdin")
+	storeCmd.Flags().StringVar(&storeOpts.MetricsFile, "metrics-file", "", "write Prometheus metrics of this backup to a file for the node exporter's textfile collector")
+	storeCmd.Flags().StringVar(&storeOpts.PreHook, "pre", "", "command to run before storing the snapshot, e.g. to dump a database. The snapshot gets aborted if it fails")
+	storeCmd.Flags().StringVar(&storeOpts.PostSuccessHook, "post-success", "", "command to run after the snapshot has been stored")
+	storeCmd.Flags().StringVar(&storeOpts.PostFailureHook, "post-failure", "", "command to run after storing the snapshot failed")
 	RootCmd.AddCommand(storeCmd)
 }
 
-func store(repository *knoxite.Repository, chunkIndex *knoxite.ChunkIndex, snapshot *knoxite.Snapshot, targets []string, opts StoreOptions) error {
-	// we want to be notified during the first phase of a shutdown
-	cancel := shutdown.First()
+// checkpointPath returns where the checkpoint for storing targets in a volume
+// gets kept.
+func checkpointPath(repoURL, volumeID string, targets []string) (string, error) {
+	dir, err := knoxite.StateDir()
+	if err != nil {
+		return "", err
+	}
 
-	wd, err := os.Getwd()
+	h := sha256.Sum256([]byte(strings.Join(append([]string{repoURL, volumeID}, targets...), "\x00")))
+	return filepath.Join(dir, "checkpoints", hex.EncodeToString(h[:16])), nil
+}
+
+// saveCheckpoint writes the snapshot's progress to path, unless path is empty.
+func saveCheckpoint(snapshot *knoxite.Snapshot, path string, repository *knoxite.Repository) bool {
+	if path == "" {
+		return false
+	}
+	if err := snapshot.SaveCheckpoint(path, repository); err != nil {
+		log.Printf("Writing checkpoint failed: %v", err)
+		return false
+	}
+	return true
+}
+
+func store(repository *knoxite.Repository, chunkIndex *knoxite.ChunkIndex, snapshot *knoxite.Snapshot, targets []string, checkpoint string, cache *knoxite.ChangeCache, baseline *knoxite.Snapshot, opts StoreOptions) error {
+	// cancel the store operation during the first phase of a shutdown
+	ctx, cancel := shutdown.CancelCtx(context.Background())
+	defer cancel()
+	// but don't let the shutdown complete before we wrote a checkpoint
+	stopped := make(chan struct{})
+	defer close(stopped)
+	if n := shutdown.FirstFn(func() { <-stopped }); n != nil {
+		defer n.Cancel()
+	}
+
+	// paths of other sources aren't relative to the working directory
+	var wd string
+	var source knoxite.Source
+	var err error
+	if opts.Stdin {
+		source = knoxite.NewSourceReader(opts.StdinFilename, os.Stdin)
+	} else if opts.Source == "" {
+		wd, err = os.Getwd()
+	} else if source, err = knoxite.SourceFromURL(opts.Source); err == nil {
+		defer source.Close()
+	}
 	if err != nil {
 		return err
 	}
@@ -118,89 +299,203 @@ func store(repository *knoxite.Repository, chunkIndex *knoxite.ChunkIndex, snaps
 	if err != nil {
 		return err
 	}
+	if encryption == knoxite.EncryptionAES {
+		// prefer authenticated encryption, unless older versions of knoxite
+		// need to be able to read the repository
+		encryption = repository.Encryption()
+	}
+	if encryption, err = repository.DataEncryption(encryption); err != nil {
+		return err
+	}
+	prefix, err := stripPrefix(opts)
+	if err != nil {
+		return err
+	}
+	processors, err := parseProcessors(opts.Processors)
+	if err != nil {
+		return err
+	}
+	if len(processors) > 0 && opts.Stdin {
+		return i18n.Errorf("store can't pass the data read from stdin through processors")
+	}
 
 	so := knoxite.StoreOptions{
-		CWD:         wd,
-		Paths:       targets,
-		Excludes:    opts.Excludes,
-		Compress:    compression,
-		Encrypt:     encryption,
-		Pedantic:    opts.Pedantic,
-		DataParts:   uint(len(repository.BackendManager().Backends) - int(opts.FailureTolerance)),
-		ParityParts: opts.FailureTolerance,
-	}
-
-	startTime := time.Now()
-	progress := snapshot.Add(*repository, chunkIndex, so)
-
-	fileProgressBar := &goprogressbar.ProgressBar{Width: 40}
-	overallProgressBar := &goprogressbar.ProgressBar{
-		Text:  fmt.Sprintf("%d of %d total", 0, 0),
-		Width: 60,
-		PrependTextFunc: func(p *goprogressbar.ProgressBar) string {
-			return fmt.Sprintf("%s/s",
-				knoxite.SizeToString(uint64(float64(p.Current)/time.Since(startTime).Seconds())))
-		},
+		CWD:              wd,
+		Paths:            targets,
+		Excludes:         opts.Excludes,
+		ExcludeFiles:     opts.ExcludeFiles,
+		ExcludeMarkers:   opts.ExcludeMarkers,
+		ExcludeCaches:    opts.ExcludeCaches,
+		OneFileSystem:    opts.OneFileSystem,
+		FollowSymlinks:   opts.FollowSymlinks,
+		Compress:         compression,
+		Encrypt:          encryption,
+		Pedantic:         opts.Pedantic,
+		DataParts:        uint(len(repository.BackendManager().Backends) - int(opts.FailureTolerance)),
+		ParityParts:      opts.FailureTolerance,
+		Chunker:          repository.ChunkerSettings(),
+		Concurrency:      opts.Concurrency,
+		DiskConcurrency:  opts.DiskConcurrency,
+		Limits:           opts.Limits,
+		Source:           source,
+		AlternateStreams: opts.AlternateStreams,
+		DryRun:           opts.DryRun,
+		Limiter:          jobLimiter,
+		ChangeCache:      cache,
+		StripPrefix:      prefix,
+		Baseline:         baseline,
+		Processors:       processors,
+		ExcludeFlagged:   opts.ExcludeFlagged,
 	}
 
-	pb := goprogressbar.MultiProgressBar{}
-	pb.AddProgressBar(fileProgressBar)
-	pb.AddProgressBar(overallProgressBar)
-	lastPath := ""
+	ui := newProgressUI()
+	so.Timings = ui.timings
+	progress := snapshot.Add(ctx, *repository, chunkIndex, so)
 
-	items := int64(1)
 	errs := make(map[string]error)
+	var skipped []error
+	lastCheckpoint := time.Now()
 	for p := range progress {
-		select {
-		case n := <-cancel:
-			fmt.Println("Aborting...")
-			close(n)
-			return nil
-
-		default:
-			if p.Error != nil {
-				if storeOpts.Pedantic {
-					fmt.Println()
-					return p.Error
-				}
-				errs[p.Path] = p.Error
-				snapshot.Stats.Errors++
-			}
-			if p.Path != lastPath && lastPath != "" {
-				items++
-				fmt.Println()
-			}
-			fileProgressBar.Total = int64(p.CurrentItemStats.Size)
-			fileProgressBar.Current = int64(p.CurrentItemStats.Transferred)
-			fileProgressBar.PrependText = fmt.Sprintf("%s  %s/s",
-				knoxite.SizeToString(uint64(fileProgressBar.Current)),
-				knoxite.SizeToString(p.TransferSpeed()))
-
-			overallProgressBar.Total = int64(p.TotalStatistics.Size)
-			overallProgressBar.Current = int64(p.TotalStatistics.Transferred)
-			overallProgressBar.Text = fmt.Sprintf("%s / %s (%s of %s)",
-				knoxite.SizeToString(uint64(overallProgressBar.Current)),
-				knoxite.SizeToString(uint64(overallProgressBar.Total)),
-				humanize.Comma(items),
-				humanize.Comma(int64(p.TotalStatistics.Files+p.TotalStatistics.Dirs+p.TotalStatistics.SymLinks)))
-
-			if p.Path != lastPath {
-				lastPath = p.Path
-				fileProgressBar.Text = p.Path
+		if knoxite.IsSkipped(p.Error) {
+			skipped = append(skipped, p.Error)
+		} else if p.Error != nil {
+			if storeOpts.Pedantic {
+				ui.Abort()
+				saveCheckpoint(snapshot, checkpoint, repository)
+				return p.Error
 			}
+			errs[p.Path] = p.Error
+			snapshot.Stats.Errors++
+		}
+
+		ui.SetTotal(p.TotalStatistics.Size,
+			p.TotalStatistics.Files+p.TotalStatistics.Dirs+p.TotalStatistics.SymLinks)
+		ui.Update(p)
 
-			pb.LazyPrint()
+		if time.Since(lastCheckpoint) >= checkpointInterval {
+			saveCheckpoint(snapshot, checkpoint, repository)
+			lastCheckpoint = time.Now()
+		}
+	}
+	if ctx.Err() != nil {
+		ui.Abort()
+		log.Print(i18n.Sprintf("Aborting..."))
+		if saveCheckpoint(snapshot, checkpoint, repository) {
+			log.Print(i18n.Sprintf("Run the same command again to resume storing this snapshot"))
 		}
+		return nil
+	}
+	ui.Finish(snapshot.Stats)
+	if opts.DryRun {
+		for file, err := range errs {
+			fmt.Fprintln(os.Stderr, i18n.Sprintf("'%s': failed to read: %v", file, err))
+		}
+		for _, err := range skipped {
+			log.Warnf("%v", err)
+		}
+		warnFlagged(snapshot)
+		return nil
 	}
 
-	fmt.Printf("\nSnapshot %s created: %s\n", snapshot.ID, snapshot.Stats.String())
+	if globalOpts.JSON {
+		printEvent(struct {
+			Event string `json:"event"`
+			ID    string `json:"id"`
+		}{"snapshot", snapshot.ID})
+	}
+	log.Printf("Snapshot %s created", snapshot.ID)
 	for file, err := range errs {
-		fmt.Printf("'%s': failed to store: %v\n", file, err)
+		fmt.Fprintln(os.Stderr, i18n.Sprintf("'%s': failed to store: %v", file, err))
+	}
+	for _, err := range skipped {
+		log.Warnf("%v", err)
 	}
+	warnFlagged(snapshot)
 	return nil
 }
 
-func executeStore(volumeID string, args []string, opts StoreOptions) error {
+// warnFlagged reports the files of a snapshot, which got flagged by a
+// processor.
+func warnFlagged(snapshot *knoxite.Snapshot) {
+	for _, archive := range snapshot.Flagged() {
+		for name, finding := range archive.Flags {
+			log.Warnf("'%s': flagged by %s: %s", archive.Path, name, finding)
+		}
+	}
+}
+
+// commandLine returns args with the values of password flags and the
+// passwords of URLs removed, so it can be recorded in a snapshot.
+func commandLine(args []string) []string {
+	secret := map[string]bool{"--password": true, "--volume-password": true}
+	var cl []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if flag := strings.SplitN(arg, "=", 2); secret[flag[0]] {
+			cl = append(cl, flag[0]+"=xxxxx")
+			if len(flag) == 1 {
+				// the value is the next argument
+				i++
+			}
+			continue
+		}
+		cl = append(cl, knoxite.RedactURL(arg))
+	}
+	return cl
+}
+
+// stripPrefix returns the absolute prefix to remove from the stored paths.
+// Prefixes of local paths may be relative to the working directory.
+func stripPrefix(opts StoreOptions) (string, error) {
+	if opts.StripPrefix == "" {
+		return "", nil
+	}
+	if filepath.IsAbs(opts.StripPrefix) {
+		return filepath.Clean(opts.StripPrefix), nil
+	}
+	if opts.Source != "" || opts.Stdin {
+		return "", i18n.Errorf("--strip-prefix needs to be an absolute path")
+	}
+	return filepath.Abs(opts.StripPrefix)
+}
+
+// findBaseline returns the snapshot to detect the changes made since with, the
+// one given with --baseline or the latest snapshot of the volume storing the
+// same paths as so. It returns nil if there's none.
+func findBaseline(repository *knoxite.Repository, volume *knoxite.Volume, so knoxite.StoreOptions, opts StoreOptions) (*knoxite.Snapshot, error) {
+	switch opts.Baseline {
+	case "none":
+		return nil, nil
+	case "":
+		// data read from stdin can't be recognized as unchanged
+		if opts.Stdin {
+			return nil, nil
+		}
+		return volume.FindBaseline(repository, so)
+	}
+
+	snapshot, err := volume.LoadSnapshot(opts.Baseline, repository)
+	if err != nil {
+		return nil, i18n.Errorf("Loading baseline snapshot %s failed: %v", opts.Baseline, err)
+	}
+	return snapshot.Merged(repository)
+}
+
+// storeTargets returns the directory relative paths are stored relative to,
+// and the paths to store. Paths of a source other than the local file system
+// get stored as they are.
+func storeTargets(args []string, opts StoreOptions) (string, []string, error) {
+	if opts.Stdin {
+		return "", []string{opts.StdinFilename}, nil
+	}
+	if opts.Source != "" {
+		return "", args, nil
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", nil, err
+	}
 	targets := []string{}
 	for _, target := range args {
 		if absTarget, err := filepath.Abs(target); err == nil {
@@ -208,54 +503,203 @@ func executeStore(volumeID string, args []string, opts StoreOptions) error {
 		}
 		targets = append(targets, target)
 	}
+	return wd, targets, nil
+}
+
+func executeStore(volumeID string, args []string, opts StoreOptions) error {
+	_, err := storeSnapshot(volumeID, args, opts)
+	return err
+}
+
+// storeSnapshot stores a snapshot of the given paths in a volume and returns
+// it.
+func storeSnapshot(volumeID string, args []string, opts StoreOptions) (snapshot *knoxite.Snapshot, err error) {
+	var newChunks int
+	var saved bool
+	// hooks get the ID of the volume, once it has been found
+	hookVolume := volumeID
+	start := time.Now()
+	if opts.DryRun {
+		// nothing gets stored, so there's nothing to report or to prepare
+		opts.MetricsFile = ""
+		opts.PreHook, opts.PostSuccessHook, opts.PostFailureHook = "", "", ""
+	}
+	if opts.MetricsFile != "" {
+		defer func() {
+			writeBackupMetrics(opts.MetricsFile, volumeID, snapshot, newChunks, start, err)
+		}()
+	}
+	defer func() {
+		if err == nil && saved {
+			err = runHook(hookPostSuccess, opts.PostSuccessHook, hookVolume, snapshot, start, nil)
+			return
+		}
+		failure := err
+		if failure == nil {
+			failure = ErrStoreAborted
+		}
+		if herr := runHook(hookPostFailure, opts.PostFailureHook, hookVolume, snapshot, start, failure); herr != nil {
+			log.Warn(herr)
+		}
+	}()
+	if err := runHook(hookPre, opts.PreHook, volumeID, nil, start, nil); err != nil {
+		return snapshot, err
+	}
+
+	wd, targets, err := storeTargets(args, opts)
+	if err != nil {
+		return snapshot, err
+	}
 
 	// acquire a shutdown lock. we don't want these next calls to be interrupted
 	lock := shutdown.Lock()
 	if lock == nil {
-		return nil
+		return snapshot, nil
 	}
 	repository, err := openRepository(globalOpts.Repo, globalOpts.Password)
 	if err != nil {
-		return err
+		return snapshot, err
+	}
+	unlock, err := lockRepository(&repository, false)
+	if err != nil {
+		return snapshot, err
+	}
+	defer unlock()
+	if opts.DryRun {
+		// make sure nothing gets written to the repository
+		repository.BackendManager().ReadOnly = true
+	} else if len(repository.BackendManager().Debts()) > 0 {
+		// backends, which missed data during a previous run, shouldn't fall
+		// behind any further
+		if err := healRepository(&repository); err != nil {
+			log.Warn(err)
+		}
 	}
 	volume, err := repository.FindVolume(volumeID)
 	if err != nil {
-		return err
+		return snapshot, err
+	}
+	hookVolume = volume.ID
+	if err := unlockVolume(volume); err != nil {
+		return snapshot, err
 	}
-	snapshot, err := knoxite.NewSnapshot(opts.Description)
+	key := targets
+	if opts.Source != "" {
+		key = append([]string{opts.Source}, targets...)
+	}
+	checkpoint, err := checkpointPath(globalOpts.Repo, volume.ID, key)
 	if err != nil {
-		return err
+		return snapshot, err
+	}
+	if opts.Stdin || opts.DryRun {
+		// data read from stdin can't be read again to resume a snapshot
+		checkpoint = ""
+		opts.Resume = false
+	}
+	redactor, err := newRedactor(opts.Redact, repository.Key)
+	if err != nil {
+		return snapshot, err
+	}
+	snapshot, err = knoxite.NewSnapshot(opts.Description)
+	if err != nil {
+		return snapshot, err
+	}
+	if opts.Resume {
+		if s, err := knoxite.LoadCheckpoint(checkpoint, &repository); err == nil {
+			log.Printf("Resuming interrupted snapshot %s", s.ID)
+			s.Description = opts.Description
+			snapshot = s
+		} else if !os.IsNotExist(err) {
+			log.Printf("Ignoring unreadable checkpoint: %v", err)
+		}
+	}
+	snapshot.Tags = opts.Tags
+	snapshot.CommandLine = commandLine(os.Args)
+	if err := volume.PrepareSnapshot(snapshot); err != nil {
+		return snapshot, err
 	}
 	chunkIndex, err := knoxite.OpenChunkIndex(&repository)
 	if err != nil {
-		return err
+		return snapshot, err
+	}
+	indexed := len(chunkIndex.Chunks)
+	prefix, err := stripPrefix(opts)
+	if err != nil {
+		return snapshot, err
+	}
+	if opts.Parent != "" {
+		_, parent, err := repository.FindSnapshot(opts.Parent)
+		if err != nil {
+			return snapshot, err
+		}
+		if err := snapshot.Inherit(&repository, parent, knoxite.StoreOptions{CWD: wd, Paths: targets, StripPrefix: prefix}, &chunkIndex); err != nil {
+			return snapshot, err
+		}
+	}
+	// files read from other sources can't be recognized as unchanged
+	var cache *knoxite.ChangeCache
+	if !opts.NoChangeCache && !opts.DryRun && !opts.Stdin && opts.Source == "" {
+		if cache, err = knoxite.OpenChangeCache(&repository, volume.ID); err != nil {
+			log.Warnf("Not using the change cache: %v", err)
+		}
+	}
+	baseline, err := findBaseline(&repository, volume, knoxite.StoreOptions{CWD: wd, Paths: targets, StripPrefix: prefix}, opts)
+	if err != nil {
+		return snapshot, err
 	}
 	// release the shutdown lock
 	lock()
 
-	err = store(&repository, &chunkIndex, snapshot, targets, opts)
+	err = store(&repository, &chunkIndex, snapshot, targets, checkpoint, cache, baseline, opts)
 	if err != nil {
-		return err
+		return snapshot, err
+	}
+	newChunks = len(chunkIndex.Chunks) - indexed
+	if baseline != nil {
+		if view, err := snapshot.Merged(&repository); err == nil {
+			c := knoxite.CountChanges(baseline, view)
+			log.Printf("Files since snapshot %s: %d new, %d changed, %d unmodified, %d removed",
+				baseline.ID, c.New, c.Changed, c.Unmodified, c.Removed)
+		}
+	}
+	if opts.DryRun {
+		log.Printf("Dry run: would store %d items in %d new chunks, taking %s of storage space",
+			len(snapshot.Archives), newChunks, knoxite.SizeToString(snapshot.Stats.StorageSize))
+		return snapshot, nil
 	}
 
 	// acquire another shutdown lock. we don't want these next calls to be interrupted
 	lock = shutdown.Lock()
 	if lock == nil {
-		return nil
+		return snapshot, nil
 	}
 	defer lock()
 
+	if len(opts.Redact) > 0 {
+		snapshot.Redact(redactor)
+	}
 	err = snapshot.Save(&repository)
 	if err != nil {
-		return err
+		return snapshot, err
 	}
 	err = volume.AddSnapshot(snapshot.ID)
 	if err != nil {
-		return err
+		return snapshot, err
 	}
-	err = chunkIndex.Save(&repository)
+	err = repository.Commit(&chunkIndex)
 	if err != nil {
-		return err
+		return snapshot, err
+	}
+	saved = true
+	if cache != nil {
+		if err := cache.Save(&repository); err != nil {
+			log.Warnf("Saving the change cache failed: %v", err)
+		}
+	}
+
+	// the snapshot is complete, there's nothing left to resume
+	if err = os.Remove(checkpoint); err != nil && !os.IsNotExist(err) {
+		log.Printf("Removing checkpoint failed: %v", err)
 	}
-	return repository.Save()
+	return snapshot, nil
 }
@@ -0,0 +1,175 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	shutdown "github.com/klauspost/shutdown2"
+	"github.com/spf13/cobra"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+)
+
+// RepoTierOptions holds all the options that can be set for the 'repo tier'
+// command.
+type RepoTierOptions struct {
+	MinAge uint
+	Tier   string
+	DryRun bool
+}
+
+var (
+	repoTierOpts = RepoTierOptions{}
+
+	repoTierCmd = &cobra.Command{
+		Use:   "tier",
+		Short: "move chunks of old snapshots to a cheaper storage tier",
+		Long: `The tier command moves all chunks, which are only referenced by snapshots older
+than --min-age days, to a cheaper storage tier of the backend, e.g. an S3 storage
+class like GLACIER. Chunks referenced by a more recent snapshot get moved back to
+the default tier. Run it regularly, e.g. after forgetting old snapshots.
+Restoring from cheaper tiers is usually slower and may incur retrieval costs`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return executeRepoTier(repoTierOpts)
+		},
+	}
+)
+
+func init() {
+	repoTierCmd.Flags().UintVar(&repoTierOpts.MinAge, "min-age", 90, "minimum age in days of the snapshots whose chunks get moved")
+	repoTierCmd.Flags().StringVar(&repoTierOpts.Tier, "tier", "", "storage tier to move the chunks to, e.g. GLACIER")
+	repoTierCmd.Flags().BoolVar(&repoTierOpts.DryRun, "dry-run", false, "only report which chunks would be moved")
+	repoCmd.AddCommand(repoTierCmd)
+}
+
+func executeRepoTier(opts RepoTierOptions) error {
+	r, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+	if !opts.DryRun && !r.IsAdmin() {
+		return knoxite.ErrAppendOnly
+	}
+	tiers := r.Tiers()
+	if len(tiers) == 0 {
+		return knoxite.ErrTieringUnsupported
+	}
+	if opts.Tier == "" {
+		return i18n.Errorf("Please specify a tier with --tier, supported tiers: %s", strings.Join(tiers, ", "))
+	}
+	unlock, err := lockRepository(&r, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	index, err := knoxite.OpenChunkIndex(&r)
+	if err != nil {
+		return err
+	}
+	policy := knoxite.TierPolicy{
+		MinAge: time.Duration(opts.MinAge) * 24 * time.Hour,
+		Tier:   opts.Tier,
+	}
+	moves, err := index.PlanTiering(&r, policy, time.Now())
+	if err != nil {
+		return err
+	}
+
+	sizes := make(map[string]uint64)
+	for _, move := range moves {
+		sizes[move.Tier] += uint64(move.Chunk.Size)
+	}
+	for tier, size := range sizes {
+		log.Printf("Moving %s of chunks to tier %s", knoxite.SizeToString(size), tier)
+	}
+	if opts.DryRun || len(moves) == 0 {
+		return nil
+	}
+
+	// moved chunks need to be recorded in the index, even if we get
+	// interrupted
+	ctx, cancel := shutdown.CancelCtx(context.Background())
+	defer cancel()
+	err = index.MoveChunks(ctx, &r, moves)
+	if serr := index.Save(&r); serr != nil {
+		return serr
+	}
+	if err != nil && err == ctx.Err() {
+		log.Print(i18n.Sprintf("Aborting, run tier again to resume"))
+		return nil
+	}
+	return err
+}
+
+// archivePollInterval is how often we check whether chunks have been
+// retrieved from archive tiers.
+const archivePollInterval = 5 * time.Minute
+
+// checkTieredChunks warns about archives stored in cheaper storage tiers. With
+// --wait-for-archive it retrieves chunks from archive tiers first, with
+// --hydrate it moves them back to the default tier.
+func checkTieredChunks(ctx context.Context, repository *knoxite.Repository, archives []*knoxite.Archive, opts RestoreOptions) error {
+	tiers := repository.Tiers()
+	if len(tiers) == 0 {
+		return nil
+	}
+	index, err := knoxite.OpenChunkIndex(repository)
+	if err != nil {
+		return err
+	}
+	tiered := index.TieredChunks(archives)
+	if len(tiered) == 0 {
+		return nil
+	}
+
+	var chunks []*knoxite.ChunkIndexItem
+	for tier, c := range tiered {
+		var size uint64
+		for _, chunk := range c {
+			size += uint64(chunk.Size)
+		}
+		chunks = append(chunks, c...)
+		log.Warnf("%s of chunks are stored in tier %s, restoring them may take longer and incur retrieval costs", knoxite.SizeToString(size), tier)
+	}
+	if !opts.WaitForArchive && !opts.Hydrate {
+		log.Warn(i18n.Sprintf("Use --wait-for-archive to retrieve chunks from archive tiers first, --hydrate to move them back to the default tier"))
+		return nil
+	}
+
+	if opts.WaitForArchive {
+		last := -1
+		err = knoxite.ThawChunks(ctx, repository, chunks, archivePollInterval, func(p knoxite.ThawProgress) {
+			if p.Ready != last {
+				log.Printf("Retrieving archived chunks: %d of %d available", p.Ready, p.Total)
+				last = p.Ready
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if !opts.Hydrate {
+		return nil
+	}
+
+	moves := make([]knoxite.TierMove, 0, len(chunks))
+	for _, chunk := range chunks {
+		moves = append(moves, knoxite.TierMove{Chunk: chunk, Tier: tiers[0]})
+	}
+	log.Printf("Moving %d chunks back to tier %s", len(moves), tiers[0])
+	err = index.MoveChunks(ctx, repository, moves)
+	if serr := index.Save(repository); serr != nil {
+		return serr
+	}
+	return err
+}
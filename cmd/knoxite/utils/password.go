@@ -0,0 +1,45 @@
+/*
+ * knoxite
+ *     Copyright (c) 2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package utils
+
+import (
+	"strings"
+
+	"github.com/nbutton23/zxcvbn-go"
+	"github.com/sethvargo/go-diceware/diceware"
+)
+
+const (
+	// MinPasswordScore is the minimum score a password needs to not be
+	// considered weak.
+	MinPasswordScore = 3
+
+	// PassphraseWords is the amount of words in a generated passphrase. Six
+	// diceware words give about 77 bits of entropy.
+	PassphraseWords = 6
+)
+
+// PasswordStrength estimates how hard it is to crack pw. It returns a score
+// between 0 (trivial to guess) and 4 (very hard to guess) and a human readable
+// estimate of the time needed to crack it. userInputs contains words specific
+// to the user, which should be penalized when used in the password.
+func PasswordStrength(pw string, userInputs ...string) (int, string) {
+	m := zxcvbn.PasswordStrength(pw, userInputs)
+	return m.Score, m.CrackTimeDisplay
+}
+
+// GeneratePassphrase returns a random passphrase made of PassphraseWords
+// diceware words.
+func GeneratePassphrase() (string, error) {
+	words, err := diceware.Generate(PassphraseWords)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(words, "-"), nil
+}
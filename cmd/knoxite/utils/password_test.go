@@ -0,0 +1,73 @@
+/*
+ * knoxite
+ *     Copyright (c) 2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPasswordStrength(t *testing.T) {
+	tests := []struct {
+		password   string
+		userInputs []string
+		weak       bool
+	}{
+		{"", nil, true},
+		{"password", nil, true},
+		{"123456", nil, true},
+		{"qwertyuiop", nil, true},
+		{"letmein1", nil, true},
+		{"correct-horse-battery-staple", nil, false},
+		{"vQ7#pL2!xZ9@mK4$", nil, false},
+		// words specific to the user weaken a password
+		{"muesli-knoxite-2020", nil, false},
+		{"muesli-knoxite-2020", []string{"muesli", "knoxite", "2020"}, true},
+	}
+
+	for _, tt := range tests {
+		score, crackTime := PasswordStrength(tt.password, tt.userInputs...)
+		if score < 0 || score > 4 {
+			t.Errorf("Score of %q out of range: %d", tt.password, score)
+		}
+		if weak := score < MinPasswordScore; weak != tt.weak {
+			t.Errorf("Expected password %q (user inputs %v) to be weak: %t, got score %d",
+				tt.password, tt.userInputs, tt.weak, score)
+		}
+		if crackTime == "" {
+			t.Errorf("Expected a crack time estimate for %q", tt.password)
+		}
+	}
+}
+
+func TestGeneratePassphrase(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		pw, err := GeneratePassphrase()
+		if err != nil {
+			t.Fatalf("Failed generating passphrase: %s", err)
+		}
+
+		words := strings.Split(pw, "-")
+		if len(words) != PassphraseWords {
+			t.Errorf("Expected %d words, got %d: %s", PassphraseWords, len(words), pw)
+		}
+		for _, w := range words {
+			if w == "" || strings.Trim(w, "abcdefghijklmnopqrstuvwxyz") != "" {
+				t.Errorf("Expected a lowercase diceware word, got %q in %s", w, pw)
+			}
+		}
+		if score, _ := PasswordStrength(pw); score < MinPasswordScore {
+			t.Errorf("Expected generated passphrase %s to be strong, got score %d", pw, score)
+		}
+		if seen[pw] {
+			t.Errorf("Passphrase %s has been generated twice", pw)
+		}
+		seen[pw] = true
+	}
+}
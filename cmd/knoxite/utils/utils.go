@@ -18,13 +18,14 @@ import (
 	"syscall"
 
 	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
 	"github.com/mitchellh/go-homedir"
-	"github.com/muesli/crunchy"
 	"golang.org/x/term"
 )
 
 var (
 	ErrPasswordMismatch   = errors.New("passwords did not match")
+	ErrPasswordWeak       = errors.New("password is too weak")
 	ErrEncryptionUnknown  = errors.New("unknown encryption format")
 	ErrCompressionUnknown = errors.New("unknown compression format")
 	ErrLogLevelUnknown    = errors.New("unknown log level")
@@ -34,7 +35,7 @@ func ReadPassword(prompt string) (string, error) {
 	var tty io.WriteCloser
 	tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0)
 	if err != nil {
-		tty = os.Stdout
+		tty = os.Stderr
 	} else {
 		defer tty.Close()
 	}
@@ -46,16 +47,29 @@ func ReadPassword(prompt string) (string, error) {
 	return string(buf), err
 }
 
+// ReadPasswordTwice asks the user for a new password and makes them confirm
+// it. Weak passwords are only accepted if the user insists on using them. If
+// the user doesn't enter a password, a strong passphrase gets generated.
+// Like the prompts, all messages get printed to stderr, so they don't mix with
+// the output of a command.
 func ReadPasswordTwice(prompt, promptConfirm string) (string, error) {
+	fmt.Fprintln(os.Stderr, i18n.Sprintf("Leave the password empty to generate a strong passphrase."))
 	pw, err := ReadPassword(prompt)
 	if err != nil {
 		return pw, err
 	}
 
-	crunchErr := crunchy.NewValidator().Check(pw)
-	if crunchErr != nil {
-		fmt.Printf("Password is considered unsafe: %v\n", crunchErr)
-		fmt.Printf("Are you sure you want to use this password (y/N)?: ")
+	if pw == "" {
+		pw, err = GeneratePassphrase()
+		if err != nil {
+			return pw, err
+		}
+
+		fmt.Fprintf(os.Stderr, "\n%s\n\n    %s\n\n", i18n.Sprintf("Your passphrase is:"), pw)
+		fmt.Fprintln(os.Stderr, i18n.Sprintf("Write it down and keep it in a safe place. Without it you won't be able to access your data!"))
+	} else if score, crackTime := PasswordStrength(pw); score < MinPasswordScore {
+		fmt.Fprintln(os.Stderr, i18n.Sprintf("Password is weak (estimated time to crack: %s).", crackTime))
+		fmt.Fprint(os.Stderr, i18n.Sprintf("Are you sure you want to use this password (y/N)?: "))
 		var buf string
 		_, err = fmt.Scan(&buf)
 		if err != nil {
@@ -65,7 +79,7 @@ func ReadPasswordTwice(prompt, promptConfirm string) (string, error) {
 		buf = strings.TrimSpace(buf)
 		buf = strings.ToLower(buf)
 		if buf != "y" {
-			return pw, crunchErr
+			return pw, ErrPasswordWeak
 		}
 	}
 
@@ -132,6 +146,8 @@ func EncryptionTypeFromString(s string) (uint16, error) {
 		fallthrough
 	case "aes":
 		return knoxite.EncryptionAES, nil
+	case "aes-gcm":
+		return knoxite.EncryptionAESGCM, nil
 	case "none":
 		return knoxite.EncryptionNone, nil
 	}
@@ -146,6 +162,8 @@ func EncryptionText(enum int) string {
 		return "none"
 	case knoxite.EncryptionAES:
 		return "AES"
+	case knoxite.EncryptionAESGCM:
+		return "AES-GCM"
 	}
 
 	return "unknown"
@@ -200,7 +218,9 @@ func LogLevelFromString(s string) (knoxite.LogLevel, error) {
 	switch strings.ToLower(s) {
 	case "fatal":
 		return knoxite.LogLevelFatal, nil
-	case "warning":
+	case "error":
+		return knoxite.LogLevelError, nil
+	case "warning", "warn":
 		return knoxite.LogLevelWarning, nil
 	case "print":
 		return knoxite.LogLevelPrint, nil
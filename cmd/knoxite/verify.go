@@ -9,10 +9,14 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"time"
 
+	shutdown "github.com/klauspost/shutdown2"
 	"github.com/knoxite/knoxite"
-	"github.com/muesli/goprogressbar"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 )
@@ -21,12 +25,35 @@ type VerifyOptions struct {
 	Percentage int
 }
 
+// verifyEvent is emitted in JSON mode once a verification is done.
+type verifyEvent struct {
+	Event     string                   `json:"event"`
+	Errors    int                      `json:"errors"`
+	Failures  []string                 `json:"failures"`
+	Integrity *knoxite.IntegrityReport `json:"integrity,omitempty"`
+}
+
+func printVerifyEvent(report *knoxite.IntegrityReport, errs []error) {
+	e := verifyEvent{Event: "verified", Errors: len(errs), Failures: []string{}, Integrity: report}
+	if report != nil {
+		e.Errors += report.Errors()
+	}
+	for _, err := range errs {
+		e.Failures = append(e.Failures, err.Error())
+	}
+	printEvent(e)
+}
+
 var (
 	verifyOpts = VerifyOptions{}
 
 	verifyCmd = &cobra.Command{
 		Use:   "verify [volume [snapshot]]",
 		Short: "verify a repo, volume or snapshot",
+		Long: `The verify command downloads and decrypts a sample of the stored chunks and
+checks their integrity. When verifying the whole repository, it also cross-checks
+the chunk-index and the stored chunks against the snapshots' metadata and
+reports missing, orphaned and unindexed chunks`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) == 0 {
 				return executeVerifyRepo(verifyOpts)
@@ -55,33 +82,131 @@ func executeVerifyRepo(opts VerifyOptions) error {
 		return err
 	}
 
-	progress, err := knoxite.VerifyRepo(repository, opts.Percentage)
+	errors, err := verifyRepository(&repository, opts.Percentage)
 	if err != nil {
 		return err
 	}
 
-	errors := verify(progress)
+	log.Printf("Verify repository done: %d errors", errors)
+	return nil
+}
+
+// verifyRepository cross-checks the metadata of a repository and the content
+// of a sample of its files. The outcome gets recorded in the repository,
+// unless the verification got interrupted.
+func verifyRepository(repository *knoxite.Repository, percentage int) (int, error) {
+	ctx, cancel := shutdown.CancelCtx(context.Background())
+	defer cancel()
 
-	fmt.Println()
-	fmt.Printf("Verify repository done: %d errors\n", len(errors))
+	index, err := knoxite.OpenChunkIndex(repository)
+	if err != nil {
+		return 0, err
+	}
+	report, err := knoxite.CheckIntegrity(ctx, repository, &index)
+	if err != nil {
+		return 0, err
+	}
+	if !globalOpts.JSON {
+		printIntegrityReport(report)
+	}
+
+	progress, err := knoxite.VerifyRepo(ctx, *repository, percentage)
+	if err != nil {
+		return 0, err
+	}
+
+	errs := verify(progress)
+	if globalOpts.JSON {
+		printVerifyEvent(report, errs)
+	}
+
+	errors := report.Errors() + len(errs)
+	if ctx.Err() != nil {
+		return errors, ctx.Err()
+	}
+
+	repository.RecordVerification(knoxite.Verification{
+		Date:       time.Now(),
+		Percentage: percentage,
+		Errors:     errors,
+	})
+	return errors, repository.Save()
+}
+
+// autoVerify verifies the repository after storing snapshots or forgetting
+// them, if the profile in use asks for it.
+func autoVerify(forgot bool) error {
+	rep, ok := cfg.Repositories[globalOpts.Alias]
+	if !ok || (forgot && !rep.VerifyForget) || (!forgot && rep.VerifyEvery == 0) {
+		return nil
+	}
+
+	repository, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+	if !forgot && repository.Unverified() < int(rep.VerifyEvery) {
+		return nil
+	}
+	if !repository.CanRead() {
+		log.Warn(i18n.Sprintf("Skipping verification, the repository's private key is required to read its data"))
+		return nil
+	}
+
+	log.Print(i18n.Sprintf("Verifying repository..."))
+	errors, err := verifyRepository(&repository, rep.VerifyPercent)
+	if err != nil {
+		return err
+	}
+	if errors > 0 {
+		return i18n.Errorf("Verify repository failed: %d errors", errors)
+	}
+
+	log.Print(i18n.Sprintf("Verify repository done: no errors"))
 	return nil
 }
 
+func printIntegrityReport(report *knoxite.IntegrityReport) {
+	for id, reason := range report.UnreadableSnapshots {
+		fmt.Fprintln(os.Stderr, i18n.Sprintf("Snapshot %s can't be read: %s", id, reason))
+	}
+	for _, hash := range report.MissingChunks {
+		fmt.Fprintln(os.Stderr, i18n.Sprintf("Chunk %s is missing", hash))
+	}
+	for _, chunk := range report.OrphanedChunks {
+		fmt.Fprintln(os.Stderr, i18n.Sprintf("Chunk %s isn't referenced by any snapshot", chunk))
+	}
+	for _, hash := range report.UnindexedChunks {
+		fmt.Fprintln(os.Stderr, i18n.Sprintf("Chunk %s is missing in the chunk-index", hash))
+	}
+	for _, hash := range report.StaleChunks {
+		fmt.Fprintln(os.Stderr, i18n.Sprintf("Chunk %s is indexed for snapshots that don't reference it", hash))
+	}
+	for _, location := range report.UnlistedBackends {
+		log.Warnf("Can't list the chunks stored in %s, skipped checking for missing & orphaned chunks", knoxite.RedactURL(location))
+	}
+}
+
 func executeVerifyVolume(volumeId string, opts VerifyOptions) error {
 	repository, err := openRepository(globalOpts.Repo, globalOpts.Password)
 	if err != nil {
 		return err
 	}
 
-	progress, err := knoxite.VerifyVolume(repository, volumeId, opts.Percentage)
+	ctx, cancel := shutdown.CancelCtx(context.Background())
+	defer cancel()
+
+	progress, err := knoxite.VerifyVolume(ctx, repository, volumeId, opts.Percentage)
 	if err != nil {
 		return err
 	}
 
 	errors := verify(progress)
+	if globalOpts.JSON {
+		printVerifyEvent(nil, errors)
+	}
 
-	fmt.Println()
-	fmt.Printf("Verify volume done: %d errors\n", len(errors))
+	log.Printf("Verify volume done: %d errors", len(errors))
 	return nil
 }
 
@@ -91,46 +216,41 @@ func executeVerifySnapshot(snapshotId string, opts VerifyOptions) error {
 		return err
 	}
 
-	progress, err := knoxite.VerifySnapshot(repository, snapshotId, opts.Percentage)
+	ctx, cancel := shutdown.CancelCtx(context.Background())
+	defer cancel()
+
+	progress, err := knoxite.VerifySnapshot(ctx, repository, snapshotId, opts.Percentage)
 	if err != nil {
 		return err
 	}
 
 	errors := verify(progress)
+	if globalOpts.JSON {
+		printVerifyEvent(nil, errors)
+	}
 
-	fmt.Println()
-	fmt.Printf("Verify snapshot done: %d errors\n", len(errors))
+	log.Printf("Verify snapshot done: %d errors", len(errors))
 	return nil
 }
 
 func verify(progress <-chan knoxite.Progress) []error {
 	var errors []error
 
-	pb := &goprogressbar.ProgressBar{Total: 1000, Width: 40}
-	lastPath := ""
-
+	ui := newProgressUI()
 	for p := range progress {
 		if p.Error != nil {
-			fmt.Println()
 			errors = append(errors, p.Error)
 		}
 
-		pb.Total = int64(p.CurrentItemStats.Size)
-		pb.Current = int64(p.CurrentItemStats.Transferred)
-		pb.PrependText = fmt.Sprintf("%s / %s",
-			knoxite.SizeToString(uint64(pb.Current)),
-			knoxite.SizeToString(uint64(pb.Total)))
-
-		if p.Path != lastPath {
-			// We have just started restoring a new item
-			if len(lastPath) > 0 {
-				fmt.Println()
-			}
-			lastPath = p.Path
-			pb.Text = p.Path
-		}
+		ui.Update(p)
+	}
+	ui.Close()
 
-		pb.LazyPrint()
+	if globalOpts.JSON {
+		return errors
+	}
+	for _, err := range errors {
+		fmt.Fprintln(os.Stderr, i18n.Sprintf("Verify failed: %v", err))
 	}
 
 	return errors
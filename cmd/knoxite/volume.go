@@ -9,18 +9,18 @@
 package main
 
 import (
-	"fmt"
-
 	shutdown "github.com/klauspost/shutdown2"
 	"github.com/muesli/gotable"
 	"github.com/spf13/cobra"
 
 	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
 )
 
 // VolumeInitOptions holds all the options that can be set for the 'volume init' command.
 type VolumeInitOptions struct {
 	Description string
+	Protect     bool
 }
 
 var (
@@ -35,12 +35,13 @@ var (
 	volumeInitCmd = &cobra.Command{
 		Use:   "init [name]",
 		Short: "initialize a new volume",
-		Long:  `The init command initializes a new volume`,
+		Long: `The init command initializes a new volume. Protected volumes get encrypted
+with their own key, which is wrapped by a separate password`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) != 1 {
-				return fmt.Errorf("init needs a name for the new volume")
+				return i18n.Errorf("init needs a name for the new volume")
 			}
-			return executeVolumeInit(args[0], volumeInitOpts.Description)
+			return executeVolumeInit(args[0], volumeInitOpts)
 		},
 	}
 	volumeRemoveCmd = &cobra.Command{
@@ -49,7 +50,7 @@ var (
 		Long:  `The remove command removes a volume from a repository`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) != 1 {
-				return fmt.Errorf("remove needs a volume to work on")
+				return i18n.Errorf("remove needs a volume to work on")
 			}
 			return executeVolumeRemove(args[0])
 		},
@@ -66,6 +67,7 @@ var (
 
 func init() {
 	volumeInitCmd.Flags().StringVarP(&volumeInitOpts.Description, "desc", "d", "", "a description or comment for this volume")
+	volumeInitCmd.Flags().BoolVar(&volumeInitOpts.Protect, "protect", false, "protect the volume by its own key and password")
 
 	volumeCmd.AddCommand(volumeInitCmd)
 	volumeCmd.AddCommand(volumeRemoveCmd)
@@ -73,7 +75,7 @@ func init() {
 	RootCmd.AddCommand(volumeCmd)
 }
 
-func executeVolumeInit(name, description string) error {
+func executeVolumeInit(name string, opts VolumeInitOptions) error {
 	// acquire a shutdown lock. we don't want these next calls to be interrupted
 	lock := shutdown.Lock()
 	if lock == nil {
@@ -86,21 +88,33 @@ func executeVolumeInit(name, description string) error {
 		return err
 	}
 
-	vol, err := knoxite.NewVolume(name, description)
+	vol, err := knoxite.NewVolume(name, opts.Description)
 	if err != nil {
 		return err
 	}
 
 	err = repository.AddVolume(vol)
 	if err != nil {
-		return fmt.Errorf("Creating volume %s failed: %v", name, err)
+		return i18n.Errorf("Creating volume %s failed: %v", name, err)
+	}
+	if opts.Protect {
+		password, err := readVolumePassword()
+		if err != nil {
+			return err
+		}
+		if _, err := repository.ProtectVolume(vol, password); err != nil {
+			return i18n.Errorf("Protecting volume %s failed: %v", name, err)
+		}
 	}
 
 	annotation := "Name: " + vol.Name
 	if len(vol.Description) > 0 {
 		annotation += ", Description: " + vol.Description
 	}
-	fmt.Printf("Volume %s (%s) created\n", vol.ID, annotation)
+	if vol.Protected() {
+		annotation += ", protected"
+	}
+	log.Printf("Volume %s (%s) created", vol.ID, annotation)
 	return repository.Save()
 }
 
@@ -109,6 +123,14 @@ func executeVolumeRemove(volumeID string) error {
 	if err != nil {
 		return err
 	}
+	if !repo.IsAdmin() {
+		return knoxite.ErrAppendOnly
+	}
+	unlock, err := lockRepository(&repo, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	chunkIndex, err := knoxite.OpenChunkIndex(&repo)
 	if err != nil {
@@ -140,8 +162,8 @@ func executeVolumeRemove(volumeID string) error {
 		return err
 	}
 
-	fmt.Printf("Volume %s '%s' successfully removed\n", vol.ID, vol.Name)
-	fmt.Println("Do not forget to run 'repo pack' to delete un-referenced chunks and free up storage space!")
+	log.Printf("Volume %s '%s' successfully removed", vol.ID, vol.Name)
+	log.Print(i18n.Sprintf("Do not forget to run 'repo pack' to delete un-referenced chunks and free up storage space!"))
 	return nil
 }
 
@@ -151,10 +173,22 @@ func executeVolumeList() error {
 		return err
 	}
 
-	tab := gotable.NewTable([]string{"ID", "Name", "Description"},
-		[]int64{-8, -32, -48}, "No volumes found. This repository is empty.")
+	if globalOpts.JSON {
+		volumes := repository.Volumes
+		if volumes == nil {
+			volumes = []*knoxite.Volume{}
+		}
+		return printJSON(volumes)
+	}
+
+	tab := gotable.NewTable([]string{"ID", "Name", "Description", "Protected"},
+		[]int64{-8, -32, -48, -9}, "No volumes found. This repository is empty.")
 	for _, volume := range repository.Volumes {
-		tab.AppendRow([]interface{}{volume.ID, volume.Name, volume.Description})
+		protected := ""
+		if volume.Protected() {
+			protected = "yes"
+		}
+		tab.AppendRow([]interface{}{volume.ID, volume.Name, volume.Description, protected})
 	}
 
 	_ = tab.Print()
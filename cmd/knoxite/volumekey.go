@@ -0,0 +1,185 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"github.com/muesli/gotable"
+	"github.com/spf13/cobra"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+	"github.com/knoxite/knoxite/cmd/knoxite/utils"
+)
+
+var (
+	volumeKeyCmd = &cobra.Command{
+		Use:   "key",
+		Short: "manage the passwords of a protected volume",
+		Long: `The key command manages the passwords, which grant access to a volume
+protected by its own key. Only operators knowing one of these passwords can
+store and restore the volume's snapshots, the repository's password alone
+isn't sufficient. Pass the volume's password with --volume-password or the
+KNOXITE_VOLUME_PASSWORD environment variable`,
+		RunE: nil,
+	}
+	volumeKeyListCmd = &cobra.Command{
+		Use:   "list [volume]",
+		Short: "list all keys of a protected volume",
+		Long:  `The list command lists all keys of a protected volume`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return i18n.Errorf("list needs a volume to work on")
+			}
+			return executeVolumeKeyList(args[0])
+		},
+	}
+	volumeKeyAddCmd = &cobra.Command{
+		Use:   "add [volume]",
+		Short: "add another password to a protected volume",
+		Long:  `The add command grants access to a protected volume with another password`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 1 {
+				return i18n.Errorf("add needs a volume to work on")
+			}
+			return executeVolumeKeyAdd(args[0])
+		},
+	}
+	volumeKeyRemoveCmd = &cobra.Command{
+		Use:   "remove [volume] [key ID]",
+		Short: "revoke a password of a protected volume",
+		Long: `The remove command revokes access to a protected volume for a key. The key
+used to unlock the volume can't be removed, use another password instead`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 {
+				return i18n.Errorf("remove needs a volume and the ID of a key to be removed")
+			}
+			return executeVolumeKeyRemove(args[0], args[1])
+		},
+	}
+)
+
+func init() {
+	volumeKeyCmd.AddCommand(volumeKeyListCmd)
+	volumeKeyCmd.AddCommand(volumeKeyAddCmd)
+	volumeKeyCmd.AddCommand(volumeKeyRemoveCmd)
+	volumeCmd.AddCommand(volumeKeyCmd)
+}
+
+// unlockVolumes unlocks all protected volumes of a repository, which password
+// grants access to.
+func unlockVolumes(r *knoxite.Repository, password string) {
+	for _, vol := range r.Volumes {
+		if vol.Protected() && vol.Unlock(password) == nil {
+			log.Debugf("Unlocked volume %s", vol.ID)
+		}
+	}
+}
+
+// unlockVolume asks for the password of a protected volume, unless it has been
+// unlocked already.
+func unlockVolume(vol *knoxite.Volume) error {
+	if !vol.Protected() || vol.Unlocked() {
+		return nil
+	}
+	if globalOpts.VolumePassword != "" {
+		return knoxite.ErrOpenVolumeFailed
+	}
+
+	password, err := utils.ReadPassword(i18n.Sprintf("Enter password of volume %s:", vol.Name))
+	if err != nil {
+		return err
+	}
+	return vol.Unlock(password)
+}
+
+// readVolumePassword returns the password for a new key of a protected volume.
+func readVolumePassword() (string, error) {
+	if globalOpts.VolumePassword != "" {
+		return globalOpts.VolumePassword, nil
+	}
+	return utils.ReadPasswordTwice(i18n.Sprintf("Enter a password to protect this volume with:"), i18n.Sprintf("Confirm password:"))
+}
+
+func executeVolumeKeyList(volumeID string) error {
+	r, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+	vol, err := r.FindVolume(volumeID)
+	if err != nil {
+		return err
+	}
+
+	if globalOpts.JSON {
+		keys := vol.Keys
+		if keys == nil {
+			keys = []knoxite.KeyRecord{}
+		}
+		return printJSON(keys)
+	}
+
+	tab := gotable.NewTable([]string{"", "ID", "Created", "Hostname", "User"},
+		[]int64{-1, -8, -19, -24, -16}, "No keys found. This volume is not protected by its own key.")
+	for _, k := range vol.Keys {
+		current := ""
+		if k.ID == vol.CurrentKey() {
+			current = "*"
+		}
+		tab.AppendRow([]interface{}{current, k.ID, k.Created.Format(timeFormat), k.Hostname, k.Username})
+	}
+
+	_ = tab.Print()
+	return nil
+}
+
+func executeVolumeKeyAdd(volumeID string) error {
+	r, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+	vol, err := r.FindVolume(volumeID)
+	if err != nil {
+		return err
+	}
+	if err := unlockVolume(vol); err != nil {
+		return err
+	}
+
+	password, err := utils.ReadPasswordTwice(i18n.Sprintf("Enter new password:"), i18n.Sprintf("Confirm password:"))
+	if err != nil {
+		return err
+	}
+	k, err := r.AddVolumeKey(vol, password)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Added key %s to volume %s", k.ID, vol.ID)
+	return nil
+}
+
+func executeVolumeKeyRemove(volumeID, id string) error {
+	r, err := openRepository(globalOpts.Repo, globalOpts.Password)
+	if err != nil {
+		return err
+	}
+	vol, err := r.FindVolume(volumeID)
+	if err != nil {
+		return err
+	}
+
+	if err := unlockVolume(vol); err != nil {
+		return err
+	}
+	if err := r.RemoveVolumeKey(vol, id); err != nil {
+		return err
+	}
+
+	log.Printf("Removed key %s from volume %s", id, vol.ID)
+	return nil
+}
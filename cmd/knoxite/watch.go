@@ -0,0 +1,254 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package main
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	shutdown "github.com/klauspost/shutdown2"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/cmd/knoxite/i18n"
+)
+
+// watcher collects the changes made to the paths being backed up.
+type watcher struct {
+	*fsnotify.Watcher
+
+	targets []string
+	ignored []string
+	changed map[string]bool
+}
+
+// newWatcher watches all directories within targets. Changes within the
+// ignored paths, e.g. knoxite's own files, don't get collected.
+func newWatcher(targets, ignored []string) (*watcher, error) {
+	fw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &watcher{
+		Watcher: fw,
+		targets: targets,
+		ignored: ignored,
+		changed: make(map[string]bool),
+	}
+	for _, target := range targets {
+		if err := w.add(target); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+// add watches path and all directories below it.
+func (w *watcher) add(path string) error {
+	return filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// vanished in the meantime
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if w.isIgnored(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && path != w.target(path) {
+			// files get watched by their directory
+			return nil
+		}
+		return w.Add(path)
+	})
+}
+
+// isIgnored returns whether path is within one of the ignored paths.
+func (w *watcher) isIgnored(path string) bool {
+	for _, p := range w.ignored {
+		if path == p || strings.HasPrefix(path, p+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// target returns the target path is stored as part of.
+func (w *watcher) target(path string) string {
+	for _, t := range w.targets {
+		if path == t || strings.HasPrefix(path, t+string(os.PathSeparator)) {
+			return t
+		}
+	}
+	return ""
+}
+
+// handle records the directory affected by an event. It returns false for
+// events that don't require a new snapshot.
+func (w *watcher) handle(ev fsnotify.Event) bool {
+	target := w.target(ev.Name)
+	if target == "" || w.isIgnored(ev.Name) {
+		return false
+	}
+
+	if ev.Op&fsnotify.Create != 0 {
+		if fi, err := os.Lstat(ev.Name); err == nil && fi.IsDir() {
+			if err := w.add(ev.Name); err != nil {
+				log.Warnf("Watching %s failed: %v", ev.Name, err)
+			}
+		}
+	}
+
+	// storing the directory again picks up added, modified and removed
+	// entries alike
+	dir := ev.Name
+	if dir != target {
+		dir = filepath.Dir(dir)
+	}
+	w.changed[dir] = true
+	return true
+}
+
+// paths returns the changed directories, leaving out the ones within other
+// changed directories, and resets them.
+func (w *watcher) paths() []string {
+	var dirs []string
+	for dir := range w.changed {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	var paths []string
+	for _, dir := range dirs {
+		if len(paths) > 0 {
+			last := paths[len(paths)-1]
+			if dir == last || strings.HasPrefix(dir, last+string(os.PathSeparator)) {
+				continue
+			}
+		}
+		paths = append(paths, dir)
+	}
+	w.changed = make(map[string]bool)
+	return paths
+}
+
+// ignoredPaths returns the local paths knoxite writes to while storing a
+// snapshot, which mustn't trigger another one.
+func ignoredPaths() []string {
+	var ignored []string
+	if dir, err := knoxite.StateDir(); err == nil {
+		ignored = append(ignored, dir)
+	}
+	if u, err := url.Parse(globalOpts.Repo); err == nil && (u.Scheme == "" || u.Scheme == "file") {
+		if path, err := filepath.Abs(u.Path); err == nil {
+			ignored = append(ignored, path)
+		}
+	}
+	return ignored
+}
+
+// watchStore stores a snapshot of the given paths, then watches them for
+// changes. Once nothing changed for the quiet period, a partial snapshot of
+// the changed directories gets stored, which inherits everything else from the
+// previous snapshot.
+func watchStore(volumeID string, args []string, opts StoreOptions) error {
+	if opts.Stdin || opts.Source != "" {
+		return i18n.Errorf("--watch only works with paths of the local file system")
+	}
+	if opts.QuietPeriod <= 0 {
+		return i18n.Errorf("The quiet period needs to be positive")
+	}
+	_, targets, err := storeTargets(args, opts)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := shutdown.CancelCtx(context.Background())
+	defer cancel()
+
+	// start watching first, so changes made while storing the initial
+	// snapshot don't get missed
+	w, err := newWatcher(targets, ignoredPaths())
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	snapshot, err := storeSnapshot(volumeID, targets, opts)
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil || snapshot == nil {
+		// interrupted
+		return nil
+	}
+	parent := snapshot.ID
+	log.Printf("Watching %s for changes", strings.Join(targets, ", "))
+
+	timer := time.NewTimer(opts.QuietPeriod)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case ev, ok := <-w.Events:
+			if !ok {
+				return nil
+			}
+			if w.handle(ev) {
+				log.Debugf("Changed: %s", ev.Name)
+				timer.Reset(opts.QuietPeriod)
+			}
+
+		case err, ok := <-w.Errors:
+			if !ok {
+				return nil
+			}
+			// events may have been dropped, fall back to storing everything
+			log.Warnf("Watching for changes failed: %v", err)
+			for _, target := range targets {
+				w.changed[target] = true
+			}
+			timer.Reset(opts.QuietPeriod)
+
+		case <-timer.C:
+			paths := w.paths()
+			if len(paths) == 0 {
+				continue
+			}
+			log.Printf("Storing changes of %s", strings.Join(paths, ", "))
+
+			opts.Parent = parent
+			snapshot, err := storeSnapshot(volumeID, paths, opts)
+			if ctx.Err() != nil || (err == nil && snapshot == nil) {
+				return nil
+			}
+			if err != nil {
+				// try again after the next quiet period
+				log.Warnf("Storing changes failed: %v", err)
+				for _, path := range paths {
+					w.changed[path] = true
+				}
+				timer.Reset(opts.QuietPeriod)
+				continue
+			}
+			parent = snapshot.ID
+		}
+	}
+}
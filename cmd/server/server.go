@@ -1,18 +1,32 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/knoxite/knoxite"
 )
 
 const storagePath = "/tmp/knoxite.storage"
 
+// appendOnly rejects all requests removing or overwriting chunks and
+// snapshots, so compromised clients can't destroy existing backups.
+var appendOnly = flag.Bool("append-only", false, "reject requests removing or overwriting chunks and snapshots")
+
+// referencesMut protects the chunk references of all snapshots.
+var referencesMut sync.Mutex
+
 func authPath(w http.ResponseWriter, r *http.Request) (string, error) {
 	auth, _, ok := r.BasicAuth()
 	if !ok {
@@ -70,7 +84,16 @@ func upload(w http.ResponseWriter, r *http.Request) {
 		defer file.Close()
 
 		fmt.Fprintf(w, "%v", handler.Header)
-		f, err := os.OpenFile(filepath.Join(path, "chunks", handler.Filename), os.O_WRONLY|os.O_CREATE, 0600)
+		flags := os.O_WRONLY | os.O_CREATE
+		if *appendOnly {
+			flags |= os.O_EXCL
+		}
+		f, err := os.OpenFile(filepath.Join(path, "chunks", handler.Filename), flags, 0600)
+		if os.IsExist(err) {
+			// chunks are addressed by their hash, the stored one is kept
+			fmt.Println("Kept existing chunk", filepath.Join(path, "chunks", handler.Filename))
+			return
+		}
 		if err != nil {
 			fmt.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -99,11 +122,161 @@ func download(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if r.Method == "GET" {
+	// HEAD requests let clients check whether a chunk is stored already
+	if r.Method == "GET" || r.Method == "HEAD" {
 		http.ServeFile(w, r, filepath.Join(path, "chunks", r.URL.Path[10:]))
 	}
 }
 
+// loadReferences returns the chunks referenced by a snapshot.
+func loadReferences(path, snapshot string) ([]knoxite.ChunkIndexItem, error) {
+	var chunks []knoxite.ChunkIndexItem
+	b, err := ioutil.ReadFile(filepath.Join(path, "references", snapshot))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return chunks, nil
+		}
+		return chunks, err
+	}
+
+	err = json.Unmarshal(b, &chunks)
+	return chunks, err
+}
+
+// references logic. The server maintains the chunk-index itself: clients tell
+// it which chunks their snapshots reference.
+func references(w http.ResponseWriter, r *http.Request) {
+	path, err := authPath(w, r)
+	if err != nil {
+		fmt.Println("ERROR:", err)
+		return
+	}
+
+	snapshot := filepath.Base(r.URL.Path[12:])
+	referencesMut.Lock()
+	defer referencesMut.Unlock()
+
+	switch r.Method {
+	case "POST":
+		fmt.Println("Receiving chunk references of snapshot", snapshot)
+
+		var added []knoxite.ChunkIndexItem
+		if err := json.NewDecoder(r.Body).Decode(&added); err != nil {
+			fmt.Println(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		chunks, err := loadReferences(path, snapshot)
+		if err != nil {
+			fmt.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		known := make(map[string]bool)
+		for _, chunk := range chunks {
+			known[chunk.Hash] = true
+		}
+		for _, chunk := range added {
+			if !known[chunk.Hash] {
+				known[chunk.Hash] = true
+				chunks = append(chunks, chunk)
+			}
+		}
+
+		b, err := json.Marshal(chunks)
+		if err == nil {
+			err = os.MkdirAll(filepath.Join(path, "references"), 0700)
+		}
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(path, "references", snapshot), b, 0600)
+		}
+		if err != nil {
+			fmt.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+	case "DELETE":
+		fmt.Println("Removing chunk references of snapshot", snapshot)
+		if *appendOnly {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		err := os.Remove(filepath.Join(path, "references", snapshot))
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// pack logic. Deletes all chunks no longer referenced by any snapshot.
+func pack(w http.ResponseWriter, r *http.Request) {
+	fmt.Println("Packing chunks")
+
+	path, err := authPath(w, r)
+	if err != nil {
+		fmt.Println("ERROR:", err)
+		return
+	}
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if *appendOnly {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	referencesMut.Lock()
+	defer referencesMut.Unlock()
+
+	referenced := make(map[string]bool)
+	snapshots, err := ioutil.ReadDir(filepath.Join(path, "references"))
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	for _, fi := range snapshots {
+		chunks, err := loadReferences(path, fi.Name())
+		if err != nil {
+			fmt.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		for _, chunk := range chunks {
+			referenced[chunk.Hash] = true
+		}
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(path, "chunks"))
+	if err != nil {
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var freed uint64
+	for _, fi := range files {
+		part, err := knoxite.ParseChunkPartName(fi.Name())
+		if err != nil || referenced[part.Hash] {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(path, "chunks", fi.Name())); err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Println("Deleted unreferenced chunk", fi.Name())
+		freed += uint64(fi.Size())
+	}
+
+	fmt.Fprintf(w, "%d", freed)
+}
+
 // uploadRepo logic.
 func uploadRepo(w http.ResponseWriter, r *http.Request) {
 	fmt.Println("Receiving repository")
@@ -129,17 +302,40 @@ func uploadRepo(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
+	// only replace the repository file once its new version has been
+	// written completely, so it never goes missing
+	name := filepath.Join(path, "repository.knoxite")
+	tmp := name + ".tmp"
 	fmt.Fprintf(w, "%v", handler.Header)
-	f, err := os.OpenFile(filepath.Join(path, "repository.knoxite"), os.O_WRONLY|os.O_CREATE, 0600)
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		fmt.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	defer f.Close()
-
 	_, err = io.Copy(f, file)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
 	if err != nil {
+		_ = os.Remove(tmp)
+		fmt.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if *appendOnly {
+		// keep all previous versions of the repository, so an admin can
+		// roll back changes made by a compromised client
+		err := os.Link(name, fmt.Sprintf("%s.%d", name, time.Now().UnixNano()))
+		if err != nil && !os.IsNotExist(err) {
+			_ = os.Remove(tmp)
+			fmt.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := os.Rename(tmp, name); err != nil {
 		fmt.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -195,14 +391,23 @@ func uploadSnapshot(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	fmt.Fprintf(w, "%v", handler.Header)
-	f, err := os.OpenFile(filepath.Join(path, "snapshots", handler.Filename), os.O_WRONLY|os.O_CREATE, 0600)
+	flags := os.O_WRONLY | os.O_CREATE
+	if *appendOnly {
+		flags |= os.O_EXCL
+	}
+	f, err := os.OpenFile(filepath.Join(path, "snapshots", handler.Filename), flags, 0600)
+	if os.IsExist(err) {
+		fmt.Println("Rejected overwriting snapshot", handler.Filename)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		fmt.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 	defer f.Close()
+	fmt.Fprintf(w, "%v", handler.Header)
 
 	_, err = io.Copy(f, file)
 	if err != nil {
@@ -228,11 +433,15 @@ func downloadSnapshot(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
+	flag.Parse()
+
 	http.HandleFunc("/upload", upload)
 	http.HandleFunc("/download/", download)
 	http.HandleFunc("/repository", repository)
 	http.HandleFunc("/snapshot", uploadSnapshot)
 	http.HandleFunc("/snapshot/", downloadSnapshot)
+	http.HandleFunc("/references/", references)
+	http.HandleFunc("/pack", pack)
 	err := http.ListenAndServe(":42024", nil) // setting listening port
 	if err != nil {
 		log.Fatal("ListenAndServe:", err)
@@ -0,0 +1,102 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"errors"
+	"time"
+)
+
+// CommitLockTimeout is how long Commit waits for other clients to finish
+// committing their snapshots.
+const CommitLockTimeout = 10 * time.Minute
+
+// Commit saves the chunk-index and the repository's metadata, after merging
+// them with the changes other clients saved since they got loaded. Clients
+// holding a shared lock may store snapshots at the same time, so they take
+// turns committing them.
+func (r *Repository) Commit(index *ChunkIndex) error {
+	l, err := r.lockCommit()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := r.Unlock(l); err != nil {
+			log.Warnf("Releasing the commit lock failed: %v", err)
+		}
+	}()
+
+	if err := index.Save(r); err != nil {
+		return err
+	}
+	if err := r.mergeVolumes(); err != nil {
+		return err
+	}
+	return r.Save()
+}
+
+// lockCommit waits until no other client commits, and takes the commit lock.
+// Clients holding an exclusive lock don't need it.
+func (r *Repository) lockCommit() (*Lock, error) {
+	if r.lock != nil && r.lock.exclusive {
+		return nil, nil
+	}
+
+	deadline := time.Now().Add(CommitLockTimeout)
+	delay := 100 * time.Millisecond
+	for {
+		l, err := r.storeLock(false, true)
+		var locked *LockedError
+		if !errors.As(err, &locked) || time.Now().After(deadline) {
+			return l, err
+		}
+		log.Debugf("Waiting for another client to commit: %v", err)
+
+		time.Sleep(delay)
+		if delay < 2*time.Second {
+			delay *= 2
+		}
+	}
+}
+
+// mergeVolumes adds the snapshots, which other clients added to the volumes
+// since the repository got loaded. Snapshots only get removed while holding
+// an exclusive lock, so the snapshots of both are kept.
+func (r *Repository) mergeVolumes() error {
+	b, err := r.backend.LoadRepository()
+	if err != nil {
+		return err
+	}
+	_, b, err = splitKeyRecords(b)
+	if err != nil {
+		return err
+	}
+	var stored Repository
+	if err := decodeMetadata(CompressionNone, r.password, b, &stored); err != nil {
+		return err
+	}
+
+	for _, sv := range stored.Volumes {
+		v, err := r.FindVolume(sv.ID)
+		if err != nil {
+			continue
+		}
+		known := make(map[string]bool)
+		for _, id := range sv.Snapshots {
+			known[id] = true
+		}
+		snapshots := append([]string{}, sv.Snapshots...)
+		for _, id := range v.Snapshots {
+			if !known[id] {
+				snapshots = append(snapshots, id)
+			}
+		}
+		v.Snapshots = snapshots
+	}
+	return nil
+}
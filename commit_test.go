@@ -0,0 +1,135 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConcurrentStores(t *testing.T) {
+	for _, version := range []uint{chunkIndexPacksVersion - 1, RepositoryVersion} {
+		src, err := ioutil.TempDir("", "knoxite.source")
+		if err != nil {
+			t.Fatalf("Failed creating temporary dir for source: %s", err)
+		}
+		defer os.RemoveAll(src)
+		dir, err := ioutil.TempDir("", "knoxite")
+		if err != nil {
+			t.Fatalf("Failed creating temporary dir for repository: %s", err)
+		}
+		defer os.RemoveAll(dir)
+
+		r, err := NewRepository(dir, "this_is_a_password")
+		if err != nil {
+			t.Fatalf("Failed creating repository: %s", err)
+		}
+		// older repositories store a single chunk-index
+		r.Version = version
+		r.ReaderVersion = version
+		vol, err := NewVolume("test", "")
+		if err != nil {
+			t.Fatalf("Failed creating volume: %s", err)
+		}
+		if err := r.AddVolume(vol); err != nil {
+			t.Fatalf("Failed creating volume: %s", err)
+		}
+		if err := r.Save(); err != nil {
+			t.Fatalf("Failed saving repository: %s", err)
+		}
+
+		// both clients open the repository before either of them commits
+		type client struct {
+			r        Repository
+			index    ChunkIndex
+			snapshot *Snapshot
+		}
+		var clients []*client
+		for _, name := range []string{"a", "b"} {
+			c := &client{}
+			if c.r, err = OpenRepository(dir, "this_is_a_password"); err != nil {
+				t.Fatalf("Failed opening repository: %s", err)
+			}
+			l, err := c.r.Lock(false)
+			if err != nil {
+				t.Fatalf("Failed locking repository: %s", err)
+			}
+			defer c.r.Unlock(l)
+			if c.index, err = OpenChunkIndex(&c.r); err != nil {
+				t.Fatalf("Failed opening chunk-index: %s", err)
+			}
+
+			if err := ioutil.WriteFile(filepath.Join(src, name), []byte("content of "+name), 0600); err != nil {
+				t.Fatalf("Failed creating file: %s", err)
+			}
+			if c.snapshot, err = NewSnapshot(name); err != nil {
+				t.Fatalf("Failed creating snapshot: %s", err)
+			}
+			opts := StoreOptions{
+				CWD:       src,
+				Paths:     []string{filepath.Join(src, name)},
+				Encrypt:   EncryptionAES,
+				DataParts: 1,
+			}
+			for p := range c.snapshot.Add(context.Background(), c.r, &c.index, opts) {
+				if p.Error != nil {
+					t.Fatalf("Failed adding to snapshot: %s", p.Error)
+				}
+			}
+			clients = append(clients, c)
+		}
+
+		for _, c := range clients {
+			if err := c.snapshot.Save(&c.r); err != nil {
+				t.Fatalf("Failed saving snapshot: %s", err)
+			}
+			v, err := c.r.FindVolume(vol.ID)
+			if err != nil {
+				t.Fatalf("Failed finding volume: %s", err)
+			}
+			if err := v.AddSnapshot(c.snapshot.ID); err != nil {
+				t.Fatalf("Failed adding snapshot to volume: %s", err)
+			}
+			if err := c.r.Commit(&c.index); err != nil {
+				t.Fatalf("Failed committing snapshot: %s", err)
+			}
+		}
+
+		r, err = OpenRepository(dir, "this_is_a_password")
+		if err != nil {
+			t.Fatalf("Failed opening repository: %s", err)
+		}
+		v, err := r.FindVolume(vol.ID)
+		if err != nil {
+			t.Fatalf("Failed finding volume: %s", err)
+		}
+		if len(v.Snapshots) != 2 {
+			t.Errorf("Expected both snapshots to be part of the volume, got %v", v.Snapshots)
+		}
+		index, err := OpenChunkIndex(&r)
+		if err != nil {
+			t.Fatalf("Failed opening chunk-index: %s", err)
+		}
+		for _, c := range clients {
+			for _, arc := range c.snapshot.Archives {
+				if arc.Type != File {
+					continue
+				}
+				if !index.hasChunks(arc.Chunks) {
+					t.Errorf("Expected the chunks of snapshot %s to be indexed (repository version %d)", c.snapshot.Description, version)
+				}
+			}
+		}
+		if locks, err := r.Locks(); err != nil || len(locks) != 2 {
+			t.Errorf("Expected the commit locks to be released, found %d locks: %v", len(locks), err)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"os"
+	"sort"
+)
+
+// CompareReport describes how the data stored on two backends diverges. A
+// and B refer to the first and second backend passed to CompareBackends.
+type CompareReport struct {
+	// Snapshots and Chunks are the amount of snapshots and parts of chunks
+	// stored on both backends
+	Snapshots int `json:"snapshots"`
+	Chunks    int `json:"chunks"`
+
+	SnapshotsOnlyA []string `json:"snapshots_only_a"`
+	SnapshotsOnlyB []string `json:"snapshots_only_b"`
+	// SnapshotsDiffer contains the IDs of snapshots stored on both backends,
+	// whose contents differ
+	SnapshotsDiffer []string `json:"snapshots_differ"`
+
+	// ChunksOnlyA, ChunksOnlyB and ChunksDiffer contain parts of chunks by
+	// the name they get stored with
+	ChunksOnlyA []string `json:"chunks_only_a"`
+	ChunksOnlyB []string `json:"chunks_only_b"`
+	// ChunksDiffer contains the parts of chunks stored on both backends,
+	// whose sizes differ
+	ChunksDiffer []string `json:"chunks_differ"`
+	// SizesCompared is false if one of the backends can't tell the size of
+	// a chunk without loading it
+	SizesCompared bool `json:"sizes_compared"`
+
+	RepositoryDiffers bool `json:"repository_differs"`
+	ChunkIndexDiffers bool `json:"chunk_index_differs"`
+}
+
+// Identical reports whether both backends store the same data.
+func (report *CompareReport) Identical() bool {
+	return len(report.SnapshotsOnlyA) == 0 && len(report.SnapshotsOnlyB) == 0 &&
+		len(report.SnapshotsDiffer) == 0 &&
+		len(report.ChunksOnlyA) == 0 && len(report.ChunksOnlyB) == 0 &&
+		len(report.ChunksDiffer) == 0 &&
+		!report.RepositoryDiffers && !report.ChunkIndexDiffers
+}
+
+// CompareBackends checks whether two backends, e.g. a repository and its
+// mirror, store the same snapshots and chunks. Snapshots and the repository's
+// metadata are compared by their checksums, chunks only by their names and
+// sizes, so none of them get downloaded.
+func CompareBackends(ctx context.Context, a, b Backend) (*CompareReport, error) {
+	listerA, ok := a.(BackendLister)
+	if !ok {
+		return nil, ErrListingUnsupported
+	}
+	listerB, ok := b.(BackendLister)
+	if !ok {
+		return nil, ErrListingUnsupported
+	}
+
+	report := &CompareReport{}
+	var err error
+	if report.RepositoryDiffers, err = differs(a.LoadRepository, b.LoadRepository); err != nil {
+		return nil, err
+	}
+	if report.ChunkIndexDiffers, err = differs(a.LoadChunkIndex, b.LoadChunkIndex); err != nil {
+		return nil, err
+	}
+
+	snapshotsA, err := listerA.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	snapshotsB, err := listerB.ListSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	var common []string
+	report.SnapshotsOnlyA, report.SnapshotsOnlyB, common = compareSets(snapshotsA, snapshotsB)
+	report.Snapshots = len(common)
+	for _, id := range common {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		d, err := differs(
+			func() ([]byte, error) { return a.LoadSnapshot(id) },
+			func() ([]byte, error) { return b.LoadSnapshot(id) })
+		if err != nil {
+			return nil, err
+		}
+		if d {
+			report.SnapshotsDiffer = append(report.SnapshotsDiffer, id)
+		}
+	}
+
+	chunksA, err := listerA.ListChunks()
+	if err != nil {
+		return nil, err
+	}
+	chunksB, err := listerB.ListChunks()
+	if err != nil {
+		return nil, err
+	}
+	parts := make(map[string]ChunkPart)
+	var namesA, namesB []string
+	for _, part := range chunksA {
+		parts[part.String()] = part
+		namesA = append(namesA, part.String())
+	}
+	for _, part := range chunksB {
+		namesB = append(namesB, part.String())
+	}
+	report.ChunksOnlyA, report.ChunksOnlyB, common = compareSets(namesA, namesB)
+	report.Chunks = len(common)
+
+	sizerA, okA := a.(ChunkSizer)
+	sizerB, okB := b.(ChunkSizer)
+	report.SizesCompared = okA && okB
+	if !report.SizesCompared {
+		return report, nil
+	}
+	for _, name := range common {
+		part := parts[name]
+		sizeA, err := sizerA.ChunkSize(ctx, part.Hash, part.Part, part.TotalParts)
+		if err != nil {
+			return nil, err
+		}
+		sizeB, err := sizerB.ChunkSize(ctx, part.Hash, part.Part, part.TotalParts)
+		if err != nil {
+			return nil, err
+		}
+		if sizeA != sizeB {
+			report.ChunksDiffer = append(report.ChunksDiffer, name)
+		}
+	}
+
+	return report, nil
+}
+
+// compareSets returns the sorted elements only found in a, only found in b
+// and found in both.
+func compareSets(a, b []string) ([]string, []string, []string) {
+	inB := make(map[string]bool)
+	for _, s := range b {
+		inB[s] = true
+	}
+
+	var onlyA, onlyB, both []string
+	inA := make(map[string]bool)
+	for _, s := range a {
+		inA[s] = true
+		if inB[s] {
+			both = append(both, s)
+		} else {
+			onlyA = append(onlyA, s)
+		}
+	}
+	for _, s := range b {
+		if !inA[s] {
+			onlyB = append(onlyB, s)
+		}
+	}
+
+	sort.Strings(onlyA)
+	sort.Strings(onlyB)
+	sort.Strings(both)
+	return onlyA, onlyB, both
+}
+
+// differs loads the same data from two backends and compares its checksums.
+// Data missing on both backends doesn't differ.
+func differs(loadA, loadB func() ([]byte, error)) (bool, error) {
+	sumA, err := checksum(loadA)
+	if err != nil {
+		return false, err
+	}
+	sumB, err := checksum(loadB)
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(sumA, sumB), nil
+}
+
+func checksum(load func() ([]byte, error)) ([]byte, error) {
+	b, err := load()
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	return sum[:], nil
+}
@@ -0,0 +1,90 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestCompareBackends(t *testing.T) {
+	ctx := context.Background()
+	var backends []Backend
+	for i := 0; i < 2; i++ {
+		dir, err := ioutil.TempDir("", "knoxite")
+		if err != nil {
+			t.Fatalf("Failed creating temporary dir for repository: %s", err)
+		}
+		defer os.RemoveAll(dir)
+
+		backend, err := BackendFromURL(dir)
+		if err != nil {
+			t.Fatalf("Failed creating backend: %s", err)
+		}
+		if err := backend.InitRepository(); err != nil {
+			t.Fatalf("Failed initializing repository: %s", err)
+		}
+		if err := backend.SaveRepository([]byte("repository")); err != nil {
+			t.Fatalf("Failed saving repository: %s", err)
+		}
+		for _, id := range []string{"snapshot", "modified"} {
+			if err := backend.SaveSnapshot(id, []byte(id)); err != nil {
+				t.Fatalf("Failed saving snapshot: %s", err)
+			}
+		}
+		for _, hash := range []string{"aaaaaa", "bbbbbb"} {
+			if _, err := backend.StoreChunk(ctx, hash, 0, 1, bytes.NewReader([]byte(hash)), 6); err != nil {
+				t.Fatalf("Failed storing chunk: %s", err)
+			}
+		}
+		backends = append(backends, backend)
+	}
+	a, b := backends[0], backends[1]
+
+	report, err := CompareBackends(ctx, a, b)
+	if err != nil {
+		t.Fatalf("Failed comparing backends: %s", err)
+	}
+	if !report.Identical() || report.Snapshots != 2 || report.Chunks != 2 || !report.SizesCompared {
+		t.Errorf("Expected identical backends, got %+v", report)
+	}
+
+	// let the mirror lag behind and diverge
+	_ = a.SaveSnapshot("new", []byte("new"))
+	_ = b.SaveSnapshot("modified", []byte("tampered"))
+	_, _ = a.StoreChunk(ctx, "cccccc", 0, 1, bytes.NewReader([]byte("cccccc")), 6)
+	_ = b.DeleteChunk(ctx, "aaaaaa", 0, 1)
+	_, _ = b.StoreChunk(ctx, "aaaaaa", 0, 1, bytes.NewReader([]byte("aaa")), 3)
+	_ = b.DeleteChunk(ctx, "bbbbbb", 0, 1)
+	_ = b.SaveRepository([]byte("other repository"))
+
+	report, err = CompareBackends(ctx, a, b)
+	if err != nil {
+		t.Fatalf("Failed comparing backends: %s", err)
+	}
+	if report.Identical() {
+		t.Fatal("Expected backends to diverge")
+	}
+	expected := &CompareReport{
+		Snapshots:         2,
+		Chunks:            1,
+		SnapshotsOnlyA:    []string{"new"},
+		SnapshotsDiffer:   []string{"modified"},
+		ChunksOnlyA:       []string{"bbbbbb.0_1", "cccccc.0_1"},
+		ChunksDiffer:      []string{"aaaaaa.0_1"},
+		SizesCompared:     true,
+		RepositoryDiffers: true,
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("Expected report %+v, got %+v", expected, report)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"compress/flate"
 	"compress/gzip"
 	"compress/zlib"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -30,6 +31,11 @@ const (
 	CompressionZstd
 )
 
+// Error declarations.
+var (
+	ErrUnknownCompression = errors.New("Unknown compression algo")
+)
+
 // Compressor is a pipeline processor that compresses data.
 type Compressor struct {
 	Method uint16
@@ -54,6 +60,8 @@ func (c Compressor) Process(data []byte) ([]byte, error) {
 		w = zlib.NewWriter(&buf)
 	case CompressionZstd:
 		w, err = zstd.NewWriter(&buf)
+	default:
+		return []byte{}, ErrUnknownCompression
 	}
 	if err != nil {
 		return []byte{}, err
@@ -118,6 +126,9 @@ func (c Decompressor) Process(data []byte) ([]byte, error) {
 		}
 
 		zr = ioutil.NopCloser(zri)
+
+	default:
+		return nil, ErrUnknownCompression
 	}
 
 	defer zr.Close()
@@ -0,0 +1,65 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"io"
+)
+
+// contextReader stops reading once its context is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+type contextReadSeeker struct {
+	contextReader
+	s io.Seeker
+}
+
+func (r *contextReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	return r.s.Seek(offset, whence)
+}
+
+type contextReadCloser struct {
+	contextReader
+	c io.Closer
+}
+
+func (r *contextReadCloser) Close() error {
+	return r.c.Close()
+}
+
+// ContextReader returns a reader that stops reading from r once ctx is done.
+// Backends without native support for contexts can use it to abort transfers.
+// The returned reader is an io.Seeker if r is one.
+func ContextReader(ctx context.Context, r io.Reader) io.Reader {
+	if s, ok := r.(io.Seeker); ok {
+		return &contextReadSeeker{
+			contextReader: contextReader{ctx: ctx, r: r},
+			s:             s,
+		}
+	}
+	return &contextReader{ctx: ctx, r: r}
+}
+
+// ContextReadCloser is like ContextReader, but also passes on calls to Close.
+func ContextReadCloser(ctx context.Context, r io.ReadCloser) io.ReadCloser {
+	return &contextReadCloser{
+		contextReader: contextReader{ctx: ctx, r: r},
+		c:             r,
+	}
+}
@@ -0,0 +1,220 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Error declarations.
+var (
+	ErrSnapshotExists = errors.New("The snapshot already exists in the destination repository")
+)
+
+// CopyOptions describes how copied chunks get stored in the destination
+// repository.
+type CopyOptions struct {
+	// Encrypt is the encryption of the copied chunks
+	Encrypt     uint16
+	DataParts   uint
+	ParityParts uint
+}
+
+// A Copier copies snapshots and the chunks they refer to from one repository
+// to another. The chunks get re-encrypted with the key of the destination
+// repository. Chunks, which are already stored in the destination, don't get
+// copied again.
+type Copier struct {
+	src   *Repository
+	dst   *Repository
+	index *ChunkIndex
+	opts  CopyOptions
+
+	// chunks maps the key, content and format of chunks to the chunks
+	// stored in the destination repository
+	chunks map[string]Chunk
+}
+
+// CopyStats describes the data copied by a Copier.
+type CopyStats struct {
+	Chunks      uint64
+	StorageSize uint64
+}
+
+// NewCopier returns a Copier storing chunks in dst and updating its
+// chunk-index as it goes. The chunks of the snapshots stored in dst
+// already, except for those of locked volumes, get reused.
+func NewCopier(src, dst *Repository, index *ChunkIndex, opts CopyOptions) (*Copier, error) {
+	c := &Copier{
+		src:    src,
+		dst:    dst,
+		index:  index,
+		opts:   opts,
+		chunks: make(map[string]Chunk),
+	}
+
+	for _, volume := range dst.Volumes {
+		for _, id := range volume.Snapshots {
+			snapshot, err := volume.LoadSnapshot(id, dst)
+			if err == ErrVolumeLocked {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			for _, arc := range snapshot.Archives {
+				for _, chunk := range arc.Chunks {
+					if !chunk.Hole {
+						c.chunks[copyKey(chunk.KeyID, snapshot.DedupDomain, arc.Compressed, arc.Encrypted, chunk)] = chunk
+					}
+				}
+			}
+		}
+	}
+	return c, nil
+}
+
+// copyKey identifies chunks with equal content and format of a deduplication
+// domain, encrypted with the same key.
+func copyKey(keyID, domain string, compression, encryption uint16, chunk Chunk) string {
+	return fmt.Sprintf("%s.%s.%s.%d.%d.%d.%d", keyID, domain, chunk.DecryptedHash, compression, encryption, chunk.DataParts, chunk.ParityParts)
+}
+
+// Snapshot copies snapshot to volume of the destination repository and
+// returns the copy. Partial snapshots get copied as full snapshots. The copy
+// keeps the ID of the snapshot, so copying it again fails with
+// ErrSnapshotExists. Save the destination repository and its chunk-index
+// afterwards.
+func (c *Copier) Snapshot(ctx context.Context, snapshot *Snapshot, volume *Volume) (*Snapshot, CopyStats, error) {
+	var stats CopyStats
+	if _, _, err := c.dst.FindSnapshot(snapshot.ID); err == nil {
+		return nil, stats, ErrSnapshotExists
+	}
+	snapshot, err := snapshot.Merged(c.src)
+	if err != nil {
+		return nil, stats, err
+	}
+
+	copied := &Snapshot{
+		ID:          snapshot.ID,
+		Date:        snapshot.Date,
+		Description: snapshot.Description,
+		Tags:        snapshot.Tags,
+		Hostname:    snapshot.Hostname,
+		Username:    snapshot.Username,
+		Stats:       snapshot.Stats,
+		Archives:    make(map[string]*Archive),
+		Paths:       snapshot.Paths,
+		CommandLine: snapshot.CommandLine,
+		Annotations: snapshot.Annotations,
+		Chunker:     snapshot.Chunker,
+	}
+	if err := volume.PrepareSnapshot(copied); err != nil {
+		return nil, stats, err
+	}
+
+	paths := make([]string, 0, len(snapshot.Archives))
+	for path := range snapshot.Archives {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		arc := snapshot.Archives[path]
+		a := *arc
+		a.Encrypted = c.opts.Encrypt
+		a.Chunks = make([]Chunk, len(arc.Chunks))
+		for i, chunk := range arc.Chunks {
+			if ctx.Err() != nil {
+				return nil, stats, ctx.Err()
+			}
+			if !chunk.Hole {
+				ch, err := c.chunk(ctx, copied, arc, &a, chunk, &stats)
+				if err != nil {
+					return nil, stats, fmt.Errorf("%s: %w", path, err)
+				}
+				chunk = ch
+			}
+			a.Chunks[i] = chunk
+		}
+		copied.Archives[path] = &a
+	}
+
+	if err := copied.Save(c.dst); err != nil {
+		return nil, stats, err
+	}
+	if err := volume.AddSnapshot(copied.ID); err != nil {
+		return nil, stats, err
+	}
+	for _, arc := range copied.Archives {
+		c.index.AddArchive(arc, copied.ID)
+	}
+	return copied, stats, nil
+}
+
+// chunk stores chunk of the source archive arc in the destination
+// repository, unless it's stored there already, and returns the new chunk.
+// dstArc is the archive it gets copied to.
+func (c *Copier) chunk(ctx context.Context, snapshot *Snapshot, arc, dstArc *Archive, chunk Chunk, stats *CopyStats) (Chunk, error) {
+	opts := StoreOptions{
+		Compress:     dstArc.Compressed,
+		Encrypt:      dstArc.Encrypted,
+		DataParts:    c.opts.DataParts,
+		ParityParts:  c.opts.ParityParts,
+		dedupDomain:  snapshot.DedupDomain,
+		chunkHeaders: c.dst.chunkHeaders(),
+	}
+	password := snapshot.encryptionKey(c.dst, opts.Encrypt)
+	id := ""
+	if opts.Encrypt == EncryptionAES || opts.Encrypt == EncryptionAESGCM {
+		id = keyID(password)
+	}
+	if opts.ParityParts == 0 {
+		opts.DataParts = 1
+	}
+
+	k := copyKey(id, snapshot.DedupDomain, opts.Compress, opts.Encrypt, Chunk{
+		DecryptedHash: chunk.DecryptedHash,
+		DataParts:     opts.DataParts,
+		ParityParts:   opts.ParityParts,
+	})
+	if ch, ok := c.chunks[k]; ok {
+		ch.Num = chunk.Num
+		return ch, nil
+	}
+
+	start := time.Now()
+	b, err := loadChunk(ctx, *c.src, *arc, chunk, nil)
+	if err != nil {
+		return chunk, err
+	}
+	pipe, err := NewEncodingPipeline(opts.Compress, opts.Encrypt, password)
+	if err != nil {
+		return chunk, err
+	}
+	ch, err := encodeChunk(pipe, password, opts, inputChunk{Data: b, Num: chunk.Num})
+	if err != nil {
+		return chunk, err
+	}
+	n, err := c.dst.backend.StoreChunk(ctx, ch)
+	if err != nil {
+		return chunk, err
+	}
+	log.Debugf("Copied chunk %s as %s in %s", chunk.Hash, ch.Hash, time.Since(start))
+
+	stats.Chunks++
+	stats.StorageSize += n
+
+	ch.Data = &[][]byte{}
+	c.chunks[k] = ch
+	return ch, nil
+}
@@ -0,0 +1,162 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopySnapshot(t *testing.T) {
+	ctx := context.Background()
+
+	srcDir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(srcDir)
+	dstDir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dstDir)
+	data, err := ioutil.TempDir("", "knoxite.src")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(data)
+
+	content := make([]byte, 2*preferredChunkSize+1234)
+	rand.Read(content)
+	files := map[string][]byte{
+		"a.bin":     content,
+		"sub/b.bin": content,
+		"c.txt":     []byte("some text"),
+	}
+	for name, b := range files {
+		path := filepath.Join(data, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src, err := NewRepository(srcDir, "source_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	vol, _ := NewVolume("test", "")
+	_ = src.AddVolume(vol)
+	srcIndex, err := OpenChunkIndex(&src)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	opts := StoreOptions{
+		CWD:       data,
+		Paths:     []string{data},
+		Compress:  CompressionZstd,
+		Encrypt:   EncryptionAESGCM,
+		DataParts: 1,
+	}
+	var snapshots []*Snapshot
+	for i := 0; i < 2; i++ {
+		snapshot, _ := NewSnapshot("test_snapshot")
+		_ = snapshot.Annotate("run", "test")
+		for p := range snapshot.Add(ctx, src, &srcIndex, opts) {
+			if p.Error != nil {
+				t.Fatalf("Failed adding to snapshot: %s", p.Error)
+			}
+		}
+		if err := snapshot.Save(&src); err != nil {
+			t.Fatal(err)
+		}
+		_ = vol.AddSnapshot(snapshot.ID)
+		snapshots = append(snapshots, snapshot)
+	}
+
+	dst, err := NewRepository(dstDir, "destination_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	dstVol, _ := NewVolume("copies", "")
+	_ = dst.AddVolume(dstVol)
+	dstIndex, err := OpenChunkIndex(&dst)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	copyOpts := CopyOptions{
+		Encrypt:   EncryptionAESGCM,
+		DataParts: 1,
+	}
+
+	copier, err := NewCopier(&src, &dst, &dstIndex, copyOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied, stats, err := copier.Snapshot(ctx, snapshots[0], dstVol)
+	if err != nil {
+		t.Fatalf("Failed copying snapshot: %s", err)
+	}
+	// equal files share their chunks
+	if stats.Chunks != uint64(len(srcIndex.Chunks)) {
+		t.Errorf("Expected %d chunks to be copied, got %d", len(srcIndex.Chunks), stats.Chunks)
+	}
+	if _, _, err := copier.Snapshot(ctx, snapshots[0], dstVol); err != ErrSnapshotExists {
+		t.Errorf("Expected %v when copying a snapshot twice, got %v", ErrSnapshotExists, err)
+	}
+	if err := dst.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dstIndex.Save(&dst); err != nil {
+		t.Fatal(err)
+	}
+
+	// chunks already stored in the destination get reused
+	copier, err = NewCopier(&src, &dst, &dstIndex, copyOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, stats, err = copier.Snapshot(ctx, snapshots[1], dstVol); err != nil {
+		t.Fatalf("Failed copying snapshot: %s", err)
+	}
+	if stats.Chunks != 0 {
+		t.Errorf("Expected no chunks to be copied, got %d", stats.Chunks)
+	}
+
+	dst, err = OpenRepository(dstDir, "destination_password")
+	if err != nil {
+		t.Fatalf("Failed opening repository: %s", err)
+	}
+	_, snapshot, err := dst.FindSnapshot(copied.ID)
+	if err != nil {
+		t.Fatalf("Failed loading copied snapshot: %s", err)
+	}
+	if snapshot.Description != snapshots[0].Description || snapshot.Annotations["run"] != "test" ||
+		snapshot.Stats.Size != snapshots[0].Stats.Size {
+		t.Errorf("Expected metadata of the snapshot to be copied, got %+v", snapshot)
+	}
+
+	var buf bytes.Buffer
+	progress, err := ExportSnapshot(ctx, dst, snapshot, &buf, FormatTar, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Failed exporting snapshot: %s", err)
+	}
+	for p := range progress {
+		if p.Error != nil {
+			t.Fatalf("Failed exporting snapshot: %s", p.Error)
+		}
+	}
+	compareExported(t, "copied", files, readTar(t, &buf))
+}
@@ -10,10 +10,14 @@ package knoxite
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -21,6 +25,10 @@ import (
 	"github.com/klauspost/reedsolomon"
 )
 
+// ErrNotAFile is returned when reading the content of an item, which isn't a
+// regular file.
+var ErrNotAFile = errors.New("Not a regular file")
+
 // ChunkError records an error and the index
 // that caused it.
 type ChunkError struct {
@@ -65,52 +73,281 @@ func (e *DataReconstructionError) Error() string {
 	return fmt.Sprintf("Could not reconstruct data, got %d out of %d chunks (%d backends missing data)", e.BlocksFound, e.Chunk.DataParts, e.FailedBackends)
 }
 
-// DecodeSnapshot restores an entire snapshot to dst.
-func DecodeSnapshot(repository Repository, snapshot *Snapshot, dst string, excludes []string, pedantic bool) (<-chan Progress, error) {
+// RestoreOptions holds all the options for restoring a snapshot.
+type RestoreOptions struct {
+	// Includes restricts restoring to the items matching one of these
+	// patterns, as well as their content and parent directories
+	Includes []string
+	Excludes []string
+	// MinSize and MaxSize restrict restoring to files of at least and at most
+	// this many bytes, ModifiedAfter to files modified since then. Zero
+	// values disable the filters. Directories and symlinks aren't affected
+	MinSize       uint64
+	MaxSize       uint64
+	ModifiedAfter time.Time
+	Pedantic      bool
+	// MaxOpenFiles is the amount of files being restored in parallel, and
+	// thereby limits how many file descriptors are open at the same time
+	MaxOpenFiles uint
+	// SkipXAttrs doesn't restore extended attributes and ACLs, e.g. because
+	// the target file system doesn't support them
+	SkipXAttrs bool
+	// Timings collects the time spent in the stages of restoring, unless
+	// it's nil
+	Timings *Timings
+	// ProgressInterval is the interval in which progress updates get
+	// coalesced. Its zero value uses the DefaultProgressInterval, a negative
+	// value reports every single chunk
+	ProgressInterval time.Duration
+}
+
+// DefaultMaxOpenFiles is the amount of files restored in parallel, unless
+// specified otherwise in RestoreOptions.
+const DefaultMaxOpenFiles = 16
+
+// matchFile returns true if a file passes the size and modification time
+// filters.
+func (opts RestoreOptions) matchFile(arc *Archive) bool {
+	if arc.Size < opts.MinSize || (opts.MaxSize > 0 && arc.Size > opts.MaxSize) {
+		return false
+	}
+	return opts.ModifiedAfter.IsZero() || arc.ModTime >= opts.ModifiedAfter.Unix()
+}
+
+// SelectArchives returns the items of a snapshot that get restored with the
+// given options, sorted by their path. Patterns support the same wildcards as
+// exclude pattern files, including "**" to match any number of directories.
+func SelectArchives(snapshot *Snapshot, opts RestoreOptions) ([]*Archive, error) {
+	includes, err := compileGlobs(opts.Includes)
+	if err != nil {
+		return nil, err
+	}
+	excludes, err := compileGlobs(opts.Excludes)
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]*Archive)
+	for _, arc := range snapshot.Archives {
+		match := false
+		for _, exclude := range opts.Excludes {
+			match, err = filepath.Match(strings.ToLower(exclude), strings.ToLower(arc.Path))
+			if err != nil {
+				return nil, fmt.Errorf("invalid exclude filter %s: %v", exclude, err)
+			}
+			if match {
+				break
+			}
+		}
+		if match || excludes.match(arc.Path, true) {
+			continue
+		}
+		if len(includes) > 0 && !includes.match(arc.Path, true) {
+			continue
+		}
+		if arc.Type == File && !opts.matchFile(arc) {
+			continue
+		}
+
+		selected[arc.Path] = arc
+	}
+
+	// restore the parent directories of included items, too
+	if len(includes) > 0 {
+		for path := range selected {
+			for dir := filepath.Dir(path); dir != path; path, dir = dir, filepath.Dir(dir) {
+				arc, ok := snapshot.Archives[dir]
+				if !ok || arc.Type != Directory || excludes.match(dir, true) {
+					continue
+				}
+				selected[dir] = arc
+			}
+		}
+	}
+
+	archives := make([]*Archive, 0, len(selected))
+	for _, arc := range selected {
+		archives = append(archives, arc)
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].Path < archives[j].Path })
+	return archives, nil
+}
+
+// DecodeSnapshot restores an entire snapshot to dst. Restoring stops and the
+// returned channel gets closed once ctx is done.
+//
+// All directories get created first, in sorted order, before up to
+// opts.MaxOpenFiles files get restored in parallel.
+func DecodeSnapshot(ctx context.Context, repository Repository, snapshot *Snapshot, dst string, opts RestoreOptions) (<-chan Progress, error) {
+	if opts.MaxOpenFiles == 0 {
+		opts.MaxOpenFiles = DefaultMaxOpenFiles
+	}
+
+	archives, err := SelectArchives(snapshot, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs, files, links []*Archive
+	restored := make(map[string]bool)
+	for _, arc := range archives {
+		if arc.Type == Directory {
+			dirs = append(dirs, arc)
+		} else {
+			files = append(files, arc)
+		}
+		restored[arc.Path] = arc.LinkTo == ""
+	}
+
+	// hard links get restored once the files they link to exist, unless
+	// those don't get restored at all
+	n := 0
+	for _, arc := range files {
+		if arc.LinkTo != "" && restored[arc.LinkTo] {
+			links = append(links, arc)
+		} else {
+			files[n] = arc
+			n++
+		}
+	}
+	files = files[:n]
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].Path < dirs[j].Path })
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+
 	prog := make(chan Progress)
+	// errors still get reported after restoring has been canceled
+	throttled := throttleProgress(ctx, prog, opts.ProgressInterval)
+	ctx, cancel := context.WithCancel(ctx)
+
+	// fail reports an error and returns true if restoring should stop
+	fail := func(arc *Archive, err error) bool {
+		if ctx.Err() != nil {
+			return true
+		}
+		p := newProgressError(err)
+		p.Path = arc.Path
+		if !sendProgress(ctx, prog, p) || opts.Pedantic {
+			cancel()
+			return true
+		}
+		return false
+	}
+
+	decode := func(arc *Archive) error {
+		path := filepath.Join(dst, arc.Path)
+		if err := decodeArchive(ctx, prog, repository, *arc, path, opts.Timings); err != nil {
+			return err
+		}
+		if !opts.SkipXAttrs {
+			if err := writeXAttrs(path, arc.XAttrs); err != nil {
+				return err
+			}
+		}
+		return writeFileAttributes(path, arc)
+	}
+
 	go func() {
 		defer close(prog)
-		for _, arc := range snapshot.Archives {
-			path := filepath.Join(dst, arc.Path)
+		defer cancel()
 
-			match := false
-			for _, exclude := range excludes {
-				var err error
-				match, err = filepath.Match(strings.ToLower(exclude), strings.ToLower(arc.Path))
-				if err != nil {
-					fmt.Println("Invalid exclude filter:", exclude)
+		// create all directories up front, parents before their children
+		for _, arc := range dirs {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := decode(arc); err != nil {
+				if fail(arc, err) {
 					return
 				}
-				if match {
-					break
+			}
+		}
+		var parent string
+		for _, arc := range files {
+			dir := filepath.Dir(filepath.Join(dst, arc.Path))
+			if dir == parent {
+				continue
+			}
+			parent = dir
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				if fail(arc, err) {
+					return
 				}
 			}
+		}
 
-			if match {
-				continue
+		jobs := make(chan *Archive)
+		var wg sync.WaitGroup
+		for i := uint(0); i < opts.MaxOpenFiles; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for arc := range jobs {
+					if err := decode(arc); err != nil {
+						fail(arc, err)
+					}
+				}
+			}()
+		}
+
+	loop:
+		for _, arc := range files {
+			select {
+			case jobs <- arc:
+			case <-ctx.Done():
+				break loop
 			}
+		}
+		close(jobs)
+		wg.Wait()
 
-			err := DecodeArchive(prog, repository, *arc, path)
-			if err != nil {
-				p := newProgressError(err)
-				p.Path = arc.Path
-				prog <- p
-				if pedantic {
-					break
+		for _, arc := range links {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := decodeHardLink(ctx, prog, repository, *arc, dst, opts.Timings); err != nil {
+				if fail(arc, err) {
+					return
 				}
-				continue
 			}
 		}
 	}()
 
-	return prog, nil
+	return throttled, nil
+}
+
+// decodeHardLink restores a hard link to an already restored file. Should
+// creating the link fail, e.g. because the file system doesn't support hard
+// links, the file gets restored on its own.
+func decodeHardLink(ctx context.Context, progress chan<- Progress, repository Repository, arc Archive, dst string, timings *Timings) error {
+	path := filepath.Join(dst, arc.Path)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(filepath.Join(dst, arc.LinkTo), path); err != nil {
+		return decodeArchive(ctx, progress, repository, arc, path, timings)
+	}
+
+	p := newProgress(&arc)
+	p.TotalStatistics.Files++
+	p.TotalStatistics.StorageSize = arc.StorageSize
+	p.CurrentItemStats.Transferred = arc.Size
+	p.TotalStatistics.Transferred = arc.Size
+	if !sendProgress(ctx, progress, p) {
+		return ctx.Err()
+	}
+	return nil
 }
 
-func decodeChunk(repository Repository, archive Archive, chunk Chunk, b []byte) ([]byte, error) {
-	pipe, err := NewDecodingPipeline(archive.Compressed, archive.Encrypted, repository.Key)
+func decodeChunk(repository Repository, compression, encryption uint16, chunk Chunk, b []byte, timings *Timings) ([]byte, error) {
+	key, err := repository.decryptionKey(encryption, chunk.KeyID)
 	if err != nil {
 		return []byte{}, err
 	}
+	pipe, err := NewDecodingPipeline(compression, encryption, key)
+	if err != nil {
+		return []byte{}, err
+	}
+	pipe = timings.pipeline(pipe, StageDecrypting, StageDecompressing)
 	b, err = pipe.Process(b)
 	if err != nil {
 		return []byte{}, err
@@ -124,7 +361,35 @@ func decodeChunk(repository Repository, archive Archive, chunk Chunk, b []byte)
 	return b, nil
 }
 
-func loadChunk(repository Repository, archive Archive, chunk Chunk) ([]byte, error) {
+// loadChunkPart loads a single part of a chunk and strips its header. It also
+// returns the compression & encryption the chunk was stored with.
+func loadChunkPart(ctx context.Context, repository Repository, archive Archive, chunk Chunk, part uint, timings *Timings) ([]byte, uint16, uint16, error) {
+	start := time.Now()
+	b, err := repository.backend.LoadChunk(ctx, chunk, part)
+	timings.since(StageDownloading, start)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	header, data, err := SplitChunkHeader(b)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if header == nil || !header.matches(chunk, part) {
+		// stored by an older version of knoxite
+		return b, archive.Compressed, archive.Encrypted, nil
+	}
+	return data, header.Compression, header.Encryption, nil
+}
+
+// loadChunk loads and decodes a chunk. The time spent doing so gets added to
+// timings, unless it's nil.
+func loadChunk(ctx context.Context, repository Repository, archive Archive, chunk Chunk, timings *Timings) ([]byte, error) {
+	if chunk.Hole {
+		return make([]byte, chunk.OriginalSize), nil
+	}
+	compression, encryption := archive.Compressed, archive.Encrypted
+
 	if chunk.ParityParts > 0 {
 		enc, err := reedsolomon.New(int(chunk.DataParts), int(chunk.ParityParts))
 		if err != nil {
@@ -133,16 +398,23 @@ func loadChunk(repository Repository, archive Archive, chunk Chunk) ([]byte, err
 		pars := make([][]byte, chunk.DataParts+chunk.ParityParts)
 		parsFound := uint(0)
 		parsMissing := 0
+		// the parts which couldn't be loaded and need to be repaired
+		var failed []string
 
 		// try to load all parts until we can successfully combine/reconstruct the chunk
 		for i := 0; i < int(chunk.DataParts+chunk.ParityParts); i++ {
-			var err error
-			pars[i], err = repository.backend.LoadChunk(chunk, uint(i))
+			b, c, e, err := loadChunkPart(ctx, repository, archive, chunk, uint(i), timings)
+			if ctx.Err() != nil {
+				return []byte{}, ctx.Err()
+			}
 			if err != nil {
 				pars[i] = nil
 				parsMissing++
+				failed = append(failed, fmt.Sprintf("%s (%v)", ChunkPart{chunk.Hash, uint(i), chunk.DataParts}, err))
 				continue
 			}
+			pars[i] = b
+			compression, encryption = c, e
 			parsFound++
 
 			// check if we already have a sufficient amount of parts
@@ -163,22 +435,31 @@ func loadChunk(repository Repository, archive Archive, chunk Chunk) ([]byte, err
 					continue
 				}
 				_ = w.Flush()
-				return decodeChunk(repository, archive, chunk, b.Bytes())
+				data, err := decodeChunk(repository, compression, encryption, chunk, b.Bytes(), timings)
+				if err == nil && len(failed) > 0 {
+					log.Warnf("Reconstructed chunk %s from its parity parts, these parts need to be repaired: %s",
+						chunk.Hash, strings.Join(failed, ", "))
+				}
+				return data, err
 			}
 		}
 
 		return []byte{}, &DataReconstructionError{chunk, parsFound, chunk.DataParts - parsFound}
 	}
 
-	b, err := repository.backend.LoadChunk(chunk, 0)
+	b, compression, encryption, err := loadChunkPart(ctx, repository, archive, chunk, 0, timings)
 	if err != nil {
 		return []byte{}, err
 	}
-	return decodeChunk(repository, archive, chunk, b)
+	return decodeChunk(repository, compression, encryption, chunk, b, timings)
 }
 
 // DecodeArchive restores a single archive to path.
-func DecodeArchive(progress chan<- Progress, repository Repository, arc Archive, path string) error {
+func DecodeArchive(ctx context.Context, progress chan<- Progress, repository Repository, arc Archive, path string) error {
+	return decodeArchive(ctx, progress, repository, arc, path, nil)
+}
+
+func decodeArchive(ctx context.Context, progress chan<- Progress, repository Repository, arc Archive, path string, timings *Timings) error {
 	p := newProgress(&arc)
 
 	if arc.Type == Directory {
@@ -188,15 +469,31 @@ func DecodeArchive(progress chan<- Progress, repository Repository, arc Archive,
 			return err
 		}
 		p.TotalStatistics.Dirs++
-		progress <- p
+		if !sendProgress(ctx, progress, p) {
+			return ctx.Err()
+		}
 	} else if arc.Type == SymLink {
 		//fmt.Printf("Creating symlink %s -> %s\n", path, arc.PointsTo)
-		err := os.Symlink(arc.PointsTo, path)
+		err := os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			return err
+		}
+
+		// replace an existing link, so the stored target gets restored exactly
+		if fi, err := os.Lstat(path); err == nil && isSymLink(fi) {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+		}
+
+		err = os.Symlink(arc.PointsTo, path)
 		if err != nil {
 			return err
 		}
 		p.TotalStatistics.SymLinks++
-		progress <- p
+		if !sendProgress(ctx, progress, p) {
+			return ctx.Err()
+		}
 	} else if arc.Type == File {
 		parts := uint(len(arc.Chunks))
 		//fmt.Printf("Creating file %s (%d chunks).\n", path, parts)
@@ -204,7 +501,9 @@ func DecodeArchive(progress chan<- Progress, repository Repository, arc Archive,
 		p.TotalStatistics.Files++
 		p.TotalStatistics.Size = arc.Size
 		p.TotalStatistics.StorageSize = arc.StorageSize
-		progress <- p
+		if !sendProgress(ctx, progress, p) {
+			return ctx.Err()
+		}
 
 		// FIXME: we don't always need to create the path
 		// this is just a safety measure for now
@@ -218,6 +517,15 @@ func DecodeArchive(progress chan<- Progress, repository Repository, arc Archive,
 		if err != nil {
 			return err
 		}
+		defer f.Close()
+
+		// holes get skipped, so any previous content must be gone
+		sparse := arc.sparse()
+		if sparse {
+			if err := f.Truncate(0); err != nil {
+				return err
+			}
+		}
 
 		for i := uint(0); i < parts; i++ {
 			idx, err := arc.IndexOfChunk(i)
@@ -226,22 +534,38 @@ func DecodeArchive(progress chan<- Progress, repository Repository, arc Archive,
 			}
 
 			chunk := arc.Chunks[idx]
-			b, err := loadChunk(repository, arc, chunk)
-			if err != nil {
-				return err
+			n := chunk.OriginalSize
+			if chunk.Hole {
+				_, err = f.Seek(int64(n), io.SeekCurrent)
+			} else {
+				var b []byte
+				b, err = loadChunk(ctx, repository, arc, chunk, timings)
+				if err != nil {
+					return err
+				}
+				start := time.Now()
+				n, err = f.Write(b)
+				timings.since(StageWriting, start)
 			}
-
-			_, err = f.Write(b)
 			if err != nil {
 				return err
 			}
 
-			p.TotalStatistics.Transferred += uint64(len(b))
-			p.CurrentItemStats.Transferred += uint64(len(b))
-			progress <- p
+			p.TotalStatistics.Transferred += uint64(n)
+			p.CurrentItemStats.Transferred += uint64(n)
+			if !sendProgress(ctx, progress, p) {
+				return ctx.Err()
+			}
 			// fmt.Printf("Chunk OK: %d bytes, hash: %s\n", size, chunk.DecryptedHash)
 		}
 
+		// extend the file in case it ends with a hole
+		if sparse {
+			if err := f.Truncate(int64(arc.Size)); err != nil {
+				return err
+			}
+		}
+
 		err = f.Sync()
 		if err != nil {
 			return err
@@ -276,7 +600,7 @@ func init() {
 }
 
 // DecodeArchiveData returns the content of a single archive.
-func DecodeArchiveData(repository Repository, arc Archive) ([]byte, Stats, error) {
+func DecodeArchiveData(ctx context.Context, repository Repository, arc Archive) ([]byte, Stats, error) {
 	var b []byte
 	var stats Stats
 
@@ -290,13 +614,18 @@ func DecodeArchiveData(repository Repository, arc Archive) ([]byte, Stats, error
 			}
 
 			chunk := arc.Chunks[idx]
+			if chunk.Hole {
+				b = append(b, make([]byte, chunk.OriginalSize)...)
+				continue
+			}
 			mutex.Lock()
 			cd, ok := cache[chunk.Hash]
 			if ok {
-				fmt.Println("Using cached chunk", chunk.Hash)
+				log.Debugf("Using cached chunk %s", chunk.Hash)
 			} else {
-				cd, err = loadChunk(repository, arc, chunk)
+				cd, err = loadChunk(ctx, repository, arc, chunk, nil)
 				if err != nil {
+					mutex.Unlock()
 					return b, stats, err
 				}
 				cache[chunk.Hash] = cd
@@ -315,7 +644,51 @@ func DecodeArchiveData(repository Repository, arc Archive) ([]byte, Stats, error
 	return b, stats, nil
 }
 
-func readArchiveChunk(repository Repository, arc Archive, chunkNum uint) (*[]byte, error) {
+// WriteArchiveData streams the content of a single archive to w. Chunks get
+// written one by one, so the content doesn't need to fit into memory.
+func WriteArchiveData(ctx context.Context, repository Repository, arc Archive, w io.Writer) error {
+	if arc.Type != File {
+		return ErrNotAFile
+	}
+
+	var zeros []byte
+	for i := uint(0); i < uint(len(arc.Chunks)); i++ {
+		idx, err := arc.IndexOfChunk(i)
+		if err != nil {
+			return err
+		}
+
+		chunk := arc.Chunks[idx]
+		if chunk.Hole {
+			if zeros == nil {
+				zeros = make([]byte, preferredChunkSize)
+			}
+			for n := chunk.OriginalSize; n > 0; {
+				m := n
+				if m > len(zeros) {
+					m = len(zeros)
+				}
+				if _, err := w.Write(zeros[:m]); err != nil {
+					return err
+				}
+				n -= m
+			}
+			continue
+		}
+
+		b, err := loadChunk(ctx, repository, arc, chunk, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readArchiveChunk(ctx context.Context, repository Repository, arc Archive, chunkNum uint) (*[]byte, error) {
 	var b []byte
 	var err error
 
@@ -325,11 +698,16 @@ func readArchiveChunk(repository Repository, arc Archive, chunkNum uint) (*[]byt
 	}
 
 	chunk := arc.Chunks[idx]
+	if chunk.Hole {
+		b = make([]byte, chunk.OriginalSize)
+		return &b, nil
+	}
 	mutex.Lock()
 	cd, ok := cache[chunk.Hash]
 	if !ok {
-		cd, err = loadChunk(repository, arc, chunk)
+		cd, err = loadChunk(ctx, repository, arc, chunk, nil)
 		if err != nil {
+			mutex.Unlock()
 			return &b, err
 		}
 		cache[chunk.Hash] = cd
@@ -342,7 +720,7 @@ func readArchiveChunk(repository Repository, arc Archive, chunkNum uint) (*[]byt
 }
 
 // ReadArchive reads from an archive.
-func ReadArchive(repository Repository, arc Archive, offset int, size int) (*[]byte, error) {
+func ReadArchive(ctx context.Context, repository Repository, arc Archive, offset int, size int) (*[]byte, error) {
 	var b []byte
 
 	// fmt.Println("Read req:", offset, size)
@@ -356,7 +734,10 @@ func ReadArchive(repository Repository, arc Archive, offset int, size int) (*[]b
 			if neededPart >= uint(len(arc.Chunks)) {
 				return &b, nil
 			}
-			cd, err := readArchiveChunk(repository, arc, neededPart)
+			cd, err := readArchiveChunk(ctx, repository, arc, neededPart)
+			if ctx.Err() != nil {
+				return &b, ctx.Err()
+			}
 			if err != nil || len(*cd) == 0 {
 				//return b, err
 				panic(err)
@@ -377,9 +758,10 @@ func ReadArchive(repository Repository, arc Archive, offset int, size int) (*[]b
 			neededPart++
 		}
 
-		// cache the next block NOW
+		// cache the next block NOW. This must not be tied to the request's
+		// context, which is done as soon as we return
 		go func() {
-			_, _ = readArchiveChunk(repository, arc, neededPart)
+			_, _ = readArchiveChunk(context.Background(), repository, arc, neededPart)
 		}()
 	}
 
@@ -0,0 +1,75 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import "errors"
+
+// dedupVersion is the first repository version supporting deduplication
+// scoped to volumes. Older versions of knoxite would share chunks between
+// all volumes.
+const dedupVersion = 11
+
+// Deduplication scopes.
+const (
+	// DedupRepository shares equal chunks between all volumes
+	DedupRepository = "repository"
+	// DedupVolume only shares equal chunks between the snapshots of a volume
+	DedupVolume = "volume"
+)
+
+// Error declarations.
+var (
+	ErrInvalidDedupScope = errors.New("Invalid deduplication scope, use repository or volume")
+)
+
+// DedupScope returns whether equal chunks get shared by all volumes of the
+// repository or only by the snapshots of each volume.
+func (r *Repository) DedupScope() string {
+	if r.Dedup == "" {
+		return DedupRepository
+	}
+	return r.Dedup
+}
+
+// SetDedupScope changes whether equal chunks get shared by all volumes of the
+// repository or only by the snapshots of each volume, e.g. to isolate tenants
+// or to guarantee that removing a volume deletes all of its data. It only
+// affects snapshots stored afterwards, chunks which are shared already stay
+// shared until the snapshots referencing them get removed.
+func (r *Repository) SetDedupScope(scope string) error {
+	if !r.IsAdmin() {
+		return ErrAppendOnly
+	}
+	switch scope {
+	case DedupRepository:
+		r.Dedup = ""
+	case DedupVolume:
+		r.Dedup = scope
+		if r.Version < dedupVersion {
+			r.Version = dedupVersion
+		}
+		if r.ReaderVersion < dedupVersion {
+			r.ReaderVersion = dedupVersion
+		}
+	default:
+		return ErrInvalidDedupScope
+	}
+
+	for _, v := range r.Volumes {
+		r.setDedupDomain(v)
+	}
+	return r.Save()
+}
+
+// setDedupDomain sets the domain the snapshots of v get deduplicated in.
+func (r *Repository) setDedupDomain(v *Volume) {
+	v.DedupDomain = ""
+	if r.Dedup == DedupVolume {
+		v.DedupDomain = v.ID
+	}
+}
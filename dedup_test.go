@@ -0,0 +1,126 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDedupScope(t *testing.T) {
+	src, err := ioutil.TempDir("", "knoxite.source")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+	if err := ioutil.WriteFile(filepath.Join(src, "data"), []byte("shared content"), 0600); err != nil {
+		t.Fatalf("Failed writing test file: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, "this_is_a_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	var volumes []*Volume
+	for _, name := range []string{"a", "b"} {
+		v, err := NewVolume(name, "")
+		if err != nil {
+			t.Fatalf("Failed creating volume: %s", err)
+		}
+		if err := r.AddVolume(v); err != nil {
+			t.Fatalf("Failed adding volume: %s", err)
+		}
+		volumes = append(volumes, v)
+	}
+
+	// store returns the hash of the chunk stored for the test file in v
+	store := func(v *Volume) string {
+		snapshot, err := NewSnapshot("test_snapshot")
+		if err != nil {
+			t.Fatalf("Failed creating snapshot: %s", err)
+		}
+		if err := v.PrepareSnapshot(snapshot); err != nil {
+			t.Fatalf("Failed preparing snapshot: %s", err)
+		}
+		opts := StoreOptions{
+			CWD:       src,
+			Paths:     []string{src},
+			Encrypt:   EncryptionAES,
+			DataParts: 1,
+		}
+		for p := range snapshot.Add(context.Background(), r, &index, opts) {
+			if p.Error != nil {
+				t.Fatalf("Failed adding to snapshot: %s", p.Error)
+			}
+		}
+
+		arc := snapshot.Archives["data"]
+		b, err := loadChunk(context.Background(), r, *arc, arc.Chunks[0], nil)
+		if err != nil || string(b) != "shared content" {
+			t.Errorf("Failed loading chunk of volume %s: %v", v.Name, err)
+		}
+		return arc.Chunks[0].Hash
+	}
+
+	if r.DedupScope() != DedupRepository {
+		t.Errorf("Expected the default scope %s, got %s", DedupRepository, r.DedupScope())
+	}
+	if store(volumes[0]) != store(volumes[1]) {
+		t.Error("Expected the volumes to share equal chunks")
+	}
+
+	if err := r.SetDedupScope("tenant"); err != ErrInvalidDedupScope {
+		t.Errorf("Expected error %v, got %v", ErrInvalidDedupScope, err)
+	}
+	if err := r.SetDedupScope(DedupVolume); err != nil {
+		t.Fatalf("Failed changing the deduplication scope: %s", err)
+	}
+	if r.ReaderVersion < dedupVersion {
+		t.Errorf("Expected reader version %d, got %d", dedupVersion, r.ReaderVersion)
+	}
+	a, b := store(volumes[0]), store(volumes[1])
+	if a == b {
+		t.Error("Expected the volumes not to share any chunks")
+	}
+	if store(volumes[0]) != a {
+		t.Error("Expected the snapshots of a volume to share equal chunks")
+	}
+
+	// new volumes get their own domain, too
+	v, err := NewVolume("c", "")
+	if err != nil {
+		t.Fatalf("Failed creating volume: %s", err)
+	}
+	if err := r.AddVolume(v); err != nil {
+		t.Fatalf("Failed adding volume: %s", err)
+	}
+	if c := store(v); c == a || c == b {
+		t.Error("Expected a new volume not to share any chunks")
+	}
+
+	r2, err := OpenRepository(dir, "this_is_a_password")
+	if err != nil {
+		t.Fatalf("Failed opening repository: %s", err)
+	}
+	if r2.DedupScope() != DedupVolume || r2.Volumes[0].DedupDomain != r2.Volumes[0].ID {
+		t.Errorf("Expected the deduplication scope to be stored, got %s", r2.DedupScope())
+	}
+}
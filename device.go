@@ -0,0 +1,72 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+// DefaultDiskConcurrency is the amount of files read in parallel from each
+// rotational disk, unless specified otherwise in StoreOptions.
+const DefaultDiskConcurrency = 1
+
+// deviceLimiter limits the amount of files read in parallel from each
+// rotational disk, which would otherwise keep seeking back and forth between
+// them. Files on flash storage, and on devices which can't be identified,
+// are read without limit.
+type deviceLimiter struct {
+	limit      uint
+	rotational func(dev uint64) bool
+
+	mut sync.Mutex
+	// slots maps the rotational devices to their semaphores, and all other
+	// devices to nil
+	slots map[uint64]chan struct{}
+}
+
+func newDeviceLimiter(limit uint) *deviceLimiter {
+	if limit == 0 {
+		limit = DefaultDiskConcurrency
+	}
+	return &deviceLimiter{
+		limit:      limit,
+		rotational: rotational,
+		slots:      make(map[uint64]chan struct{}),
+	}
+}
+
+// acquire waits until a file of device dev can be read and returns a
+// function releasing the slot again. It returns false if ctx is done before.
+func (l *deviceLimiter) acquire(ctx context.Context, dev uint64) (func(), bool) {
+	if dev == 0 {
+		return func() {}, true
+	}
+
+	l.mut.Lock()
+	slot, ok := l.slots[dev]
+	if !ok {
+		if l.rotational(dev) {
+			slot = make(chan struct{}, l.limit)
+			log.Debugf("Reading at most %d files in parallel from rotational device %s", l.limit, strconv.FormatUint(dev, 10))
+		}
+		l.slots[dev] = slot
+	}
+	l.mut.Unlock()
+
+	if slot == nil {
+		return func() {}, true
+	}
+	select {
+	case slot <- struct{}{}:
+		return func() { <-slot }, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
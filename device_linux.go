@@ -0,0 +1,28 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// rotational returns true if the block device dev is a spinning disk. The
+// queue of partitions is the one of the disk they're located on.
+func rotational(dev uint64) bool {
+	base := fmt.Sprintf("/sys/dev/block/%d:%d/", unix.Major(dev), unix.Minor(dev))
+	for _, path := range []string{base + "queue/rotational", base + "../queue/rotational"} {
+		if b, err := ioutil.ReadFile(path); err == nil {
+			return strings.TrimSpace(string(b)) == "1"
+		}
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+// rotational returns false, as spinning disks can't be detected on this
+// platform.
+func rotational(dev uint64) bool {
+	return false
+}
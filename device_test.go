@@ -0,0 +1,59 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDeviceLimiter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	l := newDeviceLimiter(2)
+	l.rotational = func(dev uint64) bool { return dev == 1 }
+
+	var releases []func()
+	for i := 0; i < 2; i++ {
+		release, ok := l.acquire(ctx, 1)
+		if !ok {
+			t.Fatal("Expected to read from the rotational device")
+		}
+		releases = append(releases, release)
+	}
+	// flash storage and unknown devices are never limited
+	for i := 0; i < 10; i++ {
+		if _, ok := l.acquire(ctx, 2); !ok {
+			t.Fatal("Expected to read from the flash device")
+		}
+		if _, ok := l.acquire(ctx, 0); !ok {
+			t.Fatal("Expected to read from the unknown device")
+		}
+	}
+
+	acquired := make(chan bool)
+	go func() {
+		_, ok := l.acquire(ctx, 1)
+		acquired <- ok
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("Expected the rotational device to be limited to 2 readers")
+	default:
+	}
+	releases[0]()
+	if !<-acquired {
+		t.Error("Expected to read from the rotational device once a slot has been released")
+	}
+
+	cancel()
+	if _, ok := l.acquire(ctx, 1); ok {
+		t.Error("Expected waiting for the rotational device to be aborted")
+	}
+}
@@ -0,0 +1,144 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"sort"
+)
+
+// Types of changes between two snapshots.
+const (
+	Added    = "added"
+	Removed  = "removed"
+	Modified = "modified"
+)
+
+// ArchiveDiff describes how an archive changed between two snapshots.
+type ArchiveDiff struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+	// Fields lists what changed for modified archives: "type", "size",
+	// "mode", "mtime" and "content"
+	Fields []string `json:"fields,omitempty"`
+	Old    *Archive `json:"-"`
+	New    *Archive `json:"-"`
+}
+
+// DiffSnapshots compares the archives of two snapshots and returns all
+// archives which have been added, removed or modified in b, sorted by path.
+func DiffSnapshots(a, b *Snapshot) []ArchiveDiff {
+	diffs := []ArchiveDiff{}
+
+	for path, old := range a.Archives {
+		current, ok := b.Archives[path]
+		if !ok {
+			diffs = append(diffs, ArchiveDiff{Path: path, Change: Removed, Old: old})
+			continue
+		}
+
+		if fields := diffArchive(old, current); len(fields) > 0 {
+			diffs = append(diffs, ArchiveDiff{Path: path, Change: Modified, Fields: fields, Old: old, New: current})
+		}
+	}
+	for path, current := range b.Archives {
+		if _, ok := a.Archives[path]; !ok {
+			diffs = append(diffs, ArchiveDiff{Path: path, Change: Added, New: current})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Path < diffs[j].Path
+	})
+	return diffs
+}
+
+// ChangeStats counts how the files of a snapshot changed since another one.
+type ChangeStats struct {
+	New        uint64 `json:"new"`
+	Changed    uint64 `json:"changed"`
+	Unmodified uint64 `json:"unmodified"`
+	Removed    uint64 `json:"removed"`
+}
+
+// CountChanges counts the files which have been added, modified or removed in
+// b since a, and the files which haven't been modified.
+func CountChanges(a, b *Snapshot) ChangeStats {
+	var stats ChangeStats
+	for path, current := range b.Archives {
+		old, ok := a.Archives[path]
+		switch {
+		case current.Type != File:
+			if ok && old.Type == File {
+				stats.Removed++
+			}
+		case !ok || old.Type != File:
+			stats.New++
+		case len(diffArchive(old, current)) > 0:
+			stats.Changed++
+		default:
+			stats.Unmodified++
+		}
+	}
+	for path, old := range a.Archives {
+		if _, ok := b.Archives[path]; !ok && old.Type == File {
+			stats.Removed++
+		}
+	}
+	return stats
+}
+
+// diffArchive returns which attributes of an archive differ.
+func diffArchive(a, b *Archive) []string {
+	fields := diffMetadata(a, b)
+	if a.PointsTo != b.PointsTo || !sameContent(a, b) {
+		fields = append(fields, "content")
+	}
+
+	return fields
+}
+
+// diffMetadata returns which attributes of an archive, except its content,
+// differ.
+func diffMetadata(a, b *Archive) []string {
+	var fields []string
+	if a.Type != b.Type {
+		fields = append(fields, "type")
+	}
+	if a.Size != b.Size {
+		fields = append(fields, "size")
+	}
+	if a.Mode != b.Mode {
+		fields = append(fields, "mode")
+	}
+	if a.ModTime != b.ModTime {
+		fields = append(fields, "mtime")
+	}
+
+	return fields
+}
+
+// sameContent returns true if both archives consist of the same data, no
+// matter how their chunks have been encoded.
+func sameContent(a, b *Archive) bool {
+	if len(a.Chunks) != len(b.Chunks) {
+		return false
+	}
+
+	chunks := make(map[uint]Chunk, len(a.Chunks))
+	for _, chunk := range a.Chunks {
+		chunks[chunk.Num] = chunk
+	}
+	for _, chunk := range b.Chunks {
+		c, ok := chunks[chunk.Num]
+		if !ok || c.DecryptedHash != chunk.DecryptedHash || c.Hole != chunk.Hole || c.OriginalSize != chunk.OriginalSize {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,160 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	file := func(path string, size uint64, mtime int64, hashes ...string) *Archive {
+		archive := &Archive{Path: path, Type: File, Mode: 0644, Size: size, ModTime: mtime}
+		for i, h := range hashes {
+			archive.Chunks = append(archive.Chunks, Chunk{Num: uint(i), DecryptedHash: h, Hash: h + "_encrypted"})
+		}
+		return archive
+	}
+
+	a := &Snapshot{Archives: map[string]*Archive{
+		"unchanged": file("unchanged", 1, 1, "x"),
+		"removed":   file("removed", 1, 1, "x"),
+		"touched":   file("touched", 1, 1, "x"),
+		"rewritten": file("rewritten", 2, 1, "x", "y"),
+		"chmod":     file("chmod", 1, 1, "x"),
+	}}
+	b := &Snapshot{Archives: map[string]*Archive{
+		"unchanged": file("unchanged", 1, 1, "x"),
+		"added":     file("added", 1, 1, "x"),
+		"touched":   file("touched", 1, 2, "x"),
+		"rewritten": file("rewritten", 2, 1, "x", "z"),
+		"chmod":     file("chmod", 1, 1, "x"),
+	}}
+	b.Archives["chmod"].Mode = 0600
+	// re-encrypted chunks don't change the content
+	b.Archives["unchanged"].Chunks[0].Hash = "reencrypted"
+
+	expected := []struct {
+		path   string
+		change string
+		fields []string
+	}{
+		{"added", Added, nil},
+		{"chmod", Modified, []string{"mode"}},
+		{"removed", Removed, nil},
+		{"rewritten", Modified, []string{"content"}},
+		{"touched", Modified, []string{"mtime"}},
+	}
+
+	diffs := DiffSnapshots(a, b)
+	if len(diffs) != len(expected) {
+		t.Fatalf("Expected %d changes, got %d: %v", len(expected), len(diffs), diffs)
+	}
+	for i, e := range expected {
+		d := diffs[i]
+		if d.Path != e.path || d.Change != e.change || !reflect.DeepEqual(d.Fields, e.fields) {
+			t.Errorf("Expected %s to be %s %v, got %s %s %v", e.path, e.change, e.fields, d.Path, d.Change, d.Fields)
+		}
+	}
+}
+
+func TestDiffLocal(t *testing.T) {
+	testPassword := "this_is_a_password"
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	src, err := ioutil.TempDir("", "knoxite.src")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(src, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"unchanged", "removed", "rewritten", "touched"} {
+		write(name, "some text")
+	}
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	snapshot, _ := NewSnapshot("test_snapshot")
+	opts := StoreOptions{
+		CWD:       src,
+		Paths:     []string{src},
+		Compress:  CompressionZstd,
+		Encrypt:   EncryptionAESGCM,
+		DataParts: 1,
+	}
+	for p := range snapshot.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Fatalf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+
+	if err := os.Remove(filepath.Join(src, "removed")); err != nil {
+		t.Fatal(err)
+	}
+	write("added", "new text")
+	// same size and modification time, only hashing detects the change
+	mtime := time.Unix(snapshot.Archives["rewritten"].ModTime, 0)
+	write("rewritten", "same size")
+	if err := os.Chtimes(filepath.Join(src, "rewritten"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	later := mtime.Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(src, "touched"), later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, hashContent := range []bool{false, true} {
+		expected := []struct {
+			path   string
+			change string
+			fields []string
+		}{
+			{"added", Added, nil},
+			{"removed", Removed, nil},
+			{"rewritten", Modified, []string{"content"}},
+			{"touched", Modified, []string{"mtime"}},
+		}
+		if !hashContent {
+			expected = append(expected[:2], expected[3])
+		}
+
+		diffs, err := DiffLocal(context.Background(), snapshot, opts, hashContent)
+		if err != nil {
+			t.Fatalf("Failed comparing snapshot: %s", err)
+		}
+		if len(diffs) != len(expected) {
+			t.Fatalf("Expected %d changes, got %d: %v", len(expected), len(diffs), diffs)
+		}
+		for i, e := range expected {
+			d := diffs[i]
+			if d.Path != e.path || d.Change != e.change || !reflect.DeepEqual(d.Fields, e.fields) {
+				t.Errorf("Expected %s to be %s %v, got %s %s %v", e.path, e.change, e.fields, d.Path, d.Change, d.Fields)
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"sort"
+)
+
+// DiffLocal compares the archives of snapshot with the items currently found
+// at opts.Paths, i.e. what storing them again would change. Only archives
+// located at opts.Paths are taken into account. Items get scanned like Add
+// does, so the excludes and limits of opts apply. Items skipped by the scanner
+// are left out, other errors abort the comparison.
+//
+// Files are compared by their size, mode, modification time and type. With
+// hashContent, files whose size didn't change get chunked with the chunker
+// settings of snapshot and compared by the hashes of their chunks, too.
+func DiffLocal(ctx context.Context, snapshot *Snapshot, opts StoreOptions, hashContent bool) ([]ArchiveDiff, error) {
+	if opts.Source == nil {
+		opts.Source = &SourceLocal{}
+	}
+	opts.Chunker = snapshot.ChunkerSettings()
+	opts.Compress = CompressionNone
+	opts.Encrypt = EncryptionNone
+	opts.DataParts = 1
+	opts.ParityParts = 0
+	opts.Concurrency = 1
+
+	local := &Snapshot{Archives: make(map[string]*Archive)}
+	for result := range local.gatherTargetInformation(ctx, opts) {
+		if result.Error != nil {
+			if IsSkipped(result.Error) {
+				continue
+			}
+			return nil, result.Error
+		}
+		local.Archives[result.Archive.Path] = result.Archive
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	diffs := []ArchiveDiff{}
+	for path, old := range snapshot.Archives {
+		if !withinPaths(path, opts.CWD, opts.Paths) {
+			continue
+		}
+		current, ok := local.Archives[path]
+		if !ok {
+			diffs = append(diffs, ArchiveDiff{Path: path, Change: Removed, Old: old})
+			continue
+		}
+
+		fields := diffMetadata(old, current)
+		changed := old.PointsTo != current.PointsTo
+		if !changed && hashContent && old.Type == File && current.Type == File && old.Size == current.Size {
+			chunks, err := localChunks(ctx, current, opts)
+			if err != nil {
+				return nil, err
+			}
+			changed = !sameContent(old, &Archive{Chunks: chunks})
+		}
+		if changed {
+			fields = append(fields, "content")
+		}
+		if len(fields) > 0 {
+			diffs = append(diffs, ArchiveDiff{Path: path, Change: Modified, Fields: fields, Old: old, New: current})
+		}
+	}
+	for path, current := range local.Archives {
+		if _, ok := snapshot.Archives[path]; !ok {
+			diffs = append(diffs, ArchiveDiff{Path: path, Change: Added, New: current})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Path < diffs[j].Path
+	})
+	return diffs, nil
+}
+
+// localChunks divides the data of a scanned file into chunks, as configured by
+// opts, without storing them.
+func localChunks(ctx context.Context, archive *Archive, opts StoreOptions) ([]Chunk, error) {
+	file, err := opts.Source.Open(ctx, archive.sourcePath)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := chunkFile(ctx, file, "", opts)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	var chunks []Chunk
+	for result := range ch {
+		if result.Error != nil {
+			err = result.Error
+			continue
+		}
+		result.Chunk.Data = nil
+		chunks = append(chunks, result.Chunk)
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	return chunks, err
+}
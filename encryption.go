@@ -8,21 +8,36 @@
 package knoxite
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/sha256"
 	"errors"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
 )
 
 // Available encryption algos.
 const (
 	EncryptionNone = iota
+	// EncryptionAES is AES-256 in CFB mode. It's used by repositories of
+	// version 4 and older
 	EncryptionAES
+	// EncryptionAESGCM is AES-256 in GCM mode, which authenticates the data
+	EncryptionAESGCM
+	// EncryptionX25519 seals the data for a public key, only the
+	// corresponding private key can decrypt it
+	EncryptionX25519
 )
 
 // Error declarations.
 var (
-	ErrInvalidPassword = errors.New("Empty password not permitted")
+	ErrInvalidPassword   = errors.New("Empty password not permitted")
+	ErrDecryptionFailed  = errors.New("Decryption failed, wrong password or corrupted data")
+	ErrInvalidKey        = errors.New("Invalid public or private key")
+	ErrUnknownEncryption = errors.New("Unknown encryption algo")
 )
 
 // Encryptor is a pipeline processor that encrypts data.
@@ -31,6 +46,33 @@ type Encryptor struct {
 
 	iv    []byte
 	block cipher.Block
+
+	aead     cipher.AEAD
+	nonceKey []byte
+
+	recipient *[32]byte
+}
+
+// newAEAD returns the AES-GCM cipher for a password, as well as the key used
+// to derive nonces.
+//
+// Nonces are derived from the data getting encrypted, so equal data results
+// in equal ciphertexts and can still be deduplicated. Nonces thus only ever
+// repeat for the very same data.
+func newAEAD(password string) (cipher.AEAD, []byte, error) {
+	key := sha256.Sum256([]byte(password))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte("knoxite nonce"))
+	return aead, mac.Sum(nil), nil
 }
 
 // NewEncryptor returns a newly configured Encryptor.
@@ -38,6 +80,9 @@ func NewEncryptor(method uint16, password string) (Encryptor, error) {
 	e := Encryptor{
 		Method: method,
 	}
+	if method > EncryptionX25519 {
+		return e, ErrUnknownEncryption
+	}
 	if method == EncryptionAES {
 		if len(password) == 0 {
 			return e, ErrInvalidPassword
@@ -52,6 +97,26 @@ func NewEncryptor(method uint16, password string) (Encryptor, error) {
 			return e, err
 		}
 	}
+	if method == EncryptionAESGCM {
+		if len(password) == 0 {
+			return e, ErrInvalidPassword
+		}
+
+		var err error
+		e.aead, e.nonceKey, err = newAEAD(password)
+		if err != nil {
+			return e, err
+		}
+	}
+	if method == EncryptionX25519 {
+		// the password is the public key to seal the data for
+		if len(password) != 32 {
+			return e, ErrInvalidKey
+		}
+
+		e.recipient = new([32]byte)
+		copy(e.recipient[:], password)
+	}
 
 	return e, nil
 }
@@ -61,6 +126,21 @@ func (e Encryptor) Process(data []byte) ([]byte, error) {
 	if e.Method == EncryptionNone {
 		return data, nil
 	}
+	if e.Method == EncryptionAESGCM {
+		mac := hmac.New(sha256.New, e.nonceKey)
+		mac.Write(data)
+		nonce := mac.Sum(nil)[:e.aead.NonceSize()]
+
+		// the nonce precedes the encrypted data and its authentication tag
+		return e.aead.Seal(nonce, nonce, data, nil), nil
+	}
+	if e.Method == EncryptionX25519 {
+		// like the nonces of AES-GCM, the ephemeral key is derived from the
+		// data, so equal data can still be deduplicated
+		mac := hmac.New(sha256.New, e.recipient[:])
+		mac.Write(data)
+		return box.SealAnonymous(nil, data, e.recipient, bytes.NewReader(mac.Sum(nil)))
+	}
 
 	b := make([]byte, len(data))
 	encrypter := cipher.NewCFBEncrypter(e.block, e.iv)
@@ -75,6 +155,11 @@ type Decryptor struct {
 
 	iv    []byte
 	block cipher.Block
+
+	aead cipher.AEAD
+
+	publicKey  *[32]byte
+	privateKey *[32]byte
 }
 
 // NewDecryptor returns a newly configured Decryptor.
@@ -82,6 +167,9 @@ func NewDecryptor(method uint16, password string) (Decryptor, error) {
 	e := Decryptor{
 		Method: method,
 	}
+	if method > EncryptionX25519 {
+		return e, ErrUnknownEncryption
+	}
 	if method == EncryptionAES {
 		if len(password) == 0 {
 			return e, ErrInvalidPassword
@@ -96,6 +184,28 @@ func NewDecryptor(method uint16, password string) (Decryptor, error) {
 			return e, err
 		}
 	}
+	if method == EncryptionAESGCM {
+		if len(password) == 0 {
+			return e, ErrInvalidPassword
+		}
+
+		var err error
+		e.aead, _, err = newAEAD(password)
+		if err != nil {
+			return e, err
+		}
+	}
+	if method == EncryptionX25519 {
+		// the password is the private key
+		if len(password) != 32 {
+			return e, ErrInvalidKey
+		}
+
+		e.publicKey = new([32]byte)
+		e.privateKey = new([32]byte)
+		copy(e.privateKey[:], password)
+		curve25519.ScalarBaseMult(e.publicKey, e.privateKey)
+	}
 
 	return e, nil
 }
@@ -105,6 +215,24 @@ func (e Decryptor) Process(data []byte) ([]byte, error) {
 	if e.Method == EncryptionNone {
 		return data, nil
 	}
+	if e.Method == EncryptionAESGCM {
+		n := e.aead.NonceSize()
+		if len(data) < n+e.aead.Overhead() {
+			return nil, ErrDecryptionFailed
+		}
+		b, err := e.aead.Open(nil, data[:n], data[n:], nil)
+		if err != nil {
+			return nil, ErrDecryptionFailed
+		}
+		return b, nil
+	}
+	if e.Method == EncryptionX25519 {
+		b, ok := box.OpenAnonymous(nil, data, e.publicKey, e.privateKey)
+		if !ok {
+			return nil, ErrDecryptionFailed
+		}
+		return b, nil
+	}
 
 	b := make([]byte, len(data))
 	decrypter := cipher.NewCFBDecrypter(e.block, e.iv)
@@ -49,3 +49,73 @@ func TestEmptyPassword(t *testing.T) {
 		t.Errorf("Expected %v, got %v", ErrInvalidPassword, err)
 	}
 }
+
+func TestEncryptionAESGCM(t *testing.T) {
+	testPassword := "this_is_a_password"
+	b := []byte("1234567890")
+
+	epipe, err := NewEncodingPipeline(CompressionNone, EncryptionAESGCM, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	be, err := epipe.Process(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// equal data needs to result in equal chunks to be deduplicated
+	be2, _ := epipe.Process(b)
+	if string(be) != string(be2) {
+		t.Error("Expected equal data to be encrypted equally")
+	}
+	other, _ := epipe.Process([]byte("0987654321"))
+	if string(be[:12]) == string(other[:12]) {
+		t.Error("Expected different data to use different nonces")
+	}
+
+	dpipe, err := NewDecodingPipeline(CompressionNone, EncryptionAESGCM, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bd, err := dpipe.Process(be)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != string(bd) {
+		t.Error("Data mismatch after encryption & decryption cycle.")
+	}
+
+	be[len(be)-1] ^= 1
+	if _, err := dpipe.Process(be); err != ErrDecryptionFailed {
+		t.Errorf("Expected %v for tampered data, got %v", ErrDecryptionFailed, err)
+	}
+	if _, err := dpipe.Process(be[:4]); err != ErrDecryptionFailed {
+		t.Errorf("Expected %v for truncated data, got %v", ErrDecryptionFailed, err)
+	}
+
+	dpipe, _ = NewDecodingPipeline(CompressionNone, EncryptionAESGCM, "wrong_password")
+	if _, err := dpipe.Process(be2); err != ErrDecryptionFailed {
+		t.Errorf("Expected %v for wrong password, got %v", ErrDecryptionFailed, err)
+	}
+}
+
+func TestDecodeLegacyMetadata(t *testing.T) {
+	testPassword := "this_is_a_password"
+	v := map[string]string{"key": "value"}
+
+	for _, version := range []uint{4, RepositoryVersion} {
+		r := Repository{Version: version}
+		b, err := r.encodeMetadata(CompressionLZMA, testPassword, v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var d map[string]string
+		if err := decodeMetadata(CompressionLZMA, testPassword, b, &d); err != nil || d["key"] != "value" {
+			t.Errorf("Failed decoding metadata of repository version %d: %v", version, err)
+		}
+		if err := decodeMetadata(CompressionLZMA, "wrong_password", b, &d); err == nil {
+			t.Errorf("Expected decoding metadata of repository version %d with wrong password to fail", version)
+		}
+	}
+}
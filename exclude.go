@@ -0,0 +1,331 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// IgnoreFilename is the name of files with exclude patterns, which apply
+	// to the directory they're stored in.
+	IgnoreFilename = ".knoxiteignore"
+
+	// CacheDirTagFilename marks directories containing cached data, see
+	// https://bford.info/cachedir/
+	CacheDirTagFilename = "CACHEDIR.TAG"
+
+	cacheDirTagSignature = "Signature: 8a477f597d28d172789f06886806bc55"
+)
+
+// ignorePattern is a single gitignore-style pattern.
+type ignorePattern struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreRules are the patterns of a single pattern file. Later patterns take
+// precedence over earlier ones.
+type ignoreRules []ignorePattern
+
+// parseIgnorePattern parses a single line of a pattern file. It returns false
+// for blank lines and comments.
+func parseIgnorePattern(line string) (ignorePattern, bool, error) {
+	p := ignorePattern{}
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return p, false, nil
+	}
+
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return p, false, nil
+	}
+
+	// patterns containing a slash are relative to the pattern file, all
+	// others match at any depth
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+
+	var re strings.Builder
+	re.WriteString("^")
+	if !anchored {
+		re.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case strings.HasPrefix(line[i:], "**/"):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(line[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(line[i+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			class := line[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(line):
+			i++
+			re.WriteString(regexp.QuoteMeta(string(line[i])))
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("$")
+
+	var err error
+	p.re, err = regexp.Compile(re.String())
+	return p, true, err
+}
+
+// parseIgnoreRules parses the content of a pattern file.
+func parseIgnoreRules(b []byte) (ignoreRules, error) {
+	var rules ignoreRules
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		p, ok, err := parseIgnorePattern(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rules = append(rules, p)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+// loadIgnoreFile loads gitignore-style exclude patterns from a file.
+func loadIgnoreFile(path string) (ignoreRules, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseIgnoreRules(b)
+}
+
+// match reports whether rel, a slash-separated path relative to the pattern
+// file, is excluded. decided is false if no pattern matches rel at all.
+func (rules ignoreRules) match(rel string, isDir bool) (excluded bool, decided bool) {
+	for _, p := range rules {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.re.MatchString(rel) {
+			excluded = !p.negate
+			decided = true
+		}
+	}
+	return
+}
+
+// globs are patterns given on the command line, which get matched against
+// the paths of a snapshot's items.
+type globs ignoreRules
+
+// compileGlobs compiles patterns with gitignore-style wildcards.
+func compileGlobs(patterns []string) (globs, error) {
+	var g globs
+	for _, pattern := range patterns {
+		p, ok, err := parseIgnorePattern(strings.ToLower(pattern))
+		if err != nil || !ok {
+			return nil, fmt.Errorf("invalid pattern %s", pattern)
+		}
+		p.negate = false
+		g = append(g, p)
+	}
+	return g, nil
+}
+
+// match reports whether path matches one of the patterns. With parents set,
+// it also reports true if one of path's parent directories matches.
+func (g globs) match(path string, parents bool) bool {
+	path = strings.TrimLeft(filepath.ToSlash(strings.ToLower(path)), "/")
+	for path != "" && path != "." {
+		for _, p := range g {
+			if p.re.MatchString(path) {
+				return true
+			}
+		}
+		if !parents {
+			break
+		}
+		path = pathpkg.Dir(path)
+	}
+	return false
+}
+
+// excludeFilter decides which entries get skipped while scanning.
+type excludeFilter struct {
+	globs   []string
+	rules   ignoreRules
+	markers []string
+	caches  bool
+	// oneFileSystem stops the scanner at mount points
+	oneFileSystem bool
+}
+
+// newExcludeFilter creates a filter for the excludes of a store operation.
+func newExcludeFilter(opts StoreOptions) (*excludeFilter, error) {
+	f := &excludeFilter{
+		globs:   opts.Excludes,
+		markers: opts.ExcludeMarkers,
+		caches:  opts.ExcludeCaches,
+
+		oneFileSystem: opts.OneFileSystem,
+	}
+	for _, path := range opts.ExcludeFiles {
+		rules, err := loadIgnoreFile(path)
+		if err != nil {
+			return nil, err
+		}
+		f.rules = append(f.rules, rules...)
+	}
+
+	return f, nil
+}
+
+// matchGlobs reports whether path or its base name matches one of the
+// filter's shell patterns.
+func (f *excludeFilter) matchGlobs(path string) (bool, error) {
+	for _, exclude := range f.globs {
+		match, err := filepath.Match(strings.ToLower(exclude), strings.ToLower(path))
+		if err != nil {
+			return false, fmt.Errorf("invalid exclude filter %s: %v", exclude, err)
+		}
+		if !match {
+			match, _ = filepath.Match(strings.ToLower(exclude), strings.ToLower(filepath.Base(path)))
+		}
+		if match {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isMarked reports whether dir contains one of the filter's marker files or
+// a valid CACHEDIR.TAG.
+func (f *excludeFilter) isMarked(dir string) bool {
+	for _, marker := range f.markers {
+		if _, err := os.Lstat(filepath.Join(dir, marker)); err == nil {
+			return true
+		}
+	}
+	if !f.caches {
+		return false
+	}
+
+	fd, err := os.Open(filepath.Join(dir, CacheDirTagFilename))
+	if err != nil {
+		return false
+	}
+	defer fd.Close()
+	b := make([]byte, len(cacheDirTagSignature))
+	n, _ := fd.Read(b)
+	return string(b[:n]) == cacheDirTagSignature
+}
+
+// walkFilter applies an excludeFilter during a single walk of rootPath. It
+// also honors the pattern files found in the walked directories.
+type walkFilter struct {
+	*excludeFilter
+	rootPath string
+	dirRules map[string]ignoreRules
+}
+
+func (f *excludeFilter) walk(rootPath string) *walkFilter {
+	return &walkFilter{
+		excludeFilter: f,
+		rootPath:      rootPath,
+		dirRules:      make(map[string]ignoreRules),
+	}
+}
+
+// excluded reports whether path gets skipped. Directories which don't get
+// skipped have their pattern file loaded, so it applies to their content.
+func (w *walkFilter) excluded(path string, fi os.FileInfo) (bool, error) {
+	match, err := w.matchGlobs(path)
+	if err != nil || match {
+		return match, err
+	}
+
+	// patterns of the exclude files are relative to the walked path, those
+	// of pattern files in sub-directories take precedence
+	if rel, err := filepath.Rel(w.rootPath, path); err == nil && rel != "." {
+		match, _ = w.rules.match(filepath.ToSlash(rel), fi.IsDir())
+	}
+	var dirs []string
+	for dir := filepath.Dir(path); strings.HasPrefix(dir, w.rootPath); dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == w.rootPath || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rules, ok := w.dirRules[dirs[i]]
+		if !ok {
+			continue
+		}
+		rel, err := filepath.Rel(dirs[i], path)
+		if err != nil {
+			continue
+		}
+		if m, decided := rules.match(filepath.ToSlash(rel), fi.IsDir()); decided {
+			match = m
+		}
+	}
+	if match {
+		return true, nil
+	}
+
+	if fi.IsDir() {
+		if w.isMarked(path) {
+			return true, nil
+		}
+
+		rules, err := loadIgnoreFile(filepath.Join(path, IgnoreFilename))
+		if err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+		if len(rules) > 0 {
+			w.dirRules[path] = rules
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,134 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestIgnorePatterns(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		path     string
+		isDir    bool
+		excluded bool
+		decided  bool
+	}{
+		{"*.log", "a.log", false, true, true},
+		{"*.log", "dir/a.log", false, true, true},
+		{"*.log", "a.txt", false, false, false},
+		{"/build", "build", true, true, true},
+		{"/build", "src/build", true, false, false},
+		{"build/", "src/build", true, true, true},
+		{"build/", "src/build", false, false, false},
+		{"docs/*.md", "docs/a.md", false, true, true},
+		{"docs/*.md", "docs/sub/a.md", false, false, false},
+		{"docs/**/*.md", "docs/sub/deep/a.md", false, true, true},
+		{"**/node_modules", "a/b/node_modules", true, true, true},
+		{"tmp/**", "tmp/a/b", false, true, true},
+		{"file?.[ch]", "file1.c", false, true, true},
+		{"file[!0-9]", "file1", false, false, false},
+		{"!keep.log", "keep.log", false, false, true},
+		{`\!bang`, "!bang", false, true, true},
+		{"# comment", "# comment", false, false, false},
+	}
+
+	for _, tt := range tests {
+		rules, err := parseIgnoreRules([]byte(tt.pattern))
+		if err != nil {
+			t.Fatalf("Failed parsing pattern %s: %s", tt.pattern, err)
+		}
+		excluded, decided := rules.match(tt.path, tt.isDir)
+		if excluded != tt.excluded || decided != tt.decided {
+			t.Errorf("Pattern %s on %s: expected %v/%v, got %v/%v",
+				tt.pattern, tt.path, tt.excluded, tt.decided, excluded, decided)
+		}
+	}
+}
+
+func TestExcludeFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite.exclude")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"keep.txt":               "",
+		"debug.log":              "",
+		"important.log":          "",
+		"src/main.go":            "",
+		"src/main.o":             "",
+		"src/gen/.knoxiteignore": "*.go\n",
+		"src/gen/gen.go":         "",
+		"src/gen/README":         "",
+		"cache/CACHEDIR.TAG":     cacheDirTagSignature + "\n",
+		"cache/blob":             "",
+		"fake/CACHEDIR.TAG":      "not a cache",
+		"fake/blob":              "",
+		"marked/.nobackup":       "",
+		"marked/secret":          "",
+		".knoxiteignore":         "*.o\n!important.log\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed creating dir: %s", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed writing file: %s", err)
+		}
+	}
+	excludeFile := filepath.Join(dir, "..", filepath.Base(dir)+".ignore")
+	if err := ioutil.WriteFile(excludeFile, []byte("*.log\n"), 0644); err != nil {
+		t.Fatalf("Failed writing exclude file: %s", err)
+	}
+	defer os.Remove(excludeFile)
+
+	filter, err := newExcludeFilter(StoreOptions{
+		ExcludeFiles:   []string{excludeFile},
+		ExcludeMarkers: []string{".nobackup"},
+		ExcludeCaches:  true,
+	})
+	if err != nil {
+		t.Fatalf("Failed creating filter: %s", err)
+	}
+
+	var found []string
+	for result := range findFiles(context.Background(), dir, filter, ScanLimits{}, false, false) {
+		if result.Error != nil {
+			t.Fatalf("Failed scanning: %s", result.Error)
+		}
+		if result.Archive.Type == Directory {
+			continue
+		}
+		rel, _ := filepath.Rel(dir, result.Archive.Path)
+		found = append(found, filepath.ToSlash(rel))
+	}
+	sort.Strings(found)
+
+	expected := []string{
+		".knoxiteignore",
+		"fake/CACHEDIR.TAG",
+		"fake/blob",
+		"important.log",
+		"keep.txt",
+		"src/gen/.knoxiteignore",
+		"src/gen/README",
+		"src/main.go",
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("Expected %v, got %v", expected, found)
+	}
+}
@@ -0,0 +1,289 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Formats snapshots can be exported to.
+const (
+	FormatTar     = "tar"
+	FormatTarGzip = "tar.gz"
+	FormatTarZstd = "tar.zst"
+	// FormatZip switches to ZIP64 extensions on its own, when the archive
+	// contains files larger than 4 GiB or more than 65535 entries
+	FormatZip = "zip"
+)
+
+// ErrUnknownArchiveFormat is returned when exporting to an unsupported format.
+var ErrUnknownArchiveFormat = errors.New("Unknown archive format")
+
+// ArchiveFormatFromPath guesses the format of an archive from its file name.
+// It returns an empty string if the format can't be told.
+func ArchiveFormatFromPath(path string) string {
+	path = strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(path, ".tar"):
+		return FormatTar
+	case strings.HasSuffix(path, ".tar.gz"), strings.HasSuffix(path, ".tgz"):
+		return FormatTarGzip
+	case strings.HasSuffix(path, ".tar.zst"), strings.HasSuffix(path, ".tzst"):
+		return FormatTarZstd
+	case strings.HasSuffix(path, ".zip"):
+		return FormatZip
+	}
+	return ""
+}
+
+// archiveWriter writes the items of a snapshot to an archive.
+type archiveWriter interface {
+	// WriteHeader starts a new entry, the content of files gets written to
+	// the returned writer
+	WriteHeader(arc *Archive, name string) (io.Writer, error)
+	Close() error
+}
+
+// ExportSnapshot writes the items of a snapshot, selected by opts, as an
+// archive to w, without restoring them to disk first. The content of files
+// gets streamed chunk by chunk. Exporting stops at the first error, as the
+// archive would be incomplete anyway.
+func ExportSnapshot(ctx context.Context, repository Repository, snapshot *Snapshot, w io.Writer, format string, opts RestoreOptions) (<-chan Progress, error) {
+	var aw archiveWriter
+	switch format {
+	case FormatTar:
+		aw = newTarWriter(w, nil)
+	case FormatTarGzip:
+		gw := gzip.NewWriter(w)
+		aw = newTarWriter(gw, gw)
+	case FormatTarZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		aw = newTarWriter(zw, zw)
+	case FormatZip:
+		aw = &zipWriter{zw: zip.NewWriter(w)}
+	default:
+		return nil, ErrUnknownArchiveFormat
+	}
+
+	archives, err := SelectArchives(snapshot, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	prog := make(chan Progress)
+	go func() {
+		defer close(prog)
+
+		for _, arc := range archives {
+			if ctx.Err() != nil {
+				return
+			}
+			if err := exportArchive(ctx, prog, repository, aw, *arc, opts.Timings); err != nil {
+				p := newProgressError(err)
+				p.Path = arc.Path
+				sendProgress(ctx, prog, p)
+				return
+			}
+		}
+		if err := aw.Close(); err != nil {
+			sendProgress(ctx, prog, newProgressError(err))
+		}
+	}()
+
+	return throttleProgress(ctx, prog, opts.ProgressInterval), nil
+}
+
+// archiveName turns the path of an item into a relative, slash-separated name
+// inside an archive.
+func archiveName(path string) string {
+	name := filepath.ToSlash(path)
+	if vol := filepath.VolumeName(path); vol != "" {
+		name = name[len(vol):]
+	}
+	return strings.TrimLeft(name, "/")
+}
+
+func exportArchive(ctx context.Context, progress chan<- Progress, repository Repository, aw archiveWriter, arc Archive, timings *Timings) error {
+	p := newProgress(&arc)
+
+	w, err := aw.WriteHeader(&arc, archiveName(arc.Path))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case arc.Type == Directory:
+		p.TotalStatistics.Dirs++
+	case arc.Type == SymLink:
+		p.TotalStatistics.SymLinks++
+	case w == nil:
+		// a hard link to an already exported file
+		p.TotalStatistics.Files++
+		p.CurrentItemStats.Transferred = arc.Size
+		p.TotalStatistics.Transferred = arc.Size
+	default:
+		p.TotalStatistics.Files++
+		p.TotalStatistics.StorageSize = arc.StorageSize
+		if !sendProgress(ctx, progress, p) {
+			return ctx.Err()
+		}
+
+		for i := uint(0); i < uint(len(arc.Chunks)); i++ {
+			idx, err := arc.IndexOfChunk(i)
+			if err != nil {
+				return err
+			}
+
+			b, err := loadChunk(ctx, repository, arc, arc.Chunks[idx], timings)
+			if err != nil {
+				return err
+			}
+			start := time.Now()
+			n, err := w.Write(b)
+			timings.since(StageWriting, start)
+			if err != nil {
+				return err
+			}
+
+			p.TotalStatistics.Transferred += uint64(n)
+			p.CurrentItemStats.Transferred += uint64(n)
+			if !sendProgress(ctx, progress, p) {
+				return ctx.Err()
+			}
+		}
+		return nil
+	}
+
+	if !sendProgress(ctx, progress, p) {
+		return ctx.Err()
+	}
+	return nil
+}
+
+type tarWriter struct {
+	tw *tar.Writer
+	// compressor gets closed after the tar stream, unless it's nil
+	compressor io.Closer
+	// exported keeps track of the files hard links can refer to
+	exported map[string]bool
+}
+
+func newTarWriter(w io.Writer, compressor io.Closer) *tarWriter {
+	return &tarWriter{
+		tw:         tar.NewWriter(w),
+		compressor: compressor,
+		exported:   make(map[string]bool),
+	}
+}
+
+func (t *tarWriter) WriteHeader(arc *Archive, name string) (io.Writer, error) {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    int64(arc.Mode.Perm()),
+		ModTime: time.Unix(arc.ModTime, 0),
+		Uid:     int(arc.UID),
+		Gid:     int(arc.GID),
+		Format:  tar.FormatPAX,
+	}
+	if arc.Mode&os.ModeSetuid != 0 {
+		hdr.Mode |= 04000
+	}
+	if arc.Mode&os.ModeSetgid != 0 {
+		hdr.Mode |= 02000
+	}
+	if arc.Mode&os.ModeSticky != 0 {
+		hdr.Mode |= 01000
+	}
+
+	var content bool
+	switch {
+	case arc.Type == Directory:
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+	case arc.Type == SymLink:
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = arc.PointsTo
+	case arc.LinkTo != "" && t.exported[arc.LinkTo]:
+		hdr.Typeflag = tar.TypeLink
+		hdr.Linkname = archiveName(arc.LinkTo)
+	default:
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = int64(arc.Size)
+		t.exported[arc.Path] = true
+		content = true
+	}
+
+	if err := t.tw.WriteHeader(hdr); err != nil {
+		return nil, err
+	}
+	if !content {
+		return nil, nil
+	}
+	return t.tw, nil
+}
+
+func (t *tarWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	if t.compressor != nil {
+		return t.compressor.Close()
+	}
+	return nil
+}
+
+type zipWriter struct {
+	zw *zip.Writer
+}
+
+func (z *zipWriter) WriteHeader(arc *Archive, name string) (io.Writer, error) {
+	hdr := &zip.FileHeader{
+		Name:     name,
+		Modified: time.Unix(arc.ModTime, 0),
+		Method:   zip.Deflate,
+	}
+
+	switch arc.Type {
+	case Directory:
+		hdr.Name += "/"
+		hdr.SetMode(arc.Mode | os.ModeDir)
+		_, err := z.zw.CreateHeader(hdr)
+		return nil, err
+	case SymLink:
+		// zip stores the target of a symlink as its content
+		hdr.SetMode(arc.Mode | os.ModeSymlink)
+		w, err := z.zw.CreateHeader(hdr)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.WriteString(w, arc.PointsTo)
+		return nil, err
+	}
+
+	// zip doesn't know hard links, so they get exported as regular files
+	hdr.SetMode(arc.Mode)
+	return z.zw.CreateHeader(hdr)
+}
+
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
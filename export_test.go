@@ -0,0 +1,201 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestExportSnapshot(t *testing.T) {
+	testPassword := "this_is_a_password"
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	src, err := ioutil.TempDir("", "knoxite.src")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	files := map[string][]byte{
+		"a.txt":        []byte("some text"),
+		"sub/data.bin": bytes.Repeat([]byte("0123456789"), 300000),
+	}
+	for name, content := range files {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	snapshot, _ := NewSnapshot("test_snapshot")
+	opts := StoreOptions{
+		CWD:       src,
+		Paths:     []string{src},
+		Compress:  CompressionZstd,
+		Encrypt:   EncryptionAESGCM,
+		DataParts: 1,
+	}
+	for p := range snapshot.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Fatalf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+
+	export := func(format string) []byte {
+		var buf bytes.Buffer
+		progress, err := ExportSnapshot(context.Background(), r, snapshot, &buf, format, RestoreOptions{})
+		if err != nil {
+			t.Fatalf("Failed exporting snapshot: %s", err)
+		}
+		for p := range progress {
+			if p.Error != nil {
+				t.Fatalf("Failed exporting snapshot: %s", p.Error)
+			}
+		}
+		return buf.Bytes()
+	}
+
+	compareExported(t, FormatTar, files, readTar(t, bytes.NewReader(export(FormatTar))))
+
+	gr, err := gzip.NewReader(bytes.NewReader(export(FormatTarGzip)))
+	if err != nil {
+		t.Fatalf("Failed decompressing tar.gz archive: %s", err)
+	}
+	compareExported(t, FormatTarGzip, files, readTar(t, gr))
+
+	zsr, err := zstd.NewReader(bytes.NewReader(export(FormatTarZstd)))
+	if err != nil {
+		t.Fatalf("Failed decompressing tar.zst archive: %s", err)
+	}
+	compareExported(t, FormatTarZstd, files, readTar(t, zsr))
+	zsr.Close()
+
+	b := export(FormatZip)
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatalf("Failed reading zip archive: %s", err)
+	}
+	found := make(map[string][]byte)
+	for _, f := range zr.File {
+		if f.Mode().IsRegular() {
+			rc, err := f.Open()
+			if err != nil {
+				t.Fatalf("Failed reading %s from zip archive: %s", f.Name, err)
+			}
+			found[f.Name], _ = ioutil.ReadAll(rc)
+			rc.Close()
+		}
+	}
+	compareExported(t, FormatZip, files, found)
+
+	if _, err := ExportSnapshot(context.Background(), r, snapshot, ioutil.Discard, "rar", RestoreOptions{}); err != ErrUnknownArchiveFormat {
+		t.Errorf("Expected %v, got %v", ErrUnknownArchiveFormat, err)
+	}
+}
+
+func TestExportZip64(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode")
+	}
+
+	// more entries than a zip archive without ZIP64 extensions can hold
+	const entries = 70000
+	var buf bytes.Buffer
+	z := &zipWriter{zw: zip.NewWriter(&buf)}
+	for i := 0; i < entries; i++ {
+		arc := &Archive{Path: fmt.Sprintf("dir/%d", i), Type: Directory, Mode: 0755}
+		if _, err := z.WriteHeader(arc, archiveName(arc.Path)); err != nil {
+			t.Fatalf("Failed writing zip entry: %s", err)
+		}
+	}
+	if err := z.Close(); err != nil {
+		t.Fatalf("Failed closing zip archive: %s", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Failed reading zip archive: %s", err)
+	}
+	if len(zr.File) != entries {
+		t.Errorf("Expected %d entries in zip archive, got %d", entries, len(zr.File))
+	}
+}
+
+func TestArchiveFormatFromPath(t *testing.T) {
+	tests := map[string]string{
+		"home.tar":         FormatTar,
+		"home.TGZ":         FormatTarGzip,
+		"/tmp/home.tar.gz": FormatTarGzip,
+		"home.tar.zst":     FormatTarZstd,
+		"home.tzst":        FormatTarZstd,
+		"home.zip":         FormatZip,
+		"home.rar":         "",
+		"home":             "",
+	}
+	for path, exp := range tests {
+		if format := ArchiveFormatFromPath(path); format != exp {
+			t.Errorf("Expected format %q for %s, got %q", exp, path, format)
+		}
+	}
+}
+
+func readTar(t *testing.T, r io.Reader) map[string][]byte {
+	found := make(map[string][]byte)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed reading tar archive: %s", err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			found[hdr.Name], _ = ioutil.ReadAll(tr)
+		}
+	}
+	return found
+}
+
+func compareExported(t *testing.T, format string, expected, found map[string][]byte) {
+	if len(found) != len(expected) {
+		t.Errorf("Expected %d files in %s archive, got %d", len(expected), format, len(found))
+	}
+	for name, content := range expected {
+		if !bytes.Equal(found[name], content) {
+			t.Errorf("Unexpected content of %s in %s archive", name, format)
+		}
+	}
+}
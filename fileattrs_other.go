@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"os"
+)
+
+// readFileAttributes does nothing, items only have Windows attributes,
+// creation times and alternate data streams on Windows.
+func readFileAttributes(path string, fi os.FileInfo, arc *Archive, streams bool) {}
+
+// writeFileAttributes does nothing, Windows attributes, creation times and
+// alternate data streams can only be restored on Windows.
+func writeFileAttributes(path string, arc *Archive) error {
+	return nil
+}
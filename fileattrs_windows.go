@@ -0,0 +1,121 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	modkernel32          = windows.NewLazySystemDLL("kernel32.dll")
+	procFindFirstStreamW = modkernel32.NewProc("FindFirstStreamW")
+	procFindNextStreamW  = modkernel32.NewProc("FindNextStreamW")
+)
+
+// settableAttributes are the attributes which can be changed with
+// SetFileAttributes.
+const settableAttributes = windows.FILE_ATTRIBUTE_READONLY | windows.FILE_ATTRIBUTE_HIDDEN |
+	windows.FILE_ATTRIBUTE_SYSTEM | windows.FILE_ATTRIBUTE_ARCHIVE | windows.FILE_ATTRIBUTE_TEMPORARY |
+	windows.FILE_ATTRIBUTE_OFFLINE | windows.FILE_ATTRIBUTE_NOT_CONTENT_INDEXED
+
+// win32FindStreamData is a WIN32_FIND_STREAM_DATA structure.
+type win32FindStreamData struct {
+	StreamSize int64
+	StreamName [windows.MAX_PATH + 36]uint16
+}
+
+// readFileAttributes reads the attributes, creation time and optionally the
+// alternate data streams of an item.
+func readFileAttributes(path string, fi os.FileInfo, arc *Archive, streams bool) {
+	if d, ok := fi.Sys().(*syscall.Win32FileAttributeData); ok {
+		arc.Attributes = d.FileAttributes
+		arc.CreationTime = time.Unix(0, d.CreationTime.Nanoseconds()).Unix()
+	}
+	if streams && fi.Mode()&os.ModeSymlink == 0 {
+		arc.Streams = readStreams(path)
+	}
+}
+
+// readStreams returns the content of all alternate data streams of path.
+func readStreams(path string) map[string][]byte {
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil
+	}
+
+	var data win32FindStreamData
+	h, _, _ := procFindFirstStreamW.Call(uintptr(unsafe.Pointer(p)), 0, uintptr(unsafe.Pointer(&data)), 0)
+	if windows.Handle(h) == windows.InvalidHandle {
+		return nil
+	}
+	defer windows.FindClose(windows.Handle(h))
+
+	var streams map[string][]byte
+	for {
+		// stream names look like ":name:$DATA", the file's content is "::$DATA"
+		name := strings.TrimSuffix(strings.TrimPrefix(windows.UTF16ToString(data.StreamName[:]), ":"), ":$DATA")
+		if name != "" {
+			if b, err := ioutil.ReadFile(path + ":" + name); err == nil {
+				if streams == nil {
+					streams = make(map[string][]byte)
+				}
+				streams[name] = b
+			}
+		}
+
+		if r, _, _ := procFindNextStreamW.Call(h, uintptr(unsafe.Pointer(&data))); r == 0 {
+			break
+		}
+	}
+
+	return streams
+}
+
+// writeFileAttributes restores the alternate data streams, creation time and
+// attributes of an item. The attributes get restored last, as they can make
+// the item read-only.
+func writeFileAttributes(path string, arc *Archive) error {
+	if arc.Type == SymLink {
+		return nil
+	}
+
+	for name, data := range arc.Streams {
+		if err := ioutil.WriteFile(path+":"+name, data, arc.Mode.Perm()); err != nil {
+			return err
+		}
+	}
+
+	p, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	if arc.CreationTime != 0 {
+		h, err := windows.CreateFile(p, windows.FILE_WRITE_ATTRIBUTES, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE,
+			nil, windows.OPEN_EXISTING, windows.FILE_FLAG_BACKUP_SEMANTICS, 0)
+		if err != nil {
+			return err
+		}
+		ctime := windows.NsecToFiletime(time.Unix(arc.CreationTime, 0).UnixNano())
+		err = windows.SetFileTime(h, &ctime, nil, nil)
+		windows.CloseHandle(h)
+		if err != nil {
+			return err
+		}
+	}
+	if attrs := arc.Attributes & settableAttributes; attrs != 0 {
+		return windows.SetFileAttributes(p, attrs)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestFileAttributesRestore(t *testing.T) {
+	src, err := ioutil.TempDir("", "knoxite.source")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	file := filepath.Join(src, "file")
+	if err := ioutil.WriteFile(file, []byte("content"), 0600); err != nil {
+		t.Fatalf("Failed creating file: %s", err)
+	}
+	if err := ioutil.WriteFile(file+":knoxite", []byte("stream"), 0600); err != nil {
+		t.Skipf("Alternate data streams are not supported: %s", err)
+	}
+	p, _ := windows.UTF16PtrFromString(file)
+	if err := windows.SetFileAttributes(p, windows.FILE_ATTRIBUTE_HIDDEN); err != nil {
+		t.Fatalf("Failed setting file attributes: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, "this_is_a_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	snapshot, err := NewSnapshot("test_snapshot")
+	if err != nil {
+		t.Fatalf("Failed creating snapshot: %s", err)
+	}
+
+	opts := StoreOptions{
+		CWD:              src,
+		Paths:            []string{src},
+		Encrypt:          EncryptionAES,
+		DataParts:        1,
+		AlternateStreams: true,
+	}
+	for p := range snapshot.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Errorf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+	arc := snapshot.Archives["file"]
+	if arc.Attributes&windows.FILE_ATTRIBUTE_HIDDEN == 0 || arc.CreationTime == 0 {
+		t.Errorf("Expected attributes and creation time to be stored, got %d, %d", arc.Attributes, arc.CreationTime)
+	}
+	if string(arc.Streams["knoxite"]) != "stream" {
+		t.Fatalf("Expected alternate data stream to be stored, got %v", arc.Streams)
+	}
+
+	targetdir, err := ioutil.TempDir("", "knoxite.target")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for restore: %s", err)
+	}
+	defer os.RemoveAll(targetdir)
+
+	progress, err := DecodeSnapshot(context.Background(), r, snapshot, targetdir, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Failed restoring snapshot: %s", err)
+	}
+	for p := range progress {
+		if p.Error != nil {
+			t.Errorf("Failed restoring snapshot: %s", p.Error)
+		}
+	}
+
+	restored := filepath.Join(targetdir, "file")
+	fi, err := os.Stat(restored)
+	if err != nil {
+		t.Fatalf("Failed restoring file: %s", err)
+	}
+	d := fi.Sys().(*syscall.Win32FileAttributeData)
+	if d.FileAttributes&windows.FILE_ATTRIBUTE_HIDDEN == 0 {
+		t.Errorf("Expected file to be hidden after restoring it")
+	}
+	if ctime := d.CreationTime.Nanoseconds() / 1e9; ctime != arc.CreationTime {
+		t.Errorf("Expected creation time %d, got %d", arc.CreationTime, ctime)
+	}
+	if b, err := ioutil.ReadFile(restored + ":knoxite"); err != nil || string(b) != "stream" {
+		t.Errorf("Expected alternate data stream to be restored: %v", err)
+	}
+}
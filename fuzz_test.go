@@ -0,0 +1,144 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+// The fuzz targets cover everything parsing data loaded from storage backends,
+// which may have been tampered with. Without -fuzz they only run their seeds,
+// as part of the regular test suite:
+//
+//	go test -run '^$' -fuzz FuzzDecodeChunk
+
+const fuzzKey = "fuzzing_key"
+
+func FuzzSplitChunkHeader(f *testing.F) {
+	h, _ := ChunkHeader{Version: ChunkFormatVersion, Compression: CompressionZstd, Encryption: EncryptionAESGCM, DataParts: 1, Size: 42}.MarshalBinary()
+	f.Add(append(h, []byte("encrypted chunk data")...))
+	f.Add([]byte("KNXC"))
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		header, rest, err := SplitChunkHeader(data)
+		if err != nil || header == nil {
+			return
+		}
+		b, _ := header.MarshalBinary()
+		if !bytes.Equal(append(b, rest...), data) {
+			t.Errorf("Chunk header %v doesn't encode to the data it got decoded from", header)
+		}
+	})
+}
+
+func FuzzDecodeChunk(f *testing.F) {
+	data := []byte("some data getting stored in a chunk, some data getting stored in a chunk")
+	for _, compression := range []uint16{CompressionNone, CompressionGZip, CompressionLZMA, CompressionFlate, CompressionZlib, CompressionZstd} {
+		for _, encryption := range []uint16{EncryptionNone, EncryptionAES, EncryptionAESGCM} {
+			pipe, err := NewEncodingPipeline(compression, encryption, fuzzKey)
+			if err != nil {
+				f.Fatal(err)
+			}
+			b, err := pipe.Process(data)
+			if err != nil {
+				f.Fatal(err)
+			}
+			f.Add(compression, encryption, b)
+		}
+	}
+	// unknown algos
+	f.Add(uint16(42), uint16(EncryptionNone), data)
+	f.Add(uint16(CompressionNone), uint16(42), data)
+
+	repository := Repository{Key: fuzzKey}
+	chunk := Chunk{DecryptedHash: Hash(data, HashHighway256)}
+	f.Fuzz(func(t *testing.T, compression, encryption uint16, b []byte) {
+		// decoding must fail gracefully, rather than returning wrong data
+		d, err := decodeChunk(repository, compression, encryption, chunk, b, nil)
+		if err == nil && !bytes.Equal(d, data) {
+			t.Errorf("Decoded unexpected data %q", d)
+		}
+	})
+}
+
+func FuzzDecodeMetadata(f *testing.F) {
+	snapshot, _ := NewSnapshot("fuzzing")
+	snapshot.Tags = []string{"tag"}
+	snapshot.AddArchive(&Archive{Path: "file", Type: File, Size: 42, Chunks: []Chunk{{Hash: "hash", DataParts: 1}}})
+	for _, encryption := range []uint16{EncryptionAES, EncryptionAESGCM} {
+		pipe, err := NewEncodingPipeline(CompressionLZMA, encryption, fuzzKey)
+		if err != nil {
+			f.Fatal(err)
+		}
+		b, err := pipe.Encode(snapshot)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(b)
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var s Snapshot
+		_ = decodeMetadata(CompressionLZMA, fuzzKey, b, &s)
+
+		// the unencrypted gob encoding
+		pipe, _ := NewDecodingPipeline(CompressionNone, EncryptionNone, "")
+		_ = pipe.Decode(b, &s)
+	})
+}
+
+func FuzzSnapshotJSON(f *testing.F) {
+	snapshot, _ := NewSnapshot("fuzzing")
+	snapshot.AddArchive(&Archive{Path: "file", Type: File, Size: 42, Chunks: []Chunk{{Hash: "hash", DataParts: 1}}})
+	b, _ := json.Marshal(snapshot)
+	f.Add(b)
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		var s Snapshot
+		if err := json.Unmarshal(b, &s); err != nil {
+			return
+		}
+		if _, err := json.Marshal(&s); err != nil {
+			t.Errorf("Failed encoding decoded snapshot: %s", err)
+		}
+	})
+}
+
+func FuzzSplitKeyRecords(f *testing.F) {
+	k, err := newKeyRecord("master_key", "password")
+	if err != nil {
+		f.Fatal(err)
+	}
+	b, _ := joinKeyRecords([]KeyRecord{k}, []byte("metadata"))
+	f.Add(b)
+	f.Add([]byte("KNXK\xff\xff\xff\xff"))
+	// invalid scrypt parameters
+	k.R = 0
+	b, _ = joinKeyRecords([]KeyRecord{k}, []byte("metadata"))
+	f.Add(b)
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		keys, _, err := splitKeyRecords(b)
+		if err != nil {
+			return
+		}
+		for _, k := range keys {
+			// stay well below the limits of the scrypt parameters, fuzzing
+			// would take forever otherwise
+			if k.N <= 1<<12 && k.R <= 8 && k.P <= 2 {
+				_, _ = k.unwrap("password")
+			}
+		}
+	})
+}
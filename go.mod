@@ -9,6 +9,7 @@ require (
 	github.com/aws/aws-sdk-go v1.35.10
 	github.com/bmizerany/assert v0.0.0-20160611221934-b7ed37b82869 // indirect
 	github.com/dustin/go-humanize v1.0.0
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/go-ini/ini v1.51.1 // indirect
 	github.com/google/readahead v0.0.0-20161222183148-eaceba169032 // indirect
 	github.com/jlaffaye/ftp v0.0.0-20210307004419-5d4190119067
@@ -19,10 +20,10 @@ require (
 	github.com/minio/minio-go v6.0.14+incompatible
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/muesli/combinator v0.3.0
-	github.com/muesli/crunchy v0.4.0
 	github.com/muesli/go-app-paths v0.2.1
 	github.com/muesli/goprogressbar v0.2.0
 	github.com/muesli/gotable v0.0.0-20210307142814-960606545b8b
+	github.com/nbutton23/zxcvbn-go v0.0.0-20210217022336-fa2cb2858354
 	github.com/nu7hatch/gouuid v0.0.0-20131221200532-179d4d0c4d8d
 	github.com/onsi/ginkgo v1.14.2
 	github.com/onsi/gomega v1.10.3
@@ -32,6 +33,7 @@ require (
 	github.com/restic/chunker v0.4.0
 	github.com/rsteube/carapace v0.8.14
 	github.com/segmentio/go-env v1.1.0 // indirect
+	github.com/sethvargo/go-diceware v0.3.0
 	github.com/spf13/cobra v1.2.1
 	github.com/spf13/pflag v1.0.5
 	github.com/studio-b12/gowebdav v0.0.0-20210203212356-8244b5a5f51a
@@ -40,10 +42,13 @@ require (
 	github.com/tj/go-dropy v0.0.0-20151223190506-225699a12156
 	github.com/ulikunitz/xz v0.5.10
 	github.com/ungerik/go-dry v0.0.0-20180411133923-654ae31114c8 // indirect
+	github.com/zalando/go-keyring v0.1.1
 	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
 	golang.org/x/net v0.0.0-20210410081132-afb366fc7cd1
+	golang.org/x/oauth2 v0.0.0-20210402161424-2e8d93401602
 	golang.org/x/sys v0.0.0-20210510120138-977fb7262007
 	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1
+	golang.org/x/text v0.3.6
 	google.golang.org/api v0.44.0
 	gopkg.in/kothar/go-backblaze.v0 v0.0.0-20210124194846-35409b867216
 )
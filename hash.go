@@ -35,3 +35,16 @@ func Hash(b []byte, hashtype uint8) string {
 
 	return hex.EncodeToString(data[:])
 }
+
+// domainHash returns the hash identifying a chunk of encoded data b in a
+// deduplication domain. Equal chunks of different domains get different
+// hashes, so they never get shared.
+func domainHash(b []byte, domain string) string {
+	if domain == "" {
+		return Hash(b, HashHighway256)
+	}
+
+	key := sha256.Sum256([]byte(domain))
+	data := highwayhash.Sum(b, key[:])
+	return hex.EncodeToString(data[:])
+}
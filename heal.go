@@ -0,0 +1,477 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrBackendUnavailable is returned for backends, which have been found to be
+// unavailable earlier on and get skipped.
+var ErrBackendUnavailable = errors.New("Storage backend is unavailable")
+
+// BackendDebt records the data a backend missed, because it was unavailable
+// while the data got stored on the other backends of a repository.
+type BackendDebt struct {
+	Location string `json:"location"`
+	// Chunks contains parts of chunks by the name they get stored with
+	Chunks       []string `json:"chunks,omitempty"`
+	Snapshots    []string `json:"snapshots,omitempty"`
+	ChunkIndex   bool     `json:"chunk_index,omitempty"`
+	Repository   bool     `json:"repository,omitempty"`
+	PasswordHint bool     `json:"password_hint,omitempty"`
+}
+
+// HealReport describes the data Heal copied to lagging backends.
+type HealReport struct {
+	Chunks    int
+	Snapshots int
+	// Lagging contains the locations of all backends, which still miss data
+	Lagging []string
+}
+
+// debt is the mutable form of a BackendDebt.
+type debt struct {
+	chunks       map[string]bool
+	snapshots    map[string]bool
+	chunkIndex   bool
+	repository   bool
+	passwordHint bool
+}
+
+func (d *debt) settled() bool {
+	return len(d.chunks) == 0 && len(d.snapshots) == 0 &&
+		!d.chunkIndex && !d.repository && !d.passwordHint
+}
+
+// debtLedger keeps track of the data each backend missed, by its location.
+// Chunks get stored concurrently, so it's guarded by a mutex.
+type debtLedger struct {
+	mut   sync.Mutex
+	debts map[string]*debt
+	// down contains the backends found to be unavailable, which don't get
+	// accessed again until they get healed
+	down map[string]bool
+}
+
+func newDebtLedger() *debtLedger {
+	return &debtLedger{
+		debts: make(map[string]*debt),
+		down:  make(map[string]bool),
+	}
+}
+
+// markDown records that the backend at location is unavailable.
+func (l *debtLedger) markDown(location string, err error) {
+	if l == nil {
+		return
+	}
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	if !l.down[location] {
+		log.Warnf("%s is unavailable, its data will be copied once it's back: %v", RedactURL(location), err)
+		l.down[location] = true
+	}
+}
+
+// isDown reports whether the backend at location has been found to be
+// unavailable.
+func (l *debtLedger) isDown(location string) bool {
+	if l == nil {
+		return false
+	}
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	return l.down[location]
+}
+
+// update lets fn modify the debt of the backend at location.
+func (l *debtLedger) update(location string, fn func(d *debt)) {
+	if l == nil {
+		// the BackendManager hasn't been set up with AddBackend
+		return
+	}
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	d, ok := l.debts[location]
+	if !ok {
+		d = &debt{
+			chunks:    make(map[string]bool),
+			snapshots: make(map[string]bool),
+		}
+	}
+	fn(d)
+	if d.settled() {
+		delete(l.debts, location)
+	} else {
+		l.debts[location] = d
+	}
+}
+
+// owes reports whether the backend at location misses data matching fn.
+func (l *debtLedger) owes(location string, fn func(d *debt) bool) bool {
+	if l == nil {
+		return false
+	}
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	d, ok := l.debts[location]
+	return ok && fn(d)
+}
+
+// unavailable returns true if err indicates that a backend couldn't be
+// reached, rather than it rejecting an operation.
+func unavailable(err error) bool {
+	return IsRetryable(err) || errors.Is(err, os.ErrNotExist)
+}
+
+// Debts returns the data each backend missed while it was unavailable.
+func (backend *BackendManager) Debts() []BackendDebt {
+	if backend.debts == nil {
+		return nil
+	}
+	backend.debts.mut.Lock()
+	defer backend.debts.mut.Unlock()
+
+	var debts []BackendDebt
+	for location, d := range backend.debts.debts {
+		bd := BackendDebt{
+			Location:     location,
+			ChunkIndex:   d.chunkIndex,
+			Repository:   d.repository,
+			PasswordHint: d.passwordHint,
+		}
+		for name := range d.chunks {
+			bd.Chunks = append(bd.Chunks, name)
+		}
+		for id := range d.snapshots {
+			bd.Snapshots = append(bd.Snapshots, id)
+		}
+		sort.Strings(bd.Chunks)
+		sort.Strings(bd.Snapshots)
+		debts = append(debts, bd)
+	}
+	sort.Slice(debts, func(i, j int) bool {
+		return debts[i].Location < debts[j].Location
+	})
+
+	return debts
+}
+
+// setDebts restores the debts recorded in a repository.
+func (backend *BackendManager) setDebts(debts []BackendDebt) {
+	backend.debts = newDebtLedger()
+	for _, bd := range debts {
+		bd := bd
+		backend.debts.update(bd.Location, func(d *debt) {
+			for _, name := range bd.Chunks {
+				d.chunks[name] = true
+			}
+			for _, id := range bd.Snapshots {
+				d.snapshots[id] = true
+			}
+			d.chunkIndex = bd.ChunkIndex
+			d.repository = bd.Repository
+			d.passwordHint = bd.PasswordHint
+		})
+	}
+}
+
+// findBackend returns the backend stored at location.
+func (backend *BackendManager) findBackend(location string) *Backend {
+	for _, be := range backend.Backends {
+		if (*be).Location() == location {
+			return be
+		}
+	}
+	return nil
+}
+
+// readOrder returns all backends, those missing the data described by owes
+// last, so stale data gets read only if no other backend is available.
+func (backend *BackendManager) readOrder(owes func(d *debt) bool) []*Backend {
+	var current, lagging []*Backend
+	for _, be := range backend.Backends {
+		if backend.debts.owes((*be).Location(), owes) {
+			lagging = append(lagging, be)
+		} else {
+			current = append(current, be)
+		}
+	}
+	return append(current, lagging...)
+}
+
+// saveOnAll calls save for every backend and traces it as request. Backends,
+// which are unavailable, get skipped and record a debt with mark instead, as
+// long as the data could be stored on at least one backend. Backends, which
+// stored the data, settle their debt.
+func (backend *BackendManager) saveOnAll(request string, save func(be Backend) error, mark func(d *debt, owed bool)) error {
+	var lagging []*Backend
+	var lastErr error
+	for _, be := range backend.Backends {
+		err := ErrBackendUnavailable
+		if !backend.debts.isDown((*be).Location()) {
+			err = backend.retry(context.Background(), func() error {
+				start := time.Now()
+				err := save(*be)
+				trace(be, start, err, "%s", request)
+				return err
+			})
+		}
+		if err == nil {
+			backend.debts.update((*be).Location(), func(d *debt) { mark(d, false) })
+			continue
+		}
+		if !unavailable(err) {
+			return err
+		}
+
+		lagging = append(lagging, be)
+		lastErr = err
+	}
+
+	if len(lagging) == len(backend.Backends) {
+		return lastErr
+	}
+	for _, be := range lagging {
+		backend.debts.markDown((*be).Location(), lastErr)
+		backend.debts.update((*be).Location(), func(d *debt) { mark(d, true) })
+	}
+	return nil
+}
+
+// heal copies the data be missed from the other backends. It returns the
+// amount of copied chunks and snapshots.
+func (backend *BackendManager) heal(ctx context.Context, be *Backend, bd BackendDebt) (int, int, error) {
+	location := (*be).Location()
+	var chunks, snapshots int
+
+	for _, name := range bd.Chunks {
+		part, err := ParseChunkPartName(name)
+		if err != nil {
+			backend.debts.update(location, func(d *debt) { delete(d.chunks, name) })
+			continue
+		}
+
+		if err := backend.copyChunkPartFromOthers(ctx, be, part); err != nil {
+			return chunks, snapshots, err
+		}
+		log.Debugf("Copied chunk %s (part %d/%d) to %s", part.Hash, part.Part+1, part.TotalParts, RedactURL(location))
+		backend.debts.update(location, func(d *debt) { delete(d.chunks, name) })
+		chunks++
+	}
+
+	for _, id := range bd.Snapshots {
+		b, err := backend.loadFromOthers(be, func(other Backend) ([]byte, error) {
+			return other.LoadSnapshot(id)
+		})
+		if err != nil {
+			return chunks, snapshots, err
+		}
+		if err := backend.retry(ctx, func() error { return (*be).SaveSnapshot(id, b) }); err != nil {
+			return chunks, snapshots, err
+		}
+		backend.debts.update(location, func(d *debt) { delete(d.snapshots, id) })
+		snapshots++
+	}
+
+	if bd.ChunkIndex {
+		b, err := backend.loadFromOthers(be, func(other Backend) ([]byte, error) {
+			return other.LoadChunkIndex()
+		})
+		if err != nil {
+			return chunks, snapshots, err
+		}
+		// the packs it lists need to be copied first
+		if manifest, ok := parseChunkIndexManifest(b); ok {
+			if err := backend.healChunkIndexPacks(ctx, be, manifest); err != nil {
+				return chunks, snapshots, err
+			}
+		}
+		if err := backend.retry(ctx, func() error { return (*be).SaveChunkIndex(b) }); err != nil {
+			return chunks, snapshots, err
+		}
+		backend.debts.update(location, func(d *debt) { d.chunkIndex = false })
+	}
+
+	if bd.PasswordHint {
+		b, err := backend.loadFromOthers(be, func(other Backend) ([]byte, error) {
+			return other.LoadPasswordHint()
+		})
+		if err != nil {
+			return chunks, snapshots, err
+		}
+		if err := backend.retry(ctx, func() error { return (*be).SavePasswordHint(b) }); err != nil {
+			return chunks, snapshots, err
+		}
+		backend.debts.update(location, func(d *debt) { d.passwordHint = false })
+	}
+
+	return chunks, snapshots, nil
+}
+
+// copyChunkPartFromOthers copies a part of a chunk from any backend but be
+// to be.
+func (backend *BackendManager) copyChunkPartFromOthers(ctx context.Context, be *Backend, part ChunkPart) error {
+	lastErr := ErrLoadChunkFailed
+	for _, other := range backend.Backends {
+		if other == be {
+			continue
+		}
+
+		err := backend.retry(ctx, func() error {
+			return copyChunkPart(ctx, other, be, part)
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+	}
+
+	return lastErr
+}
+
+// copyChunkPart copies a part of a chunk from one backend to another. It gets
+// streamed through if the source can tell its size, otherwise it needs to be
+// read first.
+func copyChunkPart(ctx context.Context, from, to *Backend, part ChunkPart) error {
+	var size uint64
+	sizer, sized := (*from).(ChunkSizer)
+	if sized {
+		var err error
+		size, err = sizer.ChunkSize(ctx, part.Hash, part.Part, part.TotalParts)
+		if err != nil {
+			return err
+		}
+	}
+
+	r, err := (*from).LoadChunk(ctx, part.Hash, part.Part, part.TotalParts)
+	if err != nil {
+		return err
+	}
+	if !sized {
+		data, err := readAll(r)
+		if err != nil {
+			return err
+		}
+		_, err = (*to).StoreChunk(ctx, part.Hash, part.Part, part.TotalParts, bytes.NewReader(data), uint64(len(data)))
+		return err
+	}
+
+	_, err = (*to).StoreChunk(ctx, part.Hash, part.Part, part.TotalParts, r, size)
+	if cerr := r.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// loadFromOthers loads data with load from any backend but be.
+func (backend *BackendManager) loadFromOthers(be *Backend, load func(other Backend) ([]byte, error)) ([]byte, error) {
+	var lastErr error
+	for _, other := range backend.Backends {
+		if other == be {
+			continue
+		}
+
+		var b []byte
+		err := backend.retry(context.Background(), func() error {
+			var err error
+			b, err = load(*other)
+			return err
+		})
+		if err == nil {
+			return b, nil
+		}
+		lastErr = err
+	}
+
+	return nil, lastErr
+}
+
+// healChunkIndexPacks copies the packs of the chunk-index be is missing from
+// the other backends.
+func (backend *BackendManager) healChunkIndexPacks(ctx context.Context, be *Backend, manifest chunkIndexManifest) error {
+	pb, ok := (*be).(ChunkIndexPackBackend)
+	if !ok {
+		return ErrBackendUnavailable
+	}
+	for _, id := range manifest.Packs {
+		if _, err := pb.LoadChunkIndexPack(id); err == nil {
+			continue
+		}
+		b, err := backend.loadFromOthers(be, func(other Backend) ([]byte, error) {
+			if opb, ok := other.(ChunkIndexPackBackend); ok {
+				return opb.LoadChunkIndexPack(id)
+			}
+			return nil, ErrLoadChunkIndexFailed
+		})
+		if err != nil {
+			return err
+		}
+		if err := backend.retry(ctx, func() error { return pb.SaveChunkIndexPack(id, b) }); err != nil {
+			return err
+		}
+		log.Debugf("Copied chunk-index pack %s to %s", id, RedactURL((*be).Location()))
+	}
+	return nil
+}
+
+// Heal copies all data, which backends missed while they were unavailable,
+// from the other backends of the repository. Backends, which are still
+// unavailable, keep their debt and get healed on a later attempt.
+func (r *Repository) Heal(ctx context.Context) (HealReport, error) {
+	var report HealReport
+	if r.ReadOnly() {
+		return report, ErrRepositoryReadOnly
+	}
+
+	for _, bd := range r.backend.Debts() {
+		be := r.backend.findBackend(bd.Location)
+		if be == nil {
+			// the backend is no longer part of the repository
+			r.backend.debts.update(bd.Location, func(d *debt) { *d = debt{} })
+			continue
+		}
+
+		chunks, snapshots, err := r.backend.heal(ctx, be, bd)
+		report.Chunks += chunks
+		report.Snapshots += snapshots
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+		if err != nil && unavailable(err) {
+			r.backend.debts.markDown(bd.Location, err)
+		} else if err != nil {
+			log.Warnf("Copying missing data to %s failed: %v", RedactURL(bd.Location), err)
+		}
+	}
+
+	// stores the repository on all available backends, which settles their
+	// debts for it
+	if err := r.Save(); err != nil {
+		return report, err
+	}
+	for _, bd := range r.backend.Debts() {
+		report.Lagging = append(report.Lagging, bd.Location)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,158 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+var errBackendDown = errors.New("connection refused")
+
+// unavailableBackend fails to store any data while it's down.
+type unavailableBackend struct {
+	Backend
+	down bool
+}
+
+func (b *unavailableBackend) StoreChunk(ctx context.Context, shasum string, part, totalParts uint, data io.Reader, size uint64) (uint64, error) {
+	if b.down {
+		return 0, errBackendDown
+	}
+	return b.Backend.StoreChunk(ctx, shasum, part, totalParts, data, size)
+}
+
+func (b *unavailableBackend) SaveSnapshot(id string, data []byte) error {
+	if b.down {
+		return errBackendDown
+	}
+	return b.Backend.SaveSnapshot(id, data)
+}
+
+func (b *unavailableBackend) SaveChunkIndex(data []byte) error {
+	if b.down {
+		return errBackendDown
+	}
+	return b.Backend.SaveChunkIndex(data)
+}
+
+func (b *unavailableBackend) SaveRepository(data []byte) error {
+	if b.down {
+		return errBackendDown
+	}
+	return b.Backend.SaveRepository(data)
+}
+
+func TestHeal(t *testing.T) {
+	testPassword := "this_is_a_password"
+	ctx := context.Background()
+
+	var dirs []string
+	for i := 0; i < 2; i++ {
+		dir, err := ioutil.TempDir("", "knoxite")
+		if err != nil {
+			t.Fatalf("Failed creating temporary dir for repository: %s", err)
+		}
+		defer os.RemoveAll(dir)
+		dirs = append(dirs, dir)
+	}
+
+	r, err := NewRepository(dirs[0], testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	mirror, err := BackendFromURL(dirs[1])
+	if err != nil {
+		t.Fatalf("Failed creating backend: %s", err)
+	}
+	if err := mirror.InitRepository(); err != nil {
+		t.Fatalf("Failed initializing repository: %s", err)
+	}
+	ub := &unavailableBackend{Backend: mirror}
+	var be Backend = ub
+	r.backend.AddBackend(&be)
+	r.backend.Retry = &RetryPolicy{}
+	if err := r.Save(); err != nil {
+		t.Fatalf("Failed saving repository: %s", err)
+	}
+
+	// store data while the mirror is down
+	ub.down = true
+	for _, hash := range []string{"aaaaaa", "bbbbbb"} {
+		chunk := Chunk{Hash: hash, DataParts: 1, Data: &[][]byte{[]byte(hash)}}
+		if _, err := r.backend.StoreChunk(ctx, chunk); err != nil {
+			t.Fatalf("Expected storing chunk to fall back to another backend, got %s", err)
+		}
+	}
+	if err := r.backend.SaveSnapshot("snapshot", []byte("snapshot")); err != nil {
+		t.Fatalf("Failed saving snapshot: %s", err)
+	}
+	if err := r.backend.SaveChunkIndex([]byte("index")); err != nil {
+		t.Fatalf("Failed saving chunk-index: %s", err)
+	}
+	if err := r.Save(); err != nil {
+		t.Fatalf("Failed saving repository: %s", err)
+	}
+
+	debts := r.backend.Debts()
+	if len(debts) != 1 || debts[0].Location != mirror.Location() {
+		t.Fatalf("Expected the mirror to miss data, got %+v", debts)
+	}
+	if len(debts[0].Chunks) != 1 || len(debts[0].Snapshots) != 1 ||
+		!debts[0].ChunkIndex || !debts[0].Repository {
+		t.Errorf("Expected the mirror to miss a chunk, a snapshot, the chunk-index and the repository, got %+v", debts[0])
+	}
+
+	// the debt gets recorded in the repository, even though the mirror is
+	// back when the repository gets opened again
+	r, err = OpenRepository(dirs[0], testPassword)
+	if err != nil {
+		t.Fatalf("Failed opening repository: %s", err)
+	}
+	if !reflect.DeepEqual(r.backend.Debts(), debts) {
+		t.Errorf("Expected debts %+v, got %+v", debts, r.backend.Debts())
+	}
+	if b, err := r.backend.LoadChunkIndex(); err != nil || string(b) != "index" {
+		t.Errorf("Expected the current chunk-index to be loaded, got %q: %v", b, err)
+	}
+
+	report, err := r.Heal(ctx)
+	if err != nil {
+		t.Fatalf("Failed healing repository: %s", err)
+	}
+	if report.Chunks != 1 || report.Snapshots != 1 || len(report.Lagging) != 0 {
+		t.Errorf("Expected a chunk and a snapshot to be copied, got %+v", report)
+	}
+	if len(r.backend.Debts()) != 0 {
+		t.Errorf("Expected all debts to be settled, got %+v", r.backend.Debts())
+	}
+
+	b, err := mirror.LoadSnapshot("snapshot")
+	if err != nil || string(b) != "snapshot" {
+		t.Errorf("Expected the snapshot to be copied to the mirror, got %q: %v", b, err)
+	}
+	b, err = mirror.LoadChunkIndex()
+	if err != nil || string(b) != "index" {
+		t.Errorf("Expected the chunk-index to be copied to the mirror, got %q: %v", b, err)
+	}
+	part, _ := ParseChunkPartName(debts[0].Chunks[0])
+	rc, err := mirror.LoadChunk(ctx, part.Hash, part.Part, part.TotalParts)
+	if err != nil {
+		t.Errorf("Expected the chunk to be copied to the mirror: %s", err)
+	} else if b, err := readAll(rc); err != nil || string(b) != part.Hash {
+		t.Errorf("Expected the chunk to be copied to the mirror, got %q: %v", b, err)
+	}
+	if _, err := OpenRepository(dirs[1], testPassword); err != nil {
+		t.Errorf("Expected the repository to be copied to the mirror: %s", err)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import "errors"
+
+// Error declarations.
+var (
+	ErrIndexMaintainedByBackend = errors.New("The chunk-index is maintained by the storage backends")
+)
+
+// IndexStats summarizes the chunks stored in a repository, as recorded in its
+// chunk-index.
+type IndexStats struct {
+	// Chunks is the amount of unique chunks
+	Chunks uint64 `json:"chunks"`
+	// StorageSize is the size of all chunks after compression and
+	// encryption, excluding parity data
+	StorageSize uint64 `json:"stored_size"`
+	// ParitySize is the size of the parity data stored in addition
+	ParitySize uint64 `json:"parity_size"`
+	// Unreferenced is the size of the chunks no snapshot references anymore,
+	// which 'repo pack' would release
+	Unreferenced uint64 `json:"unreferenced_size"`
+
+	Snapshots map[string]*SnapshotIndexStats `json:"snapshots"`
+}
+
+// SnapshotIndexStats summarizes the chunks referenced by a single snapshot.
+type SnapshotIndexStats struct {
+	Chunks uint64 `json:"chunks"`
+	// UniqueSize is the size of the chunks only this snapshot references,
+	// i.e. what removing it would release
+	UniqueSize uint64 `json:"unique_size"`
+	// SharedSize is the size of the chunks other snapshots reference, too
+	SharedSize uint64 `json:"shared_size"`
+}
+
+// paritySize returns the size of the parity parts of a chunk.
+func (chunk *ChunkIndexItem) paritySize() uint64 {
+	if chunk.ParityParts == 0 || chunk.DataParts == 0 {
+		return 0
+	}
+	partSize := (uint64(chunk.Size) + uint64(chunk.DataParts) - 1) / uint64(chunk.DataParts)
+	return partSize * uint64(chunk.ParityParts)
+}
+
+// Stats summarizes the chunks of the chunk-index, without accessing the chunks
+// themselves.
+func (index *ChunkIndex) Stats() (IndexStats, error) {
+	if len(index.indexing) > 0 {
+		return IndexStats{}, ErrIndexMaintainedByBackend
+	}
+
+	stats := IndexStats{
+		Snapshots: make(map[string]*SnapshotIndexStats),
+	}
+	for _, chunk := range index.Chunks {
+		size := uint64(chunk.Size)
+		stats.Chunks++
+		stats.StorageSize += size
+		stats.ParitySize += chunk.paritySize()
+
+		// a snapshot references a chunk once for each of its occurrences
+		snapshots := make(map[string]bool)
+		for _, id := range chunk.Snapshots {
+			snapshots[id] = true
+		}
+		if len(snapshots) == 0 {
+			stats.Unreferenced += size
+		}
+		for id := range snapshots {
+			s, ok := stats.Snapshots[id]
+			if !ok {
+				s = &SnapshotIndexStats{}
+				stats.Snapshots[id] = s
+			}
+			s.Chunks++
+			if len(snapshots) == 1 {
+				s.UniqueSize += size
+			} else {
+				s.SharedSize += size
+			}
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,61 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import "testing"
+
+func TestChunkIndexStats(t *testing.T) {
+	index := ChunkIndex{
+		Chunks: map[string]*ChunkIndexItem{
+			"a": {Hash: "a", DataParts: 1, Size: 100, Snapshots: []string{"s1", "s1"}},
+			"b": {Hash: "b", DataParts: 1, Size: 200, Snapshots: []string{"s1", "s2"}},
+			"c": {Hash: "c", DataParts: 2, ParityParts: 1, Size: 301, Snapshots: []string{"s2"}},
+			"d": {Hash: "d", DataParts: 1, Size: 50},
+		},
+	}
+
+	stats, err := index.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Chunks != 4 {
+		t.Errorf("Expected 4 chunks, got %d", stats.Chunks)
+	}
+	if stats.StorageSize != 651 {
+		t.Errorf("Expected storage size of 651, got %d", stats.StorageSize)
+	}
+	if stats.ParitySize != 151 {
+		t.Errorf("Expected parity size of 151, got %d", stats.ParitySize)
+	}
+	if stats.Unreferenced != 50 {
+		t.Errorf("Expected unreferenced size of 50, got %d", stats.Unreferenced)
+	}
+
+	expected := map[string]SnapshotIndexStats{
+		"s1": {Chunks: 2, UniqueSize: 100, SharedSize: 200},
+		"s2": {Chunks: 2, UniqueSize: 301, SharedSize: 200},
+	}
+	if len(stats.Snapshots) != len(expected) {
+		t.Errorf("Expected stats of %d snapshots, got %d", len(expected), len(stats.Snapshots))
+	}
+	for id, e := range expected {
+		s, ok := stats.Snapshots[id]
+		if !ok {
+			t.Errorf("Missing stats of snapshot %s", id)
+			continue
+		}
+		if *s != e {
+			t.Errorf("Expected stats %+v of snapshot %s, got %+v", e, id, *s)
+		}
+	}
+
+	index.indexing = []IndexingBackend{nil}
+	if _, err := index.Stats(); err != ErrIndexMaintainedByBackend {
+		t.Errorf("Expected %v, got %v", ErrIndexMaintainedByBackend, err)
+	}
+}
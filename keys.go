@@ -0,0 +1,241 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/user"
+	"time"
+
+	uuid "github.com/nu7hatch/gouuid"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KeyRecord grants access to a repository with a password. The repository's
+// metadata is encrypted with a random master key, which every key record
+// stores wrapped with a key derived from its password. Passwords can thus be
+// added, changed and revoked without re-encrypting any data.
+type KeyRecord struct {
+	ID       string    `json:"id"`
+	Created  time.Time `json:"created"`
+	Hostname string    `json:"hostname"`
+	Username string    `json:"username"`
+
+	// Salt and the scrypt parameters derive the key wrapping the master key
+	Salt []byte `json:"salt"`
+	N    int    `json:"n"`
+	R    int    `json:"r"`
+	P    int    `json:"p"`
+	// Key is the master key, encrypted with the derived key
+	Key []byte `json:"key"`
+
+	// Admin keys may remove data from append-only repositories. The flag
+	// isn't bound to any secret, so it's only enforced by knoxite itself
+	Admin bool `json:"admin,omitempty"`
+}
+
+// keyRecordsVersion is the first repository version using key records.
+const keyRecordsVersion = 6
+
+// keyDerivation holds the scrypt parameters used for new key records.
+var keyDerivation = struct{ N, R, P int }{1 << 15, 8, 1}
+
+// maxKeyDerivation limits the scrypt parameters of stored key records, so a
+// corrupted record can't exhaust all memory.
+var maxKeyDerivation = struct{ N, R, P int }{1 << 20, 32, 16}
+
+var keyRecordsMagic = []byte("KNXK")
+
+// Error declarations.
+var (
+	ErrKeyNotFound      = errors.New("Key not found")
+	ErrLastKey          = errors.New("The last key of a repository can't be removed")
+	ErrKeyInUse         = errors.New("The key used to open the repository can't be removed")
+	ErrInvalidKeyRecord = errors.New("Invalid key record")
+)
+
+// deriveKey derives the key wrapping the master key from a password.
+func (k KeyRecord) deriveKey(password string) (string, error) {
+	if len(password) == 0 {
+		return "", ErrInvalidPassword
+	}
+	if k.N < 2 || k.N > maxKeyDerivation.N || k.N&(k.N-1) != 0 ||
+		k.R < 1 || k.R > maxKeyDerivation.R || k.P < 1 || k.P > maxKeyDerivation.P {
+		return "", ErrInvalidKeyRecord
+	}
+	b, err := scrypt.Key([]byte(password), k.Salt, k.N, k.R, k.P, 32)
+	return string(b), err
+}
+
+// newKeyRecord wraps the master key with a password.
+func newKeyRecord(masterKey, password string) (KeyRecord, error) {
+	k := KeyRecord{
+		Created: time.Now(),
+		Salt:    make([]byte, 32),
+		N:       keyDerivation.N,
+		R:       keyDerivation.R,
+		P:       keyDerivation.P,
+	}
+
+	u, err := uuid.NewV4()
+	if err != nil {
+		return k, err
+	}
+	k.ID = u.String()[:8]
+	k.Hostname, _ = os.Hostname()
+	if usr, err := user.Current(); err == nil {
+		k.Username = usr.Username
+	}
+	if _, err := rand.Read(k.Salt); err != nil {
+		return k, err
+	}
+
+	key, err := k.deriveKey(password)
+	if err != nil {
+		return k, err
+	}
+	e, err := NewEncryptor(EncryptionAESGCM, key)
+	if err != nil {
+		return k, err
+	}
+	k.Key, err = e.Process([]byte(masterKey))
+	return k, err
+}
+
+// unwrap returns the master key, if password matches the key record.
+func (k KeyRecord) unwrap(password string) (string, error) {
+	key, err := k.deriveKey(password)
+	if err != nil {
+		return "", err
+	}
+	d, err := NewDecryptor(EncryptionAESGCM, key)
+	if err != nil {
+		return "", err
+	}
+	b, err := d.Process(k.Key)
+	return string(b), err
+}
+
+// splitKeyRecords separates the key records preceding the stored metadata of
+// a repository from the metadata itself. Repositories of older versions don't
+// have any key records: their metadata is encrypted with the password.
+func splitKeyRecords(b []byte) ([]KeyRecord, []byte, error) {
+	if len(b) < len(keyRecordsMagic)+4 || !bytes.Equal(b[:len(keyRecordsMagic)], keyRecordsMagic) {
+		return nil, b, nil
+	}
+	b = b[len(keyRecordsMagic):]
+
+	n := binary.BigEndian.Uint32(b)
+	b = b[4:]
+	if uint32(len(b)) < n {
+		return nil, nil, ErrOpenRepositoryFailed
+	}
+
+	var keys []KeyRecord
+	if err := json.Unmarshal(b[:n], &keys); err != nil {
+		return nil, nil, err
+	}
+	return keys, b[n:], nil
+}
+
+// joinKeyRecords prefixes the stored metadata of a repository with its key
+// records.
+func joinKeyRecords(keys []KeyRecord, b []byte) ([]byte, error) {
+	j, err := json.Marshal(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(keyRecordsMagic)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(j)))
+	buf.Write(j)
+	buf.Write(b)
+	return buf.Bytes(), nil
+}
+
+// initKeys protects the repository with a new master key, which is wrapped
+// by password.
+func (r *Repository) initKeys(password string) error {
+	masterKey, err := generateRandomKey(repositoryKeyLength)
+	if err != nil {
+		return ErrGenerateRandomKeyFailed
+	}
+	k, err := newKeyRecord(masterKey, password)
+	if err != nil {
+		return err
+	}
+
+	r.keys = []KeyRecord{k}
+	r.currentKey = k.ID
+	r.password = masterKey
+	if r.Version < keyRecordsVersion {
+		r.Version = keyRecordsVersion
+	}
+	if r.ReaderVersion < keyRecordsVersion {
+		r.ReaderVersion = keyRecordsVersion
+	}
+	return nil
+}
+
+// Keys returns the key records of the repository. Repositories created by
+// older versions of knoxite don't have any, until a key gets added.
+func (r *Repository) Keys() []KeyRecord {
+	return r.keys
+}
+
+// CurrentKey returns the ID of the key record the repository has been opened
+// with.
+func (r *Repository) CurrentKey() string {
+	return r.currentKey
+}
+
+// AddKey grants access to the repository with another password. Repositories
+// created by older versions of knoxite get converted to use key records,
+// which makes them inaccessible for those versions.
+func (r *Repository) AddKey(password string) (KeyRecord, error) {
+	if len(r.keys) == 0 {
+		// the password used so far becomes the first key
+		if err := r.initKeys(r.password); err != nil {
+			return KeyRecord{}, err
+		}
+	}
+
+	k, err := newKeyRecord(r.password, password)
+	if err != nil {
+		return k, err
+	}
+	r.keys = append(r.keys, k)
+	return k, r.Save()
+}
+
+// RemoveKey revokes access to the repository for the key record with the
+// given ID.
+func (r *Repository) RemoveKey(id string) error {
+	for i, k := range r.keys {
+		if k.ID != id {
+			continue
+		}
+		if len(r.keys) == 1 {
+			return ErrLastKey
+		}
+		if id == r.currentKey {
+			return ErrKeyInUse
+		}
+
+		r.keys = append(r.keys[:i], r.keys[i+1:]...)
+		return r.Save()
+	}
+
+	return ErrKeyNotFound
+}
@@ -0,0 +1,127 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func init() {
+	// speed up the tests, key records store the parameters they used
+	keyDerivation.N = 1 << 10
+}
+
+func TestRepositoryKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, "first_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	first := r.CurrentKey()
+	if len(r.Keys()) != 1 || first == "" {
+		t.Fatalf("Expected a single key in use, got %v", r.Keys())
+	}
+	if err := r.RemoveKey(first); err != ErrLastKey {
+		t.Errorf("Expected %v, got %v", ErrLastKey, err)
+	}
+
+	second, err := r.AddKey("second_password")
+	if err != nil {
+		t.Fatalf("Failed adding key: %s", err)
+	}
+
+	for _, password := range []string{"first_password", "second_password"} {
+		o, err := OpenRepository(dir, password)
+		if err != nil {
+			t.Fatalf("Failed opening repository with %s: %s", password, err)
+		}
+		if o.Key != r.Key {
+			t.Errorf("Expected the same data key for all passwords")
+		}
+	}
+	if _, err := OpenRepository(dir, "wrong_password"); err != ErrOpenRepositoryFailed {
+		t.Errorf("Expected %v, got %v", ErrOpenRepositoryFailed, err)
+	}
+
+	// rotate the first password
+	r, _ = OpenRepository(dir, "second_password")
+	if err := r.RemoveKey(second.ID); err != ErrKeyInUse {
+		t.Errorf("Expected %v, got %v", ErrKeyInUse, err)
+	}
+	if err := r.RemoveKey("unknown"); err != ErrKeyNotFound {
+		t.Errorf("Expected %v, got %v", ErrKeyNotFound, err)
+	}
+	if err := r.RemoveKey(first); err != nil {
+		t.Fatalf("Failed removing key: %s", err)
+	}
+	if _, err := OpenRepository(dir, "first_password"); err != ErrOpenRepositoryFailed {
+		t.Errorf("Expected removed key not to open the repository, got %v", err)
+	}
+
+	if err := r.ChangePassword("third_password"); err != nil {
+		t.Fatalf("Failed changing password: %s", err)
+	}
+	if len(r.Keys()) != 1 || r.CurrentKey() == second.ID {
+		t.Errorf("Expected the key in use to be replaced, got %v", r.Keys())
+	}
+	if _, err := OpenRepository(dir, "second_password"); err != ErrOpenRepositoryFailed {
+		t.Errorf("Expected old password not to open the repository, got %v", err)
+	}
+	if _, err := OpenRepository(dir, "third_password"); err != nil {
+		t.Errorf("Failed opening repository with changed password: %s", err)
+	}
+}
+
+func TestRepositoryKeysConversion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// a repository created by an older version of knoxite
+	r, err := NewRepository(dir, "first_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	r.Version, r.ReaderVersion = 5, 5
+	r.keys = nil
+	r.password = "first_password"
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = OpenRepository(dir, "first_password")
+	if err != nil {
+		t.Fatalf("Failed opening repository: %s", err)
+	}
+	if len(r.Keys()) != 0 {
+		t.Errorf("Expected no keys, got %v", r.Keys())
+	}
+
+	if _, err := r.AddKey("second_password"); err != nil {
+		t.Fatalf("Failed adding key: %s", err)
+	}
+	for _, password := range []string{"first_password", "second_password"} {
+		o, err := OpenRepository(dir, password)
+		if err != nil {
+			t.Fatalf("Failed opening converted repository with %s: %s", password, err)
+		}
+		if o.Version != keyRecordsVersion || len(o.Keys()) != 2 {
+			t.Errorf("Expected repository version %d with 2 keys, got version %d with %d keys",
+				keyRecordsVersion, o.Version, len(o.Keys()))
+		}
+	}
+}
@@ -0,0 +1,370 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"runtime"
+	"sync"
+	"syscall"
+	"time"
+
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+// StaleLockAge is the age after which a lock is considered stale, e.g.
+// because the client holding it crashed. Locks of processes, which don't run
+// anymore on this host, are stale right away.
+const StaleLockAge = 24 * time.Hour
+
+// LockRefreshInterval is how often KeepLock refreshes a lock. Locks, which
+// have been refreshed before, are stale once they haven't been refreshed for
+// StaleRefreshAge.
+const (
+	LockRefreshInterval = 5 * time.Minute
+	StaleRefreshAge     = 30 * time.Minute
+)
+
+// ErrLockLost is returned when a client lost its lock on a repository, e.g.
+// because another client removed it. The repository must not be modified
+// anymore then.
+var ErrLockLost = errors.New("Lost the lock on the repository")
+
+// Lock prevents other clients from modifying a repository, while it's being
+// accessed. Any amount of clients can hold shared locks at the same time, e.g.
+// while storing or restoring snapshots. An exclusive lock, e.g. for packing
+// the repository, can only be held by a single client. Clients holding a
+// shared lock additionally take a commit lock while saving the repository's
+// metadata, which only a single client can hold at a time as well.
+type Lock struct {
+	ID        string    `json:"id"`
+	Exclusive bool      `json:"exclusive"`
+	Commit    bool      `json:"commit,omitempty"`
+	Hostname  string    `json:"hostname"`
+	Username  string    `json:"username"`
+	PID       int       `json:"pid"`
+	Created   time.Time `json:"created"`
+	// Refreshed is when the lock got refreshed last by KeepLock. Clients,
+	// which don't refresh their locks, don't set it
+	Refreshed time.Time `json:"refreshed,omitempty"`
+}
+
+// lockState tracks whether the lock held by a client is still valid. It's
+// shared by all copies of a Repository made after locking it.
+type lockState struct {
+	mut       sync.Mutex
+	lost      error
+	exclusive bool
+}
+
+// LockedError records the lock, which prevented locking a repository.
+type LockedError struct {
+	Lock Lock
+}
+
+func (e *LockedError) Error() string {
+	kind := "shared"
+	if e.Lock.Exclusive {
+		kind = "exclusive"
+	} else if e.Lock.Commit {
+		kind = "commit"
+	}
+	return fmt.Sprintf("Repository is locked by %s@%s (PID %d, %s lock %s since %s)",
+		e.Lock.Username, e.Lock.Hostname, e.Lock.PID, kind, e.Lock.ID, e.Lock.Created.Format(time.RFC3339))
+}
+
+// Stale returns true if the client holding the lock is gone.
+func (l Lock) Stale() bool {
+	if l.Refreshed.IsZero() && time.Since(l.Created) > StaleLockAge {
+		return true
+	}
+	if !l.Refreshed.IsZero() && time.Since(l.Refreshed) > StaleRefreshAge {
+		return true
+	}
+	if hostname, _ := os.Hostname(); hostname == l.Hostname {
+		return !processExists(l.PID)
+	}
+	return false
+}
+
+func processExists(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		// finding a process only succeeds if it exists
+		return true
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// conflicts returns true if l and other can't be held at the same time.
+func (l Lock) conflicts(other Lock) bool {
+	return l.Exclusive || other.Exclusive || (l.Commit && other.Commit)
+}
+
+func newLock(exclusive, commit bool) (Lock, error) {
+	l := Lock{
+		Exclusive: exclusive,
+		Commit:    commit,
+		PID:       os.Getpid(),
+		Created:   time.Now(),
+	}
+	u, err := uuid.NewV4()
+	if err != nil {
+		return l, err
+	}
+	l.ID = u.String()
+	l.Hostname, _ = os.Hostname()
+	if usr, err := user.Current(); err == nil {
+		l.Username = usr.Username
+	}
+	return l, nil
+}
+
+// Lock locks the repository, shared or exclusively. It fails with a
+// LockedError if another client holds a conflicting lock, which isn't stale.
+// If none of the storage backends can store locks, or the repository has been
+// opened read-only, the returned lock is nil.
+func (r *Repository) Lock(exclusive bool) (*Lock, error) {
+	l, err := r.storeLock(exclusive, false)
+	if l != nil {
+		r.lock = &lockState{exclusive: exclusive}
+	}
+	return l, err
+}
+
+// storeLock stores a new lock, unless it conflicts with the lock of another
+// client.
+func (r *Repository) storeLock(exclusive, commit bool) (*Lock, error) {
+	lb := r.backend.lockingBackend()
+	if lb == nil || r.backend.ReadOnly {
+		return nil, nil
+	}
+
+	l, err := newLock(exclusive, commit)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLocks(lb, l, ""); err != nil {
+		if err == ErrListingUnsupported {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	b, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	if err := lb.SaveLock(l.ID, b); err != nil {
+		return nil, err
+	}
+
+	// another client may have locked the repository at the same time
+	if err := checkLocks(lb, l, l.ID); err != nil {
+		_ = lb.DeleteLock(l.ID)
+		return nil, err
+	}
+	return &l, nil
+}
+
+// RefreshLock marks l as still being held, so other clients don't consider it
+// stale. It fails with an error wrapping ErrLockLost if the lock has been
+// removed by another client, after which the repository can't be saved
+// anymore.
+func (r *Repository) RefreshLock(l *Lock) error {
+	lb := r.backend.lockingBackend()
+	if l == nil || lb == nil {
+		return nil
+	}
+	if err := r.checkLock(); err != nil {
+		return err
+	}
+
+	ids, err := lb.ListLocks()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, id := range ids {
+		if id == l.ID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		err := fmt.Errorf("%w, it has been removed by another client", ErrLockLost)
+		r.loseLock(err)
+		return err
+	}
+
+	refreshed := *l
+	refreshed.Refreshed = time.Now()
+	b, err := json.Marshal(refreshed)
+	if err != nil {
+		return err
+	}
+	if err := lb.SaveLock(l.ID, b); err != nil {
+		return err
+	}
+	l.Refreshed = refreshed.Refreshed
+	return nil
+}
+
+// KeepLock refreshes l every interval until ctx is done, or a zero interval
+// uses the LockRefreshInterval. If the lock has been removed by another
+// client, or couldn't be refreshed before other clients consider it stale,
+// lost gets called with the error and refreshing stops. The repository can't
+// be saved anymore then.
+func (r *Repository) KeepLock(ctx context.Context, l *Lock, interval time.Duration, lost func(error)) {
+	if l == nil {
+		return
+	}
+	if interval == 0 {
+		interval = LockRefreshInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	refreshed := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := r.RefreshLock(l)
+		if err == nil {
+			refreshed = time.Now()
+			continue
+		}
+		if !errors.Is(err, ErrLockLost) {
+			log.Warnf("Refreshing the repository lock failed: %v", err)
+			if time.Since(refreshed) < StaleRefreshAge-interval {
+				continue
+			}
+			err = fmt.Errorf("%w, refreshing it failed since %s: %v", ErrLockLost, refreshed.Format(time.RFC3339), err)
+			r.loseLock(err)
+		}
+		lost(err)
+		return
+	}
+}
+
+// loseLock records that the lock of the client isn't valid anymore.
+func (r *Repository) loseLock(err error) {
+	if r.lock == nil {
+		return
+	}
+	r.lock.mut.Lock()
+	defer r.lock.mut.Unlock()
+	r.lock.lost = err
+}
+
+// checkLock returns an error if the client lost its lock on the repository.
+func (r *Repository) checkLock() error {
+	if r.lock == nil {
+		return nil
+	}
+	r.lock.mut.Lock()
+	defer r.lock.mut.Unlock()
+	return r.lock.lost
+}
+
+// checkLocks returns a LockedError if any lock other than own conflicts with
+// lock.
+func checkLocks(lb LockingBackend, lock Lock, own string) error {
+	locks, err := loadLocks(lb)
+	if err != nil {
+		return err
+	}
+	for _, l := range locks {
+		if l.ID == own || l.Stale() {
+			continue
+		}
+		if lock.conflicts(l) {
+			return &LockedError{l}
+		}
+	}
+	return nil
+}
+
+func loadLocks(lb LockingBackend) ([]Lock, error) {
+	ids, err := lb.ListLocks()
+	if err != nil {
+		return nil, err
+	}
+
+	var locks []Lock
+	for _, id := range ids {
+		b, err := lb.LoadLock(id)
+		if err != nil {
+			// the lock got released in the meantime
+			continue
+		}
+		var l Lock
+		if err := json.Unmarshal(b, &l); err != nil || l.ID != id {
+			// damaged locks are stale, so they get ignored and removed
+			l = Lock{ID: id, Exclusive: true}
+		}
+		locks = append(locks, l)
+	}
+	return locks, nil
+}
+
+// Unlock releases a lock. Releasing a nil lock does nothing.
+func (r *Repository) Unlock(l *Lock) error {
+	lb := r.backend.lockingBackend()
+	if l == nil || lb == nil {
+		return nil
+	}
+	return lb.DeleteLock(l.ID)
+}
+
+// Locks returns all locks of the repository.
+func (r *Repository) Locks() ([]Lock, error) {
+	lb := r.backend.lockingBackend()
+	if lb == nil {
+		return nil, nil
+	}
+	return loadLocks(lb)
+}
+
+// RemoveLocks removes the stale locks of the repository, or all of them. It
+// returns the removed locks.
+func (r *Repository) RemoveLocks(all bool) ([]Lock, error) {
+	lb := r.backend.lockingBackend()
+	if lb == nil {
+		return nil, nil
+	}
+	locks, err := loadLocks(lb)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []Lock
+	for _, l := range locks {
+		if !all && !l.Stale() {
+			continue
+		}
+		if err := lb.DeleteLock(l.ID); err != nil {
+			return removed, err
+		}
+		removed = append(removed, l)
+	}
+	return removed, nil
+}
@@ -0,0 +1,170 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, "this_is_a_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+
+	// shared locks don't conflict
+	a, err := r.Lock(false)
+	if err != nil || a == nil {
+		t.Fatalf("Failed locking repository: %v", err)
+	}
+	b, err := r.Lock(false)
+	if err != nil || b == nil {
+		t.Fatalf("Failed locking repository a second time: %v", err)
+	}
+	if _, err := r.Lock(true); err == nil {
+		t.Fatalf("Expected exclusive lock to fail while shared locks are held")
+	} else if _, ok := err.(*LockedError); !ok {
+		t.Fatalf("Expected a LockedError, got %v", err)
+	}
+	if err := r.Unlock(a); err != nil {
+		t.Fatalf("Failed unlocking repository: %s", err)
+	}
+	if err := r.Unlock(b); err != nil {
+		t.Fatalf("Failed unlocking repository: %s", err)
+	}
+
+	x, err := r.Lock(true)
+	if err != nil {
+		t.Fatalf("Failed locking repository exclusively: %s", err)
+	}
+	if _, err := r.Lock(false); err == nil {
+		t.Fatalf("Expected shared lock to fail while an exclusive lock is held")
+	}
+	if removed, err := r.RemoveLocks(false); err != nil || len(removed) != 0 {
+		t.Fatalf("Expected no stale locks to be removed, got %d: %v", len(removed), err)
+	}
+	if err := r.Unlock(x); err != nil {
+		t.Fatalf("Failed unlocking repository: %s", err)
+	}
+
+	// a lock left behind by a client, which crashed a long time ago
+	stale, _ := newLock(true, false)
+	stale.Created = time.Now().Add(-StaleLockAge - time.Hour)
+	data, _ := json.Marshal(stale)
+	if err := r.backend.lockingBackend().SaveLock(stale.ID, data); err != nil {
+		t.Fatalf("Failed saving lock: %s", err)
+	}
+	if !stale.Stale() {
+		t.Errorf("Expected lock to be stale")
+	}
+	l, err := r.Lock(true)
+	if err != nil {
+		t.Fatalf("Expected stale lock to be ignored, got %s", err)
+	}
+	locks, err := r.Locks()
+	if err != nil || len(locks) != 2 {
+		t.Fatalf("Expected 2 locks, got %d: %v", len(locks), err)
+	}
+	removed, err := r.RemoveLocks(false)
+	if err != nil || len(removed) != 1 || removed[0].ID != stale.ID {
+		t.Fatalf("Expected stale lock to be removed, got %v: %v", removed, err)
+	}
+	if removed, err := r.RemoveLocks(true); err != nil || len(removed) != 1 || removed[0].ID != l.ID {
+		t.Fatalf("Expected all locks to be removed, got %v: %v", removed, err)
+	}
+
+	// only a single client can commit at a time, while others keep storing
+	shared, err := r.Lock(false)
+	if err != nil {
+		t.Fatalf("Failed locking repository: %s", err)
+	}
+	c, err := r.storeLock(false, true)
+	if err != nil || c == nil {
+		t.Fatalf("Failed taking commit lock while a shared lock is held: %v", err)
+	}
+	if _, err := r.storeLock(false, true); err == nil {
+		t.Fatalf("Expected a second commit lock to fail")
+	}
+	if _, err := r.Lock(false); err != nil {
+		t.Fatalf("Expected shared lock to succeed while committing, got %s", err)
+	}
+	if _, err := r.Lock(true); err == nil {
+		t.Fatalf("Expected exclusive lock to fail while committing")
+	}
+	_ = r.Unlock(c)
+	_ = r.Unlock(shared)
+}
+
+func TestKeepLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, "this_is_a_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	l, err := r.Lock(true)
+	if err != nil {
+		t.Fatalf("Failed locking repository: %s", err)
+	}
+
+	if err := r.RefreshLock(l); err != nil {
+		t.Fatalf("Failed refreshing lock: %s", err)
+	}
+	locks, err := r.Locks()
+	if err != nil || len(locks) != 1 || locks[0].Refreshed.IsZero() {
+		t.Fatalf("Expected lock to be refreshed, got %v: %v", locks, err)
+	}
+	refreshed := locks[0]
+	refreshed.Refreshed = time.Now().Add(-StaleRefreshAge - time.Minute)
+	if !refreshed.Stale() {
+		t.Errorf("Expected lock, which hasn't been refreshed in time, to be stale")
+	}
+
+	// another client breaks the lock
+	lost := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.KeepLock(ctx, l, 10*time.Millisecond, func(err error) {
+		lost <- err
+	})
+	if _, err := r.RemoveLocks(true); err != nil {
+		t.Fatalf("Failed removing locks: %s", err)
+	}
+	select {
+	case err := <-lost:
+		if !errors.Is(err, ErrLockLost) {
+			t.Errorf("Expected %v, got %v", ErrLockLost, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the lost lock to be detected")
+	}
+
+	if err := r.Save(); !errors.Is(err, ErrLockLost) {
+		t.Errorf("Expected saving the repository to fail with %v, got %v", ErrLockLost, err)
+	}
+	index, _ := OpenChunkIndex(&r)
+	if err := index.Save(&r); !errors.Is(err, ErrLockLost) {
+		t.Errorf("Expected saving the chunk-index to fail with %v, got %v", ErrLockLost, err)
+	}
+}
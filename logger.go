@@ -11,6 +11,8 @@ package knoxite
 type Logger interface {
 	Fatal(v ...interface{})
 	Fatalf(format string, v ...interface{})
+	Error(v ...interface{})
+	Errorf(format string, v ...interface{})
 	Warn(v ...interface{})
 	Warnf(format string, v ...interface{})
 	Print(v ...interface{})
@@ -29,6 +31,12 @@ func SetLogger(l Logger) {
 	log = l
 }
 
+// GetLogger returns the logger set via SetLogger, so storage backends and
+// other packages can log with it, too.
+func GetLogger() Logger {
+	return log
+}
+
 // The quiet NopLogger will be used by default if no logger has been set via SetLogger().
 type NopLogger struct {
 }
@@ -37,6 +45,10 @@ func (nl NopLogger) Fatal(v ...interface{}) {}
 
 func (nl NopLogger) Fatalf(format string, v ...interface{}) {}
 
+func (nl NopLogger) Error(v ...interface{}) {}
+
+func (nl NopLogger) Errorf(format string, v ...interface{}) {}
+
 func (nl NopLogger) Warn(v ...interface{}) {}
 
 func (nl NopLogger) Warnf(format string, v ...interface{}) {}
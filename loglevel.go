@@ -13,6 +13,7 @@ type LogLevel int
 
 const (
 	LogLevelFatal = iota
+	LogLevelError
 	LogLevelWarning
 	LogLevelPrint
 	LogLevelInfo
@@ -20,5 +21,5 @@ const (
 )
 
 func (l LogLevel) String() string {
-	return [...]string{"Fatal", "Warning", "Print", "Info", "Debug"}[l]
+	return [...]string{"Fatal", "Error", "Warning", "Print", "Info", "Debug"}[l]
 }
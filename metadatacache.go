@@ -0,0 +1,112 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// A metadataCache keeps copies of the snapshots and the chunk-index loaded
+// from a remote backend on the local machine, along with their version, e.g.
+// their ETag. As long as their version doesn't change, they don't need to be
+// downloaded again. The copies stay encrypted, just like on the backend.
+type metadataCache string
+
+// newMetadataCache returns the cache of the metadata loaded from be. It's
+// disabled if be isn't a VersionedBackend, or if there's no cache dir.
+func newMetadataCache(be Backend) (metadataCache, VersionedBackend) {
+	vb, ok := be.(VersionedBackend)
+	if !ok {
+		return "", nil
+	}
+	dir, err := CacheDir()
+	if err != nil {
+		return "", nil
+	}
+
+	// the same repository may be accessed with different credentials
+	h := sha256.Sum256([]byte(RedactURL(be.Location())))
+	return metadataCache(filepath.Join(dir, "metadata", hex.EncodeToString(h[:16]))), vb
+}
+
+// load returns the cached copy of an object, if it's still of version.
+func (c metadataCache) load(name, version string) ([]byte, bool) {
+	if c == "" || version == "" {
+		return nil, false
+	}
+	b, err := ioutil.ReadFile(filepath.Join(string(c), name))
+	if err != nil {
+		return nil, false
+	}
+	i := bytes.IndexByte(b, '\n')
+	if i < 0 || string(b[:i]) != version {
+		return nil, false
+	}
+	return b[i+1:], true
+}
+
+// save caches a copy of an object of version.
+func (c metadataCache) save(name, version string, data []byte) {
+	if c == "" || version == "" {
+		return
+	}
+	path := filepath.Join(string(c), name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+
+	b := make([]byte, 0, len(version)+1+len(data))
+	b = append(append(append(b, version...), '\n'), data...)
+	// write to a temporary file first, so we never end up with a partially
+	// written copy
+	if err := ioutil.WriteFile(path+".tmp", b, 0600); err != nil {
+		return
+	}
+	_ = os.Rename(path+".tmp", path)
+}
+
+// loadCached loads an object from be with load, unless the cached copy is
+// still up to date. name identifies the object in the cache, version returns
+// its current version.
+func (backend *BackendManager) loadCached(be *Backend, name string, version func(vb VersionedBackend) (string, error), load func() ([]byte, error)) ([]byte, error) {
+	cache, vb := newMetadataCache(*be)
+	if vb == nil {
+		return load()
+	}
+
+	var v string
+	err := backend.retry(context.Background(), func() error {
+		var err error
+		v, err = version(vb)
+		if errors.Is(err, ErrVersioningUnsupported) {
+			return Permanent(err)
+		}
+		return err
+	})
+	if err != nil {
+		// the object may not exist (anymore)
+		return load()
+	}
+	if b, ok := cache.load(name, v); ok {
+		log.Debugf("Using cached copy of %s from %s", name, RedactURL((*be).Location()))
+		return b, nil
+	}
+
+	b, err := load()
+	if err == nil {
+		cache.save(name, v, b)
+	}
+	return b, err
+}
@@ -0,0 +1,108 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// versionedBackend tells the version of the metadata it stores, and counts
+// how often it got loaded.
+type versionedBackend struct {
+	Backend
+	version string
+	err     error
+	loads   int
+}
+
+func (b *versionedBackend) LoadChunkIndex() ([]byte, error) {
+	b.loads++
+	return b.Backend.LoadChunkIndex()
+}
+
+func (b *versionedBackend) LoadSnapshot(id string) ([]byte, error) {
+	b.loads++
+	return b.Backend.LoadSnapshot(id)
+}
+
+func (b *versionedBackend) ChunkIndexVersion() (string, error) {
+	return b.version, b.err
+}
+
+func (b *versionedBackend) SnapshotVersion(id string) (string, error) {
+	return b.version + id, b.err
+}
+
+func TestMetadataCache(t *testing.T) {
+	cache, err := ioutil.TempDir("", "knoxite.cache")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for cache: %s", err)
+	}
+	defer os.RemoveAll(cache)
+	os.Setenv(EnvCacheDir, cache)
+	defer os.Unsetenv(EnvCacheDir)
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	local, err := BackendFromURL(dir)
+	if err != nil {
+		t.Fatalf("Failed creating backend: %s", err)
+	}
+	if err := local.InitRepository(); err != nil {
+		t.Fatalf("Failed initializing repository: %s", err)
+	}
+
+	vb := &versionedBackend{Backend: local, version: "v1"}
+	var be Backend = vb
+	bm := BackendManager{}
+	bm.AddBackend(&be)
+
+	if err := bm.SaveChunkIndex([]byte("index")); err != nil {
+		t.Fatalf("Failed saving chunk-index: %s", err)
+	}
+	if err := bm.SaveSnapshot("s1", []byte("snapshot")); err != nil {
+		t.Fatalf("Failed saving snapshot: %s", err)
+	}
+	load := func(expected []byte, loads int) {
+		b, err := bm.LoadChunkIndex()
+		if err != nil {
+			t.Fatalf("Failed loading chunk-index: %s", err)
+		}
+		if !bytes.Equal(b, expected) {
+			t.Errorf("Expected chunk-index %q, got %q", expected, b)
+		}
+		if _, err := bm.LoadSnapshot("s1"); err != nil {
+			t.Fatalf("Failed loading snapshot: %s", err)
+		}
+		if vb.loads != loads {
+			t.Errorf("Expected %d loads from the backend, got %d", loads, vb.loads)
+		}
+	}
+
+	load([]byte("index"), 2)
+	// unchanged metadata gets loaded from the cache
+	load([]byte("index"), 2)
+
+	// changed metadata gets loaded again
+	if err := bm.SaveChunkIndex([]byte("changed")); err != nil {
+		t.Fatalf("Failed saving chunk-index: %s", err)
+	}
+	vb.version = "v2"
+	load([]byte("changed"), 4)
+	load([]byte("changed"), 4)
+
+	// the metadata always gets loaded, if its version is unknown
+	vb.err = ErrVersioningUnsupported
+	load([]byte("changed"), 6)
+}
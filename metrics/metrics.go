@@ -0,0 +1,219 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+// Package metrics collects counters and gauges and exposes them in the text
+// format read by Prometheus, either over HTTP or as a file for the textfile
+// collector of the node exporter.
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidMetrics is returned for files, which don't contain metrics.
+var ErrInvalidMetrics = errors.New("Invalid metrics file")
+
+// Metric types.
+const (
+	Counter = "counter"
+	Gauge   = "gauge"
+)
+
+// family holds all samples of a metric, indexed by their labels.
+type family struct {
+	help    string
+	typ     string
+	samples map[string]float64
+}
+
+// Registry collects metrics. It's safe for concurrent use.
+type Registry struct {
+	mut      sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// Add increases the counter name with the given labels, passed as pairs of
+// label names and values, by v.
+func (r *Registry) Add(name, help string, v float64, labels ...string) {
+	r.update(name, help, Counter, labels, func(old float64) float64 {
+		return old + v
+	})
+}
+
+// Set sets the gauge name with the given labels, passed as pairs of label
+// names and values, to v.
+func (r *Registry) Set(name, help string, v float64, labels ...string) {
+	r.update(name, help, Gauge, labels, func(float64) float64 {
+		return v
+	})
+}
+
+func (r *Registry) update(name, help, typ string, labels []string, fn func(old float64) float64) {
+	key := formatLabels(labels)
+
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	f := r.family(name)
+	f.help, f.typ = help, typ
+	f.samples[key] = fn(f.samples[key])
+}
+
+// formatLabels renders label pairs like {name="value",...}.
+func formatLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		pairs = append(pairs, labels[i]+`="`+v+`"`)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// WriteTo writes all metrics in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bw := bufio.NewWriter(w)
+	var n int64
+	for _, name := range names {
+		f := r.families[name]
+		c, err := fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", name, f.help, name, f.typ)
+		n += int64(c)
+		if err != nil {
+			return n, err
+		}
+
+		keys := make([]string, 0, len(f.samples))
+		for k := range f.samples {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			c, err := fmt.Fprintf(bw, "%s%s %s\n", name, k, strconv.FormatFloat(f.samples[k], 'g', -1, 64))
+			n += int64(c)
+			if err != nil {
+				return n, err
+			}
+		}
+	}
+
+	return n, bw.Flush()
+}
+
+// ServeHTTP exposes the metrics to Prometheus.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = r.WriteTo(w)
+}
+
+// LoadFile reads metrics written by WriteFile, so a command can update the
+// metrics of previous runs.
+func LoadFile(path string) (*Registry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := NewRegistry()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			fields := strings.SplitN(line, " ", 4)
+			if len(fields) < 4 {
+				continue
+			}
+			fam := r.family(fields[2])
+			switch fields[1] {
+			case "HELP":
+				fam.help = fields[3]
+			case "TYPE":
+				fam.typ = fields[3]
+			}
+			continue
+		}
+
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(line[i+1:], 64)
+		if err != nil {
+			return nil, ErrInvalidMetrics
+		}
+		name, key := line[:i], ""
+		if j := strings.Index(name, "{"); j >= 0 {
+			name, key = name[:j], name[j:]
+		}
+		r.family(name).samples[key] = v
+	}
+
+	return r, scanner.Err()
+}
+
+// family returns the metric name, creating it if necessary.
+func (r *Registry) family(name string) *family {
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{typ: "untyped", samples: make(map[string]float64)}
+		r.families[name] = f
+	}
+	return f
+}
+
+// WriteFile writes all metrics to path, replacing it atomically, so the
+// textfile collector never reads an incomplete file.
+func (r *Registry) WriteFile(path string) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := r.WriteTo(f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	// the collector runs as another user
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
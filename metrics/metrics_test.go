@@ -0,0 +1,72 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	r.Add("knoxite_requests_total", "Requests served.", 1, "method", "GET")
+	r.Add("knoxite_requests_total", "Requests served.", 2, "method", "GET")
+	r.Add("knoxite_requests_total", "Requests served.", 1, "method", "PUT")
+	r.Set("knoxite_backup_success", "Whether the backup succeeded.", 1, "volume", `say "hi"`)
+	r.Set("knoxite_backup_duration_seconds", "Duration of the backup.", 1.5)
+
+	expected := `# HELP knoxite_backup_duration_seconds Duration of the backup.
+# TYPE knoxite_backup_duration_seconds gauge
+knoxite_backup_duration_seconds 1.5
+# HELP knoxite_backup_success Whether the backup succeeded.
+# TYPE knoxite_backup_success gauge
+knoxite_backup_success{volume="say \"hi\""} 1
+# HELP knoxite_requests_total Requests served.
+# TYPE knoxite_requests_total counter
+knoxite_requests_total{method="GET"} 3
+knoxite_requests_total{method="PUT"} 1
+`
+	var buf bytes.Buffer
+	if _, err := r.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != expected {
+		t.Errorf("Expected metrics:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	dir, err := ioutil.TempDir("", "knoxite.metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "knoxite.prom")
+	if err := r.WriteFile(path); err != nil {
+		t.Fatalf("Failed writing metrics: %s", err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil || string(b) != expected {
+		t.Errorf("Expected metrics file:\n%s\ngot:\n%s (%v)", expected, b, err)
+	}
+	loaded, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("Failed loading metrics: %s", err)
+	}
+	buf.Reset()
+	_, _ = loaded.WriteTo(&buf)
+	if buf.String() != expected {
+		t.Errorf("Expected loaded metrics:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Expected no temporary files to be left behind, found %d files", len(files))
+	}
+}
@@ -0,0 +1,110 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Environment variables overriding the default directories.
+const (
+	EnvConfigDir = "KNOXITE_CONFIG_DIR"
+	EnvCacheDir  = "KNOXITE_CACHE_DIR"
+	EnvStateDir  = "KNOXITE_STATE_DIR"
+)
+
+const appDir = "knoxite"
+
+// Error declarations.
+var (
+	ErrStateDirUnknown = errors.New("%LocalAppData% is not defined")
+)
+
+// ConfigDir returns the directory knoxite's configuration is kept in.
+//
+// Unix:    $XDG_CONFIG_HOME/knoxite, defaults to ~/.config/knoxite
+// macOS:   ~/Library/Application Support/knoxite
+// Windows: %AppData%\knoxite
+//
+// The location can be overridden with $KNOXITE_CONFIG_DIR.
+func ConfigDir() (string, error) {
+	if dir := os.Getenv(EnvConfigDir); dir != "" {
+		return dir, nil
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, appDir), nil
+}
+
+// CacheDir returns the directory knoxite keeps data in that can safely be
+// deleted at any time.
+//
+// Unix:    $XDG_CACHE_HOME/knoxite, defaults to ~/.cache/knoxite
+// macOS:   ~/Library/Caches/knoxite
+// Windows: %LocalAppData%\knoxite
+//
+// The location can be overridden with $KNOXITE_CACHE_DIR.
+func CacheDir() (string, error) {
+	if dir := os.Getenv(EnvCacheDir); dir != "" {
+		return dir, nil
+	}
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, appDir), nil
+}
+
+// StateDir returns the directory knoxite keeps data in that should persist
+// between runs, but isn't part of its configuration, like access tokens.
+//
+// Unix:    $XDG_STATE_HOME/knoxite, defaults to ~/.local/state/knoxite
+// macOS:   ~/Library/Application Support/knoxite
+// Windows: %LocalAppData%\knoxite
+//
+// The location can be overridden with $KNOXITE_STATE_DIR.
+func StateDir() (string, error) {
+	if dir := os.Getenv(EnvStateDir); dir != "" {
+		return dir, nil
+	}
+
+	var dir string
+	switch runtime.GOOS {
+	case "windows":
+		dir = os.Getenv("LocalAppData")
+		if dir == "" {
+			return "", ErrStateDirUnknown
+		}
+
+	case "darwin", "ios":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, "Library", "Application Support")
+
+	default:
+		dir = os.Getenv("XDG_STATE_HOME")
+		if dir == "" || !filepath.IsAbs(dir) {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", err
+			}
+			dir = filepath.Join(home, ".local", "state")
+		}
+	}
+
+	return filepath.Join(dir, appDir), nil
+}
@@ -0,0 +1,87 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// setenv sets an environment variable and returns a func restoring it.
+func setenv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestDirOverrides(t *testing.T) {
+	tests := []struct {
+		env string
+		fn  func() (string, error)
+	}{
+		{EnvConfigDir, ConfigDir},
+		{EnvCacheDir, CacheDir},
+		{EnvStateDir, StateDir},
+	}
+
+	for _, tt := range tests {
+		defer setenv(tt.env, "/tmp/knoxite-"+tt.env)()
+
+		dir, err := tt.fn()
+		if err != nil {
+			t.Fatalf("Failed getting dir for %s: %s", tt.env, err)
+		}
+		if dir != "/tmp/knoxite-"+tt.env {
+			t.Errorf("Expected %s to override the dir, got %s", tt.env, dir)
+		}
+	}
+}
+
+func TestXDGDirs(t *testing.T) {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		t.Skip("XDG dirs are only used on unix")
+	}
+
+	defer setenv("XDG_CONFIG_HOME", "/tmp/xdg/config")()
+	defer setenv("XDG_CACHE_HOME", "/tmp/xdg/cache")()
+	defer setenv("XDG_STATE_HOME", "/tmp/xdg/state")()
+
+	tests := map[string]func() (string, error){
+		"/tmp/xdg/config/knoxite": ConfigDir,
+		"/tmp/xdg/cache/knoxite":  CacheDir,
+		"/tmp/xdg/state/knoxite":  StateDir,
+	}
+	for expected, fn := range tests {
+		dir, err := fn()
+		if err != nil {
+			t.Fatalf("Failed getting dir: %s", err)
+		}
+		if dir != expected {
+			t.Errorf("Expected dir %s, got %s", expected, dir)
+		}
+	}
+
+	// relative paths must be ignored according to the XDG spec
+	defer setenv("XDG_STATE_HOME", "relative")()
+	defer setenv("HOME", "/tmp/home")()
+	dir, err := StateDir()
+	if err != nil {
+		t.Fatalf("Failed getting state dir: %s", err)
+	}
+	if expected := filepath.Join("/tmp/home", ".local", "state", "knoxite"); dir != expected {
+		t.Errorf("Expected state dir %s, got %s", expected, dir)
+	}
+}
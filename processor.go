@@ -0,0 +1,114 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// A Processor inspects the files being stored, e.g. to scan them for malware
+// or sensitive data. Files it flags get recorded with its finding in their
+// Flags, or don't get stored at all with StoreOptions.ExcludeFlagged.
+type Processor interface {
+	// Name identifies the processor in the Flags of the files it flagged
+	Name() string
+	// Process inspects a file, whose content gets read from r. It returns
+	// why the file got flagged, or an empty string if it's fine
+	Process(ctx context.Context, archive *Archive, r io.Reader) (string, error)
+}
+
+// ProcessorError reports a processor, which failed to inspect a file.
+type ProcessorError struct {
+	Processor string
+	Err       error
+}
+
+func (e *ProcessorError) Error() string {
+	return fmt.Sprintf("processor %s failed: %v", e.Processor, e.Err)
+}
+
+func (e *ProcessorError) Unwrap() error {
+	return e.Err
+}
+
+// process passes a file through the processors and records their findings.
+// It returns false if the file mustn't be stored.
+func (s *storer) process(archive *Archive) bool {
+	if archive.Type != File || len(s.opts.Processors) == 0 {
+		return true
+	}
+
+	path := s.sourcePath(archive)
+	for _, p := range s.opts.Processors {
+		r, err := s.opts.Source.Open(s.ctx, path)
+		if os.IsNotExist(err) {
+			// the file has been deleted in the meantime
+			return false
+		}
+		if err != nil {
+			// storing the file reports the error
+			return true
+		}
+		finding, err := p.Process(s.ctx, archive, r)
+		r.Close()
+		if err != nil {
+			// a file which couldn't be inspected still gets stored
+			if s.fail(archive.Path, &ProcessorError{Processor: p.Name(), Err: err}) {
+				return false
+			}
+			continue
+		}
+		if finding == "" {
+			continue
+		}
+		if archive.Flags == nil {
+			archive.Flags = make(map[string]string)
+		}
+		archive.Flags[p.Name()] = finding
+	}
+
+	if len(archive.Flags) == 0 || !s.opts.ExcludeFlagged {
+		return true
+	}
+
+	snapshot := s.snapshot
+	snapshot.mut.Lock()
+	snapshot.Stats.Size -= archive.Size
+	snapshot.Stats.Files--
+	snapshot.mut.Unlock()
+	var findings []string
+	for name, finding := range archive.Flags {
+		findings = append(findings, fmt.Sprintf("flagged by %s: %s", name, finding))
+	}
+	sort.Strings(findings)
+	s.fail(archive.Path, &SkippedError{Path: archive.Path, Reason: strings.Join(findings, ", ")})
+	return false
+}
+
+// Flagged returns the files of the snapshot, which got flagged by a
+// processor.
+func (snapshot *Snapshot) Flagged() []*Archive {
+	snapshot.mut.Lock()
+	defer snapshot.mut.Unlock()
+
+	var flagged []*Archive
+	for _, archive := range snapshot.Archives {
+		if len(archive.Flags) > 0 {
+			flagged = append(flagged, archive)
+		}
+	}
+	sort.Slice(flagged, func(i, j int) bool {
+		return flagged[i].Path < flagged[j].Path
+	})
+	return flagged
+}
@@ -0,0 +1,149 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// signatureProcessor flags files containing a signature.
+type signatureProcessor struct {
+	signature []byte
+}
+
+func (p signatureProcessor) Name() string {
+	return "signature"
+}
+
+func (p signatureProcessor) Process(ctx context.Context, archive *Archive, r io.Reader) (string, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	if bytes.Contains(b, p.signature) {
+		return "signature found", nil
+	}
+	return "", nil
+}
+
+// failingProcessor can't inspect any file.
+type failingProcessor struct{}
+
+func (p failingProcessor) Name() string {
+	return "failing"
+}
+
+func (p failingProcessor) Process(ctx context.Context, archive *Archive, r io.Reader) (string, error) {
+	return "", errors.New("scanner unavailable")
+}
+
+func TestSnapshotProcessors(t *testing.T) {
+	src, err := ioutil.TempDir("", "knoxite.source")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+	if err := ioutil.WriteFile(filepath.Join(src, "clean"), []byte("harmless"), 0600); err != nil {
+		t.Fatalf("Failed creating file: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "infected"), []byte("some EVIL content"), 0600); err != nil {
+		t.Fatalf("Failed creating file: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, "this_is_a_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+
+	tests := []struct {
+		exclude    bool
+		processors []Processor
+		stored     bool
+		skipped    int
+		failed     int
+	}{
+		{false, []Processor{signatureProcessor{[]byte("EVIL")}}, true, 0, 0},
+		{true, []Processor{signatureProcessor{[]byte("EVIL")}}, false, 1, 0},
+		// files which couldn't be inspected get stored anyway
+		{true, []Processor{failingProcessor{}}, true, 0, 2},
+	}
+	for _, tt := range tests {
+		snapshot, err := NewSnapshot("test_snapshot")
+		if err != nil {
+			t.Fatalf("Failed creating snapshot: %s", err)
+		}
+		opts := StoreOptions{
+			CWD:            src,
+			Paths:          []string{src},
+			Encrypt:        EncryptionAES,
+			DataParts:      1,
+			Processors:     tt.processors,
+			ExcludeFlagged: tt.exclude,
+		}
+		var skipped, failed int
+		for p := range snapshot.Add(context.Background(), r, &index, opts) {
+			var perr *ProcessorError
+			switch {
+			case IsSkipped(p.Error):
+				skipped++
+			case errors.As(p.Error, &perr):
+				failed++
+			case p.Error != nil:
+				t.Errorf("Failed adding to snapshot: %s", p.Error)
+			}
+		}
+		if skipped != tt.skipped {
+			t.Errorf("Expected %d skipped files, got %d", tt.skipped, skipped)
+		}
+		if failed != tt.failed {
+			t.Errorf("Expected %d failed inspections, got %d", tt.failed, failed)
+		}
+
+		arc, ok := snapshot.Archives["infected"]
+		if ok != tt.stored {
+			t.Errorf("Expected the flagged file to be stored: %v, got %v", tt.stored, ok)
+		}
+		if !tt.stored {
+			if snapshot.Stats.Files != 1 {
+				t.Errorf("Expected 1 file in the snapshot's statistics, got %d", snapshot.Stats.Files)
+			}
+			continue
+		}
+
+		flagged := snapshot.Flagged()
+		if _, ok := tt.processors[0].(failingProcessor); ok {
+			if len(flagged) != 0 {
+				t.Errorf("Expected no flagged files, got %d", len(flagged))
+			}
+			continue
+		}
+		if len(flagged) != 1 || flagged[0] != arc {
+			t.Errorf("Expected only the infected file to be flagged, got %v", flagged)
+		}
+		if f := arc.Flags["signature"]; f != "signature found" {
+			t.Errorf("Expected the finding to be recorded, got %q", f)
+		}
+	}
+}
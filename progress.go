@@ -7,7 +7,14 @@
 
 package knoxite
 
-import "time"
+import (
+	"context"
+	"time"
+)
+
+// DefaultProgressInterval is the interval in which progress updates get
+// coalesced, unless specified otherwise in StoreOptions or RestoreOptions.
+const DefaultProgressInterval = 100 * time.Millisecond
 
 // Progress contains stats and current path.
 type Progress struct {
@@ -43,3 +50,69 @@ func newProgressError(err error) Progress {
 func (p Progress) TransferSpeed() uint64 {
 	return uint64(float64(p.CurrentItemStats.Transferred) / time.Since(p.Timer).Seconds())
 }
+
+// sendProgress sends p on progress unless ctx is done first. It reports
+// whether p got sent.
+func sendProgress(ctx context.Context, progress chan<- Progress, p Progress) bool {
+	select {
+	case progress <- p:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// throttleProgress coalesces the updates sent on in and forwards them on the
+// returned channel at most once per interval. Only the latest update of each
+// path gets forwarded, in the order they arrived, so the totals stay exact.
+// Errors get forwarded right away, after the pending updates. A zero interval
+// uses the DefaultProgressInterval, a negative one disables throttling. The
+// returned channel gets closed once in got closed.
+func throttleProgress(ctx context.Context, in <-chan Progress, interval time.Duration) <-chan Progress {
+	if interval < 0 {
+		return in
+	}
+	if interval == 0 {
+		interval = DefaultProgressInterval
+	}
+
+	out := make(chan Progress)
+	go func() {
+		defer close(out)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		// the updates not forwarded yet, superseded ones are left out
+		var pending []Progress
+		latest := make(map[string]int)
+		flush := func() {
+			for i, p := range pending {
+				if latest[p.Path] == i {
+					sendProgress(ctx, out, p)
+				}
+			}
+			pending = pending[:0]
+			latest = make(map[string]int)
+		}
+
+		for {
+			select {
+			case p, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				if p.Error != nil {
+					flush()
+					sendProgress(ctx, out, p)
+					continue
+				}
+				latest[p.Path] = len(pending)
+				pending = append(pending, p)
+			case <-ticker.C:
+				flush()
+			}
+		}
+	}()
+	return out
+}
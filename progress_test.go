@@ -8,6 +8,7 @@
 package knoxite
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -36,3 +37,46 @@ func TestProgressError(t *testing.T) {
 		t.Errorf("Expected error, got %s", p.Error)
 	}
 }
+
+func TestThrottleProgress(t *testing.T) {
+	in := make(chan Progress)
+	out := throttleProgress(context.Background(), in, time.Hour)
+
+	go func() {
+		var total uint64
+		for i := 0; i < 1000; i++ {
+			total++
+			p := Progress{Path: "a"}
+			if i%2 == 1 {
+				p.Path = "b"
+			}
+			p.TotalStatistics.Transferred = total
+			in <- p
+		}
+		in <- newProgressError(errors.New("TestError"))
+		in <- Progress{Path: "c"}
+		close(in)
+	}()
+
+	var got []Progress
+	for p := range out {
+		got = append(got, p)
+	}
+	if len(got) != 4 {
+		t.Fatalf("Expected %d updates, got %d", 4, len(got))
+	}
+	if got[0].Path != "a" || got[1].Path != "b" || got[3].Path != "c" {
+		t.Errorf("Expected updates for a, b and c, got %v", got)
+	}
+	if got[1].TotalStatistics.Transferred != 1000 {
+		t.Errorf("Expected %d transferred bytes, got %d", 1000, got[1].TotalStatistics.Transferred)
+	}
+	if got[2].Error == nil {
+		t.Error("Expected error to be forwarded")
+	}
+
+	in = make(chan Progress)
+	if throttleProgress(context.Background(), in, -1) != (<-chan Progress)(in) {
+		t.Error("Expected a negative interval to disable throttling")
+	}
+}
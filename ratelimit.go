@@ -0,0 +1,106 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A RateLimiter limits the rate data gets transferred with. Its rate can be
+// changed while transfers are being limited by it, e.g. to share bandwidth
+// with other transfers.
+type RateLimiter struct {
+	mut  sync.Mutex
+	rate uint64
+	// reserved is the amount of bytes accounted for so far, paid how many of
+	// them fit into the rate as of paidAt
+	reserved float64
+	paid     float64
+	paidAt   time.Time
+	// changed gets closed when the rate changes
+	changed chan struct{}
+}
+
+// NewRateLimiter returns a RateLimiter allowing rate bytes per second. A rate
+// of 0 doesn't limit the transfers.
+func NewRateLimiter(rate uint64) *RateLimiter {
+	return &RateLimiter{
+		rate:    rate,
+		changed: make(chan struct{}),
+	}
+}
+
+// SetRate changes the rate to rate bytes per second, 0 removes the limit.
+// Transfers waiting already continue at the new rate.
+func (l *RateLimiter) SetRate(rate uint64) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	l.settle(time.Now())
+	l.rate = rate
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// Rate returns the rate in bytes per second, 0 if it's unlimited.
+func (l *RateLimiter) Rate() uint64 {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	return l.rate
+}
+
+// settle updates how many of the reserved bytes fit into the rate as of now.
+// The caller must hold the mutex.
+func (l *RateLimiter) settle(now time.Time) {
+	if l.rate > 0 {
+		l.paid += now.Sub(l.paidAt).Seconds() * float64(l.rate)
+	}
+	// time without any transfers doesn't allow bursts afterwards
+	if l.rate == 0 || l.paid > l.reserved {
+		l.paid = l.reserved
+	}
+	l.paidAt = now
+}
+
+// Wait accounts for a transfer of n bytes and blocks until the transfers
+// accounted for so far don't exceed the rate anymore, or until ctx is done. A
+// nil RateLimiter never blocks.
+func (l *RateLimiter) Wait(ctx context.Context, n uint64) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mut.Lock()
+	l.settle(time.Now())
+	l.reserved += float64(n)
+	until := l.reserved
+	l.mut.Unlock()
+
+	for {
+		l.mut.Lock()
+		l.settle(time.Now())
+		if l.paid >= until {
+			l.mut.Unlock()
+			return nil
+		}
+		wait := time.Duration((until - l.paid) / float64(l.rate) * float64(time.Second))
+		changed := l.changed
+		l.mut.Unlock()
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-changed:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,62 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	var nl *RateLimiter
+	if err := nl.Wait(context.Background(), 1<<30); err != nil {
+		t.Errorf("Expected a nil limiter not to block, got %s", err)
+	}
+
+	l := NewRateLimiter(10000)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := l.Wait(context.Background(), 1000); err != nil {
+			t.Fatalf("Failed waiting: %s", err)
+		}
+	}
+	if d := time.Since(start); d < 300*time.Millisecond {
+		t.Errorf("Expected transferring 3000 bytes at 10000 bytes/s to take at least 300ms, took %s", d)
+	}
+
+	// waiting transfers continue at the new rate
+	l.SetRate(100)
+	done := make(chan time.Time)
+	go func() {
+		_ = l.Wait(context.Background(), 1000)
+		done <- time.Now()
+	}()
+	time.Sleep(50 * time.Millisecond)
+	start = time.Now()
+	l.SetRate(100000)
+	if d := (<-done).Sub(start); d > 100*time.Millisecond {
+		t.Errorf("Expected a waiting transfer to speed up when the rate increases, took %s", d)
+	}
+
+	l.SetRate(0)
+	start = time.Now()
+	if err := l.Wait(context.Background(), 1<<30); err != nil {
+		t.Fatalf("Failed waiting: %s", err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("Expected an unlimited rate not to block, took %s", d)
+	}
+
+	l.SetRate(1)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx, 1000); err != context.Canceled {
+		t.Errorf("Expected error %v, got %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,169 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"io"
+	"sort"
+	"time"
+)
+
+// RechunkSnapshot divides the files of a snapshot into chunks again, using the
+// chunker settings of opts, and stores the new chunks. Every file keeps its
+// compression, encryption and redundancy settings. As equal data results in
+// equal chunks, files already rechunked by other snapshots get deduplicated.
+//
+// The snapshot and the chunk-index get updated once all files have been
+// rechunked. Save the chunk-index after each snapshot, so an interrupted
+// migration can be resumed by skipping the snapshots, whose ChunkerSettings
+// match already. The chunks no longer referenced get removed by packing the
+// repository.
+func RechunkSnapshot(ctx context.Context, repository *Repository, index *ChunkIndex, snapshot *Snapshot, opts StoreOptions) (Stats, error) {
+	var stats Stats
+	if err := opts.Chunker.orDefault().Validate(); err != nil {
+		return stats, err
+	}
+	if opts.Concurrency == 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+
+	paths := make([]string, 0, len(snapshot.Archives))
+	for path := range snapshot.Archives {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	rechunked := make(map[string]*Archive)
+	for _, path := range paths {
+		arc := snapshot.Archives[path]
+		if arc.Type != File || arc.LinkTo != "" || len(arc.Chunks) == 0 {
+			continue
+		}
+
+		a, err := rechunkArchive(ctx, repository, snapshot, *arc, opts)
+		if err != nil {
+			return stats, err
+		}
+		stats.Files++
+		stats.Size += a.Size
+		stats.StorageSize += a.StorageSize
+		rechunked[path] = a
+	}
+
+	// hard links share the chunks of the item they link to
+	for path, arc := range snapshot.Archives {
+		if target, ok := rechunked[arc.LinkTo]; ok && arc.LinkTo != "" {
+			a := *arc
+			a.Chunks = target.Chunks
+			rechunked[path] = &a
+		}
+	}
+
+	for path, arc := range rechunked {
+		snapshot.Stats.StorageSize += arc.StorageSize - snapshot.Archives[path].StorageSize
+		snapshot.Archives[path] = arc
+	}
+	snapshot.setChunker(opts.Chunker)
+
+	// the snapshot gets replaced, hence the backends reject overwriting it
+	// in append-only repositories
+	if err := snapshot.Save(repository); err != nil {
+		return stats, err
+	}
+	index.RemoveSnapshot(snapshot.ID)
+	for _, arc := range snapshot.Archives {
+		index.AddArchive(arc, snapshot.ID)
+	}
+	return stats, nil
+}
+
+// rechunkArchive streams the content of a file through the chunker and
+// returns a copy of arc, which refers to the new chunks.
+func rechunkArchive(ctx context.Context, repository *Repository, snapshot *Snapshot, arc Archive, opts StoreOptions) (*Archive, error) {
+	opts.Compress = arc.Compressed
+	opts.Encrypt = arc.Encrypted
+	opts.dedupDomain = snapshot.DedupDomain
+	opts.chunkHeaders = repository.chunkHeaders()
+	opts.DataParts = 1
+	opts.ParityParts = 0
+	for _, chunk := range arc.Chunks {
+		if !chunk.Hole {
+			opts.DataParts, opts.ParityParts = chunk.DataParts, chunk.ParityParts
+			break
+		}
+	}
+
+	// stops chunking once storing a chunk failed
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	r, w := io.Pipe()
+	go func() {
+		for i := uint(0); i < uint(len(arc.Chunks)); i++ {
+			idx, err := arc.IndexOfChunk(i)
+			if err != nil {
+				_ = w.CloseWithError(err)
+				return
+			}
+			b, err := loadChunk(ctx, *repository, arc, arc.Chunks[idx], opts.Timings)
+			if err == nil {
+				_, err = w.Write(b)
+			}
+			if err != nil {
+				_ = w.CloseWithError(err)
+				return
+			}
+		}
+		_ = w.Close()
+	}()
+
+	chunks, err := chunkFile(ctx, r, snapshot.encryptionKey(repository, opts.Encrypt), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	a := arc
+	a.Chunks = nil
+	a.StorageSize = 0
+	for cd := range chunks {
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err == nil {
+			err = cd.Error
+		}
+		if err != nil {
+			// drain the remaining chunks, so the chunker can finish
+			continue
+		}
+
+		chunk := cd.Chunk
+		if !chunk.Hole {
+			start := time.Now()
+			n, serr := repository.backend.StoreChunk(ctx, chunk)
+			opts.Timings.since(StageUploading, start)
+			if serr != nil {
+				err = serr
+				cancel()
+				continue
+			}
+			a.StorageSize += n
+		}
+		chunk.Data = &[][]byte{}
+		a.Chunks = append(a.Chunks, chunk)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(a.Chunks, func(i, j int) bool {
+		return a.Chunks[i].Num < a.Chunks[j].Num
+	})
+	return &a, nil
+}
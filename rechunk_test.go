@@ -0,0 +1,122 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRechunkSnapshot(t *testing.T) {
+	testPassword := "this_is_a_password"
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	src, err := ioutil.TempDir("", "knoxite.src")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	data := make([]byte, 3*preferredChunkSize+1234)
+	rand.Read(data)
+	files := map[string][]byte{
+		"a.bin":     data,
+		"sub/b.bin": data,
+		"c.txt":     []byte("some text"),
+	}
+	for name, content := range files {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	snapshot, _ := NewSnapshot("test_snapshot")
+	opts := StoreOptions{
+		CWD:       src,
+		Paths:     []string{src},
+		Compress:  CompressionZstd,
+		Encrypt:   EncryptionAESGCM,
+		DataParts: 1,
+	}
+	for p := range snapshot.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Fatalf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+	if snapshot.Chunker != nil {
+		t.Errorf("Expected snapshot to use the default chunker settings, got %v", snapshot.ChunkerSettings())
+	}
+
+	settings := ChunkerSettings{Fixed: true, MaxSize: 256 * 1024}
+	opts.Chunker = settings
+	stats, err := RechunkSnapshot(context.Background(), &r, &index, snapshot, opts)
+	if err != nil {
+		t.Fatalf("Failed rechunking snapshot: %s", err)
+	}
+	if stats.Files != 3 {
+		t.Errorf("Expected 3 files to be rechunked, got %d", stats.Files)
+	}
+
+	snapshot, err = openSnapshot(snapshot.ID, &r)
+	if err != nil {
+		t.Fatalf("Failed opening snapshot: %s", err)
+	}
+	if snapshot.ChunkerSettings() != settings {
+		t.Errorf("Expected chunker settings %v, got %v", settings, snapshot.ChunkerSettings())
+	}
+	a, b := snapshot.Archives["a.bin"], snapshot.Archives["sub/b.bin"]
+	if len(a.Chunks) != 13 {
+		t.Errorf("Expected 13 chunks, got %d", len(a.Chunks))
+	}
+	for i := range a.Chunks {
+		if a.Chunks[i].OriginalSize > int(settings.MaxSize) {
+			t.Errorf("Chunk %d exceeds the maximum chunk size: %d", i, a.Chunks[i].OriginalSize)
+		}
+		// equal files share their chunks
+		if a.Chunks[i].Hash != b.Chunks[i].Hash {
+			t.Errorf("Expected chunk %d of equal files to be deduplicated", i)
+		}
+	}
+
+	var buf bytes.Buffer
+	progress, err := ExportSnapshot(context.Background(), r, snapshot, &buf, FormatTar, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Failed exporting snapshot: %s", err)
+	}
+	for p := range progress {
+		if p.Error != nil {
+			t.Fatalf("Failed exporting snapshot: %s", p.Error)
+		}
+	}
+	compareExported(t, "rechunked", files, readTar(t, &buf))
+
+	if _, err := RechunkSnapshot(context.Background(), &r, &index, snapshot, StoreOptions{Chunker: ChunkerSettings{MaxSize: 1}}); err != ErrInvalidChunkerSettings {
+		t.Errorf("Expected %v, got %v", ErrInvalidChunkerSettings, err)
+	}
+}
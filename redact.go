@@ -0,0 +1,131 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+)
+
+// A Redactor hides private information in the metadata of snapshots, e.g.
+// when a repository is shared with others. The stored data itself doesn't
+// get redacted.
+type Redactor interface {
+	// RedactHostname returns the hostname to be stored
+	RedactHostname(hostname string) string
+	// RedactPath returns the path to be stored. Distinct paths must remain
+	// distinct
+	RedactPath(path string) string
+	// RedactOwner returns the owner and group IDs to be stored
+	RedactOwner(uid, gid uint32) (uint32, uint32)
+}
+
+// RedactMode defines how NameRedactor redacts a name.
+type RedactMode int
+
+// Available redaction modes.
+const (
+	RedactKeep RedactMode = iota
+	RedactStrip
+	RedactHash
+)
+
+// NameRedactor strips or hashes hostnames, owners and path components.
+// Hashes are keyed with a secret, so only those knowing it can tell which
+// name a hash stands for.
+type NameRedactor struct {
+	Secret string
+
+	// Hostname defines whether the hostname gets stripped or hashed
+	Hostname RedactMode
+	// Owners strips owner and group IDs
+	Owners bool
+	// Components are the path components getting hashed wherever they
+	// appear in a path, e.g. user names
+	Components []string
+}
+
+// Hash returns the hash a name gets replaced with.
+func (r NameRedactor) Hash(name string) string {
+	mac := hmac.New(sha256.New, []byte(r.Secret))
+	mac.Write([]byte(name))
+	return "redacted-" + hex.EncodeToString(mac.Sum(nil))[:12]
+}
+
+// RedactHostname strips or hashes the hostname.
+func (r NameRedactor) RedactHostname(hostname string) string {
+	switch r.Hostname {
+	case RedactStrip:
+		return ""
+	case RedactHash:
+		return r.Hash(hostname)
+	}
+	return hostname
+}
+
+// RedactPath hashes all of path's components matching one of r.Components.
+func (r NameRedactor) RedactPath(path string) string {
+	if len(r.Components) == 0 || path == "" {
+		return path
+	}
+
+	sep := string(filepath.Separator)
+	components := strings.Split(path, sep)
+	for i, c := range components {
+		for _, name := range r.Components {
+			if c == name {
+				components[i] = r.Hash(c)
+				break
+			}
+		}
+	}
+	return strings.Join(components, sep)
+}
+
+// RedactOwner strips the owner and group IDs, if r.Owners is set.
+func (r NameRedactor) RedactOwner(uid, gid uint32) (uint32, uint32) {
+	if r.Owners {
+		return 0, 0
+	}
+	return uid, gid
+}
+
+// Redact hides private information in the snapshot's metadata. It needs to
+// be called before saving the snapshot, right after all items have been
+// added.
+func (snapshot *Snapshot) Redact(r Redactor) {
+	snapshot.mut.Lock()
+	defer snapshot.mut.Unlock()
+
+	snapshot.Hostname = r.RedactHostname(snapshot.Hostname)
+	// user names commonly appear as path components, e.g. of home
+	// directories, so they get redacted alike
+	snapshot.Username = r.RedactPath(snapshot.Username)
+	for i, path := range snapshot.Subtrees {
+		snapshot.Subtrees[i] = r.RedactPath(path)
+	}
+	for i, path := range snapshot.Paths {
+		snapshot.Paths[i] = r.RedactPath(path)
+	}
+	for i, arg := range snapshot.CommandLine {
+		snapshot.CommandLine[i] = r.RedactPath(arg)
+	}
+
+	archives := make(map[string]*Archive, len(snapshot.Archives))
+	for _, archive := range snapshot.Archives {
+		archive.Path = r.RedactPath(archive.Path)
+		archive.LinkTo = r.RedactPath(archive.LinkTo)
+		archive.PointsTo = r.RedactPath(archive.PointsTo)
+		archive.UID, archive.GID = r.RedactOwner(archive.UID, archive.GID)
+		archives[archive.Path] = archive
+	}
+	snapshot.Archives = archives
+}
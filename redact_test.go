@@ -0,0 +1,73 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	home := filepath.Join("home", "alice")
+	snapshot, _ := NewSnapshot("test")
+	snapshot.Hostname = "laptop"
+	snapshot.Username = "alice"
+	snapshot.Subtrees = []string{home}
+	snapshot.Paths = []string{home}
+	snapshot.CommandLine = []string{"knoxite", "store", home}
+	snapshot.AddArchive(&Archive{Path: home, Type: Directory, UID: 1000, GID: 1000})
+	snapshot.AddArchive(&Archive{Path: filepath.Join(home, "alice"), Type: File, UID: 1000, GID: 1000})
+	snapshot.AddArchive(&Archive{Path: filepath.Join("home", "bob"), Type: SymLink, PointsTo: home})
+
+	r := NameRedactor{
+		Secret:     "secret",
+		Hostname:   RedactHash,
+		Owners:     true,
+		Components: []string{"alice"},
+	}
+	snapshot.Redact(r)
+
+	if snapshot.Hostname != r.Hash("laptop") {
+		t.Errorf("Expected hashed hostname, got %s", snapshot.Hostname)
+	}
+	redacted := filepath.Join("home", r.Hash("alice"))
+	if snapshot.Subtrees[0] != redacted {
+		t.Errorf("Expected subtree %s, got %s", redacted, snapshot.Subtrees[0])
+	}
+	if snapshot.Username != r.Hash("alice") {
+		t.Errorf("Expected hashed username, got %s", snapshot.Username)
+	}
+	if snapshot.Paths[0] != redacted || snapshot.CommandLine[2] != redacted {
+		t.Errorf("Expected paths %s and command line %v to be redacted", snapshot.Paths, snapshot.CommandLine)
+	}
+	if len(snapshot.Archives) != 3 {
+		t.Fatalf("Expected 3 items, got %d", len(snapshot.Archives))
+	}
+	for path, archive := range snapshot.Archives {
+		if strings.Contains(path, "alice") || strings.Contains(archive.PointsTo, "alice") {
+			t.Errorf("Expected %s to be redacted", path)
+		}
+		if path != archive.Path {
+			t.Errorf("Expected item %s to be stored as %s", archive.Path, path)
+		}
+		if archive.UID != 0 || archive.GID != 0 {
+			t.Errorf("Expected owner of %s to be stripped", path)
+		}
+	}
+	if _, ok := snapshot.Archives[filepath.Join(redacted, r.Hash("alice"))]; !ok {
+		t.Errorf("Expected all matching components to be redacted")
+	}
+
+	if other := (NameRedactor{Secret: "other"}); other.Hash("alice") == r.Hash("alice") {
+		t.Errorf("Expected hashes to depend on the secret")
+	}
+	if stripped := (NameRedactor{Hostname: RedactStrip}).RedactHostname("laptop"); stripped != "" {
+		t.Errorf("Expected stripped hostname, got %s", stripped)
+	}
+}
@@ -0,0 +1,228 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// Error declarations.
+var (
+	ErrChunkUnrepairable = errors.New("Chunk can't be repaired from the remaining parts")
+)
+
+// RepairedChunk describes which parts of a chunk have been repaired.
+type RepairedChunk struct {
+	Hash    string `json:"hash"`
+	Missing []uint `json:"missing,omitempty"`
+	Corrupt []uint `json:"corrupt,omitempty"`
+}
+
+// RepairReport describes the outcome of a repair operation.
+type RepairReport struct {
+	// Checked is the amount of chunks which have been checked
+	Checked  int             `json:"checked"`
+	Repaired []RepairedChunk `json:"repaired"`
+	// Unrepairable maps the hashes of chunks, which couldn't be repaired, to
+	// the reason why
+	Unrepairable map[string]string `json:"unrepairable"`
+}
+
+// Repair checks all chunks of the given snapshots. Missing or corrupt parts
+// of chunks stored with parity parts get reconstructed from the remaining
+// parts and stored again. With dryRun set, damaged chunks only get reported.
+func Repair(ctx context.Context, repository Repository, snapshots []*Snapshot, dryRun bool) (*RepairReport, error) {
+	report := &RepairReport{
+		Unrepairable: make(map[string]string),
+	}
+
+	checked := make(map[string]bool)
+	for _, snapshot := range snapshots {
+		paths := make([]string, 0, len(snapshot.Archives))
+		for path := range snapshot.Archives {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		for _, path := range paths {
+			archive := snapshot.Archives[path]
+			for _, chunk := range archive.Chunks {
+				if chunk.Hole || checked[chunk.Hash] {
+					continue
+				}
+				checked[chunk.Hash] = true
+				report.Checked++
+
+				repaired, err := RepairChunk(ctx, repository, *archive, chunk, dryRun)
+				if ctx.Err() != nil {
+					return report, ctx.Err()
+				}
+				if err != nil {
+					report.Unrepairable[chunk.Hash] = err.Error()
+					continue
+				}
+				if repaired != nil {
+					report.Repaired = append(report.Repaired, *repaired)
+				}
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// RepairChunk checks a single chunk and repairs its missing or corrupt parts.
+// It returns nil if the chunk is intact. Only chunks with parity parts can be
+// repaired, and at most one corrupt part gets detected per chunk.
+func RepairChunk(ctx context.Context, repository Repository, archive Archive, chunk Chunk, dryRun bool) (*RepairedChunk, error) {
+	if chunk.ParityParts == 0 {
+		// without parity there is nothing to reconstruct from
+		_, err := loadChunk(ctx, repository, archive, chunk, nil)
+		return nil, err
+	}
+
+	total := chunk.DataParts + chunk.ParityParts
+	enc, err := reedsolomon.New(int(chunk.DataParts), int(chunk.ParityParts))
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([][]byte, total)
+	sources := make([]*Backend, total)
+	compression, encryption := archive.Compressed, archive.Encrypted
+	var missing []uint
+	for i := uint(0); i < total; i++ {
+		b, be, err := repository.backend.locateChunk(ctx, chunk, i)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			missing = append(missing, i)
+			continue
+		}
+
+		header, data, err := SplitChunkHeader(b)
+		if err == nil && header != nil && header.matches(chunk, i) {
+			b = data
+			compression, encryption = header.Compression, header.Encryption
+		}
+		parts[i] = b
+		sources[i] = be
+	}
+	if uint(len(missing)) > chunk.ParityParts {
+		return nil, &DataReconstructionError{chunk, total - uint(len(missing)), uint(len(missing))}
+	}
+
+	// reconstruct reports whether the chunk can be restored without the
+	// given part, and fills in all missing parts if it can
+	reconstruct := func(without int) ([][]byte, bool) {
+		shards := make([][]byte, total)
+		copy(shards, parts)
+		if without >= 0 {
+			shards[without] = nil
+		}
+		if err := enc.Reconstruct(shards); err != nil {
+			return nil, false
+		}
+		// catches corrupt parity parts, which don't affect the data itself
+		if ok, err := enc.Verify(shards); err != nil || !ok {
+			return nil, false
+		}
+
+		var b bytes.Buffer
+		if err := enc.Join(&b, shards, chunk.Size); err != nil {
+			return nil, false
+		}
+		if _, err := decodeChunk(repository, compression, encryption, chunk, b.Bytes(), nil); err != nil {
+			return nil, false
+		}
+		return shards, true
+	}
+
+	result := &RepairedChunk{Hash: chunk.Hash, Missing: missing}
+	shards, ok := reconstruct(-1)
+	if !ok {
+		// find the corrupt part, as long as we can afford to lose another one
+		if uint(len(missing)) < chunk.ParityParts {
+			for i := range parts {
+				if parts[i] == nil {
+					continue
+				}
+				if shards, ok = reconstruct(i); ok {
+					result.Corrupt = []uint{uint(i)}
+					break
+				}
+			}
+		}
+		if !ok {
+			return nil, ErrChunkUnrepairable
+		}
+	}
+	if len(result.Missing) == 0 && len(result.Corrupt) == 0 {
+		return nil, nil
+	}
+	if dryRun {
+		return result, nil
+	}
+
+	for _, i := range append(append([]uint{}, result.Missing...), result.Corrupt...) {
+		be := sources[i]
+		if be == nil {
+			be = repairTarget(&repository.backend, sources, i)
+		} else {
+			// backends skip parts that already exist with the same size
+			_ = (*be).DeleteChunk(ctx, chunk.Hash, i, chunk.DataParts)
+		}
+
+		data := shards[i]
+		if repository.chunkHeaders() {
+			header := ChunkHeader{
+				Version:     ChunkFormatVersion,
+				Compression: compression,
+				Encryption:  encryption,
+				Part:        uint16(i),
+				DataParts:   uint16(chunk.DataParts),
+				ParityParts: uint16(chunk.ParityParts),
+				Size:        uint32(chunk.Size),
+			}
+			h, _ := header.MarshalBinary()
+			data = append(h, data...)
+		}
+		if err := repository.backend.storeChunkPart(ctx, be, chunk, i, data); err != nil {
+			return result, fmt.Errorf("storing part %d failed: %v", i+1, err)
+		}
+		sources[i] = be
+	}
+
+	return result, nil
+}
+
+// repairTarget picks the backend to store a lost part of a chunk on. It
+// prefers backends which don't store any other part of the chunk yet, so the
+// chunk keeps its failure tolerance.
+func repairTarget(backend *BackendManager, sources []*Backend, part uint) *Backend {
+	used := make(map[*Backend]bool)
+	for _, be := range sources {
+		if be != nil {
+			used[be] = true
+		}
+	}
+	for _, be := range backend.Backends {
+		if !used[be] {
+			return be
+		}
+	}
+
+	return backend.Backends[int(part)%len(backend.Backends)]
+}
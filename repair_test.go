@@ -0,0 +1,208 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRepair(t *testing.T) {
+	testPassword := "this_is_a_password"
+
+	src, err := ioutil.TempDir("", "knoxite.source")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	content := make([]byte, 4096)
+	_, _ = rand.Read(content)
+	if err := ioutil.WriteFile(filepath.Join(src, "data"), content, 0600); err != nil {
+		t.Fatalf("Failed writing test file: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	snapshot, err := NewSnapshot("test_snapshot")
+	if err != nil {
+		t.Fatalf("Failed creating snapshot: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+
+	opts := StoreOptions{
+		CWD:         src,
+		Paths:       []string{src},
+		Encrypt:     EncryptionAES,
+		DataParts:   2,
+		ParityParts: 1,
+	}
+	for p := range snapshot.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Fatalf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+
+	chunk := snapshot.Archives["data"].Chunks[0]
+	partPath := func(part int) string {
+		return filepath.Join(dir, chunksDirname, SubDirForChunk(chunk.Hash), chunk.Hash+"."+strconv.Itoa(part)+"_2")
+	}
+	corrupt := func(part int) {
+		b, err := ioutil.ReadFile(partPath(part))
+		if err != nil {
+			t.Fatalf("Failed reading chunk: %s", err)
+		}
+		b[len(b)-1] ^= 0xff
+		if err := ioutil.WriteFile(partPath(part), b, 0600); err != nil {
+			t.Fatalf("Failed writing chunk: %s", err)
+		}
+	}
+
+	tests := []struct {
+		damage   func()
+		expected *RepairedChunk
+	}{
+		{func() {}, nil},
+		{func() { _ = os.Remove(partPath(0)) }, &RepairedChunk{Hash: chunk.Hash, Missing: []uint{0}}},
+		{func() { corrupt(1) }, &RepairedChunk{Hash: chunk.Hash, Corrupt: []uint{1}}},
+		{func() { corrupt(2) }, &RepairedChunk{Hash: chunk.Hash, Corrupt: []uint{2}}},
+	}
+	for _, tt := range tests {
+		tt.damage()
+
+		// a dry-run must not change anything
+		for _, dryRun := range []bool{true, false} {
+			repaired, err := RepairChunk(context.Background(), r, *snapshot.Archives["data"], chunk, dryRun)
+			if err != nil {
+				t.Fatalf("Failed repairing chunk: %s", err)
+			}
+			if !reflect.DeepEqual(repaired, tt.expected) {
+				t.Errorf("Expected %+v, got %+v", tt.expected, repaired)
+			}
+		}
+
+		report, err := Repair(context.Background(), r, []*Snapshot{snapshot}, true)
+		if err != nil {
+			t.Fatalf("Failed repairing snapshot: %s", err)
+		}
+		if report.Checked != 1 || len(report.Repaired) != 0 || len(report.Unrepairable) != 0 {
+			t.Errorf("Expected repaired chunk to be intact, got %+v", report)
+		}
+	}
+
+	// too much damage
+	_ = os.Remove(partPath(0))
+	_ = os.Remove(partPath(1))
+	report, err := Repair(context.Background(), r, []*Snapshot{snapshot}, false)
+	if err != nil {
+		t.Fatalf("Failed repairing snapshot: %s", err)
+	}
+	if _, ok := report.Unrepairable[chunk.Hash]; !ok {
+		t.Errorf("Expected chunk %s to be unrepairable, got %+v", chunk.Hash, report)
+	}
+}
+
+// warnLogger records the warnings logged.
+type warnLogger struct {
+	NopLogger
+	warnings []string
+}
+
+func (l *warnLogger) Warnf(format string, v ...interface{}) {
+	l.warnings = append(l.warnings, fmt.Sprintf(format, v...))
+}
+
+func TestLoadChunkFromParity(t *testing.T) {
+	testPassword := "this_is_a_password"
+
+	src, err := ioutil.TempDir("", "knoxite.source")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	content := make([]byte, 4096)
+	_, _ = rand.Read(content)
+	if err := ioutil.WriteFile(filepath.Join(src, "data"), content, 0600); err != nil {
+		t.Fatalf("Failed writing test file: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	snapshot, err := NewSnapshot("test_snapshot")
+	if err != nil {
+		t.Fatalf("Failed creating snapshot: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+
+	opts := StoreOptions{
+		CWD:         src,
+		Paths:       []string{src},
+		Encrypt:     EncryptionAES,
+		DataParts:   2,
+		ParityParts: 1,
+	}
+	for p := range snapshot.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Fatalf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+
+	archive := snapshot.Archives["data"]
+	chunk := archive.Chunks[0]
+	part := ChunkPart{chunk.Hash, 0, 2}
+	if err := os.Remove(filepath.Join(dir, chunksDirname, SubDirForChunk(chunk.Hash), part.String())); err != nil {
+		t.Fatalf("Failed removing chunk part: %s", err)
+	}
+
+	logger := &warnLogger{}
+	defer SetLogger(GetLogger())
+	SetLogger(logger)
+
+	b, err := loadChunk(context.Background(), r, *archive, chunk, nil)
+	if err != nil {
+		t.Fatalf("Failed loading chunk without its first part: %s", err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Error("Reconstructed chunk doesn't match the stored data")
+	}
+	if len(logger.warnings) != 1 || !strings.Contains(logger.warnings[0], part.String()) {
+		t.Errorf("Expected a warning about the missing part %s, got %v", part, logger.warnings)
+	}
+}
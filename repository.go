@@ -9,31 +9,81 @@ package knoxite
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"reflect"
+	"strings"
+
+	uuid "github.com/nu7hatch/gouuid"
 )
 
 // A Repository is a collection of backup snapshots.
 type Repository struct {
+	ID      string    `json:"id"`
 	Version uint      `json:"version"`
 	Volumes []*Volume `json:"volumes"`
 	Paths   []string  `json:"storage"`
 	Key     string    `json:"key"` // key for encrypting data stored with knoxite
 	// Owner   string    `json:"owner"`
 
+	// RetiredKeys holds the keys data got encrypted with before the key got
+	// rotated, until all data has been re-encrypted with Key
+	RetiredKeys []string `json:"retired_keys,omitempty"`
+
+	// ReaderVersion is the oldest repository version a version of knoxite
+	// needs to support, in order to correctly read the repository. Versions
+	// of knoxite only supporting older repository versions refuse to open it
+	ReaderVersion uint `json:"reader_version,omitempty"`
+
+	// PublicKey seals all data of repositories using asymmetric encryption.
+	// The private key needed to read it isn't stored in the repository
+	PublicKey []byte `json:"public_key,omitempty"`
+
+	// Verifications maps the IDs of snapshots to their last verification
+	Verifications map[string]Verification `json:"verifications,omitempty"`
+
+	// AppendOnly prevents clients without an admin key from removing or
+	// overwriting data
+	AppendOnly bool `json:"append_only,omitempty"`
+
+	// Chunker holds the settings new snapshots get divided into chunks with,
+	// unless these are the DefaultChunkerSettings
+	Chunker *ChunkerSettings `json:"chunker,omitempty"`
+
+	// Debts records the data backends missed while they were unavailable,
+	// until Heal copies it to them
+	Debts []BackendDebt `json:"backend_debts,omitempty"`
+
+	// Dedup is the scope of deduplication, DedupVolume or empty for the
+	// default DedupRepository
+	Dedup string `json:"dedup,omitempty"`
+
 	backend  BackendManager
 	password string // password for knoxite repository file
+
+	keys       []KeyRecord
+	currentKey string
+
+	privateKey string
+
+	// appendOnly is set for clients restricted by the append-only mode
+	appendOnly *appendOnlyState
+	// lock is set once the client locked the repository
+	lock *lockState
 }
 
 // Const declarations.
 const (
-	RepositoryVersion   = 4
+	RepositoryVersion   = 12
 	repositoryKeyLength = 32
 )
 
 // Error declarations.
 var (
 	ErrRepositoryIncompatible  = errors.New("The repository is not compatible with this version of Knoxite")
+	ErrRepositoryNewer         = errors.New("The repository has been written by a newer version of knoxite, please upgrade knoxite")
 	ErrOpenRepositoryFailed    = errors.New("Wrong password or corrupted repository")
 	ErrVolumeNotFound          = errors.New("Volume not found")
 	ErrSnapshotNotFound        = errors.New("Snapshot not found")
@@ -49,9 +99,12 @@ func NewRepository(path, password string) (Repository, error) {
 	}
 
 	repository := Repository{
-		Version:  RepositoryVersion,
-		password: password,
-		Key:      key,
+		Version:       RepositoryVersion,
+		ReaderVersion: RepositoryVersion,
+		Key:           key,
+	}
+	if err := repository.initKeys(password); err != nil {
+		return repository, err
 	}
 
 	backend, err := BackendFromURL(path)
@@ -77,7 +130,20 @@ func generateRandomKey(length int) (string, error) {
 }
 
 // OpenRepository opens an existing repository and migrates it if possible.
+// Repositories written by newer versions of knoxite can only be read.
 func OpenRepository(path, password string) (Repository, error) {
+	return openRepository(path, password, false)
+}
+
+// OpenRepositoryReadOnly opens an existing repository without ever modifying
+// it. Unlike OpenRepository it also opens repositories, which have been
+// written by newer versions of knoxite in a way this version may
+// misinterpret.
+func OpenRepositoryReadOnly(path, password string) (Repository, error) {
+	return openRepository(path, password, true)
+}
+
+func openRepository(path, password string, readOnly bool) (Repository, error) {
 	repository := Repository{
 		password: password,
 	}
@@ -91,14 +157,35 @@ func OpenRepository(path, password string) (Repository, error) {
 		return repository, err
 	}
 
-	pipe, err := NewDecodingPipeline(CompressionNone, EncryptionAES, password)
+	keys, b, err := splitKeyRecords(b)
 	if err != nil {
-		return repository, err
+		return repository, ErrOpenRepositoryFailed
 	}
-	err = pipe.Decode(b, &repository)
+	for _, k := range keys {
+		// repositories with key records are encrypted with the master key
+		if masterKey, err := k.unwrap(password); err == nil {
+			repository.password = masterKey
+			repository.keys = keys
+			repository.currentKey = k.ID
+			break
+		}
+	}
+	if len(keys) > 0 && repository.currentKey == "" {
+		return repository, ErrOpenRepositoryFailed
+	}
+
+	err = decodeMetadata(CompressionNone, repository.password, b, &repository)
 	if err != nil {
 		return repository, ErrOpenRepositoryFailed
 	}
+	if repository.ReaderVersion > RepositoryVersion && !readOnly {
+		return repository, ErrRepositoryNewer
+	}
+	if repository.Version > RepositoryVersion {
+		// saving the repository would drop all metadata this version of
+		// knoxite doesn't know about
+		readOnly = true
+	}
 	if repository.Version < RepositoryVersion {
 		// migrate to current version
 		err = repository.Migrate()
@@ -114,12 +201,30 @@ func OpenRepository(path, password string) (Repository, error) {
 		}
 		repository.backend.AddBackend(&backend)
 	}
+	repository.backend.setDebts(repository.Debts)
+	repository.backend.ReadOnly = readOnly
+	repository.restrictAppendOnly()
 
 	return repository, err
 }
 
+// ChunkerSettings returns the settings new snapshots get divided into chunks
+// with.
+func (r *Repository) ChunkerSettings() ChunkerSettings {
+	if r.Chunker == nil {
+		return DefaultChunkerSettings
+	}
+	return *r.Chunker
+}
+
+// ReadOnly returns true if the repository can't be modified.
+func (r *Repository) ReadOnly() bool {
+	return r.backend.ReadOnly
+}
+
 // AddVolume adds a volume to a repository.
 func (r *Repository) AddVolume(volume *Volume) error {
+	r.setDedupDomain(volume)
 	r.Volumes = append(r.Volumes, volume)
 	return nil
 }
@@ -152,35 +257,48 @@ func (r *Repository) FindVolume(id string) (*Volume, error) {
 
 // FindSnapshot finds a snapshot within a repository.
 func (r *Repository) FindSnapshot(id string) (*Volume, *Snapshot, error) {
+	if !r.CanRead() {
+		return &Volume{}, &Snapshot{}, ErrPrivateKeyRequired
+	}
 	if id == "latest" {
-		latestVolume := &Volume{}
-		latestSnapshot := &Snapshot{}
-		found := false
-		for _, volume := range r.Volumes {
-			for _, snapshotID := range volume.Snapshots {
-				snapshot, err := volume.LoadSnapshot(snapshotID, r)
-				if err == nil {
-					if !found || snapshot.Date.Sub(latestSnapshot.Date) > 0 {
-						latestSnapshot = snapshot
-						latestVolume = volume
-						found = true
-					}
-				}
-			}
-		}
+		return r.FindLatestSnapshot(SnapshotFilter{})
+	}
 
-		if found {
-			return latestVolume, latestSnapshot, nil
+	for _, volume := range r.Volumes {
+		snapshot, err := volume.LoadSnapshot(id, r)
+		if err == nil || err == ErrVolumeLocked {
+			return volume, snapshot, err
 		}
-	} else {
-		for _, volume := range r.Volumes {
-			snapshot, err := volume.LoadSnapshot(id, r)
-			if err == nil {
-				return volume, snapshot, err
+	}
+
+	return &Volume{}, &Snapshot{}, ErrSnapshotNotFound
+}
+
+// FindLatestSnapshot finds the most recent snapshot matching filter.
+func (r *Repository) FindLatestSnapshot(filter SnapshotFilter) (*Volume, *Snapshot, error) {
+	if !r.CanRead() {
+		return &Volume{}, &Snapshot{}, ErrPrivateKeyRequired
+	}
+	latestVolume := &Volume{}
+	latestSnapshot := &Snapshot{}
+	found := false
+	for _, volume := range r.Volumes {
+		for _, snapshotID := range volume.Snapshots {
+			snapshot, err := volume.LoadSnapshot(snapshotID, r)
+			if err != nil || !filter.Matches(snapshot) {
+				continue
+			}
+			if !found || snapshot.Date.Sub(latestSnapshot.Date) > 0 {
+				latestSnapshot = snapshot
+				latestVolume = volume
+				found = true
 			}
 		}
 	}
 
+	if found {
+		return latestVolume, latestSnapshot, nil
+	}
 	return &Volume{}, &Snapshot{}, ErrSnapshotNotFound
 }
 
@@ -213,22 +331,169 @@ func (r *Repository) init() error {
 // Save writes a repository's metadata.
 func (r *Repository) Save() error {
 	r.Paths = r.backend.Locations()
+	if r.ID == "" {
+		// repositories created by older versions of knoxite don't have an ID
+		u, err := uuid.NewV4()
+		if err != nil {
+			return err
+		}
+		r.ID = u.String()
+	}
 
-	pipe, err := NewEncodingPipeline(CompressionNone, EncryptionAES, r.password)
-	if err != nil {
+	if err := r.checkAppendOnly(); err != nil {
+		return err
+	}
+	if err := r.checkLock(); err != nil {
+		return err
+	}
+
+	r.Debts = r.backend.Debts()
+	if err := r.saveMetadata(); err != nil {
 		return err
 	}
-	b, err := pipe.Encode(r)
+	if debts := r.backend.Debts(); !reflect.DeepEqual(debts, r.Debts) {
+		// a backend missed the repository itself, which needs to be recorded
+		// on the other backends
+		r.Debts = debts
+		return r.saveMetadata()
+	}
+	return nil
+}
+
+func (r *Repository) saveMetadata() error {
+	b, err := r.encodeMetadata(CompressionNone, r.password, r)
 	if err != nil {
 		return err
 	}
+	if len(r.keys) > 0 {
+		if b, err = joinKeyRecords(r.keys, b); err != nil {
+			return err
+		}
+	}
 	return r.backend.SaveRepository(b)
 }
 
-// Changes password of repository.
+// Encryption returns the encryption algo used for the repository's metadata.
+// It's also the default for storing new data in the repository.
+func (r *Repository) Encryption() uint16 {
+	if r.Version < 5 {
+		return EncryptionAES
+	}
+	return EncryptionAESGCM
+}
+
+// encodeMetadata encodes and encrypts the metadata v with the repository's
+// encryption algo.
+func (r *Repository) encodeMetadata(compression uint16, password string, v interface{}) ([]byte, error) {
+	pipe, err := NewEncodingPipeline(compression, r.Encryption(), password)
+	if err != nil {
+		return nil, err
+	}
+	return pipe.Encode(v)
+}
+
+// decodeMetadata decrypts and decodes metadata into v. The metadata may have
+// been encrypted with either algo knoxite has been using for metadata.
+func decodeMetadata(compression uint16, password string, b []byte, v interface{}) error {
+	pipe, err := NewDecodingPipeline(compression, EncryptionAESGCM, password)
+	if err != nil {
+		return err
+	}
+	if err = pipe.Decode(b, v); err != ErrDecryptionFailed {
+		return err
+	}
+
+	// stored by a repository of version 4 or older
+	pipe, err = NewDecodingPipeline(compression, EncryptionAES, password)
+	if err != nil {
+		return err
+	}
+	return pipe.Decode(b, v)
+}
+
+// KeyFingerprint returns a fingerprint of the repository's encryption key. It
+// can be used to tell keys apart, without revealing the key itself.
+func (r *Repository) KeyFingerprint() string {
+	h := sha256.Sum256([]byte(r.Key))
+	s := hex.EncodeToString(h[:16])
+
+	var groups []string
+	for i := 0; i < len(s); i += 4 {
+		groups = append(groups, s[i:i+4])
+	}
+	return strings.Join(groups, ":")
+}
+
+// PasswordHint returns the repository's password hint, or an empty string if
+// there is none.
+func (r *Repository) PasswordHint() string {
+	b, err := r.backend.LoadPasswordHint()
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// SetPasswordHint stores a password hint for the repository. The hint does
+// not get encrypted, so it must not reveal the password. An empty hint
+// removes the existing one.
+func (r *Repository) SetPasswordHint(hint string) error {
+	return r.backend.SavePasswordHint([]byte(hint))
+}
+
+// LoadPasswordHint returns the password hint of the repository at path,
+// without having to open the repository.
+func LoadPasswordHint(path string) (string, error) {
+	backend, err := BackendFromURL(path)
+	if err != nil {
+		return "", err
+	}
+	b, err := backend.LoadPasswordHint()
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Changes password of repository. For repositories with key records, the
+// password of the key the repository has been opened with gets changed.
 func (r *Repository) ChangePassword(newPassword string) error {
-	r.password = newPassword
+	if len(r.keys) == 0 {
+		r.password = newPassword
+		return r.Save()
+	}
+
+	for i, k := range r.keys {
+		if k.ID != r.currentKey {
+			continue
+		}
+		nk, err := newKeyRecord(r.password, newPassword)
+		if err != nil {
+			return err
+		}
+		nk.Admin = k.Admin
+		if r.appendOnly != nil {
+			// replacing a key doesn't revoke access
+			delete(r.appendOnly.keys, k.ID)
+			r.appendOnly.keys[nk.ID] = nk.Admin
+		}
+		r.keys[i] = nk
+		r.currentKey = nk.ID
+	}
+	return r.Save()
+}
+
+// Upgrade raises the format of the repository to the current version, which
+// encrypts its metadata with authenticated encryption. Older versions of
+// knoxite can't read the repository anymore afterwards. The metadata of
+// existing snapshots gets upgraded by re-encrypting it.
+func (r *Repository) Upgrade() error {
+	if !r.IsAdmin() {
+		return ErrAppendOnly
+	}
 
+	r.Version = RepositoryVersion
+	r.ReaderVersion = RepositoryVersion
 	return r.Save()
 }
 
@@ -248,6 +513,34 @@ func (r *Repository) Migrate() error {
 
 			return r.Save()
 		}
+	case v == 4:
+		// still supported, but keeps using AES-CFB for its metadata and
+		// stores chunks without headers
+		return nil
+	case v == 5:
+		// still supported, but doesn't use key records until a key gets
+		// added
+		return nil
+	case v == 6:
+		// only repositories using asymmetric encryption need version 7
+		return nil
+	case v == 7:
+		// only append-only repositories need version 8
+		return nil
+	case v == 8:
+		// only repositories, which rotated their data key, need version 9
+		return nil
+	case v == 9:
+		// only repositories with protected volumes need version 10
+		return nil
+	case v == 10:
+		// only repositories deduplicating each volume on its own need
+		// version 11
+		return nil
+	case v == 11:
+		// keeps its single chunk-index until it gets upgraded, so older
+		// versions of knoxite can still read it
+		return nil
 	}
 	return ErrRepositoryIncompatible
 }
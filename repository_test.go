@@ -11,6 +11,7 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestRepositoryCreate(t *testing.T) {
@@ -74,6 +75,92 @@ func TestRepositoryIsEmpty(t *testing.T) {
 	}
 }
 
+func TestRepositoryFindLatestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, _ := NewRepository(dir, "this_is_a_password")
+	vol, _ := NewVolume("test", "")
+	_ = r.AddVolume(vol)
+
+	var ids []string
+	for i, tag := range []string{"db", "db", "web"} {
+		snapshot, _ := NewSnapshot("test_snapshot")
+		snapshot.Date = time.Date(2020, 6, 10+i, 0, 0, 0, 0, time.UTC)
+		snapshot.Tags = []string{tag}
+		_ = snapshot.Save(&r)
+		_ = vol.AddSnapshot(snapshot.ID)
+		ids = append(ids, snapshot.ID)
+	}
+
+	_, snapshot, err := r.FindSnapshot("latest")
+	if err != nil || snapshot.ID != ids[2] {
+		t.Errorf("Expected latest snapshot %s, got %s: %v", ids[2], snapshot.ID, err)
+	}
+	_, snapshot, err = r.FindLatestSnapshot(SnapshotFilter{Tags: []string{"db"}})
+	if err != nil || snapshot.ID != ids[1] {
+		t.Errorf("Expected latest db snapshot %s, got %s: %v", ids[1], snapshot.ID, err)
+	}
+	if _, _, err = r.FindLatestSnapshot(SnapshotFilter{Tags: []string{"mail"}}); err != ErrSnapshotNotFound {
+		t.Errorf("Expected %v, got %v", ErrSnapshotNotFound, err)
+	}
+}
+
+func TestRepositoryNewerVersion(t *testing.T) {
+	testPassword := "this_is_a_password"
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+
+	// newer versions which can still be read by this version
+	r.Version = RepositoryVersion + 1
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+	r, err = OpenRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed opening repository: %s", err)
+	}
+	if !r.ReadOnly() {
+		t.Errorf("Expected repository of a newer version to be read-only")
+	}
+	if err := r.Save(); err != ErrRepositoryReadOnly {
+		t.Errorf("Expected %v, got %v", ErrRepositoryReadOnly, err)
+	}
+	snapshot, _ := NewSnapshot("test")
+	if err := snapshot.Save(&r); err != ErrRepositoryReadOnly {
+		t.Errorf("Expected %v, got %v", ErrRepositoryReadOnly, err)
+	}
+
+	// newer versions which can't be read by this version
+	r.backend.ReadOnly = false
+	r.ReaderVersion = RepositoryVersion + 1
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = OpenRepository(dir, testPassword); err != ErrRepositoryNewer {
+		t.Errorf("Expected %v, got %v", ErrRepositoryNewer, err)
+	}
+	r, err = OpenRepositoryReadOnly(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed opening repository read-only: %s", err)
+	}
+	if !r.ReadOnly() {
+		t.Errorf("Expected repository to be read-only")
+	}
+}
+
 func TestRepositoryChangePassword(t *testing.T) {
 	testPassword := "this_is_a_password"
 	newPassword := "this_is_another_password"
@@ -115,3 +202,58 @@ func TestRepositoryChangePassword(t *testing.T) {
 	}
 
 }
+
+func TestRepositoryPasswordHint(t *testing.T) {
+	testPassword := "this_is_a_password"
+	testHint := "the usual one"
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Errorf("Failed creating temporary dir for repository: %s", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Errorf("Failed creating repository: %s", err)
+		return
+	}
+	if r.ID == "" {
+		t.Error("Repository should have an ID")
+	}
+	if hint := r.PasswordHint(); hint != "" {
+		t.Errorf("Expected no password hint, got %s", hint)
+	}
+
+	err = r.SetPasswordHint(testHint)
+	if err != nil {
+		t.Errorf("Failed setting password hint: %s", err)
+		return
+	}
+
+	// the hint must be readable without the password
+	hint, err := LoadPasswordHint(dir)
+	if err != nil {
+		t.Errorf("Failed loading password hint: %s", err)
+		return
+	}
+	if hint != testHint {
+		t.Errorf("Expected password hint %s, got %s", testHint, hint)
+	}
+
+	r2, err := OpenRepository(dir, testPassword)
+	if err != nil {
+		t.Errorf("Failed opening repository: %s", err)
+		return
+	}
+	if r2.ID != r.ID {
+		t.Errorf("Expected repository ID %s, got %s", r.ID, r2.ID)
+	}
+	if r2.KeyFingerprint() != r.KeyFingerprint() {
+		t.Errorf("Expected key fingerprint %s, got %s", r.KeyFingerprint(), r2.KeyFingerprint())
+	}
+	if r2.PasswordHint() != testHint {
+		t.Errorf("Expected password hint %s, got %s", testHint, r2.PasswordHint())
+	}
+}
@@ -0,0 +1,87 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+// RestorePlan describes what restoring a selection of items involves.
+type RestorePlan struct {
+	Files    uint64 `json:"files"`
+	Dirs     uint64 `json:"dirs"`
+	SymLinks uint64 `json:"symlinks"`
+	// Size is the size of the restored files
+	Size uint64 `json:"size"`
+	// Chunks is the amount of chunks getting loaded, Download their size
+	Chunks   uint64 `json:"chunks"`
+	Download uint64 `json:"download"`
+	// Backends maps the locations of the storage backends to the amount of
+	// data getting loaded from each of them
+	Backends map[string]uint64 `json:"backends"`
+}
+
+// downloadSize returns the amount of data loaded to restore a chunk: all of
+// its data parts, but none of its parity parts.
+func (chunk Chunk) downloadSize() uint64 {
+	if chunk.DataParts <= 1 {
+		return uint64(chunk.Size)
+	}
+	partSize := (uint64(chunk.Size) + uint64(chunk.DataParts) - 1) / uint64(chunk.DataParts)
+	return partSize * uint64(chunk.DataParts)
+}
+
+// chunkSource returns the backend a chunk gets loaded from, unless all of
+// them are unavailable: the first one, which didn't miss storing it.
+func (backend *BackendManager) chunkSource(hash string) *Backend {
+	for _, be := range backend.readOrder(func(d *debt) bool { return d.chunks[hash] }) {
+		if !backend.debts.isDown((*be).Location()) {
+			return be
+		}
+	}
+	return nil
+}
+
+// PlanRestore returns how many items restoring the given archives creates and
+// how much data gets loaded from which storage backend, without loading any
+// of it. Chunks get loaded for every file they're part of.
+func PlanRestore(repository *Repository, archives []*Archive) RestorePlan {
+	plan := RestorePlan{
+		Backends: make(map[string]uint64),
+	}
+
+	restored := make(map[string]bool)
+	for _, arc := range archives {
+		restored[arc.Path] = arc.LinkTo == ""
+	}
+	for _, arc := range archives {
+		switch arc.Type {
+		case Directory:
+			plan.Dirs++
+			continue
+		case SymLink:
+			plan.SymLinks++
+			continue
+		}
+		plan.Files++
+		plan.Size += arc.Size
+		if arc.LinkTo != "" && restored[arc.LinkTo] {
+			// hard links don't need any data
+			continue
+		}
+
+		for _, chunk := range arc.Chunks {
+			if chunk.Hole {
+				continue
+			}
+			size := chunk.downloadSize()
+			plan.Chunks++
+			plan.Download += size
+			if be := repository.backend.chunkSource(chunk.Hash); be != nil {
+				plan.Backends[(*be).Location()] += size
+			}
+		}
+	}
+	return plan
+}
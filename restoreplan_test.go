@@ -0,0 +1,61 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestPlanRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, "this_is_a_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+
+	archives := []*Archive{
+		{Path: "dir", Type: Directory},
+		{Path: "dir/link", Type: SymLink, PointsTo: "file"},
+		{Path: "dir/file", Type: File, Size: 300, Chunks: []Chunk{
+			{Hash: "a", DataParts: 1, Size: 100, OriginalSize: 100},
+			{Hash: "b", DataParts: 1, Size: 50, OriginalSize: 100},
+			{OriginalSize: 100, Hole: true},
+		}},
+		{Path: "dir/hardlink", Type: File, Size: 300, LinkTo: "dir/file", Chunks: []Chunk{
+			{Hash: "a", DataParts: 1, Size: 100, OriginalSize: 100},
+		}},
+		{Path: "dir/parity", Type: File, Size: 100, Chunks: []Chunk{
+			{Hash: "c", DataParts: 2, ParityParts: 1, Size: 101, OriginalSize: 100},
+		}},
+	}
+
+	plan := PlanRestore(&r, archives)
+	if plan.Dirs != 1 || plan.SymLinks != 1 || plan.Files != 3 {
+		t.Errorf("Expected 1 dir, 1 symlink and 3 files, got %d, %d and %d", plan.Dirs, plan.SymLinks, plan.Files)
+	}
+	if plan.Size != 700 {
+		t.Errorf("Expected size of 700, got %d", plan.Size)
+	}
+	if plan.Chunks != 3 {
+		t.Errorf("Expected 3 chunks to be loaded, got %d", plan.Chunks)
+	}
+	if plan.Download != 252 {
+		t.Errorf("Expected download of 252, got %d", plan.Download)
+	}
+	location := (*r.BackendManager().Backends[0]).Location()
+	if len(plan.Backends) != 1 || plan.Backends[location] != 252 {
+		t.Errorf("Expected everything to be loaded from %s, got %v", location, plan.Backends)
+	}
+}
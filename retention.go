@@ -0,0 +1,245 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy describes which snapshots to keep. Each field is the amount
+// of most recent snapshots, hours, days, weeks, months or years for which the
+// latest snapshot gets kept.
+type RetentionPolicy struct {
+	Last    int `json:"last,omitempty"`
+	Hourly  int `json:"hourly,omitempty"`
+	Daily   int `json:"daily,omitempty"`
+	Weekly  int `json:"weekly,omitempty"`
+	Monthly int `json:"monthly,omitempty"`
+	Yearly  int `json:"yearly,omitempty"`
+}
+
+// PathRetentionPolicy applies a retention policy to all items of snapshots
+// stored at or below Path.
+type PathRetentionPolicy struct {
+	Path   string          `json:"path"`
+	Policy RetentionPolicy `json:"policy"`
+}
+
+// RetentionPlan describes the outcome of applying retention policies.
+type RetentionPlan struct {
+	Keep   []*Snapshot `json:"keep"`
+	Remove []*Snapshot `json:"remove"`
+	// Prune maps the IDs of kept snapshots to the paths of their items, which
+	// aren't kept by the policy of their path
+	Prune map[string][]string `json:"prune"`
+}
+
+// ParseRetentionPolicy parses a policy like "last=3,daily=7,weekly=4".
+func ParseRetentionPolicy(s string) (RetentionPolicy, error) {
+	policy := RetentionPolicy{}
+	for _, rule := range strings.Split(s, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		kv := strings.SplitN(rule, "=", 2)
+		if len(kv) != 2 {
+			return policy, fmt.Errorf("invalid retention rule %s, expected [unit]=[amount]", rule)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil || n < 0 {
+			return policy, fmt.Errorf("invalid amount in retention rule %s", rule)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "last":
+			policy.Last = n
+		case "hourly":
+			policy.Hourly = n
+		case "daily":
+			policy.Daily = n
+		case "weekly":
+			policy.Weekly = n
+		case "monthly":
+			policy.Monthly = n
+		case "yearly":
+			policy.Yearly = n
+		default:
+			return policy, fmt.Errorf("unknown unit in retention rule %s", rule)
+		}
+	}
+
+	return policy, nil
+}
+
+// String returns the policy in the format understood by ParseRetentionPolicy.
+func (p RetentionPolicy) String() string {
+	var rules []string
+	for _, r := range []struct {
+		unit string
+		n    int
+	}{
+		{"last", p.Last}, {"hourly", p.Hourly}, {"daily", p.Daily},
+		{"weekly", p.Weekly}, {"monthly", p.Monthly}, {"yearly", p.Yearly},
+	} {
+		if r.n > 0 {
+			rules = append(rules, fmt.Sprintf("%s=%d", r.unit, r.n))
+		}
+	}
+	return strings.Join(rules, ",")
+}
+
+// Empty returns true if the policy doesn't keep any snapshots. Empty policies
+// don't remove anything either.
+func (p RetentionPolicy) Empty() bool {
+	return p == RetentionPolicy{}
+}
+
+// keep returns the IDs of the snapshots kept by the policy.
+func (p RetentionPolicy) keep(snapshots []*Snapshot) map[string]bool {
+	kept := make(map[string]bool)
+	if p.Empty() {
+		for _, s := range snapshots {
+			kept[s.ID] = true
+		}
+		return kept
+	}
+
+	sorted := make([]*Snapshot, len(snapshots))
+	copy(sorted, snapshots)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Date.After(sorted[j].Date)
+	})
+
+	buckets := []struct {
+		n   int
+		key func(t time.Time) string
+	}{
+		{p.Last, nil},
+		{p.Hourly, func(t time.Time) string { return t.Format("2006-01-02 15") }},
+		{p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{p.Weekly, func(t time.Time) string {
+			y, w := t.ISOWeek()
+			return fmt.Sprintf("%d-%d", y, w)
+		}},
+		{p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{p.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+	for _, b := range buckets {
+		left := b.n
+		last := ""
+		for i, s := range sorted {
+			if left == 0 {
+				break
+			}
+
+			key := strconv.Itoa(i)
+			if b.key != nil {
+				key = b.key(s.Date.Local())
+			}
+			if key != last {
+				kept[s.ID] = true
+				last = key
+				left--
+			}
+		}
+	}
+
+	return kept
+}
+
+// retentionGroup returns the index of the path policy applying to an item,
+// or -1 if none of them does. The most specific path wins.
+func retentionGroup(path string, paths []PathRetentionPolicy) int {
+	path = strings.TrimPrefix(filepath.Clean(path), string(os.PathSeparator))
+
+	group := -1
+	longest := -1
+	for i, p := range paths {
+		prefix := strings.TrimPrefix(filepath.Clean(p.Path), string(os.PathSeparator))
+		if prefix != path && prefix != "" && !strings.HasPrefix(path, prefix+string(os.PathSeparator)) {
+			continue
+		}
+		if len(prefix) > longest {
+			group = i
+			longest = len(prefix)
+		}
+	}
+	return group
+}
+
+// PlanRetention decides which snapshots to keep. Items of snapshots stored at
+// or below the path of one of paths are grouped by it, and only kept in the
+// snapshots kept by the group's policy. All other items are subject to the
+// default policy. Snapshots in which no group keeps any items get removed.
+func PlanRetention(snapshots []*Snapshot, policy RetentionPolicy, paths []PathRetentionPolicy) *RetentionPlan {
+	plan := &RetentionPlan{
+		Prune: make(map[string][]string),
+	}
+
+	// groups[i] holds the snapshots with items in path policy i, the last
+	// one those with items subject to the default policy
+	groups := make([][]*Snapshot, len(paths)+1)
+	items := make(map[string]map[int][]string)
+	for _, s := range snapshots {
+		items[s.ID] = make(map[int][]string)
+		for path := range s.Archives {
+			g := retentionGroup(path, paths)
+			if g < 0 {
+				g = len(paths)
+			}
+			if len(items[s.ID][g]) == 0 {
+				groups[g] = append(groups[g], s)
+			}
+			items[s.ID][g] = append(items[s.ID][g], path)
+		}
+		if len(s.Archives) == 0 {
+			groups[len(paths)] = append(groups[len(paths)], s)
+		}
+	}
+
+	kept := make([]map[string]bool, len(groups))
+	for g, ss := range groups {
+		p := policy
+		if g < len(paths) {
+			p = paths[g].Policy
+		}
+		kept[g] = p.keep(ss)
+	}
+
+	for _, s := range snapshots {
+		var prune []string
+		keep := len(s.Archives) == 0 && kept[len(paths)][s.ID]
+		for g, group := range items[s.ID] {
+			if kept[g][s.ID] {
+				keep = true
+			} else {
+				prune = append(prune, group...)
+			}
+		}
+
+		if !keep {
+			plan.Remove = append(plan.Remove, s)
+			continue
+		}
+		plan.Keep = append(plan.Keep, s)
+		if len(prune) > 0 {
+			sort.Strings(prune)
+			plan.Prune[s.ID] = prune
+		}
+	}
+
+	return plan
+}
@@ -0,0 +1,116 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRetentionPolicy(t *testing.T) {
+	p, err := ParseRetentionPolicy("last=3, Daily=7,weekly=4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := (RetentionPolicy{Last: 3, Daily: 7, Weekly: 4}); p != exp {
+		t.Errorf("Expected %+v, got %+v", exp, p)
+	}
+	if p.String() != "last=3,daily=7,weekly=4" {
+		t.Errorf("Unexpected policy string %s", p.String())
+	}
+
+	for _, s := range []string{"daily", "daily=x", "daily=-1", "fortnightly=2"} {
+		if _, err := ParseRetentionPolicy(s); err == nil {
+			t.Errorf("Expected an error parsing %s", s)
+		}
+	}
+}
+
+func TestPlanRetention(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.Local)
+
+	// one snapshot per day, each with items in /etc and /var/log
+	var snapshots []*Snapshot
+	for i := 0; i < 10; i++ {
+		s := &Snapshot{
+			ID:   string(rune('a' + i)),
+			Date: start.AddDate(0, 0, i),
+			Archives: map[string]*Archive{
+				"/etc/passwd":       {Path: "/etc/passwd", Type: File, Size: 1},
+				"/var/log/syslog":   {Path: "/var/log/syslog", Type: File, Size: 2},
+				"/var/log/kern.log": {Path: "/var/log/kern.log", Type: File, Size: 3},
+			},
+			Stats: Stats{Files: 3, Size: 6},
+		}
+		snapshots = append(snapshots, s)
+	}
+
+	plan := PlanRetention(snapshots, RetentionPolicy{Last: 2}, []PathRetentionPolicy{
+		{Path: "/etc", Policy: RetentionPolicy{Daily: 5}},
+	})
+
+	var kept, removed []string
+	for _, s := range plan.Keep {
+		kept = append(kept, s.ID)
+	}
+	for _, s := range plan.Remove {
+		removed = append(removed, s.ID)
+	}
+	if exp := []string{"f", "g", "h", "i", "j"}; !reflect.DeepEqual(kept, exp) {
+		t.Errorf("Expected to keep %v, got %v", exp, kept)
+	}
+	if exp := []string{"a", "b", "c", "d", "e"}; !reflect.DeepEqual(removed, exp) {
+		t.Errorf("Expected to remove %v, got %v", exp, removed)
+	}
+
+	// only the two most recent snapshots keep their logs
+	exp := map[string][]string{}
+	for _, id := range []string{"f", "g", "h"} {
+		exp[id] = []string{"/var/log/kern.log", "/var/log/syslog"}
+	}
+	if !reflect.DeepEqual(plan.Prune, exp) {
+		t.Errorf("Expected to prune %v, got %v", exp, plan.Prune)
+	}
+
+	s := snapshots[5]
+	for _, path := range plan.Prune[s.ID] {
+		s.RemoveArchive(path)
+	}
+	if len(s.Archives) != 1 || s.Stats.Files != 1 || s.Stats.Size != 1 {
+		t.Errorf("Unexpected snapshot after pruning: %d items, %+v", len(s.Archives), s.Stats)
+	}
+}
+
+func TestPlanRetentionBuckets(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 30, 0, 0, time.Local)
+
+	// four snapshots per day over 60 days
+	var snapshots []*Snapshot
+	for i := 0; i < 240; i++ {
+		snapshots = append(snapshots, &Snapshot{
+			ID:       start.Add(time.Duration(i) * 6 * time.Hour).Format("2006-01-02 15"),
+			Date:     start.Add(time.Duration(i) * 6 * time.Hour),
+			Archives: map[string]*Archive{"file": {Path: "file"}},
+		})
+	}
+
+	plan := PlanRetention(snapshots, RetentionPolicy{Daily: 3, Monthly: 2}, nil)
+	var kept []string
+	for _, s := range plan.Keep {
+		kept = append(kept, s.ID)
+	}
+	// the last snapshot of the last three days and of the last two months
+	exp := []string{"2020-01-31 18", "2020-02-27 18", "2020-02-28 18", "2020-02-29 18"}
+	if !reflect.DeepEqual(kept, exp) {
+		t.Errorf("Expected to keep %v, got %v", exp, kept)
+	}
+	if len(plan.Remove) != len(snapshots)-len(exp) {
+		t.Errorf("Expected to remove %d snapshots, got %d", len(snapshots)-len(exp), len(plan.Remove))
+	}
+}
@@ -0,0 +1,160 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"os"
+	"time"
+)
+
+// RetryPolicy defines how failed backend operations get retried.
+type RetryPolicy struct {
+	// Retries is the amount of retries after the first attempt failed
+	Retries int
+	// InitialDelay is the delay before the first retry. It doubles with every
+	// further retry, up to MaxDelay
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+}
+
+// DefaultRetryPolicy is used by a BackendManager without a RetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{
+	Retries:      3,
+	InitialDelay: 500 * time.Millisecond,
+	MaxDelay:     30 * time.Second,
+}
+
+// PermanentError wraps an error, which won't go away by retrying the
+// operation that caused it.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent marks err as an error that must not be retried.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// NotFound marks err as an error caused by data, which doesn't exist. Callers
+// can check for it with errors.Is(err, os.ErrNotExist) and it won't be
+// retried.
+func NotFound(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &notFoundError{err: err}
+}
+
+type notFoundError struct {
+	err error
+}
+
+func (e *notFoundError) Error() string {
+	return e.err.Error()
+}
+
+func (e *notFoundError) Unwrap() error {
+	return e.err
+}
+
+func (e *notFoundError) Is(target error) bool {
+	return target == os.ErrNotExist
+}
+
+// RetryableStatusCode returns true if a request that failed with the given
+// HTTP status code may succeed when it gets retried.
+func RetryableStatusCode(code int) bool {
+	return code >= 500 || code == 408 || code == 429
+}
+
+// StatusError returns err for a request that failed with the given HTTP
+// status code, marked as permanent unless the request may be retried. Requests
+// for data, which doesn't exist, fail with an error matching os.ErrNotExist.
+func StatusError(code int, err error) error {
+	if err == nil || RetryableStatusCode(code) {
+		return err
+	}
+	if code == http.StatusNotFound {
+		return NotFound(err)
+	}
+	return Permanent(err)
+}
+
+// IsRetryable returns true if err is a transient error and the operation that
+// caused it may succeed when it gets retried.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var perm *PermanentError
+	if errors.As(err, &perm) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+		return false
+	}
+
+	// network errors, timeouts and anything we can't tell apart from them get
+	// another try
+	return true
+}
+
+// retry calls op until it succeeds, fails with an error that can't be
+// retried, or the policy's retries are exhausted. The delay between retries
+// grows exponentially and gets randomized, so clients don't retry in lockstep.
+func (p RetryPolicy) retry(ctx context.Context, op func() error) error {
+	delay := p.InitialDelay
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil || attempt >= p.Retries || !IsRetryable(err) {
+			return err
+		}
+
+		// wait somewhere between half and the full delay
+		d := delay
+		if d > 1 {
+			d = d/2 + time.Duration(rand.Int63n(int64(d/2)))
+		}
+		log.Debugf("Retrying in %s (attempt %d/%d): %v", d, attempt+1, p.Retries, err)
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+
+		delay *= 2
+		if delay > p.MaxDelay {
+			delay = p.MaxDelay
+		}
+	}
+}
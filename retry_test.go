@@ -0,0 +1,192 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestIsRetryable(t *testing.T) {
+	errTransient := errors.New("connection reset")
+
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{nil, false},
+		{errTransient, true},
+		{fmt.Errorf("wrapped: %w", errTransient), true},
+		{Permanent(errTransient), false},
+		{fmt.Errorf("wrapped: %w", Permanent(errTransient)), false},
+		{context.Canceled, false},
+		{&os.PathError{Op: "open", Path: "foo", Err: os.ErrNotExist}, false},
+		{StatusError(503, errTransient), true},
+		{StatusError(429, errTransient), true},
+		{StatusError(404, errTransient), false},
+		{NotFound(errTransient), false},
+		{StatusError(403, errTransient), false},
+	}
+
+	for _, tt := range tests {
+		if r := IsRetryable(tt.err); r != tt.retryable {
+			t.Errorf("Expected IsRetryable(%v) to be %v, got %v", tt.err, tt.retryable, r)
+		}
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	errRequest := errors.New("request failed")
+
+	if err := StatusError(404, errRequest); !errors.Is(err, os.ErrNotExist) || !errors.Is(err, errRequest) {
+		t.Errorf("Expected %v to match os.ErrNotExist and the request's error", err)
+	}
+	if err := StatusError(403, errRequest); errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected %v not to match os.ErrNotExist", err)
+	}
+	if err := StatusError(503, errRequest); err != errRequest {
+		t.Errorf("Expected %v, got %v", errRequest, err)
+	}
+	if err := StatusError(404, nil); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	policy := RetryPolicy{
+		Retries:      3,
+		InitialDelay: time.Millisecond,
+		MaxDelay:     2 * time.Millisecond,
+	}
+	errTransient := errors.New("connection reset")
+
+	// succeeds on the third attempt
+	attempts := 0
+	err := policy.retry(context.Background(), func() error {
+		attempts++
+		if attempts < 3 {
+			return errTransient
+		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Errorf("Expected success after 3 attempts, got %v after %d", err, attempts)
+	}
+
+	// gives up once all retries are used up
+	attempts = 0
+	err = policy.retry(context.Background(), func() error {
+		attempts++
+		return errTransient
+	})
+	if err != errTransient || attempts != policy.Retries+1 {
+		t.Errorf("Expected %v after %d attempts, got %v after %d", errTransient, policy.Retries+1, err, attempts)
+	}
+
+	// permanent errors don't get retried
+	attempts = 0
+	err = policy.retry(context.Background(), func() error {
+		attempts++
+		return Permanent(errTransient)
+	})
+	if !errors.Is(err, errTransient) || attempts != 1 {
+		t.Errorf("Expected %v after 1 attempt, got %v after %d", errTransient, err, attempts)
+	}
+
+	// stops waiting for the next attempt once the context is done
+	policy.InitialDelay = time.Hour
+	policy.MaxDelay = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts = 0
+	err = policy.retry(ctx, func() error {
+		attempts++
+		cancel()
+		return errTransient
+	})
+	if err != context.Canceled || attempts != 1 {
+		t.Errorf("Expected %v after 1 attempt, got %v after %d", context.Canceled, err, attempts)
+	}
+}
+
+// flakyRangeBackend serves the chunk-index in ranges, but every download gets
+// interrupted after cut bytes.
+type flakyRangeBackend struct {
+	Backend
+	data     []byte
+	cut      int
+	requests int
+}
+
+func (b *flakyRangeBackend) LoadChunkIndexRange(ctx context.Context, offset int64) (io.ReadCloser, int64, error) {
+	b.requests++
+	end := int(offset) + b.cut
+	if end > len(b.data) {
+		end = len(b.data)
+	}
+	r := io.MultiReader(bytes.NewReader(b.data[offset:end]), &failingReader{})
+	if end == len(b.data) {
+		r = bytes.NewReader(b.data[offset:])
+	}
+	return ioutil.NopCloser(r), int64(len(b.data)), nil
+}
+
+func (b *flakyRangeBackend) LoadSnapshotRange(ctx context.Context, id string, offset int64) (io.ReadCloser, int64, error) {
+	return nil, 0, os.ErrNotExist
+}
+
+type failingReader struct{}
+
+func (*failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestResumeDownload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	local, err := BackendFromURL(dir)
+	if err != nil {
+		t.Fatalf("Failed creating backend: %s", err)
+	}
+
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	var be Backend = &flakyRangeBackend{Backend: local, data: data, cut: 1000}
+	bm := BackendManager{Retry: &RetryPolicy{
+		Retries:      1,
+		InitialDelay: time.Millisecond,
+		MaxDelay:     time.Millisecond,
+	}}
+	bm.AddBackend(&be)
+
+	// every download makes progress, so it never runs out of retries
+	b, err := bm.LoadChunkIndex()
+	if err != nil {
+		t.Fatalf("Failed loading chunk-index: %s", err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Errorf("Expected %d bytes of chunk-index, got %d", len(data), len(b))
+	}
+	if requests := be.(*flakyRangeBackend).requests; requests != 10 {
+		t.Errorf("Expected 10 requests, got %d", requests)
+	}
+
+	// downloads without any progress use up the retries
+	be.(*flakyRangeBackend).cut = 0
+	if _, err := bm.LoadChunkIndex(); err != ErrLoadChunkIndexFailed {
+		t.Errorf("Expected %v, got %v", ErrLoadChunkIndexFailed, err)
+	}
+}
@@ -0,0 +1,345 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// keyRotationVersion is the repository version introducing retired data
+// keys, which older versions of knoxite wouldn't decrypt data with.
+const keyRotationVersion = 9
+
+// Error declarations.
+var (
+	ErrRotateAsymmetric  = errors.New("Repositories using asymmetric encryption can't rotate their data key")
+	ErrDataKeyNotFound   = errors.New("The key the data has been encrypted with is not part of the repository")
+	ErrVerifyChunkFailed = errors.New("Re-encrypted chunk differs from the stored chunk")
+)
+
+// keyID identifies a data key, without revealing the key itself.
+func keyID(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:8])
+}
+
+// dataKey returns the data key identified by id. The keys of unlocked,
+// protected volumes are data keys, too.
+func (r *Repository) dataKey(id string) (string, error) {
+	if id == "" {
+		// stored by an older version of knoxite, before the key got rotated
+		// for the first time
+		if len(r.RetiredKeys) > 0 {
+			return r.RetiredKeys[0], nil
+		}
+		return r.Key, nil
+	}
+
+	keys := append([]string{r.Key}, r.RetiredKeys...)
+	for _, key := range append(keys, r.unlockedVolumeKeys()...) {
+		if keyID(key) == id {
+			return key, nil
+		}
+	}
+	return "", ErrDataKeyNotFound
+}
+
+// decodeWithDataKeys decrypts and decodes metadata encrypted with the data
+// key. Metadata, which hasn't been re-encrypted since the key got rotated,
+// gets decrypted with the retired keys, the metadata of protected volumes
+// with the keys of unlocked volumes. It returns the key, which decrypted the
+// metadata.
+func (r *Repository) decodeWithDataKeys(b []byte, v interface{}) (string, error) {
+	err := decodeMetadata(CompressionLZMA, r.Key, b, v)
+	if err == nil {
+		return r.Key, nil
+	}
+	for i := len(r.RetiredKeys) - 1; i >= 0; i-- {
+		if decodeMetadata(CompressionLZMA, r.RetiredKeys[i], b, v) == nil {
+			return r.RetiredKeys[i], nil
+		}
+	}
+	for _, key := range r.unlockedVolumeKeys() {
+		if decodeMetadata(CompressionLZMA, key, b, v) == nil {
+			return key, nil
+		}
+	}
+	return "", err
+}
+
+// RotateKey replaces the key data gets encrypted with by a new random key.
+// Data stored from now on gets encrypted with the new key. The old key is
+// kept to decrypt the existing data, until a Reencryption re-encrypted all of
+// it and ForgetRetiredKeys removes the old key from the repository.
+func (r *Repository) RotateKey() error {
+	if r.Asymmetric() {
+		return ErrRotateAsymmetric
+	}
+	if !r.IsAdmin() {
+		return ErrAppendOnly
+	}
+
+	key, err := generateRandomKey(repositoryKeyLength)
+	if err != nil {
+		return ErrGenerateRandomKeyFailed
+	}
+	r.RetiredKeys = append(r.RetiredKeys, r.Key)
+	r.Key = key
+	if r.Version < keyRotationVersion {
+		r.Version = keyRotationVersion
+	}
+	if r.ReaderVersion < keyRotationVersion {
+		r.ReaderVersion = keyRotationVersion
+	}
+
+	return r.Save()
+}
+
+// ForgetRetiredKeys removes the keys replaced by RotateKey from the
+// repository. Only call it once all snapshots have been re-encrypted and the
+// chunks encrypted with the retired keys have been removed by packing the
+// chunk-index, as this data can't be decrypted anymore afterwards.
+func (r *Repository) ForgetRetiredKeys() error {
+	if !r.IsAdmin() {
+		return ErrAppendOnly
+	}
+
+	r.RetiredKeys = nil
+	return r.Save()
+}
+
+// A Reencryption re-encrypts the chunks and metadata of snapshots with the
+// current data key of a repository, after the key got rotated, or with
+// another encryption algo. It can also recompress the chunks with another
+// compression algo. Chunks shared by several snapshots only get re-encrypted
+// once.
+type Reencryption struct {
+	repository *Repository
+	index      *ChunkIndex
+
+	// Encrypt is the encryption algo chunks get re-encrypted with. Its zero
+	// value keeps the algo of each archive. Archives which aren't encrypted
+	// stay unencrypted
+	Encrypt uint16
+	// Compress is the compression algo chunks get recompressed with. Its
+	// zero value keeps the algo of each archive. Archives which aren't
+	// compressed stay uncompressed
+	Compress uint16
+	// Metadata re-encrypts the metadata of all snapshots, even if none of
+	// their chunks need to be re-encrypted, e.g. after the repository has
+	// been upgraded to a format using another algo for its metadata
+	Metadata bool
+
+	// chunks maps the key, content and format of chunks to the chunks
+	// encrypted with the current key and algos
+	chunks map[string]Chunk
+}
+
+// ReencryptStats describes the data re-encrypted by a Reencryption.
+type ReencryptStats struct {
+	Chunks      uint64
+	StorageSize uint64
+}
+
+// NewReencryption returns a Reencryption, which updates index as it goes.
+func NewReencryption(repository *Repository, index *ChunkIndex) *Reencryption {
+	return &Reencryption{
+		repository: repository,
+		index:      index,
+		chunks:     make(map[string]Chunk),
+	}
+}
+
+// reencryptionKey identifies chunks with equal content and format of a
+// deduplication domain, encrypted with the key identified by id.
+func reencryptionKey(id, domain string, arc *Archive, chunk Chunk) string {
+	return fmt.Sprintf("%s.%s.%s.%d.%d.%d.%d", id, domain, chunk.DecryptedHash, arc.Compressed, arc.Encrypted, chunk.DataParts, chunk.ParityParts)
+}
+
+// encryption returns the algo the chunks of arc get re-encrypted with.
+func (re *Reencryption) encryption(arc *Archive) uint16 {
+	if re.Encrypt == EncryptionNone || arc.Encrypted == EncryptionNone {
+		return arc.Encrypted
+	}
+	return re.Encrypt
+}
+
+// compression returns the algo the chunks of arc get recompressed with.
+func (re *Reencryption) compression(arc *Archive) uint16 {
+	if re.Compress == CompressionNone || arc.Compressed == CompressionNone {
+		return arc.Compressed
+	}
+	return re.Compress
+}
+
+// currentKeyID identifies the key the data of snapshot gets encrypted with by
+// method. Only symmetric encryption uses data keys.
+func (re *Reencryption) currentKeyID(snapshot *Snapshot, method uint16) string {
+	if method != EncryptionAES && method != EncryptionAESGCM {
+		return ""
+	}
+	return keyID(snapshot.encryptionKey(re.repository, method))
+}
+
+// Pending returns true if snapshot still refers to data encrypted with a
+// retired key or another algo than Encrypt, or compressed with another algo
+// than Compress. The chunks of snapshots, which
+// have been re-encrypted already, get reused for the snapshots re-encrypted
+// later on, so call Pending for all snapshots first to resume an interrupted
+// re-encryption. Snapshots of protected volumes are encrypted with the
+// volume's key, which never gets retired.
+func (re *Reencryption) Pending(snapshot *Snapshot) bool {
+	pending := re.Metadata
+	if !re.repository.Asymmetric() && snapshot.key != snapshot.encryptionKey(re.repository, EncryptionAESGCM) {
+		pending = true
+	}
+
+	for _, arc := range snapshot.Archives {
+		current := re.currentKeyID(snapshot, arc.Encrypted)
+		stale := arc.Encrypted != re.encryption(arc) || arc.Compressed != re.compression(arc)
+		for _, chunk := range arc.Chunks {
+			if chunk.Hole {
+				continue
+			}
+			if stale || chunk.KeyID != current {
+				pending = true
+				continue
+			}
+			re.chunks[reencryptionKey(current, snapshot.DedupDomain, arc, chunk)] = chunk
+		}
+	}
+	return pending
+}
+
+// Snapshot re-encrypts all chunks of snapshot, which have been encrypted with
+// a retired key or another algo than Encrypt or compressed with another algo
+// than Compress, and verifies each of them after storing it. The snapshot's
+// metadata gets re-encrypted and the chunk-index updated once all chunks have
+// been re-encrypted. Save the chunk-index after each snapshot, so an
+// interrupted re-encryption can be resumed by skipping the snapshots, which
+// aren't Pending anymore. The chunks no longer referenced get removed by
+// packing the repository.
+func (re *Reencryption) Snapshot(ctx context.Context, snapshot *Snapshot) (ReencryptStats, error) {
+	var stats ReencryptStats
+
+	paths := make([]string, 0, len(snapshot.Archives))
+	for path := range snapshot.Archives {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		arc := snapshot.Archives[path]
+		a := *arc
+		a.Encrypted = re.encryption(arc)
+		a.Compressed = re.compression(arc)
+		a.Chunks = make([]Chunk, len(arc.Chunks))
+		current := re.currentKeyID(snapshot, a.Encrypted)
+		for i, chunk := range arc.Chunks {
+			if ctx.Err() != nil {
+				return stats, ctx.Err()
+			}
+			if !chunk.Hole && (chunk.KeyID != current || a.Encrypted != arc.Encrypted || a.Compressed != arc.Compressed) {
+				c, err := re.chunk(ctx, snapshot, arc, &a, chunk, &stats)
+				if err != nil {
+					return stats, fmt.Errorf("%s: %w", path, err)
+				}
+				chunk = c
+			}
+			a.Chunks[i] = chunk
+		}
+		snapshot.Archives[path] = &a
+	}
+
+	// the snapshot gets replaced, hence the backends reject overwriting it
+	// in append-only repositories
+	if err := snapshot.Save(re.repository); err != nil {
+		return stats, err
+	}
+	re.index.RemoveSnapshot(snapshot.ID)
+	for _, arc := range snapshot.Archives {
+		re.index.AddArchive(arc, snapshot.ID)
+	}
+	return stats, nil
+}
+
+// chunk re-encrypts chunk of arc with the current key and the algos of the
+// re-encrypted archive target, unless an equal chunk has been re-encrypted
+// already, and returns the new chunk.
+func (re *Reencryption) chunk(ctx context.Context, snapshot *Snapshot, arc, target *Archive, chunk Chunk, stats *ReencryptStats) (Chunk, error) {
+	k := reencryptionKey(re.currentKeyID(snapshot, target.Encrypted), snapshot.DedupDomain, target, chunk)
+	if c, ok := re.chunks[k]; ok {
+		c.Num = chunk.Num
+		return c, nil
+	}
+
+	repository := re.repository
+	b, err := loadChunk(ctx, *repository, *arc, chunk, nil)
+	if err != nil {
+		return chunk, err
+	}
+
+	opts := StoreOptions{
+		Compress:     target.Compressed,
+		Encrypt:      target.Encrypted,
+		DataParts:    chunk.DataParts,
+		ParityParts:  chunk.ParityParts,
+		dedupDomain:  snapshot.DedupDomain,
+		chunkHeaders: repository.chunkHeaders(),
+	}
+	key := snapshot.encryptionKey(repository, opts.Encrypt)
+	pipe, err := NewEncodingPipeline(opts.Compress, opts.Encrypt, key)
+	if err != nil {
+		return chunk, err
+	}
+	c, err := encodeChunk(pipe, key, opts, inputChunk{Data: b, Num: chunk.Num})
+	if err != nil {
+		return chunk, err
+	}
+
+	start := time.Now()
+	n, err := repository.backend.StoreChunk(ctx, c)
+	if err != nil {
+		return chunk, err
+	}
+	if err := re.verify(ctx, target, c); err != nil {
+		return chunk, err
+	}
+	log.Debugf("Re-encrypted chunk %s as %s in %s", chunk.Hash, c.Hash, time.Since(start))
+
+	stats.Chunks++
+	stats.StorageSize += n
+
+	c.Data = &[][]byte{}
+	re.chunks[k] = c
+	return c, nil
+}
+
+// verify reads back all parts of a re-encrypted chunk, compares them with the
+// data which got stored and makes sure the chunk can be decrypted.
+func (re *Reencryption) verify(ctx context.Context, arc *Archive, chunk Chunk) error {
+	for i, data := range *chunk.Data {
+		b, err := re.repository.backend.LoadChunk(ctx, chunk, uint(i))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(b, data) {
+			return ErrVerifyChunkFailed
+		}
+	}
+
+	_, err := loadChunk(ctx, *re.repository, *arc, chunk, nil)
+	return err
+}
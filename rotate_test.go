@@ -0,0 +1,387 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotateKey(t *testing.T) {
+	testPassword := "this_is_a_password"
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	src, err := ioutil.TempDir("", "knoxite.src")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	data := make([]byte, 2*preferredChunkSize+1234)
+	rand.Read(data)
+	files := map[string][]byte{
+		"a.bin":     data,
+		"sub/b.bin": data,
+		"c.txt":     []byte("some text"),
+	}
+	for name, content := range files {
+		path := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	vol, _ := NewVolume("test", "")
+	if err := r.AddVolume(vol); err != nil {
+		t.Fatal(err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	snapshot, _ := NewSnapshot("test_snapshot")
+	opts := StoreOptions{
+		CWD:       src,
+		Paths:     []string{src},
+		Compress:  CompressionZstd,
+		Encrypt:   EncryptionAESGCM,
+		DataParts: 1,
+	}
+	for p := range snapshot.Add(ctx, r, &index, opts) {
+		if p.Error != nil {
+			t.Fatalf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+	if err := snapshot.Save(&r); err != nil {
+		t.Fatal(err)
+	}
+	if err := vol.AddSnapshot(snapshot.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Save(&r); err != nil {
+		t.Fatal(err)
+	}
+	oldKey := r.Key
+	oldChunks := len(index.Chunks)
+
+	if err := r.RotateKey(); err != nil {
+		t.Fatalf("Failed rotating key: %s", err)
+	}
+	if r.Key == oldKey || len(r.RetiredKeys) != 1 {
+		t.Fatalf("Expected a new key and the old key to be retired")
+	}
+
+	// existing data can still be read with the retired key
+	r, err = OpenRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed opening repository: %s", err)
+	}
+	index, err = OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	snapshot, err = r.Volumes[0].LoadSnapshot(snapshot.ID, &r)
+	if err != nil {
+		t.Fatalf("Failed loading snapshot encrypted with the retired key: %s", err)
+	}
+
+	re := NewReencryption(&r, &index)
+	if !re.Pending(snapshot) {
+		t.Fatal("Expected snapshot to need re-encryption")
+	}
+	stats, err := re.Snapshot(ctx, snapshot)
+	if err != nil {
+		t.Fatalf("Failed re-encrypting snapshot: %s", err)
+	}
+	// equal files share their chunks
+	if stats.Chunks != uint64(oldChunks) {
+		t.Errorf("Expected %d chunks to be re-encrypted, got %d", oldChunks, stats.Chunks)
+	}
+	if err := index.Save(&r); err != nil {
+		t.Fatal(err)
+	}
+
+	freed, err := index.Pack(ctx, &r)
+	if err != nil {
+		t.Fatalf("Failed packing repository: %s", err)
+	}
+	if freed == 0 || len(index.Chunks) != oldChunks {
+		t.Errorf("Expected the %d chunks encrypted with the retired key to be deleted, %d chunks left", oldChunks, len(index.Chunks))
+	}
+	if err := index.Save(&r); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.ForgetRetiredKeys(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = OpenRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed opening repository: %s", err)
+	}
+	if len(r.RetiredKeys) != 0 {
+		t.Errorf("Expected retired keys to be removed, got %d", len(r.RetiredKeys))
+	}
+	snapshot, err = r.Volumes[0].LoadSnapshot(snapshot.ID, &r)
+	if err != nil {
+		t.Fatalf("Failed loading re-encrypted snapshot: %s", err)
+	}
+	index, err = OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening re-encrypted chunk-index: %s", err)
+	}
+	if NewReencryption(&r, &index).Pending(snapshot) {
+		t.Error("Expected snapshot to be re-encrypted")
+	}
+
+	var buf bytes.Buffer
+	progress, err := ExportSnapshot(ctx, r, snapshot, &buf, FormatTar, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Failed exporting snapshot: %s", err)
+	}
+	for p := range progress {
+		if p.Error != nil {
+			t.Fatalf("Failed exporting snapshot: %s", p.Error)
+		}
+	}
+	compareExported(t, "re-encrypted", files, readTar(t, &buf))
+}
+
+func TestReencryptAlgo(t *testing.T) {
+	testPassword := "this_is_a_password"
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	src, err := ioutil.TempDir("", "knoxite.src")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	data := make([]byte, 2*preferredChunkSize+1234)
+	rand.Read(data)
+	files := map[string][]byte{
+		"a.bin": data,
+		"c.txt": []byte("some text"),
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(src, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a repository of an older format, using AES-CFB for all data
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	r.Version, r.ReaderVersion = 4, 4
+	vol, _ := NewVolume("test", "")
+	_ = r.AddVolume(vol)
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	snapshot, _ := NewSnapshot("test_snapshot")
+	opts := StoreOptions{
+		CWD:       src,
+		Paths:     []string{src},
+		Encrypt:   EncryptionAES,
+		DataParts: 1,
+	}
+	for p := range snapshot.Add(ctx, r, &index, opts) {
+		if p.Error != nil {
+			t.Fatalf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+	if err := snapshot.Save(&r); err != nil {
+		t.Fatal(err)
+	}
+	_ = vol.AddSnapshot(snapshot.ID)
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Save(&r); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Upgrade(); err != nil {
+		t.Fatalf("Failed upgrading repository: %s", err)
+	}
+	if r.Version != RepositoryVersion {
+		t.Errorf("Expected repository version %d, got %d", RepositoryVersion, r.Version)
+	}
+
+	re := NewReencryption(&r, &index)
+	re.Encrypt = EncryptionAESGCM
+	if !re.Pending(snapshot) {
+		t.Fatal("Expected snapshot using AES-CFB to need re-encryption")
+	}
+	stats, err := re.Snapshot(ctx, snapshot)
+	if err != nil {
+		t.Fatalf("Failed re-encrypting snapshot: %s", err)
+	}
+	if stats.Chunks != uint64(len(index.Chunks)/2) {
+		t.Errorf("Expected %d chunks to be re-encrypted, got %d", len(index.Chunks)/2, stats.Chunks)
+	}
+	if err := index.Save(&r); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = OpenRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed opening repository: %s", err)
+	}
+	snapshot, err = r.Volumes[0].LoadSnapshot(snapshot.ID, &r)
+	if err != nil {
+		t.Fatalf("Failed loading re-encrypted snapshot: %s", err)
+	}
+	for path, arc := range snapshot.Archives {
+		if arc.Type == File && arc.Encrypted != EncryptionAESGCM {
+			t.Errorf("Expected %s to be encrypted with AES-GCM, got %d", path, arc.Encrypted)
+		}
+	}
+	re = NewReencryption(&r, &index)
+	re.Encrypt = EncryptionAESGCM
+	if re.Pending(snapshot) {
+		t.Error("Expected snapshot to be re-encrypted")
+	}
+
+	var buf bytes.Buffer
+	progress, err := ExportSnapshot(ctx, r, snapshot, &buf, FormatTar, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Failed exporting snapshot: %s", err)
+	}
+	for p := range progress {
+		if p.Error != nil {
+			t.Fatalf("Failed exporting snapshot: %s", p.Error)
+		}
+	}
+	compareExported(t, "re-encrypted", files, readTar(t, &buf))
+}
+
+func TestRecompress(t *testing.T) {
+	testPassword := "this_is_a_password"
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	src, err := ioutil.TempDir("", "knoxite.src")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	files := map[string][]byte{
+		"a.txt": bytes.Repeat([]byte("compressible "), preferredChunkSize/4),
+		"c.txt": []byte("some text"),
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(src, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	vol, _ := NewVolume("test", "")
+	_ = r.AddVolume(vol)
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	snapshot, _ := NewSnapshot("test_snapshot")
+	opts := StoreOptions{
+		CWD:       src,
+		Paths:     []string{src},
+		Compress:  CompressionGZip,
+		Encrypt:   EncryptionAESGCM,
+		DataParts: 1,
+	}
+	for p := range snapshot.Add(ctx, r, &index, opts) {
+		if p.Error != nil {
+			t.Fatalf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+	if err := snapshot.Save(&r); err != nil {
+		t.Fatal(err)
+	}
+	_ = vol.AddSnapshot(snapshot.ID)
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	re := NewReencryption(&r, &index)
+	re.Compress = CompressionZstd
+	if !re.Pending(snapshot) {
+		t.Fatal("Expected snapshot compressed with GZip to need recompression")
+	}
+	if _, err := re.Snapshot(ctx, snapshot); err != nil {
+		t.Fatalf("Failed recompressing snapshot: %s", err)
+	}
+	if err := index.Save(&r); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err = OpenRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed opening repository: %s", err)
+	}
+	snapshot, err = r.Volumes[0].LoadSnapshot(snapshot.ID, &r)
+	if err != nil {
+		t.Fatalf("Failed loading recompressed snapshot: %s", err)
+	}
+	for path, arc := range snapshot.Archives {
+		if arc.Type == File && (arc.Compressed != CompressionZstd || arc.Encrypted != EncryptionAESGCM) {
+			t.Errorf("Expected %s to be compressed with zstd and stay encrypted, got %d and %d", path, arc.Compressed, arc.Encrypted)
+		}
+	}
+	re = NewReencryption(&r, &index)
+	re.Compress = CompressionZstd
+	if re.Pending(snapshot) {
+		t.Error("Expected snapshot to be recompressed")
+	}
+
+	var buf bytes.Buffer
+	progress, err := ExportSnapshot(ctx, r, snapshot, &buf, FormatTar, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Failed exporting snapshot: %s", err)
+	}
+	for p := range progress {
+		if p.Error != nil {
+			t.Fatalf("Failed exporting snapshot: %s", p.Error)
+		}
+	}
+	compareExported(t, "recompressed", files, readTar(t, &buf))
+}
@@ -0,0 +1,296 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SalvageOptions holds all the options for salvaging a repository.
+type SalvageOptions struct {
+	// Verify loads and decodes every chunk, instead of only checking that
+	// enough of its parts are stored
+	Verify bool
+}
+
+// DamagedArchive is an archive, which can't be restored completely.
+type DamagedArchive struct {
+	Snapshot string `json:"snapshot"`
+	Path     string `json:"path"`
+	Error    string `json:"error"`
+}
+
+// SalvageReport describes which data could be salvaged from a repository.
+type SalvageReport struct {
+	// Snapshots contains all snapshots that could be read
+	Snapshots []*Snapshot `json:"-"`
+	// Unreferenced contains the IDs of all readable snapshots, which aren't
+	// part of any volume
+	Unreferenced []string `json:"unreferenced_snapshots"`
+	// UnreadableSnapshots maps the IDs of snapshots, which couldn't be read,
+	// to the reason why
+	UnreadableSnapshots map[string]string `json:"unreadable_snapshots"`
+	// RecoverableArchives is the amount of archives, which can be restored
+	RecoverableArchives int              `json:"recoverable_archives"`
+	DamagedArchives     []DamagedArchive `json:"damaged_archives"`
+	// OrphanedChunks contains all stored chunks, which aren't referenced by
+	// any readable snapshot, as "<hash>_<data parts>"
+	OrphanedChunks []string `json:"orphaned_chunks"`
+	// OrphanedHeaders describes how orphaned chunks have been encoded, as
+	// read from the header of their first stored part. Chunks stored without
+	// a header are missing
+	OrphanedHeaders map[string]ChunkHeader `json:"orphaned_headers,omitempty"`
+	// UnlistedBackends contains the locations of all backends, which can't
+	// enumerate the data they store
+	UnlistedBackends []string `json:"unlisted_backends"`
+}
+
+// RecoverRepository creates new metadata for an existing repository, whose
+// repository file got lost or damaged. key is the repository's data key, as
+// found in the "key" field of the repository's metadata (see "knoxite repo
+// cat"). The key is only stored in the repository file, so it can't be derived
+// from the remaining data and must have been saved before the file got lost.
+// Its KeyFingerprint matches the one on the repository's recovery sheet. The
+// volumes of the repository are lost, but its snapshots can be found with
+// Salvage.
+func RecoverRepository(path, password, key string) (Repository, error) {
+	repository := Repository{
+		Version:       RepositoryVersion,
+		ReaderVersion: RepositoryVersion,
+		Key:           key,
+	}
+	if err := repository.initKeys(password); err != nil {
+		return repository, err
+	}
+
+	backend, err := BackendFromURL(path)
+	if err != nil {
+		return repository, err
+	}
+	repository.backend.AddBackend(&backend)
+
+	return repository, nil
+}
+
+// chunkKey identifies a chunk by its hash and the amount of its data parts.
+func chunkKey(hash string, dataParts uint) string {
+	return fmt.Sprintf("%s_%d", hash, dataParts)
+}
+
+// listStoredData returns the parts of all chunks stored in a repository,
+// indexed by chunkKey, and the IDs of all stored snapshots. It also returns
+// the locations of all backends that can't enumerate the data they store.
+func listStoredData(repository *Repository) (map[string]map[uint]bool, map[string]bool, []string, error) {
+	parts := make(map[string]map[uint]bool)
+	ids := make(map[string]bool)
+	var unlisted []string
+
+	for _, be := range repository.backend.Backends {
+		lister, ok := (*be).(BackendLister)
+		if !ok {
+			unlisted = append(unlisted, (*be).Location())
+			continue
+		}
+
+		chunks, err := lister.ListChunks()
+		if err == ErrListingUnsupported {
+			unlisted = append(unlisted, (*be).Location())
+			continue
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		snapshots, err := lister.ListSnapshots()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		for _, c := range chunks {
+			k := chunkKey(c.Hash, c.TotalParts)
+			if parts[k] == nil {
+				parts[k] = make(map[uint]bool)
+			}
+			parts[k][c.Part] = true
+		}
+		for _, id := range snapshots {
+			ids[id] = true
+		}
+	}
+
+	return parts, ids, unlisted, nil
+}
+
+// orphanedHeader reads the header of the first stored part of an orphaned
+// chunk. It returns nil if the part has been stored without a header.
+func orphanedHeader(ctx context.Context, repository *Repository, k string, stored map[uint]bool) (*ChunkHeader, error) {
+	i := strings.LastIndex(k, "_")
+	dataParts, err := strconv.ParseUint(k[i+1:], 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	part := ^uint(0)
+	for p := range stored {
+		if p < part {
+			part = p
+		}
+	}
+
+	b, err := repository.backend.LoadChunk(ctx, Chunk{Hash: k[:i], DataParts: uint(dataParts)}, part)
+	if err != nil {
+		return nil, err
+	}
+	header, _, err := SplitChunkHeader(b)
+	if err != nil {
+		return nil, err
+	}
+	// legacy parts could start with the magic by pure chance
+	if header == nil || header.Part != uint16(part) || header.DataParts != uint16(dataParts) {
+		return nil, nil
+	}
+	return header, nil
+}
+
+// storedParts returns how many parts of chunk are stored.
+func storedParts(parts map[string]map[uint]bool, chunk Chunk) uint {
+	found := uint(0)
+	stored := parts[chunkKey(chunk.Hash, chunk.DataParts)]
+	for i := uint(0); i < chunk.DataParts+chunk.ParityParts; i++ {
+		if stored[i] {
+			found++
+		}
+	}
+	return found
+}
+
+// Salvage scans all backends of a repository for snapshots and chunks and
+// reports which snapshots and archives can still be restored, even if the
+// repository's volumes or chunk-index are damaged.
+func Salvage(ctx context.Context, repository *Repository, opts SalvageOptions) (*SalvageReport, error) {
+	report := &SalvageReport{
+		UnreadableSnapshots: make(map[string]string),
+	}
+
+	// collect all stored chunk parts and snapshots
+	parts, ids, unlisted, err := listStoredData(repository)
+	if err != nil {
+		return report, err
+	}
+	report.UnlistedBackends = unlisted
+	listed := len(unlisted) < len(repository.backend.Backends)
+
+	referenced := make(map[string]bool)
+	for _, vol := range repository.Volumes {
+		for _, id := range vol.Snapshots {
+			referenced[id] = true
+			ids[id] = true
+		}
+	}
+
+	sorted := make([]string, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Strings(sorted)
+
+	// without a listing we can only tell whether a chunk is intact by
+	// loading it
+	verify := opts.Verify || !listed
+	checked := make(map[string]error)
+	used := make(map[string]bool)
+
+	for _, id := range sorted {
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+
+		snapshot, err := openSnapshot(id, repository)
+		if err != nil {
+			report.UnreadableSnapshots[id] = err.Error()
+			continue
+		}
+		report.Snapshots = append(report.Snapshots, snapshot)
+		if !referenced[id] {
+			report.Unreferenced = append(report.Unreferenced, id)
+		}
+
+		paths := make([]string, 0, len(snapshot.Archives))
+		for path := range snapshot.Archives {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+
+		for _, path := range paths {
+			archive := snapshot.Archives[path]
+
+			var archiveErr error
+			for _, chunk := range archive.Chunks {
+				if chunk.Hole {
+					continue
+				}
+				k := chunkKey(chunk.Hash, chunk.DataParts)
+				used[k] = true
+
+				err, ok := checked[k]
+				if !ok {
+					if verify {
+						_, err = loadChunk(ctx, *repository, *archive, chunk, nil)
+						if ctx.Err() != nil {
+							return report, ctx.Err()
+						}
+					} else {
+						if found := storedParts(parts, chunk); found < chunk.DataParts {
+							err = &DataReconstructionError{chunk, found, chunk.DataParts - found}
+						}
+					}
+					checked[k] = err
+				}
+				if err != nil && archiveErr == nil {
+					archiveErr = err
+				}
+			}
+
+			if archiveErr != nil {
+				report.DamagedArchives = append(report.DamagedArchives, DamagedArchive{
+					Snapshot: id,
+					Path:     path,
+					Error:    archiveErr.Error(),
+				})
+				continue
+			}
+			report.RecoverableArchives++
+		}
+	}
+
+	// orphaned chunks can't be decoded without the snapshots referencing
+	// them, but their headers still tell how they have been stored
+	for k, stored := range parts {
+		if used[k] {
+			continue
+		}
+		report.OrphanedChunks = append(report.OrphanedChunks, k)
+
+		header, err := orphanedHeader(ctx, repository, k, stored)
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+		if err != nil || header == nil {
+			continue
+		}
+		if report.OrphanedHeaders == nil {
+			report.OrphanedHeaders = make(map[string]ChunkHeader)
+		}
+		report.OrphanedHeaders[k] = *header
+	}
+	sort.Strings(report.OrphanedChunks)
+
+	return report, nil
+}
@@ -0,0 +1,138 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestSalvage(t *testing.T) {
+	testPassword := "this_is_a_password"
+
+	src, err := ioutil.TempDir("", "knoxite.source")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+	for _, name := range []string{"intact", "damaged"} {
+		if err := ioutil.WriteFile(filepath.Join(src, name), []byte("content of "+name), 0600); err != nil {
+			t.Fatalf("Failed writing test file: %s", err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	vol, err := NewVolume("test", "")
+	if err != nil {
+		t.Fatalf("Failed creating volume: %s", err)
+	}
+	_ = r.AddVolume(vol)
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+
+	// store two snapshots, only the first one becomes part of the volume
+	var snapshots []*Snapshot
+	for i := 0; i < 2; i++ {
+		snapshot, err := NewSnapshot("test_snapshot")
+		if err != nil {
+			t.Fatalf("Failed creating snapshot: %s", err)
+		}
+		opts := StoreOptions{
+			CWD:       src,
+			Paths:     []string{src},
+			Encrypt:   EncryptionAES,
+			DataParts: 1,
+		}
+		for p := range snapshot.Add(context.Background(), r, &index, opts) {
+			if p.Error != nil {
+				t.Fatalf("Failed adding to snapshot: %s", p.Error)
+			}
+		}
+		if err := snapshot.Save(&r); err != nil {
+			t.Fatalf("Failed saving snapshot: %s", err)
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	_ = vol.AddSnapshot(snapshots[0].ID)
+	if err := r.Save(); err != nil {
+		t.Fatalf("Failed saving repository: %s", err)
+	}
+
+	// lose a chunk, an unreadable snapshot and an orphaned chunk
+	chunk := snapshots[0].Archives["damaged"].Chunks[0]
+	err = os.Remove(filepath.Join(dir, chunksDirname, SubDirForChunk(chunk.Hash), chunk.Hash+".0_1"))
+	if err != nil {
+		t.Fatalf("Failed deleting chunk: %s", err)
+	}
+	if err := r.backend.SaveSnapshot("garbage", []byte("garbage")); err != nil {
+		t.Fatalf("Failed writing snapshot: %s", err)
+	}
+	orphan := Hash([]byte("orphan"), HashHighway256)
+	header := ChunkHeader{Version: ChunkFormatVersion, Encryption: EncryptionAES, DataParts: 1, Size: 6}
+	h, _ := header.MarshalBinary()
+	_, err = r.backend.StoreChunk(context.Background(), Chunk{Hash: orphan, DataParts: 1, Data: &[][]byte{append(h, "orphan"...)}})
+	if err != nil {
+		t.Fatalf("Failed storing chunk: %s", err)
+	}
+
+	check := func(r *Repository, unreferenced int) {
+		for _, verify := range []bool{false, true} {
+			report, err := Salvage(context.Background(), r, SalvageOptions{Verify: verify})
+			if err != nil {
+				t.Fatalf("Failed salvaging repository: %s", err)
+			}
+
+			if len(report.Snapshots) != 2 || len(report.Unreferenced) != unreferenced {
+				t.Errorf("Expected 2 snapshots, %d unreferenced, got %d snapshots, %v unreferenced",
+					unreferenced, len(report.Snapshots), report.Unreferenced)
+			}
+			if _, ok := report.UnreadableSnapshots["garbage"]; !ok || len(report.UnreadableSnapshots) != 1 {
+				t.Errorf("Expected snapshot garbage to be unreadable, got %v", report.UnreadableSnapshots)
+			}
+			// both snapshots share the lost chunk
+			if len(report.DamagedArchives) != 2 || report.DamagedArchives[0].Path != "damaged" {
+				t.Errorf("Expected damaged archives, got %v", report.DamagedArchives)
+			}
+			if report.RecoverableArchives != 2 {
+				t.Errorf("Expected 2 recoverable archives, got %d", report.RecoverableArchives)
+			}
+			if len(report.OrphanedChunks) != 1 || report.OrphanedChunks[0] != orphan+"_"+strconv.Itoa(1) {
+				t.Errorf("Expected orphaned chunk %s, got %v", orphan, report.OrphanedChunks)
+			}
+			if h := report.OrphanedHeaders[orphan+"_1"]; h != header || len(report.OrphanedHeaders) != 1 {
+				t.Errorf("Expected header %v of the orphaned chunk, got %v", header, report.OrphanedHeaders)
+			}
+		}
+	}
+	check(&r, 1)
+
+	// recover from a lost repository file
+	if err := os.Remove(filepath.Join(dir, RepoFilename)); err != nil {
+		t.Fatalf("Failed deleting repository file: %s", err)
+	}
+	recovered, err := RecoverRepository(dir, testPassword, r.Key)
+	if err != nil {
+		t.Fatalf("Failed recovering repository: %s", err)
+	}
+	check(&recovered, 2)
+}
@@ -8,18 +8,146 @@
 package knoxite
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
-func findFiles(rootPath string, excludes []string) <-chan ArchiveResult {
+// ScanLimits protects the scanner against pathological directory trees.
+// Entries exceeding one of the limits get skipped and reported with a
+// SkippedError. Limits which are 0 default to the ones in DefaultScanLimits.
+type ScanLimits struct {
+	// MaxDepth is the maximum amount of directories an entry may be nested
+	// in, below the scanned path
+	MaxDepth int
+	// MaxNameLength is the maximum length of a file name in bytes
+	MaxNameLength int
+	// MaxPathLength is the maximum length of a path in bytes
+	MaxPathLength int
+	// MaxSymLinkLength is the maximum length of a symlink's target in bytes
+	MaxSymLinkLength int
+}
+
+// DefaultScanLimits are used for all limits which aren't set explicitly.
+var DefaultScanLimits = ScanLimits{
+	MaxDepth:         512,
+	MaxNameLength:    1024,
+	MaxPathLength:    4096,
+	MaxSymLinkLength: 4096,
+}
+
+// SkippedError records an entry, which has been skipped by the scanner.
+type SkippedError struct {
+	Path   string
+	Reason string
+}
+
+func (e *SkippedError) Error() string {
+	return fmt.Sprintf("skipped %s: %s", e.Path, e.Reason)
+}
+
+// IsSkipped returns true if err reports an entry skipped by the scanner.
+func IsSkipped(err error) bool {
+	var skipped *SkippedError
+	return errors.As(err, &skipped)
+}
+
+// withDefaults returns the limits with all unset limits set to their default.
+func (l ScanLimits) withDefaults() ScanLimits {
+	if l.MaxDepth == 0 {
+		l.MaxDepth = DefaultScanLimits.MaxDepth
+	}
+	if l.MaxNameLength == 0 {
+		l.MaxNameLength = DefaultScanLimits.MaxNameLength
+	}
+	if l.MaxPathLength == 0 {
+		l.MaxPathLength = DefaultScanLimits.MaxPathLength
+	}
+	if l.MaxSymLinkLength == 0 {
+		l.MaxSymLinkLength = DefaultScanLimits.MaxSymLinkLength
+	}
+	return l
+}
+
+// check returns why path exceeds the limits, or an empty string.
+func (l ScanLimits) check(rootPath, path string) string {
+	if len(path) > l.MaxPathLength {
+		return fmt.Sprintf("path is longer than %d bytes", l.MaxPathLength)
+	}
+	if len(filepath.Base(path)) > l.MaxNameLength {
+		return fmt.Sprintf("name is longer than %d bytes", l.MaxNameLength)
+	}
+	if rel, err := filepath.Rel(rootPath, path); err == nil &&
+		strings.Count(rel, string(os.PathSeparator)) >= l.MaxDepth {
+		return fmt.Sprintf("nested deeper than %d directories", l.MaxDepth)
+	}
+	return ""
+}
+
+// findFiles scans rootPath and sends all the items found on the returned
+// channel. With follow set, symlinks get replaced by the items they point to.
+// Links to directories which have been scanned already, e.g. because they
+// point to one of their parents, are kept as links to prevent loops.
+func findFiles(ctx context.Context, rootPath string, filter *excludeFilter, limits ScanLimits, streams, follow bool) <-chan ArchiveResult {
 	c := make(chan ArchiveResult)
+	wf := filter.walk(rootPath)
+	limits = limits.withDefaults()
+
+	skip := func(path, reason string) error {
+		select {
+		case c <- ArchiveResult{Archive: &Archive{Path: path}, Error: &SkippedError{path, reason}}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	// the device of rootPath, set once it has been visited
+	var rootDev *uint64
+	// the directories scanned so far, only tracked when following links
+	visited := make(map[[2]uint64]bool)
+
 	go func() {
 		defer close(c)
-		err := filepath.Walk(rootPath, func(path string, fi os.FileInfo, err error) error {
+
+		var visit filepath.WalkFunc
+		// walkLink scans the content of a followed link to a directory
+		walkLink := func(path string) error {
+			fd, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			names, err := fd.Readdirnames(-1)
+			fd.Close()
+			if err != nil {
+				return err
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if err := filepath.Walk(filepath.Join(path, name), visit); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+
+		visit = func(path string, fi os.FileInfo, err error) error {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if reason := limits.check(rootPath, path); reason != "" {
+				if err := skip(path, reason); err != nil {
+					return err
+				}
+				if fi != nil && fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
 			if err != nil {
 				if os.IsNotExist(err) {
 					return nil
@@ -32,26 +160,32 @@ func findFiles(rootPath string, excludes []string) <-chan ArchiveResult {
 				return fmt.Errorf("%s: could not read", path)
 			}
 
-			match := false
-			for _, exclude := range excludes {
-				// fmt.Println("Matching", path, filepath.Base(path), exclude)
-				match, err = filepath.Match(strings.ToLower(exclude), strings.ToLower(path))
-				if err != nil {
-					fmt.Println("Invalid exclude filter:", exclude)
-					return err
+			// filepath.Walk doesn't know a followed link is a directory, so
+			// it can't be skipped like one
+			skipDir := filepath.SkipDir
+			followed := false
+			if follow && isSymLink(fi) {
+				skipDir = nil
+				if target, err := os.Stat(path); err == nil {
+					id, ok := dirID(target)
+					if isRegularFile(target) || (target.IsDir() && ok && !visited[id]) {
+						fi, followed = target, target.IsDir()
+					}
 				}
-				if !match {
-					match, _ = filepath.Match(strings.ToLower(exclude), strings.ToLower(filepath.Base(path)))
+			}
+			if follow && fi.IsDir() {
+				if id, ok := dirID(fi); ok {
+					visited[id] = true
 				}
+			}
 
-				if match {
-					// fmt.Printf("\n\nSkipping %s as it matches filter: %s\n\n", path, exclude)
-					break
-				}
+			match, err := wf.excluded(path, fi)
+			if err != nil {
+				return err
 			}
 			if match {
 				if fi.IsDir() {
-					return filepath.SkipDir
+					return skipDir
 				}
 				return nil
 			}
@@ -60,39 +194,76 @@ func findFiles(rootPath string, excludes []string) <-chan ArchiveResult {
 			if !ok {
 				return &os.PathError{Op: "stat", Path: path, Err: errors.New("error reading metadata")}
 			}
+			// mount points get stored, but not what's mounted on them
+			mountPoint := false
+			if wf.oneFileSystem {
+				dev := statT.dev()
+				if rootDev == nil {
+					rootDev = &dev
+				} else if fi.IsDir() && dev != *rootDev {
+					mountPoint = true
+				}
+			}
 			archive := Archive{
 				Path:    path,
 				Mode:    fi.Mode(),
 				ModTime: fi.ModTime().Unix(),
 				UID:     statT.uid(),
 				GID:     statT.gid(),
+				XAttrs:  readXAttrs(path),
 				// AbsPath: path,
 				// FileInfo: fi,
 			}
+			readFileAttributes(path, fi, &archive, streams)
 			if isSymLink(fi) {
 				symlink, err := os.Readlink(path)
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "\n\nerror resolving symlink for: %v - %v\n\n", path, err)
+					log.Warnf("Error resolving symlink for %s: %v", path, err)
 					return nil
 				}
 
+				if len(symlink) > limits.MaxSymLinkLength {
+					return skip(path, fmt.Sprintf("symlink target is longer than %d bytes", limits.MaxSymLinkLength))
+				}
+
 				archive.Type = SymLink
 				archive.PointsTo = symlink
+				if target, err := os.Stat(path); err == nil {
+					archive.TargetMode = target.Mode()
+				} else {
+					archive.Dangling = true
+				}
 			} else if fi.IsDir() {
 				archive.Type = Directory
 			} else if isRegularFile(fi) {
 				archive.Type = File
 				archive.Size = uint64(fi.Size())
+				archive.device = statT.dev()
+				archive.ino = statT.ino()
+				if statT.nlink() > 1 && statT.ino() != 0 {
+					archive.inode = [2]uint64{statT.dev(), statT.ino()}
+				}
 			} else {
 				return nil
 			}
 
-			c <- ArchiveResult{Archive: &archive, Error: nil}
-			return nil
-		})
+			select {
+			case c <- ArchiveResult{Archive: &archive, Error: nil}:
+				if mountPoint {
+					return skipDir
+				}
+				if followed {
+					return walkLink(path)
+				}
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
 
-		if err != nil {
-			c <- ArchiveResult{Archive: nil, Error: err}
+		err := filepath.Walk(rootPath, visit)
+		if err != nil && ctx.Err() == nil {
+			c <- ArchiveResult{Archive: &Archive{Path: rootPath}, Error: err}
 		}
 	}()
 	return c
@@ -109,3 +280,13 @@ func isSymLink(fi os.FileInfo) bool {
 func isRegularFile(fi os.FileInfo) bool {
 	return fi != nil && fi.Mode()&(os.ModeType|os.ModeCharDevice|os.ModeSymlink) == 0
 }
+
+// dirID returns the device and inode identifying the directory fi. It reports
+// false if the file system doesn't provide them.
+func dirID(fi os.FileInfo) ([2]uint64, bool) {
+	statT, ok := toStatT(fi.Sys())
+	if !ok || statT.ino() == 0 {
+		return [2]uint64{}, false
+	}
+	return [2]uint64{statT.dev(), statT.ino()}, true
+}
@@ -0,0 +1,110 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScanLimits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite.scan")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	deep := filepath.Join(dir, strings.Repeat("d"+string(os.PathSeparator), 20))
+	if err := os.MkdirAll(deep, 0755); err != nil {
+		t.Fatalf("Failed creating nested dirs: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, strings.Repeat("n", 50)), nil, 0600); err != nil {
+		t.Fatalf("Failed writing file: %s", err)
+	}
+	if err := os.Symlink(strings.Repeat("t", 50), filepath.Join(dir, "link")); err != nil {
+		t.Fatalf("Failed creating symlink: %s", err)
+	}
+
+	limits := ScanLimits{
+		MaxDepth:         5,
+		MaxNameLength:    40,
+		MaxSymLinkLength: 40,
+	}
+
+	var paths []string
+	skipped := make(map[string]bool)
+	for result := range findFiles(context.Background(), dir, &excludeFilter{}, limits, false, false) {
+		if IsSkipped(result.Error) {
+			rel, _ := filepath.Rel(dir, result.Archive.Path)
+			skipped[rel] = true
+			continue
+		}
+		if result.Error != nil {
+			t.Fatalf("Failed scanning: %s", result.Error)
+		}
+		paths = append(paths, result.Archive.Path)
+	}
+
+	expected := []string{
+		filepath.Join("d", "d", "d", "d", "d", "d"),
+		strings.Repeat("n", 50),
+		"link",
+	}
+	if len(skipped) != len(expected) {
+		t.Errorf("Expected %d skipped entries, got %v", len(expected), skipped)
+	}
+	for _, e := range expected {
+		if !skipped[e] {
+			t.Errorf("Expected %s to be skipped, got %v", e, skipped)
+		}
+	}
+	// the root, plus five nested dirs
+	if len(paths) != 6 {
+		t.Errorf("Expected 6 entries, got %v", paths)
+	}
+}
+
+func TestScanOneFileSystem(t *testing.T) {
+	// /dev/shm is a separate file system on most unix systems
+	dev, err1 := os.Lstat("/dev")
+	shm, err2 := os.Lstat("/dev/shm")
+	if err1 != nil || err2 != nil {
+		t.Skip("/dev/shm not found")
+	}
+	devT, ok1 := toStatT(dev.Sys())
+	shmT, ok2 := toStatT(shm.Sys())
+	if !ok1 || !ok2 || devT.dev() == shmT.dev() {
+		t.Skip("/dev/shm is not a mount point")
+	}
+	f, err := ioutil.TempFile("/dev/shm", "knoxite.scan")
+	if err != nil {
+		t.Skipf("Can't write to /dev/shm: %s", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	for _, oneFileSystem := range []bool{false, true} {
+		found := make(map[string]bool)
+		filter := &excludeFilter{oneFileSystem: oneFileSystem}
+		for result := range findFiles(context.Background(), "/dev", filter, ScanLimits{}, false, false) {
+			if result.Error == nil {
+				found[result.Archive.Path] = true
+			}
+		}
+		if !found["/dev/shm"] {
+			t.Errorf("Expected the mount point /dev/shm to be found (one file system: %v)", oneFileSystem)
+		}
+		if found[f.Name()] == oneFileSystem {
+			t.Errorf("Expected %s to be found: %v, got %v", f.Name(), !oneFileSystem, found[f.Name()])
+		}
+	}
+}
@@ -0,0 +1,383 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Calendar defines the regular times at which snapshots get stored.
+// Custom calendars can be plugged into a Schedule.
+type Calendar interface {
+	// After returns the first time a snapshot is due after t
+	After(t time.Time) time.Time
+}
+
+// scheduledHour is the hour of the day at which daily, weekly and monthly
+// snapshots get stored.
+const scheduledHour = 3
+
+// namedCalendar stores snapshots hourly, daily, weekly or monthly.
+type namedCalendar string
+
+// After returns the next full hour, or the next 3am of the next day, sunday
+// or first day of a month.
+func (c namedCalendar) After(t time.Time) time.Time {
+	y, m, d := t.Date()
+	var next time.Time
+	switch c {
+	case "hourly":
+		return time.Date(y, m, d, t.Hour()+1, 0, 0, 0, t.Location())
+	case "daily":
+		next = time.Date(y, m, d, scheduledHour, 0, 0, 0, t.Location())
+		if !next.After(t) {
+			next = next.AddDate(0, 0, 1)
+		}
+	case "weekly":
+		next = time.Date(y, m, d-int(t.Weekday()), scheduledHour, 0, 0, 0, t.Location())
+		if !next.After(t) {
+			next = next.AddDate(0, 0, 7)
+		}
+	case "monthly":
+		next = time.Date(y, m, 1, scheduledHour, 0, 0, 0, t.Location())
+		if !next.After(t) {
+			next = next.AddDate(0, 1, 0)
+		}
+	}
+	return next
+}
+
+// CalendarFromName returns the calendar for a schedule like "daily" or a cron
+// expression like "30 2 * * 1-5". It returns nil for "never".
+func CalendarFromName(name string) (Calendar, error) {
+	switch name {
+	case "hourly", "daily", "weekly", "monthly":
+		return namedCalendar(name), nil
+	case "never", "":
+		return nil, nil
+	}
+	if len(strings.Fields(name)) == len(cronFields) {
+		return ParseCronCalendar(name)
+	}
+	return nil, fmt.Errorf("unknown schedule %s, expected hourly, daily, weekly, monthly, never or a cron expression", name)
+}
+
+// cronField describes the range of a field of a cron expression.
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// cronCalendar stores snapshots at the times matching a cron expression.
+type cronCalendar struct {
+	// fields holds the allowed values of each field
+	fields [5][]bool
+	// anyDom and anyDow are true if the day of month or the day of week
+	// aren't restricted. If both are, a day matching either of them is due
+	anyDom, anyDow bool
+}
+
+// ParseCronCalendar parses a cron expression with the five fields minute,
+// hour, day of month, month and day of week. Each field is either "*", a
+// value, a range like "1-5" or a list of these like "1,15", optionally with a
+// step like "*/15". Sunday is both 0 and 7 in the day of week field.
+func ParseCronCalendar(spec string) (Calendar, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression %s, expected 5 fields", spec)
+	}
+
+	c := &cronCalendar{
+		anyDom: fields[2] == "*",
+		anyDow: fields[4] == "*",
+	}
+	for i, f := range cronFields {
+		values, err := parseCronField(fields[i], f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %s: %v", spec, err)
+		}
+		c.fields[i] = values
+	}
+	// sunday
+	c.fields[4][0] = c.fields[4][0] || c.fields[4][7]
+
+	// never due, e.g. on february 30th
+	if c.After(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)).IsZero() {
+		return nil, fmt.Errorf("invalid cron expression %s, it's never due", spec)
+	}
+	return c, nil
+}
+
+// parseCronField returns the values allowed by a field of a cron expression.
+func parseCronField(s string, f cronField) ([]bool, error) {
+	values := make([]bool, f.max+1)
+	for _, item := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(item[i+1:]); err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in %s field %s", f.name, item)
+			}
+			item = item[:i]
+		}
+
+		from, to := f.min, f.max
+		if item != "*" {
+			r := strings.SplitN(item, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(r[0]); err != nil {
+				return nil, fmt.Errorf("invalid %s %s", f.name, item)
+			}
+			to = from
+			if len(r) == 2 {
+				if to, err = strconv.Atoi(r[1]); err != nil {
+					return nil, fmt.Errorf("invalid %s %s", f.name, item)
+				}
+			} else if step > 1 {
+				// "5/15" means from 5 to the end
+				to = f.max
+			}
+		}
+		if from < f.min || to > f.max || from > to {
+			return nil, fmt.Errorf("%s %s out of range %d-%d", f.name, item, f.min, f.max)
+		}
+
+		for v := from; v <= to; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// day returns true if snapshots are due on the day of t.
+func (c *cronCalendar) day(t time.Time) bool {
+	dom := c.fields[2][t.Day()]
+	dow := c.fields[4][t.Weekday()]
+	switch {
+	case c.anyDom && c.anyDow:
+		return true
+	case c.anyDom:
+		return dow
+	case c.anyDow:
+		return dom
+	}
+	return dom || dow
+}
+
+// After returns the first minute after t matching the cron expression. It
+// returns a zero time if there is none within the next five years.
+func (c *cronCalendar) After(t time.Time) time.Time {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	limit := next.AddDate(5, 0, 0)
+
+	for next.Before(limit) {
+		y, m, d := next.Date()
+		switch {
+		case !c.fields[3][m]:
+			next = time.Date(y, m+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.day(next):
+			next = time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+		case !c.fields[1][next.Hour()]:
+			next = time.Date(y, m, d, next.Hour()+1, 0, 0, 0, t.Location())
+		case !c.fields[0][next.Minute()]:
+			next = next.Add(time.Minute)
+		default:
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+// BlackoutWindow is a time range on certain days of the week, during which no
+// snapshots get stored, e.g. during business hours. A window ending before
+// it starts lasts until the next day.
+type BlackoutWindow struct {
+	Weekdays [7]bool
+	// Start and End are durations since midnight
+	Start time.Duration
+	End   time.Duration
+}
+
+var weekdayNames = []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+
+// ParseBlackoutWindow parses a window like "Mon-Fri 09:00-17:00",
+// "Sat,Sun 00:00-24:00" or "22:00-06:00". Without days it applies to all of
+// them.
+func ParseBlackoutWindow(s string) (BlackoutWindow, error) {
+	w := BlackoutWindow{}
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return w, fmt.Errorf("invalid blackout window %s, expected [days] [HH:MM]-[HH:MM]", s)
+	}
+
+	if len(fields) == 1 {
+		for i := range w.Weekdays {
+			w.Weekdays[i] = true
+		}
+	} else {
+		for _, days := range strings.Split(fields[0], ",") {
+			r := strings.SplitN(days, "-", 2)
+			from, err := parseWeekday(r[0])
+			if err != nil {
+				return w, err
+			}
+			to := from
+			if len(r) == 2 {
+				if to, err = parseWeekday(r[1]); err != nil {
+					return w, err
+				}
+			}
+			for d := from; ; d = (d + 1) % 7 {
+				w.Weekdays[d] = true
+				if d == to {
+					break
+				}
+			}
+		}
+	}
+
+	times := strings.SplitN(fields[len(fields)-1], "-", 2)
+	if len(times) != 2 {
+		return w, fmt.Errorf("invalid blackout window %s, expected [days] [HH:MM]-[HH:MM]", s)
+	}
+	var err error
+	if w.Start, err = parseTimeOfDay(times[0]); err != nil {
+		return w, err
+	}
+	if w.End, err = parseTimeOfDay(times[1]); err != nil {
+		return w, err
+	}
+	if w.Start == w.End {
+		return w, fmt.Errorf("invalid blackout window %s, it doesn't last any time", s)
+	}
+
+	return w, nil
+}
+
+// parseWeekday parses a weekday's name, which may be abbreviated to its first
+// three letters.
+func parseWeekday(s string) (int, error) {
+	s = strings.ToLower(s)
+	for i, name := range weekdayNames {
+		if len(s) >= 3 && strings.HasPrefix(name, s) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid weekday %s", s)
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	hm := strings.SplitN(s, ":", 2)
+	if len(hm) != 2 {
+		return 0, fmt.Errorf("invalid time %s, expected HH:MM", s)
+	}
+	h, err := strconv.Atoi(hm[0])
+	if err != nil || h < 0 || h > 24 {
+		return 0, fmt.Errorf("invalid hour in %s", s)
+	}
+	m, err := strconv.Atoi(hm[1])
+	if err != nil || m < 0 || m > 59 || (h == 24 && m > 0) {
+		return 0, fmt.Errorf("invalid minute in %s", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// end returns the end of the window containing t, if any.
+func (w BlackoutWindow) end(t time.Time) (time.Time, bool) {
+	y, m, d := t.Date()
+	midnight := time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+	tod := t.Sub(midnight)
+	today := w.Weekdays[t.Weekday()]
+	yesterday := w.Weekdays[(t.Weekday()+6)%7]
+
+	if w.Start < w.End {
+		if today && tod >= w.Start && tod < w.End {
+			return midnight.Add(w.End), true
+		}
+		return t, false
+	}
+
+	// the window lasts until the next day
+	if today && tod >= w.Start {
+		return time.Date(y, m, d+1, 0, 0, 0, 0, t.Location()).Add(w.End), true
+	}
+	if yesterday && tod < w.End {
+		return midnight.Add(w.End), true
+	}
+	return t, false
+}
+
+// Schedule decides when the next snapshot gets stored.
+type Schedule struct {
+	// Calendar defines the regular times snapshots are due. Without one no
+	// snapshots get scheduled
+	Calendar Calendar
+	// Blackouts are the windows during which no snapshots get stored
+	Blackouts []BlackoutWindow
+	// Jitter is the maximum random delay added to each scheduled time, so
+	// many hosts don't store their snapshots at the same time
+	Jitter time.Duration
+	// CatchUp stores a snapshot as soon as possible, when its scheduled time
+	// got missed, e.g. while the computer was asleep. Otherwise the snapshot
+	// is skipped
+	CatchUp bool
+}
+
+// Next returns when to store the next snapshot, given the time the last one
+// got stored at. It returns a zero time if no snapshot is going to be
+// scheduled.
+func (s Schedule) Next(last, now time.Time) time.Time {
+	if s.Calendar == nil {
+		return time.Time{}
+	}
+
+	next := now
+	if !last.IsZero() {
+		next = s.Calendar.After(last)
+		if next.Before(now) {
+			// the scheduled time has been missed
+			if s.CatchUp {
+				next = now
+			} else {
+				next = s.Calendar.After(now)
+			}
+		}
+	}
+	if s.Jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(s.Jitter))))
+	}
+
+	// windows may follow each other, but can't keep on postponing the next
+	// snapshot for longer than a week
+	for i := 0; i <= 8*len(s.Blackouts); i++ {
+		blacked := false
+		for _, w := range s.Blackouts {
+			if end, ok := w.end(next); ok {
+				next = end
+				blacked = true
+			}
+		}
+		if !blacked {
+			return next
+		}
+	}
+
+	return time.Time{}
+}
@@ -0,0 +1,187 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04", s, time.UTC)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func TestCalendar(t *testing.T) {
+	tests := []struct {
+		name string
+		t    string
+		exp  string
+	}{
+		{"hourly", "2020-06-10 14:30", "2020-06-10 15:00"},
+		{"hourly", "2020-06-10 23:00", "2020-06-11 00:00"},
+		{"daily", "2020-06-10 02:00", "2020-06-10 03:00"},
+		{"daily", "2020-06-10 03:00", "2020-06-11 03:00"},
+		// 2020-06-10 is a wednesday
+		{"weekly", "2020-06-10 14:30", "2020-06-14 03:00"},
+		{"monthly", "2020-06-10 14:30", "2020-07-01 03:00"},
+		{"monthly", "2020-12-01 02:00", "2020-12-01 03:00"},
+	}
+
+	for _, tt := range tests {
+		c, err := CalendarFromName(tt.name)
+		if err != nil {
+			t.Fatalf("Failed getting calendar %s: %s", tt.name, err)
+		}
+		if next := c.After(date(tt.t)); !next.Equal(date(tt.exp)) {
+			t.Errorf("Expected %s after %s to be %s, got %s", tt.name, tt.t, tt.exp, next)
+		}
+	}
+
+	if c, err := CalendarFromName("never"); err != nil || c != nil {
+		t.Errorf("Expected no calendar for never, got %v: %v", c, err)
+	}
+	if _, err := CalendarFromName("sometimes"); err == nil {
+		t.Errorf("Expected error for unknown calendar")
+	}
+}
+
+func TestCronCalendar(t *testing.T) {
+	tests := []struct {
+		spec string
+		t    string
+		exp  string
+	}{
+		{"*/15 * * * *", "2020-06-10 14:31", "2020-06-10 14:45"},
+		{"30 2 * * *", "2020-06-10 02:30", "2020-06-11 02:30"},
+		// 2020-06-12 is a friday
+		{"0 22 * * 1-5", "2020-06-12 22:10", "2020-06-15 22:00"},
+		{"0 3 * * 7", "2020-06-10 14:30", "2020-06-14 03:00"},
+		{"0 0 1,15 * *", "2020-06-02 00:00", "2020-06-15 00:00"},
+		{"0 12 * 2 *", "2020-06-10 14:30", "2021-02-01 12:00"},
+		{"0 0 29 2 *", "2020-03-01 00:00", "2024-02-29 00:00"},
+		// either the day of month or the day of week
+		{"0 0 13 * 5", "2020-06-10 14:30", "2020-06-12 00:00"},
+	}
+
+	for _, tt := range tests {
+		c, err := CalendarFromName(tt.spec)
+		if err != nil {
+			t.Fatalf("Failed parsing cron expression %s: %s", tt.spec, err)
+		}
+		if next := c.After(date(tt.t)); !next.Equal(date(tt.exp)) {
+			t.Errorf("Expected %s after %s to be %s, got %s", tt.spec, tt.t, tt.exp, next)
+		}
+	}
+
+	for _, spec := range []string{"60 * * * *", "* * * *", "*/0 * * * *", "5-1 * * * *", "0 0 30 2 *", "a * * * *"} {
+		if _, err := ParseCronCalendar(spec); err == nil {
+			t.Errorf("Expected error for invalid cron expression %s", spec)
+		}
+	}
+}
+
+func TestParseBlackoutWindow(t *testing.T) {
+	w, err := ParseBlackoutWindow("Mon-Fri 09:00-17:30")
+	if err != nil {
+		t.Fatalf("Failed parsing blackout window: %s", err)
+	}
+	if w.Weekdays != [7]bool{false, true, true, true, true, true, false} {
+		t.Errorf("Unexpected weekdays %v", w.Weekdays)
+	}
+	if w.Start != 9*time.Hour || w.End != 17*time.Hour+30*time.Minute {
+		t.Errorf("Unexpected window %s-%s", w.Start, w.End)
+	}
+
+	w, err = ParseBlackoutWindow("fri-monday,wed 22:00-24:00")
+	if err != nil {
+		t.Fatalf("Failed parsing blackout window: %s", err)
+	}
+	if w.Weekdays != [7]bool{true, true, false, true, false, true, true} {
+		t.Errorf("Unexpected weekdays %v", w.Weekdays)
+	}
+
+	for _, s := range []string{"", "09:00", "Mo 09:00-10:00", "Mon 09:00-09:00", "25:00-26:00", "Mon Tue 09:00-10:00", "9-17"} {
+		if _, err := ParseBlackoutWindow(s); err == nil {
+			t.Errorf("Expected error parsing blackout window %q", s)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	daily, _ := CalendarFromName("daily")
+	hourly, _ := CalendarFromName("hourly")
+	business, _ := ParseBlackoutWindow("Mon-Fri 08:00-18:00")
+	night, _ := ParseBlackoutWindow("22:00-06:00")
+	morning, _ := ParseBlackoutWindow("Mon-Fri 06:00-08:00")
+
+	tests := []struct {
+		schedule Schedule
+		last     string
+		now      string
+		exp      string
+	}{
+		// first snapshot
+		{Schedule{Calendar: daily}, "", "2020-06-10 14:30", "2020-06-10 14:30"},
+		// due later today
+		{Schedule{Calendar: daily}, "2020-06-09 03:00", "2020-06-10 01:00", "2020-06-10 03:00"},
+		// missed while asleep
+		{Schedule{Calendar: daily}, "2020-06-08 03:00", "2020-06-10 14:30", "2020-06-11 03:00"},
+		{Schedule{Calendar: daily, CatchUp: true}, "2020-06-08 03:00", "2020-06-10 14:30", "2020-06-10 14:30"},
+		// caught up snapshots respect blackouts
+		{Schedule{Calendar: daily, CatchUp: true, Blackouts: []BlackoutWindow{business}}, "2020-06-08 03:00", "2020-06-10 14:30", "2020-06-10 18:00"},
+		// postponed past business hours, but not on the weekend
+		{Schedule{Calendar: hourly, Blackouts: []BlackoutWindow{business}}, "2020-06-10 07:30", "2020-06-10 07:45", "2020-06-10 18:00"},
+		{Schedule{Calendar: hourly, Blackouts: []BlackoutWindow{business}}, "2020-06-13 09:30", "2020-06-13 09:45", "2020-06-13 10:00"},
+		// windows lasting until the next day, following each other
+		{Schedule{Calendar: hourly, Blackouts: []BlackoutWindow{business, morning, night}}, "2020-06-10 21:30", "2020-06-10 21:45", "2020-06-11 18:00"},
+		{Schedule{Calendar: hourly, Blackouts: []BlackoutWindow{night}}, "2020-06-11 01:30", "2020-06-11 01:45", "2020-06-11 06:00"},
+		// never
+		{Schedule{}, "2020-06-10 14:30", "2020-06-10 14:30", ""},
+	}
+
+	for i, tt := range tests {
+		var last time.Time
+		if tt.last != "" {
+			last = date(tt.last)
+		}
+		var exp time.Time
+		if tt.exp != "" {
+			exp = date(tt.exp)
+		}
+
+		if next := tt.schedule.Next(last, date(tt.now)); !next.Equal(exp) {
+			t.Errorf("Test %d: expected next snapshot at %s, got %s", i, exp, next)
+		}
+	}
+}
+
+func TestScheduleBlackedOut(t *testing.T) {
+	hourly, _ := CalendarFromName("hourly")
+	always, _ := ParseBlackoutWindow("00:00-24:00")
+
+	s := Schedule{Calendar: hourly, Blackouts: []BlackoutWindow{always}}
+	if next := s.Next(date("2020-06-10 14:30"), date("2020-06-10 14:30")); !next.IsZero() {
+		t.Errorf("Expected no snapshot to be scheduled, got %s", next)
+	}
+}
+
+func TestScheduleJitter(t *testing.T) {
+	hourly, _ := CalendarFromName("hourly")
+	s := Schedule{Calendar: hourly, Jitter: 10 * time.Minute}
+
+	for i := 0; i < 100; i++ {
+		next := s.Next(date("2020-06-10 14:30"), date("2020-06-10 14:30"))
+		if next.Before(date("2020-06-10 15:00")) || !next.Before(date("2020-06-10 15:10")) {
+			t.Fatalf("Expected next snapshot within jitter, got %s", next)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recorder remembers the status code and size of a response.
+type recorder struct {
+	http.ResponseWriter
+	code int
+	sent int64
+}
+
+func (rec *recorder) WriteHeader(code int) {
+	rec.code = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *recorder) Write(b []byte) (int, error) {
+	n, err := rec.ResponseWriter.Write(b)
+	rec.sent += int64(n)
+	return n, err
+}
+
+// record adds a served request to the server's metrics.
+func (s *Server) record(r *http.Request, rec *recorder, d time.Duration) {
+	code := strconv.Itoa(rec.code)
+	s.Metrics.Add("knoxite_server_requests_total", "Requests served, by method and status code.", 1,
+		"method", r.Method, "code", code)
+	s.Metrics.Add("knoxite_server_request_duration_seconds_total", "Time spent serving requests.", d.Seconds())
+	s.Metrics.Add("knoxite_server_sent_bytes_total", "Bytes sent to clients.", float64(rec.sent))
+	if r.ContentLength > 0 {
+		s.Metrics.Add("knoxite_server_received_bytes_total", "Bytes received from clients.", float64(r.ContentLength))
+	}
+
+	switch {
+	case rec.code >= 500:
+		s.Metrics.Add("knoxite_server_errors_total", "Requests failed due to server errors.", 1)
+	case rec.code < 300 && r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/chunks/"):
+		s.Metrics.Add("knoxite_server_chunks_stored_total", "Chunks stored by clients.", 1)
+	}
+}
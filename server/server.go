@@ -0,0 +1,519 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+// Package server exposes repositories through a REST API, which knoxite
+// clients access with the knoxite:// and knoxites:// storage backends. Clients
+// never get direct access to the storage the server uses.
+//
+// All requests need to be authenticated with HTTP basic auth. Each user gets a
+// repository of their own:
+//
+//	POST   /init                create the repository
+//	GET    /repository          load the repository, PUT stores it
+//	GET    /hint                load the password hint, PUT stores it
+//	GET    /index               load the chunk-index, PUT stores it
+//	GET    /space               available space in bytes
+//	GET    /chunks/             list all chunks
+//	GET    /chunks/<name>       load a chunk, HEAD checks whether it exists
+//	PUT    /chunks/<name>       store a chunk, DELETE deletes it
+//	GET    /snapshots/          list all snapshots
+//	GET    /snapshots/<id>      load a snapshot, PUT stores it
+//	GET    /locks/              list all locks
+//	GET    /locks/<id>          load a lock, PUT stores it, DELETE deletes it
+//	POST   /references/<id>     add the chunks a snapshot references
+//	DELETE /references/<id>     drop all chunk references of a snapshot
+//	POST   /pack                delete all unreferenced chunks
+//
+// GET requests for the chunk-index and snapshots may ask for a range of them
+// with a Range header, to resume an interrupted download.
+//
+// The server maintains the chunk-index of the repositories itself: clients
+// don't need to load it, they ask whether a chunk is stored already with a
+// HEAD request, only push the missing ones and tell the server which chunks
+// their snapshots reference.
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/metrics"
+)
+
+// Error declarations.
+var (
+	ErrForbidden   = errors.New("The server is append-only")
+	ErrInvalidName = errors.New("Invalid name")
+
+	errMethodNotAllowed = errors.New("Method not allowed")
+	errLengthRequired   = errors.New("Content-Length required")
+	errBadRequest       = errors.New("Malformed request body")
+)
+
+// Server serves the repositories of its users, which get stored in
+// sub-directories of Root.
+type Server struct {
+	Root  string
+	Users Users
+	// AppendOnly rejects all requests removing or overwriting chunks and
+	// snapshots, so compromised clients can't destroy existing backups. All
+	// previous versions of the repository file, the password hint and the
+	// chunk-index are kept
+	AppendOnly bool
+	// Log receives a line for each request, if it's set
+	Log func(format string, v ...interface{})
+	// Metrics collects statistics about the served requests, if it's set
+	Metrics *metrics.Registry
+
+	// authenticated caches the credentials which passed the expensive
+	// bcrypt check
+	authenticated sync.Map
+	// referencesMut protects the chunk references of all snapshots
+	referencesMut sync.Mutex
+}
+
+// ServeHTTP authenticates and answers a request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Metrics == nil {
+		s.serve(w, r)
+		return
+	}
+
+	start := time.Now()
+	rec := &recorder{ResponseWriter: w, code: http.StatusOK}
+	s.serve(rec, r)
+	s.record(r, rec, time.Since(start))
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	user, password, ok := r.BasicAuth()
+	if !ok || !s.authenticate(user, password) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="knoxite"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		s.logf("%s %s: authentication failed for user %q", r.Method, r.URL.Path, user)
+		return
+	}
+
+	kind, name := r.URL.Path, ""
+	kind = strings.TrimPrefix(kind, "/")
+	if i := strings.Index(kind, "/"); i >= 0 {
+		kind, name = kind[:i], kind[i+1:]
+	}
+	if name != "" && !validName(name) {
+		http.Error(w, ErrInvalidName.Error(), http.StatusBadRequest)
+		return
+	}
+
+	backend := s.backend(user)
+	var err error
+	switch kind {
+	case "init":
+		err = expectMethod(r, http.MethodPost)
+		if err == nil {
+			err = backend.InitRepository()
+		}
+	case "repository":
+		err = s.serveMetadata(w, r, filepath.Join(backend.Path, knoxite.RepoFilename),
+			backend.LoadRepository, backend.SaveRepository)
+	case "hint":
+		err = s.serveMetadata(w, r, backend.PasswordHintFileName(),
+			backend.LoadPasswordHint, backend.SavePasswordHint)
+	case "index":
+		err = s.serveMetadata(w, r, backend.ChunkIndexFileName(),
+			backend.LoadChunkIndex, backend.SaveChunkIndex)
+	case "space":
+		err = expectMethod(r, http.MethodGet)
+		if err == nil {
+			err = serveSpace(w, backend)
+		}
+	case "chunks":
+		if name == "" {
+			err = serveList(w, r, func() (interface{}, error) { return backend.ListChunks() })
+		} else {
+			err = s.serveChunk(w, r, backend, name)
+		}
+	case "snapshots":
+		if name == "" {
+			err = serveList(w, r, func() (interface{}, error) { return backend.ListSnapshots() })
+		} else {
+			err = s.serveSnapshot(w, r, backend, name)
+		}
+	case "locks":
+		if name == "" {
+			err = serveList(w, r, func() (interface{}, error) { return backend.ListLocks() })
+		} else {
+			err = serveLock(w, r, backend, name)
+		}
+	case "references":
+		if name == "" {
+			err = ErrInvalidName
+		} else {
+			err = s.serveReferences(r, backend, name)
+		}
+	case "pack":
+		err = expectMethod(r, http.MethodPost)
+		if err == nil {
+			err = s.servePack(w, r, backend)
+		}
+	default:
+		err = os.ErrNotExist
+	}
+
+	if err != nil {
+		code := statusCode(err)
+		http.Error(w, err.Error(), code)
+		s.logf("%s %s (%s): %d %v", r.Method, r.URL.Path, user, code, err)
+		return
+	}
+	s.logf("%s %s (%s)", r.Method, r.URL.Path, user)
+}
+
+func (s *Server) authenticate(user, password string) bool {
+	key := sha256.Sum256([]byte(user + ":" + password))
+	if _, ok := s.authenticated.Load(key); ok {
+		return true
+	}
+	if !s.Users.Authenticate(user, password) {
+		return false
+	}
+	s.authenticated.Store(key, true)
+	return true
+}
+
+func (s *Server) logf(format string, v ...interface{}) {
+	if s.Log != nil {
+		s.Log(format, v...)
+	}
+}
+
+// backend returns the storage of a user's repository.
+func (s *Server) backend(user string) *knoxite.StorageLocal {
+	backend := &knoxite.StorageLocal{}
+	backend.StorageFilesystem, _ = knoxite.NewStorageFilesystem(filepath.Join(s.Root, user), backend)
+	return backend
+}
+
+// serveMetadata serves the repository file, the password hint or the
+// chunk-index, which get stored in the file name. In append-only mode
+// replacing them keeps their previous versions.
+func (s *Server) serveMetadata(w http.ResponseWriter, r *http.Request, name string, load func() ([]byte, error), save func([]byte) error) error {
+	if r.Method == http.MethodPut && s.AppendOnly {
+		return saveVersion(r, name)
+	}
+	return serveFile(w, r, load, save)
+}
+
+// saveVersion replaces a file with the request body, but keeps all previous
+// versions of it, so an admin can roll back changes made by a compromised
+// client. The new version only replaces the current one once it has been
+// written completely, so the file never goes missing.
+func saveVersion(r *http.Request, name string) error {
+	tmp := name + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	err = os.Link(name, fmt.Sprintf("%s.%d", name, time.Now().UnixNano()))
+	if err != nil && !os.IsNotExist(err) {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+func (s *Server) serveChunk(w http.ResponseWriter, r *http.Request, backend *knoxite.StorageLocal, name string) error {
+	part, err := knoxite.ParseChunkPartName(name)
+	if err != nil {
+		return ErrInvalidName
+	}
+	fileName := backend.ChunkFileName(part.Hash, part.Part, part.TotalParts)
+
+	switch r.Method {
+	case http.MethodHead:
+		size, err := backend.Stat(fileName)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Length", strconv.FormatUint(size, 10))
+
+	case http.MethodGet:
+		rc, err := backend.LoadChunk(r.Context(), part.Hash, part.Part, part.TotalParts)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		_, _ = io.Copy(w, rc)
+
+	case http.MethodPut:
+		if r.ContentLength < 0 {
+			return errLengthRequired
+		}
+		size, err := backend.Stat(fileName)
+		if err == nil && size == uint64(r.ContentLength) {
+			// chunks are addressed by their hash, the stored one is kept
+			return nil
+		}
+		if err == nil && s.AppendOnly {
+			return ErrForbidden
+		}
+
+		_, err = backend.StoreChunk(r.Context(), part.Hash, part.Part, part.TotalParts, r.Body, uint64(r.ContentLength))
+		if err != nil {
+			// don't keep incomplete chunks around
+			_ = backend.DeleteFile(fileName)
+			return err
+		}
+
+	case http.MethodDelete:
+		if s.AppendOnly {
+			return ErrForbidden
+		}
+		return backend.DeleteChunk(r.Context(), part.Hash, part.Part, part.TotalParts)
+
+	default:
+		return errMethodNotAllowed
+	}
+	return nil
+}
+
+func (s *Server) serveSnapshot(w http.ResponseWriter, r *http.Request, backend *knoxite.StorageLocal, id string) error {
+	if r.Method == http.MethodPut && s.AppendOnly {
+		if _, err := backend.LoadSnapshot(id); err == nil {
+			return ErrForbidden
+		}
+	}
+	return serveFile(w, r,
+		func() ([]byte, error) { return backend.LoadSnapshot(id) },
+		func(b []byte) error { return backend.SaveSnapshot(id, b) })
+}
+
+// referencesPath returns the path the chunks referenced by a snapshot get
+// stored at.
+func referencesPath(backend *knoxite.StorageLocal, snapshot string) string {
+	return filepath.Join(backend.Path, "references", snapshot)
+}
+
+// loadReferences returns the chunks referenced by a snapshot.
+func loadReferences(backend *knoxite.StorageLocal, snapshot string) ([]knoxite.ChunkIndexItem, error) {
+	var chunks []knoxite.ChunkIndexItem
+	b, err := ioutil.ReadFile(referencesPath(backend, snapshot))
+	if os.IsNotExist(err) {
+		return chunks, nil
+	}
+	if err != nil {
+		return chunks, err
+	}
+
+	err = json.Unmarshal(b, &chunks)
+	return chunks, err
+}
+
+// serveReferences records the chunks referenced by a snapshot on POST, and
+// drops all of them on DELETE requests.
+func (s *Server) serveReferences(r *http.Request, backend *knoxite.StorageLocal, snapshot string) error {
+	s.referencesMut.Lock()
+	defer s.referencesMut.Unlock()
+
+	switch r.Method {
+	case http.MethodPost:
+		var added []knoxite.ChunkIndexItem
+		if err := json.NewDecoder(r.Body).Decode(&added); err != nil {
+			return errBadRequest
+		}
+		chunks, err := loadReferences(backend, snapshot)
+		if err != nil {
+			return err
+		}
+
+		known := make(map[string]bool)
+		for _, chunk := range chunks {
+			known[chunk.Hash] = true
+		}
+		for _, chunk := range added {
+			if !known[chunk.Hash] {
+				known[chunk.Hash] = true
+				chunks = append(chunks, chunk)
+			}
+		}
+
+		b, err := json.Marshal(chunks)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(referencesPath(backend, snapshot)), 0700); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(referencesPath(backend, snapshot), b, 0600)
+
+	case http.MethodDelete:
+		if s.AppendOnly {
+			return ErrForbidden
+		}
+		err := os.Remove(referencesPath(backend, snapshot))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return errMethodNotAllowed
+}
+
+// servePack deletes all chunks no longer referenced by any snapshot, and
+// responds with the freed storage space.
+func (s *Server) servePack(w http.ResponseWriter, r *http.Request, backend *knoxite.StorageLocal) error {
+	if s.AppendOnly {
+		return ErrForbidden
+	}
+	s.referencesMut.Lock()
+	defer s.referencesMut.Unlock()
+
+	referenced := make(map[string]bool)
+	snapshots, err := ioutil.ReadDir(filepath.Join(backend.Path, "references"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, fi := range snapshots {
+		chunks, err := loadReferences(backend, fi.Name())
+		if err != nil {
+			return err
+		}
+		for _, chunk := range chunks {
+			referenced[chunk.Hash] = true
+		}
+	}
+
+	parts, err := backend.ListChunks()
+	if err != nil {
+		return err
+	}
+	var freed uint64
+	for _, part := range parts {
+		if referenced[part.Hash] {
+			continue
+		}
+		size, _ := backend.Stat(backend.ChunkFileName(part.Hash, part.Part, part.TotalParts))
+		if err := backend.DeleteChunk(r.Context(), part.Hash, part.Part, part.TotalParts); err != nil {
+			return err
+		}
+		s.logf("Deleted unreferenced chunk %s", part)
+		freed += size
+	}
+
+	_, err = fmt.Fprint(w, freed)
+	return err
+}
+
+// serveLock serves locks. Clients need to be able to delete them, even on
+// append-only servers.
+func serveLock(w http.ResponseWriter, r *http.Request, backend *knoxite.StorageLocal, id string) error {
+	if r.Method == http.MethodDelete {
+		return backend.DeleteLock(id)
+	}
+	return serveFile(w, r,
+		func() ([]byte, error) { return backend.LoadLock(id) },
+		func(b []byte) error { return backend.SaveLock(id, b) })
+}
+
+// serveFile loads a file on GET and stores the request body on PUT requests.
+// GET requests may ask for a range of the file, to resume a download.
+func serveFile(w http.ResponseWriter, r *http.Request, load func() ([]byte, error), save func([]byte) error) error {
+	switch r.Method {
+	case http.MethodGet:
+		b, err := load()
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(b))
+		return nil
+
+	case http.MethodPut:
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		return save(b)
+	}
+	return errMethodNotAllowed
+}
+
+// serveList encodes the names of stored objects as JSON.
+func serveList(w http.ResponseWriter, r *http.Request, list func() (interface{}, error)) error {
+	if err := expectMethod(r, http.MethodGet); err != nil {
+		return err
+	}
+	v, err := list()
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(v)
+}
+
+func serveSpace(w http.ResponseWriter, backend *knoxite.StorageLocal) error {
+	space, err := backend.AvailableSpace()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(w, space)
+	return err
+}
+
+// validName checks the name of a chunk, snapshot or lock, so it can't escape
+// the directories they're stored in.
+func validName(name string) bool {
+	return name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+func expectMethod(r *http.Request, method string) error {
+	if r.Method != method {
+		return errMethodNotAllowed
+	}
+	return nil
+}
+
+// statusCode returns the HTTP status code for an error.
+func statusCode(err error) int {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	case err == knoxite.ErrRepositoryExists:
+		return http.StatusConflict
+	case err == ErrForbidden:
+		return http.StatusForbidden
+	case err == ErrInvalidName || err == errBadRequest:
+		return http.StatusBadRequest
+	case err == errMethodNotAllowed:
+		return http.StatusMethodNotAllowed
+	case err == errLengthRequired:
+		return http.StatusLengthRequired
+	}
+	return http.StatusInternalServerError
+}
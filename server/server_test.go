@@ -0,0 +1,344 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/metrics"
+	"github.com/knoxite/knoxite/storage/rest"
+)
+
+func testServer(t *testing.T, appendOnly bool) (*httptest.Server, string) {
+	dir, err := ioutil.TempDir("", "knoxite.server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := make(Users)
+	if err := users.SetPassword("alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.SetPassword("../bob", "secret"); err != ErrInvalidUser {
+		t.Errorf("Expected %v, got %v", ErrInvalidUser, err)
+	}
+
+	ts := httptest.NewServer(&Server{Root: dir, Users: users, AppendOnly: appendOnly})
+	return ts, dir
+}
+
+func testBackend(t *testing.T, ts *httptest.Server, password string) knoxite.Backend {
+	u, _ := url.Parse(ts.URL)
+	u.Scheme = "knoxite"
+	u.User = url.UserPassword("alice", password)
+
+	backend, err := (&rest.RESTStorage{}).NewBackend(*u)
+	if err != nil {
+		t.Fatalf("Failed creating backend: %s", err)
+	}
+	return backend
+}
+
+func TestServer(t *testing.T) {
+	ts, dir := testServer(t, false)
+	defer os.RemoveAll(dir)
+	defer ts.Close()
+
+	if err := testBackend(t, ts, "wrong").InitRepository(); !errors.Is(err, rest.ErrUnauthorized) {
+		t.Errorf("Expected %v, got %v", rest.ErrUnauthorized, err)
+	}
+
+	backend := testBackend(t, ts, "secret")
+	if err := backend.InitRepository(); err != nil {
+		t.Fatalf("Failed initializing repository: %s", err)
+	}
+	if err := backend.InitRepository(); err != knoxite.ErrRepositoryExists {
+		t.Errorf("Expected %v, got %v", knoxite.ErrRepositoryExists, err)
+	}
+	if err := backend.SaveRepository([]byte("repository")); err != nil {
+		t.Fatalf("Failed saving repository: %s", err)
+	}
+	if b, err := backend.LoadRepository(); err != nil || string(b) != "repository" {
+		t.Errorf("Expected to load the repository, got %q: %v", b, err)
+	}
+	if _, err := backend.LoadSnapshot("missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected %v, got %v", os.ErrNotExist, err)
+	}
+
+	ctx := context.Background()
+	if err := backend.SaveChunkIndex([]byte("chunk-index")); err != nil {
+		t.Fatalf("Failed saving chunk-index: %s", err)
+	}
+	rc, size, err := backend.(knoxite.RangeLoader).LoadChunkIndexRange(ctx, 6)
+	if err != nil {
+		t.Fatalf("Failed loading chunk-index range: %s", err)
+	}
+	b, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if string(b) != "index" || size != 11 {
+		t.Errorf("Expected %q of 11 bytes, got %q of %d", "index", b, size)
+	}
+
+	data := []byte("chunk data")
+	if _, err := backend.StoreChunk(ctx, "abcdef", 0, 1, bytes.NewReader(data), uint64(len(data))); err != nil {
+		t.Fatalf("Failed storing chunk: %s", err)
+	}
+	rc, err = backend.LoadChunk(ctx, "abcdef", 0, 1)
+	if err != nil {
+		t.Fatalf("Failed loading chunk: %s", err)
+	}
+	b, _ = ioutil.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(b, data) {
+		t.Errorf("Expected chunk %q, got %q", data, b)
+	}
+
+	lister := backend.(knoxite.BackendLister)
+	parts, err := lister.ListChunks()
+	if err != nil || len(parts) != 1 || parts[0].Hash != "abcdef" {
+		t.Errorf("Expected a single chunk, got %v: %v", parts, err)
+	}
+	if err := backend.DeleteChunk(ctx, "abcdef", 0, 1); err != nil {
+		t.Errorf("Failed deleting chunk: %s", err)
+	}
+
+	locker := backend.(knoxite.LockingBackend)
+	if err := locker.SaveLock("lock", []byte("lock")); err != nil {
+		t.Fatalf("Failed saving lock: %s", err)
+	}
+	if ids, err := locker.ListLocks(); err != nil || len(ids) != 1 {
+		t.Errorf("Expected a single lock, got %v: %v", ids, err)
+	}
+	if err := locker.DeleteLock("lock"); err != nil {
+		t.Errorf("Failed deleting lock: %s", err)
+	}
+
+	// names can't escape the repository
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/snapshots/..", nil)
+	req.SetBasicAuth("alice", "secret")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected path tampering to be rejected, got status %d", res.StatusCode)
+	}
+}
+
+func TestServerAppendOnly(t *testing.T) {
+	ts, dir := testServer(t, true)
+	defer os.RemoveAll(dir)
+	defer ts.Close()
+
+	backend := testBackend(t, ts, "secret")
+	if err := backend.InitRepository(); err != nil {
+		t.Fatalf("Failed initializing repository: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := backend.SaveRepository([]byte("repository")); err != nil {
+			t.Fatalf("Failed saving repository: %s", err)
+		}
+	}
+	versions := func(name string) int {
+		files, _ := ioutil.ReadDir(filepath.Dir(name))
+		var n int
+		for _, fi := range files {
+			if strings.HasPrefix(fi.Name(), filepath.Base(name)) {
+				n++
+			}
+		}
+		return n
+	}
+	if n := versions(filepath.Join(dir, "alice", knoxite.RepoFilename)); n != 2 {
+		t.Errorf("Expected the previous version of the repository to be kept, found %d versions", n)
+	}
+
+	// an aborted upload neither replaces nor removes the repository
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/repository", &failingReader{})
+	req.ContentLength = 100
+	req.SetBasicAuth("alice", "secret")
+	if res, err := http.DefaultClient.Do(req); err == nil {
+		res.Body.Close()
+	}
+	if b, err := backend.LoadRepository(); err != nil || string(b) != "repository" {
+		t.Errorf("Expected the repository to be kept, got %q: %v", b, err)
+	}
+
+	if err := backend.SaveSnapshot("snapshot", []byte("snapshot")); err != nil {
+		t.Fatalf("Failed saving snapshot: %s", err)
+	}
+	if err := backend.SaveSnapshot("snapshot", []byte("overwritten")); !errors.Is(err, rest.ErrAppendOnly) {
+		t.Errorf("Expected %v, got %v", rest.ErrAppendOnly, err)
+	}
+
+	// replacing the password hint or the chunk-index keeps their previous
+	// versions as well
+	for i := 0; i < 2; i++ {
+		if err := backend.SavePasswordHint([]byte("hint")); err != nil {
+			t.Fatalf("Failed saving password hint: %s", err)
+		}
+	}
+	if n := versions(filepath.Join(dir, "alice", knoxite.PasswordHintFilename)); n != 2 {
+		t.Errorf("Expected the previous version of the password hint to be kept, found %d versions", n)
+	}
+	if b, err := backend.LoadPasswordHint(); err != nil || string(b) != "hint" {
+		t.Errorf("Expected the password hint, got %q: %v", b, err)
+	}
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := backend.SaveChunkIndex([]byte("chunk-index")); err != nil {
+			t.Fatalf("Failed saving chunk-index: %s", err)
+		}
+	}
+	if n := versions(filepath.Join(dir, "alice", "chunks", knoxite.ChunkIndexFilename)); n != 2 {
+		t.Errorf("Expected the previous version of the chunk-index to be kept, found %d versions", n)
+	}
+	rc, size, err := backend.(knoxite.RangeLoader).LoadChunkIndexRange(ctx, 6)
+	if err != nil {
+		t.Fatalf("Failed loading chunk-index range: %s", err)
+	}
+	b, _ := ioutil.ReadAll(rc)
+	rc.Close()
+	if string(b) != "index" || size != 11 {
+		t.Errorf("Expected %q of 11 bytes, got %q of %d", "index", b, size)
+	}
+
+	data := []byte("chunk data")
+	for i := 0; i < 2; i++ {
+		if _, err := backend.StoreChunk(ctx, "abcdef", 0, 1, bytes.NewReader(data), uint64(len(data))); err != nil {
+			t.Fatalf("Failed storing chunk: %s", err)
+		}
+	}
+	if err := backend.DeleteChunk(ctx, "abcdef", 0, 1); !errors.Is(err, rest.ErrAppendOnly) {
+		t.Errorf("Expected %v, got %v", rest.ErrAppendOnly, err)
+	}
+}
+
+func TestServerReferences(t *testing.T) {
+	ts, dir := testServer(t, false)
+	defer os.RemoveAll(dir)
+	defer ts.Close()
+
+	backend := testBackend(t, ts, "secret")
+	if err := backend.InitRepository(); err != nil {
+		t.Fatalf("Failed initializing repository: %s", err)
+	}
+	ib, ok := backend.(knoxite.IndexingBackend)
+	if !ok {
+		t.Fatal("Expected the server to maintain the chunk-index")
+	}
+
+	ctx := context.Background()
+	data := []byte("chunk data")
+	for _, hash := range []string{"abcdef", "fedcba"} {
+		if _, err := backend.StoreChunk(ctx, hash, 0, 1, bytes.NewReader(data), uint64(len(data))); err != nil {
+			t.Fatalf("Failed storing chunk: %s", err)
+		}
+	}
+	if ok, err := ib.HasChunk(ctx, "abcdef", 0, 1); err != nil || !ok {
+		t.Errorf("Expected the chunk to be stored, got %v: %v", ok, err)
+	}
+	if ok, err := ib.HasChunk(ctx, "missing", 0, 1); err != nil || ok {
+		t.Errorf("Expected the chunk to be missing, got %v: %v", ok, err)
+	}
+
+	if err := ib.AddChunkReferences("s1", []knoxite.ChunkIndexItem{{Hash: "abcdef"}}); err != nil {
+		t.Fatalf("Failed adding chunk references: %s", err)
+	}
+	if err := ib.AddChunkReferences("s2", []knoxite.ChunkIndexItem{{Hash: "fedcba"}}); err != nil {
+		t.Fatalf("Failed adding chunk references: %s", err)
+	}
+	if err := ib.RemoveChunkReferences("s2"); err != nil {
+		t.Fatalf("Failed removing chunk references: %s", err)
+	}
+	freed, err := ib.PackChunks(ctx)
+	if err != nil {
+		t.Fatalf("Failed packing chunks: %s", err)
+	}
+	if freed != uint64(len(data)) {
+		t.Errorf("Expected %d bytes to be freed, got %d", len(data), freed)
+	}
+	if ok, _ := ib.HasChunk(ctx, "abcdef", 0, 1); !ok {
+		t.Error("Expected the referenced chunk to be kept")
+	}
+	if ok, _ := ib.HasChunk(ctx, "fedcba", 0, 1); ok {
+		t.Error("Expected the unreferenced chunk to be deleted")
+	}
+
+	// append-only servers keep all chunks and references
+	ts2 := httptest.NewServer(&Server{Root: dir, Users: (ts.Config.Handler.(*Server)).Users, AppendOnly: true})
+	defer ts2.Close()
+	ib = testBackend(t, ts2, "secret").(knoxite.IndexingBackend)
+	if err := ib.RemoveChunkReferences("s1"); !errors.Is(err, rest.ErrAppendOnly) {
+		t.Errorf("Expected %v, got %v", rest.ErrAppendOnly, err)
+	}
+	if _, err := ib.PackChunks(ctx); !errors.Is(err, rest.ErrAppendOnly) {
+		t.Errorf("Expected %v, got %v", rest.ErrAppendOnly, err)
+	}
+}
+
+// failingReader fails like a connection, which broke during an upload.
+type failingReader struct {
+	sent bool
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if !r.sent {
+		r.sent = true
+		return copy(p, "partial"), nil
+	}
+	return 0, errors.New("connection reset")
+}
+
+func TestServerMetrics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite.server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	users := make(Users)
+	_ = users.SetPassword("alice", "secret")
+
+	reg := metrics.NewRegistry()
+	ts := httptest.NewServer(&Server{Root: dir, Users: users, Metrics: reg})
+	defer ts.Close()
+
+	backend := testBackend(t, ts, "secret")
+	if err := backend.InitRepository(); err != nil {
+		t.Fatalf("Failed initializing repository: %s", err)
+	}
+	data := []byte("chunk data")
+	if _, err := backend.StoreChunk(context.Background(), "abcdef", 0, 1, bytes.NewReader(data), uint64(len(data))); err != nil {
+		t.Fatalf("Failed storing chunk: %s", err)
+	}
+
+	var buf bytes.Buffer
+	_, _ = reg.WriteTo(&buf)
+	for _, line := range []string{
+		`knoxite_server_requests_total{method="POST",code="200"} 1`,
+		`knoxite_server_requests_total{method="PUT",code="200"} 1`,
+		`knoxite_server_chunks_stored_total 1`,
+		`knoxite_server_received_bytes_total 10`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", line, buf.String())
+		}
+	}
+}
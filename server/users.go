@@ -0,0 +1,100 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Error declarations.
+var (
+	ErrInvalidUser  = errors.New("Invalid user name, only letters, digits, '.', '_' and '-' are allowed")
+	ErrInvalidUsers = errors.New("Invalid users file, expected lines of user:bcrypt-hash")
+)
+
+var userRegexp = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// Users maps user names to the bcrypt hashes of their passwords.
+type Users map[string]string
+
+// LoadUsers reads a users file. Each line holds a user name and the bcrypt
+// hash of its password, separated by a colon, like files created by
+// 'htpasswd -B'.
+func LoadUsers(path string) (Users, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(Users)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, ":", 2)
+		if len(fields) != 2 || !userRegexp.MatchString(fields[0]) {
+			return nil, ErrInvalidUsers
+		}
+		users[fields[0]] = fields[1]
+	}
+	return users, scanner.Err()
+}
+
+// Save writes the users file.
+func (users Users) Save(path string) error {
+	names := make([]string, 0, len(users))
+	for name := range users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := fmt.Fprintf(f, "%s:%s\n", name, users[name]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
+
+// SetPassword adds a user or changes its password.
+func (users Users) SetPassword(user, password string) error {
+	if !userRegexp.MatchString(user) {
+		return ErrInvalidUser
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	users[user] = string(hash)
+	return nil
+}
+
+// Authenticate checks a user's password.
+func (users Users) Authenticate(user, password string) bool {
+	hash, ok := users[user]
+	if !ok {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
@@ -8,9 +8,13 @@
 package knoxite
 
 import (
+	"context"
+	"fmt"
 	"math"
 	"os"
+	"os/user"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -25,22 +29,123 @@ type Snapshot struct {
 	ID          string              `json:"id"`
 	Date        time.Time           `json:"date"`
 	Description string              `json:"description"`
+	Tags        []string            `json:"tags,omitempty"`
+	Hostname    string              `json:"hostname,omitempty"`
+	Username    string              `json:"username,omitempty"`
 	Stats       Stats               `json:"stats"`
 	Archives    map[string]*Archive `json:"items"`
+
+	// Paths are the stored targets, absolute unless they've been read from
+	// another source than the local file system. CommandLine is the command
+	// line of the program that stored the snapshot, if it recorded it
+	Paths       []string `json:"paths,omitempty"`
+	CommandLine []string `json:"command_line,omitempty"`
+
+	// Annotations are key/value pairs attached to the snapshot, e.g. by
+	// external tools after it has been stored
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// Parent is the snapshot a partial snapshot inherits all items from,
+	// which aren't located in one of its Subtrees
+	Parent   string   `json:"parent,omitempty"`
+	Subtrees []string `json:"subtrees,omitempty"`
+
+	// Chunker holds the settings the files of the snapshot have been divided
+	// into chunks with, unless these are the DefaultChunkerSettings
+	Chunker *ChunkerSettings `json:"chunker,omitempty"`
+
+	// DedupDomain is the deduplication domain of the volume the snapshot has
+	// been stored in, its chunks are only shared within the domain
+	DedupDomain string `json:"dedup_domain,omitempty"`
+
+	// Baseline is the snapshot the items of this one have been compared with
+	// to detect changes. Unlike a Parent, it doesn't pass on any items
+	Baseline string `json:"baseline,omitempty"`
+
+	// key is the data key the snapshot's metadata has been encrypted with
+	key string
+	// volumeKey is the key of the protected volume the snapshot is stored in
+	volumeKey string
 }
 
 // StoreOptions holds all the storage settings for a snapshot operation.
 type StoreOptions struct {
-	CWD         string
-	Paths       []string
-	Excludes    []string
-	Compress    uint16
-	Encrypt     uint16
-	Pedantic    bool
-	DataParts   uint
-	ParityParts uint
+	CWD      string
+	Paths    []string
+	Excludes []string
+	// ExcludeFiles are files with gitignore-style exclude patterns, relative
+	// to the stored paths
+	ExcludeFiles []string
+	// ExcludeMarkers skips directories containing one of these files
+	ExcludeMarkers []string
+	// ExcludeCaches skips directories containing a valid CACHEDIR.TAG file
+	ExcludeCaches bool
+	// OneFileSystem doesn't descend into directories on other file systems
+	// than the stored path, e.g. /proc or mounted network shares
+	OneFileSystem bool
+	Compress      uint16
+	Encrypt       uint16
+	Pedantic      bool
+	DataParts     uint
+	ParityParts   uint
+	// Chunker defines how files get divided into chunks. Its zero value
+	// uses the DefaultChunkerSettings
+	Chunker ChunkerSettings
+	// Concurrency is the amount of chunks being processed in parallel
+	Concurrency uint
+	// DiskConcurrency is the amount of files read in parallel from each
+	// rotational disk. Its zero value uses the DefaultDiskConcurrency
+	DiskConcurrency uint
+	// Limits protect against pathological directory trees
+	Limits ScanLimits
+	// Source provides the stored data, the local file system if it's nil
+	Source Source
+	// AlternateStreams stores the NTFS alternate data streams of items on
+	// Windows
+	AlternateStreams bool
+	// FollowSymlinks stores the items symlinks point to instead of the
+	// links themselves
+	FollowSymlinks bool
+	// Timings collects the time spent in the stages of storing, unless it's
+	// nil
+	Timings *Timings
+	// ProgressInterval is the interval in which progress updates get
+	// coalesced. Its zero value uses the DefaultProgressInterval, a negative
+	// value reports every single chunk
+	ProgressInterval time.Duration
+
+	// dedupDomain is the deduplication domain the chunks get stored in
+	dedupDomain string
+	// chunkHeaders prefixes every stored chunk part with a ChunkHeader
+	chunkHeaders bool
+	// DryRun scans and chunks the items without storing any data. Chunks,
+	// which aren't found in the chunk-index, count towards the StorageSize
+	// of the snapshot, as if they had been stored
+	DryRun bool
+	// Limiter limits the rate chunks get uploaded with, unless it's nil
+	Limiter *RateLimiter
+	// ChangeCache reuses the chunks of local files, which haven't changed
+	// since they got stored before, unless it's nil
+	ChangeCache *ChangeCache
+	// StripPrefix gets removed from the paths of the stored items, e.g. to
+	// record the paths of a file system snapshot mounted at StripPrefix as
+	// the paths they belong to
+	StripPrefix string
+	// Baseline is a previous snapshot of the same volume. The chunks of its
+	// files get reused for the files which haven't changed since, unless it's
+	// nil
+	Baseline *Snapshot
+	// Processors inspect every file before it gets stored, e.g. to scan it
+	// for malware. The Source needs to be able to open files more than once
+	Processors []Processor
+	// ExcludeFlagged doesn't store files flagged by one of the Processors
+	ExcludeFlagged bool
 }
 
+// DefaultConcurrency is the amount of chunks processed in parallel, unless
+// specified otherwise in StoreOptions.
+const DefaultConcurrency = 4
+
 // NewSnapshot creates a new snapshot.
 func NewSnapshot(description string) (*Snapshot, error) {
 	snapshot := Snapshot{
@@ -54,18 +159,45 @@ func NewSnapshot(description string) (*Snapshot, error) {
 		return &snapshot, err
 	}
 	snapshot.ID = u.String()[:8]
+	snapshot.Hostname, _ = os.Hostname()
+	if usr, err := user.Current(); err == nil {
+		snapshot.Username = usr.Username
+	}
 
 	return &snapshot, nil
 }
 
-func (snapshot *Snapshot) gatherTargetInformation(cwd string, paths []string, excludes []string) <-chan ArchiveResult {
+// setPaths records the targets of a store operation.
+func (snapshot *Snapshot) setPaths(opts StoreOptions) {
+	snapshot.Paths = opts.snapshotPaths()
+}
+
+// snapshotPaths returns the paths a snapshot of the targets records. Local
+// paths get stored as absolute paths.
+func (opts StoreOptions) snapshotPaths() []string {
+	_, local := opts.Source.(*SourceLocal)
+	local = local || opts.Source == nil
+	paths := []string{}
+	for _, path := range opts.Paths {
+		if local && !filepath.IsAbs(path) {
+			path = filepath.Join(opts.CWD, path)
+		}
+		paths = append(paths, opts.logicalPath(path))
+	}
+	return paths
+}
+
+func (snapshot *Snapshot) gatherTargetInformation(ctx context.Context, opts StoreOptions) <-chan ArchiveResult {
 	ch := make(chan ArchiveResult)
 	var wg sync.WaitGroup
 
 	results := func(rr []ArchiveResult) {
 		go func() {
 			for _, r := range rr {
-				ch <- r
+				select {
+				case ch <- r:
+				case <-ctx.Done():
+				}
 				wg.Done()
 			}
 		}()
@@ -73,19 +205,32 @@ func (snapshot *Snapshot) gatherTargetInformation(cwd string, paths []string, ex
 
 	go func() {
 		var archives []ArchiveResult
+		start := time.Now()
+		// links maps the inodes of files with multiple hard links to the
+		// first path they've been found at
+		links := make(map[[2]uint64]string)
 
-		for _, path := range paths {
-			ff := findFiles(path, excludes)
+		for _, path := range opts.Paths {
+			ff := opts.Source.Scan(ctx, path, opts)
 
 			for result := range ff {
 				if result.Error == nil {
-					rel, err := filepath.Rel(cwd, result.Archive.Path)
+					result.Archive.sourcePath = result.Archive.Path
+					result.Archive.Path = opts.logicalPath(result.Archive.Path)
+					rel, err := filepath.Rel(opts.logicalPath(opts.CWD), result.Archive.Path)
 					if err == nil && !strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
 						result.Archive.Path = rel
 					}
 					if isSpecialPath(result.Archive.Path) {
 						continue
 					}
+					if inode := result.Archive.inode; inode != [2]uint64{} {
+						if target, ok := links[inode]; ok {
+							result.Archive.LinkTo = target
+						} else {
+							links[inode] = result.Archive.Path
+						}
+					}
 
 					// update scan statistics
 					snapshot.mut.Lock()
@@ -112,6 +257,7 @@ func (snapshot *Snapshot) gatherTargetInformation(cwd string, paths []string, ex
 		}
 
 		results(archives)
+		opts.Timings.since(StageScanning, start)
 
 		wg.Wait()
 		close(ch)
@@ -121,26 +267,99 @@ func (snapshot *Snapshot) gatherTargetInformation(cwd string, paths []string, ex
 }
 
 // Add adds a path to a Snapshot.
-func (snapshot *Snapshot) Add(repository Repository, chunkIndex *ChunkIndex, opts StoreOptions) <-chan Progress {
+//
+// Up to opts.Concurrency items get stored in parallel, and each of them
+// chunks, compresses, encrypts and uploads up to opts.Concurrency chunks at
+// the same time. All items share the same limit for uploads though, so there
+// are never more than opts.Concurrency uploads in flight.
+//
+// Once ctx is done, all in-flight transfers get aborted and the returned
+// channel gets closed. Items which haven't been stored completely until then
+// don't get added to the snapshot.
+//
+// If the snapshot already contains items, e.g. because it got loaded with
+// LoadCheckpoint, only new and changed items get stored. Items which don't
+// exist anymore get removed from the snapshot.
+func (snapshot *Snapshot) Add(ctx context.Context, repository Repository, chunkIndex *ChunkIndex, opts StoreOptions) <-chan Progress {
 	progress := make(chan Progress)
+	// errors still get reported after storing has been canceled
+	throttled := throttleProgress(ctx, progress, opts.ProgressInterval)
+	ctx, cancel := context.WithCancel(ctx)
+
+	if opts.Concurrency == 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	opts.DataParts = uint(math.Max(1, float64(opts.DataParts)))
+	if opts.Source == nil {
+		opts.Source = &SourceLocal{}
+	}
+	snapshot.setChunker(opts.Chunker)
+	snapshot.setPaths(opts)
+	opts.dedupDomain = snapshot.DedupDomain
+	opts.chunkHeaders = repository.chunkHeaders()
+	if _, local := opts.Source.(*SourceLocal); !local || opts.DryRun {
+		opts.ChangeCache = nil
+	}
+	if opts.ChangeCache != nil {
+		opts.ChangeCache.addRoots(snapshot.Paths)
+	}
+	if opts.Baseline != nil && opts.Baseline.DedupDomain != snapshot.DedupDomain {
+		// its chunks can't be shared with this snapshot
+		opts.Baseline = nil
+	}
+	if opts.Baseline != nil {
+		snapshot.Baseline = opts.Baseline.ID
+	}
 
-	ch := snapshot.gatherTargetInformation(opts.CWD, opts.Paths, opts.Excludes)
+	ch := snapshot.gatherTargetInformation(ctx, opts)
+
+	s := &storer{
+		snapshot:   snapshot,
+		repository: &repository,
+		chunkIndex: chunkIndex,
+		opts:       opts,
+		progress:   progress,
+		uploads:    make(chan struct{}, opts.Concurrency),
+		devices:    newDeviceLimiter(opts.DiskConcurrency),
+		ctx:        ctx,
+		cancel:     cancel,
+		pending:    make(map[string]bool),
+		planned:    make(map[string]bool),
+	}
+	cwd := opts.logicalPath(opts.CWD)
+	for path := range snapshot.Archives {
+		if withinPaths(path, cwd, snapshot.Paths) {
+			s.pending[path] = true
+		}
+	}
 
 	go func() {
 		defer close(progress)
+		defer cancel()
+
+		archives := make(chan *Archive)
+		var wg sync.WaitGroup
+		for i := uint(0); i < opts.Concurrency; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for archive := range archives {
+					s.store(archive)
+				}
+			}()
+		}
+
+	loop:
 		for result := range ch {
 			if result.Error != nil {
-				p := newProgressError(result.Error)
-				p.Path = result.Archive.Path
-				progress <- p
-				if opts.Pedantic {
+				if s.fail(result.Archive.Path, result.Error) {
 					break
 				}
 				continue
 			}
 
 			archive := result.Archive
-			rel, err := filepath.Rel(opts.CWD, archive.Path)
+			rel, err := filepath.Rel(cwd, archive.Path)
 			if err == nil && !strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
 				archive.Path = rel
 			}
@@ -148,81 +367,534 @@ func (snapshot *Snapshot) Add(repository Repository, chunkIndex *ChunkIndex, opt
 				continue
 			}
 
-			p := newProgress(archive)
+			select {
+			case archives <- archive:
+			case <-ctx.Done():
+				break loop
+			}
+		}
+
+		close(archives)
+		wg.Wait()
+
+		for _, archive := range s.linkHardLinks() {
+			if ctx.Err() != nil {
+				break
+			}
+			// the item it links to couldn't be stored, store it on its own
+			archive.LinkTo = ""
+			s.store(archive)
+		}
+
+		if ctx.Err() == nil {
+			// items of a resumed snapshot, which don't exist anymore
 			snapshot.mut.Lock()
-			p.TotalStatistics = snapshot.Stats
+			for path := range s.pending {
+				delete(snapshot.Archives, path)
+			}
 			snapshot.mut.Unlock()
-			progress <- p
-
-			if archive.Type == File {
-				opts.DataParts = uint(math.Max(1, float64(opts.DataParts)))
-				chunkchan, err := chunkFile(archive.Path, repository.Key, opts)
-				if err != nil {
-					if os.IsNotExist(err) {
-						// if this file has already been deleted before we could backup it, we can gracefully ignore it and continue
-						continue
-					}
-					p = newProgressError(err)
-					p.Path = archive.Path
-					progress <- p
-					if opts.Pedantic {
-						break
-					}
-					continue
+		}
+	}()
+
+	return throttled
+}
+
+// withinPaths returns true if the archive path, which may be relative to cwd,
+// is one of paths or located below one of them.
+func withinPaths(path, cwd string, paths []string) bool {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(cwd, path)
+	}
+	for _, p := range paths {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(cwd, p)
+		}
+		rel, err := filepath.Rel(p, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// logicalPath returns path with the StripPrefix removed from it, as the path
+// it belongs to. Paths outside of the StripPrefix get returned as they are.
+func (opts StoreOptions) logicalPath(path string) string {
+	if opts.StripPrefix == "" || !filepath.IsAbs(path) {
+		return path
+	}
+	rel, err := filepath.Rel(opts.StripPrefix, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return path
+	}
+	return filepath.Join(filepath.VolumeName(path)+string(os.PathSeparator), rel)
+}
+
+// storer stores the items of a snapshot concurrently.
+type storer struct {
+	snapshot   *Snapshot
+	repository *Repository
+	chunkIndex *ChunkIndex
+	opts       StoreOptions
+	progress   chan<- Progress
+
+	// uploads limits the amount of concurrently stored chunks
+	uploads chan struct{}
+	// devices limits the amount of files read concurrently from spinning
+	// disks
+	devices *deviceLimiter
+	// ctx gets canceled when the caller's context is done or a pedantic store
+	// operation failed
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// pending contains the items of a resumed snapshot that haven't been
+	// visited yet. It's protected by the snapshot's mutex
+	pending map[string]bool
+	// planned contains the chunks a dry run would have stored. It's
+	// protected by the snapshot's mutex
+	planned map[string]bool
+}
+
+// resume re-adds an item that has already been stored before the store
+// operation got interrupted, unless it changed since. It returns true if the
+// item doesn't need to be stored again.
+func (s *storer) resume(archive *Archive) bool {
+	snapshot := s.snapshot
+	snapshot.mut.Lock()
+	prev, ok := snapshot.Archives[archive.Path]
+	if !ok || !s.pending[archive.Path] {
+		snapshot.mut.Unlock()
+		return false
+	}
+	delete(s.pending, archive.Path)
+	if !prev.unchanged(archive, s.opts) {
+		delete(snapshot.Archives, archive.Path)
+		snapshot.mut.Unlock()
+		return false
+	}
+
+	s.chunkIndex.AddArchive(prev, snapshot.ID)
+	snapshot.Stats.Transferred += prev.Size
+	snapshot.Stats.StorageSize += prev.StorageSize
+
+	p := newProgress(prev)
+	p.CurrentItemStats.Transferred = prev.Size
+	p.TotalStatistics = snapshot.Stats
+	snapshot.mut.Unlock()
+
+	sendProgress(s.ctx, s.progress, p)
+	return true
+}
+
+// reuse adds a file with the chunks it has been stored with before, if the
+// change cache or the baseline snapshot show it hasn't changed since. It
+// returns true if the file doesn't need to be read.
+func (s *storer) reuse(archive *Archive) bool {
+	if archive.Type != File || (s.opts.ChangeCache == nil && s.opts.Baseline == nil) {
+		return false
+	}
+
+	snapshot := s.snapshot
+	path := s.opts.logicalPath(s.sourcePath(archive))
+	snapshot.mut.Lock()
+	if e, ok := s.opts.ChangeCache.lookup(path, archive, s.opts, s.chunkIndex); ok {
+		archive.Chunks = e.Chunks
+		archive.Encrypted = e.Encrypted
+		archive.Compressed = e.Compressed
+	} else if prev, ok := s.opts.Baseline.unchanged(archive, s.opts, s.chunkIndex); ok {
+		archive.Chunks = prev.Chunks
+		archive.Encrypted = prev.Encrypted
+		archive.Compressed = prev.Compressed
+		s.opts.ChangeCache.update(path, archive, s.opts, time.Now().Unix())
+	} else {
+		snapshot.mut.Unlock()
+		return false
+	}
+	delete(s.pending, archive.Path)
+	snapshot.AddArchive(archive)
+	s.chunkIndex.AddArchive(archive, snapshot.ID)
+	snapshot.Stats.Transferred += archive.Size
+
+	p := newProgress(archive)
+	p.CurrentItemStats.Transferred = archive.Size
+	p.TotalStatistics = snapshot.Stats
+	snapshot.mut.Unlock()
+
+	sendProgress(s.ctx, s.progress, p)
+	return true
+}
+
+// unchanged returns the archive of the baseline snapshot, which matches the
+// current item and storage options, if all of its chunks are still found in
+// the chunk-index.
+func (baseline *Snapshot) unchanged(archive *Archive, opts StoreOptions, index *ChunkIndex) (*Archive, bool) {
+	if baseline == nil {
+		return nil, false
+	}
+	prev, ok := baseline.Archives[archive.Path]
+	if !ok || !prev.unchanged(archive, opts) || !index.hasChunks(prev.Chunks) {
+		return nil, false
+	}
+	return prev, true
+}
+
+// sourcePath returns the path the data of archive gets read from.
+func (s *storer) sourcePath(archive *Archive) string {
+	if archive.sourcePath != "" {
+		return archive.sourcePath
+	}
+	if filepath.IsAbs(archive.Path) {
+		return archive.Path
+	}
+	return filepath.Join(s.opts.CWD, archive.Path)
+}
+
+// fail reports an error for path and returns true if the store operation
+// should be stopped.
+func (s *storer) fail(path string, err error) bool {
+	if s.ctx.Err() != nil {
+		// errors caused by the cancellation itself are not worth reporting
+		return true
+	}
+
+	p := newProgressError(err)
+	p.Path = path
+	if !sendProgress(s.ctx, s.progress, p) {
+		return true
+	}
+
+	// skipped entries are expected, they don't stop pedantic store operations
+	if s.opts.Pedantic && !IsSkipped(err) {
+		s.cancel()
+		return true
+	}
+	return false
+}
+
+// link adds a hard link to the snapshot. Its data only gets stored once, for
+// the item it links to, and gets shared with it by linkHardLinks.
+func (s *storer) link(archive *Archive) {
+	snapshot := s.snapshot
+	p := newProgress(archive)
+	p.CurrentItemStats.Transferred = archive.Size
+
+	snapshot.mut.Lock()
+	delete(s.pending, archive.Path)
+	snapshot.Stats.Transferred += archive.Size
+	snapshot.AddArchive(archive)
+	p.TotalStatistics = snapshot.Stats
+	snapshot.mut.Unlock()
+
+	sendProgress(s.ctx, s.progress, p)
+}
+
+// linkHardLinks shares the chunks of stored items with their hard links. It
+// returns the hard links whose linked item hasn't been stored.
+func (s *storer) linkHardLinks() []*Archive {
+	snapshot := s.snapshot
+	snapshot.mut.Lock()
+	defer snapshot.mut.Unlock()
+
+	var unlinked []*Archive
+	for path, archive := range snapshot.Archives {
+		if archive.LinkTo == "" {
+			continue
+		}
+		target, ok := snapshot.Archives[archive.LinkTo]
+		if !ok || target.Type != File || target.LinkTo != "" {
+			delete(snapshot.Archives, path)
+			snapshot.Stats.Transferred -= archive.Size
+			unlinked = append(unlinked, archive)
+			continue
+		}
+
+		archive.Chunks = target.Chunks
+		archive.Encrypted = target.Encrypted
+		archive.Compressed = target.Compressed
+		s.chunkIndex.AddArchive(archive, snapshot.ID)
+	}
+	return unlinked
+}
+
+// store stores a single archive and adds it to the snapshot.
+func (s *storer) store(archive *Archive) {
+	snapshot := s.snapshot
+	if archive.LinkTo != "" {
+		s.link(archive)
+		return
+	}
+	if s.resume(archive) || !s.process(archive) || s.reuse(archive) {
+		return
+	}
+
+	p := newProgress(archive)
+	snapshot.mut.Lock()
+	p.TotalStatistics = snapshot.Stats
+	snapshot.mut.Unlock()
+	if !sendProgress(s.ctx, s.progress, p) {
+		return
+	}
+
+	if archive.Type == File {
+		path := s.sourcePath(archive)
+		readAt := time.Now().Unix()
+		release, ok := s.devices.acquire(s.ctx, archive.device)
+		if !ok {
+			return
+		}
+		r, err := s.opts.Source.Open(s.ctx, path)
+		if err != nil {
+			release()
+			if os.IsNotExist(err) {
+				// if this file has already been deleted before we could backup it, we can gracefully ignore it and continue
+				return
+			}
+			s.fail(archive.Path, err)
+			return
+		}
+		chunkchan, err := chunkFile(s.ctx, r, s.snapshot.encryptionKey(s.repository, s.opts.Encrypt), s.opts)
+		if err != nil {
+			release()
+			s.fail(archive.Path, err)
+			return
+		}
+		archive.Encrypted = s.opts.Encrypt
+		archive.Compressed = s.opts.Compress
+
+		// mut protects the archive and p while its chunks are being stored
+		var mut sync.Mutex
+		var wg sync.WaitGroup
+		for cd := range chunkchan {
+			if s.ctx.Err() != nil {
+				break
+			}
+			if cd.Error != nil {
+				if s.fail(archive.Path, cd.Error) {
+					break
 				}
-				archive.Encrypted = opts.Encrypt
-				archive.Compressed = opts.Compress
-
-				for cd := range chunkchan {
-					if cd.Error != nil {
-						p = newProgressError(err)
-						p.Path = archive.Path
-						progress <- p
-						if opts.Pedantic {
-							return
-						}
-						continue
-					}
-					chunk := cd.Chunk
-					// fmt.Printf("\tSplit %s (#%d, %d bytes), compression: %s, encryption: %s, hash: %s\n", id.Path, cd.Num, cd.Size, CompressionText(cd.Compressed), EncryptionText(cd.Encrypted), cd.Hash)
+				continue
+			}
+
+			s.uploads <- struct{}{}
+			wg.Add(1)
+			go func(chunk Chunk) {
+				defer func() {
+					<-s.uploads
+					wg.Done()
+				}()
+				// fmt.Printf("\tSplit %s (#%d, %d bytes), compression: %s, encryption: %s, hash: %s\n", id.Path, cd.Num, cd.Size, CompressionText(cd.Compressed), EncryptionText(cd.Encrypted), cd.Hash)
 
-					// store this chunk
-					n, err := repository.backend.StoreChunk(chunk)
+				// store this chunk, holes don't need to be stored
+				var n uint64
+				if !chunk.Hole && s.opts.DryRun {
+					n = s.plan(chunk)
+				} else if !chunk.Hole {
+					var err error
+					start := time.Now()
+					n, err = s.repository.backend.StoreChunk(s.ctx, chunk)
+					s.opts.Timings.since(StageUploading, start)
 					if err != nil {
-						p = newProgressError(err)
-						p.Path = archive.Path
-						progress <- p
-						if opts.Pedantic {
-							return
-						}
-						continue
+						s.fail(archive.Path, err)
+						return
+					}
+					if err := s.opts.Limiter.Wait(s.ctx, n); err != nil {
+						s.fail(archive.Path, err)
+						return
 					}
+				}
 
-					// release the memory, we don't need the data anymore
-					chunk.Data = &[][]byte{}
+				// release the memory, we don't need the data anymore
+				chunk.Data = &[][]byte{}
 
-					archive.Chunks = append(archive.Chunks, chunk)
-					archive.StorageSize += n
+				mut.Lock()
+				defer mut.Unlock()
+				archive.Chunks = append(archive.Chunks, chunk)
+				archive.StorageSize += n
 
-					p.CurrentItemStats.StorageSize = archive.StorageSize
-					p.CurrentItemStats.Transferred += uint64(chunk.OriginalSize)
-					snapshot.Stats.Transferred += uint64(chunk.OriginalSize)
-					snapshot.Stats.StorageSize += n
+				p.CurrentItemStats.StorageSize = archive.StorageSize
+				p.CurrentItemStats.Transferred += uint64(chunk.OriginalSize)
 
-					snapshot.mut.Lock()
-					p.TotalStatistics = snapshot.Stats
-					snapshot.mut.Unlock()
-					progress <- p
-				}
+				snapshot.mut.Lock()
+				snapshot.Stats.Transferred += uint64(chunk.OriginalSize)
+				snapshot.Stats.StorageSize += n
+				p.TotalStatistics = snapshot.Stats
+				snapshot.mut.Unlock()
+				sendProgress(s.ctx, s.progress, p)
+			}(cd.Chunk)
+		}
+		// the file has been read, its remaining chunks only get uploaded
+		release()
+		wg.Wait()
+
+		// drain the remaining chunks, so the chunker can finish
+		go func() {
+			for range chunkchan {
 			}
+		}()
 
-			snapshot.AddArchive(archive)
-			chunkIndex.AddArchive(archive, snapshot.ID)
+		if s.ctx.Err() != nil {
+			return
 		}
 
-	}()
+		// chunks get stored in parallel, so we need to restore their order
+		sort.Slice(archive.Chunks, func(i, j int) bool {
+			return archive.Chunks[i].Num < archive.Chunks[j].Num
+		})
 
-	return progress
+		// the size of streamed data isn't known before it has been read
+		if archive.Size == 0 {
+			for _, chunk := range archive.Chunks {
+				archive.Size += uint64(chunk.OriginalSize)
+			}
+			snapshot.mut.Lock()
+			snapshot.Stats.Size += archive.Size
+			snapshot.mut.Unlock()
+		}
+		s.opts.ChangeCache.update(s.opts.logicalPath(path), archive, s.opts, readAt)
+	}
+
+	snapshot.mut.Lock()
+	snapshot.AddArchive(archive)
+	s.chunkIndex.AddArchive(archive, snapshot.ID)
+	snapshot.mut.Unlock()
+}
+
+// plan returns the storage space a dry run would have needed to store chunk,
+// unless it's stored already or got planned to be stored before.
+func (s *storer) plan(chunk Chunk) uint64 {
+	s.snapshot.mut.Lock()
+	defer s.snapshot.mut.Unlock()
+	if _, ok := s.chunkIndex.Chunks[chunk.Hash]; ok || s.planned[chunk.Hash] {
+		return 0
+	}
+	s.planned[chunk.Hash] = true
+
+	var n uint64
+	for _, data := range *chunk.Data {
+		n += uint64(len(data))
+	}
+	return n
+}
+
+// ChunkerSettings returns the settings the files of the snapshot have been
+// divided into chunks with.
+func (snapshot *Snapshot) ChunkerSettings() ChunkerSettings {
+	if snapshot.Chunker == nil {
+		return DefaultChunkerSettings
+	}
+	return *snapshot.Chunker
+}
+
+func (snapshot *Snapshot) setChunker(settings ChunkerSettings) {
+	snapshot.Chunker = nil
+	if settings = settings.orDefault(); settings != DefaultChunkerSettings {
+		snapshot.Chunker = &settings
+	}
+}
+
+// Inherit turns snapshot into a partial snapshot of parent. It only records
+// the items stored at opts.Paths, all others get inherited from parent. Their
+// chunks get referenced by snapshot in the chunk-index, so they're kept as
+// long as snapshot is.
+func (snapshot *Snapshot) Inherit(repository *Repository, parent *Snapshot, opts StoreOptions, index *ChunkIndex) error {
+	snapshot.Parent = parent.ID
+	snapshot.Subtrees = []string{}
+	for _, path := range opts.Paths {
+		path = opts.logicalPath(path)
+		rel, err := filepath.Rel(opts.logicalPath(opts.CWD), path)
+		if err == nil && !strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+			path = rel
+		}
+		snapshot.Subtrees = append(snapshot.Subtrees, path)
+	}
+
+	view, err := snapshot.Merged(repository)
+	if err != nil {
+		return err
+	}
+	for path, archive := range view.Archives {
+		if _, ok := snapshot.Archives[path]; !ok {
+			index.AddArchive(archive, snapshot.ID)
+		}
+	}
+	return nil
+}
+
+// Merged returns the complete view of a partial snapshot, consisting of its
+// own items and the ones inherited from its parent. Snapshots without a parent
+// get returned as they are.
+func (snapshot *Snapshot) Merged(repository *Repository) (*Snapshot, error) {
+	if snapshot.Parent == "" {
+		return snapshot, nil
+	}
+
+	// parents may have been removed from their volume, but their metadata
+	// is still around
+	parent, err := openSnapshot(snapshot.Parent, repository)
+	if err != nil {
+		return nil, fmt.Errorf("loading parent snapshot %s failed: %v", snapshot.Parent, err)
+	}
+	parent, err = parent.Merged(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	view := &Snapshot{
+		ID:          snapshot.ID,
+		Date:        snapshot.Date,
+		Description: snapshot.Description,
+		Tags:        snapshot.Tags,
+		Hostname:    snapshot.Hostname,
+		Username:    snapshot.Username,
+		Paths:       snapshot.Paths,
+		CommandLine: snapshot.CommandLine,
+		Annotations: snapshot.Annotations,
+		Parent:      snapshot.Parent,
+		Subtrees:    snapshot.Subtrees,
+		DedupDomain: snapshot.DedupDomain,
+		Baseline:    snapshot.Baseline,
+		Stats: Stats{
+			Transferred: snapshot.Stats.Transferred,
+			Errors:      snapshot.Stats.Errors,
+		},
+		Archives: make(map[string]*Archive),
+	}
+	for path, archive := range parent.Archives {
+		if !withinSubtrees(path, snapshot.Subtrees) {
+			view.Archives[path] = archive
+		}
+	}
+	for path, archive := range snapshot.Archives {
+		view.Archives[path] = archive
+	}
+
+	for _, archive := range view.Archives {
+		view.Stats.Size += archive.Size
+		view.Stats.StorageSize += archive.StorageSize
+		switch archive.Type {
+		case Directory:
+			view.Stats.Dirs++
+		case File:
+			view.Stats.Files++
+		case SymLink:
+			view.Stats.SymLinks++
+		}
+	}
+
+	return view, nil
+}
+
+// withinSubtrees returns true if the archive path is one of subtrees or
+// located below one of them. Paths are compared as they've been recorded.
+func withinSubtrees(path string, subtrees []string) bool {
+	for _, subtree := range subtrees {
+		rel, err := filepath.Rel(subtree, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
 }
 
 // Clone clones a snapshot.
@@ -232,12 +904,80 @@ func (snapshot *Snapshot) Clone() (*Snapshot, error) {
 		return s, err
 	}
 
+	s.Tags = snapshot.Tags
 	s.Stats = snapshot.Stats
 	s.Archives = snapshot.Archives
+	s.DedupDomain = snapshot.DedupDomain
 
 	return s, nil
 }
 
+// SnapshotFilter selects snapshots by their metadata. Empty criteria match
+// all snapshots.
+type SnapshotFilter struct {
+	// Search matches snapshots whose description, tags, hostname or
+	// annotations contain it, ignoring case
+	Search string
+	// Tags matches snapshots carrying all of these tags
+	Tags []string
+	Host string
+	// Annotations matches snapshots carrying all of these annotations. An
+	// empty value matches any value of its key
+	Annotations map[string]string
+	// Since and Until match snapshots taken within this time range
+	Since time.Time
+	Until time.Time
+}
+
+// Matches returns true if the snapshot meets all criteria of the filter.
+func (f SnapshotFilter) Matches(snapshot *Snapshot) bool {
+	if !f.Since.IsZero() && snapshot.Date.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && snapshot.Date.After(f.Until) {
+		return false
+	}
+	if f.Host != "" && !strings.EqualFold(f.Host, snapshot.Hostname) {
+		return false
+	}
+	for _, tag := range f.Tags {
+		if !snapshot.HasTag(tag) {
+			return false
+		}
+	}
+	for key, value := range f.Annotations {
+		v, ok := snapshot.Annotations[key]
+		if !ok || (value != "" && v != value) {
+			return false
+		}
+	}
+
+	if f.Search == "" {
+		return true
+	}
+	search := strings.ToLower(f.Search)
+	fields := append([]string{snapshot.Description, snapshot.Hostname}, snapshot.Tags...)
+	for key, value := range snapshot.Annotations {
+		fields = append(fields, key+"="+value)
+	}
+	for _, field := range fields {
+		if strings.Contains(strings.ToLower(field), search) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasTag returns true if the snapshot carries tag.
+func (snapshot *Snapshot) HasTag(tag string) bool {
+	for _, t := range snapshot.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
 // openSnapshot opens an existing snapshot.
 func openSnapshot(id string, repository *Repository) (*Snapshot, error) {
 	snapshot := Snapshot{
@@ -247,28 +987,70 @@ func openSnapshot(id string, repository *Repository) (*Snapshot, error) {
 	if err != nil {
 		return &snapshot, err
 	}
-	pipe, err := NewDecodingPipeline(CompressionLZMA, EncryptionAES, repository.Key)
+
+	// snapshots of protected volumes are encrypted with the volume's key
+	volumeKey, err := repository.volumeKey(id)
 	if err != nil {
 		return &snapshot, err
 	}
-	err = pipe.Decode(b, &snapshot)
+	if volumeKey != "" {
+		snapshot.key, snapshot.volumeKey = volumeKey, volumeKey
+		return &snapshot, decodeMetadata(CompressionLZMA, volumeKey, b, &snapshot)
+	}
+
+	snapshot.key, err = repository.decodeSnapshot(b, &snapshot)
 	return &snapshot, err
 }
 
 // Save writes a snapshot's metadata.
 func (snapshot *Snapshot) Save(repository *Repository) error {
-	pipe, err := NewEncodingPipeline(CompressionLZMA, EncryptionAES, repository.Key)
-	if err != nil {
+	if err := repository.checkLock(); err != nil {
 		return err
 	}
-	b, err := pipe.Encode(snapshot)
+
+	var b []byte
+	var err error
+	if snapshot.volumeKey != "" {
+		b, err = repository.encodeMetadata(CompressionLZMA, snapshot.volumeKey, snapshot)
+	} else {
+		b, err = repository.encodeSnapshot(snapshot)
+	}
 	if err != nil {
 		return err
 	}
-	return repository.backend.SaveSnapshot(snapshot.ID, b)
+	if err := repository.backend.SaveSnapshot(snapshot.ID, b); err != nil {
+		return err
+	}
+	if snapshot.volumeKey != "" {
+		snapshot.key = snapshot.volumeKey
+	} else if !repository.Asymmetric() {
+		snapshot.key = repository.Key
+	}
+	return nil
 }
 
 // AddArchive adds an archive to a snapshot.
 func (snapshot *Snapshot) AddArchive(archive *Archive) {
 	snapshot.Archives[archive.Path] = archive
 }
+
+// RemoveArchive removes an archive from a snapshot and updates the
+// snapshot's statistics accordingly.
+func (snapshot *Snapshot) RemoveArchive(path string) {
+	archive, ok := snapshot.Archives[path]
+	if !ok {
+		return
+	}
+	delete(snapshot.Archives, path)
+
+	snapshot.Stats.Size -= archive.Size
+	snapshot.Stats.StorageSize -= archive.StorageSize
+	switch archive.Type {
+	case Directory:
+		snapshot.Stats.Dirs--
+	case File:
+		snapshot.Stats.Files--
+	case SymLink:
+		snapshot.Stats.SymLinks--
+	}
+}
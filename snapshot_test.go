@@ -9,11 +9,18 @@
 package knoxite
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/hex"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/minio/highwayhash"
 	"github.com/muesli/combinator"
@@ -124,7 +131,7 @@ func TestSnapshotCreate(t *testing.T) {
 				ParityParts: tt.ParityParts,
 			}
 
-			progress := snapshot.Add(r, &index, opts)
+			progress := snapshot.Add(context.Background(), r, &index, opts)
 			for p := range progress {
 				if p.Error != nil {
 					t.Errorf("Failed adding to snapshot: %s", p.Error)
@@ -169,6 +176,7 @@ func TestSnapshotCreate(t *testing.T) {
 				t.Errorf("Failed opening repository: %s", err)
 				return
 			}
+			wd, _ := os.Getwd()
 
 			_, snapshot, err := r.FindSnapshot(snapshotOriginal.ID)
 			if err != nil {
@@ -181,6 +189,12 @@ func TestSnapshotCreate(t *testing.T) {
 			if snapshot.Description != snapshotOriginal.Description {
 				t.Errorf("Failed verifying snapshot description: %s != %s", snapshot.Description, snapshotOriginal.Description)
 			}
+			if snapshot.Hostname != snapshotOriginal.Hostname || snapshot.Username != snapshotOriginal.Username {
+				t.Errorf("Failed verifying snapshot origin: %s@%s != %s@%s", snapshot.Username, snapshot.Hostname, snapshotOriginal.Username, snapshotOriginal.Hostname)
+			}
+			if len(snapshot.Paths) != 2 || snapshot.Paths[0] != filepath.Join(wd, "snapshot_test.go") {
+				t.Errorf("Failed verifying absolute snapshot paths: %v", snapshot.Paths)
+			}
 
 			for i, archive := range snapshotOriginal.Archives {
 				if archive.Path != snapshot.Archives[i].Path {
@@ -200,7 +214,7 @@ func TestSnapshotCreate(t *testing.T) {
 			}
 			defer os.RemoveAll(targetdir)
 
-			progress, err := DecodeSnapshot(r, snapshot, targetdir, tt.ExcludesRestore, false)
+			progress, err := DecodeSnapshot(context.Background(), r, snapshot, targetdir, RestoreOptions{Excludes: tt.ExcludesRestore})
 			if err != nil {
 				t.Errorf("Failed restoring snapshot: %s", err)
 				return
@@ -252,6 +266,359 @@ func TestSnapshotCreate(t *testing.T) {
 	}
 }
 
+func TestSnapshotConcurrentStore(t *testing.T) {
+	testPassword := "this_is_a_password"
+
+	src, err := ioutil.TempDir("", "knoxite.source")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	// a few files spanning multiple chunks each
+	var size uint64
+	var paths []string
+	for i := 0; i < 4; i++ {
+		data := make([]byte, (i+1)*(1<<20)+i)
+		_, _ = rand.Read(data)
+
+		path := filepath.Join(src, fmt.Sprintf("file%d", i))
+		if err := ioutil.WriteFile(path, data, 0600); err != nil {
+			t.Fatalf("Failed writing test file: %s", err)
+		}
+		size += uint64(len(data))
+		paths = append(paths, path)
+	}
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	snapshot, err := NewSnapshot("test_snapshot")
+	if err != nil {
+		t.Fatalf("Failed creating snapshot: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+
+	opts := StoreOptions{
+		CWD:         src,
+		Paths:       paths,
+		Compress:    CompressionNone,
+		Encrypt:     EncryptionAES,
+		DataParts:   1,
+		Concurrency: 8,
+	}
+
+	var last Stats
+	for p := range snapshot.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Errorf("Failed adding to snapshot: %s", p.Error)
+		}
+		if p.TotalStatistics.Transferred < last.Transferred {
+			t.Errorf("Transferred bytes decreased: %d < %d", p.TotalStatistics.Transferred, last.Transferred)
+		}
+		last = p.TotalStatistics
+	}
+
+	if snapshot.Stats.Transferred != size {
+		t.Errorf("Expected %d transferred bytes, got %d", size, snapshot.Stats.Transferred)
+	}
+	if len(snapshot.Archives) != len(paths) {
+		t.Fatalf("Expected %d archives, got %d", len(paths), len(snapshot.Archives))
+	}
+	for _, archive := range snapshot.Archives {
+		for i, chunk := range archive.Chunks {
+			if chunk.Num != uint(i) {
+				t.Errorf("Chunks of %s are out of order: expected #%d, got #%d", archive.Path, i, chunk.Num)
+			}
+		}
+	}
+
+	targetdir, err := ioutil.TempDir("", "knoxite.target")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for restore: %s", err)
+	}
+	defer os.RemoveAll(targetdir)
+
+	progress, err := DecodeSnapshot(context.Background(), r, snapshot, targetdir, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Failed restoring snapshot: %s", err)
+	}
+	for p := range progress {
+		if p.Error != nil {
+			t.Errorf("Failed restoring snapshot: %s", p.Error)
+		}
+	}
+
+	for _, path := range paths {
+		hash1, err := hashFile(path)
+		if err != nil {
+			t.Fatalf("Failed generating shasum for %s: %s", path, err)
+		}
+		hash2, err := hashFile(filepath.Join(targetdir, filepath.Base(path)))
+		if err != nil {
+			t.Fatalf("Failed generating shasum for restored %s: %s", path, err)
+		}
+		if hash1 != hash2 {
+			t.Errorf("Failed verifying shasum: %s != %s", hash1, hash2)
+		}
+	}
+}
+
+func TestSnapshotResume(t *testing.T) {
+	testPassword := "this_is_a_password"
+
+	src, err := ioutil.TempDir("", "knoxite.source")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	files := map[string][]byte{
+		"unchanged": []byte("this file doesn't change"),
+		"changed":   []byte("this file changes"),
+		"deleted":   []byte("this file gets deleted"),
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(src, name), data, 0600); err != nil {
+			t.Fatalf("Failed writing test file: %s", err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	snapshot, err := NewSnapshot("test_snapshot")
+	if err != nil {
+		t.Fatalf("Failed creating snapshot: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+
+	opts := StoreOptions{
+		CWD:       src,
+		Paths:     []string{src},
+		Compress:  CompressionNone,
+		Encrypt:   EncryptionAES,
+		DataParts: 1,
+	}
+	for p := range snapshot.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Fatalf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+
+	checkpoint := filepath.Join(dir, "checkpoint")
+	if err := snapshot.SaveCheckpoint(checkpoint, &r); err != nil {
+		t.Fatalf("Failed saving checkpoint: %s", err)
+	}
+
+	// change the source after the checkpoint was written
+	if err := os.Remove(filepath.Join(src, "deleted")); err != nil {
+		t.Fatalf("Failed deleting test file: %s", err)
+	}
+	files["changed"] = []byte("this file has been changed")
+	if err := ioutil.WriteFile(filepath.Join(src, "changed"), files["changed"], 0600); err != nil {
+		t.Fatalf("Failed writing test file: %s", err)
+	}
+	mtime := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(src, "changed"), mtime, mtime); err != nil {
+		t.Fatalf("Failed changing mtime: %s", err)
+	}
+
+	resumed, err := LoadCheckpoint(checkpoint, &r)
+	if err != nil {
+		t.Fatalf("Failed loading checkpoint: %s", err)
+	}
+	if resumed.ID != snapshot.ID || len(resumed.Archives) != len(snapshot.Archives) {
+		t.Fatalf("Checkpoint doesn't match snapshot")
+	}
+
+	index, err = OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	var transferred uint64
+	for p := range resumed.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Fatalf("Failed resuming snapshot: %s", p.Error)
+		}
+		transferred = p.TotalStatistics.Transferred
+	}
+
+	if _, ok := resumed.Archives["deleted"]; ok {
+		t.Errorf("Deleted file is still part of the resumed snapshot")
+	}
+	if a, b := resumed.Archives["unchanged"], snapshot.Archives["unchanged"]; a == nil || a.Chunks[0].Hash != b.Chunks[0].Hash {
+		t.Errorf("Unchanged file wasn't resumed")
+	}
+	if expected := uint64(len(files["changed"]) + len(files["unchanged"])); transferred != expected {
+		t.Errorf("Expected %d transferred bytes, got %d", expected, transferred)
+	}
+	for _, chunk := range resumed.Archives["unchanged"].Chunks {
+		if c, ok := index.Chunks[chunk.Hash]; !ok || len(c.Snapshots) != 1 {
+			t.Errorf("Chunks of resumed files are missing from the chunk-index")
+		}
+	}
+
+	targetdir, err := ioutil.TempDir("", "knoxite.target")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for restore: %s", err)
+	}
+	defer os.RemoveAll(targetdir)
+
+	progress, err := DecodeSnapshot(context.Background(), r, resumed, targetdir, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Failed restoring snapshot: %s", err)
+	}
+	for p := range progress {
+		if p.Error != nil {
+			t.Errorf("Failed restoring snapshot: %s", p.Error)
+		}
+	}
+	for _, name := range []string{"changed", "unchanged"} {
+		b, err := ioutil.ReadFile(filepath.Join(targetdir, name))
+		if err != nil {
+			t.Fatalf("Failed reading restored file: %s", err)
+		}
+		if string(b) != string(files[name]) {
+			t.Errorf("Restored %s doesn't match, got %q", name, b)
+		}
+	}
+}
+
+func TestSnapshotCancel(t *testing.T) {
+	testPassword := "this_is_a_password"
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	snapshot, err := NewSnapshot("test_snapshot")
+	if err != nil {
+		t.Fatalf("Failed creating snapshot: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed getting working dir: %s", err)
+	}
+	opts := StoreOptions{
+		CWD:       wd,
+		Paths:     []string{"snapshot_test.go", "snapshot.go"},
+		Compress:  CompressionNone,
+		Encrypt:   EncryptionAES,
+		DataParts: 1,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	for p := range snapshot.Add(ctx, r, &index, opts) {
+		if p.Error != nil {
+			t.Errorf("Unexpected error after cancellation: %s", p.Error)
+		}
+	}
+	if len(snapshot.Archives) > 0 {
+		t.Errorf("Expected no archives to be stored, got %d", len(snapshot.Archives))
+	}
+
+	progress, err := DecodeSnapshot(ctx, r, snapshot, dir, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Failed restoring snapshot: %s", err)
+	}
+	for range progress {
+		t.Errorf("Expected no progress after cancellation")
+	}
+}
+
+func TestSnapshotDryRun(t *testing.T) {
+	testPassword := "this_is_a_password"
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed getting working dir: %s", err)
+	}
+	opts := StoreOptions{
+		CWD:       wd,
+		Paths:     []string{"snapshot_test.go", "snapshot.go"},
+		Compress:  CompressionNone,
+		Encrypt:   EncryptionAES,
+		DataParts: 1,
+		DryRun:    true,
+	}
+
+	dryRun, _ := NewSnapshot("dry_run")
+	for p := range dryRun.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Fatalf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+	if len(dryRun.Archives) != 2 || dryRun.Stats.StorageSize == 0 {
+		t.Errorf("Expected 2 archives and their storage size, got %d archives of %d bytes", len(dryRun.Archives), dryRun.Stats.StorageSize)
+	}
+	parts, err := (*r.backend.Backends[0]).(BackendLister).ListChunks()
+	if err != nil || len(parts) != 0 {
+		t.Errorf("Expected no chunks to be stored during a dry run, got %d: %v", len(parts), err)
+	}
+
+	// the storage size of the dry run matches the one of really storing it
+	index, _ = OpenChunkIndex(&r)
+	opts.DryRun = false
+	snapshot, _ := NewSnapshot("test_snapshot")
+	for p := range snapshot.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Fatalf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+	if dryRun.Stats.StorageSize != snapshot.Stats.StorageSize {
+		t.Errorf("Expected a storage size of %d, got %d", snapshot.Stats.StorageSize, dryRun.Stats.StorageSize)
+	}
+}
+
 func TestSnapshotClone(t *testing.T) {
 	snapshot, _ := NewSnapshot("test_snapshot")
 	s, err := snapshot.Clone()
@@ -306,3 +673,570 @@ func TestSnapshotFind(t *testing.T) {
 		t.Errorf("Failed finding latest snapshot: %s %s", err, snapshot.ID)
 	}
 }
+
+func TestSnapshotFilter(t *testing.T) {
+	date := time.Date(2020, 6, 15, 12, 0, 0, 0, time.UTC)
+	snapshot := &Snapshot{
+		Date:        date,
+		Description: "Weekly backup of my photos",
+		Tags:        []string{"photos", "weekly"},
+		Hostname:    "laptop",
+	}
+
+	tests := []struct {
+		filter  SnapshotFilter
+		matches bool
+	}{
+		{SnapshotFilter{}, true},
+		{SnapshotFilter{Search: "PHOTOS"}, true},
+		{SnapshotFilter{Search: "lap"}, true},
+		{SnapshotFilter{Search: "weekl"}, true},
+		{SnapshotFilter{Search: "documents"}, false},
+		{SnapshotFilter{Tags: []string{"photos", "weekly"}}, true},
+		{SnapshotFilter{Tags: []string{"photos", "daily"}}, false},
+		{SnapshotFilter{Host: "Laptop"}, true},
+		{SnapshotFilter{Host: "desktop"}, false},
+		{SnapshotFilter{Since: date.Add(-time.Hour), Until: date.Add(time.Hour)}, true},
+		{SnapshotFilter{Since: date.Add(time.Hour)}, false},
+		{SnapshotFilter{Until: date.Add(-time.Hour)}, false},
+	}
+
+	for _, tt := range tests {
+		if m := tt.filter.Matches(snapshot); m != tt.matches {
+			t.Errorf("Expected filter %+v to return %v, got %v", tt.filter, tt.matches, m)
+		}
+	}
+}
+
+func TestSelectArchives(t *testing.T) {
+	snapshot := &Snapshot{Archives: make(map[string]*Archive)}
+	for _, path := range []string{"home", "home/user", "home/user/photos", "etc"} {
+		snapshot.AddArchive(&Archive{Path: path, Type: Directory})
+	}
+	for _, path := range []string{"home/user/a.jpg", "home/user/photos/b.JPG", "home/user/photos/c.png",
+		"home/user/notes.txt", "etc/passwd", "etc/d.jpg"} {
+		snapshot.AddArchive(&Archive{Path: path, Type: File, Size: 100, ModTime: 1000})
+	}
+	snapshot.Archives["home/user/photos/c.png"].Size = 5000
+	snapshot.Archives["etc/passwd"].ModTime = 2000
+
+	tests := []struct {
+		opts RestoreOptions
+		exp  []string
+	}{
+		{RestoreOptions{Includes: []string{"home/**/*.jpg"}},
+			[]string{"home", "home/user", "home/user/a.jpg", "home/user/photos", "home/user/photos/b.JPG"}},
+		{RestoreOptions{Includes: []string{"*.jpg"}, Excludes: []string{"etc"}},
+			[]string{"home", "home/user", "home/user/a.jpg", "home/user/photos", "home/user/photos/b.JPG"}},
+		{RestoreOptions{Includes: []string{"home/user/photos"}, Excludes: []string{"**/*.png"}},
+			[]string{"home", "home/user", "home/user/photos", "home/user/photos/b.JPG"}},
+		{RestoreOptions{Excludes: []string{"home"}},
+			[]string{"etc", "etc/d.jpg", "etc/passwd"}},
+		{RestoreOptions{Includes: []string{"home"}, MinSize: 1000},
+			[]string{"home", "home/user", "home/user/photos", "home/user/photos/c.png"}},
+		{RestoreOptions{Includes: []string{"home/user/photos"}, MaxSize: 1000},
+			[]string{"home", "home/user", "home/user/photos", "home/user/photos/b.JPG"}},
+		{RestoreOptions{Excludes: []string{"home"}, ModifiedAfter: time.Unix(1500, 0)},
+			[]string{"etc", "etc/passwd"}},
+	}
+
+	for _, tt := range tests {
+		archives, err := SelectArchives(snapshot, tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, arc := range archives {
+			paths = append(paths, arc.Path)
+		}
+		if !reflect.DeepEqual(paths, tt.exp) {
+			t.Errorf("Expected %v for %+v, got %v", tt.exp, tt.opts, paths)
+		}
+	}
+}
+
+func TestHardLinkRestore(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hard links aren't detected on windows")
+	}
+
+	testPassword := "this_is_a_password"
+
+	src, err := ioutil.TempDir("", "knoxite.source")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	if err := ioutil.WriteFile(filepath.Join(src, "a"), []byte("content"), 0600); err != nil {
+		t.Fatalf("Failed creating file: %s", err)
+	}
+	for _, name := range []string{"b", "c"} {
+		if err := os.Link(filepath.Join(src, "a"), filepath.Join(src, name)); err != nil {
+			t.Fatalf("Failed creating hard link: %s", err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	snapshot, err := NewSnapshot("test_snapshot")
+	if err != nil {
+		t.Fatalf("Failed creating snapshot: %s", err)
+	}
+
+	opts := StoreOptions{
+		CWD:       src,
+		Paths:     []string{src},
+		Encrypt:   EncryptionAES,
+		DataParts: 1,
+	}
+	for p := range snapshot.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Errorf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+
+	var stored, linked int
+	for _, name := range []string{"a", "b", "c"} {
+		arc := snapshot.Archives[name]
+		if arc.LinkTo == "" {
+			stored++
+		} else {
+			linked++
+		}
+		if len(arc.Chunks) != 1 {
+			t.Errorf("Expected %s to reference the shared chunk", name)
+		}
+	}
+	if stored != 1 || linked != 2 {
+		t.Fatalf("Expected 1 stored file and 2 hard links, got %d and %d", stored, linked)
+	}
+	if snapshot.Stats.Files != 3 || snapshot.Stats.StorageSize != snapshot.Archives["a"].StorageSize+
+		snapshot.Archives["b"].StorageSize+snapshot.Archives["c"].StorageSize {
+		t.Errorf("Unexpected snapshot statistics: %+v", snapshot.Stats)
+	}
+
+	targetdir, err := ioutil.TempDir("", "knoxite.target")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for restore: %s", err)
+	}
+	defer os.RemoveAll(targetdir)
+
+	progress, err := DecodeSnapshot(context.Background(), r, snapshot, targetdir, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Failed restoring snapshot: %s", err)
+	}
+	for p := range progress {
+		if p.Error != nil {
+			t.Errorf("Failed restoring snapshot: %s", p.Error)
+		}
+	}
+
+	a, err := os.Stat(filepath.Join(targetdir, "a"))
+	if err != nil {
+		t.Fatalf("Failed to stat restored file: %s", err)
+	}
+	for _, name := range []string{"b", "c"} {
+		fi, err := os.Stat(filepath.Join(targetdir, name))
+		if err != nil {
+			t.Fatalf("Failed to stat restored file: %s", err)
+		}
+		if !os.SameFile(a, fi) {
+			t.Errorf("Expected %s to be restored as a hard link", name)
+		}
+	}
+
+	// files restored without the file they link to get their own copy
+	only := filepath.Join(targetdir, "only")
+	progress, err = DecodeSnapshot(context.Background(), r, snapshot, only, RestoreOptions{Includes: []string{"c"}})
+	if err != nil {
+		t.Fatalf("Failed restoring snapshot: %s", err)
+	}
+	for p := range progress {
+		if p.Error != nil {
+			t.Errorf("Failed restoring snapshot: %s", p.Error)
+		}
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(only, "c")); err != nil || string(b) != "content" {
+		t.Errorf("Failed restoring hard link on its own: %v", err)
+	}
+}
+
+func TestSnapshotMerged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, "this_is_a_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+
+	parent, err := NewSnapshot("parent")
+	if err != nil {
+		t.Fatalf("Failed creating snapshot: %s", err)
+	}
+	for _, path := range []string{"cold", "hot"} {
+		parent.AddArchive(&Archive{Path: path, Type: Directory})
+	}
+	parent.AddArchive(&Archive{Path: "cold/c", Type: File, Size: 1, Chunks: []Chunk{{Hash: "c"}}})
+	parent.AddArchive(&Archive{Path: "hot/h", Type: File, Size: 1, Chunks: []Chunk{{Hash: "h"}}})
+	parent.AddArchive(&Archive{Path: "hot/gone", Type: File, Size: 1})
+	if err := parent.Save(&r); err != nil {
+		t.Fatalf("Failed saving snapshot: %s", err)
+	}
+
+	child, err := NewSnapshot("child")
+	if err != nil {
+		t.Fatalf("Failed creating snapshot: %s", err)
+	}
+	if err := child.Inherit(&r, parent, StoreOptions{CWD: "/src", Paths: []string{"/src/hot"}}, &index); err != nil {
+		t.Fatalf("Failed inheriting from parent: %s", err)
+	}
+	child.AddArchive(&Archive{Path: "hot", Type: Directory})
+	child.AddArchive(&Archive{Path: "hot/h", Type: File, Size: 2})
+	child.AddArchive(&Archive{Path: "hot/new", Type: File, Size: 2})
+	if err := child.Save(&r); err != nil {
+		t.Fatalf("Failed saving snapshot: %s", err)
+	}
+
+	grandchild, err := NewSnapshot("grandchild")
+	if err != nil {
+		t.Fatalf("Failed creating snapshot: %s", err)
+	}
+	if err := grandchild.Inherit(&r, child, StoreOptions{CWD: "/src", Paths: []string{"/src/cold"}}, &index); err != nil {
+		t.Fatalf("Failed inheriting from parent: %s", err)
+	}
+
+	view, err := grandchild.Merged(&r)
+	if err != nil {
+		t.Fatalf("Failed merging snapshot: %s", err)
+	}
+	var paths []string
+	for path := range view.Archives {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	if exp := []string{"hot", "hot/h", "hot/new"}; !reflect.DeepEqual(paths, exp) {
+		t.Errorf("Expected %v, got %v", exp, paths)
+	}
+	if view.Stats.Files != 2 || view.Stats.Dirs != 1 || view.Stats.Size != 4 {
+		t.Errorf("Unexpected statistics of merged snapshot: %+v", view.Stats)
+	}
+
+	// inherited chunks are referenced by the partial snapshots
+	if c := index.Chunks["c"]; c == nil || !reflect.DeepEqual(c.Snapshots, []string{child.ID}) {
+		t.Errorf("Expected inherited chunk to be referenced by %s", child.ID)
+	}
+}
+
+func TestSnapshotFollowSymlinks(t *testing.T) {
+	src, err := ioutil.TempDir("", "knoxite.source")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	if err := os.Mkdir(filepath.Join(src, "sub"), 0755); err != nil {
+		t.Fatalf("Failed creating dir: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "sub", "file"), []byte("content"), 0600); err != nil {
+		t.Fatalf("Failed creating file: %s", err)
+	}
+	links := map[string]string{
+		"dlink":    "sub",
+		"flink":    filepath.Join("sub", "file"),
+		"sub/loop": "..",
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(src, link)); err != nil {
+			t.Fatalf("Failed creating symlink: %s", err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, "this_is_a_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+
+	tests := []struct {
+		follow   bool
+		expected map[string]uint8
+	}{
+		{false, map[string]uint8{
+			"dlink": SymLink, "flink": SymLink, "sub/file": File, "sub/loop": SymLink,
+		}},
+		{true, map[string]uint8{
+			"dlink": Directory, "dlink/file": File, "dlink/loop": SymLink,
+			"flink": File, "sub/file": File, "sub/loop": SymLink,
+		}},
+	}
+	for _, tt := range tests {
+		snapshot, err := NewSnapshot("test_snapshot")
+		if err != nil {
+			t.Fatalf("Failed creating snapshot: %s", err)
+		}
+		opts := StoreOptions{
+			CWD:            src,
+			Paths:          []string{src},
+			Encrypt:        EncryptionAES,
+			DataParts:      1,
+			FollowSymlinks: tt.follow,
+		}
+		for p := range snapshot.Add(context.Background(), r, &index, opts) {
+			if p.Error != nil {
+				t.Errorf("Failed adding to snapshot: %s", p.Error)
+			}
+		}
+		if _, ok := snapshot.Archives["dlink/file"]; ok != tt.follow {
+			t.Errorf("Expected the content of dlink to be stored: %v", tt.follow)
+		}
+		for path, typ := range tt.expected {
+			arc, ok := snapshot.Archives[filepath.FromSlash(path)]
+			if !ok || arc.Type != typ {
+				t.Errorf("Expected %s to be stored as type %d (following links: %v)", path, typ, tt.follow)
+			}
+		}
+
+		// restoring recreates what has been stored, links or their targets
+		targetdir, err := ioutil.TempDir("", "knoxite.target")
+		if err != nil {
+			t.Fatalf("Failed creating temporary dir for restore: %s", err)
+		}
+		defer os.RemoveAll(targetdir)
+		progress, err := DecodeSnapshot(context.Background(), r, snapshot, targetdir, RestoreOptions{})
+		if err != nil {
+			t.Fatalf("Failed restoring snapshot: %s", err)
+		}
+		for p := range progress {
+			if p.Error != nil {
+				t.Errorf("Failed restoring snapshot: %s", p.Error)
+			}
+		}
+		for path, typ := range tt.expected {
+			fi, err := os.Lstat(filepath.Join(targetdir, filepath.FromSlash(path)))
+			if err != nil {
+				t.Errorf("Failed to stat restored %s: %s", path, err)
+				continue
+			}
+			if isSymLink(fi) != (typ == SymLink) || fi.IsDir() != (typ == Directory) {
+				t.Errorf("Expected %s to be restored as type %d, got mode %s", path, typ, fi.Mode())
+			}
+		}
+	}
+}
+
+func TestSnapshotStripPrefix(t *testing.T) {
+	src, err := ioutil.TempDir("", "knoxite.source")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	// a file system snapshot mounted at src/snap
+	mount := filepath.Join(src, "snap")
+	if err := os.MkdirAll(filepath.Join(mount, "home", "user"), 0755); err != nil {
+		t.Fatalf("Failed creating dir: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(mount, "home", "user", "file"), []byte("content"), 0600); err != nil {
+		t.Fatalf("Failed creating file: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, "this_is_a_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+
+	snapshot, err := NewSnapshot("test_snapshot")
+	if err != nil {
+		t.Fatalf("Failed creating snapshot: %s", err)
+	}
+	opts := StoreOptions{
+		CWD:         src,
+		Paths:       []string{filepath.Join(mount, "home")},
+		Encrypt:     EncryptionAES,
+		DataParts:   1,
+		StripPrefix: mount,
+	}
+	for p := range snapshot.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Errorf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+
+	home := filepath.Join(string(os.PathSeparator), "home")
+	if !reflect.DeepEqual(snapshot.Paths, []string{home}) {
+		t.Errorf("Expected the snapshot's paths to be %v, got %v", []string{home}, snapshot.Paths)
+	}
+	for _, path := range []string{home, filepath.Join(home, "user"), filepath.Join(home, "user", "file")} {
+		if _, ok := snapshot.Archives[path]; !ok {
+			t.Errorf("Expected %s to be stored", path)
+		}
+	}
+	if arc := snapshot.Archives[filepath.Join(home, "user", "file")]; arc != nil && arc.Size != 7 {
+		t.Errorf("Expected the file's content to be stored, got %d bytes", arc.Size)
+	}
+	if len(snapshot.Archives) != 3 {
+		t.Errorf("Expected 3 stored items, got %d", len(snapshot.Archives))
+	}
+
+	// paths outside of the prefix stay as they are
+	if p := opts.logicalPath(src); p != src {
+		t.Errorf("Expected %s to stay unchanged, got %s", src, p)
+	}
+	if p := opts.logicalPath(mount + "2"); p != mount+"2" {
+		t.Errorf("Expected %s to stay unchanged, got %s", mount+"2", p)
+	}
+}
+
+func TestSnapshotBaseline(t *testing.T) {
+	src, err := ioutil.TempDir("", "knoxite.source")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	mtime := time.Now().Add(-time.Hour)
+	// write changes the content of a file in place, keeping its inode
+	write := func(name, content string) {
+		path := filepath.Join(src, name)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0600)
+		if err != nil {
+			t.Fatalf("Failed opening test file: %s", err)
+		}
+		if _, err := f.WriteString(content); err != nil {
+			t.Fatalf("Failed writing test file: %s", err)
+		}
+		f.Close()
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatalf("Failed changing modification time: %s", err)
+		}
+	}
+	write("unmodified", "first content")
+	write("changed", "first content")
+	write("removed", "content")
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, "this_is_a_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	vol, err := NewVolume("test", "")
+	if err != nil {
+		t.Fatalf("Failed creating volume: %s", err)
+	}
+	if err := r.AddVolume(vol); err != nil {
+		t.Fatalf("Failed adding volume: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+
+	opts := StoreOptions{
+		CWD:       src,
+		Paths:     []string{src},
+		Encrypt:   EncryptionAES,
+		DataParts: 1,
+	}
+	store := func(opts StoreOptions) *Snapshot {
+		snapshot, err := NewSnapshot("test_snapshot")
+		if err != nil {
+			t.Fatalf("Failed creating snapshot: %s", err)
+		}
+		for p := range snapshot.Add(context.Background(), r, &index, opts) {
+			if p.Error != nil {
+				t.Fatalf("Failed adding to snapshot: %s", p.Error)
+			}
+		}
+		if err := snapshot.Save(&r); err != nil {
+			t.Fatalf("Failed saving snapshot: %s", err)
+		}
+		if err := vol.AddSnapshot(snapshot.ID); err != nil {
+			t.Fatalf("Failed adding snapshot to volume: %s", err)
+		}
+		return snapshot
+	}
+
+	if baseline, err := vol.FindBaseline(&r, opts); err != nil || baseline != nil {
+		t.Errorf("Expected no baseline in an empty volume, got %v, %v", baseline, err)
+	}
+	first := store(opts)
+	if other, err := vol.FindBaseline(&r, StoreOptions{CWD: src, Paths: []string{filepath.Join(src, "changed")}}); err != nil || other != nil {
+		t.Errorf("Expected no baseline for other paths, got %v, %v", other, err)
+	}
+	baseline, err := vol.FindBaseline(&r, opts)
+	if err != nil || baseline == nil || baseline.ID != first.ID {
+		t.Fatalf("Expected snapshot %s as baseline, got %v, %v", first.ID, baseline, err)
+	}
+
+	// the same size and modification time let a file appear unmodified, so
+	// its content doesn't get read again
+	write("unmodified", "other content")
+	mtime = mtime.Add(time.Minute)
+	write("changed", "other content")
+	write("new", "content")
+	if err := os.Remove(filepath.Join(src, "removed")); err != nil {
+		t.Fatalf("Failed removing test file: %s", err)
+	}
+
+	opts.Baseline = baseline
+	second := store(opts)
+	if second.Baseline != first.ID {
+		t.Errorf("Expected baseline %s to be recorded, got %s", first.ID, second.Baseline)
+	}
+	if second.Archives["unmodified"].Chunks[0].Hash != first.Archives["unmodified"].Chunks[0].Hash {
+		t.Error("Expected the chunks of the unmodified file to be reused")
+	}
+	if second.Archives["changed"].Chunks[0].Hash == first.Archives["changed"].Chunks[0].Hash {
+		t.Error("Expected the changed file to be stored again")
+	}
+
+	expected := ChangeStats{New: 1, Changed: 1, Unmodified: 1, Removed: 1}
+	if stats := CountChanges(first, second); stats != expected {
+		t.Errorf("Expected changes %+v, got %+v", expected, stats)
+	}
+}
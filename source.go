@@ -0,0 +1,91 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// SourceFactory is used to initialize a new source.
+type SourceFactory interface {
+	NewSource(url url.URL) (Source, error)
+	Protocols() []string
+}
+
+// Source provides the data which gets stored in a snapshot, e.g. the local
+// file system, a bucket or a mailbox.
+type Source interface {
+	// Location returns the type and location of the source
+	Location() string
+
+	// Protocols returns the Protocol Schemes supported by this source
+	Protocols() []string
+
+	// Description returns a user-friendly description for this source
+	Description() string
+
+	// Close the source
+	Close() error
+
+	// Scan finds all items at or below path. The items' paths get used as
+	// their paths in the snapshot, as well as to Open them. The returned
+	// channel gets closed once all items have been found or ctx is done
+	Scan(ctx context.Context, path string, opts StoreOptions) <-chan ArchiveResult
+	// Open returns a reader for the content of a File found by Scan. The
+	// caller must close it
+	Open(ctx context.Context, path string) (io.ReadCloser, error)
+}
+
+// Error declarations.
+var (
+	ErrInvalidSourceURL = errors.New("Invalid source url specified")
+
+	sources = []SourceFactory{}
+)
+
+// RegisterSource needs to be called by sources to register themselves.
+func RegisterSource(factory SourceFactory) {
+	sources = append(sources, factory)
+}
+
+// SourceProtocols returns the URL schemes supported by all registered
+// sources.
+func SourceProtocols() []string {
+	var protocols []string
+	for _, source := range sources {
+		protocols = append(protocols, source.Protocols()...)
+	}
+
+	return protocols
+}
+
+// SourceFromURL returns the matching source for a URL. Plain paths refer to
+// the local file system.
+func SourceFromURL(path string) (Source, error) {
+	if !strings.Contains(path, "://") {
+		return &SourceLocal{}, nil
+	}
+
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, source := range sources {
+		for _, p := range source.Protocols() {
+			if p == u.Scheme {
+				return source.NewSource(*u)
+			}
+		}
+	}
+
+	return nil, ErrInvalidSourceURL
+}
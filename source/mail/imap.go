@@ -0,0 +1,406 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package mail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knoxite/knoxite"
+)
+
+// IMAPSource reads the messages of an IMAP account. Each mailbox becomes a
+// Maildir, so restoring a snapshot results in a Maildir tree.
+type IMAPSource struct {
+	url    url.URL
+	client *imapClient
+
+	// mailboxes maps the paths of mailboxes to their names
+	mailboxes map[string]string
+	delimiter string
+	selected  string
+	validity  uint32
+
+	// the connection can only handle one command at a time
+	mut sync.Mutex
+}
+
+// imapMessage is the metadata of a single message.
+type imapMessage struct {
+	uid   uint32
+	flags []interface{}
+	date  time.Time
+	size  uint64
+}
+
+// Error declarations.
+var (
+	ErrInvalidMessageName = errors.New("Not a message stored from an IMAP account")
+	ErrUIDValidityChanged = errors.New("The UIDs of the mailbox have changed since it was scanned")
+)
+
+const imapDateLayout = "_2-Jan-2006 15:04:05 -0700"
+
+func init() {
+	knoxite.RegisterSource(&IMAPSource{})
+}
+
+// NewSource connects to an IMAP server and logs in.
+func (*IMAPSource) NewSource(u url.URL) (knoxite.Source, error) {
+	secure := u.Scheme == "imaps"
+	if _, port, err := net.SplitHostPort(u.Host); err != nil || len(port) == 0 {
+		port = "imap"
+		if secure {
+			port = "imaps"
+		}
+		u.Host = net.JoinHostPort(u.Host, port)
+	}
+
+	client, err := dialIMAP(u.Host, secure)
+	if err != nil {
+		return &IMAPSource{}, err
+	}
+
+	if u.User != nil {
+		pw, _ := u.User.Password()
+		if _, err := client.command("LOGIN", u.User.Username(), pw); err != nil {
+			client.Close()
+			return &IMAPSource{}, err
+		}
+		// don't leak the password through Location
+		u.User = url.User(u.User.Username())
+	}
+
+	return &IMAPSource{
+		url:       u,
+		client:    client,
+		mailboxes: make(map[string]string),
+		delimiter: "/",
+	}, nil
+}
+
+// Location returns the type and location of the source.
+func (s *IMAPSource) Location() string {
+	return s.url.String()
+}
+
+// Protocols returns the Protocol Schemes supported by this source.
+func (*IMAPSource) Protocols() []string {
+	return []string{"imap", "imaps"}
+}
+
+// Description returns a user-friendly description for this source.
+func (*IMAPSource) Description() string {
+	return "IMAP Account"
+}
+
+// Close logs out of the IMAP server.
+func (s *IMAPSource) Close() error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	_, _ = s.client.command("LOGOUT")
+	return s.client.Close()
+}
+
+// Scan finds all messages of the mailbox path and its children. An empty
+// path or "." selects all mailboxes of the account.
+func (s *IMAPSource) Scan(ctx context.Context, p string, opts knoxite.StoreOptions) <-chan knoxite.ArchiveResult {
+	ch := make(chan knoxite.ArchiveResult)
+	go func() {
+		defer close(ch)
+		send := func(r knoxite.ArchiveResult) bool {
+			select {
+			case ch <- r:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		if p == "." {
+			p = ""
+		}
+		mailboxes, err := s.list(strings.Trim(p, "/"))
+		if err != nil {
+			send(knoxite.ArchiveResult{Archive: &knoxite.Archive{Path: p}, Error: err})
+			return
+		}
+
+		for _, mailbox := range mailboxes {
+			if ctx.Err() != nil {
+				return
+			}
+
+			messages, validity, err := s.fetchMessages(mailbox)
+			if err != nil {
+				if !send(knoxite.ArchiveResult{Archive: &knoxite.Archive{Path: mailbox}, Error: err}) {
+					return
+				}
+				continue
+			}
+
+			for _, dir := range []string{"", "cur", "new", "tmp"} {
+				if !send(knoxite.ArchiveResult{Archive: s.archive(path.Join(mailbox, dir), knoxite.Directory, time.Time{}, 0)}) {
+					return
+				}
+			}
+			for _, m := range messages {
+				name := messageName(m, validity, s.url.Hostname())
+				if !send(knoxite.ArchiveResult{Archive: s.archive(path.Join(mailbox, "cur", name), knoxite.File, m.date, m.size)}) {
+					return
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+// Open fetches the content of a message found by Scan.
+func (s *IMAPSource) Open(ctx context.Context, p string) (io.ReadCloser, error) {
+	validity, uid, err := parseMessageName(path.Base(p))
+	if err != nil {
+		return nil, err
+	}
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if err := s.examine(path.Dir(path.Dir(p))); err != nil {
+		return nil, err
+	}
+	if validity != s.validity {
+		return nil, ErrUIDValidityChanged
+	}
+
+	responses, err := s.client.command("UID FETCH " + strconv.FormatUint(uint64(uid), 10) + " (BODY.PEEK[])")
+	if err != nil {
+		return nil, err
+	}
+	for _, resp := range responses {
+		items := fetchItems(resp)
+		if items == nil || items["UID"] != strconv.FormatUint(uint64(uid), 10) {
+			continue
+		}
+		if body, ok := items["BODY[]"].(string); ok {
+			return ioutil.NopCloser(bytes.NewBufferString(body)), nil
+		}
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// archive returns an item owned by the current user, as it'll be restored
+// as a Maildir of the user.
+func (s *IMAPSource) archive(p string, typ uint8, date time.Time, size uint64) *knoxite.Archive {
+	arc := &knoxite.Archive{
+		Path:    p,
+		Type:    typ,
+		Mode:    0600,
+		ModTime: date.Unix(),
+		Size:    size,
+		UID:     uint32(os.Getuid()),
+		GID:     uint32(os.Getgid()),
+	}
+	if typ == knoxite.Directory {
+		arc.Mode = os.ModeDir | 0700
+		arc.ModTime = time.Now().Unix()
+	}
+	return arc
+}
+
+// list returns the paths of all selectable mailboxes at or below prefix.
+func (s *IMAPSource) list(prefix string) ([]string, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	responses, err := s.client.command(`LIST "" "*"`)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, resp := range responses {
+		if len(resp.fields) < 4 || resp.status() != "LIST" {
+			continue
+		}
+		attrs, _ := resp.fields[1].([]interface{})
+		delimiter, _ := resp.fields[2].(string)
+		name, _ := resp.fields[3].(string)
+		if name == "" || hasFlag(attrs, `\Noselect`) || hasFlag(attrs, `\NonExistent`) {
+			continue
+		}
+		if delimiter != "" {
+			s.delimiter = delimiter
+		}
+
+		p := strings.Replace(name, s.delimiter, "/", -1)
+		s.mailboxes[p] = name
+		if prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/") {
+			paths = append(paths, p)
+		}
+	}
+
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// examine selects the mailbox at path read-only.
+func (s *IMAPSource) examine(p string) error {
+	if s.selected == p {
+		return nil
+	}
+
+	name, ok := s.mailboxes[p]
+	if !ok {
+		name = strings.Replace(p, "/", s.delimiter, -1)
+	}
+	responses, err := s.client.command("EXAMINE", name)
+	if err != nil {
+		s.selected = ""
+		return err
+	}
+
+	s.selected = p
+	s.validity = 0
+	for _, resp := range responses {
+		if v := resp.code("UIDVALIDITY"); v != "" {
+			n, err := strconv.ParseUint(v, 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid UIDVALIDITY %s", v)
+			}
+			s.validity = uint32(n)
+		}
+	}
+	return nil
+}
+
+// fetchMessages selects the mailbox at path and returns the metadata of its
+// messages, as well as its UIDVALIDITY.
+func (s *IMAPSource) fetchMessages(p string) ([]imapMessage, uint32, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.selected = ""
+	if err := s.examine(p); err != nil {
+		return nil, 0, err
+	}
+
+	responses, err := s.client.command("UID FETCH 1:* (UID FLAGS INTERNALDATE RFC822.SIZE)")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var messages []imapMessage
+	for _, resp := range responses {
+		items := fetchItems(resp)
+		if items == nil {
+			continue
+		}
+
+		m := imapMessage{}
+		uid, _ := items["UID"].(string)
+		n, err := strconv.ParseUint(uid, 10, 32)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid UID %s in mailbox %s", uid, p)
+		}
+		m.uid = uint32(n)
+		m.flags, _ = items["FLAGS"].([]interface{})
+		if date, ok := items["INTERNALDATE"].(string); ok {
+			m.date, _ = time.Parse(imapDateLayout, date)
+		}
+		if size, ok := items["RFC822.SIZE"].(string); ok {
+			m.size, _ = strconv.ParseUint(size, 10, 64)
+		}
+		messages = append(messages, m)
+	}
+
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].uid < messages[j].uid
+	})
+	return messages, s.validity, nil
+}
+
+// fetchItems returns the data items of a FETCH response by their names, or
+// nil if resp isn't one.
+func fetchItems(resp imapResponse) map[string]interface{} {
+	if len(resp.fields) != 3 {
+		return nil
+	}
+	if s, _ := resp.fields[1].(string); strings.ToUpper(s) != "FETCH" {
+		return nil
+	}
+	list, ok := resp.fields[2].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	items := make(map[string]interface{})
+	for i := 0; i+1 < len(list); i += 2 {
+		if name, ok := list[i].(string); ok {
+			items[strings.ToUpper(name)] = list[i+1]
+		}
+	}
+	return items
+}
+
+func hasFlag(flags []interface{}, flag string) bool {
+	for _, f := range flags {
+		if s, ok := f.(string); ok && strings.EqualFold(s, flag) {
+			return true
+		}
+	}
+	return false
+}
+
+// messageName returns the Maildir file name of a message. It only depends on
+// the message itself, so unchanged messages keep their name across runs.
+func messageName(m imapMessage, validity uint32, host string) string {
+	info := ""
+	for _, f := range []struct {
+		flag string
+		info string
+	}{
+		{`\Draft`, "D"}, {`\Flagged`, "F"}, {`\Answered`, "R"}, {`\Seen`, "S"}, {`\Deleted`, "T"},
+	} {
+		if hasFlag(m.flags, f.flag) {
+			info += f.info
+		}
+	}
+
+	return fmt.Sprintf("%d.V%dU%d.%s:2,%s", m.date.Unix(), validity, m.uid, host, info)
+}
+
+// parseMessageName returns the UIDVALIDITY and UID of a message's Maildir
+// file name.
+func parseMessageName(name string) (uint32, uint32, error) {
+	parts := strings.SplitN(name, ".", 3)
+	if len(parts) < 3 {
+		return 0, 0, ErrInvalidMessageName
+	}
+
+	var validity, uid uint32
+	if n, err := fmt.Sscanf(parts[1], "V%dU%d", &validity, &uid); err != nil || n != 2 {
+		return 0, 0, ErrInvalidMessageName
+	}
+	return validity, uid, nil
+}
@@ -0,0 +1,299 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package mail
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// imapClient speaks the small subset of IMAP4rev1 (RFC 3501) needed to read
+// mailboxes. It can only handle one command at a time.
+type imapClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// imapResponse is a single untagged response. Status responses only carry
+// their status in fields and the remaining line in text.
+type imapResponse struct {
+	fields []interface{}
+	text   string
+}
+
+// dialIMAP connects to an IMAP server, using TLS if secure is set.
+func dialIMAP(addr string, secure bool) (*imapClient, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+
+	var conn net.Conn
+	var err error
+	if secure {
+		host, _, _ := net.SplitHostPort(addr)
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: host})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &imapClient{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+	}
+	tag, resp, err := c.readResponse()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if tag != "*" || (resp.status() != "OK" && resp.status() != "PREAUTH") {
+		conn.Close()
+		return nil, fmt.Errorf("unexpected IMAP greeting: %s", resp.text)
+	}
+
+	return c, nil
+}
+
+// Close closes the connection.
+func (c *imapClient) Close() error {
+	return c.conn.Close()
+}
+
+// command sends a command and returns its untagged responses. Arguments get
+// sent as quoted strings.
+func (c *imapClient) command(cmd string, args ...string) ([]imapResponse, error) {
+	c.tag++
+	tag := "a" + strconv.Itoa(c.tag)
+
+	line := tag + " " + cmd
+	for _, arg := range args {
+		line += " " + quote(arg)
+	}
+	if _, err := io.WriteString(c.conn, line+"\r\n"); err != nil {
+		return nil, err
+	}
+
+	var responses []imapResponse
+	for {
+		t, resp, err := c.readResponse()
+		if err != nil {
+			return nil, err
+		}
+		switch t {
+		case "*":
+			if resp.status() == "BYE" && !strings.HasPrefix(cmd, "LOGOUT") {
+				return nil, fmt.Errorf("IMAP server closed the connection: %s", resp.text)
+			}
+			responses = append(responses, resp)
+		case tag:
+			if resp.status() != "OK" {
+				return nil, fmt.Errorf("IMAP command %s failed: %s", strings.Fields(cmd)[0], resp.text)
+			}
+			return responses, nil
+		}
+	}
+}
+
+// status returns the status of a status response.
+func (resp imapResponse) status() string {
+	if len(resp.fields) == 0 {
+		return ""
+	}
+	s, _ := resp.fields[0].(string)
+	return strings.ToUpper(s)
+}
+
+// code returns the value of a response code like [UIDVALIDITY 42].
+func (resp imapResponse) code(name string) string {
+	if !strings.HasPrefix(strings.ToUpper(resp.text), "["+name+" ") {
+		return ""
+	}
+	end := strings.IndexByte(resp.text, ']')
+	if end < 0 {
+		return ""
+	}
+	return resp.text[len(name)+2 : end]
+}
+
+// readResponse reads the next response and returns its tag.
+func (c *imapClient) readResponse() (string, imapResponse, error) {
+	resp := imapResponse{}
+	tag, err := c.readAtom()
+	if err != nil {
+		return "", resp, err
+	}
+	if tag == "+" {
+		resp.text, err = c.readLine()
+		return tag, resp, err
+	}
+	if err := c.skipSpace(); err != nil {
+		return "", resp, err
+	}
+
+	for {
+		b, err := c.r.Peek(1)
+		if err != nil {
+			return "", resp, err
+		}
+		if b[0] == '\r' || b[0] == '\n' {
+			_, err = c.readLine()
+			return tag, resp, err
+		}
+
+		v, err := c.readValue()
+		if err != nil {
+			return "", resp, err
+		}
+		resp.fields = append(resp.fields, v)
+
+		switch resp.status() {
+		case "OK", "NO", "BAD", "BYE", "PREAUTH":
+			if len(resp.fields) == 1 {
+				resp.text, err = c.readLine()
+				resp.text = strings.TrimSpace(resp.text)
+				return tag, resp, err
+			}
+		}
+		if err := c.skipSpace(); err != nil {
+			return "", resp, err
+		}
+	}
+}
+
+// readValue reads an atom, a quoted string, a literal or a parenthesized
+// list. NIL gets returned as nil.
+func (c *imapClient) readValue() (interface{}, error) {
+	b, err := c.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch b {
+	case '(':
+		var list []interface{}
+		for {
+			if err := c.skipSpace(); err != nil {
+				return nil, err
+			}
+			p, err := c.r.Peek(1)
+			if err != nil {
+				return nil, err
+			}
+			if p[0] == ')' {
+				_, err = c.r.ReadByte()
+				return list, err
+			}
+			v, err := c.readValue()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+
+	case '"':
+		var s strings.Builder
+		for {
+			b, err := c.r.ReadByte()
+			if err != nil {
+				return nil, err
+			}
+			switch b {
+			case '"':
+				return s.String(), nil
+			case '\\':
+				if b, err = c.r.ReadByte(); err != nil {
+					return nil, err
+				}
+			}
+			s.WriteByte(b)
+		}
+
+	case '{':
+		n, err := c.r.ReadString('}')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSuffix(n[:len(n)-1], "+"))
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid IMAP literal {%s", n)
+		}
+		if _, err := c.readLine(); err != nil {
+			return nil, err
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	}
+
+	if err := c.r.UnreadByte(); err != nil {
+		return nil, err
+	}
+	atom, err := c.readAtom()
+	if err != nil {
+		return nil, err
+	}
+	if strings.ToUpper(atom) == "NIL" {
+		return nil, nil
+	}
+	return atom, nil
+}
+
+// readAtom reads an atom. Brackets are part of atoms like BODY[HEADER].
+func (c *imapClient) readAtom() (string, error) {
+	var s strings.Builder
+	brackets := 0
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case b == '[':
+			brackets++
+		case b == ']' && brackets > 0:
+			brackets--
+		case brackets == 0 && (b == ' ' || b == '(' || b == ')' || b == '\r' || b == '\n'):
+			return s.String(), c.r.UnreadByte()
+		}
+		s.WriteByte(b)
+	}
+}
+
+func (c *imapClient) skipSpace() error {
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != ' ' {
+			return c.r.UnreadByte()
+		}
+	}
+}
+
+// readLine reads the remainder of the current line.
+func (c *imapClient) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// quote returns s as a quoted string.
+func quote(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	return `"` + s + `"`
+}
@@ -0,0 +1,166 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package mail
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/knoxite/knoxite"
+)
+
+type testMessage struct {
+	uid   int
+	flags string
+	body  string
+}
+
+var testMailboxes = map[string][]testMessage{
+	"INBOX": {
+		{7, `\Seen \Answered`, "Subject: first\r\n\r\nHello"},
+		{9, "", "Subject: second\r\n\r\nWorld"},
+	},
+	"Archive.2020": {
+		{1, `\Flagged`, "Subject: old\r\n\r\n\"quoted\" (parens)"},
+	},
+}
+
+// serveIMAP answers the commands sent by IMAPSource with testMailboxes.
+func serveIMAP(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	fmt.Fprint(conn, "* OK test server ready\r\n")
+
+	selected := ""
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		f := strings.SplitN(strings.TrimSpace(line), " ", 3)
+		tag, cmd, args := f[0], strings.ToUpper(f[1]), ""
+		if len(f) > 2 {
+			args = f[2]
+		}
+
+		switch cmd {
+		case "LOGIN":
+			if args != `"user" "pass"` {
+				fmt.Fprintf(conn, "%s NO invalid credentials\r\n", tag)
+				continue
+			}
+		case "LIST":
+			fmt.Fprint(conn, "* LIST (\\HasNoChildren) \".\" INBOX\r\n")
+			fmt.Fprint(conn, "* LIST (\\Noselect \\HasChildren) \".\" \"Archive\"\r\n")
+			fmt.Fprint(conn, "* LIST (\\HasNoChildren) \".\" \"Archive.2020\"\r\n")
+		case "EXAMINE":
+			selected = strings.Trim(args, `"`)
+			fmt.Fprintf(conn, "* %d EXISTS\r\n", len(testMailboxes[selected]))
+			fmt.Fprint(conn, "* OK [UIDVALIDITY 42] UIDs valid\r\n")
+		case "UID":
+			for i, m := range testMailboxes[selected] {
+				if strings.Contains(args, "BODY.PEEK[]") {
+					if !strings.HasPrefix(args, fmt.Sprintf("FETCH %d ", m.uid)) {
+						continue
+					}
+					fmt.Fprintf(conn, "* %d FETCH (UID %d BODY[] {%d}\r\n%s)\r\n", i+1, m.uid, len(m.body), m.body)
+					continue
+				}
+				fmt.Fprintf(conn, "* %d FETCH (UID %d FLAGS (%s) INTERNALDATE \"01-Feb-2020 10:00:00 +0000\" RFC822.SIZE %d)\r\n",
+					i+1, m.uid, m.flags, len(m.body))
+			}
+		case "LOGOUT":
+			fmt.Fprint(conn, "* BYE\r\n")
+			fmt.Fprintf(conn, "%s OK done\r\n", tag)
+			return
+		default:
+			fmt.Fprintf(conn, "%s BAD unknown command\r\n", tag)
+			continue
+		}
+		fmt.Fprintf(conn, "%s OK done\r\n", tag)
+	}
+}
+
+func newTestIMAPSource(t *testing.T, user string) (knoxite.Source, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err == nil {
+			serveIMAP(conn)
+		}
+	}()
+
+	u, _ := url.Parse("imap://" + user + "@" + l.Addr().String())
+	return knoxite.SourceFromURL(u.String())
+}
+
+func TestIMAPSource(t *testing.T) {
+	if _, err := newTestIMAPSource(t, "user:wrong"); err == nil {
+		t.Error("Expected login with wrong password to fail")
+	}
+
+	source, err := newTestIMAPSource(t, "user:pass")
+	if err != nil {
+		t.Fatalf("Failed connecting to IMAP server: %s", err)
+	}
+	defer source.Close()
+	if strings.Contains(source.Location(), "pass") {
+		t.Errorf("Location %s contains the password", source.Location())
+	}
+
+	var paths []string
+	for result := range source.Scan(context.Background(), "", knoxite.StoreOptions{}) {
+		if result.Error != nil {
+			t.Fatalf("Failed scanning mailboxes: %s", result.Error)
+		}
+		paths = append(paths, result.Archive.Path)
+	}
+
+	exp := []string{
+		"Archive/2020", "Archive/2020/cur", "Archive/2020/new", "Archive/2020/tmp",
+		"Archive/2020/cur/1580551200.V42U1.127.0.0.1:2,F",
+		"INBOX", "INBOX/cur", "INBOX/new", "INBOX/tmp",
+		"INBOX/cur/1580551200.V42U7.127.0.0.1:2,RS",
+		"INBOX/cur/1580551200.V42U9.127.0.0.1:2,",
+	}
+	if strings.Join(paths, "\n") != strings.Join(exp, "\n") {
+		t.Fatalf("Expected items %v, got %v", exp, paths)
+	}
+
+	for _, p := range []string{exp[4], exp[9], exp[10]} {
+		rc, err := source.Open(context.Background(), p)
+		if err != nil {
+			t.Fatalf("Failed opening %s: %s", p, err)
+		}
+		b, _ := ioutil.ReadAll(rc)
+		rc.Close()
+
+		_, uid, _ := parseMessageName(path.Base(p))
+		mailbox := strings.Replace(path.Dir(path.Dir(p)), "/", ".", -1)
+		for _, m := range testMailboxes[mailbox] {
+			if m.uid == int(uid) && m.body != string(b) {
+				t.Errorf("Expected message %q, got %q", m.body, string(b))
+			}
+		}
+	}
+
+	if _, err := source.Open(context.Background(), "INBOX/cur/1580551200.V41U7.127.0.0.1:2,RS"); err != ErrUIDValidityChanged {
+		t.Errorf("Expected error %v, got %v", ErrUIDValidityChanged, err)
+	}
+}
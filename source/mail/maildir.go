@@ -0,0 +1,107 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package mail
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/knoxite/knoxite"
+)
+
+// MaildirSource reads the messages of a local Maildir, including the Maildirs
+// of its folders. Files which aren't messages, like the index files of mail
+// servers, and messages still being delivered get skipped.
+type MaildirSource struct {
+	root  string
+	local knoxite.SourceLocal
+}
+
+func init() {
+	knoxite.RegisterSource(&MaildirSource{})
+}
+
+// NewSource returns a MaildirSource for the Maildir at the URL's path.
+func (*MaildirSource) NewSource(u url.URL) (knoxite.Source, error) {
+	fi, err := os.Stat(u.Path)
+	if err != nil {
+		return &MaildirSource{}, err
+	}
+	if !fi.IsDir() {
+		return &MaildirSource{}, knoxite.ErrInvalidSourceURL
+	}
+
+	return &MaildirSource{
+		root: filepath.Clean(u.Path),
+	}, nil
+}
+
+// Location returns the type and location of the source.
+func (s *MaildirSource) Location() string {
+	return s.root
+}
+
+// Protocols returns the Protocol Schemes supported by this source.
+func (*MaildirSource) Protocols() []string {
+	return []string{"maildir"}
+}
+
+// Description returns a user-friendly description for this source.
+func (*MaildirSource) Description() string {
+	return "Maildir"
+}
+
+// Close the source.
+func (*MaildirSource) Close() error {
+	return nil
+}
+
+// Scan finds all messages at or below path, which is relative to the root of
+// the Maildir. The items' paths are relative to it as well.
+func (s *MaildirSource) Scan(ctx context.Context, path string, opts knoxite.StoreOptions) <-chan knoxite.ArchiveResult {
+	ch := make(chan knoxite.ArchiveResult)
+	go func() {
+		defer close(ch)
+		for result := range s.local.Scan(ctx, filepath.Join(s.root, path), opts) {
+			if result.Error == nil {
+				rel, err := filepath.Rel(s.root, result.Archive.Path)
+				if err != nil || rel == "." || !isMaildirItem(rel, result.Archive.Type) {
+					continue
+				}
+				result.Archive.Path = rel
+			}
+
+			select {
+			case ch <- result:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// Open opens a message.
+func (s *MaildirSource) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	return s.local.Open(ctx, filepath.Join(s.root, path))
+}
+
+// isMaildirItem reports whether an item belongs into a backup of a Maildir:
+// directories, and files stored in cur or new.
+func isMaildirItem(path string, typ uint8) bool {
+	if typ == knoxite.Directory {
+		return filepath.Base(filepath.Dir(path)) != "tmp"
+	}
+
+	dir := filepath.Base(filepath.Dir(path))
+	return dir == "cur" || dir == "new"
+}
@@ -0,0 +1,116 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package mail
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/knoxite/knoxite"
+)
+
+func TestMaildirSource(t *testing.T) {
+	maildir, err := ioutil.TempDir("", "knoxite.maildir")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for maildir: %s", err)
+	}
+	defer os.RemoveAll(maildir)
+
+	files := map[string]string{
+		"cur/1.host:2,S":        "first",
+		"new/2.host":            "second",
+		"tmp/3.host":            "incomplete",
+		".Sent/cur/4.host:2,S":  "reply",
+		"dovecot.index":         "index",
+		".Sent/dovecot-uidlist": "uids",
+	}
+	for name, content := range files {
+		p := filepath.Join(maildir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	source, err := knoxite.SourceFromURL("maildir://" + filepath.ToSlash(maildir))
+	if err != nil {
+		t.Fatalf("Failed creating source: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := knoxite.NewRepository(dir, "this_is_a_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	index, err := knoxite.OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	snapshot, err := knoxite.NewSnapshot("test_snapshot")
+	if err != nil {
+		t.Fatalf("Failed creating snapshot: %s", err)
+	}
+
+	opts := knoxite.StoreOptions{
+		Paths:     []string{"."},
+		Encrypt:   knoxite.EncryptionAES,
+		DataParts: 1,
+		Source:    source,
+	}
+	for p := range snapshot.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Errorf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+	if snapshot.Stats.Files != 3 {
+		t.Errorf("Expected 3 messages in snapshot, got %d", snapshot.Stats.Files)
+	}
+
+	targetdir, err := ioutil.TempDir("", "knoxite.target")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for restore: %s", err)
+	}
+	defer os.RemoveAll(targetdir)
+
+	progress, err := knoxite.DecodeSnapshot(context.Background(), r, snapshot, targetdir, knoxite.RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Failed restoring snapshot: %s", err)
+	}
+	for p := range progress {
+		if p.Error != nil {
+			t.Errorf("Failed restoring snapshot: %s", p.Error)
+		}
+	}
+
+	for name, content := range files {
+		b, err := ioutil.ReadFile(filepath.Join(targetdir, filepath.FromSlash(name)))
+		switch filepath.Base(filepath.Dir(name)) {
+		case "cur", "new":
+			if err != nil || string(b) != content {
+				t.Errorf("Failed restoring message %s: %v", name, err)
+			}
+		default:
+			if err == nil {
+				t.Errorf("Expected %s not to be stored", name)
+			}
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(targetdir, "tmp")); err != nil || !fi.IsDir() {
+		t.Errorf("Expected the tmp directory to be restored: %v", err)
+	}
+}
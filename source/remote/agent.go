@@ -0,0 +1,85 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package remote
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+
+	"github.com/knoxite/knoxite"
+)
+
+// scanRequest is sent to the agent to scan a path on the remote host.
+type scanRequest struct {
+	Path             string             `json:"path"`
+	Excludes         []string           `json:"excludes,omitempty"`
+	ExcludeFiles     []string           `json:"exclude_files,omitempty"`
+	ExcludeMarkers   []string           `json:"exclude_markers,omitempty"`
+	ExcludeCaches    bool               `json:"exclude_caches,omitempty"`
+	OneFileSystem    bool               `json:"one_file_system,omitempty"`
+	FollowSymlinks   bool               `json:"follow_symlinks,omitempty"`
+	AlternateStreams bool               `json:"alternate_streams,omitempty"`
+	Limits           knoxite.ScanLimits `json:"limits"`
+}
+
+// scanResult is a single item found by the agent, or an error.
+type scanResult struct {
+	Archive *knoxite.Archive `json:"archive"`
+	Error   string           `json:"error,omitempty"`
+	// Skipped is the reason the item has been skipped by the scanner
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// ServeScan handles a scan request of a RemoteSource on the remote host. It
+// reads the request from r and writes the items found to w.
+func ServeScan(ctx context.Context, r io.Reader, w io.Writer) error {
+	var req scanRequest
+	if err := json.NewDecoder(r).Decode(&req); err != nil {
+		return err
+	}
+
+	opts := knoxite.StoreOptions{
+		Excludes:         req.Excludes,
+		ExcludeFiles:     req.ExcludeFiles,
+		ExcludeMarkers:   req.ExcludeMarkers,
+		ExcludeCaches:    req.ExcludeCaches,
+		OneFileSystem:    req.OneFileSystem,
+		FollowSymlinks:   req.FollowSymlinks,
+		AlternateStreams: req.AlternateStreams,
+		Limits:           req.Limits,
+	}
+
+	enc := json.NewEncoder(w)
+	for result := range (&knoxite.SourceLocal{}).Scan(ctx, req.Path, opts) {
+		res := scanResult{Archive: result.Archive}
+		if skipped, ok := result.Error.(*knoxite.SkippedError); ok {
+			res.Skipped = skipped.Reason
+		} else if result.Error != nil {
+			res.Error = result.Error.Error()
+		}
+		if err := enc.Encode(res); err != nil {
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
+
+// ServeCat writes the content of a file on the remote host to w.
+func ServeCat(path string, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
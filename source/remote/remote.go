@@ -0,0 +1,359 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	kh "golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/knoxite/knoxite"
+)
+
+// RemoteSource pulls the data of a remote host over SSH. It runs knoxite's
+// agent on the remote host, which scans the stored paths and streams the
+// content of files back.
+type RemoteSource struct {
+	url   url.URL
+	ssh   *ssh.Client
+	agent string
+}
+
+// Error declarations.
+var (
+	ErrRemotePlatform = errors.New("Can't upload the agent, the remote host runs on a different platform")
+)
+
+// knownHostsPath returns the path of the known_hosts file used to verify the
+// keys of remote hosts.
+var knownHostsPath = func() string {
+	usr, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(usr.HomeDir, ".ssh", "known_hosts")
+}
+
+// agentUploadPath is where an uploaded agent gets stored, relative to the
+// remote user's home directory.
+const agentUploadPath = ".knoxite-agent"
+
+func init() {
+	knoxite.RegisterSource(&RemoteSource{})
+}
+
+// NewSource connects to the host of a URL like ssh://user@host. The agent
+// query parameter sets the command starting knoxite on the remote host, with
+// upload=true the running knoxite binary gets uploaded and used instead.
+func (*RemoteSource) NewSource(u url.URL) (knoxite.Source, error) {
+	_, port, err := net.SplitHostPort(u.Host)
+	if err != nil || len(port) == 0 {
+		port = "22"
+		u.Host = net.JoinHostPort(u.Host, port)
+	}
+	username := u.User.Username()
+	password, isSet := u.User.Password()
+
+	auth := []ssh.AuthMethod{}
+	if isSet {
+		auth = append(auth, ssh.Password(password))
+	} else {
+		conn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+		if err != nil {
+			return &RemoteSource{}, knoxite.ErrInvalidPassword
+		}
+		auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+
+	// if no known_hosts file can be found, ignore the host key for now...
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if cb, err := kh.New(knownHostsPath()); err == nil {
+		hostKeyCallback = cb
+	}
+
+	conn, err := ssh.Dial("tcp", u.Host, &ssh.ClientConfig{
+		User:            username,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		return &RemoteSource{}, err
+	}
+
+	s := &RemoteSource{
+		ssh:   conn,
+		agent: u.Query().Get("agent"),
+	}
+	if s.agent == "" {
+		s.agent = "knoxite"
+	}
+	if u.Query().Get("upload") == "true" {
+		if err := s.upload(); err != nil {
+			conn.Close()
+			return &RemoteSource{}, err
+		}
+	}
+
+	// don't leak the password through Location
+	u.User = url.User(username)
+	s.url = u
+
+	return s, nil
+}
+
+// Location returns the type and location of the source.
+func (s *RemoteSource) Location() string {
+	return s.url.String()
+}
+
+// Protocols returns the Protocol Schemes supported by this source.
+func (*RemoteSource) Protocols() []string {
+	return []string{"ssh"}
+}
+
+// Description returns a user-friendly description for this source.
+func (*RemoteSource) Description() string {
+	return "Remote Host (SSH)"
+}
+
+// Close the SSH connection.
+func (s *RemoteSource) Close() error {
+	return s.ssh.Close()
+}
+
+// Scan lets the agent scan path on the remote host.
+func (s *RemoteSource) Scan(ctx context.Context, path string, opts knoxite.StoreOptions) <-chan knoxite.ArchiveResult {
+	ch := make(chan knoxite.ArchiveResult)
+	go func() {
+		defer close(ch)
+		send := func(r knoxite.ArchiveResult) bool {
+			select {
+			case ch <- r:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		fail := func(err error) {
+			send(knoxite.ArchiveResult{Archive: &knoxite.Archive{Path: path}, Error: err})
+		}
+
+		req, err := json.Marshal(scanRequest{
+			Path:             path,
+			Excludes:         opts.Excludes,
+			ExcludeFiles:     opts.ExcludeFiles,
+			ExcludeMarkers:   opts.ExcludeMarkers,
+			ExcludeCaches:    opts.ExcludeCaches,
+			OneFileSystem:    opts.OneFileSystem,
+			FollowSymlinks:   opts.FollowSymlinks,
+			AlternateStreams: opts.AlternateStreams,
+			Limits:           opts.Limits,
+		})
+		if err != nil {
+			fail(err)
+			return
+		}
+
+		rc, err := s.run(bytes.NewReader(req), "agent", "scan")
+		if err != nil {
+			fail(err)
+			return
+		}
+		defer rc.Close()
+
+		dec := json.NewDecoder(bufio.NewReader(rc))
+		for {
+			var res scanResult
+			if err := dec.Decode(&res); err == io.EOF {
+				return
+			} else if err != nil {
+				fail(err)
+				return
+			}
+
+			result := knoxite.ArchiveResult{Archive: res.Archive}
+			if result.Archive == nil {
+				result.Archive = &knoxite.Archive{Path: path}
+			}
+			if res.Skipped != "" {
+				result.Error = &knoxite.SkippedError{Path: result.Archive.Path, Reason: res.Skipped}
+			} else if res.Error != "" {
+				result.Error = errors.New(res.Error)
+			}
+			if !send(result) {
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// Open streams the content of a file on the remote host.
+func (s *RemoteSource) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	return s.run(nil, "agent", "cat", path)
+}
+
+// run starts the agent in a new session. The returned reader provides its
+// output and fails if the agent does.
+func (s *RemoteSource) run(stdin io.Reader, args ...string) (io.ReadCloser, error) {
+	session, err := s.ssh.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stderr := &bytes.Buffer{}
+	session.Stdin = stdin
+	session.Stderr = stderr
+
+	cmd := []string{shellQuote(s.agent)}
+	for _, arg := range args {
+		cmd = append(cmd, shellQuote(arg))
+	}
+	if err := session.Start(strings.Join(cmd, " ")); err != nil {
+		session.Close()
+		return nil, err
+	}
+
+	return &sessionReader{session: session, stdout: stdout, stderr: stderr}, nil
+}
+
+// upload copies the running knoxite binary to the remote host and uses it
+// as the agent.
+func (s *RemoteSource) upload() error {
+	platform, err := s.output("uname -sm")
+	if err != nil {
+		return err
+	}
+	if !samePlatform(platform) {
+		return ErrRemotePlatform
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	local, err := os.Open(exe)
+	if err != nil {
+		return err
+	}
+	defer local.Close()
+	fi, err := local.Stat()
+	if err != nil {
+		return err
+	}
+
+	client, err := sftp.NewClient(s.ssh)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	s.agent = "./" + agentUploadPath
+	if rfi, err := client.Stat(agentUploadPath); err == nil && rfi.Size() == fi.Size() {
+		// already uploaded
+		return nil
+	}
+
+	remote, err := client.Create(agentUploadPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(remote, local); err != nil {
+		remote.Close()
+		return err
+	}
+	if err := remote.Close(); err != nil {
+		return err
+	}
+	return client.Chmod(agentUploadPath, 0700)
+}
+
+// output runs a command on the remote host and returns its output.
+func (s *RemoteSource) output(cmd string) (string, error) {
+	session, err := s.ssh.NewSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.Close()
+
+	b, err := session.Output(cmd)
+	return strings.TrimSpace(string(b)), err
+}
+
+// samePlatform reports whether the output of uname -sm matches the platform
+// knoxite has been built for.
+func samePlatform(uname string) bool {
+	f := strings.Fields(strings.ToLower(uname))
+	if len(f) != 2 || f[0] != runtime.GOOS {
+		return false
+	}
+
+	arch := map[string]string{
+		"x86_64":  "amd64",
+		"amd64":   "amd64",
+		"aarch64": "arm64",
+		"arm64":   "arm64",
+		"i386":    "386",
+		"i686":    "386",
+	}[f[1]]
+	if strings.HasPrefix(f[1], "armv") {
+		arch = "arm"
+	}
+	return arch == runtime.GOARCH
+}
+
+// sessionReader reads the output of an agent. Once all output has been read,
+// it reports the agent's failure, if any.
+type sessionReader struct {
+	session *ssh.Session
+	stdout  io.Reader
+	stderr  *bytes.Buffer
+}
+
+func (r *sessionReader) Read(p []byte) (int, error) {
+	n, err := r.stdout.Read(p)
+	if err == io.EOF {
+		if werr := r.session.Wait(); werr != nil {
+			if msg := strings.TrimSpace(r.stderr.String()); msg != "" {
+				return n, fmt.Errorf("remote agent failed: %s", msg)
+			}
+			return n, fmt.Errorf("remote agent failed: %v", werr)
+		}
+	}
+	return n, err
+}
+
+func (r *sessionReader) Close() error {
+	return r.session.Close()
+}
+
+// shellQuote quotes s for the remote shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
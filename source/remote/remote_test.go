@@ -0,0 +1,171 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package remote
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/knoxite/knoxite"
+)
+
+// serveSSH accepts a single connection and runs the agent for each command
+// it receives.
+func serveSSH(l net.Listener, config *ssh.ServerConfig) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer ch.Close()
+			for req := range reqs {
+				if req.Type != "exec" {
+					_ = req.Reply(false, nil)
+					continue
+				}
+				var payload struct{ Command string }
+				_ = ssh.Unmarshal(req.Payload, &payload)
+				_ = req.Reply(true, nil)
+
+				// arguments are quoted like 'knoxite' 'agent' 'cat' 'path'
+				args := strings.Split(strings.Trim(payload.Command, "'"), "' '")
+				err := errors.New("unknown command")
+				switch {
+				case len(args) == 3 && args[2] == "scan":
+					err = ServeScan(context.Background(), ch, ch)
+				case len(args) == 4 && args[2] == "cat":
+					err = ServeCat(args[3], ch)
+				}
+
+				status := uint32(0)
+				if err != nil {
+					fmt.Fprintln(ch.Stderr(), err)
+					status = 1
+				}
+				_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				return
+			}
+		}()
+	}
+}
+
+func TestRemoteSource(t *testing.T) {
+	src, err := ioutil.TempDir("", "knoxite.source")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+	if err := os.Mkdir(filepath.Join(src, "dir"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "dir", "file"), []byte("remote content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() != "user" || string(pass) != "pass" {
+				return nil, errors.New("invalid credentials")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go serveSSH(l, config)
+
+	// only accept the server's host key
+	hosts := filepath.Join(src, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(l.Addr().String())}, signer.PublicKey())
+	if err := ioutil.WriteFile(hosts, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	knownHostsPath = func() string { return hosts }
+
+	source, err := knoxite.SourceFromURL("ssh://user:pass@" + l.Addr().String() + "?agent=knoxite")
+	if err != nil {
+		t.Fatalf("Failed connecting to remote host: %s", err)
+	}
+	defer source.Close()
+	if strings.Contains(source.Location(), "pass") {
+		t.Errorf("Location %s contains the password", source.Location())
+	}
+
+	var paths []string
+	for result := range source.Scan(context.Background(), src, knoxite.StoreOptions{Excludes: []string{"known_hosts"}}) {
+		if result.Error != nil {
+			t.Fatalf("Failed scanning remote host: %s", result.Error)
+		}
+		paths = append(paths, result.Archive.Path)
+	}
+	exp := []string{src, filepath.Join(src, "dir"), filepath.Join(src, "dir", "file")}
+	if strings.Join(paths, "\n") != strings.Join(exp, "\n") {
+		t.Fatalf("Expected items %v, got %v", exp, paths)
+	}
+
+	rc, err := source.Open(context.Background(), filepath.Join(src, "dir", "file"))
+	if err != nil {
+		t.Fatalf("Failed opening remote file: %s", err)
+	}
+	b, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(b) != "remote content" {
+		t.Errorf("Expected remote content, got %q: %v", string(b), err)
+	}
+
+	rc, err = source.Open(context.Background(), filepath.Join(src, "missing"))
+	if err == nil {
+		_, err = ioutil.ReadAll(rc)
+		rc.Close()
+	}
+	if err == nil {
+		t.Errorf("Expected reading a missing remote file to fail")
+	}
+}
+
+func TestSamePlatform(t *testing.T) {
+	if samePlatform("Plan9 mips") {
+		t.Errorf("Expected a different platform")
+	}
+	if samePlatform("") {
+		t.Errorf("Expected empty output not to match")
+	}
+}
@@ -0,0 +1,194 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package s3
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go"
+
+	"github.com/knoxite/knoxite"
+	s3storage "github.com/knoxite/knoxite/storage/s3"
+)
+
+// S3Source reads the objects of an S3 or S3 compatible bucket. Objects get
+// streamed straight into the repository, without staging them on local disk.
+type S3Source struct {
+	url    url.URL
+	bucket string
+	prefix string
+	client *minio.Client
+}
+
+// Error declarations.
+var (
+	ErrInvalidBucket = errors.New("No bucket specified")
+)
+
+func init() {
+	knoxite.RegisterSource(&S3Source{})
+}
+
+// NewSource returns a S3Source for a URL like s3s://host/bucket/prefix. The
+// region can be passed with the region query parameter.
+func (*S3Source) NewSource(u url.URL) (knoxite.Source, error) {
+	creds, err := s3storage.NewCredentials(u.User)
+	if err != nil {
+		return &S3Source{}, err
+	}
+
+	bucketAndPrefix := strings.SplitN(strings.Trim(u.Path, "/"), "/", 2)
+	if bucketAndPrefix[0] == "" {
+		return &S3Source{}, ErrInvalidBucket
+	}
+
+	cl, err := minio.NewWithCredentials(u.Host, creds, u.Scheme == "s3s", u.Query().Get("region"))
+	if err != nil {
+		return &S3Source{}, err
+	}
+
+	s := &S3Source{
+		client: cl,
+		bucket: bucketAndPrefix[0],
+	}
+	if len(bucketAndPrefix) > 1 {
+		s.prefix = bucketAndPrefix[1]
+	}
+	// don't leak the credentials through Location
+	u.User = nil
+	s.url = u
+
+	return s, nil
+}
+
+// Location returns the type and location of the source.
+func (s *S3Source) Location() string {
+	return s.url.String()
+}
+
+// Protocols returns the Protocol Schemes supported by this source.
+func (*S3Source) Protocols() []string {
+	return []string{"s3", "s3s"}
+}
+
+// Description returns a user-friendly description for this source.
+func (*S3Source) Description() string {
+	return "Amazon S3 Bucket"
+}
+
+// Close the source.
+func (*S3Source) Close() error {
+	return nil
+}
+
+// Scan lists all objects at or below path, which is relative to the bucket
+// and prefix of the source's URL. Directories get derived from the objects'
+// keys.
+func (s *S3Source) Scan(ctx context.Context, p string, opts knoxite.StoreOptions) <-chan knoxite.ArchiveResult {
+	ch := make(chan knoxite.ArchiveResult)
+	go func() {
+		defer close(ch)
+		send := func(r knoxite.ArchiveResult) bool {
+			select {
+			case ch <- r:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		p = strings.Trim(path.Clean("/"+p), "/")
+		prefix := s.key(p)
+		if prefix != "" {
+			prefix += "/"
+		}
+
+		done := make(chan struct{})
+		defer close(done)
+
+		dirs := make(map[string]bool)
+		for obj := range s.client.ListObjectsV2(s.bucket, prefix, true, done) {
+			if obj.Err != nil {
+				send(knoxite.ArchiveResult{Archive: &knoxite.Archive{Path: p}, Error: obj.Err})
+				return
+			}
+
+			name := strings.TrimPrefix(strings.TrimPrefix(obj.Key, s.key("")), "/")
+			isDir := strings.HasSuffix(name, "/")
+			name = strings.TrimSuffix(name, "/")
+			if name == "" {
+				continue
+			}
+
+			// report each directory once, before its content
+			var parents []string
+			for dir := name; dir != "." && !dirs[dir]; dir = path.Dir(dir) {
+				if dir != name || isDir {
+					parents = append(parents, dir)
+				}
+				dirs[dir] = true
+			}
+			for i := len(parents) - 1; i >= 0; i-- {
+				date := time.Now()
+				if parents[i] == name {
+					date = obj.LastModified
+				}
+				if !send(knoxite.ArchiveResult{Archive: archive(parents[i], knoxite.Directory, date, 0)}) {
+					return
+				}
+			}
+
+			if !isDir {
+				if !send(knoxite.ArchiveResult{Archive: archive(name, knoxite.File, obj.LastModified, uint64(obj.Size))}) {
+					return
+				}
+			}
+		}
+	}()
+
+	return ch
+}
+
+// Open returns a reader streaming an object's content.
+func (s *S3Source) Open(ctx context.Context, p string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObjectWithContext(ctx, s.bucket, s.key(p), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// key returns the object key of path.
+func (s *S3Source) key(p string) string {
+	return strings.Trim(path.Join(s.prefix, p), "/")
+}
+
+// archive returns an item owned by the current user, as objects don't have
+// owners on the local system.
+func archive(p string, typ uint8, date time.Time, size uint64) *knoxite.Archive {
+	arc := &knoxite.Archive{
+		Path:    p,
+		Type:    typ,
+		Mode:    0600,
+		ModTime: date.Unix(),
+		Size:    size,
+		UID:     uint32(os.Getuid()),
+		GID:     uint32(os.Getgid()),
+	}
+	if typ == knoxite.Directory {
+		arc.Mode = os.ModeDir | 0700
+	}
+	return arc
+}
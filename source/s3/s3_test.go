@@ -0,0 +1,107 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package s3
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/knoxite/knoxite"
+)
+
+var testObjects = map[string]string{
+	"photos/2020/beach.jpg":  "beach",
+	"photos/2020/forest.jpg": "forest",
+	"photos/empty/":          "",
+	"photos/readme.txt":      "readme",
+	"other/file":             "other",
+}
+
+// serveS3 answers ListObjectsV2 and GetObject requests for the bucket
+// "bucket" with testObjects.
+func serveS3(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+	if r.URL.Query().Get("list-type") == "2" {
+		prefix := r.URL.Query().Get("prefix")
+		var keys []string
+		for k := range testObjects {
+			if strings.HasPrefix(k, prefix) {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult><Name>bucket</Name><IsTruncated>false</IsTruncated>`)
+		for _, k := range keys {
+			fmt.Fprintf(w, `<Contents><Key>%s</Key><LastModified>2020-02-01T10:00:00.000Z</LastModified><ETag>"x"</ETag><Size>%d</Size></Contents>`,
+				k, len(testObjects[k]))
+		}
+		fmt.Fprint(w, `</ListBucketResult>`)
+		return
+	}
+
+	data, ok := testObjects[key]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code></Error>`)
+		return
+	}
+	w.Header().Set("Last-Modified", "Sat, 01 Feb 2020 10:00:00 GMT")
+	w.Header().Set("ETag", `"x"`)
+	w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+	fmt.Fprint(w, data)
+}
+
+func TestS3Source(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(serveS3))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	source, err := knoxite.SourceFromURL("s3://key:secret@" + u.Host + "/bucket/photos?region=us-east-1")
+	if err != nil {
+		t.Fatalf("Failed creating source: %s", err)
+	}
+	if strings.Contains(source.Location(), "secret") {
+		t.Errorf("Location %s contains the credentials", source.Location())
+	}
+
+	var paths []string
+	for result := range source.Scan(context.Background(), ".", knoxite.StoreOptions{}) {
+		if result.Error != nil {
+			t.Fatalf("Failed scanning bucket: %s", result.Error)
+		}
+		paths = append(paths, fmt.Sprintf("%s %d", result.Archive.Path, result.Archive.Type))
+	}
+	exp := []string{
+		fmt.Sprintf("2020 %d", knoxite.Directory),
+		fmt.Sprintf("2020/beach.jpg %d", knoxite.File),
+		fmt.Sprintf("2020/forest.jpg %d", knoxite.File),
+		fmt.Sprintf("empty %d", knoxite.Directory),
+		fmt.Sprintf("readme.txt %d", knoxite.File),
+	}
+	if strings.Join(paths, "\n") != strings.Join(exp, "\n") {
+		t.Fatalf("Expected items %v, got %v", exp, paths)
+	}
+
+	rc, err := source.Open(context.Background(), "2020/forest.jpg")
+	if err != nil {
+		t.Fatalf("Failed opening object: %s", err)
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil || string(b) != "forest" {
+		t.Errorf("Expected object content %q, got %q: %v", "forest", string(b), err)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+)
+
+// SourceLocal provides the data of the local file system. It's used unless
+// another source is specified in StoreOptions.
+type SourceLocal struct{}
+
+func init() {
+	RegisterSource(&SourceLocal{})
+}
+
+// NewSource returns a SourceLocal source.
+func (*SourceLocal) NewSource(u url.URL) (Source, error) {
+	return &SourceLocal{}, nil
+}
+
+// Location returns the type and location of the source.
+func (*SourceLocal) Location() string {
+	return "/"
+}
+
+// Protocols returns the Protocol Schemes supported by this source.
+func (*SourceLocal) Protocols() []string {
+	return []string{"file"}
+}
+
+// Description returns a user-friendly description for this source.
+func (*SourceLocal) Description() string {
+	return "Local File System"
+}
+
+// Close the source.
+func (*SourceLocal) Close() error {
+	return nil
+}
+
+// Scan walks the directory tree at path. It skips all items excluded by opts
+// and those exceeding opts.Limits.
+func (*SourceLocal) Scan(ctx context.Context, path string, opts StoreOptions) <-chan ArchiveResult {
+	filter, err := newExcludeFilter(opts)
+	if err != nil {
+		ch := make(chan ArchiveResult, 1)
+		ch <- ArchiveResult{Archive: &Archive{Path: path}, Error: err}
+		close(ch)
+		return ch
+	}
+
+	return findFiles(ctx, path, filter, opts.Limits, opts.AlternateStreams, opts.FollowSymlinks)
+}
+
+// Open opens a file.
+func (*SourceLocal) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	return os.Open(path)
+}
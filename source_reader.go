@@ -0,0 +1,93 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// SourceReader provides a single file, whose content gets read from a reader,
+// e.g. a database dump piped to stdin. The file's size is unknown until all of
+// its content has been read.
+type SourceReader struct {
+	name string
+	r    io.Reader
+	date time.Time
+
+	mut  sync.Mutex
+	read bool
+}
+
+// Error declarations.
+var (
+	ErrSourceReaderConsumed = errors.New("The content of the reader has already been read")
+)
+
+// NewSourceReader returns a source providing the content of r as a file
+// called name.
+func NewSourceReader(name string, r io.Reader) *SourceReader {
+	return &SourceReader{
+		name: name,
+		r:    r,
+		date: time.Now(),
+	}
+}
+
+// Location returns the type and location of the source.
+func (s *SourceReader) Location() string {
+	return s.name
+}
+
+// Protocols returns the Protocol Schemes supported by this source.
+func (*SourceReader) Protocols() []string {
+	return []string{}
+}
+
+// Description returns a user-friendly description for this source.
+func (*SourceReader) Description() string {
+	return "Reader"
+}
+
+// Close the source.
+func (*SourceReader) Close() error {
+	return nil
+}
+
+// Scan returns the single file of the source, no matter which path gets
+// scanned.
+func (s *SourceReader) Scan(ctx context.Context, path string, opts StoreOptions) <-chan ArchiveResult {
+	ch := make(chan ArchiveResult, 1)
+	ch <- ArchiveResult{Archive: &Archive{
+		Path:    s.name,
+		Type:    File,
+		Mode:    0600,
+		ModTime: s.date.Unix(),
+		UID:     uint32(os.Getuid()),
+		GID:     uint32(os.Getgid()),
+	}}
+	close(ch)
+	return ch
+}
+
+// Open returns the reader. It can only be opened once.
+func (s *SourceReader) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.read {
+		return nil, ErrSourceReaderConsumed
+	}
+	s.read = true
+	return ioutil.NopCloser(s.r), nil
+}
@@ -0,0 +1,184 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// sourceMemory provides data kept in memory, e.g. the messages of a mailbox.
+type sourceMemory struct {
+	files map[string]string
+}
+
+func (*sourceMemory) NewSource(u url.URL) (Source, error) {
+	return &sourceMemory{files: map[string]string{
+		"INBOX/1": "first message",
+		"INBOX/2": "second message",
+		"Sent/1":  "reply",
+	}}, nil
+}
+
+func (*sourceMemory) Location() string    { return "memory" }
+func (*sourceMemory) Protocols() []string { return []string{"memory"} }
+func (*sourceMemory) Description() string { return "Memory" }
+func (*sourceMemory) Close() error        { return nil }
+
+func (s *sourceMemory) Scan(ctx context.Context, path string, opts StoreOptions) <-chan ArchiveResult {
+	ch := make(chan ArchiveResult)
+	go func() {
+		defer close(ch)
+		ch <- ArchiveResult{Archive: &Archive{Path: path, Type: Directory, Mode: os.ModeDir | 0700}}
+
+		var names []string
+		for name := range s.files {
+			if strings.HasPrefix(name, path+"/") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			ch <- ArchiveResult{Archive: &Archive{Path: name, Type: File, Mode: 0600, Size: uint64(len(s.files[name]))}}
+		}
+	}()
+	return ch
+}
+
+func (s *sourceMemory) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	data, ok := s.files[path]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewBufferString(data)), nil
+}
+
+func TestSource(t *testing.T) {
+	RegisterSource(&sourceMemory{})
+
+	if _, err := SourceFromURL("unknown://source"); err != ErrInvalidSourceURL {
+		t.Errorf("Expected error %v for unknown source, got %v", ErrInvalidSourceURL, err)
+	}
+	if s, err := SourceFromURL("/some/path"); err != nil || s.Description() != "Local File System" {
+		t.Errorf("Expected local source for plain paths, got %v", err)
+	}
+	source, err := SourceFromURL("memory://mailbox")
+	if err != nil {
+		t.Fatalf("Failed creating source: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, "this_is_a_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	snapshot, err := NewSnapshot("test_snapshot")
+	if err != nil {
+		t.Fatalf("Failed creating snapshot: %s", err)
+	}
+
+	opts := StoreOptions{
+		Paths:     []string{"INBOX"},
+		Encrypt:   EncryptionAES,
+		DataParts: 1,
+		Source:    source,
+	}
+	for p := range snapshot.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Errorf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+	if len(snapshot.Archives) != 3 || snapshot.Stats.Files != 2 {
+		t.Fatalf("Expected 3 items in snapshot, got %d", len(snapshot.Archives))
+	}
+
+	targetdir, err := ioutil.TempDir("", "knoxite.target")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for restore: %s", err)
+	}
+	defer os.RemoveAll(targetdir)
+
+	progress, err := DecodeSnapshot(context.Background(), r, snapshot, targetdir, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Failed restoring snapshot: %s", err)
+	}
+	for p := range progress {
+		if p.Error != nil {
+			t.Errorf("Failed restoring snapshot: %s", p.Error)
+		}
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(targetdir, "INBOX", "2"))
+	if err != nil || string(b) != "second message" {
+		t.Errorf("Failed restoring data of source: %v", err)
+	}
+}
+
+func TestSourceReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, "this_is_a_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	snapshot, err := NewSnapshot("test_snapshot")
+	if err != nil {
+		t.Fatalf("Failed creating snapshot: %s", err)
+	}
+
+	data := strings.Repeat("INSERT INTO knoxite VALUES (1);\n", 100000)
+	opts := StoreOptions{
+		Paths:     []string{"dump.sql"},
+		Encrypt:   EncryptionAES,
+		DataParts: 1,
+		Source:    NewSourceReader("dump.sql", strings.NewReader(data)),
+	}
+	for p := range snapshot.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Errorf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+
+	arc, ok := snapshot.Archives["dump.sql"]
+	if !ok || arc.Size != uint64(len(data)) || snapshot.Stats.Size != uint64(len(data)) {
+		t.Fatalf("Expected a file of %d bytes in snapshot, got %+v", len(data), snapshot.Stats)
+	}
+	b, _, err := DecodeArchiveData(context.Background(), r, *arc)
+	if err != nil || string(b) != data {
+		t.Errorf("Failed reading stored data: %v", err)
+	}
+
+	if _, err := opts.Source.Open(context.Background(), "dump.sql"); err != ErrSourceReaderConsumed {
+		t.Errorf("Expected error %v when reading twice, got %v", ErrSourceReaderConsumed, err)
+	}
+}
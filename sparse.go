@@ -0,0 +1,55 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"io"
+	"os"
+)
+
+// maxHoleSize limits the size of a single hole chunk, so reading it doesn't
+// require huge buffers.
+const maxHoleSize = 64 * preferredChunkSize
+
+// dataRegion is a part of a sparse file which contains data.
+type dataRegion struct {
+	offset int64
+	length int64
+}
+
+// sparseRegions returns the data regions of file, or nil if it's not a sparse
+// file or its holes can't be detected.
+func sparseRegions(file io.Reader) []dataRegion {
+	f, ok := file.(*os.File)
+	if !ok {
+		return nil
+	}
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil
+	}
+
+	regions, err := dataRegions(f, fi.Size())
+	if _, serr := f.Seek(0, io.SeekStart); err != nil || serr != nil {
+		return nil
+	}
+	if len(regions) == 1 && regions[0].offset == 0 && regions[0].length == fi.Size() {
+		return nil
+	}
+	return regions
+}
+
+// isZero returns true if b only contains zeros.
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
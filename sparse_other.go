@@ -0,0 +1,21 @@
+//go:build !linux && !freebsd && !darwin
+// +build !linux,!freebsd,!darwin
+
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"os"
+)
+
+// dataRegions treats the entire file as data, as holes can't be detected on
+// this platform. Runs of zeros still don't get stored.
+func dataRegions(f *os.File, size int64) ([]dataRegion, error) {
+	return []dataRegion{{offset: 0, length: size}}, nil
+}
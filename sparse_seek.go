@@ -0,0 +1,16 @@
+//go:build linux || freebsd
+// +build linux freebsd
+
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+const (
+	seekData = 3
+	seekHole = 4
+)
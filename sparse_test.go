@@ -0,0 +1,132 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSparseFile(t *testing.T) {
+	testPassword := "this_is_a_password"
+
+	src, err := ioutil.TempDir("", "knoxite.source")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	// 4 MiB of data surrounded by holes, followed by 4 MiB of zeros
+	const size = 80 << 20
+	data := bytes.Repeat([]byte("knoxite"), (4<<20)/7)
+	f, err := os.Create(filepath.Join(src, "image"))
+	if err != nil {
+		t.Fatalf("Failed creating file: %s", err)
+	}
+	if _, err := f.WriteAt(data, 32<<20); err != nil {
+		t.Fatalf("Failed writing file: %s", err)
+	}
+	if _, err := f.WriteAt(make([]byte, 4<<20), 40<<20); err != nil {
+		t.Fatalf("Failed writing file: %s", err)
+	}
+	if err := f.Truncate(size); err != nil {
+		t.Fatalf("Failed writing file: %s", err)
+	}
+	f.Close()
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	snapshot, err := NewSnapshot("test_snapshot")
+	if err != nil {
+		t.Fatalf("Failed creating snapshot: %s", err)
+	}
+
+	opts := StoreOptions{
+		CWD:       src,
+		Paths:     []string{src},
+		Encrypt:   EncryptionAES,
+		DataParts: 1,
+	}
+	for p := range snapshot.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Errorf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+
+	arc := snapshot.Archives["image"]
+	if arc.Size != size || !arc.sparse() {
+		t.Fatalf("Expected a sparse file of %d bytes, got %d bytes", size, arc.Size)
+	}
+	if arc.StorageSize > 2*uint64(len(data)) {
+		t.Errorf("Expected holes not to be stored, got %d bytes in storage", arc.StorageSize)
+	}
+	for _, item := range index.Chunks {
+		if item.Hash == "" {
+			t.Errorf("Expected holes not to be indexed")
+		}
+	}
+
+	targetdir, err := ioutil.TempDir("", "knoxite.target")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for restore: %s", err)
+	}
+	defer os.RemoveAll(targetdir)
+
+	// existing content must not remain in the holes
+	path := filepath.Join(targetdir, "image")
+	if err := ioutil.WriteFile(path, bytes.Repeat([]byte{1}, 1<<20), 0600); err != nil {
+		t.Fatalf("Failed creating file: %s", err)
+	}
+
+	progress, err := DecodeSnapshot(context.Background(), r, snapshot, targetdir, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Failed restoring snapshot: %s", err)
+	}
+	for p := range progress {
+		if p.Error != nil {
+			t.Errorf("Failed restoring snapshot: %s", p.Error)
+		}
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed reading restored file: %s", err)
+	}
+	exp := make([]byte, size)
+	copy(exp[32<<20:], data)
+	if !bytes.Equal(b, exp) {
+		t.Errorf("Restored file differs from the original")
+	}
+
+	b, _, err = DecodeArchiveData(context.Background(), r, *arc)
+	if err != nil || !bytes.Equal(b, exp) {
+		t.Errorf("Failed reading sparse file: %v", err)
+	}
+
+	var buf bytes.Buffer
+	err = WriteArchiveData(context.Background(), r, *arc, &buf)
+	if err != nil || !bytes.Equal(buf.Bytes(), exp) {
+		t.Errorf("Failed streaming sparse file: %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+//go:build linux || freebsd || darwin
+// +build linux freebsd darwin
+
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// dataRegions finds the data regions of a file with SEEK_DATA and SEEK_HOLE.
+func dataRegions(f *os.File, size int64) ([]dataRegion, error) {
+	var regions []dataRegion
+	for offset := int64(0); offset < size; {
+		data, err := f.Seek(offset, seekData)
+		if errors.Is(err, syscall.ENXIO) {
+			// the remainder of the file is a hole
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		hole, err := f.Seek(data, seekHole)
+		if err != nil {
+			return nil, err
+		}
+		if hole > size {
+			hole = size
+		}
+
+		regions = append(regions, dataRegion{offset: data, length: hole - data})
+		offset = hole
+	}
+
+	return regions, nil
+}
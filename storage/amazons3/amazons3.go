@@ -22,6 +22,12 @@ type AmazonS3Client interface {
 	HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error)
 	PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error)
 	DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error)
+	CopyObject(input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error)
+	RestoreObject(input *s3.RestoreObjectInput) (*s3.RestoreObjectOutput, error)
+	CreateMultipartUpload(input *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(input *s3.UploadPartInput) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(input *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(input *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error)
 }
 
 // AmazonS3StorageBackend is the storage backend that adapts knoxite's backend
@@ -32,4 +38,16 @@ type AmazonS3StorageBackend struct {
 	url        url.URL
 	service    AmazonS3Client
 	bucketName string
+
+	// storageClass, sse and sseKMSKeyID get applied to all objects we write,
+	// empty values leave the decision to the bucket's defaults
+	storageClass string
+	sse          string
+	sseKMSKeyID  string
+	// files larger than partSize get uploaded in multiple parts
+	partSize int64
+	// chunks retrieved from archive classes stay readable for restoreDays,
+	// restoreTier trades retrieval speed for costs
+	restoreDays int64
+	restoreTier string
 }
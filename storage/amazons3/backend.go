@@ -8,8 +8,7 @@
 package amazons3
 
 import (
-	"bytes"
-	"io/ioutil"
+	"io"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
@@ -31,6 +30,20 @@ func (backend *AmazonS3StorageBackend) Stat(path string) (uint64, error) {
 	return uint64(*out.ContentLength), nil
 }
 
+// Version returns the ETag of the object with key `path`.
+func (backend *AmazonS3StorageBackend) Version(path string) (string, error) {
+	out, err := backend.service.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(backend.bucketName),
+		Key:    aws.String(path),
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(out.ETag), nil
+}
+
 // CreatePath creates a folder in a filesystem-like storage backend.
 func (*AmazonS3StorageBackend) CreatePath(path string) error {
 	// In S3, this is a no-op since "paths" are just a convention and
@@ -38,8 +51,8 @@ func (*AmazonS3StorageBackend) CreatePath(path string) error {
 	return nil
 }
 
-// ReadFile reads a file from the backend.
-func (backend *AmazonS3StorageBackend) ReadFile(path string) ([]byte, error) {
+// ReadFile returns a reader for a file from the backend.
+func (backend *AmazonS3StorageBackend) ReadFile(path string) (io.ReadCloser, error) {
 	result, err := backend.service.GetObject(&s3.GetObjectInput{
 		Key:    aws.String(path),
 		Bucket: aws.String(backend.bucketName),
@@ -48,23 +61,31 @@ func (backend *AmazonS3StorageBackend) ReadFile(path string) ([]byte, error) {
 		return nil, err
 	}
 
-	resultBytes, err := ioutil.ReadAll(result.Body)
-	defer result.Body.Close()
-	if err != nil {
-		return nil, err
-	}
-
-	return resultBytes, nil
+	return result.Body, nil
 }
 
 // WriteFile writes a file to the storage backend.
-func (backend *AmazonS3StorageBackend) WriteFile(path string, data []byte) (uint64, error) {
-	databuf := bytes.NewReader(data)
+func (backend *AmazonS3StorageBackend) WriteFile(path string, data io.Reader, size uint64) (uint64, error) {
+	if backend.partSize > 0 && int64(size) > backend.partSize {
+		return backend.writeMultipart(path, data, size)
+	}
+
+	// the SDK can only sign and retry requests with seekable bodies, so only
+	// fall back to a plain reader if we have to
+	body, ok := data.(io.ReadSeeker)
+	if !ok {
+		body = aws.ReadSeekCloser(data)
+	}
 
 	_, err := backend.service.PutObject(&s3.PutObjectInput{
-		Key:    aws.String(path),
-		Bucket: aws.String(backend.bucketName),
-		Body:   databuf,
+		Key:           aws.String(path),
+		Bucket:        aws.String(backend.bucketName),
+		Body:          body,
+		ContentLength: aws.Int64(int64(size)),
+
+		StorageClass:         optionalString(backend.storageClass),
+		ServerSideEncryption: optionalString(backend.sse),
+		SSEKMSKeyId:          optionalString(backend.sseKMSKeyID),
 	})
 
 	if err != nil {
@@ -77,7 +98,7 @@ func (backend *AmazonS3StorageBackend) WriteFile(path string, data []byte) (uint
 	// The only way we could find that out at this point is via an additional
 	// HEAD request, increasing latency and cost.
 
-	return uint64(len(data)), nil
+	return size, nil
 }
 
 // DeleteFile deletes a file from the storage backend.
@@ -112,3 +133,12 @@ func (*AmazonS3StorageBackend) AvailableSpace() (uint64, error) {
 	// Amazon S3 doesn't constrain bucket size, so we treat it as unlimited storage
 	return 0, knoxite.ErrAvailableSpaceUnlimited
 }
+
+// optionalString returns nil for empty strings, leaving the setting to the
+// bucket's defaults.
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
@@ -9,7 +9,9 @@ package amazons3
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/url"
 
@@ -28,10 +30,19 @@ type mockS3Client struct {
 	getObjectError     error
 	deleteObjectOutput *s3.DeleteObjectOutput
 	deleteObjectError  error
+	putObjectInput     *s3.PutObjectInput
 	putObjectOutput    *s3.PutObjectOutput
 	putObjectError     error
 	headObjectOutput   *s3.HeadObjectOutput
 	headObjectError    error
+	copyObjectInput    *s3.CopyObjectInput
+	copyObjectError    error
+	uploadedParts      [][]byte
+	uploadPartError    error
+	completedParts     []*s3.CompletedPart
+	aborted            bool
+	restoreObjectInput *s3.RestoreObjectInput
+	restoreObjectError error
 }
 
 func (mc *mockS3Client) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
@@ -43,9 +54,43 @@ func (mc *mockS3Client) DeleteObject(input *s3.DeleteObjectInput) (*s3.DeleteObj
 }
 
 func (mc *mockS3Client) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	mc.putObjectInput = input
 	return mc.putObjectOutput, mc.putObjectError
 }
 
+func (mc *mockS3Client) CreateMultipartUpload(input *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String("upload")}, nil
+}
+
+func (mc *mockS3Client) UploadPart(input *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	if mc.uploadPartError != nil {
+		return nil, mc.uploadPartError
+	}
+	b, _ := ioutil.ReadAll(input.Body)
+	mc.uploadedParts = append(mc.uploadedParts, b)
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf("etag%d", *input.PartNumber))}, nil
+}
+
+func (mc *mockS3Client) CompleteMultipartUpload(input *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+	mc.completedParts = input.MultipartUpload.Parts
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (mc *mockS3Client) AbortMultipartUpload(input *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	mc.aborted = true
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (mc *mockS3Client) CopyObject(input *s3.CopyObjectInput) (*s3.CopyObjectOutput, error) {
+	mc.copyObjectInput = input
+	return &s3.CopyObjectOutput{}, mc.copyObjectError
+}
+
+func (mc *mockS3Client) RestoreObject(input *s3.RestoreObjectInput) (*s3.RestoreObjectOutput, error) {
+	mc.restoreObjectInput = input
+	return &s3.RestoreObjectOutput{}, mc.restoreObjectError
+}
+
 func (mc *mockS3Client) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
 	return mc.headObjectOutput, mc.headObjectError
 }
@@ -121,7 +166,11 @@ var _ = Describe("ReadFile", func() {
 				},
 			}
 
-			result, err = backend.ReadFile("asdf")
+			var r io.ReadCloser
+			r, err = backend.ReadFile("asdf")
+			if err == nil {
+				result, err = ioutil.ReadAll(r)
+			}
 		})
 
 		It("doesn't return an error", func() {
@@ -141,7 +190,12 @@ var _ = Describe("ReadFile", func() {
 				},
 			}
 
-			result, err = backend.ReadFile("asdf")
+			result = nil
+			var r io.ReadCloser
+			r, err = backend.ReadFile("asdf")
+			if err == nil {
+				result, err = ioutil.ReadAll(r)
+			}
 		})
 
 		It("returns an empty byte array", func() {
@@ -170,7 +224,7 @@ var _ = Describe("WriteFile", func() {
 				service: &mockS3Client{},
 			}
 
-			size, err = backend.WriteFile("asdf", file)
+			size, err = backend.WriteFile("asdf", bytes.NewReader(file), uint64(len(file)))
 		})
 
 		It("should return the file's size", func() {
@@ -182,6 +236,64 @@ var _ = Describe("WriteFile", func() {
 		})
 	})
 
+	When("the storage class and encryption are configured", func() {
+		var client *mockS3Client
+
+		BeforeEach(func() {
+			client = &mockS3Client{}
+			backend = &AmazonS3StorageBackend{
+				service:      client,
+				storageClass: s3.StorageClassStandardIa,
+				sse:          s3.ServerSideEncryptionAwsKms,
+				sseKMSKeyID:  "key",
+			}
+
+			size, err = backend.WriteFile("asdf", bytes.NewReader(file), uint64(len(file)))
+		})
+
+		It("should apply them to the object", func() {
+			Expect(*client.putObjectInput.StorageClass).To(Equal(s3.StorageClassStandardIa))
+			Expect(*client.putObjectInput.ServerSideEncryption).To(Equal(s3.ServerSideEncryptionAwsKms))
+			Expect(*client.putObjectInput.SSEKMSKeyId).To(Equal("key"))
+		})
+	})
+
+	When("the file is larger than a part", func() {
+		var client *mockS3Client
+
+		BeforeEach(func() {
+			client = &mockS3Client{}
+			backend = &AmazonS3StorageBackend{service: client, partSize: 3}
+
+			size, err = backend.WriteFile("asdf", bytes.NewReader(file), uint64(len(file)))
+		})
+
+		It("should upload it in parts", func() {
+			Expect(err).To(BeNil())
+			Expect(size).To(Equal(uint64(len(file))))
+			Expect(client.uploadedParts).To(Equal([][]byte{[]byte("asd"), []byte("fas"), []byte("df")}))
+			Expect(client.completedParts).To(HaveLen(3))
+			Expect(*client.completedParts[2].ETag).To(Equal("etag3"))
+		})
+	})
+
+	When("uploading a part fails", func() {
+		var client *mockS3Client
+
+		BeforeEach(func() {
+			client = &mockS3Client{uploadPartError: awserr.New("InternalError", "lol", fmt.Errorf("lel"))}
+			backend = &AmazonS3StorageBackend{service: client, partSize: 3}
+
+			size, err = backend.WriteFile("asdf", bytes.NewReader(file), uint64(len(file)))
+		})
+
+		It("should abort the upload", func() {
+			Expect(err).ToNot(BeNil())
+			Expect(size).To(BeZero())
+			Expect(client.aborted).To(BeTrue())
+		})
+	})
+
 	When("there was an error writing the file", func() {
 		BeforeEach(func() {
 			backend = &AmazonS3StorageBackend{
@@ -190,7 +302,7 @@ var _ = Describe("WriteFile", func() {
 				},
 			}
 
-			size, err = backend.WriteFile("asdf", file)
+			size, err = backend.WriteFile("asdf", bytes.NewReader(file), uint64(len(file)))
 		})
 
 		It("should return a file size of zero", func() {
@@ -293,3 +405,116 @@ var _ = Describe("AvailableSpace", func() {
 		Expect(space).To(Equal(uint64(0)))
 	})
 })
+
+var _ = Describe("SetChunkTier", func() {
+	var (
+		backend *AmazonS3StorageBackend
+		client  *mockS3Client
+		err     error
+	)
+
+	When("the chunk exists", func() {
+		BeforeEach(func() {
+			client = &mockS3Client{}
+			backend = &AmazonS3StorageBackend{service: client, bucketName: "foobarfoo"}
+			backend.StorageFilesystem, _ = knoxite.NewStorageFilesystem("/asdgf", backend)
+			err = backend.SetChunkTier(context.Background(), "abcdef", 0, 1, s3.StorageClassGlacier)
+		})
+
+		It("shouldn't return an error", func() {
+			Expect(err).To(BeNil())
+		})
+
+		It("should copy the chunk onto itself with the new storage class", func() {
+			Expect(*client.copyObjectInput.Key).To(Equal("/asdgf/chunks/ab/cd/abcdef.0_1"))
+			Expect(*client.copyObjectInput.CopySource).To(Equal("foobarfoo%2F%2Fasdgf%2Fchunks%2Fab%2Fcd%2Fabcdef.0_1"))
+			Expect(*client.copyObjectInput.StorageClass).To(Equal(s3.StorageClassGlacier))
+		})
+	})
+
+	When("the chunk doesn't exist", func() {
+		BeforeEach(func() {
+			client = &mockS3Client{copyObjectError: awserr.New(s3.ErrCodeNoSuchKey, "Foobar", fmt.Errorf("NoSuchKey"))}
+			backend = &AmazonS3StorageBackend{service: client, bucketName: "foobarfoo"}
+			backend.StorageFilesystem, _ = knoxite.NewStorageFilesystem("/asdgf", backend)
+			err = backend.SetChunkTier(context.Background(), "abcdef", 0, 1, s3.StorageClassGlacier)
+		})
+
+		It("should return an error, which doesn't get retried", func() {
+			Expect(knoxite.IsRetryable(err)).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("ThawChunk", func() {
+	var (
+		client *mockS3Client
+		ready  bool
+		err    error
+	)
+
+	thaw := func() {
+		backend := &AmazonS3StorageBackend{service: client, bucketName: "foobarfoo", restoreDays: 3, restoreTier: s3.TierBulk}
+		backend.StorageFilesystem, _ = knoxite.NewStorageFilesystem("/asdgf", backend)
+		ready, err = backend.ThawChunk(context.Background(), "abcdef", 0, 1)
+	}
+
+	When("the chunk isn't archived", func() {
+		BeforeEach(func() {
+			client = &mockS3Client{headObjectOutput: &s3.HeadObjectOutput{StorageClass: aws.String(s3.StorageClassStandardIa)}}
+			thaw()
+		})
+
+		It("should be ready right away", func() {
+			Expect(err).To(BeNil())
+			Expect(ready).To(BeTrue())
+			Expect(client.restoreObjectInput).To(BeNil())
+		})
+	})
+
+	When("the chunk is archived", func() {
+		BeforeEach(func() {
+			client = &mockS3Client{headObjectOutput: &s3.HeadObjectOutput{StorageClass: aws.String(s3.StorageClassGlacier)}}
+			thaw()
+		})
+
+		It("should request it to be restored", func() {
+			Expect(err).To(BeNil())
+			Expect(ready).To(BeFalse())
+			Expect(*client.restoreObjectInput.Key).To(Equal("/asdgf/chunks/ab/cd/abcdef.0_1"))
+			Expect(*client.restoreObjectInput.RestoreRequest.Days).To(Equal(int64(3)))
+			Expect(*client.restoreObjectInput.RestoreRequest.GlacierJobParameters.Tier).To(Equal(s3.TierBulk))
+		})
+	})
+
+	When("the chunk is being restored", func() {
+		BeforeEach(func() {
+			client = &mockS3Client{headObjectOutput: &s3.HeadObjectOutput{
+				StorageClass: aws.String(s3.StorageClassDeepArchive),
+				Restore:      aws.String(`ongoing-request="true"`),
+			}}
+			thaw()
+		})
+
+		It("shouldn't be ready yet", func() {
+			Expect(err).To(BeNil())
+			Expect(ready).To(BeFalse())
+			Expect(client.restoreObjectInput).To(BeNil())
+		})
+	})
+
+	When("the chunk has been restored", func() {
+		BeforeEach(func() {
+			client = &mockS3Client{headObjectOutput: &s3.HeadObjectOutput{
+				StorageClass: aws.String(s3.StorageClassGlacier),
+				Restore:      aws.String(`ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`),
+			}}
+			thaw()
+		})
+
+		It("should be ready", func() {
+			Expect(err).To(BeNil())
+			Expect(ready).To(BeTrue())
+		})
+	})
+})
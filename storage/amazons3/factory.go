@@ -8,14 +8,32 @@
 package amazons3
 
 import (
+	"errors"
 	"net/url"
+	"strconv"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/knoxite/knoxite"
 )
 
+// Error declarations.
+var (
+	ErrInvalidStorageClass = errors.New("Invalid storage class, archive classes can't hold a repository")
+	ErrInvalidSSE          = errors.New("Invalid server-side encryption, use AES256 or aws:kms")
+	ErrInvalidRestore      = errors.New("Invalid archive retrieval settings, use a positive amount of restore_days and a restore_tier of Expedited, Standard or Bulk")
+)
+
+const (
+	// defaultPartSize is the size of the parts large files get uploaded in
+	defaultPartSize = 8 * 1024 * 1024
+	// defaultRestoreDays is how long chunks retrieved from archive classes
+	// stay readable
+	defaultRestoreDays = 7
+)
+
 func init() {
 	knoxite.RegisterStorageBackend(&AmazonS3StorageBackend{})
 }
@@ -36,15 +54,47 @@ func (*AmazonS3StorageBackend) NewBackend(url url.URL) (knoxite.Backend, error)
 		sessionConfig.S3ForcePathStyle = aws.Bool(true)
 	}
 
-	sesn, err := session.NewSession(sessionConfig)
+	// without credentials in the URL the SDK falls back to the environment,
+	// the shared config and credentials files and IAM roles
+	if url.User != nil && url.User.Username() != "" {
+		secret, _ := url.User.Password()
+		if secret == "" {
+			return &AmazonS3StorageBackend{}, knoxite.ErrInvalidPassword
+		}
+		sessionConfig.Credentials = credentials.NewStaticCredentials(url.User.Username(), secret, "")
+	}
+
+	// the shared config may define profiles assuming roles or their regions
+	sesn, err := session.NewSessionWithOptions(session.Options{
+		Config:            *sessionConfig,
+		SharedConfigState: session.SharedConfigEnable,
+	})
 	if err != nil {
 		return &AmazonS3StorageBackend{}, err
 	}
 
 	new := &AmazonS3StorageBackend{
-		url:        url,
-		service:    s3.New(sesn),
-		bucketName: url.Hostname(),
+		url:          url,
+		service:      s3.New(sesn),
+		bucketName:   url.Hostname(),
+		storageClass: url.Query().Get("storage_class"),
+		sse:          url.Query().Get("sse"),
+		sseKMSKeyID:  url.Query().Get("sse_kms_key_id"),
+		partSize:     defaultPartSize,
+		restoreDays:  defaultRestoreDays,
+		restoreTier:  s3.TierStandard,
+	}
+	if days := url.Query().Get("restore_days"); days != "" {
+		new.restoreDays, err = strconv.ParseInt(days, 10, 64)
+		if err != nil {
+			return &AmazonS3StorageBackend{}, ErrInvalidRestore
+		}
+	}
+	if tier := url.Query().Get("restore_tier"); tier != "" {
+		new.restoreTier = tier
+	}
+	if err := new.validateOptions(); err != nil {
+		return &AmazonS3StorageBackend{}, err
 	}
 
 	fs, err := knoxite.NewStorageFilesystem(url.Path, new)
@@ -61,3 +111,46 @@ func (*AmazonS3StorageBackend) NewBackend(url url.URL) (knoxite.Backend, error)
 func (*AmazonS3StorageBackend) Protocols() []string {
 	return []string{"amazons3"}
 }
+
+// validateOptions checks the storage class and server-side encryption
+// settings.
+func (backend *AmazonS3StorageBackend) validateOptions() error {
+	switch backend.storageClass {
+	case "":
+	case s3.StorageClassGlacier, s3.StorageClassDeepArchive:
+		// the repository and its snapshots need to be readable at any time,
+		// chunks can still be moved to these classes with 'repo tier'
+		return ErrInvalidStorageClass
+	default:
+		valid := false
+		for _, class := range backend.Tiers() {
+			valid = valid || class == backend.storageClass
+		}
+		if !valid {
+			return ErrInvalidStorageClass
+		}
+	}
+
+	if backend.sseKMSKeyID != "" && backend.sse == "" {
+		backend.sse = s3.ServerSideEncryptionAwsKms
+	}
+	switch backend.sse {
+	case "", s3.ServerSideEncryptionAes256:
+		if backend.sseKMSKeyID != "" {
+			return ErrInvalidSSE
+		}
+	case s3.ServerSideEncryptionAwsKms:
+	default:
+		return ErrInvalidSSE
+	}
+
+	if backend.restoreDays <= 0 {
+		return ErrInvalidRestore
+	}
+	switch backend.restoreTier {
+	case s3.TierExpedited, s3.TierStandard, s3.TierBulk:
+	default:
+		return ErrInvalidRestore
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+/*
+ * knoxite
+ *     Copyright (c) 2020, Johannes Fürmann <fuermannj+floss@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package amazons3
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// writeMultipart uploads a file in parts of partSize. Failed requests only
+// need to repeat a single part, and the parts stay seekable, even if data
+// isn't.
+func (backend *AmazonS3StorageBackend) writeMultipart(path string, data io.Reader, size uint64) (uint64, error) {
+	upload, err := backend.service.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Key:                  aws.String(path),
+		Bucket:               aws.String(backend.bucketName),
+		StorageClass:         optionalString(backend.storageClass),
+		ServerSideEncryption: optionalString(backend.sse),
+		SSEKMSKeyId:          optionalString(backend.sseKMSKeyID),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	parts, err := backend.uploadParts(path, upload.UploadId, data, size)
+	if err == nil {
+		_, err = backend.service.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Key:             aws.String(path),
+			Bucket:          aws.String(backend.bucketName),
+			UploadId:        upload.UploadId,
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+	}
+	if err != nil {
+		// otherwise the uploaded parts keep being billed
+		_, _ = backend.service.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Key:      aws.String(path),
+			Bucket:   aws.String(backend.bucketName),
+			UploadId: upload.UploadId,
+		})
+		return 0, err
+	}
+
+	return size, nil
+}
+
+func (backend *AmazonS3StorageBackend) uploadParts(path string, uploadID *string, data io.Reader, size uint64) ([]*s3.CompletedPart, error) {
+	var parts []*s3.CompletedPart
+	buf := make([]byte, backend.partSize)
+	for left := int64(size); left > 0; {
+		n := backend.partSize
+		if left < n {
+			n = left
+		}
+		if _, err := io.ReadFull(data, buf[:n]); err != nil {
+			return nil, err
+		}
+		left -= n
+
+		number := aws.Int64(int64(len(parts) + 1))
+		out, err := backend.service.UploadPart(&s3.UploadPartInput{
+			Key:           aws.String(path),
+			Bucket:        aws.String(backend.bucketName),
+			UploadId:      uploadID,
+			PartNumber:    number,
+			Body:          bytes.NewReader(buf[:n]),
+			ContentLength: aws.Int64(n),
+		})
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: out.ETag, PartNumber: number})
+	}
+	return parts, nil
+}
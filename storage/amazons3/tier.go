@@ -0,0 +1,111 @@
+/*
+ * knoxite
+ *     Copyright (c) 2020, Johannes Fürmann <fuermannj+floss@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package amazons3
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/knoxite/knoxite"
+)
+
+// storageClassGlacierIR isn't known to the SDK version we use yet.
+const storageClassGlacierIR = "GLACIER_IR"
+
+// Tiers returns the S3 storage classes chunks can be moved to, starting with
+// the class new chunks get stored in.
+func (backend *AmazonS3StorageBackend) Tiers() []string {
+	tiers := []string{
+		s3.StorageClassStandard,
+		s3.StorageClassStandardIa,
+		s3.StorageClassOnezoneIa,
+		s3.StorageClassIntelligentTiering,
+		storageClassGlacierIR,
+		s3.StorageClassGlacier,
+		s3.StorageClassDeepArchive,
+	}
+	for i, tier := range tiers {
+		if i > 0 && tier == backend.storageClass {
+			tiers[0], tiers[i] = tiers[i], tiers[0]
+		}
+	}
+	return tiers
+}
+
+// SetChunkTier changes the storage class of a chunk by copying it onto
+// itself.
+func (backend *AmazonS3StorageBackend) SetChunkTier(ctx context.Context, shasum string, part, totalParts uint, tier string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	key := backend.ChunkFileName(shasum, part, totalParts)
+	// the copy needs to be encrypted again explicitly
+	_, err := backend.service.CopyObject(&s3.CopyObjectInput{
+		Bucket:               aws.String(backend.bucketName),
+		Key:                  aws.String(key),
+		CopySource:           aws.String(url.PathEscape(backend.bucketName + "/" + key)),
+		StorageClass:         aws.String(tier),
+		MetadataDirective:    aws.String(s3.MetadataDirectiveCopy),
+		ServerSideEncryption: optionalString(backend.sse),
+		SSEKMSKeyId:          optionalString(backend.sseKMSKeyID),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		// the chunk is stored on another backend
+		return knoxite.Permanent(err)
+	}
+	return err
+}
+
+// ThawChunk requests a chunk stored in an archive class to be restored for
+// restoreDays. It returns true once the restored copy can be read.
+func (backend *AmazonS3StorageBackend) ThawChunk(ctx context.Context, shasum string, part, totalParts uint) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	key := backend.ChunkFileName(shasum, part, totalParts)
+	head, err := backend.service.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(backend.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "NotFound" {
+			// the chunk is stored on another backend
+			return false, knoxite.Permanent(err)
+		}
+		return false, err
+	}
+
+	switch aws.StringValue(head.StorageClass) {
+	case s3.StorageClassGlacier, s3.StorageClassDeepArchive:
+	default:
+		return true, nil
+	}
+	if restore := aws.StringValue(head.Restore); restore != "" {
+		// e.g. ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"
+		return strings.Contains(restore, `ongoing-request="false"`), nil
+	}
+
+	_, err = backend.service.RestoreObject(&s3.RestoreObjectInput{
+		Bucket: aws.String(backend.bucketName),
+		Key:    aws.String(key),
+		RestoreRequest: &s3.RestoreRequest{
+			Days:                 aws.Int64(backend.restoreDays),
+			GlacierJobParameters: &s3.GlacierJobParameters{Tier: aws.String(backend.restoreTier)},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == "RestoreAlreadyInProgress" {
+		return false, nil
+	}
+	return false, err
+}
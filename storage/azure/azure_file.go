@@ -8,8 +8,10 @@
 package azure
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"net/url"
 	"path"
 	"strings"
@@ -103,12 +105,12 @@ func (backend *AzureFileStorage) AvailableSpace() (uint64, error) {
 	shareUrl := azfile.NewShareURL(backend.endpoint, azfile.NewPipeline(&backend.credential, azfile.PipelineOptions{}))
 	props, err := shareUrl.GetProperties(context.Background())
 	if err != nil {
-		return 0, err
+		return 0, classifyError(err)
 	}
 
 	stats, err := shareUrl.GetStatistics(context.Background())
 	if err != nil {
-		return 0, err
+		return 0, classifyError(err)
 	}
 
 	gb := uint64(1 << (10 * 3))
@@ -129,8 +131,11 @@ func (backend *AzureFileStorage) CreatePath(p string) error {
 		_, err := directoryUrl.Create(context.Background(), azfile.Metadata{
 			"createdby": "knoxite",
 		}, azfile.SMBProperties{})
-		if err != nil && err.(azfile.StorageError).ServiceCode() != azfile.ServiceCodeResourceAlreadyExists {
-			return err
+		if serr, ok := err.(azfile.StorageError); ok && serr.ServiceCode() == azfile.ServiceCodeResourceAlreadyExists {
+			continue
+		}
+		if err != nil {
+			return classifyError(err)
 		}
 	}
 
@@ -146,50 +151,60 @@ func (backend *AzureFileStorage) Stat(p string) (uint64, error) {
 	fileUrl := azfile.NewFileURL(u, azfile.NewPipeline(&backend.credential, azfile.PipelineOptions{}))
 	props, err := fileUrl.GetProperties(context.Background())
 	if err != nil {
-		return 0, err
+		return 0, classifyError(err)
 	}
 
 	return uint64(props.ContentLength()), nil
 }
 
-// ReadFile reads a file from Azure file storage.
-func (backend *AzureFileStorage) ReadFile(p string) ([]byte, error) {
+// ReadFile returns a reader for a file from Azure file storage.
+func (backend *AzureFileStorage) ReadFile(p string) (io.ReadCloser, error) {
 	u := backend.endpoint
 	u.Path = path.Join(u.Path, p)
 
-	size, err := backend.Stat(p)
-	if err != nil {
-		return nil, err
-	}
-
-	bytes := make([]byte, size)
 	fileUrl := azfile.NewFileURL(u, azfile.NewPipeline(&backend.credential, azfile.PipelineOptions{}))
 
-	_, err = azfile.DownloadAzureFileToBuffer(context.Background(), fileUrl, bytes, azfile.DownloadFromAzureFileOptions{Parallelism: 1})
+	resp, err := fileUrl.Download(context.Background(), 0, azfile.CountToEnd, false)
 	if err != nil {
-		return nil, err
+		return nil, classifyError(err)
 	}
 
-	return bytes, nil
+	return resp.Body(azfile.RetryReaderOptions{MaxRetryRequests: 3}), nil
 }
 
 // WriteFile writes a file on Azure file storage.
-func (backend *AzureFileStorage) WriteFile(p string, data []byte) (size uint64, err error) {
+func (backend *AzureFileStorage) WriteFile(p string, data io.Reader, size uint64) (uint64, error) {
 	u := backend.endpoint
 	u.Path = path.Join(u.Path, p)
 
 	// we assume the share & file do already exist
 	fileUrl := azfile.NewFileURL(u, azfile.NewPipeline(&backend.credential, azfile.PipelineOptions{}))
 
-	err = azfile.UploadBufferToAzureFile(context.Background(), data, fileUrl, azfile.UploadToAzureFileOptions{
-		Metadata: azfile.Metadata{
-			"createdby": "knoxite",
-		},
+	_, err := fileUrl.Create(context.Background(), int64(size), azfile.FileHTTPHeaders{}, azfile.Metadata{
+		"createdby": "knoxite",
 	})
 	if err != nil {
-		return 0, err
+		return 0, classifyError(err)
+	}
+
+	// ranges can't be bigger than FileMaxUploadRangeBytes, so we upload the
+	// file piece by piece
+	buf := make([]byte, azfile.FileMaxUploadRangeBytes)
+	var offset uint64
+	for offset < size {
+		n, err := io.ReadFull(data, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return offset, err
+		}
+
+		_, err = fileUrl.UploadRange(context.Background(), int64(offset), bytes.NewReader(buf[:n]), nil)
+		if err != nil {
+			return offset, classifyError(err)
+		}
+		offset += uint64(n)
 	}
-	return uint64(len(data)), nil
+
+	return offset, nil
 }
 
 // DeleteFile deletes a file from Azure file storage.
@@ -200,7 +215,16 @@ func (backend *AzureFileStorage) DeleteFile(p string) error {
 	// we assume the share & file do already exist
 	_, err := azfile.NewFileURL(u, azfile.NewPipeline(&backend.credential, azfile.PipelineOptions{})).Delete(context.Background())
 	if err != nil {
-		return err
+		return classifyError(err)
 	}
 	return nil
 }
+
+// classifyError marks errors of requests, which won't succeed when retried, as
+// permanent.
+func classifyError(err error) error {
+	if serr, ok := err.(azfile.StorageError); ok && serr.Response() != nil {
+		return knoxite.StatusError(serr.Response().StatusCode, err)
+	}
+	return err
+}
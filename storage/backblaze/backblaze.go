@@ -10,6 +10,7 @@ package backblaze
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"io/ioutil"
 	"net/url"
@@ -26,6 +27,7 @@ type BackblazeStorage struct {
 	url            url.URL
 	repositoryFile string
 	chunkIndexFile string
+	hintFile       string
 	Bucket         *backblaze.Bucket
 	backblaze      *backblaze.B2
 }
@@ -75,6 +77,7 @@ func (*BackblazeStorage) NewBackend(URL url.URL) (knoxite.Backend, error) {
 		url:            URL,
 		repositoryFile: bucketPrefix[1] + "-repository",
 		chunkIndexFile: bucketPrefix[1] + "-chunkindex",
+		hintFile:       bucketPrefix[1] + "-hint",
 		Bucket:         bucket,
 		backblaze:      cl,
 	}, nil
@@ -107,58 +110,68 @@ func (backend *BackblazeStorage) AvailableSpace() (uint64, error) {
 }
 
 // LoadChunk loads a Chunk from backblaze.
-func (backend *BackblazeStorage) LoadChunk(shasum string, part, totalParts uint) ([]byte, error) {
+func (backend *BackblazeStorage) LoadChunk(ctx context.Context, shasum string, part, totalParts uint) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	fileName := shasum + "." + strconv.FormatUint(uint64(part), 10) + "_" + strconv.FormatUint(uint64(totalParts), 10)
 	_, obj, err := backend.Bucket.DownloadFileByName(fileName)
 	if err != nil {
-		return nil, err
+		return nil, classifyError(err)
 	}
-	defer obj.Close()
 
-	return ioutil.ReadAll(obj)
+	return knoxite.ContextReadCloser(ctx, obj), nil
 }
 
 // StoreChunk stores a single Chunk on backblaze.
-func (backend *BackblazeStorage) StoreChunk(shasum string, part, totalParts uint, data []byte) (size uint64, err error) {
+func (backend *BackblazeStorage) StoreChunk(ctx context.Context, shasum string, part, totalParts uint, data io.Reader, size uint64) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
 	fileName := shasum + "." + strconv.FormatUint(uint64(part), 10) + "_" + strconv.FormatUint(uint64(totalParts), 10)
 
 	files, err := backend.findLatestFileVersion(fileName)
 	if err == nil && len(files) > 0 {
-		if files[0].Size == len(data) {
+		if uint64(files[0].Size) == size {
 			return 0, nil
 		}
 	}
 
-	buf := bytes.NewBuffer(data)
 	metadata := make(map[string]string)
-	file, err := backend.upload(fileName, metadata, buf)
+	file, err := backend.upload(fileName, metadata, knoxite.ContextReader(ctx, data))
 	if err != nil {
-		return 0, err
+		return 0, classifyError(err)
 	}
 	return uint64(file.ContentLength), nil
 }
 
 // DeleteChunk deletes a single Chunk.
-func (backend *BackblazeStorage) DeleteChunk(shasum string, part, totalParts uint) error {
+func (backend *BackblazeStorage) DeleteChunk(ctx context.Context, shasum string, part, totalParts uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	fileName := shasum + "." + strconv.FormatUint(uint64(part), 10) + "_" + strconv.FormatUint(uint64(totalParts), 10)
 
 	files, err := backend.findLatestFileVersion(fileName)
 	if err != nil {
-		return err
+		return classifyError(err)
 	}
 	if len(files) == 0 {
-		return knoxite.ErrDeleteChunkFailed
+		return knoxite.NotFound(knoxite.ErrDeleteChunkFailed)
 	}
 
 	_, err = backend.Bucket.DeleteFileVersion(fileName, files[0].ID)
-	return err
+	return classifyError(err)
 }
 
 // LoadSnapshot loads a snapshot.
 func (backend *BackblazeStorage) LoadSnapshot(id string) ([]byte, error) {
 	_, obj, err := backend.Bucket.DownloadFileByName("snapshot-" + id)
 	if err != nil {
-		return nil, knoxite.ErrSnapshotNotFound
+		return nil, classifyError(err)
 	}
 	defer obj.Close()
 
@@ -170,14 +183,14 @@ func (backend *BackblazeStorage) SaveSnapshot(id string, data []byte) error {
 	buf := bytes.NewBuffer(data)
 	metadata := make(map[string]string)
 	_, err := backend.upload("snapshot-"+id, metadata, buf)
-	return err
+	return classifyError(err)
 }
 
 // LoadChunkIndex reads the chunk-index.
 func (backend *BackblazeStorage) LoadChunkIndex() ([]byte, error) {
 	_, obj, err := backend.Bucket.DownloadFileByName(backend.chunkIndexFile)
 	if err != nil {
-		return nil, err
+		return nil, classifyError(err)
 	}
 	defer obj.Close()
 
@@ -189,7 +202,7 @@ func (backend *BackblazeStorage) SaveChunkIndex(data []byte) error {
 	buf := bytes.NewBuffer(data)
 	metadata := make(map[string]string)
 	_, err := backend.upload(backend.chunkIndexFile, metadata, buf)
-	return err
+	return classifyError(err)
 }
 
 // InitRepository creates a new repository.
@@ -201,7 +214,7 @@ func (backend *BackblazeStorage) InitRepository() error {
 	metadata := make(map[string]string)
 
 	if _, err := backend.upload(backend.repositoryFile, metadata, buf); err != nil {
-		return err
+		return classifyError(err)
 	}
 	return nil
 }
@@ -210,15 +223,15 @@ func (backend *BackblazeStorage) InitRepository() error {
 func (backend *BackblazeStorage) LoadRepository() ([]byte, error) {
 	files, err := backend.findLatestFileVersion(backend.repositoryFile)
 	if err != nil {
-		return nil, err
+		return nil, classifyError(err)
 	}
 	if len(files) == 0 {
-		return nil, knoxite.ErrLoadRepositoryFailed
+		return nil, knoxite.NotFound(knoxite.ErrLoadRepositoryFailed)
 	}
 
 	_, obj, err := backend.backblaze.DownloadFileByID(files[0].ID)
 	if err != nil {
-		return nil, err
+		return nil, classifyError(err)
 	}
 	defer obj.Close()
 
@@ -230,7 +243,26 @@ func (backend *BackblazeStorage) SaveRepository(data []byte) error {
 	buf := bytes.NewBuffer(data)
 	metadata := make(map[string]string)
 	_, err := backend.upload(backend.repositoryFile, metadata, buf)
-	return err
+	return classifyError(err)
+}
+
+// LoadPasswordHint reads the password hint.
+func (backend *BackblazeStorage) LoadPasswordHint() ([]byte, error) {
+	_, obj, err := backend.Bucket.DownloadFileByName(backend.hintFile)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	defer obj.Close()
+
+	return ioutil.ReadAll(obj)
+}
+
+// SavePasswordHint stores the password hint.
+func (backend *BackblazeStorage) SavePasswordHint(data []byte) error {
+	buf := bytes.NewBuffer(data)
+	metadata := make(map[string]string)
+	_, err := backend.upload(backend.hintFile, metadata, buf)
+	return classifyError(err)
 }
 
 func (backend *BackblazeStorage) findLatestFileVersion(fileName string) ([]backblaze.FileStatus, error) {
@@ -238,7 +270,7 @@ func (backend *BackblazeStorage) findLatestFileVersion(fileName string) ([]backb
 
 	list, err := backend.Bucket.ListFileVersions(fileName, "", 1)
 	if err != nil {
-		return files, err
+		return files, classifyError(err)
 	}
 
 	for _, v := range list.Files {
@@ -254,17 +286,27 @@ func (backend *BackblazeStorage) findLatestFileVersion(fileName string) ([]backb
 
 func (backend *BackblazeStorage) upload(name string, meta map[string]string, file io.Reader) (*backblaze.File, error) {
 	// delete existing versions of a file, before reuploading
-	files, err := backend.findLatestFileVersion(backend.repositoryFile)
+	files, err := backend.findLatestFileVersion(name)
 	if err != nil {
-		return nil, err
+		return nil, classifyError(err)
 	}
 
 	for _, v := range files {
 		_, err := backend.Bucket.DeleteFileVersion(v.Name, v.ID)
 		if err != nil {
-			return nil, err
+			return nil, classifyError(err)
 		}
 	}
 
-	return backend.Bucket.UploadFile(name, meta, file)
+	f, err := backend.Bucket.UploadFile(name, meta, file)
+	return f, classifyError(err)
+}
+
+// classifyError marks errors of requests, which won't succeed when retried, as
+// permanent.
+func classifyError(err error) error {
+	if b2err, ok := err.(*backblaze.B2Error); ok && b2err.IsFatal() {
+		return knoxite.StatusError(b2err.Status, err)
+	}
+	return err
 }
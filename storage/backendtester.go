@@ -10,9 +10,12 @@
 package storage
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"flag"
+	"io/ioutil"
 	mrand "math/rand"
 	"net/url"
 	"os"
@@ -115,6 +118,24 @@ func (b *BackendTest) SaveRepositoryTest(t *testing.T) {
 	}
 }
 
+func (b *BackendTest) SavePasswordHintTest(t *testing.T) {
+	hint := []byte("the name of my first pet")
+
+	err := b.Backend.SavePasswordHint(hint)
+	if err != nil {
+		t.Errorf("%s: %s", b.Description, err)
+	}
+
+	data, err := b.Backend.LoadPasswordHint()
+	if err != nil {
+		t.Errorf("%s: %s", b.Description, err)
+	}
+
+	if !reflect.DeepEqual(data, hint) {
+		t.Errorf("%s: Hint mismatch, expected %q, got %q", b.Description, hint, data)
+	}
+}
+
 func (b *BackendTest) AvailableSpaceTest(t *testing.T) {
 	space, err := b.Backend.AvailableSpace()
 	if err != nil && err != knoxite.ErrAvailableSpaceUnknown && err != knoxite.ErrAvailableSpaceUnlimited {
@@ -157,7 +178,7 @@ func (b *BackendTest) StoreChunkTest(t *testing.T) {
 	part := uint(mrand.Intn(int(totalParts)))
 
 	hashsum := knoxite.Hash(rnddata, knoxite.HashHighway256)
-	size, err := b.Backend.StoreChunk(hashsum, part, totalParts, rnddata)
+	size, err := b.Backend.StoreChunk(context.Background(), hashsum, part, totalParts, bytes.NewReader(rnddata), uint64(len(rnddata)))
 	if err != nil {
 		t.Errorf("%s: %s", b.Description, err)
 	}
@@ -166,7 +187,7 @@ func (b *BackendTest) StoreChunkTest(t *testing.T) {
 	}
 
 	// Test to store the same chunk twice. Size should be 0
-	size, err = b.Backend.StoreChunk(hashsum, part, totalParts, rnddata)
+	size, err = b.Backend.StoreChunk(context.Background(), hashsum, part, totalParts, bytes.NewReader(rnddata), uint64(len(rnddata)))
 	if err != nil {
 		t.Errorf("%s: %s", b.Description, err)
 	}
@@ -174,7 +195,7 @@ func (b *BackendTest) StoreChunkTest(t *testing.T) {
 		t.Errorf("%s: Already exisiting chunks should not be overwritten", b.Description)
 	}
 
-	data, err := b.Backend.LoadChunk(hashsum, part, totalParts)
+	data, err := b.loadChunk(hashsum, part, totalParts)
 	if err != nil {
 		t.Errorf("%s: %s", b.Description, err)
 	}
@@ -192,18 +213,31 @@ func (b *BackendTest) DeleteChunkTest(t *testing.T) {
 	part := uint(mrand.Intn(int(totalParts)))
 
 	hashsum := knoxite.Hash(rnddata, knoxite.HashHighway256)
-	_, err := b.Backend.StoreChunk(hashsum, part, totalParts, rnddata)
+	_, err := b.Backend.StoreChunk(context.Background(), hashsum, part, totalParts, bytes.NewReader(rnddata), uint64(len(rnddata)))
 	if err != nil {
 		t.Errorf("%s: %s", b.Description, err)
 	}
 
-	err = b.Backend.DeleteChunk(hashsum, part, totalParts)
+	err = b.Backend.DeleteChunk(context.Background(), hashsum, part, totalParts)
 	if err != nil {
 		t.Errorf("%s: %s", b.Description, err)
 	}
 
-	_, err = b.Backend.LoadChunk(hashsum, part, totalParts)
+	_, err = b.loadChunk(hashsum, part, totalParts)
 	if err == nil {
 		t.Errorf("%s: Expected error, got nil", b.Description)
 	}
 }
+
+func (b *BackendTest) loadChunk(shasum string, part, totalParts uint) ([]byte, error) {
+	r, err := b.Backend.LoadChunk(context.Background(), shasum, part, totalParts)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if cerr := r.Close(); err == nil && cerr != nil {
+		return nil, cerr
+	}
+	return data, err
+}
@@ -9,9 +9,9 @@
 package dropbox
 
 import (
-	"bytes"
-	"io/ioutil"
+	"io"
 	"net/url"
+	"strings"
 
 	"github.com/tj/go-dropbox"
 	"github.com/tj/go-dropy"
@@ -75,41 +75,51 @@ func (backend *DropboxStorage) Description() string {
 func (backend *DropboxStorage) AvailableSpace() (uint64, error) {
 	space, err := backend.dropy.Client.Users.GetSpaceUsage()
 	if err != nil {
-		return 0, err
+		return 0, classifyError(err)
 	}
 	return space.Allocation.Allocated - space.Allocation.Used, nil
 }
 
 // CreatePath creates a dir including all its parent dirs, when required.
 func (backend *DropboxStorage) CreatePath(path string) error {
-	return backend.dropy.Mkdir(path)
+	return classifyError(backend.dropy.Mkdir(path))
 }
 
 // Stat returns the size of a file.
 func (backend *DropboxStorage) Stat(path string) (uint64, error) {
 	fileinfo, err := backend.dropy.Stat(path)
 	if err != nil {
-		return 0, err
+		return 0, classifyError(err)
 	}
 	return uint64(fileinfo.Size()), nil
 }
 
-// ReadFile reads a file from dropbox.
-func (backend *DropboxStorage) ReadFile(path string) ([]byte, error) {
-	file, err := backend.dropy.Download(path)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-	return ioutil.ReadAll(file)
+// ReadFile returns a reader for a file from dropbox.
+func (backend *DropboxStorage) ReadFile(path string) (io.ReadCloser, error) {
+	rc, err := backend.dropy.Download(path)
+	return rc, classifyError(err)
 }
 
 // WriteFile write files on dropbox.
-func (backend *DropboxStorage) WriteFile(path string, data []byte) (size uint64, err error) {
-	return uint64(len(data)), backend.dropy.Upload(path, bytes.NewReader(data))
+func (backend *DropboxStorage) WriteFile(path string, data io.Reader, size uint64) (uint64, error) {
+	return size, classifyError(backend.dropy.Upload(path, data))
 }
 
 // DeleteFile deletes a file from dropbox.
 func (backend *DropboxStorage) DeleteFile(path string) error {
-	return backend.dropy.Delete(path)
+	return classifyError(backend.dropy.Delete(path))
+}
+
+// classifyError marks errors of requests, which won't succeed when retried, as
+// permanent.
+func classifyError(err error) error {
+	derr, ok := err.(*dropbox.Error)
+	if !ok {
+		return err
+	}
+	// dropbox reports missing paths as a conflict
+	if strings.Contains(derr.Summary, "not_found") {
+		return knoxite.NotFound(err)
+	}
+	return knoxite.StatusError(derr.StatusCode, err)
 }
@@ -69,6 +69,10 @@ func TestStorageSaveRepository(t *testing.T) {
 	backendTest.SaveRepositoryTest(t)
 }
 
+func TestStorageSavePasswordHint(t *testing.T) {
+	backendTest.SavePasswordHintTest(t)
+}
+
 func TestAvailableSpace(t *testing.T) {
 	backendTest.AvailableSpaceTest(t)
 }
@@ -9,14 +9,14 @@
 package ftp
 
 import (
-	"bytes"
 	"errors"
-	"fmt"
-	"io/ioutil"
+	"io"
 	"net"
+	"net/textproto"
 	"net/url"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/jlaffaye/ftp"
@@ -30,6 +30,9 @@ type FTPStorage struct {
 	ftp   *ftp.ServerConn
 	login bool
 	knoxite.StorageFilesystem
+
+	// the connection can only handle one command at a time
+	mut sync.Mutex
 }
 
 // Error declarations.
@@ -114,6 +117,9 @@ func (backend *FTPStorage) AvailableSpace() (uint64, error) {
 
 // CreatePath creates a dir including all its parent dirs, when required.
 func (backend *FTPStorage) CreatePath(path string) error {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
 	slicedPath := strings.Split(path, "/")
 	for i := range slicedPath {
 		if i == 0 {
@@ -128,35 +134,46 @@ func (backend *FTPStorage) CreatePath(path string) error {
 
 // Stat returns the size of a file on ftp.
 func (backend *FTPStorage) Stat(path string) (uint64, error) {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
 	size, err := backend.ftp.FileSize(path)
-	return uint64(size), err
+	return uint64(size), classifyError(err)
 }
 
-// ReadFile reads a file from ftp.
-func (backend *FTPStorage) ReadFile(path string) ([]byte, error) {
-	file, err := backend.ftp.Retr(path)
+// ReadFile returns a reader for a file from ftp. The reader needs to be
+// closed before any other command can be sent to the server.
+func (backend *FTPStorage) ReadFile(path string) (io.ReadCloser, error) {
+	backend.mut.Lock()
+	r, err := backend.ftp.Retr(path)
 	if err != nil {
-		return nil, err
+		backend.mut.Unlock()
+		return nil, classifyError(err)
 	}
-	defer file.Close()
 
-	return ioutil.ReadAll(file)
+	return &response{Response: r, unlock: backend.mut.Unlock}, nil
 }
 
 // WriteFile writes file to ftp.
-func (backend *FTPStorage) WriteFile(path string, data []byte) (size uint64, err error) {
-	err = backend.ftp.Stor(path, bytes.NewReader(data))
-	return uint64(len(data)), err
+func (backend *FTPStorage) WriteFile(path string, data io.Reader, size uint64) (uint64, error) {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	err := backend.ftp.Stor(path, data)
+	return size, classifyError(err)
 }
 
 // DeleteFile deletes a file from ftp.
 func (backend *FTPStorage) DeleteFile(path string) error {
-	return backend.ftp.Delete(path)
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	return classifyError(backend.ftp.Delete(path))
 }
 
 // DeletePath deletes a directory including all its content from ftp.
 func (backend *FTPStorage) DeletePath(path string) error {
-	fmt.Println("Deleting path", path)
+	knoxite.GetLogger().Debugf("Deleting path %s", path)
 	list, err := backend.ftp.List("")
 	if err != nil {
 		return err
@@ -199,3 +216,32 @@ func (backend *FTPStorage) DeletePath(path string) error {
 
 	return nil
 }
+
+// classifyError marks errors, which won't go away when the command gets
+// retried, as permanent.
+func classifyError(err error) error {
+	var perr *textproto.Error
+	if !errors.As(err, &perr) {
+		return err
+	}
+
+	switch {
+	case perr.Code == ftp.StatusFileUnavailable:
+		return knoxite.NotFound(err)
+	case perr.Code >= 500:
+		// only replies in the 4xx range are transient
+		return knoxite.Permanent(err)
+	}
+	return err
+}
+
+// response releases the connection once a file has been read.
+type response struct {
+	*ftp.Response
+	unlock func()
+}
+
+func (r *response) Close() error {
+	defer r.unlock()
+	return r.Response.Close()
+}
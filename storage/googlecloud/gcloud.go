@@ -11,12 +11,13 @@ package googlecloud
 import (
 	"context"
 	"errors"
-	"io/ioutil"
+	"io"
 	"net/url"
 	"os"
 	"strings"
 
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 
 	"github.com/knoxite/knoxite"
@@ -138,44 +139,37 @@ func (backend *GoogleCloudStorage) Stat(path string) (uint64, error) {
 	folder := backend.bucket.Object(path)
 	attrs, err := folder.Attrs(context.Background())
 	if err != nil {
-		return 0, err
+		return 0, classifyError(err)
 	}
 
 	return uint64(attrs.Size), nil
 }
 
-// ReadFile reads a file from Google Cloud Storage.
-func (backend *GoogleCloudStorage) ReadFile(path string) ([]byte, error) {
-	reader, err := backend.bucket.Object(path).NewReader(context.Background())
+// ReadFile returns a reader for a file from Google Cloud Storage.
+func (backend *GoogleCloudStorage) ReadFile(path string) (io.ReadCloser, error) {
+	// read may return nil in some error situation so callers need to check the
+	// error from close
+	r, err := backend.bucket.Object(path).NewReader(context.Background())
 	if err != nil {
-		return nil, err
+		return nil, classifyError(err)
 	}
-	data, err := ioutil.ReadAll(reader)
-	if err != nil {
-		return nil, err
-	}
-	// read may return nil in some error situation so we need to check the error from close
-	err = reader.Close()
-	if err != nil {
-		return nil, err
-	}
-
-	return data, nil
+	return r, nil
 }
 
 // WriteFile writes a file on Google Cloud Storage.
-func (backend *GoogleCloudStorage) WriteFile(path string, data []byte) (size uint64, err error) {
+func (backend *GoogleCloudStorage) WriteFile(path string, data io.Reader, size uint64) (uint64, error) {
 	writer := backend.bucket.Object(path).NewWriter(context.Background())
 	// we set the ChunkSize to 0 to upload the data in a single request
 	writer.ChunkSize = 0
-	written, err := writer.Write(data)
+	written, err := io.Copy(writer, data)
 	if err != nil {
-		return 0, err
+		_ = writer.Close()
+		return 0, classifyError(err)
 	}
 	// write may return nil in some error situation so we need to check the error from close
 	err = writer.Close()
 	if err != nil {
-		return 0, err
+		return 0, classifyError(err)
 	}
 
 	return uint64(written), nil
@@ -185,7 +179,20 @@ func (backend *GoogleCloudStorage) WriteFile(path string, data []byte) (size uin
 func (backend *GoogleCloudStorage) DeleteFile(path string) error {
 	err := backend.bucket.Object(path).Delete(context.Background())
 	if err != nil {
-		return err
+		return classifyError(err)
 	}
 	return nil
 }
+
+// classifyError marks errors of requests, which won't succeed when retried, as
+// permanent.
+func classifyError(err error) error {
+	if err == storage.ErrObjectNotExist || err == storage.ErrBucketNotExist {
+		return knoxite.NotFound(err)
+	}
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		return knoxite.StatusError(gerr.Code, err)
+	}
+	return err
+}
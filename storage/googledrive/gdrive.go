@@ -9,14 +9,47 @@
 package googledrive
 
 import (
+	"context"
+	"errors"
+	"io"
 	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
 
 	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/storage"
+)
+
+const (
+	// appFolderName is the folder in the user's drive all knoxite data is stored in.
+	appFolderName  = "knoxite"
+	folderMimeType = "application/vnd.google-apps.folder"
+)
+
+// Error declarations.
+var (
+	ErrMissingClientCredentials = errors.New("Google Drive needs an OAuth2 client id and secret")
+	ErrFileNotFound             = errors.New("File not found on Google Drive")
 )
 
 // GoogleDriveStorage stores data on a remote Google Drive.
 type GoogleDriveStorage struct {
-	url url.URL
+	url     url.URL
+	service *drive.Service
+
+	// maps absolute paths to drive file ids
+	mut sync.Mutex
+	ids map[string]string
+
+	knoxite.StorageFilesystem
 }
 
 func init() {
@@ -24,8 +57,61 @@ func init() {
 }
 
 // NewBackend returns a GoogleDriveStorage backend.
+//
+// The OAuth2 client credentials are either passed as user info in the URL
+// (gdrive://CLIENT_ID:CLIENT_SECRET@/path) or set via the environment
+// variables KNOXITE_GDRIVE_CLIENT_ID and KNOXITE_GDRIVE_CLIENT_SECRET.
 func (*GoogleDriveStorage) NewBackend(u url.URL) (knoxite.Backend, error) {
-	return &GoogleDriveStorage{}, knoxite.ErrInvalidRepositoryURL
+	clientID := os.Getenv("KNOXITE_GDRIVE_CLIENT_ID")
+	clientSecret := os.Getenv("KNOXITE_GDRIVE_CLIENT_SECRET")
+	if u.User != nil && u.User.Username() != "" {
+		clientID = u.User.Username()
+		clientSecret, _ = u.User.Password()
+	}
+	if clientID == "" || clientSecret == "" {
+		return &GoogleDriveStorage{}, ErrMissingClientCredentials
+	}
+
+	ctx := context.Background()
+	ts, err := storage.NewDeviceTokenSource(ctx, &storage.DeviceAuthConfig{
+		Config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Endpoint:     google.Endpoint,
+			Scopes:       []string{drive.DriveFileScope},
+		},
+		DeviceAuthURL: "https://oauth2.googleapis.com/device/code",
+		Name:          "gdrive",
+	})
+	if err != nil {
+		return &GoogleDriveStorage{}, err
+	}
+
+	service, err := drive.NewService(ctx, option.WithTokenSource(ts))
+	if err != nil {
+		return &GoogleDriveStorage{}, err
+	}
+
+	backend := GoogleDriveStorage{
+		url:     u,
+		service: service,
+		ids:     make(map[string]string),
+	}
+
+	// the app folder is the root for all paths used by this backend
+	rootID, err := backend.findOrCreateFolder("root", appFolderName)
+	if err != nil {
+		return &GoogleDriveStorage{}, err
+	}
+	backend.ids["/"] = rootID
+
+	fs, err := knoxite.NewStorageFilesystem(path.Clean("/"+u.Path), &backend)
+	if err != nil {
+		return &GoogleDriveStorage{}, err
+	}
+	backend.StorageFilesystem = fs
+
+	return &backend, nil
 }
 
 // Location returns the type and location of the repository.
@@ -50,56 +136,235 @@ func (backend *GoogleDriveStorage) Description() string {
 
 // AvailableSpace returns the free space on this backend.
 func (backend *GoogleDriveStorage) AvailableSpace() (uint64, error) {
-	return 0, knoxite.ErrAvailableSpaceUnknown
+	about, err := backend.service.About.Get().Fields("storageQuota").Do()
+	if err != nil {
+		return 0, classifyError(err)
+	}
+	if about.StorageQuota == nil {
+		return 0, knoxite.ErrAvailableSpaceUnknown
+	}
+	if about.StorageQuota.Limit == 0 {
+		return 0, knoxite.ErrAvailableSpaceUnlimited
+	}
+
+	return uint64(about.StorageQuota.Limit - about.StorageQuota.Usage), nil
+}
+
+// CreatePath creates a dir including all its parent dirs, when required.
+func (backend *GoogleDriveStorage) CreatePath(p string) error {
+	parentID := backend.ids["/"]
+	current := "/"
+	for _, name := range splitPath(p) {
+		current = path.Join(current, name)
+		if id, ok := backend.cachedID(current); ok {
+			parentID = id
+			continue
+		}
+
+		id, err := backend.findOrCreateFolder(parentID, name)
+		if err != nil {
+			return classifyError(err)
+		}
+		backend.cacheID(current, id)
+		parentID = id
+	}
+
+	return nil
+}
+
+// Stat returns the size of a file.
+func (backend *GoogleDriveStorage) Stat(p string) (uint64, error) {
+	f, err := backend.lookup(p)
+	if err != nil {
+		return 0, classifyError(err)
+	}
+	return uint64(f.Size), nil
+}
+
+// ReadFile returns a reader for a file from Google Drive.
+func (backend *GoogleDriveStorage) ReadFile(p string) (io.ReadCloser, error) {
+	f, err := backend.lookup(p)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+
+	resp, err := backend.service.Files.Get(f.Id).Download()
+	if err != nil {
+		return nil, classifyError(err)
+	}
+
+	return resp.Body, nil
 }
 
-// LoadChunk loads a Chunk from Google Drive.
-func (backend *GoogleDriveStorage) LoadChunk(shasum string, part, totalParts uint) ([]byte, error) {
-	return []byte{}, knoxite.ErrLoadChunkFailed
+// WriteFile writes a file to Google Drive, replacing its content if it
+// already exists.
+func (backend *GoogleDriveStorage) WriteFile(p string, data io.Reader, size uint64) (uint64, error) {
+	if f, err := backend.lookup(p); err == nil {
+		_, err = backend.service.Files.Update(f.Id, &drive.File{}).
+			Media(data).Do()
+		return size, classifyError(err)
+	}
+
+	dir, name := path.Split(path.Clean("/" + p))
+	parentID, err := backend.folderID(dir)
+	if err != nil {
+		return 0, classifyError(err)
+	}
+
+	f, err := backend.service.Files.Create(&drive.File{
+		Name:    name,
+		Parents: []string{parentID},
+	}).Media(data).Fields("id").Do()
+	if err != nil {
+		return 0, classifyError(err)
+	}
+	backend.cacheID(path.Clean("/"+p), f.Id)
+
+	return size, nil
 }
 
-// StoreChunk stores a single Chunk on Google Drive.
-func (backend *GoogleDriveStorage) StoreChunk(shasum string, part, totalParts uint, data []byte) (size uint64, err error) {
-	return 0, knoxite.ErrStoreChunkFailed
+// DeleteFile deletes a file from Google Drive.
+func (backend *GoogleDriveStorage) DeleteFile(p string) error {
+	f, err := backend.lookup(p)
+	if err != nil {
+		return classifyError(err)
+	}
+
+	backend.mut.Lock()
+	delete(backend.ids, path.Clean("/"+p))
+	backend.mut.Unlock()
+
+	return classifyError(backend.service.Files.Delete(f.Id).Do())
+}
+
+// classifyError marks errors of requests, which won't succeed when retried, as
+// permanent.
+func classifyError(err error) error {
+	if err == ErrFileNotFound {
+		return knoxite.NotFound(err)
+	}
+
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return err
+	}
+	// exceeded rate limits get reported as forbidden
+	for _, e := range gerr.Errors {
+		if e.Reason == "rateLimitExceeded" || e.Reason == "userRateLimitExceeded" {
+			return err
+		}
+	}
+	return knoxite.StatusError(gerr.Code, err)
 }
 
-// DeleteChunk deletes a single Chunk.
-func (backend *GoogleDriveStorage) DeleteChunk(shasum string, parts, totalParts uint) error {
-	// FIXME: implement this
-	return knoxite.ErrDeleteChunkFailed
+func (backend *GoogleDriveStorage) cachedID(p string) (string, bool) {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	id, ok := backend.ids[p]
+	return id, ok
 }
 
-// LoadSnapshot loads a snapshot.
-func (backend *GoogleDriveStorage) LoadSnapshot(id string) ([]byte, error) {
-	return []byte{}, knoxite.ErrSnapshotNotFound
+func (backend *GoogleDriveStorage) cacheID(p, id string) {
+	backend.mut.Lock()
+	defer backend.mut.Unlock()
+
+	backend.ids[p] = id
 }
 
-// SaveSnapshot stores a snapshot.
-func (backend *GoogleDriveStorage) SaveSnapshot(id string, data []byte) error {
-	return knoxite.ErrStoreSnapshotFailed
+// folderID resolves the drive id of an existing folder.
+func (backend *GoogleDriveStorage) folderID(p string) (string, error) {
+	p = path.Clean("/" + p)
+	if id, ok := backend.cachedID(p); ok {
+		return id, nil
+	}
+
+	f, err := backend.lookup(p)
+	if err != nil {
+		return "", err
+	}
+	return f.Id, nil
 }
 
-// LoadChunkIndex reads the chunk-index.
-func (backend *GoogleDriveStorage) LoadChunkIndex() ([]byte, error) {
-	return []byte{}, knoxite.ErrLoadChunkIndexFailed
+// lookup walks down the folder hierarchy to find the file at path p.
+func (backend *GoogleDriveStorage) lookup(p string) (*drive.File, error) {
+	p = path.Clean("/" + p)
+
+	parentID := backend.ids["/"]
+	current := "/"
+	var f *drive.File
+	parts := splitPath(p)
+	for i, name := range parts {
+		current = path.Join(current, name)
+
+		// parent folders rarely change, so we can rely on cached ids for them
+		if id, ok := backend.cachedID(current); ok && i < len(parts)-1 {
+			parentID = id
+			continue
+		}
+
+		var err error
+		f, err = backend.find(parentID, name)
+		if err != nil {
+			return nil, err
+		}
+		backend.cacheID(current, f.Id)
+		parentID = f.Id
+	}
+	if f == nil {
+		return nil, ErrFileNotFound
+	}
+
+	return f, nil
 }
 
-// SaveChunkIndex stores the chunk-index.
-func (backend *GoogleDriveStorage) SaveChunkIndex(data []byte) error {
-	return knoxite.ErrStoreChunkIndexFailed
+// find returns the file called name inside the folder parentID.
+func (backend *GoogleDriveStorage) find(parentID, name string) (*drive.File, error) {
+	q := "name = '" + escapeQuery(name) + "' and '" + parentID + "' in parents and trashed = false"
+	list, err := backend.service.Files.List().Q(q).Fields("files(id, name, size, mimeType)").Do()
+	if err != nil {
+		return nil, err
+	}
+	if len(list.Files) == 0 {
+		return nil, ErrFileNotFound
+	}
+
+	return list.Files[0], nil
 }
 
-// InitRepository creates a new repository.
-func (backend *GoogleDriveStorage) InitRepository() error {
-	return knoxite.ErrInvalidRepositoryURL
+func (backend *GoogleDriveStorage) findOrCreateFolder(parentID, name string) (string, error) {
+	f, err := backend.find(parentID, name)
+	if err == nil {
+		return f.Id, nil
+	}
+	if err != ErrFileNotFound {
+		return "", err
+	}
+
+	f, err = backend.service.Files.Create(&drive.File{
+		Name:     name,
+		MimeType: folderMimeType,
+		Parents:  []string{parentID},
+	}).Fields("id").Do()
+	if err != nil {
+		return "", err
+	}
+
+	return f.Id, nil
 }
 
-// LoadRepository reads the metadata for a repository.
-func (backend *GoogleDriveStorage) LoadRepository() ([]byte, error) {
-	return []byte{}, knoxite.ErrLoadRepositoryFailed
+func splitPath(p string) []string {
+	var parts []string
+	for _, s := range strings.Split(p, "/") {
+		if s != "" && s != "." {
+			parts = append(parts, s)
+		}
+	}
+
+	return parts
 }
 
-// SaveRepository stores the metadata for a repository.
-func (backend *GoogleDriveStorage) SaveRepository(data []byte) error {
-	return knoxite.ErrStoreRepositoryFailed
+func escapeQuery(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return strings.ReplaceAll(s, `'`, `\'`)
 }
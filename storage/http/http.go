@@ -9,9 +9,10 @@ package http
 
 import (
 	"bytes"
-	"fmt"
+	"context"
+	"encoding/json"
+	"io"
 	"io/ioutil"
-	"log"
 	"mime/multipart"
 	"net/http"
 	"net/url"
@@ -62,49 +63,62 @@ func (backend *HTTPStorage) AvailableSpace() (uint64, error) {
 }
 
 // LoadChunk loads a Chunk from network.
-func (backend *HTTPStorage) LoadChunk(shasum string, part, totalParts uint) ([]byte, error) {
+func (backend *HTTPStorage) LoadChunk(ctx context.Context, shasum string, part, totalParts uint) (io.ReadCloser, error) {
 	//	fmt.Printf("Fetching from: %s.\n", backend.URL+"/download/"+chunk.ShaSum)
-	res, err := http.Get(backend.URL.String() + "/download/" + shasum + "." + strconv.FormatUint(uint64(part), 10) + "_" + strconv.FormatUint(uint64(totalParts), 10))
+	req, err := http.NewRequestWithContext(ctx, "GET", backend.URL.String()+"/download/"+shasum+"."+strconv.FormatUint(uint64(part), 10)+"_"+strconv.FormatUint(uint64(totalParts), 10), nil)
 	if err != nil {
-		return []byte{}, err
+		return nil, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
 	}
-	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return []byte{}, knoxite.ErrLoadChunkFailed
+		res.Body.Close()
+		return nil, knoxite.StatusError(res.StatusCode, knoxite.ErrLoadChunkFailed)
 	}
 
-	return ioutil.ReadAll(res.Body)
+	return res.Body, nil
 }
 
 // StoreChunk stores a single Chunk on network.
-func (backend *HTTPStorage) StoreChunk(shasum string, part, totalParts uint, data []byte) (uint64, error) {
-	bodyBuf := &bytes.Buffer{}
-	bodyWriter := multipart.NewWriter(bodyBuf)
+func (backend *HTTPStorage) StoreChunk(ctx context.Context, shasum string, part, totalParts uint, data io.Reader, size uint64) (uint64, error) {
+	// the multipart body gets streamed to the server while it's being written
+	bodyReader, bodyPipe := io.Pipe()
+	bodyWriter := multipart.NewWriter(bodyPipe)
+	contentType := bodyWriter.FormDataContentType()
 
-	// this step is very important
-	fileWriter, err := bodyWriter.CreateFormFile("uploadfile", shasum+"."+strconv.FormatUint(uint64(part), 10)+"_"+strconv.FormatUint(uint64(totalParts), 10))
-	if err != nil {
-		fmt.Println("error writing to buffer")
-		return 0, err
-	}
+	go func() {
+		// this step is very important
+		fileWriter, err := bodyWriter.CreateFormFile("uploadfile", shasum+"."+strconv.FormatUint(uint64(part), 10)+"_"+strconv.FormatUint(uint64(totalParts), 10))
+		if err != nil {
+			bodyPipe.CloseWithError(err)
+			return
+		}
 
-	_, err = fileWriter.Write(data)
+		if _, err = io.Copy(fileWriter, data); err != nil {
+			bodyPipe.CloseWithError(err)
+			return
+		}
+
+		bodyPipe.CloseWithError(bodyWriter.Close())
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", backend.URL.String()+"/upload", bodyReader)
 	if err != nil {
+		bodyReader.Close()
 		return 0, err
 	}
-
-	contentType := bodyWriter.FormDataContentType()
-	bodyWriter.Close()
-
-	resp, err := http.Post(backend.URL.String()+"/upload", contentType, bodyBuf)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return 0, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return 0, knoxite.ErrStoreChunkFailed
+		return 0, knoxite.StatusError(resp.StatusCode, knoxite.ErrStoreChunkFailed)
 	}
 	_, err = ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -112,13 +126,94 @@ func (backend *HTTPStorage) StoreChunk(shasum string, part, totalParts uint, dat
 	}
 
 	//	fmt.Printf("\tUploaded chunk: %d bytes\n", len(*data))
-	return uint64(len(data)), err
+	return size, err
 }
 
 // DeleteChunk deletes a single Chunk.
-func (backend *HTTPStorage) DeleteChunk(shasum string, parts, totalParts uint) error {
+func (backend *HTTPStorage) DeleteChunk(ctx context.Context, shasum string, parts, totalParts uint) error {
 	// FIXME: implement this
-	return knoxite.ErrDeleteChunkFailed
+	return knoxite.Permanent(knoxite.ErrDeleteChunkFailed)
+}
+
+// HasChunk asks the server whether it stores a single Chunk already.
+func (backend *HTTPStorage) HasChunk(ctx context.Context, shasum string, part, totalParts uint) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", backend.URL.String()+"/download/"+shasum+"."+strconv.FormatUint(uint64(part), 10)+"_"+strconv.FormatUint(uint64(totalParts), 10), nil)
+	if err != nil {
+		return false, err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, knoxite.StatusError(res.StatusCode, knoxite.ErrLoadChunkFailed)
+	}
+}
+
+// AddChunkReferences tells the server which chunks a snapshot references.
+func (backend *HTTPStorage) AddChunkReferences(snapshot string, chunks []knoxite.ChunkIndexItem) error {
+	b, err := json.Marshal(chunks)
+	if err != nil {
+		return err
+	}
+
+	resp, err := http.Post(backend.URL.String()+"/references/"+snapshot, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return knoxite.StatusError(resp.StatusCode, knoxite.ErrStoreChunkIndexFailed)
+	}
+	return nil
+}
+
+// RemoveChunkReferences tells the server to drop the chunk references of a
+// snapshot.
+func (backend *HTTPStorage) RemoveChunkReferences(snapshot string) error {
+	req, err := http.NewRequest("DELETE", backend.URL.String()+"/references/"+snapshot, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return knoxite.StatusError(resp.StatusCode, knoxite.ErrStoreChunkIndexFailed)
+	}
+	return nil
+}
+
+// PackChunks lets the server delete all chunks no longer referenced by any
+// snapshot.
+func (backend *HTTPStorage) PackChunks(ctx context.Context) (uint64, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", backend.URL.String()+"/pack", nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, knoxite.StatusError(resp.StatusCode, knoxite.ErrDeleteChunkFailed)
+	}
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(bytes.TrimSpace(b)), 10, 64)
 }
 
 // LoadSnapshot loads a snapshot.
@@ -126,7 +221,7 @@ func (backend *HTTPStorage) LoadSnapshot(id string) ([]byte, error) {
 	//	fmt.Printf("Fetching snapshot from: %s.\n", backend.URL+"/snapshot/"+id)
 	res, err := http.Get(backend.URL.String() + "/snapshot/" + id)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer res.Body.Close()
 
@@ -141,7 +236,6 @@ func (backend *HTTPStorage) SaveSnapshot(id string, data []byte) error {
 	// this step is very important
 	fileWriter, err := bodyWriter.CreateFormFile("uploadfile", id)
 	if err != nil {
-		fmt.Println("error writing to buffer")
 		return err
 	}
 
@@ -163,7 +257,7 @@ func (backend *HTTPStorage) SaveSnapshot(id string, data []byte) error {
 		return err
 	}
 	if resp.StatusCode != http.StatusOK {
-		return knoxite.ErrStoreSnapshotFailed
+		return knoxite.StatusError(resp.StatusCode, knoxite.ErrStoreSnapshotFailed)
 	}
 	//	fmt.Printf("Uploaded snapshot: %d bytes\n", len(data))
 	return err
@@ -174,7 +268,7 @@ func (backend *HTTPStorage) LoadChunkIndex() ([]byte, error) {
 	//	fmt.Printf("Fetching chunk-index from: %s.\n", backend.URL+"/chunkindex")
 	res, err := http.Get(backend.URL.String() + "/chunkindex")
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer res.Body.Close()
 
@@ -189,7 +283,6 @@ func (backend *HTTPStorage) SaveChunkIndex(data []byte) error {
 	// this step is very important
 	fileWriter, err := bodyWriter.CreateFormFile("uploadfile", "chunkindex")
 	if err != nil {
-		fmt.Println("error writing to buffer")
 		return err
 	}
 
@@ -227,7 +320,7 @@ func (backend *HTTPStorage) LoadRepository() ([]byte, error) {
 	//	fmt.Printf("Fetching repository from: %s.\n", backend.URL+"/repository")
 	res, err := http.Get(backend.URL.String() + "/repository")
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	defer res.Body.Close()
 
@@ -242,7 +335,6 @@ func (backend *HTTPStorage) SaveRepository(data []byte) error {
 	// this step is very important
 	fileWriter, err := bodyWriter.CreateFormFile("uploadfile", "repository.knoxite")
 	if err != nil {
-		fmt.Println("error writing to buffer")
 		return err
 	}
 
@@ -269,3 +361,50 @@ func (backend *HTTPStorage) SaveRepository(data []byte) error {
 	//	fmt.Printf("Uploaded repository: %d bytes\n", len(data))
 	return err
 }
+
+// LoadPasswordHint reads the password hint.
+func (backend *HTTPStorage) LoadPasswordHint() ([]byte, error) {
+	res, err := http.Get(backend.URL.String() + "/hint")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, knoxite.ErrLoadPasswordHintFailed
+	}
+
+	return ioutil.ReadAll(res.Body)
+}
+
+// SavePasswordHint stores the password hint.
+func (backend *HTTPStorage) SavePasswordHint(data []byte) error {
+	bodyBuf := &bytes.Buffer{}
+	bodyWriter := multipart.NewWriter(bodyBuf)
+
+	fileWriter, err := bodyWriter.CreateFormFile("uploadfile", "hint")
+	if err != nil {
+		return err
+	}
+
+	_, err = fileWriter.Write(data)
+	if err != nil {
+		return err
+	}
+
+	contentType := bodyWriter.FormDataContentType()
+	bodyWriter.Close()
+
+	resp, err := http.Post(backend.URL.String()+"/hint", contentType, bodyBuf)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return knoxite.ErrStorePasswordHintFailed
+	}
+	return err
+}
@@ -11,6 +11,7 @@ package mega
 
 import (
 	"errors"
+	"io"
 	"net/url"
 	"path/filepath"
 	"strings"
@@ -106,7 +107,7 @@ func (backend *MegaStorage) CreatePath(path string) error {
 			// if we couldn't find a node at this path we need to create it
 			currentRoot, err = backend.mega.CreateDir(pathSlice, currentRoot)
 			if err != nil {
-				return err
+				return classifyError(err)
 			}
 		} else {
 			// if nodes with the same name exist we take the node at index 0
@@ -120,41 +121,66 @@ func (backend *MegaStorage) CreatePath(path string) error {
 func (backend *MegaStorage) Stat(path string) (uint64, error) {
 	node, err := backend.getNodeFromPath(path)
 	if err != nil {
-		return 0, err
+		return 0, classifyError(err)
 	}
 
 	return uint64(node.GetSize()), nil
 }
 
-// ReadFile reads a file from mega.
-func (backend *MegaStorage) ReadFile(path string) ([]byte, error) {
+// ReadFile returns a reader for a file from mega.
+func (backend *MegaStorage) ReadFile(path string) (io.ReadCloser, error) {
 	nodeToRead, err := backend.getNodeFromPath(path)
 	if err != nil {
-		return nil, err
+		return nil, classifyError(err)
 	}
 
 	download, err := backend.mega.NewDownload(nodeToRead)
 	if err != nil {
-		return nil, err
+		return nil, classifyError(err)
 	}
 
-	var bytes []byte
-	for i := 0; i < download.Chunks(); i++ {
-		chunkBytes, err := download.DownloadChunk(i)
+	return &downloadReader{download: download}, nil
+}
+
+// downloadReader fetches a mega download chunk by chunk while it's being read.
+type downloadReader struct {
+	download *mega.Download
+	chunk    int
+	buf      []byte
+}
+
+func (r *downloadReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.chunk >= r.download.Chunks() {
+			return 0, io.EOF
+		}
+
+		var err error
+		r.buf, err = r.download.DownloadChunk(r.chunk)
 		if err != nil {
-			return nil, err
+			return 0, classifyError(err)
 		}
-		bytes = append(bytes, chunkBytes...)
+		r.chunk++
 	}
 
-	return bytes, download.Finish()
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Close verifies the download once it has been read completely.
+func (r *downloadReader) Close() error {
+	if r.chunk < r.download.Chunks() {
+		return nil
+	}
+	return classifyError(r.download.Finish())
 }
 
 // WriteFile write files on mega.
-func (backend *MegaStorage) WriteFile(path string, data []byte) (size uint64, err error) {
+func (backend *MegaStorage) WriteFile(path string, data io.Reader, size uint64) (uint64, error) {
 	dir, file := filepath.Split(path)
 
-	_, err = backend.getNodeFromPath(path)
+	_, err := backend.getNodeFromPath(path)
 	if err == nil {
 		// sadly, if the file exists it needs to be deleted before re-uploading, otherwise there will be a copy
 		err = backend.DeleteFile(path)
@@ -165,39 +191,42 @@ func (backend *MegaStorage) WriteFile(path string, data []byte) (size uint64, er
 
 	nodeToWriteIn, err := backend.getNodeFromPath(dir)
 	if err != nil {
-		return 0, err
+		return 0, classifyError(err)
 	}
 
-	upload, err := backend.mega.NewUpload(nodeToWriteIn, file, int64(len(data)))
+	upload, err := backend.mega.NewUpload(nodeToWriteIn, file, int64(size))
 	if err != nil {
-		return 0, err
+		return 0, classifyError(err)
 	}
 
-	// creating a copy of data is a workaround for a bug in the github.com/t3rm1n4l/go-mega library, that overwrites data instead of using a copy itself
-	datacopy := make([]byte, len(data))
-	copy(datacopy, data)
 	for id := 0; id < upload.Chunks(); id++ {
-		chk_start, chk_size, err := upload.ChunkLocation(id)
+		_, chk_size, err := upload.ChunkLocation(id)
 		if err != nil {
+			return 0, classifyError(err)
+		}
+
+		// every chunk gets its own buffer, as the github.com/t3rm1n4l/go-mega library overwrites data instead of using a copy itself
+		chunk := make([]byte, chk_size)
+		if _, err := io.ReadFull(data, chunk); err != nil {
 			return 0, err
 		}
-		err = upload.UploadChunk(id, datacopy[chk_start:chk_start+int64(chk_size)])
+		err = upload.UploadChunk(id, chunk)
 		if err != nil {
-			return 0, err
+			return 0, classifyError(err)
 		}
 	}
 	_, err = upload.Finish()
-	return uint64(len(data)), err
+	return size, classifyError(err)
 }
 
 // DeleteFile deletes a file from mega.
 func (backend *MegaStorage) DeleteFile(path string) error {
 	fileToDelete, err := backend.getNodeFromPath(path)
 	if err != nil {
-		return err
+		return classifyError(err)
 	}
 
-	return backend.mega.Delete(fileToDelete, true)
+	return classifyError(backend.mega.Delete(fileToDelete, true))
 }
 
 // getNodeFromPath() returns the last node in a path on mega. It may be a file or a directory node.
@@ -225,12 +254,25 @@ func (backend *MegaStorage) getNodeFromPath(path string) (*mega.Node, error) {
 			}
 		}
 		if !found {
-			return nil, errors.New("file or directory not found on mega: " + pathSlice)
+			return nil, knoxite.NotFound(errors.New("file or directory not found on mega: " + pathSlice))
 		}
 		// last element of slicedPath is the actual file/directory node
 		if i == len(slicedPath)-1 {
 			return currentRoot, nil
 		}
 	}
-	return nil, errors.New("file or directory not found on mega")
+	return nil, knoxite.NotFound(errors.New("file or directory not found on mega"))
+}
+
+// classifyError marks errors, which won't go away when the request gets
+// retried, as permanent.
+func classifyError(err error) error {
+	switch err {
+	case mega.ENOENT:
+		return knoxite.NotFound(err)
+	case mega.EARGS, mega.EACCESS, mega.EKEY, mega.ESID, mega.EBLOCKED, mega.EOVERQUOTA,
+		mega.EGOINGOVERQUOTA, mega.EAPPKEY, mega.EMFAREQUIRED, mega.EMACMISMATCH:
+		return knoxite.Permanent(err)
+	}
+	return err
 }
@@ -0,0 +1,250 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package storage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"github.com/knoxite/knoxite"
+)
+
+const deviceGrant = "urn:ietf:params:oauth:grant-type:device_code"
+
+// Error declarations.
+var (
+	ErrDeviceAuthDenied  = errors.New("Authorization was denied")
+	ErrDeviceAuthExpired = errors.New("Authorization request expired")
+)
+
+// DeviceAuthConfig describes an OAuth2 client which authorizes itself via
+// the OAuth2 device flow.
+type DeviceAuthConfig struct {
+	oauth2.Config
+
+	// DeviceAuthURL is the endpoint to request device & user codes from
+	DeviceAuthURL string
+	// Name is used to identify the service towards the user and for the
+	// token cache
+	Name string
+}
+
+type deviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	// Google deviates from RFC 8628 here
+	VerificationURL string `json:"verification_url"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+type deviceToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	Error        string `json:"error"`
+}
+
+// tokenCachePath returns the path of the file the refresh token for a client
+// gets cached in. Every client gets its own file in knoxite's state dir.
+func tokenCachePath(conf *DeviceAuthConfig) (string, error) {
+	dir, err := knoxite.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, tokenCacheFile(conf)), nil
+}
+
+func tokenCacheFile(conf *DeviceAuthConfig) string {
+	h := sha256.Sum256([]byte(conf.ClientID))
+	return conf.Name + "-" + hex.EncodeToString(h[:8]) + ".json"
+}
+
+func loadToken(path string) (*oauth2.Token, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tok oauth2.Token
+	err = json.Unmarshal(b, &tok)
+	return &tok, err
+}
+
+func saveToken(path string, tok *oauth2.Token) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+// cachingTokenSource writes refreshed tokens back to the token cache.
+type cachingTokenSource struct {
+	path string
+	src  oauth2.TokenSource
+
+	mut  sync.Mutex
+	last string
+}
+
+func (ts *cachingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := ts.src.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	ts.mut.Lock()
+	defer ts.mut.Unlock()
+	if tok.AccessToken != ts.last {
+		ts.last = tok.AccessToken
+		_ = saveToken(ts.path, tok)
+	}
+
+	return tok, nil
+}
+
+// NewDeviceTokenSource returns a TokenSource for the given OAuth2 client. A
+// cached refresh token is used if available, otherwise the user gets asked to
+// authorize knoxite via the OAuth2 device flow.
+func NewDeviceTokenSource(ctx context.Context, conf *DeviceAuthConfig) (oauth2.TokenSource, error) {
+	path, err := tokenCachePath(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	tok, err := loadToken(path)
+	if err != nil || tok.RefreshToken == "" {
+		tok, err = deviceAuth(ctx, conf)
+		if err != nil {
+			return nil, err
+		}
+		if err = saveToken(path, tok); err != nil {
+			return nil, err
+		}
+	}
+
+	return &cachingTokenSource{
+		path: path,
+		src:  conf.Config.TokenSource(ctx, tok),
+		last: tok.AccessToken,
+	}, nil
+}
+
+// deviceAuth runs the OAuth2 device authorization flow: it requests a user
+// code, asks the user to enter it on the provider's verification page and then
+// polls until access got granted or denied.
+func deviceAuth(ctx context.Context, conf *DeviceAuthConfig) (*oauth2.Token, error) {
+	v := url.Values{
+		"client_id": {conf.ClientID},
+		"scope":     {strings.Join(conf.Scopes, " ")},
+	}
+
+	var dc deviceCode
+	if err := postForm(ctx, conf.DeviceAuthURL, v, &dc); err != nil {
+		return nil, err
+	}
+	if dc.DeviceCode == "" {
+		return nil, fmt.Errorf("Invalid device code response from %s", conf.Name)
+	}
+	if dc.VerificationURI == "" {
+		dc.VerificationURI = dc.VerificationURL
+	}
+
+	fmt.Fprintf(os.Stderr, "To grant knoxite access to %s, visit %s and enter the code: %s\n",
+		conf.Name, dc.VerificationURI, dc.UserCode)
+
+	interval := time.Duration(dc.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(dc.ExpiresIn) * time.Second)
+
+	v = url.Values{
+		"client_id":   {conf.ClientID},
+		"device_code": {dc.DeviceCode},
+		"grant_type":  {deviceGrant},
+	}
+	if conf.ClientSecret != "" {
+		v.Set("client_secret", conf.ClientSecret)
+	}
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		var dt deviceToken
+		if err := postForm(ctx, conf.Endpoint.TokenURL, v, &dt); err != nil {
+			return nil, err
+		}
+
+		switch dt.Error {
+		case "":
+			return &oauth2.Token{
+				AccessToken:  dt.AccessToken,
+				RefreshToken: dt.RefreshToken,
+				TokenType:    dt.TokenType,
+				Expiry:       time.Now().Add(time.Duration(dt.ExpiresIn) * time.Second),
+			}, nil
+		case "authorization_pending":
+			continue
+		case "slow_down":
+			interval += 5 * time.Second
+		case "access_denied":
+			return nil, ErrDeviceAuthDenied
+		case "expired_token":
+			return nil, ErrDeviceAuthExpired
+		default:
+			return nil, fmt.Errorf("%s authorization failed: %s", conf.Name, dt.Error)
+		}
+	}
+
+	return nil, ErrDeviceAuthExpired
+}
+
+// postForm posts v to u and decodes the JSON response into res. Error
+// responses of the token endpoint are valid JSON as well, so the status code
+// is deliberately ignored here.
+func postForm(ctx context.Context, u string, v url.Values, res interface{}) error {
+	req, err := http.NewRequest("POST", u, strings.NewReader(v.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(res)
+}
@@ -0,0 +1,351 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package onedrive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/microsoft"
+
+	"github.com/knoxite/knoxite"
+	"github.com/knoxite/knoxite/storage"
+)
+
+const (
+	graphURL = "https://graph.microsoft.com/v1.0/me/drive"
+	// all data is stored in the app's dedicated folder
+	appRoot = graphURL + "/special/approot"
+
+	// files exceeding this size need to be uploaded via an upload session
+	simpleUploadLimit = 4 << 20
+	// size of a single upload session fragment, must be a multiple of 320 KiB
+	uploadFragmentSize = 32 * 320 << 10
+)
+
+// Error declarations.
+var (
+	ErrMissingClientID = errors.New("OneDrive needs an OAuth2 client id")
+	ErrFileNotFound    = errors.New("File not found on OneDrive")
+)
+
+// OneDriveStorage stores data on Microsoft OneDrive.
+type OneDriveStorage struct {
+	url    url.URL
+	client *http.Client
+	knoxite.StorageFilesystem
+}
+
+// GraphError is an error returned by the Microsoft Graph API.
+type GraphError struct {
+	StatusCode int
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *GraphError) Error() string {
+	return fmt.Sprintf("OneDrive request failed (%d): %s %s", e.StatusCode, e.Code, e.Message)
+}
+
+type driveItem struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Size uint64 `json:"size"`
+}
+
+type drive struct {
+	Quota struct {
+		Total     uint64 `json:"total"`
+		Remaining uint64 `json:"remaining"`
+	} `json:"quota"`
+}
+
+func init() {
+	knoxite.RegisterStorageBackend(&OneDriveStorage{})
+}
+
+// NewBackend returns a OneDriveStorage backend.
+//
+// The OAuth2 client id is either passed as user info in the URL
+// (onedrive://CLIENT_ID@/path) or set via the environment variable
+// KNOXITE_ONEDRIVE_CLIENT_ID.
+func (*OneDriveStorage) NewBackend(u url.URL) (knoxite.Backend, error) {
+	clientID := os.Getenv("KNOXITE_ONEDRIVE_CLIENT_ID")
+	if u.User != nil && u.User.Username() != "" {
+		clientID = u.User.Username()
+	}
+	if clientID == "" {
+		return &OneDriveStorage{}, ErrMissingClientID
+	}
+
+	ctx := context.Background()
+	ts, err := storage.NewDeviceTokenSource(ctx, &storage.DeviceAuthConfig{
+		Config: oauth2.Config{
+			ClientID: clientID,
+			Endpoint: microsoft.AzureADEndpoint("common"),
+			Scopes:   []string{"Files.ReadWrite.AppFolder", "offline_access"},
+		},
+		DeviceAuthURL: "https://login.microsoftonline.com/common/oauth2/v2.0/devicecode",
+		Name:          "onedrive",
+	})
+	if err != nil {
+		return &OneDriveStorage{}, err
+	}
+
+	backend := OneDriveStorage{
+		url:    u,
+		client: oauth2.NewClient(ctx, ts),
+	}
+
+	fs, err := knoxite.NewStorageFilesystem(path.Clean("/"+u.Path), &backend)
+	if err != nil {
+		return &OneDriveStorage{}, err
+	}
+	backend.StorageFilesystem = fs
+
+	return &backend, nil
+}
+
+// Location returns the type and location of the repository.
+func (backend *OneDriveStorage) Location() string {
+	return backend.url.String()
+}
+
+// Close the backend.
+func (backend *OneDriveStorage) Close() error {
+	return nil
+}
+
+// Protocols returns the Protocol Schemes supported by this backend.
+func (backend *OneDriveStorage) Protocols() []string {
+	return []string{"onedrive"}
+}
+
+// Description returns a user-friendly description for this backend.
+func (backend *OneDriveStorage) Description() string {
+	return "OneDrive Storage"
+}
+
+// AvailableSpace returns the free space on this backend.
+func (backend *OneDriveStorage) AvailableSpace() (uint64, error) {
+	var d drive
+	if err := backend.request("GET", graphURL, nil, "", &d); err != nil {
+		return 0, err
+	}
+	if d.Quota.Total == 0 {
+		return 0, knoxite.ErrAvailableSpaceUnknown
+	}
+
+	return d.Quota.Remaining, nil
+}
+
+// CreatePath creates a dir including all its parent dirs, when required.
+func (backend *OneDriveStorage) CreatePath(p string) error {
+	current := "/"
+	for _, name := range strings.Split(strings.Trim(path.Clean("/"+p), "/"), "/") {
+		if name == "" {
+			continue
+		}
+
+		body, err := json.Marshal(map[string]interface{}{
+			"name":                              name,
+			"folder":                            map[string]interface{}{},
+			"@microsoft.graph.conflictBehavior": "fail",
+		})
+		if err != nil {
+			return err
+		}
+
+		err = backend.request("POST", itemURL(current, "children"), bytes.NewReader(body), "application/json", nil)
+		var gerr *GraphError
+		if errors.As(err, &gerr) && gerr.StatusCode == http.StatusConflict {
+			// folder already exists
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+
+		current = path.Join(current, name)
+	}
+
+	return nil
+}
+
+// Stat returns the size of a file.
+func (backend *OneDriveStorage) Stat(p string) (uint64, error) {
+	var item driveItem
+	if err := backend.request("GET", itemURL(p, ""), nil, "", &item); err != nil {
+		return 0, err
+	}
+
+	return item.Size, nil
+}
+
+// ReadFile returns a reader for a file from OneDrive.
+func (backend *OneDriveStorage) ReadFile(p string) (io.ReadCloser, error) {
+	resp, err := backend.do("GET", itemURL(p, "content"), nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// WriteFile writes a file to OneDrive.
+func (backend *OneDriveStorage) WriteFile(p string, data io.Reader, size uint64) (uint64, error) {
+	if size <= simpleUploadLimit {
+		// small files are buffered, as the Graph API wants to know their
+		// Content-Length upfront
+		buf := make([]byte, size)
+		if _, err := io.ReadFull(data, buf); err != nil {
+			return 0, err
+		}
+
+		err := backend.request("PUT", itemURL(p, "content"), bytes.NewReader(buf), "application/octet-stream", nil)
+		return size, err
+	}
+
+	return size, backend.uploadSession(p, data, size)
+}
+
+// DeleteFile deletes a file from OneDrive.
+func (backend *OneDriveStorage) DeleteFile(p string) error {
+	return backend.request("DELETE", itemURL(p, ""), nil, "", nil)
+}
+
+// uploadSession uploads large files in fragments.
+func (backend *OneDriveStorage) uploadSession(p string, data io.Reader, size uint64) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"item": map[string]interface{}{
+			"@microsoft.graph.conflictBehavior": "replace",
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	var session struct {
+		UploadURL string `json:"uploadUrl"`
+	}
+	err = backend.request("POST", itemURL(p, "createUploadSession"), bytes.NewReader(body), "application/json", &session)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, uploadFragmentSize)
+	for offset := uint64(0); offset < size; {
+		n, err := io.ReadFull(data, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		// the upload url is pre-authenticated and must not receive our token
+		req, err := http.NewRequest("PUT", session.UploadURL, bytes.NewReader(buf[:n]))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+uint64(n)-1, size))
+		offset += uint64(n)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		err = checkResponse(resp)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// request sends a request to the Graph API and decodes the JSON response
+// into res, unless res is nil.
+func (backend *OneDriveStorage) request(method, u string, body io.Reader, contentType string, res interface{}) error {
+	resp, err := backend.do(method, u, body, contentType)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if res == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
+
+func (backend *OneDriveStorage) do(method, u string, body io.Reader, contentType string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := backend.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// checkResponse returns the error of a failed request, marked as permanent
+// unless the request may succeed when it gets retried.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return knoxite.NotFound(ErrFileNotFound)
+	}
+
+	var res struct {
+		Error GraphError `json:"error"`
+	}
+	_ = json.NewDecoder(resp.Body).Decode(&res)
+	res.Error.StatusCode = resp.StatusCode
+
+	return knoxite.StatusError(resp.StatusCode, &res.Error)
+}
+
+// itemURL returns the Graph API URL addressing the item at path p within the
+// app folder, optionally followed by an action.
+func itemURL(p, action string) string {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		if action == "" {
+			return appRoot
+		}
+		return appRoot + "/" + action
+	}
+
+	u := appRoot + ":/" + (&url.URL{Path: p}).EscapedPath()
+	if action != "" {
+		u += ":/" + action
+	}
+	return u
+}
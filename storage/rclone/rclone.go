@@ -0,0 +1,246 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package rclone
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/knoxite/knoxite"
+)
+
+// Error declarations.
+var (
+	ErrRcloneNotFound = errors.New("rclone executable not found")
+	ErrInvalidRemote  = errors.New("Invalid rclone remote specified")
+	ErrFileNotFound   = errors.New("File not found on rclone remote")
+)
+
+// RcloneStorage stores data on any remote configured in rclone.
+type RcloneStorage struct {
+	url    url.URL
+	bin    string
+	remote string
+	// whether paths are absolute on the remote or relative to its root
+	absolute bool
+	knoxite.StorageFilesystem
+}
+
+func init() {
+	knoxite.RegisterStorageBackend(&RcloneStorage{})
+}
+
+// NewBackend returns a RcloneStorage backend.
+//
+// The host part of the URL is the name of the rclone remote, e.g.
+// rclone://remote/path. The rclone executable is looked up in $PATH, unless
+// the environment variable KNOXITE_RCLONE_BINARY points to it.
+func (*RcloneStorage) NewBackend(u url.URL) (knoxite.Backend, error) {
+	if u.Hostname() == "" {
+		return &RcloneStorage{}, ErrInvalidRemote
+	}
+
+	bin := os.Getenv("KNOXITE_RCLONE_BINARY")
+	if bin == "" {
+		bin = "rclone"
+	}
+	bin, err := exec.LookPath(bin)
+	if err != nil {
+		return &RcloneStorage{}, ErrRcloneNotFound
+	}
+
+	backend := RcloneStorage{
+		url:      u,
+		bin:      bin,
+		remote:   u.Hostname(),
+		absolute: strings.HasPrefix(u.Path, "//"),
+	}
+
+	fs, err := knoxite.NewStorageFilesystem(path.Clean("/"+u.Path), &backend)
+	if err != nil {
+		return &RcloneStorage{}, err
+	}
+	backend.StorageFilesystem = fs
+
+	return &backend, nil
+}
+
+// Location returns the type and location of the repository.
+func (backend *RcloneStorage) Location() string {
+	return backend.url.String()
+}
+
+// Close the backend.
+func (backend *RcloneStorage) Close() error {
+	return nil
+}
+
+// Protocols returns the Protocol Schemes supported by this backend.
+func (backend *RcloneStorage) Protocols() []string {
+	return []string{"rclone"}
+}
+
+// Description returns a user-friendly description for this backend.
+func (backend *RcloneStorage) Description() string {
+	return "rclone Storage"
+}
+
+// AvailableSpace returns the free space on this backend.
+func (backend *RcloneStorage) AvailableSpace() (uint64, error) {
+	out, err := backend.rclone(nil, "about", "--json", backend.remote+":")
+	if err != nil {
+		// not all remotes support querying their quota
+		return 0, knoxite.ErrAvailableSpaceUnknown
+	}
+
+	var about struct {
+		Free *uint64 `json:"free"`
+	}
+	if err := json.Unmarshal(out, &about); err != nil {
+		return 0, err
+	}
+	if about.Free == nil {
+		return 0, knoxite.ErrAvailableSpaceUnknown
+	}
+
+	return *about.Free, nil
+}
+
+// CreatePath creates a dir including all its parent dirs, when required.
+func (backend *RcloneStorage) CreatePath(p string) error {
+	_, err := backend.rclone(nil, "mkdir", backend.target(p))
+	return err
+}
+
+// Stat returns the size of a file.
+func (backend *RcloneStorage) Stat(p string) (uint64, error) {
+	out, err := backend.rclone(nil, "lsjson", "--files-only", "--no-modtime", "--no-mimetype", backend.target(p))
+	if err != nil {
+		return 0, err
+	}
+
+	var items []struct {
+		Name string `json:"Name"`
+		Size int64  `json:"Size"`
+	}
+	if err := json.Unmarshal(out, &items); err != nil {
+		return 0, err
+	}
+	for _, item := range items {
+		if item.Name == path.Base(p) {
+			return uint64(item.Size), nil
+		}
+	}
+
+	return 0, knoxite.NotFound(ErrFileNotFound)
+}
+
+// ReadFile reads a file from the remote.
+func (backend *RcloneStorage) ReadFile(p string) (io.ReadCloser, error) {
+	cmd := exec.Command(backend.bin, "cat", backend.target(p))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &catReader{ReadCloser: stdout, cmd: cmd}
+	cmd.Stderr = &r.stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// WriteFile writes a file to the remote.
+func (backend *RcloneStorage) WriteFile(p string, data io.Reader, size uint64) (uint64, error) {
+	_, err := backend.rclone(data, "rcat", backend.target(p))
+	return size, err
+}
+
+// DeleteFile deletes a file from the remote.
+func (backend *RcloneStorage) DeleteFile(p string) error {
+	_, err := backend.rclone(nil, "deletefile", backend.target(p))
+	return err
+}
+
+// DeletePath deletes a directory including all its content from the remote.
+func (backend *RcloneStorage) DeletePath(p string) error {
+	_, err := backend.rclone(nil, "purge", backend.target(p))
+	return err
+}
+
+// target returns the rclone path for p on the configured remote.
+func (backend *RcloneStorage) target(p string) string {
+	if !backend.absolute {
+		p = strings.TrimPrefix(p, "/")
+	}
+	return backend.remote + ":" + p
+}
+
+// rclone runs the rclone executable and returns its output.
+func (backend *RcloneStorage) rclone(stdin io.Reader, args ...string) ([]byte, error) {
+	cmd := exec.Command(backend.bin, args...)
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, runError(args[0], err, stderr.String())
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// catReader streams the output of a running "rclone cat".
+type catReader struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+}
+
+// Close waits for rclone to exit and returns its error, if any.
+func (r *catReader) Close() error {
+	// drain the pipe, so rclone doesn't get killed by SIGPIPE
+	_, _ = io.Copy(ioutil.Discard, r.ReadCloser)
+
+	if err := r.cmd.Wait(); err != nil {
+		return runError("cat", err, r.stderr.String())
+	}
+	return nil
+}
+
+// runError converts the error of an rclone invocation.
+func runError(command string, err error, stderr string) error {
+	if exit, ok := err.(*exec.ExitError); ok {
+		switch exit.ExitCode() {
+		case 3, 4:
+			// a missing directory or file
+			return knoxite.NotFound(ErrFileNotFound)
+		case 1, 7, 8:
+			// usage errors, fatal errors and exceeded transfer limits won't
+			// go away by retrying
+			return knoxite.Permanent(fmt.Errorf("rclone %s failed: %s", command, strings.TrimSpace(stderr)))
+		}
+		return fmt.Errorf("rclone %s failed: %s", command, strings.TrimSpace(stderr))
+	}
+	return err
+}
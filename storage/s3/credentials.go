@@ -0,0 +1,43 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package s3
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/minio/minio-go/pkg/credentials"
+
+	"github.com/knoxite/knoxite"
+)
+
+// iamTimeout limits how long we wait for the instance metadata service, which
+// isn't reachable outside of EC2 and ECS.
+const iamTimeout = 5 * time.Second
+
+// NewCredentials returns the credentials given in a URL's userinfo. Without
+// userinfo it falls back to the standard AWS credential chain: the
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables, the
+// shared credentials file (~/.aws/credentials, honoring AWS_PROFILE) and the
+// IAM role of an EC2 instance or ECS task.
+func NewCredentials(user *url.Userinfo) (*credentials.Credentials, error) {
+	if user != nil && user.Username() != "" {
+		pw, _ := user.Password()
+		if pw == "" {
+			return nil, knoxite.ErrInvalidPassword
+		}
+		return credentials.NewStaticV4(user.Username(), pw, ""), nil
+	}
+
+	return credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.FileAWSCredentials{},
+		&credentials.IAM{Client: &http.Client{Timeout: iamTimeout}},
+	}), nil
+}
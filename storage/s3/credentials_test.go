@@ -0,0 +1,47 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package s3
+
+import (
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/knoxite/knoxite"
+)
+
+func TestNewCredentials(t *testing.T) {
+	creds, err := NewCredentials(url.UserPassword("id", "secret"))
+	if err != nil {
+		t.Fatalf("Failed creating credentials: %s", err)
+	}
+	v, err := creds.Get()
+	if err != nil || v.AccessKeyID != "id" || v.SecretAccessKey != "secret" {
+		t.Errorf("Expected credentials from the URL, got %v: %v", v, err)
+	}
+
+	if _, err := NewCredentials(url.User("id")); err != knoxite.ErrInvalidPassword {
+		t.Errorf("Expected %v, got %v", knoxite.ErrInvalidPassword, err)
+	}
+
+	for k, v := range map[string]string{
+		"AWS_ACCESS_KEY_ID":     "envid",
+		"AWS_SECRET_ACCESS_KEY": "envsecret",
+	} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+	creds, err = NewCredentials(nil)
+	if err != nil {
+		t.Fatalf("Failed creating credentials: %s", err)
+	}
+	v, err = creds.Get()
+	if err != nil || v.AccessKeyID != "envid" || v.SecretAccessKey != "envsecret" {
+		t.Errorf("Expected credentials from the environment, got %v: %v", v, err)
+	}
+}
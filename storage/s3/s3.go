@@ -10,10 +10,11 @@ package s3
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"io"
 	"io/ioutil"
 	"net/url"
-	"os"
 	"strconv"
 	"strings"
 
@@ -48,22 +49,9 @@ func (*S3Storage) NewBackend(URL url.URL) (knoxite.Backend, error) {
 		return &S3Storage{}, errors.New("Invalid s3 url scheme")
 	}
 
-	var username, pw string
-	if URL.User != nil {
-		username = URL.User.Username()
-		pw, _ = URL.User.Password()
-	}
-	if len(username) == 0 {
-		username = os.Getenv("AWS_ACCESS_KEY_ID")
-		if len(username) == 0 {
-			return &S3Storage{}, knoxite.ErrInvalidUsername
-		}
-	}
-	if len(pw) == 0 {
-		pw = os.Getenv("AWS_SECRET_ACCESS_KEY")
-		if len(pw) == 0 {
-			return &S3Storage{}, knoxite.ErrInvalidPassword
-		}
+	creds, err := NewCredentials(URL.User)
+	if err != nil {
+		return &S3Storage{}, err
 	}
 
 	regionAndBucketPrefix := strings.Split(URL.Path, "/")
@@ -71,7 +59,7 @@ func (*S3Storage) NewBackend(URL url.URL) (knoxite.Backend, error) {
 		return &S3Storage{}, knoxite.ErrInvalidRepositoryURL
 	}
 
-	cl, err := minio.New(URL.Host, username, pw, ssl)
+	cl, err := minio.NewWithCredentials(URL.Host, creds, ssl, "")
 	if err != nil {
 		return &S3Storage{}, err
 	}
@@ -110,39 +98,63 @@ func (backend *S3Storage) AvailableSpace() (uint64, error) {
 	return uint64(0), knoxite.ErrAvailableSpaceUnlimited
 }
 
+// classifyError marks errors of requests, which won't succeed when retried, as
+// permanent.
+func classifyError(err error) error {
+	if err == nil || err == io.EOF {
+		return err
+	}
+	if code := minio.ToErrorResponse(err).StatusCode; code != 0 {
+		return knoxite.StatusError(code, err)
+	}
+	return err
+}
+
+// objectReader classifies the errors of an object, which only get reported
+// once it's being read.
+type objectReader struct {
+	*minio.Object
+}
+
+func (r objectReader) Read(p []byte) (int, error) {
+	n, err := r.Object.Read(p)
+	return n, classifyError(err)
+}
+
 // LoadChunk loads a Chunk from network.
-func (backend *S3Storage) LoadChunk(shasum string, part, totalParts uint) ([]byte, error) {
+func (backend *S3Storage) LoadChunk(ctx context.Context, shasum string, part, totalParts uint) (io.ReadCloser, error) {
 	fileName := shasum + "." + strconv.FormatUint(uint64(part), 10) + "_" + strconv.FormatUint(uint64(totalParts), 10)
-	obj, err := backend.client.GetObject(backend.chunkBucket, fileName, minio.GetObjectOptions{})
+	obj, err := backend.client.GetObjectWithContext(ctx, backend.chunkBucket, fileName, minio.GetObjectOptions{})
 	if err != nil {
-		return nil, err
+		return nil, classifyError(err)
 	}
-	defer obj.Close()
-
-	return ioutil.ReadAll(obj)
+	return objectReader{obj}, nil
 }
 
 // StoreChunk stores a single Chunk on network.
-func (backend *S3Storage) StoreChunk(shasum string, part, totalParts uint, data []byte) (size uint64, err error) {
+func (backend *S3Storage) StoreChunk(ctx context.Context, shasum string, part, totalParts uint, data io.Reader, size uint64) (uint64, error) {
 	fileName := shasum + "." + strconv.FormatUint(uint64(part), 10) + "_" + strconv.FormatUint(uint64(totalParts), 10)
 
-	if _, err = backend.client.StatObject(backend.chunkBucket, fileName, minio.StatObjectOptions{}); err == nil {
+	if _, err := backend.client.StatObject(backend.chunkBucket, fileName, minio.StatObjectOptions{}); err == nil {
 		// Chunk is already stored
 		return 0, nil
 	}
 
-	buf := bytes.NewBuffer(data)
-	i, err := backend.client.PutObject(backend.chunkBucket, fileName, buf, int64(buf.Len()), minio.PutObjectOptions{ContentType: "application/octet-stream"})
-	return uint64(i), err
+	i, err := backend.client.PutObjectWithContext(ctx, backend.chunkBucket, fileName, data, int64(size), minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	return uint64(i), classifyError(err)
 }
 
 // DeleteChunk deletes a single Chunk.
-func (backend *S3Storage) DeleteChunk(shasum string, part, totalParts uint) error {
+func (backend *S3Storage) DeleteChunk(ctx context.Context, shasum string, part, totalParts uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	fileName := shasum + "." + strconv.FormatUint(uint64(part), 10) + "_" + strconv.FormatUint(uint64(totalParts), 10)
 
 	err := backend.client.RemoveObject(backend.chunkBucket, fileName)
 	if err != nil {
-		return err
+		return classifyError(err)
 	}
 
 	return nil
@@ -152,18 +164,23 @@ func (backend *S3Storage) DeleteChunk(shasum string, part, totalParts uint) erro
 func (backend *S3Storage) LoadSnapshot(id string) ([]byte, error) {
 	obj, err := backend.client.GetObject(backend.snapshotBucket, id, minio.GetObjectOptions{})
 	if err != nil {
-		return nil, err
+		return nil, classifyError(err)
 	}
 	defer obj.Close()
 
-	return ioutil.ReadAll(obj)
+	return ioutil.ReadAll(objectReader{obj})
+}
+
+// LoadSnapshotRange returns a reader for a snapshot starting at offset.
+func (backend *S3Storage) LoadSnapshotRange(ctx context.Context, id string, offset int64) (io.ReadCloser, int64, error) {
+	return backend.loadRange(ctx, backend.snapshotBucket, id, offset)
 }
 
 // SaveSnapshot stores a snapshot.
 func (backend *S3Storage) SaveSnapshot(id string, data []byte) error {
 	buf := bytes.NewBuffer(data)
 	_, err := backend.client.PutObject(backend.snapshotBucket, id, buf, int64(buf.Len()), minio.PutObjectOptions{ContentType: "application/octet-stream"})
-	return err
+	return classifyError(err)
 }
 
 // LoadChunkIndex reads the chunk-index.
@@ -177,6 +194,49 @@ func (backend *S3Storage) LoadChunkIndex() ([]byte, error) {
 	return ioutil.ReadAll(obj)
 }
 
+// LoadChunkIndexRange returns a reader for the chunk-index starting at offset.
+func (backend *S3Storage) LoadChunkIndexRange(ctx context.Context, offset int64) (io.ReadCloser, int64, error) {
+	return backend.loadRange(ctx, backend.chunkBucket, knoxite.ChunkIndexFilename, offset)
+}
+
+// ChunkIndexVersion returns the ETag of the chunk-index.
+func (backend *S3Storage) ChunkIndexVersion() (string, error) {
+	return backend.etag(backend.chunkBucket, knoxite.ChunkIndexFilename)
+}
+
+// SnapshotVersion returns the ETag of a snapshot.
+func (backend *S3Storage) SnapshotVersion(id string) (string, error) {
+	return backend.etag(backend.snapshotBucket, id)
+}
+
+func (backend *S3Storage) etag(bucket, name string) (string, error) {
+	info, err := backend.client.StatObject(bucket, name, minio.StatObjectOptions{})
+	if err != nil {
+		return "", classifyError(err)
+	}
+	return info.ETag, nil
+}
+
+// loadRange returns a reader for an object starting at offset, and the
+// object's total size.
+func (backend *S3Storage) loadRange(ctx context.Context, bucket, name string, offset int64) (io.ReadCloser, int64, error) {
+	info, err := backend.client.StatObject(bucket, name, minio.StatObjectOptions{})
+	if err != nil {
+		return nil, 0, classifyError(err)
+	}
+	opts := minio.GetObjectOptions{}
+	if offset > 0 {
+		if err := opts.SetRange(offset, 0); err != nil {
+			return nil, 0, err
+		}
+	}
+	obj, err := backend.client.GetObjectWithContext(ctx, bucket, name, opts)
+	if err != nil {
+		return nil, 0, classifyError(err)
+	}
+	return objectReader{obj}, info.Size, nil
+}
+
 // SaveChunkIndex stores the chunk-index.
 func (backend *S3Storage) SaveChunkIndex(data []byte) error {
 	buf := bytes.NewBuffer(data)
@@ -245,3 +305,21 @@ func (backend *S3Storage) SaveRepository(data []byte) error {
 	_, err := backend.client.PutObject(backend.repositoryBucket, knoxite.RepoFilename, buf, int64(buf.Len()), minio.PutObjectOptions{ContentType: "application/octet-stream"})
 	return err
 }
+
+// LoadPasswordHint reads the password hint.
+func (backend *S3Storage) LoadPasswordHint() ([]byte, error) {
+	obj, err := backend.client.GetObject(backend.repositoryBucket, knoxite.PasswordHintFilename, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer obj.Close()
+
+	return ioutil.ReadAll(obj)
+}
+
+// SavePasswordHint stores the password hint.
+func (backend *S3Storage) SavePasswordHint(data []byte) error {
+	buf := bytes.NewBuffer(data)
+	_, err := backend.client.PutObject(backend.repositoryBucket, knoxite.PasswordHintFilename, buf, int64(buf.Len()), minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	return err
+}
@@ -9,7 +9,8 @@
 package sftp
 
 import (
-	"io/ioutil"
+	"errors"
+	"io"
 	"net"
 	"net/url"
 	"os"
@@ -123,11 +124,11 @@ func (backend *SFTPStorage) Location() string {
 }
 
 func (backend *SFTPStorage) CreatePath(path string) error {
-	return backend.sftp.MkdirAll(path)
+	return classifyError(backend.sftp.MkdirAll(path))
 }
 
 func (backend *SFTPStorage) DeleteFile(path string) error {
-	return backend.sftp.Remove(path)
+	return classifyError(backend.sftp.Remove(path))
 }
 
 func (backend *SFTPStorage) DeletePath(path string) error {
@@ -155,31 +156,58 @@ func (backend *SFTPStorage) DeletePath(path string) error {
 	return nil
 }
 
-func (backend *SFTPStorage) ReadFile(path string) ([]byte, error) {
-	file, err := backend.sftp.Open(path)
+func (backend *SFTPStorage) ReadFile(path string) (io.ReadCloser, error) {
+	f, err := backend.sftp.Open(path)
 	if err != nil {
-		return nil, err
+		return nil, classifyError(err)
 	}
-	defer file.Close()
-
-	return ioutil.ReadAll(file)
+	return f, nil
 }
 
-func (backend *SFTPStorage) WriteFile(path string, data []byte) (size uint64, err error) {
+func (backend *SFTPStorage) WriteFile(path string, data io.Reader, size uint64) (uint64, error) {
 	file, err := backend.sftp.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
 	if err != nil {
-		return 0, err
+		return 0, classifyError(err)
 	}
 	defer file.Close()
 
-	length, err := file.Write(data)
-	return uint64(length), err
+	length, err := io.Copy(file, data)
+	return uint64(length), classifyError(err)
+}
+
+func (backend *SFTPStorage) ReadDir(path string) ([]string, error) {
+	fis, err := backend.sftp.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	return names, nil
 }
 
 func (backend *SFTPStorage) Stat(path string) (uint64, error) {
 	stat, err := backend.sftp.Stat(path)
 	if err != nil {
-		return 0, err
+		return 0, classifyError(err)
 	}
 	return uint64(stat.Size()), err
 }
+
+// classifyError marks errors, which won't go away when the request gets
+// retried, as permanent. Missing files and denied permissions already get
+// reported as os.ErrNotExist and os.ErrPermission.
+func classifyError(err error) error {
+	var serr *sftp.StatusError
+	if errors.As(err, &serr) {
+		switch serr.FxCode() {
+		case sftp.ErrSSHFxNoSuchFile:
+			return knoxite.NotFound(err)
+		case sftp.ErrSSHFxPermissionDenied, sftp.ErrSSHFxOpUnsupported, sftp.ErrSSHFxBadMessage:
+			return knoxite.Permanent(err)
+		}
+	}
+	return err
+}
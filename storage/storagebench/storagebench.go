@@ -0,0 +1,244 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+// Package storagebench runs standardized workloads against storage backends,
+// so their performance can be compared.
+package storagebench
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/knoxite/knoxite"
+)
+
+// Operations measured for every workload.
+const (
+	OpStore  = "store"
+	OpLoad   = "load"
+	OpDelete = "delete"
+)
+
+// A Workload describes the chunks getting stored, loaded and deleted.
+type Workload struct {
+	Name string
+	// Sizes are the sizes of the chunks, they get used in turn
+	Sizes []int
+	// Chunks is the amount of chunks
+	Chunks int
+	// Parallelism is the amount of concurrent operations
+	Parallelism int
+}
+
+// A Result holds the measurements of a single operation of a workload.
+type Result struct {
+	Backend      string        `json:"backend"`
+	Workload     string        `json:"workload"`
+	Operation    string        `json:"operation"`
+	Parallelism  int           `json:"parallelism"`
+	Chunks       int           `json:"chunks"`
+	Bytes        uint64        `json:"bytes"`
+	Errors       int           `json:"errors"`
+	Duration     time.Duration `json:"duration_ns"`
+	Throughput   float64       `json:"throughput"` // bytes per second
+	OpsPerSecond float64       `json:"ops_per_second"`
+	LatencyP50   time.Duration `json:"latency_p50_ns"`
+	LatencyP99   time.Duration `json:"latency_p99_ns"`
+}
+
+const (
+	kib = 1 << 10
+	mib = 1 << 20
+)
+
+// Workloads returns the standard workloads. scale multiplies the amount of
+// chunks of each workload, e.g. 0.1 for a quick run against slow backends.
+func Workloads(scale float64) []Workload {
+	workloads := []Workload{
+		{Name: "small", Sizes: []int{4 * kib}, Chunks: 1000, Parallelism: 8},
+		{Name: "huge", Sizes: []int{64 * mib}, Chunks: 4, Parallelism: 1},
+		{Name: "mixed", Sizes: []int{4 * kib, 64 * kib, 1 * mib, 8 * mib}, Chunks: 200, Parallelism: 4},
+	}
+	for _, p := range []int{1, 2, 4, 8, 16} {
+		workloads = append(workloads, Workload{
+			Name:        "parallel-" + strconv.Itoa(p),
+			Sizes:       []int{1 * mib},
+			Chunks:      64,
+			Parallelism: p,
+		})
+	}
+
+	for i := range workloads {
+		workloads[i].Chunks = int(float64(workloads[i].Chunks) * scale)
+		if workloads[i].Chunks < 1 {
+			workloads[i].Chunks = 1
+		}
+	}
+	return workloads
+}
+
+// FindWorkloads returns the workloads with the given names.
+func FindWorkloads(workloads []Workload, names []string) ([]Workload, error) {
+	var found []Workload
+	for _, name := range names {
+		ok := false
+		for _, w := range workloads {
+			if w.Name == name {
+				found = append(found, w)
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown workload %s", name)
+		}
+	}
+	return found, nil
+}
+
+type chunk struct {
+	hash string
+	size int
+}
+
+// Run stores, loads and finally deletes the chunks of a workload. The
+// chunks consist of random data, so they don't collide with any data already
+// stored in the backend.
+func Run(ctx context.Context, backend knoxite.Backend, w Workload) ([]Result, error) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	chunks := make([]chunk, w.Chunks)
+	seeds := make([]int64, w.Chunks)
+	for i := range chunks {
+		chunks[i].size = w.Sizes[i%len(w.Sizes)]
+		seeds[i] = rnd.Int63()
+	}
+
+	store := run(ctx, w, func(i int) (uint64, error) {
+		data := make([]byte, chunks[i].size)
+		rand.New(rand.NewSource(seeds[i])).Read(data)
+		chunks[i].hash = knoxite.Hash(data, knoxite.HashHighway256)
+
+		_, err := backend.StoreChunk(ctx, chunks[i].hash, 0, 1, bytes.NewReader(data), uint64(len(data)))
+		return uint64(len(data)), err
+	})
+	load := run(ctx, w, func(i int) (uint64, error) {
+		r, err := backend.LoadChunk(ctx, chunks[i].hash, 0, 1)
+		if err != nil {
+			return 0, err
+		}
+		n, err := io.Copy(ioutil.Discard, r)
+		if cerr := r.Close(); err == nil {
+			err = cerr
+		}
+		return uint64(n), err
+	})
+	del := run(ctx, w, func(i int) (uint64, error) {
+		return 0, backend.DeleteChunk(ctx, chunks[i].hash, 0, 1)
+	})
+
+	results := []Result{store, load, del}
+	for i, op := range []string{OpStore, OpLoad, OpDelete} {
+		results[i].Backend = backend.Location()
+		results[i].Operation = op
+	}
+	return results, ctx.Err()
+}
+
+// run executes op for every chunk of a workload and measures it.
+func run(ctx context.Context, w Workload, op func(i int) (uint64, error)) Result {
+	res := Result{
+		Workload:    w.Name,
+		Parallelism: w.Parallelism,
+		Chunks:      w.Chunks,
+	}
+	latencies := make([]time.Duration, 0, w.Chunks)
+
+	var mut sync.Mutex
+	var wg sync.WaitGroup
+	jobs := make(chan int)
+	start := time.Now()
+	for p := 0; p < w.Parallelism; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				t := time.Now()
+				n, err := op(i)
+				d := time.Since(t)
+
+				mut.Lock()
+				res.Bytes += n
+				if err != nil {
+					res.Errors++
+				}
+				latencies = append(latencies, d)
+				mut.Unlock()
+			}
+		}()
+	}
+	for i := 0; i < w.Chunks && ctx.Err() == nil; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	res.Duration = time.Since(start)
+
+	if s := res.Duration.Seconds(); s > 0 {
+		res.Throughput = float64(res.Bytes) / s
+		res.OpsPerSecond = float64(len(latencies)) / s
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	res.LatencyP50 = percentile(latencies, 50)
+	res.LatencyP99 = percentile(latencies, 99)
+	return res
+}
+
+// percentile returns the p-th percentile of the sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[(len(sorted)-1)*p/100]
+}
+
+// WriteCSV writes results as CSV, including a header.
+func WriteCSV(w io.Writer, results []Result) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"backend", "workload", "operation", "parallelism", "chunks", "bytes", "errors",
+		"duration_ms", "throughput_bps", "ops_per_second", "latency_p50_ms", "latency_p99_ms"})
+	for _, r := range results {
+		_ = cw.Write([]string{
+			r.Backend,
+			r.Workload,
+			r.Operation,
+			strconv.Itoa(r.Parallelism),
+			strconv.Itoa(r.Chunks),
+			strconv.FormatUint(r.Bytes, 10),
+			strconv.Itoa(r.Errors),
+			milliseconds(r.Duration),
+			strconv.FormatFloat(r.Throughput, 'f', 0, 64),
+			strconv.FormatFloat(r.OpsPerSecond, 'f', 2, 64),
+			milliseconds(r.LatencyP50),
+			milliseconds(r.LatencyP99),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func milliseconds(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
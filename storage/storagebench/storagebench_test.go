@@ -0,0 +1,88 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package storagebench
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/knoxite/knoxite"
+)
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend, err := knoxite.BackendFromURL(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.InitRepository(); err != nil {
+		t.Fatal(err)
+	}
+
+	workloads, err := FindWorkloads(Workloads(0.1), []string{"mixed", "parallel-4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FindWorkloads(workloads, []string{"unknown"}); err == nil {
+		t.Errorf("Expected unknown workload to fail")
+	}
+
+	var results []Result
+	for _, w := range workloads {
+		res, err := Run(context.Background(), backend, w)
+		if err != nil {
+			t.Fatalf("Failed running workload %s: %s", w.Name, err)
+		}
+		results = append(results, res...)
+	}
+	if len(results) != 6 {
+		t.Fatalf("Expected 6 results, got %d", len(results))
+	}
+
+	for _, r := range results {
+		if r.Errors > 0 {
+			t.Errorf("%s %s: %d errors", r.Workload, r.Operation, r.Errors)
+		}
+		if r.Operation != OpDelete && r.Bytes == 0 {
+			t.Errorf("%s %s: expected data to be transferred", r.Workload, r.Operation)
+		}
+	}
+	if results[0].Bytes != results[1].Bytes {
+		t.Errorf("Expected to load as much data as stored, got %d and %d", results[1].Bytes, results[0].Bytes)
+	}
+
+	// all chunks got deleted again
+	chunks, err := backend.(knoxite.BackendLister).ListChunks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chunks) != 0 {
+		t.Errorf("Expected all chunks to be deleted, found %d", len(chunks))
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(results)+1 {
+		t.Errorf("Expected a header and %d rows, got %d records", len(results), len(records))
+	}
+}
@@ -10,7 +10,11 @@ package webdav
 
 import (
 	"errors"
+	"io"
 	"net/url"
+	"os"
+	"strconv"
+	"strings"
 
 	"github.com/studio-b12/gowebdav"
 
@@ -92,35 +96,59 @@ func (backend *WebDAVStorage) AvailableSpace() (uint64, error) {
 
 // CreatePath creates a path on the remote.
 func (backend *WebDAVStorage) CreatePath(path string) error {
-	return backend.Client.MkdirAll(path, 0755)
+	return classifyError(backend.Client.MkdirAll(path, 0755))
 }
 
 // DeleteFile deletes a remote file.
 func (backend *WebDAVStorage) DeleteFile(path string) error {
-	return backend.Client.Remove(path)
+	return classifyError(backend.Client.Remove(path))
 }
 
 // DeletePath deletes a directory and its contents.
 func (backend *WebDAVStorage) DeletePath(path string) error {
-	return backend.Client.Remove(path)
+	return classifyError(backend.Client.Remove(path))
 }
 
-// ReadFile reads the file.
-func (backend *WebDAVStorage) ReadFile(path string) ([]byte, error) {
-	return backend.Client.Read(path)
+// ReadFile returns a reader for the file.
+func (backend *WebDAVStorage) ReadFile(path string) (io.ReadCloser, error) {
+	rc, err := backend.Client.ReadStream(path)
+	if err != nil {
+		return nil, classifyError(err)
+	}
+	return rc, nil
 }
 
 // WriteFile writes a file.
-func (backend *WebDAVStorage) WriteFile(path string, data []byte) (size uint64, err error) {
-	err = backend.Client.Write(path, data, 0644)
-	return uint64(len(data)), err
+func (backend *WebDAVStorage) WriteFile(path string, data io.Reader, size uint64) (uint64, error) {
+	err := backend.Client.WriteStream(path, data, 0644)
+	return size, classifyError(err)
 }
 
 // Stat returns the file size by using the backends Stat function.
 func (backend *WebDAVStorage) Stat(path string) (uint64, error) {
 	stat, err := backend.Client.Stat(path)
 	if err != nil {
-		return 0, err
+		return 0, classifyError(err)
 	}
 	return uint64(stat.Size()), nil
 }
+
+// classifyError marks errors of requests, which won't succeed when retried, as
+// permanent. gowebdav only reports the HTTP status code as the leading part of
+// the error message.
+func classifyError(err error) error {
+	perr, ok := err.(*os.PathError)
+	if !ok {
+		return err
+	}
+
+	fields := strings.Fields(perr.Err.Error())
+	if len(fields) == 0 {
+		return err
+	}
+	code, cerr := strconv.Atoi(fields[0])
+	if cerr != nil {
+		return err
+	}
+	return knoxite.StatusError(code, err)
+}
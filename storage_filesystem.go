@@ -8,8 +8,13 @@
 package knoxite
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
 	"path/filepath"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -17,8 +22,14 @@ const (
 	RepoFilename = "repository.knoxite"
 	// ChunkIndexFilename is the default filename for the chunk-index.
 	ChunkIndexFilename = "index"
-	chunksDirname      = "chunks"
-	snapshotsDirname   = "snapshots"
+	// ChunkIndexPacksDirname is the default dirname for the packs of the
+	// chunk-index.
+	ChunkIndexPacksDirname = "index"
+	// PasswordHintFilename is the default filename for the password hint.
+	PasswordHintFilename = "hint"
+	chunksDirname        = "chunks"
+	snapshotsDirname     = "snapshots"
+	locksDirname         = "locks"
 )
 
 // BackendFilesystem is used to store and access data on a filesytem based backend.
@@ -27,21 +38,42 @@ type BackendFilesystem interface {
 	Stat(path string) (uint64, error)
 	// CreatePath creates a dir including all its parents dirs, when required
 	CreatePath(path string) error
-	// ReadFile reads a file from disk
-	ReadFile(path string) ([]byte, error)
-	// WriteFile writes a file to disk
-	WriteFile(path string, data []byte) (uint64, error)
+	// ReadFile returns a reader for a file on disk. The caller must close it
+	ReadFile(path string) (io.ReadCloser, error)
+	// WriteFile writes size bytes read from data to a file on disk
+	WriteFile(path string, data io.Reader, size uint64) (uint64, error)
 	// DeleteFile deletes a file from disk
 	DeleteFile(path string) error
 }
 
+// BackendFilesystemLister is implemented by filesystem based backends, which
+// can list the contents of a directory.
+type BackendFilesystemLister interface {
+	// ReadDir returns the names of all entries in a directory
+	ReadDir(path string) ([]string, error)
+}
+
+// BackendFilesystemVersioner is implemented by filesystem based backends,
+// which can tell the version of a file without reading it, e.g. its ETag.
+type BackendFilesystemVersioner interface {
+	// Version returns the version of a file, which changes whenever the file
+	// gets written
+	Version(path string) (string, error)
+}
+
+// ErrInvalidChunkPartName is returned when parsing a malformed chunk filename.
+var ErrInvalidChunkPartName = errors.New("Invalid chunk filename")
+
 // StorageFilesystem is bridging a BackendFilesystem to a Backend interface.
 type StorageFilesystem struct {
 	Path           string
 	chunkPath      string
 	snapshotPath   string
 	chunkIndexPath string
+	indexPacksPath string
 	repositoryPath string
+	hintPath       string
+	lockPath       string
 
 	storage *BackendFilesystem
 }
@@ -53,27 +85,64 @@ func NewStorageFilesystem(path string, storage BackendFilesystem) (StorageFilesy
 		chunkPath:      filepath.Join(path, chunksDirname),
 		snapshotPath:   filepath.Join(path, snapshotsDirname),
 		chunkIndexPath: filepath.Join(path, chunksDirname, ChunkIndexFilename),
+		indexPacksPath: filepath.Join(path, ChunkIndexPacksDirname),
 		repositoryPath: filepath.Join(path, RepoFilename),
+		hintPath:       filepath.Join(path, PasswordHintFilename),
+		lockPath:       filepath.Join(path, locksDirname),
 		storage:        &storage,
 	}
 	return s, nil
 }
 
+// ChunkIndexFileName returns the path the chunk-index gets stored at.
+func (backend StorageFilesystem) ChunkIndexFileName() string {
+	return backend.chunkIndexPath
+}
+
+// PasswordHintFileName returns the path the password hint gets stored at.
+func (backend StorageFilesystem) PasswordHintFileName() string {
+	return backend.hintPath
+}
+
+// ChunkFileName returns the path a part of a chunk gets stored at.
+func (backend StorageFilesystem) ChunkFileName(shasum string, part, totalParts uint) string {
+	return filepath.Join(backend.chunkPath, SubDirForChunk(shasum),
+		shasum+"."+strconv.FormatUint(uint64(part), 10)+"_"+strconv.FormatUint(uint64(totalParts), 10))
+}
+
 // LoadChunk loads a Chunk from disk.
-func (backend StorageFilesystem) LoadChunk(shasum string, part, totalParts uint) ([]byte, error) {
-	path := filepath.Join(backend.chunkPath, SubDirForChunk(shasum))
-	fileName := filepath.Join(path, shasum+"."+strconv.FormatUint(uint64(part), 10)+"_"+strconv.FormatUint(uint64(totalParts), 10))
+func (backend StorageFilesystem) LoadChunk(ctx context.Context, shasum string, part, totalParts uint) (io.ReadCloser, error) {
+	fileName := backend.ChunkFileName(shasum, part, totalParts)
 
-	return (*backend.storage).ReadFile(fileName)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	r, err := (*backend.storage).ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return ContextReadCloser(ctx, r), nil
+}
+
+// ChunkSize returns the size of a stored part of a chunk.
+func (backend StorageFilesystem) ChunkSize(ctx context.Context, shasum string, part, totalParts uint) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return (*backend.storage).Stat(backend.ChunkFileName(shasum, part, totalParts))
 }
 
 // StoreChunk stores a single Chunk on disk.
-func (backend StorageFilesystem) StoreChunk(shasum string, part, totalParts uint, data []byte) (size uint64, err error) {
+func (backend StorageFilesystem) StoreChunk(ctx context.Context, shasum string, part, totalParts uint, data io.Reader, size uint64) (uint64, error) {
 	path := filepath.Join(backend.chunkPath, SubDirForChunk(shasum))
-	fileName := filepath.Join(path, shasum+"."+strconv.FormatUint(uint64(part), 10)+"_"+strconv.FormatUint(uint64(totalParts), 10))
+	fileName := backend.ChunkFileName(shasum, part, totalParts)
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
 
 	n, err := (*backend.storage).Stat(fileName)
-	if err == nil && n == uint64(len(data)) {
+	if err == nil && n == size {
 		return 0, nil
 	}
 
@@ -82,39 +151,82 @@ func (backend StorageFilesystem) StoreChunk(shasum string, part, totalParts uint
 		return 0, err
 	}
 
-	return (*backend.storage).WriteFile(fileName, data)
+	return (*backend.storage).WriteFile(fileName, ContextReader(ctx, data), size)
 }
 
 // DeleteChunk deletes a single Chunk.
-func (backend StorageFilesystem) DeleteChunk(shasum string, part, totalParts uint) error {
-	path := filepath.Join(backend.chunkPath, SubDirForChunk(shasum))
-	fileName := filepath.Join(path, shasum+"."+strconv.FormatUint(uint64(part), 10)+"_"+strconv.FormatUint(uint64(totalParts), 10))
+func (backend StorageFilesystem) DeleteChunk(ctx context.Context, shasum string, part, totalParts uint) error {
+	fileName := backend.ChunkFileName(shasum, part, totalParts)
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	return (*backend.storage).DeleteFile(fileName)
 }
 
 // LoadSnapshot loads a snapshot.
 func (backend StorageFilesystem) LoadSnapshot(id string) ([]byte, error) {
-	return (*backend.storage).ReadFile(filepath.Join(backend.snapshotPath, id))
+	return backend.readFile(filepath.Join(backend.snapshotPath, id))
 }
 
 // SaveSnapshot stores a snapshot.
 func (backend StorageFilesystem) SaveSnapshot(id string, b []byte) error {
-	_, err := (*backend.storage).WriteFile(filepath.Join(backend.snapshotPath, id), b)
+	_, err := (*backend.storage).WriteFile(filepath.Join(backend.snapshotPath, id), bytes.NewReader(b), uint64(len(b)))
 	return err
 }
 
 // LoadChunkIndex reads the chunk-index.
 func (backend StorageFilesystem) LoadChunkIndex() ([]byte, error) {
-	return (*backend.storage).ReadFile(backend.chunkIndexPath)
+	return backend.readFile(backend.chunkIndexPath)
+}
+
+// ChunkIndexVersion returns the version of the chunk-index.
+func (backend StorageFilesystem) ChunkIndexVersion() (string, error) {
+	return backend.version(backend.chunkIndexPath)
+}
+
+// SnapshotVersion returns the version of a snapshot.
+func (backend StorageFilesystem) SnapshotVersion(id string) (string, error) {
+	return backend.version(filepath.Join(backend.snapshotPath, id))
+}
+
+func (backend StorageFilesystem) version(path string) (string, error) {
+	versioner, ok := (*backend.storage).(BackendFilesystemVersioner)
+	if !ok {
+		return "", ErrVersioningUnsupported
+	}
+
+	return versioner.Version(path)
 }
 
 // SaveChunkIndex stores the chunk-index.
 func (backend StorageFilesystem) SaveChunkIndex(b []byte) error {
-	_, err := (*backend.storage).WriteFile(backend.chunkIndexPath, b)
+	_, err := (*backend.storage).WriteFile(backend.chunkIndexPath, bytes.NewReader(b), uint64(len(b)))
+	return err
+}
+
+// LoadChunkIndexPack reads a pack of the chunk-index.
+func (backend StorageFilesystem) LoadChunkIndexPack(id string) ([]byte, error) {
+	return backend.readFile(filepath.Join(backend.indexPacksPath, id))
+}
+
+// SaveChunkIndexPack stores a pack of the chunk-index.
+func (backend StorageFilesystem) SaveChunkIndexPack(id string, b []byte) error {
+	if _, err := (*backend.storage).Stat(backend.indexPacksPath); err != nil {
+		if err := (*backend.storage).CreatePath(backend.indexPacksPath); err != nil {
+			return err
+		}
+	}
+	_, err := (*backend.storage).WriteFile(filepath.Join(backend.indexPacksPath, id), bytes.NewReader(b), uint64(len(b)))
 	return err
 }
 
+// DeleteChunkIndexPack deletes a pack of the chunk-index.
+func (backend StorageFilesystem) DeleteChunkIndexPack(id string) error {
+	return (*backend.storage).DeleteFile(filepath.Join(backend.indexPacksPath, id))
+}
+
 // InitRepository creates a new repository.
 func (backend StorageFilesystem) InitRepository() error {
 	if _, err := (*backend.storage).Stat(backend.repositoryPath); err == nil {
@@ -142,15 +254,149 @@ func (backend StorageFilesystem) InitRepository() error {
 
 // LoadRepository reads the metadata for a repository.
 func (backend StorageFilesystem) LoadRepository() ([]byte, error) {
-	return (*backend.storage).ReadFile(backend.repositoryPath)
+	return backend.readFile(backend.repositoryPath)
 }
 
 // SaveRepository stores the metadata for a repository.
 func (backend StorageFilesystem) SaveRepository(b []byte) error {
-	_, err := (*backend.storage).WriteFile(backend.repositoryPath, b)
+	_, err := (*backend.storage).WriteFile(backend.repositoryPath, bytes.NewReader(b), uint64(len(b)))
+	return err
+}
+
+// LoadPasswordHint reads the password hint.
+func (backend StorageFilesystem) LoadPasswordHint() ([]byte, error) {
+	return backend.readFile(backend.hintPath)
+}
+
+// SavePasswordHint stores the password hint.
+func (backend StorageFilesystem) SavePasswordHint(b []byte) error {
+	_, err := (*backend.storage).WriteFile(backend.hintPath, bytes.NewReader(b), uint64(len(b)))
 	return err
 }
 
+// ListSnapshots returns the IDs of all stored snapshots.
+func (backend StorageFilesystem) ListSnapshots() ([]string, error) {
+	lister, ok := (*backend.storage).(BackendFilesystemLister)
+	if !ok {
+		return nil, ErrListingUnsupported
+	}
+
+	return lister.ReadDir(backend.snapshotPath)
+}
+
+// ListChunks returns all stored parts of chunks.
+func (backend StorageFilesystem) ListChunks() ([]ChunkPart, error) {
+	lister, ok := (*backend.storage).(BackendFilesystemLister)
+	if !ok {
+		return nil, ErrListingUnsupported
+	}
+
+	var parts []ChunkPart
+	dirs, err := lister.ReadDir(backend.chunkPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		// skips the chunk-index
+		if len(dir) != 2 {
+			continue
+		}
+		subdirs, err := lister.ReadDir(filepath.Join(backend.chunkPath, dir))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, subdir := range subdirs {
+			names, err := lister.ReadDir(filepath.Join(backend.chunkPath, dir, subdir))
+			if err != nil {
+				return nil, err
+			}
+
+			for _, name := range names {
+				part, err := ParseChunkPartName(name)
+				if err != nil {
+					// not a chunk
+					continue
+				}
+				parts = append(parts, part)
+			}
+		}
+	}
+
+	return parts, nil
+}
+
+// ListLocks returns the IDs of all locks.
+func (backend StorageFilesystem) ListLocks() ([]string, error) {
+	lister, ok := (*backend.storage).(BackendFilesystemLister)
+	if !ok {
+		return nil, ErrListingUnsupported
+	}
+
+	if _, err := (*backend.storage).Stat(backend.lockPath); err != nil {
+		// no lock has ever been taken
+		return nil, nil
+	}
+	return lister.ReadDir(backend.lockPath)
+}
+
+// LoadLock reads a lock.
+func (backend StorageFilesystem) LoadLock(id string) ([]byte, error) {
+	return backend.readFile(filepath.Join(backend.lockPath, id))
+}
+
+// SaveLock stores a lock.
+func (backend StorageFilesystem) SaveLock(id string, b []byte) error {
+	if _, err := (*backend.storage).Stat(backend.lockPath); err != nil {
+		if err := (*backend.storage).CreatePath(backend.lockPath); err != nil {
+			return err
+		}
+	}
+	_, err := (*backend.storage).WriteFile(filepath.Join(backend.lockPath, id), bytes.NewReader(b), uint64(len(b)))
+	return err
+}
+
+// DeleteLock deletes a lock.
+func (backend StorageFilesystem) DeleteLock(id string) error {
+	return (*backend.storage).DeleteFile(filepath.Join(backend.lockPath, id))
+}
+
+// readFile reads an entire file into memory.
+func (backend StorageFilesystem) readFile(path string) ([]byte, error) {
+	r, err := (*backend.storage).ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return readAll(r)
+}
+
+// ParseChunkPartName parses the filename a chunk part gets stored with.
+func ParseChunkPartName(name string) (ChunkPart, error) {
+	var part ChunkPart
+
+	i := strings.LastIndex(name, ".")
+	if i <= 0 {
+		return part, ErrInvalidChunkPartName
+	}
+	nums := strings.Split(name[i+1:], "_")
+	if len(nums) != 2 {
+		return part, ErrInvalidChunkPartName
+	}
+	p, err := strconv.ParseUint(nums[0], 10, 32)
+	if err != nil {
+		return part, ErrInvalidChunkPartName
+	}
+	total, err := strconv.ParseUint(nums[1], 10, 32)
+	if err != nil {
+		return part, ErrInvalidChunkPartName
+	}
+
+	part.Hash = name[:i]
+	part.Part = uint(p)
+	part.TotalParts = uint(total)
+	return part, nil
+}
+
 // SubDirForChunk files a chunk into a subdir, based on the chunks name.
 func SubDirForChunk(id string) string {
 	return filepath.Join(id[0:2], id[2:4])
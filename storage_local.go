@@ -8,6 +8,7 @@
 package knoxite
 
 import (
+	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
@@ -71,16 +72,37 @@ func (backend StorageLocal) Stat(path string) (uint64, error) {
 	return uint64(stat.Size()), err
 }
 
-// ReadFile reads a file from disk.
-func (backend StorageLocal) ReadFile(path string) ([]byte, error) {
-	b, err := ioutil.ReadFile(path)
-	return b, err
+// ReadFile opens a file on disk for reading.
+func (backend StorageLocal) ReadFile(path string) (io.ReadCloser, error) {
+	return os.Open(path)
 }
 
 // WriteFile writes a file to disk.
-func (backend StorageLocal) WriteFile(path string, data []byte) (size uint64, err error) {
-	err = ioutil.WriteFile(path, data, 0600)
-	return uint64(len(data)), err
+func (backend StorageLocal) WriteFile(path string, data io.Reader, size uint64) (uint64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(f, data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return uint64(n), err
+}
+
+// ReadDir returns the names of all entries in a directory on disk.
+func (backend StorageLocal) ReadDir(path string) ([]string, error) {
+	fis, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	return names, nil
 }
 
 // DeleteFile deletes a file from disk.
@@ -0,0 +1,83 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// SymLinkTarget describes where a restored symlink points to.
+type SymLinkTarget struct {
+	Path     string // Path of the symlink inside the snapshot
+	PointsTo string // Link target as stored in the archive
+	Resolved string // Link target resolved against the restore destination
+	Outside  bool   // Target lies outside of the restored tree
+	Dangling bool   // Target does not exist after the restore
+}
+
+// CheckSymLinks inspects all symlinks of snapshot that have been restored to
+// dst. It returns the links pointing outside of dst or to a non-existing
+// target, sorted by path.
+func CheckSymLinks(snapshot *Snapshot, dst string) []SymLinkTarget {
+	var links []SymLinkTarget
+
+	root, err := filepath.Abs(dst)
+	if err != nil {
+		root = dst
+	}
+
+	for _, arc := range snapshot.Archives {
+		if arc.Type != SymLink {
+			continue
+		}
+
+		// skip links which haven't been restored, e.g. because of excludes
+		path := filepath.Join(root, arc.Path)
+		if fi, err := os.Lstat(path); err != nil || !isSymLink(fi) {
+			continue
+		}
+
+		resolved := arc.PointsTo
+		if !filepath.IsAbs(resolved) {
+			resolved = filepath.Join(filepath.Dir(path), resolved)
+		}
+		resolved = filepath.Clean(resolved)
+
+		link := SymLinkTarget{
+			Path:     arc.Path,
+			PointsTo: arc.PointsTo,
+			Resolved: resolved,
+			Outside:  !isSubPath(root, resolved),
+		}
+		if _, err := os.Stat(path); err != nil {
+			link.Dangling = true
+		}
+
+		if link.Outside || link.Dangling {
+			links = append(links, link)
+		}
+	}
+
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].Path < links[j].Path
+	})
+	return links
+}
+
+// isSubPath returns true if path is located within root.
+func isSubPath(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
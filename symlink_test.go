@@ -0,0 +1,121 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestSymLinkRestore(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require special privileges on windows")
+	}
+
+	testPassword := "this_is_a_password"
+
+	src, err := ioutil.TempDir("", "knoxite.source")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	if err := ioutil.WriteFile(filepath.Join(src, "file"), []byte("content"), 0600); err != nil {
+		t.Fatalf("Failed creating file: %s", err)
+	}
+	links := map[string]string{
+		"inside":   "file",
+		"dangling": "does_not_exist",
+		"outside":  "../../outside_of_the_tree",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(src, name)); err != nil {
+			t.Fatalf("Failed creating symlink: %s", err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	snapshot, err := NewSnapshot("test_snapshot")
+	if err != nil {
+		t.Fatalf("Failed creating snapshot: %s", err)
+	}
+
+	opts := StoreOptions{
+		CWD:       src,
+		Paths:     []string{src},
+		Encrypt:   EncryptionAES,
+		DataParts: 1,
+	}
+	for p := range snapshot.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Errorf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+
+	if arc := snapshot.Archives["dangling"]; arc == nil || !arc.Dangling {
+		t.Errorf("Expected dangling symlink to be recorded as such")
+	}
+	if arc := snapshot.Archives["inside"]; arc == nil || arc.Dangling || !arc.TargetMode.IsRegular() {
+		t.Errorf("Expected symlink target to be recorded as regular file")
+	}
+
+	targetdir, err := ioutil.TempDir("", "knoxite.target")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for restore: %s", err)
+	}
+	defer os.RemoveAll(targetdir)
+
+	progress, err := DecodeSnapshot(context.Background(), r, snapshot, targetdir, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Failed restoring snapshot: %s", err)
+	}
+	for p := range progress {
+		if p.Error != nil {
+			t.Errorf("Failed restoring snapshot: %s", p.Error)
+		}
+	}
+
+	for name, target := range links {
+		pointsTo, err := os.Readlink(filepath.Join(targetdir, name))
+		if err != nil {
+			t.Errorf("Failed reading restored symlink %s: %s", name, err)
+			continue
+		}
+		if pointsTo != target {
+			t.Errorf("Restored symlink %s points to %s, expected %s", name, pointsTo, target)
+		}
+	}
+
+	report := CheckSymLinks(snapshot, targetdir)
+	if len(report) != 2 {
+		t.Fatalf("Expected 2 reported symlinks, got %d: %+v", len(report), report)
+	}
+	if report[0].Path != "dangling" || !report[0].Dangling || report[0].Outside {
+		t.Errorf("Unexpected report for dangling symlink: %+v", report[0])
+	}
+	if report[1].Path != "outside" || !report[1].Outside {
+		t.Errorf("Unexpected report for outside symlink: %+v", report[1])
+	}
+}
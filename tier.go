@@ -0,0 +1,203 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// TieringBackend is implemented by backends, which can move chunks between
+// storage tiers, like the storage classes of Amazon S3. Cheaper tiers usually
+// charge for retrieving data, archive tiers even need to be restored from
+// before their data can be read.
+type TieringBackend interface {
+	// Tiers returns the names of all supported tiers. The first one is the
+	// tier new chunks get stored in
+	Tiers() []string
+	// SetChunkTier moves a single chunk to a tier
+	SetChunkTier(ctx context.Context, shasum string, part, totalParts uint, tier string) error
+}
+
+// ArchivingBackend is implemented by tiering backends with archive tiers,
+// like S3 Glacier, whose chunks need to be retrieved before they can be read.
+type ArchivingBackend interface {
+	// ThawChunk requests a chunk to be retrieved from an archive tier, unless
+	// that already happened. It returns true once the chunk can be read
+	ThawChunk(ctx context.Context, shasum string, part, totalParts uint) (bool, error)
+}
+
+// Error declarations.
+var (
+	ErrTieringUnsupported = errors.New("None of the storage backends supports storage tiers")
+	ErrUnknownTier        = errors.New("Unknown storage tier")
+)
+
+// TierPolicy moves the chunks, which are only referenced by snapshots older
+// than MinAge, to Tier. Chunks in Tier, which are referenced by a more
+// recent snapshot again, e.g. because its files didn't change, get moved back
+// to the default tier.
+type TierPolicy struct {
+	MinAge time.Duration
+	Tier   string
+}
+
+// TierMove moves a chunk to another tier.
+type TierMove struct {
+	Chunk *ChunkIndexItem
+	Tier  string
+}
+
+// Tiers returns the tiers supported by the repository's backends. The first
+// one is the default tier. It returns nil if none of the backends supports
+// storage tiers.
+func (r *Repository) Tiers() []string {
+	for _, be := range r.backend.Backends {
+		if tb, ok := (*be).(TieringBackend); ok {
+			return tb.Tiers()
+		}
+	}
+	return nil
+}
+
+// PlanTiering returns the chunks, which need to be moved to another tier to
+// satisfy policy.
+func (index *ChunkIndex) PlanTiering(repository *Repository, policy TierPolicy, now time.Time) ([]TierMove, error) {
+	tiers := repository.Tiers()
+	if len(tiers) == 0 || len(index.indexing) > 0 {
+		return nil, ErrTieringUnsupported
+	}
+	known := false
+	for _, tier := range tiers {
+		known = known || tier == policy.Tier
+	}
+	if !known {
+		return nil, ErrUnknownTier
+	}
+
+	dates := make(map[string]time.Time)
+	for _, vol := range repository.Volumes {
+		for _, id := range vol.Snapshots {
+			snapshot, err := vol.LoadSnapshot(id, repository)
+			if err != nil {
+				return nil, err
+			}
+			dates[id] = snapshot.Date
+		}
+	}
+
+	cutoff := now.Add(-policy.MinAge)
+	var moves []TierMove
+	for _, chunk := range index.Chunks {
+		if len(chunk.Snapshots) == 0 {
+			// gets removed by packing the repository anyway
+			continue
+		}
+		old := true
+		for _, id := range chunk.Snapshots {
+			if date, ok := dates[id]; !ok || date.After(cutoff) {
+				old = false
+				break
+			}
+		}
+
+		switch {
+		case old && chunk.Tier != policy.Tier:
+			moves = append(moves, TierMove{Chunk: chunk, Tier: policy.Tier})
+		case !old && chunk.Tier == policy.Tier:
+			moves = append(moves, TierMove{Chunk: chunk, Tier: tiers[0]})
+		}
+	}
+	return moves, nil
+}
+
+// MoveChunks moves all parts of chunks to other tiers and records their new
+// tier in the index. Chunks moved before an error occurred keep their new
+// tier, so the index needs to be saved nonetheless.
+func (index *ChunkIndex) MoveChunks(ctx context.Context, repository *Repository, moves []TierMove) error {
+	tiers := repository.Tiers()
+	for _, move := range moves {
+		for part := uint(0); part < move.Chunk.DataParts+move.Chunk.ParityParts; part++ {
+			err := repository.backend.SetChunkTier(ctx, move.Chunk.Hash, part, move.Chunk.DataParts, move.Tier)
+			if err != nil {
+				return err
+			}
+		}
+
+		move.Chunk.Tier = move.Tier
+		if move.Tier == tiers[0] {
+			move.Chunk.Tier = ""
+		}
+		index.changed(move.Chunk)
+	}
+	return nil
+}
+
+// TieredChunks returns the chunks of archives, which aren't stored in the
+// default tier, sorted by their tier.
+func (index *ChunkIndex) TieredChunks(archives []*Archive) map[string][]*ChunkIndexItem {
+	tiered := make(map[string][]*ChunkIndexItem)
+	seen := make(map[string]bool)
+	for _, arc := range archives {
+		for _, chunk := range arc.Chunks {
+			c, ok := index.Chunks[chunk.Hash]
+			if !ok || c.Tier == "" || seen[c.Hash] {
+				continue
+			}
+			seen[c.Hash] = true
+			tiered[c.Tier] = append(tiered[c.Tier], c)
+		}
+	}
+	return tiered
+}
+
+// ThawProgress reports how many of the chunks being retrieved from archive
+// tiers can be read already.
+type ThawProgress struct {
+	Ready int
+	Total int
+}
+
+// ThawChunks retrieves chunks from archive tiers and waits until all of them
+// can be read, checking their state every interval. Retrieving chunks from
+// archive tiers usually takes hours.
+func ThawChunks(ctx context.Context, repository *Repository, chunks []*ChunkIndexItem, interval time.Duration, progress func(ThawProgress)) error {
+	pending := chunks
+	for {
+		var waiting []*ChunkIndexItem
+		for _, chunk := range pending {
+			ready := true
+			// the data parts suffice to restore a chunk
+			for part := uint(0); part < chunk.DataParts; part++ {
+				r, err := repository.backend.ThawChunk(ctx, chunk.Hash, part, chunk.DataParts)
+				if err != nil {
+					return err
+				}
+				ready = ready && r
+			}
+			if !ready {
+				waiting = append(waiting, chunk)
+			}
+		}
+		pending = waiting
+
+		if progress != nil {
+			progress(ThawProgress{Ready: len(chunks) - len(pending), Total: len(chunks)})
+		}
+		if len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// tieringBackend records the tiers chunks get moved to.
+type tieringBackend struct {
+	Backend
+	tiers map[string]string
+	thaws map[string]int
+}
+
+func (b *tieringBackend) Tiers() []string {
+	return []string{"hot", "cold"}
+}
+
+func (b *tieringBackend) SetChunkTier(ctx context.Context, shasum string, part, totalParts uint, tier string) error {
+	b.tiers[shasum] = tier
+	return nil
+}
+
+// ThawChunk needs to be called twice, before a chunk becomes readable.
+func (b *tieringBackend) ThawChunk(ctx context.Context, shasum string, part, totalParts uint) (bool, error) {
+	b.thaws[shasum]++
+	return b.thaws[shasum] > 1, nil
+}
+
+func TestTiering(t *testing.T) {
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, "this_is_a_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	index := ChunkIndex{Chunks: make(map[string]*ChunkIndexItem)}
+	if _, err := index.PlanTiering(&r, TierPolicy{Tier: "cold"}, time.Now()); err != ErrTieringUnsupported {
+		t.Errorf("Expected %v, got %v", ErrTieringUnsupported, err)
+	}
+
+	tb := &tieringBackend{Backend: *r.backend.Backends[0], tiers: make(map[string]string), thaws: make(map[string]int)}
+	var be Backend = tb
+	r.backend.Backends[0] = &be
+
+	now := time.Now()
+	vol, _ := NewVolume("test", "")
+	_ = r.AddVolume(vol)
+	snapshots := map[time.Duration][]string{
+		100 * 24 * time.Hour: {"old", "shared"},
+		24 * time.Hour:       {"new", "shared", "warm"},
+	}
+	for age, chunks := range snapshots {
+		snapshot, _ := NewSnapshot("")
+		snapshot.Date = now.Add(-age)
+		if err := snapshot.Save(&r); err != nil {
+			t.Fatalf("Failed saving snapshot: %s", err)
+		}
+		_ = vol.AddSnapshot(snapshot.ID)
+
+		arc := &Archive{Type: File}
+		for _, hash := range chunks {
+			arc.Chunks = append(arc.Chunks, Chunk{Hash: hash, DataParts: 1})
+		}
+		index.AddArchive(arc, snapshot.ID)
+	}
+	index.Chunks["warm"].Tier = "cold"
+
+	if _, err := index.PlanTiering(&r, TierPolicy{Tier: "frozen"}, now); err != ErrUnknownTier {
+		t.Errorf("Expected %v, got %v", ErrUnknownTier, err)
+	}
+	moves, err := index.PlanTiering(&r, TierPolicy{MinAge: 30 * 24 * time.Hour, Tier: "cold"}, now)
+	if err != nil {
+		t.Fatalf("Failed planning tiering: %s", err)
+	}
+	if len(moves) != 2 {
+		t.Fatalf("Expected 2 chunks to be moved, got %d", len(moves))
+	}
+	if err := index.MoveChunks(context.Background(), &r, moves); err != nil {
+		t.Fatalf("Failed moving chunks: %s", err)
+	}
+
+	exp := map[string]string{"old": "cold", "warm": "hot"}
+	for hash, tier := range exp {
+		if tb.tiers[hash] != tier {
+			t.Errorf("Expected chunk %s to be moved to %s, got %q", hash, tier, tb.tiers[hash])
+		}
+	}
+	if index.Chunks["old"].Tier != "cold" || index.Chunks["warm"].Tier != "" || index.Chunks["shared"].Tier != "" {
+		t.Errorf("Unexpected tiers recorded in index")
+	}
+
+	tiered := index.TieredChunks([]*Archive{{Chunks: []Chunk{{Hash: "old"}, {Hash: "new"}}}})
+	if len(tiered) != 1 || len(tiered["cold"]) != 1 {
+		t.Errorf("Expected a single chunk in the cold tier, got %v", tiered)
+	}
+
+	var progress []ThawProgress
+	err = ThawChunks(context.Background(), &r, tiered["cold"], time.Millisecond, func(p ThawProgress) {
+		progress = append(progress, p)
+	})
+	if err != nil {
+		t.Fatalf("Failed retrieving chunks: %s", err)
+	}
+	if len(progress) != 2 || progress[0].Ready != 0 || progress[1].Ready != 1 {
+		t.Errorf("Unexpected progress while retrieving chunks: %v", progress)
+	}
+}
@@ -0,0 +1,174 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// A Stage is a step of storing or restoring data.
+type Stage int
+
+// Stages of storing and restoring data.
+const (
+	StageScanning Stage = iota
+	StageReading
+	StageChunking
+	StageCompressing
+	StageEncrypting
+	StageUploading
+	StageDownloading
+	StageDecrypting
+	StageDecompressing
+	StageWriting
+)
+
+// Stages lists all stages, in the order data passes through them.
+var Stages = []Stage{
+	StageScanning, StageReading, StageChunking, StageCompressing, StageEncrypting, StageUploading,
+	StageDownloading, StageDecrypting, StageDecompressing, StageWriting,
+}
+
+func (s Stage) String() string {
+	switch s {
+	case StageScanning:
+		return "scanning"
+	case StageReading:
+		return "reading"
+	case StageChunking:
+		return "chunking"
+	case StageCompressing:
+		return "compressing"
+	case StageEncrypting:
+		return "encrypting"
+	case StageUploading:
+		return "uploading"
+	case StageDownloading:
+		return "downloading"
+	case StageDecrypting:
+		return "decrypting"
+	case StageDecompressing:
+		return "decompressing"
+	case StageWriting:
+		return "writing"
+	}
+	return "unknown"
+}
+
+// Timings break down the time spent in the stages of storing or restoring
+// data. Scanning is measured in wall time. All other stages are processed
+// concurrently, so their times are summed up across all workers and can
+// exceed the duration of the entire operation.
+//
+// Timings are safe for concurrent use. A nil *Timings doesn't record anything.
+type Timings struct {
+	Scanning      time.Duration
+	Reading       time.Duration
+	Chunking      time.Duration
+	Compressing   time.Duration
+	Encrypting    time.Duration
+	Uploading     time.Duration
+	Downloading   time.Duration
+	Decrypting    time.Duration
+	Decompressing time.Duration
+	Writing       time.Duration
+}
+
+func (t *Timings) stage(s Stage) *time.Duration {
+	switch s {
+	case StageScanning:
+		return &t.Scanning
+	case StageReading:
+		return &t.Reading
+	case StageChunking:
+		return &t.Chunking
+	case StageCompressing:
+		return &t.Compressing
+	case StageEncrypting:
+		return &t.Encrypting
+	case StageUploading:
+		return &t.Uploading
+	case StageDownloading:
+		return &t.Downloading
+	case StageDecrypting:
+		return &t.Decrypting
+	case StageDecompressing:
+		return &t.Decompressing
+	case StageWriting:
+		return &t.Writing
+	}
+	return nil
+}
+
+// Get returns the time spent in a stage so far.
+func (t *Timings) Get(s Stage) time.Duration {
+	if t == nil {
+		return 0
+	}
+	return time.Duration(atomic.LoadInt64((*int64)(t.stage(s))))
+}
+
+// Add adds d to the time spent in a stage.
+func (t *Timings) Add(s Stage, d time.Duration) {
+	if t == nil {
+		return
+	}
+	atomic.AddInt64((*int64)(t.stage(s)), int64(d))
+}
+
+// since adds the time passed since start to a stage.
+func (t *Timings) since(s Stage, start time.Time) {
+	if t == nil {
+		return
+	}
+	t.Add(s, time.Since(start))
+}
+
+// pipeline returns p, with the time spent in each of its processors being
+// added to the given stages.
+func (t *Timings) pipeline(p Pipeline, stages ...Stage) Pipeline {
+	if t == nil {
+		return p
+	}
+	timed := Pipeline{}
+	for i, proc := range p.Processors {
+		timed.Processors = append(timed.Processors, timedProcessor{proc, t, stages[i]})
+	}
+	return timed
+}
+
+type timedProcessor struct {
+	PipelineProcessor
+	timings *Timings
+	stage   Stage
+}
+
+func (p timedProcessor) Process(data []byte) ([]byte, error) {
+	defer p.timings.since(p.stage, time.Now())
+	return p.PipelineProcessor.Process(data)
+}
+
+// timedReader adds the time spent reading from an io.Reader to the reading
+// stage. It also keeps its own total, so time spent reading can be told apart
+// from the time spent processing the data read.
+type timedReader struct {
+	io.Reader
+	timings *Timings
+	total   time.Duration
+}
+
+func (r *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := r.Reader.Read(p)
+	d := time.Since(start)
+	r.total += d
+	r.timings.Add(StageReading, d)
+	return n, err
+}
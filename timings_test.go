@@ -0,0 +1,89 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestTimings(t *testing.T) {
+	testPassword := "this_is_a_password"
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	target, err := ioutil.TempDir("", "knoxite.target")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for restore: %s", err)
+	}
+	defer os.RemoveAll(target)
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+
+	var storeTimings Timings
+	snapshot, _ := NewSnapshot("test_snapshot")
+	wd, _ := os.Getwd()
+	opts := StoreOptions{
+		CWD:       wd,
+		Paths:     []string{"snapshot.go", "timings.go"},
+		Compress:  CompressionGZip,
+		Encrypt:   EncryptionAESGCM,
+		DataParts: 1,
+		Timings:   &storeTimings,
+	}
+	for p := range snapshot.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Fatalf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+	for _, stage := range []Stage{StageScanning, StageReading, StageChunking, StageCompressing, StageEncrypting, StageUploading} {
+		if storeTimings.Get(stage) <= 0 {
+			t.Errorf("Expected time spent %s", stage)
+		}
+	}
+	for _, stage := range []Stage{StageDownloading, StageDecrypting, StageDecompressing, StageWriting} {
+		if storeTimings.Get(stage) != 0 {
+			t.Errorf("Expected no time spent %s while storing", stage)
+		}
+	}
+
+	var restoreTimings Timings
+	progress, err := DecodeSnapshot(context.Background(), r, snapshot, target, RestoreOptions{Timings: &restoreTimings})
+	if err != nil {
+		t.Fatalf("Failed restoring snapshot: %s", err)
+	}
+	for p := range progress {
+		if p.Error != nil {
+			t.Fatalf("Failed restoring snapshot: %s", p.Error)
+		}
+	}
+	for _, stage := range []Stage{StageDownloading, StageDecrypting, StageDecompressing, StageWriting} {
+		if restoreTimings.Get(stage) <= 0 {
+			t.Errorf("Expected time spent %s", stage)
+		}
+	}
+
+	// not collecting timings must not fail
+	var nilTimings *Timings
+	nilTimings.Add(StageReading, 42)
+	if nilTimings.Get(StageReading) != 0 {
+		t.Errorf("Expected nil timings not to record anything")
+	}
+}
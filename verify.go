@@ -8,11 +8,50 @@
 package knoxite
 
 import (
+	"context"
 	"math"
 	"math/rand"
+	"sort"
+	"time"
 )
 
-func VerifyRepo(repository Repository, percentage int) (<-chan Progress, error) {
+// Verification records the outcome of verifying a repository.
+type Verification struct {
+	Date time.Time `json:"date"`
+	// Percentage is the share of stored files, whose content got checked. If
+	// it's 0, only the metadata got checked
+	Percentage int `json:"percentage,omitempty"`
+	// Errors is the amount of problems found
+	Errors int `json:"errors"`
+}
+
+// RecordVerification records v as the last verification of all snapshots
+// currently stored in the repository.
+func (r *Repository) RecordVerification(v Verification) {
+	verifications := make(map[string]Verification)
+	for _, volume := range r.Volumes {
+		for _, id := range volume.Snapshots {
+			verifications[id] = v
+		}
+	}
+	r.Verifications = verifications
+}
+
+// Unverified returns the amount of snapshots, which haven't been verified
+// yet.
+func (r *Repository) Unverified() int {
+	n := 0
+	for _, volume := range r.Volumes {
+		for _, id := range volume.Snapshots {
+			if _, ok := r.Verifications[id]; !ok {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func VerifyRepo(ctx context.Context, repository Repository, percentage int) (<-chan Progress, error) {
 	prog := make(chan Progress)
 
 	go func() {
@@ -23,7 +62,10 @@ func VerifyRepo(repository Repository, percentage int) (<-chan Progress, error)
 			for _, snapshotHash := range volume.Snapshots {
 				_, snapshot, err := repository.FindSnapshot(snapshotHash)
 				if err != nil {
-					prog <- newProgressError(err)
+					if !sendProgress(ctx, prog, newProgressError(err)) {
+						return
+					}
+					continue
 				}
 
 				for archiveHash := range snapshot.Archives {
@@ -57,29 +99,37 @@ func VerifyRepo(repository Repository, percentage int) (<-chan Progress, error)
 		for archiveKey := range selectedArchives {
 			snapshot := archiveToSnapshot[archiveKey]
 			p := newProgress(snapshot.Archives[archiveKey])
-			prog <- p
+			if !sendProgress(ctx, prog, p) {
+				return
+			}
 
-			err := VerifyArchive(repository, *snapshot.Archives[archiveKey])
-			if err != nil {
-				prog <- newProgressError(err)
+			err := VerifyArchive(ctx, repository, *snapshot.Archives[archiveKey])
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil && !sendProgress(ctx, prog, newProgressError(err)) {
+				return
 			}
 
 			p.CurrentItemStats.Transferred += (*snapshot.Archives[archiveKey]).Size
-			prog <- p
+			if !sendProgress(ctx, prog, p) {
+				return
+			}
 		}
 	}()
 
 	return prog, nil
 }
 
-func VerifyVolume(repository Repository, volumeId string, percentage int) (<-chan Progress, error) {
+func VerifyVolume(ctx context.Context, repository Repository, volumeId string, percentage int) (<-chan Progress, error) {
 	prog := make(chan Progress)
 
 	go func() {
 		defer close(prog)
 		volume, err := repository.FindVolume(volumeId)
 		if err != nil {
-			prog <- newProgressError(err)
+			sendProgress(ctx, prog, newProgressError(err))
+			return
 		}
 
 		archiveToSnapshot := make(map[string]*Snapshot)
@@ -87,7 +137,10 @@ func VerifyVolume(repository Repository, volumeId string, percentage int) (<-cha
 		for _, snapshotHash := range volume.Snapshots {
 			_, snapshot, err := repository.FindSnapshot(snapshotHash)
 			if err != nil {
-				prog <- newProgressError(err)
+				if !sendProgress(ctx, prog, newProgressError(err)) {
+					return
+				}
+				continue
 			}
 
 			for archiveHash := range snapshot.Archives {
@@ -120,29 +173,37 @@ func VerifyVolume(repository Repository, volumeId string, percentage int) (<-cha
 		for archiveKey := range selectedArchives {
 			snapshot := archiveToSnapshot[archiveKey]
 			p := newProgress(snapshot.Archives[archiveKey])
-			prog <- p
+			if !sendProgress(ctx, prog, p) {
+				return
+			}
 
-			err := VerifyArchive(repository, *snapshot.Archives[archiveKey])
-			if err != nil {
-				prog <- newProgressError(err)
+			err := VerifyArchive(ctx, repository, *snapshot.Archives[archiveKey])
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil && !sendProgress(ctx, prog, newProgressError(err)) {
+				return
 			}
 
 			p.CurrentItemStats.Transferred += (*snapshot.Archives[archiveKey]).Size
-			prog <- p
+			if !sendProgress(ctx, prog, p) {
+				return
+			}
 		}
 	}()
 
 	return prog, nil
 }
 
-func VerifySnapshot(repository Repository, snapshotId string, percentage int) (<-chan Progress, error) {
+func VerifySnapshot(ctx context.Context, repository Repository, snapshotId string, percentage int) (<-chan Progress, error) {
 	prog := make(chan Progress)
 
 	go func() {
 		defer close(prog)
 		_, snapshot, err := repository.FindSnapshot(snapshotId)
 		if err != nil {
-			prog <- newProgressError(err)
+			sendProgress(ctx, prog, newProgressError(err))
+			return
 		}
 
 		// get all keys of the snapshot Archives
@@ -169,22 +230,29 @@ func VerifySnapshot(repository Repository, snapshotId string, percentage int) (<
 
 		for archiveKey := range selectedArchives {
 			p := newProgress(snapshot.Archives[archiveKey])
-			prog <- p
+			if !sendProgress(ctx, prog, p) {
+				return
+			}
 
-			err := VerifyArchive(repository, *snapshot.Archives[archiveKey])
-			if err != nil {
-				prog <- newProgressError(err)
+			err := VerifyArchive(ctx, repository, *snapshot.Archives[archiveKey])
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil && !sendProgress(ctx, prog, newProgressError(err)) {
+				return
 			}
 
 			p.CurrentItemStats.Transferred += (*snapshot.Archives[archiveKey]).Size
-			prog <- p
+			if !sendProgress(ctx, prog, p) {
+				return
+			}
 		}
 	}()
 
 	return prog, nil
 }
 
-func VerifyArchive(repository Repository, arc Archive) error {
+func VerifyArchive(ctx context.Context, repository Repository, arc Archive) error {
 	if arc.Type != File {
 		return nil
 	}
@@ -197,7 +265,7 @@ func VerifyArchive(repository Repository, arc Archive) error {
 		}
 
 		chunk := arc.Chunks[idx]
-		_, err = loadChunk(repository, arc, chunk)
+		_, err = loadChunk(ctx, repository, arc, chunk, nil)
 		if err != nil {
 			return err
 		}
@@ -205,3 +273,137 @@ func VerifyArchive(repository Repository, arc Archive) error {
 
 	return nil
 }
+
+// IntegrityReport describes inconsistencies between the snapshots of a
+// repository, its chunk-index and the chunks stored in its backends.
+type IntegrityReport struct {
+	// MissingChunks contains the hashes of all chunks referenced by a
+	// snapshot, which can't be reconstructed from the stored parts
+	MissingChunks []string `json:"missing_chunks"`
+	// OrphanedChunks contains all stored chunks, which aren't referenced by
+	// any snapshot, as "<hash>_<data parts>"
+	OrphanedChunks []string `json:"orphaned_chunks"`
+	// UnindexedChunks contains the hashes of all chunks referenced by a
+	// snapshot, which are missing in the chunk-index or aren't indexed for
+	// that snapshot
+	UnindexedChunks []string `json:"unindexed_chunks"`
+	// StaleChunks contains the hashes of all chunks in the chunk-index, which
+	// are indexed for snapshots that don't reference them
+	StaleChunks []string `json:"stale_chunks"`
+	// UnreadableSnapshots maps the IDs of snapshots, which couldn't be read,
+	// to the reason why
+	UnreadableSnapshots map[string]string `json:"unreadable_snapshots"`
+	// UnlistedBackends contains the locations of all backends, which can't
+	// enumerate the data they store. Missing and orphaned chunks can't be
+	// detected for them
+	UnlistedBackends []string `json:"unlisted_backends"`
+}
+
+// Errors returns the amount of inconsistencies found.
+func (r *IntegrityReport) Errors() int {
+	return len(r.MissingChunks) + len(r.OrphanedChunks) + len(r.UnindexedChunks) +
+		len(r.StaleChunks) + len(r.UnreadableSnapshots)
+}
+
+// CheckIntegrity cross-checks the snapshots of all volumes in a repository
+// against its chunk-index and the chunks stored in its backends. Unlike
+// VerifyRepo it doesn't download any chunks.
+func CheckIntegrity(ctx context.Context, repository *Repository, index *ChunkIndex) (*IntegrityReport, error) {
+	report := &IntegrityReport{
+		UnreadableSnapshots: make(map[string]string),
+	}
+
+	parts, _, unlisted, err := listStoredData(repository)
+	if err != nil {
+		return report, err
+	}
+	report.UnlistedBackends = unlisted
+	listed := len(unlisted) < len(repository.backend.Backends)
+
+	// maps chunk hashes to the snapshots referencing them
+	referenced := make(map[string]map[string]bool)
+	used := make(map[string]bool)
+	missing := make(map[string]bool)
+	unindexed := make(map[string]bool)
+
+	for _, vol := range repository.Volumes {
+		for _, id := range vol.Snapshots {
+			if ctx.Err() != nil {
+				return report, ctx.Err()
+			}
+
+			snapshot, err := openSnapshot(id, repository)
+			if err != nil {
+				report.UnreadableSnapshots[id] = err.Error()
+				continue
+			}
+
+			for _, archive := range snapshot.Archives {
+				for _, chunk := range archive.Chunks {
+					if chunk.Hole {
+						continue
+					}
+					if referenced[chunk.Hash] == nil {
+						referenced[chunk.Hash] = make(map[string]bool)
+					}
+					referenced[chunk.Hash][id] = true
+					used[chunkKey(chunk.Hash, chunk.DataParts)] = true
+
+					if listed && storedParts(parts, chunk) < chunk.DataParts {
+						missing[chunk.Hash] = true
+					}
+				}
+			}
+		}
+	}
+
+	// the chunk-index of backends maintaining it can't be checked locally
+	if len(index.indexing) > 0 {
+		referenced = nil
+	}
+	for hash, snapshots := range referenced {
+		item, ok := index.Chunks[hash]
+		if !ok {
+			unindexed[hash] = true
+			continue
+		}
+		indexed := make(map[string]bool)
+		for _, id := range item.Snapshots {
+			indexed[id] = true
+		}
+		for id := range snapshots {
+			if !indexed[id] {
+				unindexed[hash] = true
+			}
+		}
+	}
+	for hash, item := range index.Chunks {
+		for _, id := range item.Snapshots {
+			if _, unreadable := report.UnreadableSnapshots[id]; unreadable {
+				continue
+			}
+			if !referenced[hash][id] {
+				report.StaleChunks = append(report.StaleChunks, hash)
+				break
+			}
+		}
+	}
+
+	for k := range parts {
+		if !used[k] {
+			report.OrphanedChunks = append(report.OrphanedChunks, k)
+		}
+	}
+	for hash := range missing {
+		report.MissingChunks = append(report.MissingChunks, hash)
+	}
+	for hash := range unindexed {
+		report.UnindexedChunks = append(report.UnindexedChunks, hash)
+	}
+	sort.Strings(report.MissingChunks)
+	sort.Strings(report.OrphanedChunks)
+	sort.Strings(report.UnindexedChunks)
+	sort.Strings(report.StaleChunks)
+
+	return report, nil
+}
@@ -1,11 +1,13 @@
 package knoxite
 
 import (
+	"context"
 	"errors"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 var verifyTestCases = []struct {
@@ -116,7 +118,7 @@ func TestVerifyRepo(t *testing.T) {
 				ParityParts: 0,
 			}
 
-			progress := snapshot.Add(r, &index, opts)
+			progress := snapshot.Add(context.Background(), r, &index, opts)
 			for p := range progress {
 				if p.Error != nil {
 					t.Errorf("Failed adding to snapshot: %s", p.Error)
@@ -156,7 +158,7 @@ func TestVerifyRepo(t *testing.T) {
 				return
 			}
 
-			progress, err := VerifyRepo(r, tt.Percentage)
+			progress, err := VerifyRepo(context.Background(), r, tt.Percentage)
 			if err != nil {
 				t.Errorf("Failed to verify snapshot: %s", err)
 			}
@@ -228,7 +230,7 @@ func TestVerifyVolume(t *testing.T) {
 				ParityParts: 0,
 			}
 
-			progress := snapshot.Add(r, &index, opts)
+			progress := snapshot.Add(context.Background(), r, &index, opts)
 			for p := range progress {
 				if p.Error != nil {
 					t.Errorf("Failed adding to snapshot: %s", p.Error)
@@ -270,7 +272,7 @@ func TestVerifyVolume(t *testing.T) {
 				return
 			}
 
-			progress, err := VerifyVolume(r, volumeOriginal.ID, tt.Percentage)
+			progress, err := VerifyVolume(context.Background(), r, volumeOriginal.ID, tt.Percentage)
 			if err != nil {
 				t.Errorf("Failed to verify snapshot: %s", err)
 			}
@@ -342,7 +344,7 @@ func TestVerifySnapshot(t *testing.T) {
 				ParityParts: 0,
 			}
 
-			progress := snapshot.Add(r, &index, opts)
+			progress := snapshot.Add(context.Background(), r, &index, opts)
 			for p := range progress {
 				if p.Error != nil {
 					t.Errorf("Failed adding to snapshot: %s", p.Error)
@@ -383,7 +385,7 @@ func TestVerifySnapshot(t *testing.T) {
 				t.Errorf("Failed opening repository: %s", err)
 				return
 			}
-			progress, err := VerifySnapshot(r, snapshotOriginal.ID, tt.Percentage)
+			progress, err := VerifySnapshot(context.Background(), r, snapshotOriginal.ID, tt.Percentage)
 			if err != nil {
 				t.Errorf("Failed to verify snapshot: %s", err)
 			}
@@ -399,3 +401,131 @@ func TestVerifySnapshot(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckIntegrity(t *testing.T) {
+	testPassword := "this_is_a_password"
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	vol, err := NewVolume("test_name", "test_description")
+	if err != nil {
+		t.Fatalf("Failed creating volume: %s", err)
+	}
+	_ = r.AddVolume(vol)
+	snapshot, err := NewSnapshot("test_snapshot")
+	if err != nil {
+		t.Fatalf("Failed creating snapshot: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed getting working dir: %s", err)
+	}
+
+	opts := StoreOptions{
+		CWD:       wd,
+		Paths:     []string{"snapshot_test.go", "snapshot.go"},
+		Encrypt:   EncryptionAES,
+		DataParts: 1,
+	}
+	for p := range snapshot.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Fatalf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+	if err := snapshot.Save(&r); err != nil {
+		t.Fatalf("Failed saving snapshot: %s", err)
+	}
+	_ = vol.AddSnapshot(snapshot.ID)
+
+	check := func(expected int) *IntegrityReport {
+		report, err := CheckIntegrity(context.Background(), &r, &index)
+		if err != nil {
+			t.Fatalf("Failed checking integrity: %s", err)
+		}
+		if report.Errors() != expected {
+			t.Errorf("Expected %d errors, got %d: %+v", expected, report.Errors(), report)
+		}
+		return report
+	}
+	check(0)
+
+	// a lost chunk
+	chunk := snapshot.Archives["snapshot.go"].Chunks[0]
+	path := filepath.Join(dir, chunksDirname, SubDirForChunk(chunk.Hash), chunk.Hash+".0_1")
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed reading chunk: %s", err)
+	}
+	_ = os.Remove(path)
+	if report := check(1); len(report.MissingChunks) != 1 || report.MissingChunks[0] != chunk.Hash {
+		t.Errorf("Expected chunk %s to be missing, got %v", chunk.Hash, report.MissingChunks)
+	}
+	_ = ioutil.WriteFile(path, b, 0600)
+
+	// a chunk no snapshot refers to
+	orphan := Hash([]byte("orphan"), HashHighway256)
+	_, err = r.backend.StoreChunk(context.Background(), Chunk{Hash: orphan, DataParts: 1, Data: &[][]byte{[]byte("orphan")}})
+	if err != nil {
+		t.Fatalf("Failed storing chunk: %s", err)
+	}
+	if report := check(1); len(report.OrphanedChunks) != 1 {
+		t.Errorf("Expected an orphaned chunk, got %v", report.OrphanedChunks)
+	}
+	_ = os.Remove(filepath.Join(dir, chunksDirname, SubDirForChunk(orphan), orphan+".0_1"))
+
+	// chunk-index out of sync with the snapshots
+	index.RemoveSnapshot(snapshot.ID)
+	if report := check(len(snapshot.Archives["snapshot.go"].Chunks) + len(snapshot.Archives["snapshot_test.go"].Chunks)); len(report.UnindexedChunks) == 0 {
+		t.Errorf("Expected unindexed chunks, got %+v", report)
+	}
+	for _, archive := range snapshot.Archives {
+		index.AddArchive(archive, snapshot.ID)
+	}
+	index.Chunks[chunk.Hash].Snapshots = append(index.Chunks[chunk.Hash].Snapshots, "deadbeef")
+	if report := check(1); len(report.StaleChunks) != 1 || report.StaleChunks[0] != chunk.Hash {
+		t.Errorf("Expected chunk %s to be stale, got %v", chunk.Hash, report.StaleChunks)
+	}
+}
+
+func TestRecordVerification(t *testing.T) {
+	r := Repository{}
+	vol, _ := NewVolume("test", "")
+	vol.Snapshots = []string{"a", "b"}
+	r.Volumes = []*Volume{vol}
+
+	if n := r.Unverified(); n != 2 {
+		t.Errorf("Expected 2 unverified snapshots, got %d", n)
+	}
+
+	r.RecordVerification(Verification{Date: time.Now(), Percentage: 25})
+	if n := r.Unverified(); n != 0 {
+		t.Errorf("Expected no unverified snapshots, got %d", n)
+	}
+
+	// forgotten snapshots don't keep their verification around
+	vol.Snapshots = []string{"b", "c"}
+	r.RecordVerification(Verification{Date: time.Now(), Errors: 1})
+	if _, ok := r.Verifications["a"]; ok {
+		t.Errorf("Expected verification of forgotten snapshot to be dropped")
+	}
+	if v := r.Verifications["c"]; v.Errors != 1 {
+		t.Errorf("Expected verification with 1 error, got %+v", v)
+	}
+
+	vol.Snapshots = append(vol.Snapshots, "d")
+	if n := r.Unverified(); n != 1 {
+		t.Errorf("Expected 1 unverified snapshot, got %d", n)
+	}
+}
@@ -7,7 +7,12 @@
 
 package knoxite
 
-import uuid "github.com/nu7hatch/gouuid"
+import (
+	"reflect"
+	"sort"
+
+	uuid "github.com/nu7hatch/gouuid"
+)
 
 // A Volume contains various snapshots.
 type Volume struct {
@@ -15,6 +20,19 @@ type Volume struct {
 	Name        string   `json:"name"`
 	Description string   `json:"description"`
 	Snapshots   []string `json:"snapshots"`
+
+	// Keys wrap the key the snapshots of a protected volume are encrypted
+	// with
+	Keys []KeyRecord `json:"keys,omitempty"`
+
+	// DedupDomain identifies the snapshots the chunks of the volume's
+	// snapshots get shared with. It's empty if they're shared with all
+	// volumes of the repository
+	DedupDomain string `json:"dedup_domain,omitempty"`
+
+	// key is the volume's key, once a protected volume has been unlocked
+	key        string
+	currentKey string
 }
 
 // NewVolume creates a new volume.
@@ -60,6 +78,31 @@ func (v *Volume) RemoveSnapshot(id string) error {
 	return nil
 }
 
+// maxBaselineCandidates is how many of the most recent snapshots of a volume
+// FindBaseline looks at.
+const maxBaselineCandidates = 16
+
+// FindBaseline returns the most recent snapshot of the volume, which stored
+// the same paths opts stores, to detect the changes made since with. It
+// returns nil if there's none.
+func (v *Volume) FindBaseline(repository *Repository, opts StoreOptions) (*Snapshot, error) {
+	paths := opts.snapshotPaths()
+	sort.Strings(paths)
+
+	for i := len(v.Snapshots) - 1; i >= 0 && i >= len(v.Snapshots)-maxBaselineCandidates; i-- {
+		snapshot, err := openSnapshot(v.Snapshots[i], repository)
+		if err != nil {
+			return nil, err
+		}
+		stored := append([]string{}, snapshot.Paths...)
+		sort.Strings(stored)
+		if reflect.DeepEqual(stored, paths) {
+			return snapshot.Merged(repository)
+		}
+	}
+	return nil, nil
+}
+
 // LoadSnapshot loads a snapshot within a volume from a repository.
 func (v *Volume) LoadSnapshot(id string, repository *Repository) (*Snapshot, error) {
 	for _, snapshot := range v.Snapshots {
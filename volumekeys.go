@@ -0,0 +1,193 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import "errors"
+
+// volumeKeysVersion is the first repository version with protected volumes,
+// which older versions of knoxite would fail to decrypt.
+const volumeKeysVersion = 10
+
+// Error declarations.
+var (
+	ErrVolumeLocked        = errors.New("The volume is protected by its own key and hasn't been unlocked")
+	ErrOpenVolumeFailed    = errors.New("Wrong password for the protected volume")
+	ErrVolumeNotEmpty      = errors.New("Only volumes without snapshots can be protected by their own key")
+	ErrVolumeNotProtected  = errors.New("The volume is not protected by its own key")
+	ErrVolumeKeyAsymmetric = errors.New("Volumes of repositories using asymmetric encryption can't be protected by their own key")
+)
+
+// Protected returns true if the snapshots of the volume are encrypted with
+// the volume's own key, instead of the repository's key.
+func (v *Volume) Protected() bool {
+	return len(v.Keys) > 0
+}
+
+// Unlocked returns true if the key of a protected volume is available, so
+// its snapshots can be read and stored.
+func (v *Volume) Unlocked() bool {
+	return v.key != ""
+}
+
+// CurrentKey returns the ID of the key record the volume has been unlocked
+// with.
+func (v *Volume) CurrentKey() string {
+	return v.currentKey
+}
+
+// Unlock unwraps the key of a protected volume with password.
+func (v *Volume) Unlock(password string) error {
+	if !v.Protected() {
+		return ErrVolumeNotProtected
+	}
+	for _, k := range v.Keys {
+		if key, err := k.unwrap(password); err == nil {
+			v.key = key
+			v.currentKey = k.ID
+			return nil
+		}
+	}
+	return ErrOpenVolumeFailed
+}
+
+// PrepareSnapshot makes a new snapshot use the deduplication domain of the
+// volume and its key, if the volume is protected. Call it before adding any
+// data to the snapshot.
+func (v *Volume) PrepareSnapshot(snapshot *Snapshot) error {
+	snapshot.DedupDomain = v.DedupDomain
+	if !v.Protected() {
+		return nil
+	}
+	if !v.Unlocked() {
+		return ErrVolumeLocked
+	}
+	snapshot.volumeKey = v.key
+	return nil
+}
+
+// ProtectVolume encrypts all snapshots stored in the volume from now on with
+// a new random key, which is wrapped by password. Only operators knowing one
+// of the volume's passwords can then read and store its snapshots.
+func (r *Repository) ProtectVolume(v *Volume, password string) (KeyRecord, error) {
+	if r.Asymmetric() {
+		return KeyRecord{}, ErrVolumeKeyAsymmetric
+	}
+	if v.Protected() {
+		return KeyRecord{}, ErrKeyInUse
+	}
+	if len(v.Snapshots) > 0 {
+		return KeyRecord{}, ErrVolumeNotEmpty
+	}
+
+	key, err := generateRandomKey(repositoryKeyLength)
+	if err != nil {
+		return KeyRecord{}, ErrGenerateRandomKeyFailed
+	}
+	k, err := newKeyRecord(key, password)
+	if err != nil {
+		return k, err
+	}
+	v.Keys = []KeyRecord{k}
+	v.key = key
+	v.currentKey = k.ID
+	if r.Version < volumeKeysVersion {
+		r.Version = volumeKeysVersion
+	}
+	if r.ReaderVersion < volumeKeysVersion {
+		r.ReaderVersion = volumeKeysVersion
+	}
+
+	return k, r.Save()
+}
+
+// AddVolumeKey grants access to an unlocked, protected volume with another
+// password.
+func (r *Repository) AddVolumeKey(v *Volume, password string) (KeyRecord, error) {
+	if !v.Protected() {
+		return KeyRecord{}, ErrVolumeNotProtected
+	}
+	if !v.Unlocked() {
+		return KeyRecord{}, ErrVolumeLocked
+	}
+
+	k, err := newKeyRecord(v.key, password)
+	if err != nil {
+		return k, err
+	}
+	v.Keys = append(v.Keys, k)
+	return k, r.Save()
+}
+
+// RemoveVolumeKey revokes access to an unlocked, protected volume for the key
+// record with the given ID.
+func (r *Repository) RemoveVolumeKey(v *Volume, id string) error {
+	if !v.Protected() {
+		return ErrVolumeNotProtected
+	}
+	if !v.Unlocked() {
+		return ErrVolumeLocked
+	}
+
+	for i, k := range v.Keys {
+		if k.ID != id {
+			continue
+		}
+		if len(v.Keys) == 1 {
+			return ErrLastKey
+		}
+		if id == v.currentKey {
+			return ErrKeyInUse
+		}
+
+		v.Keys = append(v.Keys[:i], v.Keys[i+1:]...)
+		return r.Save()
+	}
+
+	return ErrKeyNotFound
+}
+
+// volumeKey returns the key of the protected volume containing the snapshot
+// with the given ID, or an empty string if the snapshot isn't stored in a
+// protected volume.
+func (r *Repository) volumeKey(id string) (string, error) {
+	for _, v := range r.Volumes {
+		if !v.Protected() {
+			continue
+		}
+		for _, s := range v.Snapshots {
+			if s != id {
+				continue
+			}
+			if !v.Unlocked() {
+				return "", ErrVolumeLocked
+			}
+			return v.key, nil
+		}
+	}
+	return "", nil
+}
+
+// unlockedVolumeKeys returns the keys of all unlocked volumes.
+func (r *Repository) unlockedVolumeKeys() []string {
+	var keys []string
+	for _, v := range r.Volumes {
+		if v.Unlocked() {
+			keys = append(keys, v.key)
+		}
+	}
+	return keys
+}
+
+// encryptionKey returns the key the data of the snapshot gets encrypted
+// with.
+func (snapshot *Snapshot) encryptionKey(r *Repository, method uint16) string {
+	if snapshot.volumeKey != "" && method != EncryptionX25519 {
+		return snapshot.volumeKey
+	}
+	return r.encryptionKey(method)
+}
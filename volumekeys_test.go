@@ -0,0 +1,177 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestVolumeKeys(t *testing.T) {
+	testPassword := "this_is_a_password"
+	volumePassword := "this_is_a_volume_password"
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	src, err := ioutil.TempDir("", "knoxite.src")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	files := map[string][]byte{
+		"balance.txt": []byte("some confidential numbers"),
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(src, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := NewRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	vol, _ := NewVolume("finance", "")
+	if err := r.AddVolume(vol); err != nil {
+		t.Fatal(err)
+	}
+	first, err := r.ProtectVolume(vol, volumePassword)
+	if err != nil {
+		t.Fatalf("Failed protecting volume: %s", err)
+	}
+	if _, err := r.ProtectVolume(vol, volumePassword); err != ErrKeyInUse {
+		t.Errorf("Expected %v, got %v", ErrKeyInUse, err)
+	}
+
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	snapshot, _ := NewSnapshot("test_snapshot")
+	if err := vol.PrepareSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	opts := StoreOptions{
+		CWD:       src,
+		Paths:     []string{src},
+		Compress:  CompressionZstd,
+		Encrypt:   EncryptionAESGCM,
+		DataParts: 1,
+	}
+	for p := range snapshot.Add(ctx, r, &index, opts) {
+		if p.Error != nil {
+			t.Fatalf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+	for _, arc := range snapshot.Archives {
+		for _, chunk := range arc.Chunks {
+			if chunk.KeyID != keyID(vol.key) {
+				t.Errorf("Expected chunk of %s to be encrypted with the volume's key", arc.Path)
+			}
+		}
+	}
+	if err := snapshot.Save(&r); err != nil {
+		t.Fatal(err)
+	}
+	if err := vol.AddSnapshot(snapshot.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Save(&r); err != nil {
+		t.Fatal(err)
+	}
+
+	// the repository's password alone doesn't grant access to the volume
+	r, err = OpenRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed opening repository: %s", err)
+	}
+	vol = r.Volumes[0]
+	if !vol.Protected() || vol.Unlocked() {
+		t.Fatal("Expected volume to be protected and locked")
+	}
+	if _, err := vol.LoadSnapshot(snapshot.ID, &r); err != ErrVolumeLocked {
+		t.Errorf("Expected %v, got %v", ErrVolumeLocked, err)
+	}
+	if _, _, err := r.FindSnapshot(snapshot.ID); err != ErrVolumeLocked {
+		t.Errorf("Expected %v, got %v", ErrVolumeLocked, err)
+	}
+	if err := vol.PrepareSnapshot(&Snapshot{}); err != ErrVolumeLocked {
+		t.Errorf("Expected %v, got %v", ErrVolumeLocked, err)
+	}
+	if err := vol.Unlock(testPassword); err != ErrOpenVolumeFailed {
+		t.Errorf("Expected %v, got %v", ErrOpenVolumeFailed, err)
+	}
+	if _, err := r.ProtectVolume(vol, volumePassword); err != ErrKeyInUse {
+		t.Errorf("Expected %v, got %v", ErrKeyInUse, err)
+	}
+
+	if err := vol.Unlock(volumePassword); err != nil {
+		t.Fatalf("Failed unlocking volume: %s", err)
+	}
+	second, err := r.AddVolumeKey(vol, "another_volume_password")
+	if err != nil {
+		t.Fatalf("Failed adding volume key: %s", err)
+	}
+	if err := r.RemoveVolumeKey(vol, first.ID); err != ErrKeyInUse {
+		t.Errorf("Expected %v, got %v", ErrKeyInUse, err)
+	}
+
+	r, err = OpenRepository(dir, testPassword)
+	if err != nil {
+		t.Fatalf("Failed opening repository: %s", err)
+	}
+	vol = r.Volumes[0]
+	if err := vol.Unlock("another_volume_password"); err != nil {
+		t.Fatalf("Failed unlocking volume with the added key: %s", err)
+	}
+	if err := r.RemoveVolumeKey(vol, first.ID); err != nil {
+		t.Fatalf("Failed removing volume key: %s", err)
+	}
+	if err := r.RemoveVolumeKey(vol, second.ID); err != ErrLastKey {
+		t.Errorf("Expected %v, got %v", ErrLastKey, err)
+	}
+
+	snapshot, err = vol.LoadSnapshot(snapshot.ID, &r)
+	if err != nil {
+		t.Fatalf("Failed loading snapshot of unlocked volume: %s", err)
+	}
+	var buf bytes.Buffer
+	progress, err := ExportSnapshot(ctx, r, snapshot, &buf, FormatTar, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Failed exporting snapshot: %s", err)
+	}
+	for p := range progress {
+		if p.Error != nil {
+			t.Fatalf("Failed exporting snapshot: %s", p.Error)
+		}
+	}
+	compareExported(t, "protected", files, readTar(t, &buf))
+
+	// volumes holding snapshots encrypted with the repository's key can't be
+	// protected anymore
+	other, _ := NewVolume("other", "")
+	if err := r.AddVolume(other); err != nil {
+		t.Fatal(err)
+	}
+	other.Snapshots = []string{"unprotected"}
+	if _, err := r.ProtectVolume(other, volumePassword); err != ErrVolumeNotEmpty {
+		t.Errorf("Expected %v, got %v", ErrVolumeNotEmpty, err)
+	}
+}
@@ -0,0 +1,29 @@
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error declarations.
+var (
+	ErrXAttrsUnsupported = errors.New("Extended attributes are not supported on this platform")
+)
+
+// XAttrError records an extended attribute that couldn't be restored.
+type XAttrError struct {
+	Path string
+	Name string
+	Err  error
+}
+
+func (e *XAttrError) Error() string {
+	return fmt.Sprintf("Could not restore extended attribute %s of %s: %v", e.Name, e.Path, e.Err)
+}
@@ -0,0 +1,26 @@
+//go:build !darwin && !freebsd && !linux && !netbsd
+// +build !darwin,!freebsd,!linux,!netbsd
+
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+// readXAttrs returns nil, extended attributes aren't supported on this
+// platform.
+func readXAttrs(path string) map[string][]byte {
+	return nil
+}
+
+// writeXAttrs fails for any extended attribute, as they aren't supported on
+// this platform.
+func writeXAttrs(path string, attrs map[string][]byte) error {
+	for name := range attrs {
+		return &XAttrError{path, name, ErrXAttrsUnsupported}
+	}
+	return nil
+}
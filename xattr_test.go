@@ -0,0 +1,97 @@
+//go:build linux
+// +build linux
+
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestXAttrRestore(t *testing.T) {
+	src, err := ioutil.TempDir("", "knoxite.source")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for source: %s", err)
+	}
+	defer os.RemoveAll(src)
+
+	file := filepath.Join(src, "file")
+	if err := ioutil.WriteFile(file, []byte("content"), 0600); err != nil {
+		t.Fatalf("Failed creating file: %s", err)
+	}
+	if err := unix.Setxattr(file, "user.knoxite", []byte("value"), 0); err != nil {
+		t.Skipf("Extended attributes are not supported: %s", err)
+	}
+
+	dir, err := ioutil.TempDir("", "knoxite")
+	if err != nil {
+		t.Fatalf("Failed creating temporary dir for repository: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r, err := NewRepository(dir, "this_is_a_password")
+	if err != nil {
+		t.Fatalf("Failed creating repository: %s", err)
+	}
+	index, err := OpenChunkIndex(&r)
+	if err != nil {
+		t.Fatalf("Failed opening chunk-index: %s", err)
+	}
+	snapshot, err := NewSnapshot("test_snapshot")
+	if err != nil {
+		t.Fatalf("Failed creating snapshot: %s", err)
+	}
+
+	opts := StoreOptions{
+		CWD:       src,
+		Paths:     []string{src},
+		Encrypt:   EncryptionAES,
+		DataParts: 1,
+	}
+	for p := range snapshot.Add(context.Background(), r, &index, opts) {
+		if p.Error != nil {
+			t.Errorf("Failed adding to snapshot: %s", p.Error)
+		}
+	}
+	if v := snapshot.Archives["file"].XAttrs["user.knoxite"]; string(v) != "value" {
+		t.Fatalf("Expected extended attribute to be stored, got %q", v)
+	}
+
+	for _, skip := range []bool{false, true} {
+		targetdir, err := ioutil.TempDir("", "knoxite.target")
+		if err != nil {
+			t.Fatalf("Failed creating temporary dir for restore: %s", err)
+		}
+		defer os.RemoveAll(targetdir)
+
+		progress, err := DecodeSnapshot(context.Background(), r, snapshot, targetdir, RestoreOptions{SkipXAttrs: skip})
+		if err != nil {
+			t.Fatalf("Failed restoring snapshot: %s", err)
+		}
+		for p := range progress {
+			if p.Error != nil {
+				t.Errorf("Failed restoring snapshot: %s", p.Error)
+			}
+		}
+
+		attrs := readXAttrs(filepath.Join(targetdir, "file"))
+		if skip && len(attrs) > 0 {
+			t.Errorf("Expected no extended attributes to be restored, got %v", attrs)
+		}
+		if !skip && string(attrs["user.knoxite"]) != "value" {
+			t.Errorf("Expected extended attribute to be restored, got %v", attrs)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+//go:build darwin || freebsd || linux || netbsd
+// +build darwin freebsd linux netbsd
+
+/*
+ * knoxite
+ *     Copyright (c) 2016-2020, Christian Muehlhaeuser <muesli@gmail.com>
+ *
+ *   For license see LICENSE
+ */
+
+package knoxite
+
+import (
+	"bytes"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXAttrs returns the extended attributes of path, without following
+// symlinks. On Linux these include its POSIX ACLs. It returns nil if path has
+// no extended attributes or the file system doesn't support them.
+func readXAttrs(path string) map[string][]byte {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil || size <= 0 {
+		return nil
+	}
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(path, buf)
+	if err != nil {
+		return nil
+	}
+
+	attrs := make(map[string][]byte)
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		size, err := unix.Lgetxattr(path, string(name), nil)
+		if err != nil {
+			continue
+		}
+		value := make([]byte, size)
+		size, err = unix.Lgetxattr(path, string(name), value)
+		if err != nil {
+			continue
+		}
+		attrs[string(name)] = value[:size]
+	}
+
+	if len(attrs) == 0 {
+		return nil
+	}
+	return attrs
+}
+
+// writeXAttrs sets the extended attributes of path, without following
+// symlinks.
+func writeXAttrs(path string, attrs map[string][]byte) error {
+	for name, value := range attrs {
+		if err := unix.Lsetxattr(path, name, value, 0); err != nil {
+			return &XAttrError{path, name, err}
+		}
+	}
+	return nil
+}